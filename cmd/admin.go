@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// setAdminCredentials sets the password of an admin user and enrolls a new TOTP
+// secret. The user is created with the admin role if it doesn't exist yet. With
+// a workspace the user only sees the traffic of that workspace.
+func setAdminCredentials(userName string, workspace string) error {
+	if strings.TrimSpace(userName) == "" {
+		return fmt.Errorf("username is required")
+	}
+	var workspaceID *uuid.UUID
+	if workspace != "" {
+		id, err := uuid.Parse(workspace)
+		if err != nil {
+			return fmt.Errorf("invalid workspace ID: %v", err)
+		}
+		workspaceID = &id
+	}
+
+	fmt.Print("Enter new password: ")
+	password := getInput()
+	if len(password) < 12 {
+		return fmt.Errorf("password must be at least 12 characters long")
+	}
+
+	passwordHash, err := lib.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	totpSecret, err := lib.NewTOTPSecret()
+	if err != nil {
+		return fmt.Errorf("failed to generate TOTP secret: %v", err)
+	}
+
+	db := lib.DB()
+	var user models.AdminUsers
+	result := db.Where("user_name = ?", userName).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		user = models.AdminUsers{UserName: userName, Role: models.AdminRoleAdmin, Status: models.Active}
+	} else if result.Error != nil {
+		return result.Error
+	}
+
+	user.PasswordHash = passwordHash
+	user.TOTPSecret = totpSecret
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if workspaceID != nil {
+		user.WorkspaceID = workspaceID
+	}
+	if err := db.Save(&user).Error; err != nil {
+		return fmt.Errorf("failed to save admin user: %v", err)
+	}
+
+	fmt.Printf("Credentials updated for %s\n", userName)
+	fmt.Printf("Add this account to your authenticator app:\n%s\n", lib.TOTPProvisioningURL(userName, totpSecret))
+	return nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshieldai/openshield/server"
+)
+
+// runBench runs the benchmark and prints its report, as JSON if asked. It fails
+// when the p99 latency exceeds maxP99, so runs can gate on regressions.
+func runBench(options server.BenchOptions, asJSON bool, maxP99 time.Duration) error {
+	report, err := server.RunBench(options)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Requests: %d (%d failed) in %v\n", report.Requests, report.Failures, report.Duration.Round(time.Millisecond))
+		fmt.Printf("Throughput: %.1f requests/s\n", report.Throughput)
+		fmt.Printf("Latency: p50 %v, p99 %v\n", report.P50, report.P99)
+		fmt.Printf("Allocations: %d allocs, %d bytes per request\n\n", report.AllocsPerRequest, report.BytesPerRequest)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "STAGE\tCOUNT\tMEAN\tP50\tP99")
+		for _, stage := range report.Stages {
+			fmt.Fprintf(writer, "%s\t%d\t%v\t%v\t%v\n", stage.Stage, stage.Count, stage.Mean, stage.P50, stage.P99)
+		}
+		writer.Flush()
+	}
+
+	if maxP99 > 0 && report.P99 > maxP99 {
+		return fmt.Errorf("p99 latency %v exceeds %v", report.P99, maxP99)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,10 +29,61 @@ func init() {
 	rootCmd.AddCommand(stopServerCmd)
 	dbCmd.AddCommand(createTablesCmd)
 	dbCmd.AddCommand(createMockDataCmd)
+	dbCmd.AddCommand(importOpenAIUsageCmd)
+	importOpenAIUsageCmd.Flags().String("from", "", "Start date (YYYY-MM-DD)")
+	importOpenAIUsageCmd.Flags().String("to", "", "End date, inclusive (YYYY-MM-DD)")
+	_ = importOpenAIUsageCmd.MarkFlagRequired("from")
+	_ = importOpenAIUsageCmd.MarkFlagRequired("to")
 	configCmd.AddCommand(editConfigCmd)
 	configCmd.AddCommand(addRuleCmd)
 	configCmd.AddCommand(removeRuleCmd)
 	configCmd.AddCommand(configWizardCmd)
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(setAdminCredentialsCmd)
+	setAdminCredentialsCmd.Flags().String("workspace", "", "Limit the admin user to the traffic of a workspace")
+	rootCmd.AddCommand(breakGlassCmd)
+	breakGlassCmd.AddCommand(enableBreakGlassCmd)
+	breakGlassCmd.AddCommand(disableBreakGlassCmd)
+	enableBreakGlassCmd.Flags().Duration("duration", 30*time.Minute, "How long non-critical rules stay disabled")
+	enableBreakGlassCmd.Flags().String("reason", "", "Why the bypass is needed")
+	enableBreakGlassCmd.Flags().String("operator", "", "Who is enabling the bypass")
+	_ = enableBreakGlassCmd.MarkFlagRequired("reason")
+	_ = enableBreakGlassCmd.MarkFlagRequired("operator")
+	disableBreakGlassCmd.Flags().String("operator", "", "Who is disabling the bypass")
+	_ = disableBreakGlassCmd.MarkFlagRequired("operator")
+	rootCmd.AddCommand(evidenceCmd)
+	evidenceCmd.AddCommand(exportEvidenceCmd)
+	exportEvidenceCmd.Flags().String("from", "", "Start date (YYYY-MM-DD)")
+	exportEvidenceCmd.Flags().String("to", "", "End date, inclusive (YYYY-MM-DD)")
+	exportEvidenceCmd.Flags().StringP("output", "o", "", "Path of the archive to write")
+	_ = exportEvidenceCmd.MarkFlagRequired("from")
+	_ = exportEvidenceCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(preflightCmd)
+	rootCmd.AddCommand(exportConfigCmd)
+	rootCmd.AddCommand(importConfigCmd)
+	exportConfigCmd.Flags().StringP("output", "o", "openshield-bundle.yaml", "Path of the bundle to write")
+	exportConfigCmd.Flags().String("format", "", "Bundle format, yaml or json (defaults to the output file extension)")
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().String("api-key", "", "Active API key the requests authenticate with")
+	benchCmd.Flags().Int("requests", 1000, "Number of requests to send")
+	benchCmd.Flags().Int("concurrency", 10, "Number of requests in flight")
+	benchCmd.Flags().String("model", "gpt-4o-mini", "Model of the requests")
+	benchCmd.Flags().Duration("upstream-latency", 0, "Latency the mock provider adds to every completion")
+	benchCmd.Flags().Duration("max-p99", 0, "Fail if the p99 latency exceeds this")
+	benchCmd.Flags().Bool("json", false, "Print the report as JSON")
+	_ = benchCmd.MarkFlagRequired("api-key")
+	rootCmd.AddCommand(contractTestCmd)
+	contractTestCmd.Flags().String("url", "http://localhost:8080/openai/v1", "OpenAI base URL of the instance")
+	contractTestCmd.Flags().String("api-key", "", "Active API key the requests authenticate with")
+	contractTestCmd.Flags().String("model", "gpt-4o-mini", "Model of the requests, it must support tool calls")
+	contractTestCmd.Flags().Duration("timeout", 60*time.Second, "Timeout of every check")
+	contractTestCmd.Flags().Bool("json", false, "Print the report as JSON")
+	_ = contractTestCmd.MarkFlagRequired("api-key")
+	rootCmd.AddCommand(sdkCmd)
+	sdkCmd.AddCommand(generateSDKCmd)
+	generateSDKCmd.Flags().String("lang", "go", "Language of the client, "+strings.Join(server.SDKLanguages, " or "))
+	generateSDKCmd.Flags().StringP("output", "o", "sdk", "Directory to write the client to")
+	generateSDKCmd.Flags().String("package", "openshield", "Package name of the Go client")
 }
 
 var dbCmd = &cobra.Command{
@@ -55,6 +107,19 @@ var createMockDataCmd = &cobra.Command{
 	},
 }
 
+var importOpenAIUsageCmd = &cobra.Command{
+	Use:   "import-openai-usage",
+	Short: "Import the usage of the OpenAI organization from before the migration as daily totals",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		if err := importOpenAIUsage(from, to); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Configuration related commands",
@@ -92,6 +157,169 @@ var configWizardCmd = &cobra.Command{
 	},
 }
 
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Admin user related commands",
+}
+
+var setAdminCredentialsCmd = &cobra.Command{
+	Use:   "set-credentials <username>",
+	Short: "Set the password and enroll TOTP for an admin user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		workspace, _ := cmd.Flags().GetString("workspace")
+		if err := setAdminCredentials(args[0], workspace); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var breakGlassCmd = &cobra.Command{
+	Use:   "break-glass",
+	Short: "Emergency bypass of non-critical rules",
+}
+
+var enableBreakGlassCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Disable non-critical rules on all replicas for a bounded time",
+	Run: func(cmd *cobra.Command, args []string) {
+		duration, _ := cmd.Flags().GetDuration("duration")
+		reason, _ := cmd.Flags().GetString("reason")
+		operator, _ := cmd.Flags().GetString("operator")
+		state, err := lib.EnableBreakGlass(reason, operator, duration)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Break-glass enabled until %v\n", state.ExpiresAt)
+	},
+}
+
+var disableBreakGlassCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Re-enable all rules immediately",
+	Run: func(cmd *cobra.Command, args []string) {
+		operator, _ := cmd.Flags().GetString("operator")
+		if err := lib.DisableBreakGlass(operator); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Break-glass disabled")
+	},
+}
+
+var evidenceCmd = &cobra.Command{
+	Use:   "evidence",
+	Short: "Compliance evidence related commands",
+}
+
+var exportEvidenceCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export audit logs, rules, policy versions and an access review as a signed archive",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		output, _ := cmd.Flags().GetString("output")
+		if err := exportEvidence(from, to, output); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check the database, Redis, providers, certificates and config before serving traffic",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !runPreflight() {
+			fmt.Println("Preflight failed")
+			os.Exit(1)
+		}
+		fmt.Println("Preflight passed")
+	},
+}
+
+var exportConfigCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export products, API keys (hashed), models, tags and rules to a bundle",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		format, _ := cmd.Flags().GetString("format")
+		if err := exportConfigBundle(output, format); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var importConfigCmd = &cobra.Command{
+	Use:   "import [bundle]",
+	Short: "Import a bundle created by the export command",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := importConfigBundle(args[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark the gateway with synthetic load against a mock provider",
+	Run: func(cmd *cobra.Command, args []string) {
+		options := server.BenchOptions{}
+		options.APIKey, _ = cmd.Flags().GetString("api-key")
+		options.Requests, _ = cmd.Flags().GetInt("requests")
+		options.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		options.Model, _ = cmd.Flags().GetString("model")
+		options.UpstreamLatency, _ = cmd.Flags().GetDuration("upstream-latency")
+		maxP99, _ := cmd.Flags().GetDuration("max-p99")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if err := runBench(options, asJSON, maxP99); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var contractTestCmd = &cobra.Command{
+	Use:   "contract-test",
+	Short: "Check a live instance for deviations from the OpenAI API",
+	Run: func(cmd *cobra.Command, args []string) {
+		options := server.ContractOptions{}
+		options.BaseURL, _ = cmd.Flags().GetString("url")
+		options.APIKey, _ = cmd.Flags().GetString("api-key")
+		options.Model, _ = cmd.Flags().GetString("model")
+		options.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if err := runContractTests(options, asJSON); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var sdkCmd = &cobra.Command{
+	Use:   "sdk",
+	Short: "Client SDK related commands",
+}
+
+var generateSDKCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a typed client of the gateway and admin API from the OpenAPI spec",
+	Run: func(cmd *cobra.Command, args []string) {
+		language, _ := cmd.Flags().GetString("lang")
+		output, _ := cmd.Flags().GetString("output")
+		packageName, _ := cmd.Flags().GetString("package")
+		if err := generateSDK(language, output, packageName); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 var startServerCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the server",
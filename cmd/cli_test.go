@@ -55,12 +55,12 @@ func TestCreateMockData(t *testing.T) {
 	}
 
 	createExpectations("tags", 10, 6)
-	createExpectations("ai_models", 1, 10)
-	createExpectations("api_keys", 1, 7)
-	createExpectations("audit_logs", 1, 11)
-	createExpectations("products", 1, 7)
-	createExpectations("usages", 1, 10)
-	createExpectations("workspaces", 1, 6)
+	createExpectations("ai_models", 1, 18)
+	createExpectations("api_keys", 1, 9)
+	createExpectations("audit_logs", 1, 12)
+	createExpectations("products", 1, 11)
+	createExpectations("usages", 1, 17)
+	createExpectations("workspaces", 1, 8)
 	lib.SetDB(db)
 	createMockData()
 	lib.DB()
@@ -420,7 +420,17 @@ func handleSlice(field reflect.Value, fullName string, changeDefaults bool) {
 	for i := 0; i < count; i++ {
 		fmt.Printf("Element %d of %s:\n", i+1, fullName)
 		elem := reflect.New(sliceType).Elem()
-		fillStructure(elem, fmt.Sprintf("%s[%d].", fullName, i), changeDefaults)
+		if sliceType.Kind() == reflect.Struct {
+			fillStructure(elem, fmt.Sprintf("%s[%d].", fullName, i), changeDefaults)
+		} else {
+			for {
+				fmt.Printf("Enter value for %s[%d] (%v): ", fullName, i, sliceType)
+				if setValue(elem, getInput()) {
+					break
+				}
+				fmt.Println("Invalid input. Please try again.")
+			}
+		}
 		newSlice.Index(i).Set(elem)
 	}
 
@@ -488,6 +498,12 @@ func setValue(field reflect.Value, value string) bool {
 		} else {
 			return false
 		}
+	case reflect.Float64:
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			field.SetFloat(floatValue)
+		} else {
+			return false
+		}
 	default:
 		return false
 	}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func exportConfigBundle(output string, format string) error {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(output), ".")
+	}
+	if format == "yml" {
+		format = "yaml"
+	}
+	if format != "yaml" && format != "json" {
+		return fmt.Errorf("unsupported format %q, use yaml or json", format)
+	}
+
+	bundle, err := lib.ExportConfigBundle()
+	if err != nil {
+		return err
+	}
+	data, err := lib.MarshalConfigBundle(bundle, format)
+	if err != nil {
+		return err
+	}
+	// The bundle contains rule settings and key hashes, keep it private
+	if err := os.WriteFile(output, data, 0600); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+
+	fmt.Printf("Exported %d workspaces, %d products, %d models, %d tags and %d API keys to %s\n",
+		len(bundle.Workspaces), len(bundle.Products), len(bundle.AiModels), len(bundle.Tags), len(bundle.ApiKeys), output)
+	return nil
+}
+
+func importConfigBundle(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %v", err)
+	}
+	bundle, err := lib.UnmarshalConfigBundle(data)
+	if err != nil {
+		return err
+	}
+
+	result, err := lib.ImportConfigBundle(bundle)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d workspaces, %d products, %d models and %d tags\n", result.Workspaces, result.Products, result.AiModels, result.Tags)
+	fmt.Printf("Updated %d API keys, skipped %d that don't exist in this database\n", result.ApiKeysUpdated, result.ApiKeysSkipped)
+	if result.RulesImported {
+		fmt.Println("Rules written to the config file")
+	}
+	return nil
+}
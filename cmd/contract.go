@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshieldai/openshield/server"
+)
+
+// runContractTests runs the OpenAI compatibility checks and prints their report,
+// as JSON if asked. It fails when any check found a deviation.
+func runContractTests(options server.ContractOptions, asJSON bool) error {
+	report := server.RunContractTests(options)
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "CHECK\tRESULT\tDURATION\tDEVIATION")
+		for _, result := range report.Results {
+			outcome := "PASS"
+			if !result.Passed {
+				outcome = "FAIL"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%v\t%s\n", result.Name, outcome, result.Duration.Round(time.Millisecond), result.Deviation)
+		}
+		writer.Flush()
+		fmt.Printf("\n%d passed, %d failed\n", report.Passed, report.Failed)
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d checks deviate from the OpenAI API", report.Failed, len(report.Results))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+const evidenceDateLayout = "2006-01-02"
+
+func exportEvidence(from string, to string, output string) error {
+	fromDate, err := time.Parse(evidenceDateLayout, from)
+	if err != nil {
+		return fmt.Errorf("invalid --from date: %v", err)
+	}
+	toDate, err := time.Parse(evidenceDateLayout, to)
+	if err != nil {
+		return fmt.Errorf("invalid --to date: %v", err)
+	}
+	// The end date is inclusive
+	toDate = toDate.AddDate(0, 0, 1)
+	if !fromDate.Before(toDate) {
+		return fmt.Errorf("--from must not be after --to")
+	}
+
+	signingKey := os.Getenv("OPENSHIELD_EVIDENCE_SIGNING_KEY")
+	if signingKey == "" {
+		return fmt.Errorf("OPENSHIELD_EVIDENCE_SIGNING_KEY environment variable is not set")
+	}
+
+	if output == "" {
+		output = fmt.Sprintf("openshield-evidence-%s-%s.tar.gz", from, to)
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	if err := lib.ExportEvidence(file, fromDate, toDate, []byte(signingKey)); err != nil {
+		os.Remove(output)
+		return err
+	}
+
+	fmt.Printf("Evidence archive written to %s\n", output)
+	return nil
+}
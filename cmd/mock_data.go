@@ -26,8 +26,7 @@ func init() {
 	})
 
 	faker.AddProvider("finishreason", func(v reflect.Value) (interface{}, error) {
-		reasons := []models.FinishReason{models.Stop, models.Length, models.Null, models.FunctionCall, models.ContentFilter}
-		return reasons[rand.Intn(len(reasons))], nil
+		return models.FinishReasons[rand.Intn(len(models.FinishReasons))], nil
 	})
 
 	faker.AddProvider("tags", func(v reflect.Value) (interface{}, error) {
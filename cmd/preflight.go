@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func runPreflight() bool {
+	results := lib.RunPreflight(context.Background())
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CHECK\tSTATUS\tDETAIL")
+	for _, result := range results {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", result.Check, result.Status, result.Detail)
+	}
+	writer.Flush()
+
+	return lib.PreflightPassed(results)
+}
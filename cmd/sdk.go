@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshieldai/openshield/server"
+)
+
+// generateSDK writes the client of the gateway spec in a language to the output directory
+func generateSDK(language string, output string, packageName string) error {
+	spec, err := server.GatewaySpec(server.NewRouter())
+	if err != nil {
+		return fmt.Errorf("failed to read the gateway spec: %v", err)
+	}
+	files, err := server.GenerateSDK(spec, strings.ToLower(language), packageName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		path := filepath.Join(output, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func importOpenAIUsage(from string, to string) error {
+	fromDate, err := time.Parse(evidenceDateLayout, from)
+	if err != nil {
+		return fmt.Errorf("invalid --from date: %v", err)
+	}
+	toDate, err := time.Parse(evidenceDateLayout, to)
+	if err != nil {
+		return fmt.Errorf("invalid --to date: %v", err)
+	}
+	// The end date is inclusive
+	toDate = toDate.AddDate(0, 0, 1)
+	if !fromDate.Before(toDate) {
+		return fmt.Errorf("--from must not be after --to")
+	}
+
+	adminKey := os.Getenv("OPENAI_ADMIN_KEY")
+	if adminKey == "" {
+		return fmt.Errorf("OPENAI_ADMIN_KEY environment variable is not set")
+	}
+
+	report, err := lib.ImportOpenAIUsage(context.Background(), adminKey, fromDate, toDate)
+	if err != nil {
+		return err
+	}
+	if report.Skipped {
+		fmt.Printf("OpenShield recorded usage from %s on, later days were not imported\n", report.To.Format(evidenceDateLayout))
+	}
+	if len(report.Models) > 0 {
+		fmt.Printf("Added %d models to the catalog: %v\n", len(report.Models), report.Models)
+	}
+	fmt.Printf("Imported %d daily usage totals\n", report.Rollups)
+	return nil
+}
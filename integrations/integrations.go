@@ -0,0 +1,207 @@
+// Package integrations holds HTTP shims calling OpenShield the way the OpenAI
+// clients of LLM frameworks do, so the gateway can be checked as their backend.
+// The subpackages configure the shim like LangChain and LlamaIndex.
+package integrations
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Client sends chat completions to an OpenAI-compatible base URL with the
+// headers and request defaults of a framework
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	Headers    http.Header
+	Defaults   func(req *openai.ChatCompletionRequest)
+	HTTPClient *http.Client
+}
+
+// APIError is an error response as the OpenAI SDKs under the frameworks raise
+// it. Class is the exception they raise, Parsed is false when the body wasn't
+// an OpenAI error and the frameworks only show it raw.
+type APIError struct {
+	Status  int
+	Class   string
+	Message string
+	Type    string
+	Code    string
+	Parsed  bool
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (status %d): %s", e.Class, e.Status, e.Message)
+}
+
+// errorClasses are the exceptions of the OpenAI Python and Node SDKs by status
+var errorClasses = map[int]string{
+	http.StatusBadRequest:          "BadRequestError",
+	http.StatusUnauthorized:        "AuthenticationError",
+	http.StatusForbidden:           "PermissionDeniedError",
+	http.StatusNotFound:            "NotFoundError",
+	http.StatusConflict:            "ConflictError",
+	http.StatusUnprocessableEntity: "UnprocessableEntityError",
+	http.StatusTooManyRequests:     "RateLimitError",
+}
+
+// ErrorClass returns the exception the OpenAI SDKs raise for a status
+func ErrorClass(status int) string {
+	if class, ok := errorClasses[status]; ok {
+		return class
+	}
+	if status >= http.StatusInternalServerError {
+		return "InternalServerError"
+	}
+	return "APIStatusError"
+}
+
+// ParseError reads an error response like the OpenAI SDKs do
+func ParseError(status int, body []byte) *APIError {
+	apiErr := &APIError{Status: status, Class: ErrorClass(status), Message: strings.TrimSpace(string(body))}
+	var response struct {
+		Error *struct {
+			Message string      `json:"message"`
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Error != nil && response.Error.Message != "" {
+		apiErr.Message, apiErr.Type, apiErr.Parsed = response.Error.Message, response.Error.Type, true
+		if response.Error.Code != nil {
+			apiErr.Code = fmt.Sprint(response.Error.Code)
+		}
+	}
+	return apiErr
+}
+
+func (c *Client) send(ctx context.Context, req openai.ChatCompletionRequest) (*http.Response, error) {
+	if c.Defaults != nil {
+		c.Defaults(&req)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.Headers {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+	if req.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	} else {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, ParseError(resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+// Chat sends a non-streamed chat completion
+func (c *Client) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	req.Stream = false
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var completion openai.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return completion, fmt.Errorf("error decoding completion: %v", err)
+	}
+	return completion, nil
+}
+
+// StreamResult is an assembled streamed completion
+type StreamResult struct {
+	Content      string
+	Chunks       int
+	FinishReason string
+	Usage        *openai.Usage
+}
+
+// Stream sends a streamed chat completion and assembles it the way the
+// frameworks do: "data:" lines until [DONE], and an "error" object in a
+// chunk raised as an APIError
+func (c *Client) Stream(ctx context.Context, req openai.ChatCompletionRequest, onToken func(token string)) (StreamResult, error) {
+	req.Stream = true
+	result := StreamResult{}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		return result, fmt.Errorf("stream has content type %q, want text/event-stream", contentType)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var content strings.Builder
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			result.Content = content.String()
+			return result, nil
+		}
+		if strings.Contains(data, `"error"`) {
+			if apiErr := ParseError(resp.StatusCode, []byte(data)); apiErr.Parsed {
+				return result, apiErr
+			}
+		}
+
+		var chunk openai.ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return result, fmt.Errorf("error decoding chunk %d: %v", result.Chunks+1, err)
+		}
+		result.Chunks++
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				if onToken != nil {
+					onToken(choice.Delta.Content)
+				}
+			}
+			if choice.FinishReason != "" {
+				result.FinishReason = string(choice.FinishReason)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, fmt.Errorf("stream ended after %d chunks without [DONE]", result.Chunks)
+}
@@ -0,0 +1,64 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseError(t *testing.T) {
+	apiErr := ParseError(http.StatusUnauthorized, []byte(`{"error":{"message":"Invalid API key","type":"invalid_request_error","code":"invalid_api_key"}}`))
+	assert.Equal(t, "AuthenticationError", apiErr.Class)
+	assert.Equal(t, "Invalid API key", apiErr.Message)
+	assert.Equal(t, "invalid_api_key", apiErr.Code)
+	assert.True(t, apiErr.Parsed)
+
+	raw := ParseError(http.StatusBadGateway, []byte("upstream connect error\n"))
+	assert.Equal(t, "InternalServerError", raw.Class)
+	assert.Equal(t, "upstream connect error", raw.Message)
+	assert.False(t, raw.Parsed)
+
+	assert.Equal(t, "RateLimitError", ErrorClass(http.StatusTooManyRequests))
+	assert.Equal(t, "APIStatusError", ErrorClass(http.StatusRequestEntityTooLarge))
+}
+
+func TestStream(t *testing.T) {
+	streams := map[string]string{
+		"/ok/chat/completions": `data: {"choices":[{"delta":{"content":"Hel"}}]}` + "\n\n" +
+			`data: {"choices":[{"delta":{"content":"lo"},"finish_reason":"stop"}]}` + "\n\n" +
+			`data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}` + "\n\n" +
+			"data: [DONE]\n\n",
+		"/error/chat/completions":     `data: {"error":{"message":"Output blocked by rule pii","type":"invalid_request_error"}}` + "\n\n",
+		"/truncated/chat/completions": `data: {"choices":[{"delta":{"content":"Hel"}}]}` + "\n\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, streams[r.URL.Path])
+	}))
+	defer server.Close()
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	var tokens []string
+	client := &Client{BaseURL: server.URL + "/ok", APIKey: "test"}
+	result, err := client.Stream(context.Background(), req, func(token string) { tokens = append(tokens, token) })
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", result.Content)
+	assert.Equal(t, []string{"Hel", "lo"}, tokens)
+	assert.Equal(t, "stop", result.FinishReason)
+	assert.Equal(t, 5, result.Usage.TotalTokens)
+
+	client.BaseURL = server.URL + "/error"
+	_, err = client.Stream(context.Background(), req, nil)
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "Output blocked by rule pii", apiErr.Message)
+
+	client.BaseURL = server.URL + "/truncated"
+	_, err = client.Stream(context.Background(), req, nil)
+	assert.ErrorContains(t, err, "without [DONE]")
+}
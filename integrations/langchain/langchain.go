@@ -0,0 +1,73 @@
+// Package langchain calls OpenShield like ChatOpenAI of LangChain does. Both
+// LangChain.js and LangChain Python use the OpenAI SDK of their runtime, pointed
+// at the gateway:
+//
+//	// LangChain.js
+//	new ChatOpenAI({ model: "gpt-4o-mini", apiKey: process.env.OPENSHIELD_API_KEY,
+//		configuration: { baseURL: "http://localhost:8080/openai/v1" } })
+//
+//	# LangChain Python
+//	ChatOpenAI(model="gpt-4o-mini", api_key=os.environ["OPENSHIELD_API_KEY"],
+//		base_url="http://localhost:8080/openai/v1")
+package langchain
+
+import (
+	"net/http"
+
+	"github.com/openshieldai/openshield/integrations"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Runtime is the LangChain implementation being imitated
+type Runtime string
+
+const (
+	JS     Runtime = "js"
+	Python Runtime = "python"
+)
+
+// headers are those the OpenAI SDK under each runtime sends with every request
+var headers = map[Runtime]http.Header{
+	JS: {
+		"User-Agent":                  {"OpenAI/JS 4.55.4"},
+		"X-Stainless-Lang":            {"js"},
+		"X-Stainless-Package-Version": {"4.55.4"},
+		"X-Stainless-Runtime":         {"node"},
+		"X-Stainless-Os":              {"Linux"},
+		"X-Stainless-Arch":            {"x64"},
+		"X-Stainless-Retry-Count":     {"0"},
+	},
+	Python: {
+		"User-Agent":                  {"OpenAI/Python 1.40.0"},
+		"X-Stainless-Lang":            {"python"},
+		"X-Stainless-Package-Version": {"1.40.0"},
+		"X-Stainless-Runtime":         {"CPython"},
+		"X-Stainless-Os":              {"Linux"},
+		"X-Stainless-Arch":            {"x64"},
+		"X-Stainless-Retry-Count":     {"0"},
+	},
+}
+
+// NewChatOpenAI returns a client sending what ChatOpenAI sends: n of 1, the
+// default temperature of the runtime and, when streaming, stream_options asking
+// for the usage in the last chunk.
+func NewChatOpenAI(baseURL string, apiKey string, runtime Runtime) *integrations.Client {
+	temperature := float32(1)
+	if runtime == Python {
+		temperature = 0.7
+	}
+	return &integrations.Client{
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+		Headers: headers[runtime].Clone(),
+		Defaults: func(req *openai.ChatCompletionRequest) {
+			req.N = 1
+			if req.Temperature == 0 {
+				req.Temperature = temperature
+			}
+			if req.Stream {
+				req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+			}
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package langchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/openshieldai/openshield/integrations"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func request() openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Reply with the single word OK."}},
+	}
+}
+
+func TestNewChatOpenAI(t *testing.T) {
+	var received []openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		assert.Equal(t, "js", r.Header.Get("X-Stainless-Lang"))
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received = append(received, req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"OK\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"message":{"role":"assistant","content":"OK"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewChatOpenAI(server.URL+"/openai/v1", "sk-test", JS)
+	completion, err := client.Chat(context.Background(), request())
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", completion.Choices[0].Message.Content)
+	_, err = client.Stream(context.Background(), request(), nil)
+	assert.NoError(t, err)
+
+	assert.Len(t, received, 2)
+	assert.Equal(t, 1, received[0].N)
+	assert.Equal(t, float32(1), received[0].Temperature)
+	assert.Nil(t, received[0].StreamOptions)
+	assert.True(t, received[1].StreamOptions.IncludeUsage)
+}
+
+// TestChatOpenAIAgainstGateway runs against a live instance, such as the demo,
+// with OPENSHIELD_URL set to its OpenAI base URL
+func TestChatOpenAIAgainstGateway(t *testing.T) {
+	baseURL, apiKey := os.Getenv("OPENSHIELD_URL"), os.Getenv("OPENSHIELD_API_KEY")
+	if baseURL == "" || apiKey == "" {
+		t.Skip("Skipping testing against a live instance. Set OPENSHIELD_URL and OPENSHIELD_API_KEY to enable it.")
+	}
+	ctx := context.Background()
+
+	for _, runtime := range []Runtime{JS, Python} {
+		client := NewChatOpenAI(baseURL, apiKey, runtime)
+		completion, err := client.Chat(ctx, request())
+		if assert.NoError(t, err, runtime) {
+			assert.NotEmpty(t, completion.Choices, runtime)
+		}
+
+		result, err := client.Stream(ctx, request(), nil)
+		if assert.NoError(t, err, runtime) {
+			assert.NotEmpty(t, result.Content, runtime)
+			assert.NotEmpty(t, result.FinishReason, runtime)
+		}
+
+		_, err = NewChatOpenAI(baseURL, "invalid-key", runtime).Chat(ctx, request())
+		var apiErr *integrations.APIError
+		if assert.ErrorAs(t, err, &apiErr, runtime) {
+			assert.Equal(t, "AuthenticationError", apiErr.Class, runtime)
+			assert.True(t, apiErr.Parsed, "the error must be an OpenAI error object: %s", apiErr.Message)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Package llamaindex calls OpenShield like the OpenAILike LLM of LlamaIndex
+// does. It uses the OpenAI Python SDK, pointed at the gateway:
+//
+//	OpenAILike(model="gpt-4o-mini", api_key=os.environ["OPENSHIELD_API_KEY"],
+//		api_base="http://localhost:8080/openai/v1", is_chat_model=True)
+package llamaindex
+
+import (
+	"net/http"
+
+	"github.com/openshieldai/openshield/integrations"
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultTemperature is the temperature LlamaIndex sends when none is configured
+const defaultTemperature = 0.1
+
+// NewOpenAILike returns a client sending what OpenAILike sends as a chat model
+func NewOpenAILike(apiBase string, apiKey string) *integrations.Client {
+	return &integrations.Client{
+		BaseURL: apiBase,
+		APIKey:  apiKey,
+		Headers: http.Header{
+			"User-Agent":                  {"OpenAI/Python 1.40.0"},
+			"X-Stainless-Lang":            {"python"},
+			"X-Stainless-Package-Version": {"1.40.0"},
+			"X-Stainless-Runtime":         {"CPython"},
+			"X-Stainless-Os":              {"Linux"},
+			"X-Stainless-Arch":            {"x64"},
+			"X-Stainless-Retry-Count":     {"0"},
+		},
+		Defaults: func(req *openai.ChatCompletionRequest) {
+			if req.Temperature == 0 {
+				req.Temperature = defaultTemperature
+			}
+		},
+	}
+}
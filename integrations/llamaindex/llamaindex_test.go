@@ -0,0 +1,80 @@
+package llamaindex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/openshieldai/openshield/integrations"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func request() openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Reply with the single word OK."}},
+	}
+}
+
+func TestNewOpenAILike(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "python", r.Header.Get("X-Stainless-Lang"))
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, float32(defaultTemperature), req.Temperature)
+		if req.Model != "gpt-4o-mini" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"message":"model not approved","type":"invalid_request_error","code":"model_not_found"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"message":{"role":"assistant","content":"OK"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAILike(server.URL, "sk-test")
+	_, err := client.Chat(context.Background(), request())
+	assert.NoError(t, err)
+
+	req := request()
+	req.Model = "gpt-unknown"
+	_, err = client.Chat(context.Background(), req)
+	var apiErr *integrations.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "PermissionDeniedError", apiErr.Class)
+	assert.Equal(t, "model_not_found", apiErr.Code)
+}
+
+// TestOpenAILikeAgainstGateway runs against a live instance, such as the demo,
+// with OPENSHIELD_URL set to its OpenAI base URL
+func TestOpenAILikeAgainstGateway(t *testing.T) {
+	apiBase, apiKey := os.Getenv("OPENSHIELD_URL"), os.Getenv("OPENSHIELD_API_KEY")
+	if apiBase == "" || apiKey == "" {
+		t.Skip("Skipping testing against a live instance. Set OPENSHIELD_URL and OPENSHIELD_API_KEY to enable it.")
+	}
+	ctx := context.Background()
+	client := NewOpenAILike(apiBase, apiKey)
+
+	completion, err := client.Chat(ctx, request())
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, completion.Choices)
+	}
+
+	result, err := client.Stream(ctx, request(), nil)
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, result.Content)
+	}
+
+	req := request()
+	req.Model = "openshield-unknown-model"
+	_, err = client.Chat(ctx, req)
+	var apiErr *integrations.APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.True(t, apiErr.Parsed, "the error must be an OpenAI error object: %s", apiErr.Message)
+	}
+}
@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// Access log formats
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+	AccessLogW3C      = "w3c"
+)
+
+const w3cFields = "#Fields: date time c-ip cs-method cs-uri cs-version sc-status sc-bytes time-taken cs(User-Agent) cs(Referer)\n"
+
+// AccessLogEntry is one line of the access log
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+	Workspace  string    `json:"workspace_id,omitempty"`
+	Product    string    `json:"product_id,omitempty"`
+}
+
+var accessLog struct {
+	sync.Mutex
+	path string
+	out  io.Writer
+	file *RotatingFile
+}
+
+// GetAccessLogSettings returns the access log settings with defaults applied
+func GetAccessLogSettings() AccessLog {
+	settings := AccessLog{}
+	if a := GetConfig().Settings.AccessLog; a != nil {
+		settings = *a
+	}
+	if settings.Format == "" {
+		settings.Format = AccessLogCombined
+	}
+	if settings.Path == "" {
+		settings.Path = "stdout"
+	}
+	return settings
+}
+
+// AccessLogMiddleware writes a line per request to the access log, which is
+// separate from the application logs
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := GetAccessLogSettings()
+		if !settings.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		entry := AccessLogEntry{
+			Time:       start,
+			RemoteAddr: host,
+			Method:     r.Method,
+			URI:        r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Status:     status,
+			Bytes:      ww.BytesWritten(),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			RequestID:  middleware.GetReqID(r.Context()),
+			UserAgent:  r.UserAgent(),
+			Referer:    r.Referer(),
+		}
+		if tenant := GetRequestTenant(r); tenant != nil && tenant.WorkspaceID != uuid.Nil {
+			entry.Workspace, entry.Product = tenant.WorkspaceID.String(), tenant.ProductID.String()
+		}
+		writeAccessLog(settings, entry)
+	})
+}
+
+// FormatAccessLog formats an entry as a line of the access log
+func FormatAccessLog(format string, entry AccessLogEntry) string {
+	switch format {
+	case AccessLogJSON:
+		line, _ := json.Marshal(entry)
+		return string(line) + "\n"
+	case AccessLogW3C:
+		utc := entry.Time.UTC()
+		return fmt.Sprintf("%s %s %s %s %s %s %d %d %.3f %s %s\n",
+			utc.Format("2006-01-02"), utc.Format("15:04:05"), entry.RemoteAddr, entry.Method,
+			w3cValue(entry.URI), entry.Proto, entry.Status, entry.Bytes, entry.DurationMs/1000,
+			w3cValue(entry.UserAgent), w3cValue(entry.Referer))
+	case AccessLogCommon:
+		return commonLogLine(entry) + "\n"
+	default:
+		return fmt.Sprintf("%s %q %q\n", commonLogLine(entry), ncsaValue(entry.Referer), ncsaValue(entry.UserAgent))
+	}
+}
+
+// commonLogLine is the NCSA common log format. The client isn't identified,
+// API keys have no place in access logs.
+func commonLogLine(entry AccessLogEntry) string {
+	return fmt.Sprintf("%s - - [%s] %q %d %d", entry.RemoteAddr, entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method+" "+entry.URI+" "+entry.Proto, entry.Status, entry.Bytes)
+}
+
+func ncsaValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// w3cValue replaces the spaces the extended log format uses as separators
+func w3cValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, " ", "+")
+}
+
+func writeAccessLog(settings AccessLog, entry AccessLogEntry) {
+	line := FormatAccessLog(settings.Format, entry)
+
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	if accessLog.out == nil || accessLog.path != settings.Path {
+		if err := openAccessLog(settings); err != nil {
+			log.Printf("Error opening access log: %v", err)
+			return
+		}
+	}
+	if _, err := io.WriteString(accessLog.out, line); err != nil {
+		log.Printf("Error writing access log: %v", err)
+	}
+}
+
+// openAccessLog opens the access log file, rotated with the rotation settings,
+// or standard output. The caller holds the lock.
+func openAccessLog(settings AccessLog) error {
+	closeAccessLog()
+	switch settings.Path {
+	case "stdout":
+		accessLog.out = os.Stdout
+	case "stderr":
+		accessLog.out = os.Stderr
+	default:
+		header := ""
+		if settings.Format == AccessLogW3C {
+			header = "#Version: 1.0\n" + w3cFields
+		}
+		file, err := NewRotatingFile(settings.Path, settings.Rotation, header)
+		if err != nil {
+			return err
+		}
+		accessLog.file, accessLog.out = file, file
+	}
+	accessLog.path = settings.Path
+	return nil
+}
+
+func closeAccessLog() {
+	if accessLog.file != nil {
+		accessLog.file.Close()
+	}
+	accessLog.file, accessLog.out = nil, nil
+}
+
+// ReopenAccessLog closes the access log file, it is opened again on the next
+// request. Sent on SIGHUP, so external tools can rotate the file.
+func ReopenAccessLog() {
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	closeAccessLog()
+}
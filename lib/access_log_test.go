@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAccessLog(t *testing.T) {
+	entry := AccessLogEntry{
+		Time:       time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC),
+		RemoteAddr: "10.0.0.1",
+		Method:     "POST",
+		URI:        "/openai/v1/chat/completions",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      512,
+		DurationMs: 250,
+		UserAgent:  "curl/8.0",
+	}
+
+	assert.Equal(t, `10.0.0.1 - - [01/Jul/2024:12:30:00 +0000] "POST /openai/v1/chat/completions HTTP/1.1" 200 512`+"\n", FormatAccessLog(AccessLogCommon, entry))
+	assert.Equal(t, `10.0.0.1 - - [01/Jul/2024:12:30:00 +0000] "POST /openai/v1/chat/completions HTTP/1.1" 200 512 "-" "curl/8.0"`+"\n", FormatAccessLog(AccessLogCombined, entry))
+	assert.Equal(t, "2024-07-01 12:30:00 10.0.0.1 POST /openai/v1/chat/completions HTTP/1.1 200 512 0.250 curl/8.0 -\n", FormatAccessLog(AccessLogW3C, entry))
+	assert.Contains(t, FormatAccessLog(AccessLogJSON, entry), `"status":200`)
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	settings := AppConfig.Settings.AccessLog
+	defer func() {
+		AppConfig.Settings.AccessLog = settings
+		ReopenAccessLog()
+	}()
+	path := filepath.Join(t.TempDir(), "access.log")
+	AppConfig.Settings.AccessLog = &AccessLog{Enabled: true, Path: path, Format: AccessLogW3C}
+
+	handler := AccessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/version", nil))
+
+	// Rotated away, the next request opens a new file
+	assert.NoError(t, os.Rename(path, path+".1"))
+	ReopenAccessLog()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/version", nil))
+
+	for _, file := range []string{path + ".1", path} {
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		assert.Len(t, lines, 3)
+		assert.Equal(t, "#Version: 1.0", lines[0])
+		assert.Contains(t, lines[2], "GET /version HTTP/1.1 418 15")
+	}
+}
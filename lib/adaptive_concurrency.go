@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrConcurrencyLimited is returned when no upstream slot frees up within the queue timeout
+var ErrConcurrencyLimited = errors.New("upstream concurrency limit reached")
+
+// ConcurrencyStats reports the adaptive limit of an upstream host
+type ConcurrencyStats struct {
+	Host      string  `json:"host"`
+	Limit     float64 `json:"limit"`
+	InFlight  int     `json:"in_flight"`
+	Decreases int     `json:"decreases"`
+	Rejected  int     `json:"rejected"`
+}
+
+// adaptiveLimiter limits concurrent requests with additive increase and
+// multiplicative decrease: the limit grows by 1/limit for every healthy response
+// and is multiplied by the backoff ratio when the upstream is overloaded
+type adaptiveLimiter struct {
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	lastDecrease time.Time
+	decreases    int
+	rejected     int
+	queue        fairQueue
+}
+
+// fairQueue orders the requests waiting for a slot by weighted fair queuing
+// across API keys: each request is tagged with the virtual time it finishes at
+// if its key got its weighted share, and the earliest tag gets the next slot.
+// A burst from one key only delays that key.
+type fairQueue struct {
+	waiting     []*queuedRequest
+	virtualTime float64
+	lastFinish  map[string]float64
+}
+
+type queuedRequest struct {
+	start   float64
+	finish  float64
+	granted bool
+	ready   chan struct{}
+}
+
+func (q *fairQueue) push(key string, weight float64) *queuedRequest {
+	if q.lastFinish == nil {
+		q.lastFinish = map[string]float64{}
+	}
+	start := math.Max(q.virtualTime, q.lastFinish[key])
+	request := &queuedRequest{start: start, finish: start + 1/weight, ready: make(chan struct{})}
+	q.lastFinish[key] = request.finish
+	q.waiting = append(q.waiting, request)
+	return request
+}
+
+// pop removes the request with the earliest finish tag
+func (q *fairQueue) pop() *queuedRequest {
+	next := 0
+	for i, request := range q.waiting {
+		if request.finish < q.waiting[next].finish {
+			next = i
+		}
+	}
+	request := q.waiting[next]
+	q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+	q.virtualTime = request.start
+	if len(q.waiting) == 0 {
+		// Tags only matter against each other, an idle queue starts over
+		q.virtualTime = 0
+		q.lastFinish = nil
+	}
+	return request
+}
+
+func (q *fairQueue) remove(request *queuedRequest) {
+	for i, waiting := range q.waiting {
+		if waiting == request {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+var limiters sync.Map
+
+// GetAdaptiveConcurrencySettings returns the adaptive concurrency settings with defaults applied
+func GetAdaptiveConcurrencySettings() AdaptiveConcurrency {
+	settings := AdaptiveConcurrency{}
+	if adaptive := GetConfig().Settings.AdaptiveConcurrency; adaptive != nil {
+		settings = *adaptive
+	}
+	if settings.MinLimit <= 0 {
+		settings.MinLimit = 1
+	}
+	if settings.MaxLimit < settings.MinLimit {
+		settings.MaxLimit = 200
+	}
+	if settings.InitialLimit < settings.MinLimit || settings.InitialLimit > settings.MaxLimit {
+		settings.InitialLimit = int(math.Min(20, float64(settings.MaxLimit)))
+	}
+	if settings.BackoffRatio <= 0 || settings.BackoffRatio >= 1 {
+		settings.BackoffRatio = 0.9
+	}
+	if settings.LatencyThresholdMs <= 0 {
+		settings.LatencyThresholdMs = 10000
+	}
+	if settings.QueueTimeoutMs <= 0 {
+		settings.QueueTimeoutMs = 5000
+	}
+	return settings
+}
+
+// QueueWeight returns the fair queuing weight of the API key of ctx, 1 unless listed
+func (settings AdaptiveConcurrency) QueueWeight(ctx context.Context) float64 {
+	apiKeyId, _ := ctx.Value("apiKeyId").(uuid.UUID)
+	for _, weight := range settings.QueueWeights {
+		if weight.Weight > 0 && strings.EqualFold(weight.APIKey, apiKeyId.String()) {
+			return weight.Weight
+		}
+	}
+	return 1
+}
+
+func limiterFor(host string, settings AdaptiveConcurrency) *adaptiveLimiter {
+	if limiter, ok := limiters.Load(host); ok {
+		return limiter.(*adaptiveLimiter)
+	}
+	limiter, _ := limiters.LoadOrStore(host, &adaptiveLimiter{limit: float64(settings.InitialLimit)})
+	return limiter.(*adaptiveLimiter)
+}
+
+// acquire waits for a free slot until ctx is done, queued fairly with the
+// other requests of the upstream by the API key of ctx
+func (l *adaptiveLimiter) acquire(ctx context.Context, weight float64) error {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && len(l.queue.waiting) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	apiKeyId, _ := ctx.Value("apiKeyId").(uuid.UUID)
+	request := l.queue.push(apiKeyId.String(), weight)
+	l.mu.Unlock()
+
+	select {
+	case <-request.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if request.granted {
+			// The slot was handed over while ctx ended, pass it on
+			l.inFlight--
+			l.dispatch()
+		} else {
+			l.queue.remove(request)
+		}
+		l.rejected++
+		return ctx.Err()
+	}
+}
+
+// dispatch hands the free slots to the queued requests, l.mu must be held
+func (l *adaptiveLimiter) dispatch() {
+	for l.inFlight < int(l.limit) && len(l.queue.waiting) > 0 {
+		request := l.queue.pop()
+		request.granted = true
+		l.inFlight++
+		close(request.ready)
+	}
+}
+
+// release frees a slot and adjusts the limit from the outcome of the request
+func (l *adaptiveLimiter) release(settings AdaptiveConcurrency, latency time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if overloaded {
+		// Back off at most once per request duration, so a burst of failures
+		// from the same overload only counts once
+		if time.Since(l.lastDecrease) > latency {
+			l.limit = math.Max(float64(settings.MinLimit), l.limit*settings.BackoffRatio)
+			l.lastDecrease = time.Now()
+			l.decreases++
+		}
+	} else {
+		l.limit = math.Min(float64(settings.MaxLimit), l.limit+1/l.limit)
+	}
+
+	l.dispatch()
+}
+
+// ConcurrencyStatuses returns the current limit of every upstream host
+func ConcurrencyStatuses() []ConcurrencyStats {
+	var stats []ConcurrencyStats
+	limiters.Range(func(key, value interface{}) bool {
+		limiter := value.(*adaptiveLimiter)
+		limiter.mu.Lock()
+		stats = append(stats, ConcurrencyStats{
+			Host:      key.(string),
+			Limit:     limiter.limit,
+			InFlight:  limiter.inFlight,
+			Decreases: limiter.decreases,
+			Rejected:  limiter.rejected,
+		})
+		limiter.mu.Unlock()
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// adaptiveTransport applies the adaptive concurrency limit of the upstream host.
+// A slot is held until the response body is closed, so streams count for their
+// whole duration, while the latency signal is the time to the response headers.
+type adaptiveTransport struct {
+	next http.RoundTripper
+}
+
+func (t *adaptiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	settings := GetAdaptiveConcurrencySettings()
+	if !settings.Enabled {
+		return t.next.RoundTrip(req)
+	}
+
+	limiter := limiterFor(req.URL.Host, settings)
+	ctx, cancel := context.WithTimeout(req.Context(), time.Duration(settings.QueueTimeoutMs)*time.Millisecond)
+	err := limiter.acquire(ctx, settings.QueueWeight(req.Context()))
+	cancel()
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		return nil, fmt.Errorf("%w for %s", ErrConcurrencyLimited, req.URL.Host)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	latency := time.Since(start)
+	if err != nil {
+		// Requests cancelled by the client say nothing about the upstream
+		overloaded := !errors.Is(err, context.Canceled)
+		limiter.release(settings, latency, overloaded)
+		return nil, err
+	}
+
+	overloaded := resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable ||
+		latency > time.Duration(settings.LatencyThresholdMs)*time.Millisecond
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() {
+		limiter.release(settings, latency, overloaded)
+	}}
+	return resp, nil
+}
+
+// releasingBody releases the concurrency slot of a response once its body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	settings := AdaptiveConcurrency{InitialLimit: 2, MinLimit: 1, MaxLimit: 4, BackoffRatio: 0.5}
+	limiter := &adaptiveLimiter{limit: 2}
+
+	assert.NoError(t, limiter.acquire(context.Background(), 1))
+	assert.NoError(t, limiter.acquire(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, limiter.acquire(ctx, 1), "limit of 2 should be reached")
+
+	limiter.release(settings, time.Millisecond, false)
+	assert.InDelta(t, 2.5, limiter.limit, 0.0001)
+
+	limiter.release(settings, time.Millisecond, true)
+	assert.InDelta(t, 1.25, limiter.limit, 0.0001)
+
+	// A second overload within the same request duration doesn't back off again
+	assert.NoError(t, limiter.acquire(context.Background(), 1))
+	limiter.release(settings, time.Hour, true)
+	assert.InDelta(t, 1.25, limiter.limit, 0.0001)
+	assert.Equal(t, 0, limiter.inFlight)
+}
+
+func TestAdaptiveLimiterFairQueuing(t *testing.T) {
+	settings := AdaptiveConcurrency{MinLimit: 1, MaxLimit: 1, BackoffRatio: 0.5}
+	limiter := &adaptiveLimiter{limit: 1}
+	assert.NoError(t, limiter.acquire(context.Background(), 1))
+
+	noisy := context.WithValue(context.Background(), "apiKeyId", uuid.New())
+	quiet := context.WithValue(context.Background(), "apiKeyId", uuid.New())
+	order := make(chan string, 4)
+	wait := func(ctx context.Context, name string) {
+		assert.NoError(t, limiter.acquire(ctx, 1))
+		order <- name
+	}
+	// Three requests of the noisy key queue up before the quiet key's one
+	for i := 0; i < 3; i++ {
+		go wait(noisy, "noisy")
+		time.Sleep(5 * time.Millisecond)
+	}
+	go wait(quiet, "quiet")
+	time.Sleep(5 * time.Millisecond)
+
+	var served []string
+	for i := 0; i < 4; i++ {
+		limiter.release(settings, time.Millisecond, false)
+		served = append(served, <-order)
+	}
+	assert.Equal(t, []string{"noisy", "quiet", "noisy", "noisy"}, served)
+}
@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// parseAnalyticsTime reads a time given as RFC 3339 or as a day
+func parseAnalyticsTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// UsageAnalyticsHandler answers the hourly or daily usage totals of the usage
+// aggregates, split by the group_by dimensions, only of their own workspace for
+// workspace admins
+func UsageAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	query := lib.UsageAggregateQuery{Period: r.URL.Query().Get("period"), WorkspaceID: adminWorkspace(r)}
+	if query.Period == "" {
+		query.Period = "hour"
+	}
+	window := 24 * time.Hour
+	if query.Period == "day" {
+		window = 30 * 24 * time.Hour
+	}
+
+	var err error
+	if query.To, err = parseAnalyticsTime(r.URL.Query().Get("to"), time.Now()); err != nil {
+		writeError(w, http.StatusBadRequest, "to must be an RFC 3339 time or a day")
+		return
+	}
+	if query.From, err = parseAnalyticsTime(r.URL.Query().Get("from"), query.To.Add(-window)); err != nil {
+		writeError(w, http.StatusBadRequest, "from must be an RFC 3339 time or a day")
+		return
+	}
+	if groupBy := r.URL.Query().Get("group_by"); groupBy != "" {
+		query.GroupBy = strings.Split(groupBy, ",")
+	}
+
+	rows, err := lib.QueryUsageAggregates(r.Context(), query)
+	if errors.Is(err, lib.ErrInvalidUsageQuery) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period":  query.Period,
+		"from":    query.From,
+		"to":      query.To,
+		"buckets": rows,
+	})
+}
@@ -0,0 +1,241 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ApiKeyResource is an API key as the declarative admin API reads and writes
+// it. The key itself is only returned by the request that created it.
+type ApiKeyResource struct {
+	Id        uuid.UUID     `json:"id"`
+	ProductID uuid.UUID     `json:"product_id"`
+	Status    models.Status `json:"status"`
+	Scopes    string        `json:"scopes"`
+	// RateLimitTier is a tier of the rate limiting settings, the route limit applies without one
+	RateLimitTier string    `json:"rate_limit_tier,omitempty"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	ApiKey        string    `json:"api_key,omitempty"`
+}
+
+func apiKeyResource(apiKey models.ApiKeys) ApiKeyResource {
+	return ApiKeyResource{
+		Id:            apiKey.Id,
+		ProductID:     apiKey.ProductID,
+		Status:        apiKey.Status,
+		Scopes:        apiKey.Scopes,
+		RateLimitTier: apiKey.RateLimitTier,
+		CreatedBy:     apiKey.CreatedBy,
+		CreatedAt:     apiKey.CreatedAt,
+		UpdatedAt:     apiKey.UpdatedAt,
+	}
+}
+
+// newApiKey returns a random API key
+func newApiKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "os-" + hex.EncodeToString(key), nil
+}
+
+func ListApiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	var apiKeys []models.ApiKeys
+	if err := pageOf(lib.DB().WithContext(r.Context()), page, &apiKeys); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := lib.Page{}
+	if len(apiKeys) > page.Limit {
+		apiKeys = apiKeys[:page.Limit]
+		result.NextCursor = lib.PageCursor(apiKeys[page.Limit-1].Id.String())
+	}
+	data := make([]ApiKeyResource, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		data = append(data, apiKeyResource(apiKey))
+	}
+	result.Data = data
+	json.NewEncoder(w).Encode(result)
+}
+
+func GetApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid api key id")
+		return
+	}
+	var apiKey models.ApiKeys
+	if err := lib.DB().WithContext(r.Context()).Where("id = ?", keyID).First(&apiKey).Error; err != nil {
+		writeResourceError(w, err, "api key")
+		return
+	}
+	resource := apiKeyResource(apiKey)
+	writeResource(w, http.StatusOK, resource, lib.ResourceETag(resource))
+}
+
+// validateApiKey checks an API key that was put, an unset status is active.
+// Its product must exist.
+func validateApiKey(ctx context.Context, req *ApiKeyResource) error {
+	if req.Status == "" {
+		req.Status = models.Active
+	}
+	switch {
+	case req.ProductID == uuid.Nil:
+		return fmt.Errorf("%w: product_id is required", errInvalidResource)
+	case !validStatus(req.Status):
+		return fmt.Errorf("%w: status must be active, inactive or archived", errInvalidResource)
+	case req.RateLimitTier != "" && !lib.RateLimitTierExists(req.RateLimitTier):
+		return fmt.Errorf("%w: rate_limit_tier %s is not a configured tier", errInvalidResource, req.RateLimitTier)
+	}
+	var product models.Products
+	if err := lib.DB().WithContext(ctx).Where("id = ?", req.ProductID).First(&product).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: product_id is not an existing product", errInvalidResource)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// putApiKey creates the API key keyID or replaces its product, status, scopes
+// and rate limit tier, reporting whether it was created. Putting the key it already is
+// changes nothing.
+func putApiKey(ctx context.Context, user models.AdminUsers, keyID uuid.UUID, req ApiKeyResource, precondition func(etag string) error) (models.ApiKeys, bool, error) {
+	var apiKey models.ApiKeys
+	created := false
+	err := lib.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", keyID).First(&apiKey).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := precondition(""); err != nil {
+				return err
+			}
+			var deleted int64
+			if err := tx.Unscoped().Model(&models.ApiKeys{}).Where("id = ?", keyID).Count(&deleted).Error; err != nil {
+				return err
+			}
+			if deleted > 0 {
+				return errDeletedResource
+			}
+			key, err := newApiKey()
+			if err != nil {
+				return err
+			}
+			apiKey = models.ApiKeys{
+				Base:          models.Base{Id: keyID},
+				ProductID:     req.ProductID,
+				ApiKey:        key,
+				Status:        req.Status,
+				CreatedBy:     user.UserName,
+				Scopes:        req.Scopes,
+				RateLimitTier: req.RateLimitTier,
+			}
+			created = true
+			return tx.Create(&apiKey).Error
+		}
+		if err != nil {
+			return err
+		}
+		if err := precondition(lib.ResourceETag(apiKeyResource(apiKey))); err != nil {
+			return err
+		}
+
+		changed := apiKey
+		changed.ProductID, changed.Status, changed.Scopes, changed.RateLimitTier = req.ProductID, req.Status, req.Scopes, req.RateLimitTier
+		if apiKeyResource(changed) == apiKeyResource(apiKey) {
+			return nil
+		}
+		changed.UpdatedAt = time.Now().UTC()
+		apiKey = changed
+		return tx.Model(&apiKey).Select("product_id", "status", "scopes", "rate_limit_tier", "updated_at").Updates(&apiKey).Error
+	})
+	if err == nil && !created {
+		lib.ForgetRateLimitIdentity(ctx, keyID)
+	}
+	return apiKey, created, err
+}
+
+// deleteApiKey deletes the API key keyID
+func deleteApiKey(ctx context.Context, keyID uuid.UUID, precondition func(etag string) error) error {
+	defer lib.ForgetRateLimitIdentity(ctx, keyID)
+	return lib.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var apiKey models.ApiKeys
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", keyID).First(&apiKey).Error; err != nil {
+			return err
+		}
+		if err := precondition(lib.ResourceETag(apiKeyResource(apiKey))); err != nil {
+			return err
+		}
+		return tx.Where("id = ?", keyID).Delete(&models.ApiKeys{}).Error
+	})
+}
+
+// createdApiKeyResource returns the resource of a key that was put, with the
+// key value if the write created it
+func createdApiKeyResource(apiKey models.ApiKeys, created bool) ApiKeyResource {
+	resource := apiKeyResource(apiKey)
+	if created {
+		resource.ApiKey = apiKey.ApiKey
+	}
+	return resource
+}
+
+// PutApiKeyHandler creates the API key with the ID of the path or replaces it
+func PutApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid api key id")
+		return
+	}
+	var req ApiKeyResource
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if err := validateApiKey(r.Context(), &req); err != nil {
+		writeResourceError(w, err, "api key")
+		return
+	}
+
+	user, _ := r.Context().Value("adminUser").(models.AdminUsers)
+	apiKey, created, err := putApiKey(r.Context(), user, keyID, req, requestPreconditions(r))
+	if err != nil {
+		writeResourceError(w, err, "api key")
+		return
+	}
+	auditAdminAction(r, "api_key_put", map[string]string{"id": keyID.String(), "product_id": apiKey.ProductID.String()})
+
+	writeResource(w, writeStatus(created), createdApiKeyResource(apiKey, created), lib.ResourceETag(apiKeyResource(apiKey)))
+}
+
+func DeleteApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid api key id")
+		return
+	}
+	if err := deleteApiKey(r.Context(), keyID, requestPreconditions(r)); err != nil {
+		writeResourceError(w, err, "api key")
+		return
+	}
+	auditAdminAction(r, "api_key_delete", map[string]string{"id": keyID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
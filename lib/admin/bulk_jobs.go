@@ -0,0 +1,380 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+// maxBulkOperations is the most operations a bulk job takes
+const maxBulkOperations = 1000
+
+const (
+	bulkJobRunning   = "running"
+	bulkJobCompleted = "completed"
+	bulkJobFailed    = "failed"
+)
+
+const (
+	// bulkJobStaleAfter is how long a running job goes without progress before
+	// it is failed, its replica having stopped
+	bulkJobStaleAfter = 15 * time.Minute
+	// bulkJobKeysTTL is how long the key values of created API keys wait for a poll
+	bulkJobKeysTTL = time.Hour
+)
+
+func init() {
+	lib.RegisterJob(lib.Job{
+		Name:     "fail_stale_bulk_jobs",
+		Interval: 5 * time.Minute,
+		Run:      failStaleBulkJobs,
+	})
+}
+
+// BulkOperation is one write of a bulk job: the put or delete of the product
+// or API key with ID Id. Body is the resource of a put, as the PUT endpoint of
+// the resource takes it, and Version the version a delete of a product is
+// based on.
+type BulkOperation struct {
+	Action   string          `json:"action"`
+	Resource string          `json:"resource"`
+	Id       uuid.UUID       `json:"id"`
+	Version  int             `json:"version,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+}
+
+// BulkJobRequest is the body of the creation of a bulk job
+type BulkJobRequest struct {
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkResult is the outcome of an operation of a bulk job, with the status
+// code the single request would have been answered with. Resource is the
+// written resource, with the key value for created API keys in the first poll
+// answered after they were created.
+type BulkResult struct {
+	Index    int         `json:"index"`
+	Status   int         `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Resource interface{} `json:"resource,omitempty"`
+}
+
+// BulkJobResource is a bulk job as its progress is polled
+type BulkJobResource struct {
+	Id        uuid.UUID    `json:"id"`
+	Status    string       `json:"status"`
+	Total     int          `json:"total"`
+	Completed int          `json:"completed"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+func bulkJobResource(job models.BulkJobs) BulkJobResource {
+	results := []BulkResult{}
+	json.Unmarshal([]byte(job.Results), &results)
+	return BulkJobResource{
+		Id:        job.Base.Id,
+		Status:    job.Status,
+		Total:     job.Total,
+		Completed: job.Succeeded + job.Failed,
+		Succeeded: job.Succeeded,
+		Failed:    job.Failed,
+		Results:   results,
+		CreatedBy: job.CreatedBy,
+		CreatedAt: job.Base.CreatedAt,
+		UpdatedAt: job.Base.UpdatedAt,
+	}
+}
+
+// validateBulkOperation checks what of an operation can be checked before the
+// job runs, the bodies are checked as their operations run
+func validateBulkOperation(operation BulkOperation) error {
+	switch {
+	case operation.Action != "put" && operation.Action != "delete":
+		return errors.New("action must be put or delete")
+	case operation.Resource != "product" && operation.Resource != "api_key":
+		return errors.New("resource must be product or api_key")
+	case operation.Id == uuid.Nil:
+		return errors.New("id is required")
+	case operation.Action == "put" && len(operation.Body) == 0:
+		return errors.New("body is required to put a resource")
+	}
+	return nil
+}
+
+// noPreconditions is the precondition of the writes of bulk jobs, which are
+// only checked against the versions they are based on
+func noPreconditions(etag string) error {
+	return nil
+}
+
+// runBulkOperation runs an operation of a bulk job like its single request
+func runBulkOperation(r *http.Request, user models.AdminUsers, operation BulkOperation) (int, interface{}, error) {
+	ctx := r.Context()
+	switch operation.Resource + " " + operation.Action {
+	case "product put":
+		var req ProductResource
+		if err := json.Unmarshal(operation.Body, &req); err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", errInvalidResource, err)
+		}
+		if err := validateProduct(&req); err != nil {
+			return 0, nil, err
+		}
+		product, created, err := putProduct(ctx, user, operation.Id, req, noPreconditions)
+		if err != nil {
+			return 0, nil, err
+		}
+		auditAdminAction(r, "product_put", map[string]string{"id": operation.Id.String(), "name": product.Name})
+		return writeStatus(created), productResource(product), nil
+	case "product delete":
+		if err := deleteProduct(ctx, operation.Id, operation.Version, noPreconditions); err != nil {
+			return 0, nil, err
+		}
+		auditAdminAction(r, "product_delete", map[string]string{"id": operation.Id.String()})
+		return http.StatusNoContent, nil, nil
+	case "api_key put":
+		var req ApiKeyResource
+		if err := json.Unmarshal(operation.Body, &req); err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", errInvalidResource, err)
+		}
+		if err := validateApiKey(ctx, &req); err != nil {
+			return 0, nil, err
+		}
+		apiKey, created, err := putApiKey(ctx, user, operation.Id, req, noPreconditions)
+		if err != nil {
+			return 0, nil, err
+		}
+		auditAdminAction(r, "api_key_put", map[string]string{"id": operation.Id.String(), "product_id": apiKey.ProductID.String()})
+		return writeStatus(created), createdApiKeyResource(apiKey, created), nil
+	default:
+		if err := deleteApiKey(ctx, operation.Id, noPreconditions); err != nil {
+			return 0, nil, err
+		}
+		auditAdminAction(r, "api_key_delete", map[string]string{"id": operation.Id.String()})
+		return http.StatusNoContent, nil, nil
+	}
+}
+
+// bulkJobKeys holds the key values of the API keys created by the bulk jobs of
+// this replica until a poll returns them, when Redis isn't configured
+var bulkJobKeys = struct {
+	sync.Mutex
+	jobs map[uuid.UUID]bulkJobKeySet
+}{jobs: map[uuid.UUID]bulkJobKeySet{}}
+
+type bulkJobKeySet struct {
+	keys      map[int]string
+	expiresAt time.Time
+}
+
+func bulkJobKeysKey(jobID uuid.UUID) string {
+	return "openshield:bulk_jobs:" + jobID.String() + ":keys"
+}
+
+// stashBulkJobKey keeps the key value of the API key an operation created for
+// the next poll of the job. The results of a job never hold key values, so
+// they last no longer than it takes to fetch them. With Redis the poll can be
+// answered by any replica.
+func stashBulkJobKey(ctx context.Context, jobID uuid.UUID, index int, key string) error {
+	if lib.RedisConfigured() {
+		pipe := lib.RedisClient().TxPipeline()
+		pipe.HSet(ctx, bulkJobKeysKey(jobID), strconv.Itoa(index), key)
+		pipe.Expire(ctx, bulkJobKeysKey(jobID), bulkJobKeysTTL)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+
+	bulkJobKeys.Lock()
+	defer bulkJobKeys.Unlock()
+	now := time.Now()
+	for id, set := range bulkJobKeys.jobs {
+		if now.After(set.expiresAt) {
+			delete(bulkJobKeys.jobs, id)
+		}
+	}
+	set, ok := bulkJobKeys.jobs[jobID]
+	if !ok {
+		set.keys = map[int]string{}
+	}
+	set.keys[index] = key
+	set.expiresAt = now.Add(bulkJobKeysTTL)
+	bulkJobKeys.jobs[jobID] = set
+	return nil
+}
+
+// takeBulkJobKeys returns the key values stashed for a job by operation index
+// and forgets them
+func takeBulkJobKeys(ctx context.Context, jobID uuid.UUID) (map[int]string, error) {
+	keys := map[int]string{}
+	if lib.RedisConfigured() {
+		pipe := lib.RedisClient().TxPipeline()
+		stashed := pipe.HGetAll(ctx, bulkJobKeysKey(jobID))
+		pipe.Del(ctx, bulkJobKeysKey(jobID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+		for field, key := range stashed.Val() {
+			if index, err := strconv.Atoi(field); err == nil {
+				keys[index] = key
+			}
+		}
+		return keys, nil
+	}
+
+	bulkJobKeys.Lock()
+	defer bulkJobKeys.Unlock()
+	if set, ok := bulkJobKeys.jobs[jobID]; ok && time.Now().Before(set.expiresAt) {
+		keys = set.keys
+	}
+	delete(bulkJobKeys.jobs, jobID)
+	return keys, nil
+}
+
+// withBulkJobKeys adds key values to the results of the operations that created them
+func withBulkJobKeys(resource *BulkJobResource, keys map[int]string) {
+	for i, result := range resource.Results {
+		key, ok := keys[result.Index]
+		if !ok {
+			continue
+		}
+		if fields, ok := result.Resource.(map[string]interface{}); ok {
+			fields["api_key"] = key
+		}
+		resource.Results[i] = result
+	}
+}
+
+// failStaleBulkJobs fails the jobs left running by a replica that stopped, which
+// would otherwise be polled as running forever. Running jobs record their
+// progress after every operation.
+func failStaleBulkJobs(ctx context.Context) error {
+	return lib.DB().WithContext(ctx).Model(&models.BulkJobs{}).
+		Where("status = ? AND updated_at < ?", bulkJobRunning, time.Now().UTC().Add(-bulkJobStaleAfter)).
+		Updates(map[string]interface{}{"status": bulkJobFailed, "updated_at": time.Now().UTC()}).Error
+}
+
+// runBulkJob runs the operations of a job in order, recording the progress
+// after each of them. An operation that fails doesn't stop the job.
+func runBulkJob(r *http.Request, user models.AdminUsers, job models.BulkJobs, operations []BulkOperation) {
+	results := make([]BulkResult, 0, len(operations))
+	for index, operation := range operations {
+		result := BulkResult{Index: index}
+		status, resource, err := runBulkOperation(r, user, operation)
+		var createdKey string
+		if apiKey, ok := resource.(ApiKeyResource); ok {
+			createdKey, apiKey.ApiKey = apiKey.ApiKey, ""
+			resource = apiKey
+		}
+		if err != nil {
+			result.Status, result.Error = resourceErrorStatus(err, strings.ReplaceAll(operation.Resource, "_", " "))
+			job.Failed++
+		} else {
+			result.Status, result.Resource = status, resource
+			job.Succeeded++
+		}
+		results = append(results, result)
+
+		if index == len(operations)-1 {
+			job.Status = bulkJobCompleted
+		}
+		data, _ := json.Marshal(results)
+		job.Results = string(data)
+		err = lib.DB().Model(&models.BulkJobs{}).Where("id = ?", job.Base.Id).Updates(map[string]interface{}{
+			"status":     job.Status,
+			"succeeded":  job.Succeeded,
+			"failed":     job.Failed,
+			"results":    job.Results,
+			"updated_at": time.Now().UTC(),
+		}).Error
+		if err != nil {
+			log.Printf("error recording the progress of bulk job %s: %v", job.Base.Id, err)
+		}
+		// Stashed once the result is recorded, so the poll taking it can return it
+		if createdKey != "" {
+			if err := stashBulkJobKey(r.Context(), job.Base.Id, index, createdKey); err != nil {
+				log.Printf("error keeping the key of API key %s of bulk job %s: %v", operation.Id, job.Base.Id, err)
+			}
+		}
+	}
+}
+
+// CreateBulkJobHandler starts a job putting and deleting products and API keys
+// in the background, answering 202 with the job to poll. The operations run
+// in order, so a job can create products and then their keys.
+func CreateBulkJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBulkOperations {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("a job takes 1 to %d operations", maxBulkOperations))
+		return
+	}
+	for index, operation := range req.Operations {
+		if err := validateBulkOperation(operation); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: %v", index, err))
+			return
+		}
+	}
+
+	user, _ := r.Context().Value("adminUser").(models.AdminUsers)
+	job := models.BulkJobs{
+		Status:    bulkJobRunning,
+		Total:     len(req.Operations),
+		Results:   "[]",
+		CreatedBy: user.UserName,
+	}
+	if err := lib.DB().WithContext(r.Context()).Create(&job).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "bulk_job_create", map[string]string{"id": job.Base.Id.String(), "operations": fmt.Sprint(job.Total)})
+
+	// The job outlives the request, which keeps being used for the audit log
+	go runBulkJob(r.WithContext(context.WithoutCancel(r.Context())), user, job, req.Operations)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/v1/jobs/"+job.Base.Id.String())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(bulkJobResource(job))
+}
+
+// GetBulkJobHandler answers with the progress of a bulk job and the results of
+// its finished operations. Key values of created API keys are only answered
+// once.
+func GetBulkJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job id")
+		return
+	}
+	var job models.BulkJobs
+	if err := lib.DB().WithContext(r.Context()).Where("id = ?", jobID).First(&job).Error; err != nil {
+		writeResourceError(w, err, "job")
+		return
+	}
+	resource := bulkJobResource(job)
+	keys, err := takeBulkJobKeys(r.Context(), jobID)
+	if err != nil {
+		log.Printf("error taking the API keys of bulk job %s: %v", jobID, err)
+	}
+	withBulkJobKeys(&resource, keys)
+	json.NewEncoder(w).Encode(resource)
+}
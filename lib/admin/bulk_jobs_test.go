@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkJobKeys(t *testing.T) {
+	redis := lib.AppConfig.Settings.Redis
+	defer func() { lib.AppConfig.Settings.Redis = redis }()
+	lib.AppConfig.Settings.Redis = nil
+
+	ctx := context.Background()
+	jobID := uuid.New()
+	keyID := uuid.New()
+	require.NoError(t, stashBulkJobKey(ctx, jobID, 1, "os-secret"))
+
+	// The stored results hold the key without its value
+	results, _ := json.Marshal([]BulkResult{
+		{Index: 0, Status: 201, Resource: ProductResource{Name: "team-a"}},
+		{Index: 1, Status: 201, Resource: ApiKeyResource{Id: keyID}},
+	})
+	job := models.BulkJobs{Base: models.Base{Id: jobID}, Status: bulkJobRunning, Total: 2, Succeeded: 2, Results: string(results)}
+	assert.NotContains(t, job.Results, "os-secret")
+
+	keys, err := takeBulkJobKeys(ctx, jobID)
+	require.NoError(t, err)
+	resource := bulkJobResource(job)
+	withBulkJobKeys(&resource, keys)
+	body, _ := json.Marshal(resource)
+	assert.Contains(t, string(body), `"api_key":"os-secret"`)
+
+	// The next poll no longer has it
+	keys, err = takeBulkJobKeys(ctx, jobID)
+	require.NoError(t, err)
+	resource = bulkJobResource(job)
+	withBulkJobKeys(&resource, keys)
+	body, _ = json.Marshal(resource)
+	assert.NotContains(t, string(body), "os-secret")
+
+	// Keys no poll fetched expire
+	require.NoError(t, stashBulkJobKey(ctx, jobID, 1, "os-secret"))
+	bulkJobKeys.Lock()
+	set := bulkJobKeys.jobs[jobID]
+	set.expiresAt = time.Now().Add(-time.Second)
+	bulkJobKeys.jobs[jobID] = set
+	bulkJobKeys.Unlock()
+	keys, err = takeBulkJobKeys(ctx, jobID)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
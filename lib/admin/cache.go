@@ -0,0 +1,26 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// CacheStatsHandler returns the hits and misses of the response cache of this instance
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.GetCacheStats())
+}
+
+// InvalidateCacheHandler deletes the cached completions of the model of the
+// model query parameter, "models" for model lists, or every cached response
+func InvalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	scope := r.URL.Query().Get("model")
+	deleted, err := lib.InvalidateCache(r.Context(), scope)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "cache_invalidate", map[string]interface{}{"model": scope, "deleted": deleted})
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+}
@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func ConcurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": lib.GetAdaptiveConcurrencySettings().Enabled,
+		"hosts":   lib.ConcurrencyStatuses(),
+	})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// maxConfigBundleSize bounds the size of an uploaded bundle
+const maxConfigBundleSize = 32 << 20
+
+func ExportConfigBundleHandler(w http.ResponseWriter, r *http.Request) {
+	bundle, err := lib.ExportConfigBundle()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	data, err := lib.MarshalConfigBundle(bundle, format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "config_export", map[string]string{"format": format})
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Write(data)
+}
+
+func ImportConfigBundleHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBundleSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Error reading request body")
+		return
+	}
+	bundle, err := lib.UnmarshalConfigBundle(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := lib.ImportConfigBundle(bundle)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	auditAdminAction(r, "config_import", result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+type ConfigRolloutRequest struct {
+	Config  string `json:"config"`
+	Percent int    `json:"percent"`
+}
+
+type RollbackRequest struct {
+	Reason string `json:"reason"`
+}
+
+func ConfigRolloutStatusHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.ConfigRolloutStatus())
+}
+
+func StartConfigRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	var req ConfigRolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if req.Config == "" {
+		writeError(w, http.StatusBadRequest, "config is required")
+		return
+	}
+
+	user := r.Context().Value("adminUser").(models.AdminUsers)
+	canary, err := lib.StartConfigRollout([]byte(req.Config), req.Percent, user.UserName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	auditAdminAction(r, "config_rollout_start", map[string]interface{}{"version": canary.Version, "percent": canary.Percent})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lib.ConfigRolloutStatus())
+}
+
+func PromoteConfigRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	version := lib.ConfigRolloutStatus().Version
+	if err := lib.PromoteConfigRollout(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	auditAdminAction(r, "config_rollout_promote", map[string]string{"version": version})
+	json.NewEncoder(w).Encode(lib.ConfigRolloutStatus())
+}
+
+func RollbackConfigRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	var req RollbackRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Reason == "" {
+		req.Reason = "manual rollback"
+	}
+
+	version := lib.ConfigRolloutStatus().Version
+	if err := lib.RollbackConfigRollout(req.Reason); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	auditAdminAction(r, "config_rollout_rollback", map[string]string{"version": version, "reason": req.Reason})
+	json.NewEncoder(w).Encode(lib.ConfigRolloutStatus())
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// VerifyContentRequest holds the exact bodies of a disputed provider exchange
+type VerifyContentRequest struct {
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+// VerifyContentResponse reports whether the bodies match the hashes of a usage row
+type VerifyContentResponse struct {
+	UsageID       uuid.UUID `json:"usage_id"`
+	Hashed        bool      `json:"hashed"`
+	RequestMatch  bool      `json:"request_match"`
+	ResponseMatch bool      `json:"response_match"`
+}
+
+// VerifyUsageContentHandler checks disputed request and response bodies against
+// the salted hashes stored with a usage row
+func VerifyUsageContentHandler(w http.ResponseWriter, r *http.Request) {
+	usageID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid usage id")
+		return
+	}
+	var req VerifyContentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+
+	var usage models.Usage
+	if err := lib.DB().First(&usage, "id = ?", usageID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "usage not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := VerifyContentResponse{UsageID: usageID, Hashed: usage.HashSalt != ""}
+	if result.Hashed {
+		result.RequestMatch = req.Request != "" && lib.VerifyContentHash(usage.HashSalt, usage.RequestHash, []byte(req.Request))
+		result.ResponseMatch = req.Response != "" && lib.VerifyContentHash(usage.HashSalt, usage.ResponseHash, []byte(req.Response))
+	}
+	auditAdminAction(r, "usage_content_verify", result)
+	json.NewEncoder(w).Encode(result)
+}
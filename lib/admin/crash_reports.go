@@ -0,0 +1,12 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func ListCrashReportsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.ListCrashReports())
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// DetectorReloadRequest optionally loads a detector from another model file
+type DetectorReloadRequest struct {
+	Path string `json:"path"`
+}
+
+func ListDetectorsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.ListDetectors())
+}
+
+// ReloadDetectorHandler swaps the model of a detector on every replica, the
+// previous model keeps serving until the new one loaded
+func ReloadDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var req DetectorReloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	status, err := lib.ReloadDetector(name, req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := lib.PublishDetectorReload(r.Context(), name, req.Path); err != nil {
+		log.Printf("Error publishing reload of detector %s: %v", name, err)
+	}
+	auditAdminAction(r, "detector_reload", map[string]string{"name": name, "path": status.Path})
+	json.NewEncoder(w).Encode(status)
+}
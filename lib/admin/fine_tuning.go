@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+// ListFineTuneJobsHandler returns the fine-tuning jobs created through the
+// gateway, only those of their own workspace for workspace admins
+func ListFineTuneJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := lib.FineTuneJobs(adminWorkspace(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if jobs == nil {
+		jobs = []models.FineTuneJobs{}
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
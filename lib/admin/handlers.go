@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+const SessionCookieName = "openshield_session"
+
+// dummyPasswordHash is verified against when the user does not exist
+const dummyPasswordHash = "$argon2id$v=19$m=65536,t=3,p=2$4gkobkT2v2iDeBSen9fdfw$Akki3THSk3JyEp0jxZpEH4JaiWt31vO19j4FMgcrbvg"
+
+type LoginRequest struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
+	TOTPCode string `json:"totp_code"`
+}
+
+type LoginResponse struct {
+	UserName  string           `json:"username"`
+	Role      models.AdminRole `json:"role"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if strings.TrimSpace(req.UserName) == "" {
+		writeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+
+	settings := lib.GetAdminSettings()
+	now := time.Now()
+
+	// Struct conditions drop empty fields, the username is always matched
+	var user models.AdminUsers
+	result := lib.DB().Where("user_name = ? AND status = ?", req.UserName, models.Active).First(&user)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			log.Printf("Error: %v", result.Error)
+		}
+		// Spend the same time as a real verification so unknown users can't be enumerated
+		lib.VerifyPassword(req.Password, dummyPasswordHash)
+		auditLogin(r, req.UserName, uuid.Nil, "invalid_credentials")
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	// Locked accounts are answered like a wrong password, so a lockout doesn't
+	// tell that the user exists
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		lib.VerifyPassword(req.Password, dummyPasswordHash)
+		auditLogin(r, user.UserName, user.Id, "locked")
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	if user.PasswordHash == "" || !lib.VerifyPassword(req.Password, user.PasswordHash) {
+		registerFailedLogin(&user, settings, now)
+		auditLogin(r, user.UserName, user.Id, "invalid_credentials")
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	if user.TOTPSecret == "" || !lib.ValidateTOTP(user.TOTPSecret, req.TOTPCode, now) {
+		registerFailedLogin(&user, settings, now)
+		auditLogin(r, user.UserName, user.Id, "invalid_totp")
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	token, tokenHash, err := lib.NewSessionToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create session")
+		return
+	}
+
+	expiresAt := now.Add(time.Duration(settings.SessionTTL) * time.Second)
+	session := models.AdminSessions{
+		AdminUserID: user.Id,
+		TokenHash:   tokenHash,
+		IPAddress:   r.RemoteAddr,
+		ExpiresAt:   expiresAt,
+	}
+	if err := lib.DB().Create(&session).Error; err != nil {
+		log.Printf("Error: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create session")
+		return
+	}
+
+	lib.DB().Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+	auditLogin(r, user.UserName, user.Id, "success")
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	json.NewEncoder(w).Encode(LoginResponse{
+		UserName:  user.UserName,
+		Role:      user.Role,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		lib.DB().Where("token_hash = ?", lib.HashSessionToken(cookie.Value)).Delete(&models.AdminSessions{})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuthAdminSessionMiddleware authenticates admin API requests with the session cookie
+// and stores the admin user in the request context
+func AuthAdminSessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(SessionCookieName)
+		if err != nil || cookie.Value == "" {
+			writeError(w, http.StatusUnauthorized, "missing session")
+			return
+		}
+
+		var session models.AdminSessions
+		result := lib.DB().Where("token_hash = ? AND expires_at > ?", lib.HashSessionToken(cookie.Value), time.Now()).First(&session)
+		if result.Error != nil {
+			writeError(w, http.StatusUnauthorized, "invalid session")
+			return
+		}
+
+		var user models.AdminUsers
+		result = lib.DB().Where(&models.AdminUsers{Base: models.Base{Id: session.AdminUserID}, Status: models.Active}).First(&user)
+		if result.Error != nil {
+			writeError(w, http.StatusUnauthorized, "invalid session")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "adminUser", user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdminRole rejects requests from admin users without the admin role
+func RequireAdminRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value("adminUser").(models.AdminUsers)
+		if !ok || user.Role != models.AdminRoleAdmin {
+			writeError(w, http.StatusForbidden, "admin role required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerFailedLogin counts a failed login of user in the database, so parallel
+// attempts can't skip the lockout, and locks the user after too many
+func registerFailedLogin(user *models.AdminUsers, settings lib.AdminSettings, now time.Time) {
+	var failedLogins int
+	if err := lib.DB().Raw("UPDATE admin_users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", user.Id).Scan(&failedLogins).Error; err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	user.FailedLogins = failedLogins
+	if failedLogins < settings.MaxFailedLogins {
+		return
+	}
+
+	lockedUntil := now.Add(time.Duration(settings.LockoutDuration) * time.Second)
+	result := lib.DB().Model(&models.AdminUsers{}).
+		Where("id = ? AND failed_logins >= ?", user.Id, settings.MaxFailedLogins).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": lockedUntil})
+	if result.Error != nil {
+		log.Printf("Error: %v", result.Error)
+		return
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = &lockedUntil
+	if result.RowsAffected > 0 {
+		log.Printf("Admin user %s locked until %v after too many failed logins", user.UserName, lockedUntil)
+	}
+}
+
+func auditLogin(r *http.Request, userName string, userID uuid.UUID, result string) {
+	message, _ := json.Marshal(map[string]string{
+		"username": userName,
+		"user_id":  userID.String(),
+		"result":   result,
+	})
+	// Logins are audited even with audit logging off
+	lib.SecurityAuditLog(string(message), "admin_login", "input", r)
+}
+
+func auditAdminAction(r *http.Request, action string, payload interface{}) {
+	user, _ := r.Context().Value("adminUser").(models.AdminUsers)
+	message, _ := json.Marshal(map[string]interface{}{
+		"username": user.UserName,
+		"user_id":  user.Id.String(),
+		"action":   action,
+		"payload":  payload,
+	})
+	lib.AuditLogs(string(message), "admin_action", uuid.Nil, "input", r)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"code":    statusCode,
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/lib"
+)
+
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"leader": lib.IsJobLeader(),
+		"jobs":   lib.JobStatuses(),
+	})
+}
+
+func TriggerJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := lib.TriggerJob(r.Context(), name); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	auditAdminAction(r, "job_trigger", map[string]string{"name": name})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func WriteQueueHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.GetWriteQueueStats())
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+type KillSwitchRequest struct {
+	Scope   string `json:"scope"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+func ListKillSwitchesHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.ListKillSwitches())
+}
+
+func SetKillSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	var req KillSwitchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if !lib.ValidKillSwitchScope(req.Scope) || req.Target == "" {
+		writeError(w, http.StatusBadRequest, "scope must be one of model, provider or route and target is required")
+		return
+	}
+
+	user := r.Context().Value("adminUser").(models.AdminUsers)
+	killSwitch := lib.KillSwitch{
+		Scope:     req.Scope,
+		Target:    req.Target,
+		Message:   req.Message,
+		CreatedBy: user.UserName,
+	}
+	if err := lib.SetKillSwitch(r.Context(), killSwitch); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "kill_switch_set", req)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lib.ListKillSwitches())
+}
+
+func RemoveKillSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	scope := chi.URLParam(r, "scope")
+	target := chi.URLParam(r, "*")
+	if !lib.ValidKillSwitchScope(scope) || target == "" {
+		writeError(w, http.StatusBadRequest, "invalid kill switch")
+		return
+	}
+	// Route targets are paths, which lose their leading slash in the URL pattern
+	if scope == lib.KillSwitchRoute {
+		target = "/" + target
+	}
+
+	if err := lib.RemoveKillSwitch(r.Context(), scope, target); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "kill_switch_remove", KillSwitchRequest{Scope: scope, Target: target})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func LatencyHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window": lib.GetLatencySLOSettings().Window,
+		"models": lib.LatencyReport(),
+	})
+}
+
+func LatencySLOHandler(w http.ResponseWriter, r *http.Request) {
+	settings := lib.GetLatencySLOSettings()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"objective":      settings.Objective,
+		"first_token_ms": settings.FirstTokenMs,
+		"total_ms":       settings.TotalMs,
+		"window":         settings.Window,
+		"models":         lib.SLOReport(),
+	})
+}
+
+func PromptCompressionHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":  lib.GetPromptCompressionSettings().Enabled,
+		"products": lib.CompressionReport(),
+	})
+}
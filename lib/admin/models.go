@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+type ModelApprovalRequest struct {
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+}
+
+type PendingModel struct {
+	models.AiModels
+	ApprovedWorkspaces []uuid.UUID `json:"approved_workspaces"`
+}
+
+func ListModelsHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := lib.CatalogModels(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if catalog == nil {
+		catalog = []models.AiModels{}
+	}
+	json.NewEncoder(w).Encode(catalog)
+}
+
+func ListPendingModelsHandler(w http.ResponseWriter, r *http.Request) {
+	pending, workspaces, err := lib.PendingModels(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := make([]PendingModel, 0, len(pending))
+	for _, model := range pending {
+		approved := workspaces[model.Id]
+		if approved == nil {
+			approved = []uuid.UUID{}
+		}
+		result = append(result, PendingModel{AiModels: model, ApprovedWorkspaces: approved})
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func ApproveModelHandler(w http.ResponseWriter, r *http.Request) {
+	modelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid model id")
+		return
+	}
+	var req ModelApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if req.WorkspaceID == uuid.Nil {
+		writeError(w, http.StatusBadRequest, "workspace_id is required")
+		return
+	}
+
+	user := r.Context().Value("adminUser").(models.AdminUsers)
+	model, err := lib.ApproveModel(r.Context(), modelID, req.WorkspaceID, user.UserName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "model not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "model_approve", map[string]string{"model": model.Model, "workspace_id": req.WorkspaceID.String()})
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(model)
+}
+
+func RevokeModelApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	modelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid model id")
+		return
+	}
+	workspaceID, err := uuid.Parse(chi.URLParam(r, "workspace"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid workspace id")
+		return
+	}
+
+	model, err := lib.RevokeModelApproval(r.Context(), modelID, workspaceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "model not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "model_revoke", map[string]string{"model": model.Model, "workspace_id": workspaceID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ModelDeprecationRequest struct {
+	DeprecatedAt *time.Time `json:"deprecated_at"`
+	SunsetAt     *time.Time `json:"sunset_at"`
+	Replacement  string     `json:"replacement"`
+}
+
+func DeprecateModelHandler(w http.ResponseWriter, r *http.Request) {
+	modelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid model id")
+		return
+	}
+	var req ModelDeprecationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	deprecation := lib.ModelDeprecation{SunsetAt: req.SunsetAt, Replacement: req.Replacement}
+	if req.DeprecatedAt != nil {
+		deprecation.DeprecatedAt = *req.DeprecatedAt
+	}
+	if req.SunsetAt != nil && req.DeprecatedAt != nil && req.SunsetAt.Before(*req.DeprecatedAt) {
+		writeError(w, http.StatusBadRequest, "sunset_at is before deprecated_at")
+		return
+	}
+
+	model, err := lib.DeprecateModel(r.Context(), modelID, deprecation)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "model not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	details := map[string]string{"model": model.Model, "replacement": model.Replacement}
+	if model.SunsetAt != nil {
+		details["sunset_at"] = model.SunsetAt.Format(time.RFC3339)
+	}
+	auditAdminAction(r, "model_deprecate", details)
+	json.NewEncoder(w).Encode(model)
+}
+
+func UndeprecateModelHandler(w http.ResponseWriter, r *http.Request) {
+	modelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid model id")
+		return
+	}
+
+	model, err := lib.UndeprecateModel(r.Context(), modelID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "model not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "model_undeprecate", map[string]string{"model": model.Model})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+// PolicyOverrideRequest issues an override of the named rules for a service,
+// Duration is in seconds. ApiKeyID optionally limits it to one API key.
+type PolicyOverrideRequest struct {
+	Service  string    `json:"service"`
+	Rules    []string  `json:"rules"`
+	ApiKeyID uuid.UUID `json:"api_key_id"`
+	Duration int       `json:"duration"`
+}
+
+// PolicyOverrideResponse holds an override token and what it allows
+type PolicyOverrideResponse struct {
+	Token string `json:"token"`
+	lib.PolicyOverride
+}
+
+func IssuePolicyOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	var req PolicyOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+
+	user := r.Context().Value("adminUser").(models.AdminUsers)
+	token, override, err := lib.IssuePolicyOverride(req.Service, req.Rules, req.ApiKeyID, user.UserName, time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	auditAdminAction(r, "policy_override_issue", override)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PolicyOverrideResponse{Token: token, PolicyOverride: override})
+}
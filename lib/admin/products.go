@@ -0,0 +1,257 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// ProductResource is a product as the declarative admin API reads and writes it
+type ProductResource struct {
+	Id                 uuid.UUID     `json:"id"`
+	Name               string        `json:"name"`
+	WorkspaceID        uuid.UUID     `json:"workspace_id"`
+	Status             models.Status `json:"status"`
+	StrictContent      bool          `json:"strict_content"`
+	OpenAIOrganization string        `json:"openai_organization"`
+	OpenAIProject      string        `json:"openai_project"`
+	CreatedBy          string        `json:"created_by"`
+	CreatedAt          time.Time     `json:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"`
+	// Version counts the changes of the product, a write based on another version is rejected
+	Version int `json:"version,omitempty"`
+}
+
+func productResource(product models.Products) ProductResource {
+	return ProductResource{
+		Id:                 product.Base.Id,
+		Name:               product.Name,
+		WorkspaceID:        product.WorkspaceID,
+		Status:             product.Status,
+		StrictContent:      product.StrictContent,
+		OpenAIOrganization: product.OpenAIOrganization,
+		OpenAIProject:      product.OpenAIProject,
+		CreatedBy:          product.CreatedBy,
+		CreatedAt:          product.Base.CreatedAt,
+		UpdatedAt:          product.Base.UpdatedAt,
+		Version:            product.Version,
+	}
+}
+
+// validStatus reports whether status is one a product or API key can be put in
+func validStatus(status models.Status) bool {
+	switch status {
+	case models.Active, models.Inactive, models.Archived:
+		return true
+	}
+	return false
+}
+
+func ListProductsHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageQuery(w, r)
+	if !ok {
+		return
+	}
+	var products []models.Products
+	if err := pageOf(lib.DB().WithContext(r.Context()), page, &products); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := lib.Page{}
+	if len(products) > page.Limit {
+		products = products[:page.Limit]
+		result.NextCursor = lib.PageCursor(products[page.Limit-1].Base.Id.String())
+	}
+	data := make([]ProductResource, 0, len(products))
+	for _, product := range products {
+		data = append(data, productResource(product))
+	}
+	result.Data = data
+	json.NewEncoder(w).Encode(result)
+}
+
+func GetProductHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var product models.Products
+	if err := lib.DB().WithContext(r.Context()).Where("id = ?", productID).First(&product).Error; err != nil {
+		writeResourceError(w, err, "product")
+		return
+	}
+	resource := productResource(product)
+	writeResource(w, http.StatusOK, resource, lib.ResourceETag(resource))
+}
+
+// validateProduct checks a product that was put, an unset status is active
+func validateProduct(req *ProductResource) error {
+	if req.Status == "" {
+		req.Status = models.Active
+	}
+	switch {
+	case req.Name == "":
+		return fmt.Errorf("%w: name is required", errInvalidResource)
+	case req.WorkspaceID == uuid.Nil:
+		return fmt.Errorf("%w: workspace_id is required", errInvalidResource)
+	case !validStatus(req.Status):
+		return fmt.Errorf("%w: status must be active, inactive or archived", errInvalidResource)
+	}
+	return nil
+}
+
+// putProduct creates the product productID or replaces it with req, reporting
+// whether it was created. precondition checks the ETag of the current product,
+// empty if there is none. Putting the product it already is changes nothing.
+// A write of another version than the stored one fails with errStaleVersion.
+func putProduct(ctx context.Context, user models.AdminUsers, productID uuid.UUID, req ProductResource, precondition func(etag string) error) (models.Products, bool, error) {
+	var product models.Products
+	created := false
+	err := lib.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ?", productID).First(&product).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := precondition(""); err != nil {
+				return err
+			}
+			var deleted int64
+			if err := tx.Unscoped().Model(&models.Products{}).Where("id = ?", productID).Count(&deleted).Error; err != nil {
+				return err
+			}
+			if deleted > 0 {
+				return errDeletedResource
+			}
+			product = models.Products{
+				Base:               models.Base{Id: productID},
+				Name:               req.Name,
+				WorkspaceID:        req.WorkspaceID,
+				Status:             req.Status,
+				StrictContent:      req.StrictContent,
+				OpenAIOrganization: req.OpenAIOrganization,
+				OpenAIProject:      req.OpenAIProject,
+				CreatedBy:          user.UserName,
+				Version:            1,
+			}
+			created = true
+			return tx.Create(&product).Error
+		}
+		if err != nil {
+			return err
+		}
+		if err := precondition(lib.ResourceETag(productResource(product))); err != nil {
+			return err
+		}
+		if err := checkVersion("product", req.Version, product.Version); err != nil {
+			return err
+		}
+
+		changed := product
+		changed.Name, changed.WorkspaceID, changed.Status = req.Name, req.WorkspaceID, req.Status
+		changed.StrictContent, changed.OpenAIOrganization, changed.OpenAIProject = req.StrictContent, req.OpenAIOrganization, req.OpenAIProject
+		if productResource(changed) == productResource(product) {
+			return nil
+		}
+		changed.Base.UpdatedAt = time.Now().UTC()
+		changed.Version = product.Version + 1
+		// The version condition catches a write that happened since the product was read
+		result := tx.Model(&models.Products{}).Where("id = ? AND version = ?", productID, product.Version).Updates(map[string]interface{}{
+			"name":                changed.Name,
+			"workspace_id":        changed.WorkspaceID,
+			"status":              changed.Status,
+			"strict_content":      changed.StrictContent,
+			"openai_organization": changed.OpenAIOrganization,
+			"openai_project":      changed.OpenAIProject,
+			"updated_at":          changed.Base.UpdatedAt,
+			"version":             changed.Version,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return staleVersion("product", product.Version)
+		}
+		product = changed
+		return nil
+	})
+	return product, created, err
+}
+
+// deleteProduct deletes the product productID, if version isn't 0 only if it
+// is still at that version
+func deleteProduct(ctx context.Context, productID uuid.UUID, version int, precondition func(etag string) error) error {
+	return lib.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product models.Products
+		if err := tx.Where("id = ?", productID).First(&product).Error; err != nil {
+			return err
+		}
+		if err := precondition(lib.ResourceETag(productResource(product))); err != nil {
+			return err
+		}
+		if err := checkVersion("product", version, product.Version); err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND version = ?", productID, product.Version).Delete(&models.Products{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return staleVersion("product", product.Version)
+		}
+		return result.Error
+	})
+}
+
+// PutProductHandler creates the product with the ID of the path or replaces it
+func PutProductHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var req ProductResource
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if err := validateProduct(&req); err != nil {
+		writeResourceError(w, err, "product")
+		return
+	}
+
+	user, _ := r.Context().Value("adminUser").(models.AdminUsers)
+	product, created, err := putProduct(r.Context(), user, productID, req, requestPreconditions(r))
+	if err != nil {
+		writeResourceError(w, err, "product")
+		return
+	}
+	auditAdminAction(r, "product_put", map[string]string{"id": productID.String(), "name": product.Name})
+
+	resource := productResource(product)
+	writeResource(w, writeStatus(created), resource, lib.ResourceETag(resource))
+}
+
+// DeleteProductHandler deletes a product, with the version query parameter only
+// if it is still at that version
+func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	version, ok := requestedVersion(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteProduct(r.Context(), productID, version, requestPreconditions(r)); err != nil {
+		writeResourceError(w, err, "product")
+		return
+	}
+	auditAdminAction(r, "product_delete", map[string]string{"id": productID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// quotaList is the config file list of the capacity shares of the workspaces
+const quotaList = lib.QuotaConfigKey
+
+// QuotaRequest is the capacity share put for a workspace
+type QuotaRequest struct {
+	Share float64 `json:"share"`
+	Burst bool    `json:"burst"`
+	// Version is the version of the quota the write is based on, 0 to not check it
+	Version int `json:"version,omitempty"`
+}
+
+func ListQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	listConfigEntries(w, r, quotaList, "workspace")
+}
+
+func GetQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	getConfigEntry(w, quotaList, "workspace", chi.URLParam(r, "workspace"), "quota")
+}
+
+// PutQuotaHandler sets the share of the upstream capacity reserved for the
+// workspace of the path. The shares of all workspaces add up to at most 1.
+// A write based on an older version of the quota fails with 409.
+func PutQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, err := uuid.Parse(chi.URLParam(r, "workspace"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid workspace id")
+		return
+	}
+	var req QuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if req.Share <= 0 || req.Share > 1 {
+		writeError(w, http.StatusBadRequest, "share must be above 0 and at most 1")
+		return
+	}
+
+	entry := map[string]interface{}{"workspace": workspaceID.String(), "share": req.Share, "burst": req.Burst}
+	ok := putConfigEntry(w, r, quotaList, "workspace", workspaceID.String(), entry, req.Version, "quota", func(others []map[string]interface{}) error {
+		total := req.Share
+		for _, other := range others {
+			var capacity lib.WorkspaceCapacity
+			if err := lib.DecodeConfigEntry(other, &capacity); err == nil {
+				total += capacity.Share
+			}
+		}
+		if total > 1+1e-9 {
+			return fmt.Errorf("%w: the shares of the workspaces would add up to %.2f", errInvalidResource, total)
+		}
+		return nil
+	})
+	if ok {
+		auditAdminAction(r, "quota_put", map[string]string{"workspace": workspaceID.String(), "share": fmt.Sprint(req.Share)})
+	}
+}
+
+func DeleteQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	workspace := chi.URLParam(r, "workspace")
+	if deleteConfigEntry(w, r, quotaList, "workspace", workspace, "quota") {
+		auditAdminAction(r, "quota_delete", map[string]string{"workspace": workspace})
+	}
+}
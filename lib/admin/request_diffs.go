@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// RequestDiffHandler shows the payload a client sent, the payload sent upstream
+// and the transformations of the gateway for a request ID. Workspace admins only
+// see the requests of their workspace.
+func RequestDiffHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := chi.URLParam(r, "id")
+	diff, found, err := lib.GetRequestDiff(r.Context(), requestID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	workspaceID := adminWorkspace(r)
+	if !found || (workspaceID != uuid.Nil && diff.WorkspaceID != workspaceID) {
+		writeError(w, http.StatusNotFound, "no diff kept for request "+requestID)
+		return
+	}
+	auditAdminAction(r, "request_diff_view", map[string]string{"request_id": requestID})
+	json.NewEncoder(w).Encode(diff)
+}
@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"gorm.io/gorm"
+)
+
+var (
+	// errDeletedResource is returned for writes to the ID of a deleted resource, which isn't reused
+	errDeletedResource = errors.New("the id belongs to a deleted resource")
+	// errResourceNotFound is returned for entries of the config file that don't exist
+	errResourceNotFound = errors.New("resource not found")
+	// errInvalidResource wraps what is wrong with a resource that was put
+	errInvalidResource = errors.New("invalid resource")
+	// errStaleVersion is returned for writes based on an older version of a resource
+	errStaleVersion = errors.New("stale version")
+)
+
+// writeResource answers with a resource of the declarative admin API and its ETag
+func writeResource(w http.ResponseWriter, statusCode int, resource interface{}, etag string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resource)
+}
+
+// resourceErrorStatus returns the status code and message of a failed read or
+// write of a resource
+func resourceErrorStatus(err error, resource string) (int, string) {
+	switch {
+	case errors.Is(err, lib.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, fmt.Sprintf("%s has changed or its existence doesn't match the precondition", resource)
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, errResourceNotFound):
+		return http.StatusNotFound, fmt.Sprintf("%s not found", resource)
+	case errors.Is(err, errDeletedResource), errors.Is(err, errStaleVersion):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, errInvalidResource):
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+// writeResourceError answers a failed read or write of a resource
+func writeResourceError(w http.ResponseWriter, err error, resource string) {
+	statusCode, message := resourceErrorStatus(err, resource)
+	writeError(w, statusCode, message)
+}
+
+// requestPreconditions checks the ETag of a resource against the
+// If-Match and If-None-Match headers of r
+func requestPreconditions(r *http.Request) func(etag string) error {
+	return func(etag string) error {
+		return lib.CheckPreconditions(r, etag)
+	}
+}
+
+// staleVersion returns the error of a write based on another version than the
+// current one of a resource
+func staleVersion(resource string, current int) error {
+	return fmt.Errorf("%w: the %s was changed by someone else and is at version %d now", errStaleVersion, resource, current)
+}
+
+// checkVersion rejects a write based on another version than the current one.
+// Writes without a version, 0, aren't checked.
+func checkVersion(resource string, version int, current int) error {
+	if version != 0 && version != current {
+		return staleVersion(resource, current)
+	}
+	return nil
+}
+
+// requestedVersion reads the version query parameter of a delete, 0 without one
+func requestedVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("version")
+	if value == "" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		writeError(w, http.StatusBadRequest, "version must be a positive integer")
+		return 0, false
+	}
+	return version, true
+}
+
+// writeStatus answers a write with 201 if it created the resource and 200 otherwise
+func writeStatus(created bool) int {
+	if created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// pageQuery reads the page of a list request whose items have UUIDs
+func pageQuery(w http.ResponseWriter, r *http.Request) (lib.PageQuery, bool) {
+	page, err := lib.ParsePageQuery(r)
+	if err == nil && page.After != "" {
+		if _, parseErr := uuid.Parse(page.After); parseErr != nil {
+			err = errors.New("invalid cursor")
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return lib.PageQuery{}, false
+	}
+	return page, true
+}
+
+// pageOf queries a page of the table of dest ordered by id
+func pageOf(db *gorm.DB, page lib.PageQuery, dest interface{}) error {
+	query := db.Order("id").Limit(page.Limit + 1)
+	if page.After != "" {
+		query = query.Where("id > ?", page.After)
+	}
+	return query.Find(dest).Error
+}
+
+// configPage returns a page of entries of the config file, ordered by their idField
+func configPage(entries []map[string]interface{}, idField string, page lib.PageQuery) lib.Page {
+	data := []map[string]interface{}{}
+	for _, entry := range entries {
+		if page.After != "" && fmt.Sprint(entry[idField]) <= page.After {
+			continue
+		}
+		if len(data) == page.Limit {
+			return lib.Page{Data: data, NextCursor: lib.PageCursor(fmt.Sprint(data[len(data)-1][idField]))}
+		}
+		data = append(data, entry)
+	}
+	return lib.Page{Data: data}
+}
+
+// configEntryETag returns the ETag of an entry of the config file, empty for no entry
+func configEntryETag(entry map[string]interface{}) string {
+	if entry == nil {
+		return ""
+	}
+	return lib.ResourceETag(entry)
+}
+
+// getConfigEntry answers with the entry of a list of the config file whose idField is id
+func getConfigEntry(w http.ResponseWriter, key string, idField string, id string, resource string) {
+	entry, ok, err := lib.ConfigEntry(key, idField, id)
+	if err == nil && !ok {
+		err = errResourceNotFound
+	}
+	if err != nil {
+		writeResourceError(w, err, resource)
+		return
+	}
+	writeResource(w, http.StatusOK, entry, configEntryETag(entry))
+}
+
+// putConfigEntry creates or replaces the entry of a list of the config file
+// whose idField is id. The write fails with 409 unless version, if not 0, is
+// the version of the current entry, and bumps it. validate checks the entry
+// against the other entries of the list before it is written.
+func putConfigEntry(w http.ResponseWriter, r *http.Request, key string, idField string, id string, entry map[string]interface{}, version int, resource string, validate func(others []map[string]interface{}) error) bool {
+	created := false
+	stored, err := lib.UpdateConfigEntry(key, idField, id, entry, func(current map[string]interface{}, others []map[string]interface{}) error {
+		if err := lib.CheckPreconditions(r, configEntryETag(current)); err != nil {
+			return err
+		}
+		currentVersion := lib.ConfigEntryVersion(current)
+		if err := checkVersion(resource, version, currentVersion); err != nil {
+			return err
+		}
+		entry["version"] = currentVersion + 1
+		created = current == nil
+		return validate(others)
+	})
+	if err != nil {
+		writeResourceError(w, err, resource)
+		return false
+	}
+	writeResource(w, writeStatus(created), stored, configEntryETag(stored))
+	return true
+}
+
+// deleteConfigEntry removes the entry of a list of the config file whose
+// idField is id, with the version query parameter only if it is at that version
+func deleteConfigEntry(w http.ResponseWriter, r *http.Request, key string, idField string, id string, resource string) bool {
+	version, ok := requestedVersion(w, r)
+	if !ok {
+		return false
+	}
+	_, err := lib.UpdateConfigEntry(key, idField, id, nil, func(current map[string]interface{}, others []map[string]interface{}) error {
+		if current == nil {
+			return errResourceNotFound
+		}
+		if err := lib.CheckPreconditions(r, configEntryETag(current)); err != nil {
+			return err
+		}
+		return checkVersion(resource, version, lib.ConfigEntryVersion(current))
+	})
+	if err != nil {
+		writeResourceError(w, err, resource)
+		return false
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// listConfigEntries answers with a page of the entries of a list of the config file
+func listConfigEntries(w http.ResponseWriter, r *http.Request, key string, idField string) {
+	page, err := lib.ParsePageQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := lib.ConfigEntries(key, idField)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(configPage(entries, idField, page))
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+type RuleTestRequest struct {
+	Text string `json:"text"`
+	// Direction is "input" to test the text as a prompt or "output" as a completion
+	Direction string `json:"direction"`
+	// Rule limits the test to the rule with this name
+	Rule      string    `json:"rule"`
+	ProductID uuid.UUID `json:"product_id"`
+}
+
+type RuleTestResult struct {
+	Blocked     bool   `json:"blocked"`
+	Intercepted bool   `json:"intercepted"`
+	Message     string `json:"message"`
+	// Content is the text after the rules changed it, for example masked profanity
+	Content string `json:"content"`
+}
+
+// TestRulesHandler runs the configured rules against a text without calling a
+// provider. Rules that flag for review only log the match.
+func TestRulesHandler(w http.ResponseWriter, r *http.Request) {
+	var req RuleTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	if req.Direction == "" {
+		req.Direction = "input"
+	}
+
+	config := lib.GetConfig()
+	config.Rules.Input = testedRules(config.Rules.Input, req.Rule)
+	config.Rules.Output = testedRules(config.Rules.Output, req.Rule)
+	ctx := context.WithValue(r.Context(), "config", config)
+	ctx = context.WithValue(ctx, "productId", req.ProductID)
+	r = r.WithContext(ctx)
+
+	var result RuleTestResult
+	var err error
+	switch req.Direction {
+	case "input":
+		prompt := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: req.Text}}}
+		result.Blocked, result.Message, err = rules.Input(r, prompt)
+		result.Content = prompt.Messages[0].Content
+	case "output":
+		resp := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: req.Text},
+		}}}
+		result.Blocked, result.Message, err = rules.Output(r, &resp)
+		result.Content = resp.Choices[0].Message.Content
+	default:
+		writeError(w, http.StatusBadRequest, "direction must be input or output")
+		return
+	}
+
+	var interception *rules.Interception
+	if errors.As(err, &interception) {
+		result.Intercepted = true
+	} else if err != nil && !result.Blocked {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// testedRules returns a copy of rules, limited to the rule named name if set,
+// with flagging for review turned into monitoring
+func testedRules(configured []lib.Rule, name string) []lib.Rule {
+	var tested []lib.Rule
+	for _, rule := range configured {
+		if name != "" && rule.Name != name {
+			continue
+		}
+		if rule.Action.Type == "flag" {
+			rule.Action.Type = "monitor"
+		}
+		tested = append(tested, rule)
+	}
+	return tested
+}
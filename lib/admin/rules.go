@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+)
+
+// ruleList returns the config file list of the rules of the direction of the
+// path, false once it answered the request
+func ruleList(w http.ResponseWriter, r *http.Request) (string, bool) {
+	direction := chi.URLParam(r, "direction")
+	if direction != "input" && direction != "output" {
+		writeError(w, http.StatusBadRequest, "direction must be input or output")
+		return "", false
+	}
+	return "rules." + direction, true
+}
+
+func ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if key, ok := ruleList(w, r); ok {
+		listConfigEntries(w, r, key, "name")
+	}
+}
+
+func GetRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if key, ok := ruleList(w, r); ok {
+		getConfigEntry(w, key, "name", chi.URLParam(r, "name"), "rule")
+	}
+}
+
+// PutRuleHandler creates or replaces the rule with the name of the path. The
+// body is the rule as the config file holds it, its name may be left out. Its
+// version, if set, must be the version of the current rule.
+func PutRuleHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := ruleList(w, r)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	var entry map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil || entry == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if bodyName, ok := entry["name"]; ok && bodyName != name {
+		writeError(w, http.StatusBadRequest, "name must match the name of the path")
+		return
+	}
+	entry["name"] = name
+	version := 0
+	if _, ok := entry["version"]; ok {
+		version = lib.ConfigEntryVersion(entry)
+	}
+
+	var rule lib.Rule
+	if err := lib.DecodeConfigEntry(entry, &rule); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid rule: %v", err))
+		return
+	}
+	direction := chi.URLParam(r, "direction")
+	if !rules.ValidType(direction, rule.Type) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a type of %s rules", rule.Type, direction))
+		return
+	}
+
+	if putConfigEntry(w, r, key, "name", name, entry, version, "rule", func(others []map[string]interface{}) error { return nil }) {
+		auditAdminAction(r, "rule_put", map[string]string{"direction": direction, "name": name})
+	}
+}
+
+func DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := ruleList(w, r)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if deleteConfigEntry(w, r, key, "name", name, "rule") {
+		auditAdminAction(r, "rule_delete", map[string]string{"direction": chi.URLParam(r, "direction"), "name": name})
+	}
+}
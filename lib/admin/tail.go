@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// maxTailDuration keeps tails under the request timeout of the router,
+// EventSource clients reconnect when the stream ends
+const maxTailDuration = 55 * time.Second
+
+// TailHandler streams summaries of live requests as server-sent events until
+// the timeout, in seconds, passes. Events can be filtered by api_key, workspace,
+// model, verdict and min_latency_ms. Workspace admins only get the requests of
+// their workspace.
+func TailHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := lib.TailFilter{
+		APIKeyID:    query.Get("api_key"),
+		WorkspaceID: query.Get("workspace"),
+		Model:       query.Get("model"),
+		Verdict:     query.Get("verdict"),
+	}
+	if workspaceID := adminWorkspace(r); workspaceID != uuid.Nil {
+		filter.WorkspaceID = workspaceID.String()
+	}
+	if minLatency := query.Get("min_latency_ms"); minLatency != "" {
+		ms, err := strconv.Atoi(minLatency)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid min_latency_ms")
+			return
+		}
+		filter.MinLatency = time.Duration(ms) * time.Millisecond
+	}
+	duration := 30 * time.Second
+	if timeout := query.Get("timeout"); timeout != "" {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil || seconds <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout")
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+	if duration > maxTailDuration {
+		duration = maxTailDuration
+	}
+
+	subscription := lib.SubscribeTail(filter)
+	defer lib.UnsubscribeTail(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-subscription.Events:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: request\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-timer.C:
+			fmt.Fprint(w, "event: timeout\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
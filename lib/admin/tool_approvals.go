@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+type ToolDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func ListToolApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	approvals, err := lib.PendingToolApprovals(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if approvals == nil {
+		approvals = []lib.ToolApproval{}
+	}
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func ApproveToolCallHandler(w http.ResponseWriter, r *http.Request) {
+	decideToolCall(w, r, true)
+}
+
+func RejectToolCallHandler(w http.ResponseWriter, r *http.Request) {
+	decideToolCall(w, r, false)
+}
+
+func decideToolCall(w http.ResponseWriter, r *http.Request, approved bool) {
+	var req ToolDecisionRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	user := r.Context().Value("adminUser").(models.AdminUsers)
+	approval, err := lib.DecideToolApproval(r.Context(), chi.URLParam(r, "id"), approved, user.UserName, req.Reason)
+	if errors.Is(err, lib.ErrToolApprovalNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	auditAdminAction(r, "tool_call_"+approval.Status, map[string]string{"id": approval.ID, "tool": approval.Tool, "reason": req.Reason})
+	json.NewEncoder(w).Encode(approval)
+}
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// ListTracesHandler returns the kept traces. Workspace admins only get the
+// traces of their workspace, without the sampling counts of the gateway.
+func ListTracesHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID := adminWorkspace(r)
+	stats, traces := lib.ListTraces(workspaceID)
+	response := map[string]interface{}{
+		"enabled": lib.GetTracingSettings().Enabled,
+		"traces":  traces,
+	}
+	if workspaceID == uuid.Nil {
+		response["stats"] = stats
+	}
+	json.NewEncoder(w).Encode(response)
+}
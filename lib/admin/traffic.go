@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+)
+
+// adminWorkspace returns the workspace an admin user is limited to, uuid.Nil
+// for admins of the whole gateway
+func adminWorkspace(r *http.Request) uuid.UUID {
+	user, ok := r.Context().Value("adminUser").(models.AdminUsers)
+	if !ok || user.WorkspaceID == nil {
+		return uuid.Nil
+	}
+	return *user.WorkspaceID
+}
+
+// RequireGatewayScope rejects admin users limited to a workspace, for the
+// endpoints that show or change the whole gateway
+func RequireGatewayScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminWorkspace(r) != uuid.Nil {
+			writeError(w, http.StatusForbidden, "not available to workspace admins")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TrafficHandler returns the traffic of the workspaces, only their own for
+// workspace admins
+func TrafficHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(lib.ListTenantTraffic(adminWorkspace(r)))
+}
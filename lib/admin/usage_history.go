@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// UsageHistoryHandler answers the daily usage totals between the from and to
+// days, inclusive, including days whose rows were moved to cold storage
+func UsageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := time.Parse(time.DateOnly, r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "from must be a day as YYYY-MM-DD")
+		return
+	}
+	to := time.Now().UTC()
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be a day as YYYY-MM-DD")
+			return
+		}
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, "to is before from")
+		return
+	}
+
+	history, err := lib.UsageHistory(r.Context(), from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from": from.Format(time.DateOnly),
+		"to":   to.Format(time.DateOnly),
+		"days": history,
+	})
+}
@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+func WatchdogHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": lib.GetWatchdogSettings().Enabled,
+		"status":  lib.GetWatchdogStatus(),
+	})
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/openshieldai/openshield/models"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+func init() {
+	RegisterJob(Job{
+		Name:     "purge_admin_sessions",
+		Interval: time.Hour,
+		Run:      purgeExpiredSessions,
+	})
+}
+
+func purgeExpiredSessions(ctx context.Context) error {
+	result := DB().WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.AdminSessions{})
+	if result.Error != nil {
+		return result.Error
+	}
+	log.Printf("Purged %d expired admin sessions", result.RowsAffected)
+	return nil
+}
+
+// GetAdminSettings returns the admin login settings with defaults applied
+func GetAdminSettings() AdminSettings {
+	settings := AdminSettings{}
+	if admin := GetConfig().Settings.Admin; admin != nil {
+		settings = *admin
+	}
+	if settings.SessionTTL <= 0 {
+		settings.SessionTTL = 3600
+	}
+	if settings.MaxFailedLogins <= 0 {
+		settings.MaxFailedLogins = 5
+	}
+	if settings.LockoutDuration <= 0 {
+		settings.LockoutDuration = 900
+	}
+	return settings
+}
+
+// HashPassword returns the encoded argon2id hash of password
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// VerifyPassword reports whether password matches an encoded argon2id hash
+func VerifyPassword(password string, encodedHash string) bool {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory uint32
+	var iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, computed) == 1
+}
+
+// NewTOTPSecret returns a random base32 encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// TOTPCode returns the RFC 6238 code for secret at time t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/totpPeriod))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// ValidateTOTP checks code against secret, allowing one period of clock skew
+func ValidateTOTP(secret string, code string, t time.Time) bool {
+	for _, skew := range []int{0, -1, 1} {
+		expected, err := TOTPCode(secret, t.Add(time.Duration(skew*totpPeriod)*time.Second))
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// TOTPProvisioningURL returns the otpauth:// URL used to enroll an authenticator app
+func TOTPProvisioningURL(userName string, secret string) string {
+	return fmt.Sprintf("otpauth://totp/OpenShield:%s?secret=%s&issuer=OpenShield&digits=%d&period=%d", userName, secret, totpDigits, totpPeriod)
+}
+
+// NewSessionToken returns a random session token and the hash stored for it
+func NewSessionToken() (string, string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", "", err
+	}
+	encoded := hex.EncodeToString(token)
+	return encoded, HashSessionToken(encoded), nil
+}
+
+// HashSessionToken returns the hash under which a session token is stored
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
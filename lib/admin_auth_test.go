@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	assert.NoError(t, err)
+
+	assert.True(t, VerifyPassword("correct horse battery staple", hash))
+	assert.False(t, VerifyPassword("wrong password", hash))
+	assert.False(t, VerifyPassword("correct horse battery staple", "not-a-hash"))
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for the SHA-1 secret, truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tc := range testCases {
+		code, err := TOTPCode(secret, time.Unix(tc.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, code)
+	}
+
+	assert.True(t, ValidateTOTP(secret, "287082", time.Unix(59+30, 0)))
+	assert.False(t, ValidateTOTP(secret, "287082", time.Unix(59+90, 0)))
+}
@@ -0,0 +1,95 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+const (
+	defaultBaseURL = "https://api.anthropic.com/v1"
+	defaultVersion = "2023-06-01"
+	providerName   = "anthropic"
+)
+
+// OSInterceptedHeader names the rule that answered the request instead of the provider
+const OSInterceptedHeader = "OS-Intercepted"
+
+// sendRequest sends a request to the Anthropic API with the key of the gateway.
+// The anthropic-version and anthropic-beta headers of the client are passed on.
+func sendRequest(ctx context.Context, r *http.Request, config lib.Configuration, method string, path string, model string, body []byte) (*http.Response, error) {
+	httpClient, err := lib.ModelHTTPClient(config.Providers.Anthropic, model)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := defaultBaseURL
+	if config.Providers.Anthropic != nil && config.Providers.Anthropic.BaseURL != "" {
+		baseURL = config.Providers.Anthropic.BaseURL
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(baseURL, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", config.Secrets.AnthropicAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Anthropic-Version", defaultVersion)
+	if version := r.Header.Get("Anthropic-Version"); version != "" {
+		req.Header.Set("Anthropic-Version", version)
+	}
+	if beta := r.Header.Get("Anthropic-Beta"); beta != "" {
+		req.Header.Set("Anthropic-Beta", beta)
+	}
+	return httpClient.Do(req)
+}
+
+// writeError writes an error in the format of the Anthropic API, which its SDKs
+// raise, or as an error envelope if the client asked for one
+func writeError(w http.ResponseWriter, r *http.Request, statusCode int, errorType string, message string) {
+	log.Printf("Error: %v", message)
+	if lib.WantsEnvelope(r) {
+		lib.WriteEnvelopeError(w, providerName, statusCode, errorType, message)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+}
+
+// errorType returns the Anthropic error type of a status the gateway refuses a request with
+func errorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound, http.StatusGone:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	}
+	return "invalid_request_error"
+}
+
+// relayResponse writes a response of the provider as it came, errors included
+func relayResponse(w http.ResponseWriter, resp *http.Response) {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
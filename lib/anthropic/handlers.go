@@ -0,0 +1,349 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// MessagesHandler checks a Messages API request as the chat completion it
+// amounts to, with the policies and rules of the OpenAI routes, and sends it to
+// Anthropic with the texts the input rules redacted. The texts of responses go
+// through the output rules, for streams in windows of the streamed text.
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("error reading request body: %v", err))
+		return
+	}
+	var req messagesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if req.Model == "" || len(req.Messages) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_request_error", "model and messages are required")
+		return
+	}
+
+	chatReq := chatRequest(req)
+	r, body, ok := checkRequest(w, r, body, req, chatReq)
+	if !ok {
+		return
+	}
+
+	config := lib.GetRequestConfig(r)
+	ctx, hashes := lib.WithContentHashes(r.Context())
+	start := time.Now()
+	resp, err := sendRequest(ctx, r, config, http.MethodPost, "/messages", req.Model, body)
+	if err != nil {
+		writeError(w, r, lib.DeadlineStatus(r, http.StatusBadGateway), "api_error", fmt.Sprintf("failed to create message: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayResponse(w, resp)
+		return
+	}
+
+	if req.Stream {
+		usage := newStreamUsage(r.Context(), chatReq)
+		usage.hashes = hashes
+		relayStream(w, r, resp, usage, start)
+		return
+	}
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", fmt.Sprintf("error reading response: %v", err))
+		return
+	}
+	lib.RecordLatency(req.Model, 0, time.Since(start))
+	var message messagesResponse
+	if err := json.Unmarshal(response, &message); err != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", fmt.Sprintf("error decoding response: %v", err))
+		return
+	}
+	response, ok = checkOutput(w, r, response, &message)
+	if !ok {
+		return
+	}
+
+	if lib.AuditLoggingActive(r) {
+		apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+		lib.AuditLogs(string(response), "anthropic_messages", apiKeyId, "output", r)
+	}
+	lib.ProviderUsage(r.Context(), models.Anthropic, hashes, message.Model, 0, message.Usage.InputTokens, message.Usage.OutputTokens,
+		message.Usage.InputTokens+message.Usage.OutputTokens, message.StopReason, "anthropic_messages")
+	if lib.WantsEnvelope(r) {
+		envelope := toEnvelope(message)
+		envelope.Warnings = lib.ModelWarnings(r)
+		lib.WriteEnvelope(w, envelope)
+		return
+	}
+	w.Write(lib.AddModelWarnings(r, response))
+}
+
+// checkRequest applies the request policies, checks and input rules of the
+// OpenAI routes to chatReq, the chat completion the Messages API request req
+// amounts to. It returns the body to send to Anthropic, with the texts the
+// rules redacted, and reports false once it answered the request.
+func checkRequest(w http.ResponseWriter, r *http.Request, body []byte, req messagesRequest, chatReq openai.ChatCompletionRequest) (*http.Request, []byte, bool) {
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return r, nil, false
+	}
+	r, status, err := lib.ApplyRequestPolicies(w, r, req.Model)
+	if err != nil {
+		writeError(w, r, status, errorType(status), err.Error())
+		return r, nil, false
+	}
+	if err := lib.CheckStrictContent(r, body, req.Model, req.Temperature); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return r, nil, false
+	}
+	if err := checkMessageURLs(req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return r, nil, false
+	}
+
+	// The rules redact the messages in place, the converted ones are kept to compare
+	original := chatReq.Messages
+	chatReq.Messages = slices.Clone(chatReq.Messages)
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	if filtered, errorMessage, err := rules.Input(r, chatReq); filtered {
+		var interception *rules.Interception
+		if errors.As(err, &interception) {
+			lib.AuditLogs(string(body), interception.Rule, apiKeyId, lib.RestrictedMessageType, r)
+			writeInterception(w, chatReq, interception)
+			return r, nil, false
+		}
+		lib.AuditLogs(string(body), "anthropic_messages", apiKeyId, "input", r)
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		writeBlocked(w, r, errorMessage, err)
+		return r, nil, false
+	}
+	if !slices.EqualFunc(original, chatReq.Messages, func(a, b openai.ChatCompletionMessage) bool { return a.Content == b.Content }) {
+		if body, err = redactedBody(body, req, chatReq.Messages); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "api_error", fmt.Sprintf("error encoding request body: %v", err))
+			return r, nil, false
+		}
+	}
+	lib.AuditLogs(string(body), "anthropic_messages", apiKeyId, "input", r)
+	lib.SaveRequestDiff(r, body)
+
+	if err := lib.ReserveCapacity(r, lib.CountPromptTokens(chatReq)+req.MaxTokens); err != nil {
+		writeError(w, r, http.StatusTooManyRequests, "rate_limit_error", err.Error())
+		return r, nil, false
+	}
+	return r, body, true
+}
+
+// writeBlocked answers a request the rules blocked, with the 403 of a rule
+// violation or the 400 of other blocks
+func writeBlocked(w http.ResponseWriter, r *http.Request, message string, err error) {
+	status, errorType, code := http.StatusBadRequest, "invalid_request_error", lib.EnvelopeContentBlocked
+	var violation *rules.Violation
+	if errors.As(err, &violation) {
+		status, errorType, code = http.StatusForbidden, "permission_error", lib.EnvelopeRuleViolation
+	}
+	if lib.WantsEnvelope(r) {
+		log.Printf("Error: %v", message)
+		lib.WriteEnvelopeError(w, providerName, status, code, message)
+		return
+	}
+	writeError(w, r, status, errorType, message)
+}
+
+// checkOutput applies the response limits and output rules to the text blocks
+// of a message. The response is only encoded again when the gateway changed a
+// text. It reports false once it answered the request.
+func checkOutput(w http.ResponseWriter, r *http.Request, response []byte, message *messagesResponse) ([]byte, bool) {
+	resp := openai.ChatCompletionResponse{Model: message.Model}
+	var blocks []int
+	for i, block := range message.Content {
+		if block.Type != "text" {
+			continue
+		}
+		content, reached, err := lib.NewResponseLimiter(message.Model).Limit(block.Text)
+		if err != nil {
+			writeError(w, r, http.StatusBadGateway, "api_error", err.Error())
+			return nil, false
+		}
+		if reached {
+			message.StopReason = "max_tokens"
+		}
+		blocks = append(blocks, i)
+		resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{
+			Index:   len(resp.Choices),
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+		})
+	}
+	if blocked, errorMessage, _ := rules.Output(r, &resp); blocked {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		writeBlocked(w, r, errorMessage, nil)
+		return nil, false
+	}
+
+	changed := false
+	for i, choice := range resp.Choices {
+		if block := &message.Content[blocks[i]]; block.Text != choice.Message.Content {
+			block.Text = choice.Message.Content
+			changed = true
+		}
+	}
+	if !changed {
+		return response, true
+	}
+
+	// Other fields of the message and its blocks are kept as they came
+	var fields map[string]json.RawMessage
+	var content []map[string]interface{}
+	if json.Unmarshal(response, &fields) != nil || json.Unmarshal(fields["content"], &content) != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", "error decoding response content")
+		return nil, false
+	}
+	for _, i := range blocks {
+		content[i]["text"] = message.Content[i].Text
+	}
+	fields["content"], _ = json.Marshal(content)
+	fields["stop_reason"], _ = json.Marshal(message.StopReason)
+	response, _ = json.Marshal(fields)
+	return response, true
+}
+
+// writeInterception answers the request with the content of the intercepting
+// rule, shaped like a message of the requested model
+func writeInterception(w http.ResponseWriter, req openai.ChatCompletionRequest, interception *rules.Interception) {
+	w.Header().Set(OSInterceptedHeader, interception.Rule)
+	message := map[string]interface{}{
+		"id":            "msg_os_" + uuid.NewString(),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         req.Model,
+		"content":       []contentBlock{{Type: "text", Text: interception.Content}},
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"usage":         usage{},
+	}
+
+	if !req.Stream {
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	message["content"], message["stop_reason"] = []contentBlock{}, nil
+	for _, event := range []struct {
+		name string
+		data interface{}
+	}{
+		{"message_start", map[string]interface{}{"type": "message_start", "message": message}},
+		{"content_block_start", map[string]interface{}{"type": "content_block_start", "index": 0, "content_block": contentBlock{Type: "text"}}},
+		{"content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": 0, "delta": map[string]string{"type": "text_delta", "text": interception.Content}}},
+		{"content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0}},
+		{"message_delta", map[string]interface{}{"type": "message_delta", "delta": map[string]string{"stop_reason": "end_turn"}, "usage": usage{}}},
+		{"message_stop", map[string]interface{}{"type": "message_stop"}},
+	} {
+		data, _ := json.Marshal(event.data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+	}
+}
+
+// toEnvelope converts a message to the gateway-native envelope
+func toEnvelope(message messagesResponse) lib.Envelope {
+	var content string
+	for _, block := range message.Content {
+		content += block.Text
+	}
+	return lib.Envelope{
+		ID:       message.ID,
+		Provider: providerName,
+		Model:    message.Model,
+		Created:  time.Now().Unix(),
+		Choices: []lib.EnvelopeChoice{{
+			Content:              content,
+			FinishReason:         lib.NormalizeFinishReason(providerName, message.StopReason),
+			ProviderFinishReason: message.StopReason,
+		}},
+		Usage: lib.EnvelopeUsage{
+			InputTokens:  message.Usage.InputTokens,
+			OutputTokens: message.Usage.OutputTokens,
+			TotalTokens:  message.Usage.InputTokens + message.Usage.OutputTokens,
+		},
+	}
+}
+
+// ListModelsHandler lists the Anthropic models the API key may use
+func ListModelsHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+	path := "/models"
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	resp, err := sendRequest(r.Context(), r, config, http.MethodGet, path, "", nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", fmt.Sprintf("failed to list models: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayResponse(w, resp)
+		return
+	}
+
+	var list map[string]json.RawMessage
+	var data []struct {
+		ID string `json:"id"`
+	}
+	var raw []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", fmt.Sprintf("error decoding models: %v", err))
+		return
+	}
+	json.Unmarshal(list["data"], &data)
+	json.Unmarshal(list["data"], &raw)
+	allowed := []json.RawMessage{}
+	for i, model := range data {
+		if lib.ModelAllowed(r, model.ID) {
+			allowed = append(allowed, raw[i])
+		}
+	}
+	list["data"], _ = json.Marshal(allowed)
+	json.NewEncoder(w).Encode(list)
+}
+
+func GetModelHandler(w http.ResponseWriter, r *http.Request) {
+	model := chi.URLParam(r, "model")
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+	if !lib.ModelAllowed(r, model) {
+		writeError(w, r, http.StatusNotFound, "not_found_error", lib.ErrModelNotApproved.Error())
+		return
+	}
+
+	resp, err := sendRequest(r.Context(), r, lib.GetRequestConfig(r), http.MethodGet, "/models/"+url.PathEscape(model), model, nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "api_error", fmt.Sprintf("failed to get model: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	relayResponse(w, resp)
+}
@@ -0,0 +1,177 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withPIIRules sets the PII scanner as the input and output rules, redacting
+func withPIIRules(t *testing.T) {
+	t.Helper()
+	configRules, settings := lib.AppConfig.Rules, lib.AppConfig.Settings
+	t.Cleanup(func() { lib.AppConfig.Rules, lib.AppConfig.Settings = configRules, settings })
+	// Executions and usage would be written to the database
+	lib.AppConfig.Settings.RuleExecutions = &lib.RuleExecutions{Enabled: false}
+	lib.AppConfig.Settings.UsageLogging = &lib.FeatureToggle{Enabled: false}
+	lib.AppConfig.Settings.Redis = nil
+	rule := lib.Rule{Enabled: true, Name: "pii", Type: "pii_scanner", Action: lib.Action{Type: "redact"}}
+	lib.AppConfig.Rules.Input = []lib.Rule{rule}
+	lib.AppConfig.Rules.Output = []lib.Rule{rule}
+}
+
+func messagesRequestFor(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", strings.NewReader(body))
+	return req.WithContext(context.WithValue(req.Context(), "apiKeyId", uuid.New()))
+}
+
+func TestRedactedBody(t *testing.T) {
+	withPIIRules(t)
+	body := []byte(`{"model":"claude-3-5-sonnet","max_tokens":64,"metadata":{"user_id":"u1"},
+		"system":[{"type":"text","text":"Be brief"}],
+		"messages":[
+			{"role":"user","content":"My email is john@example.com"},
+			{"role":"assistant","content":"Noted"},
+			{"role":"user","content":[{"type":"text","text":"Call 555-123-4567"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}},{"type":"text","text":"please"}]}
+		]}`)
+	var req messagesRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+
+	// The body is sent to Anthropic as checkRequest encodes it after the input rules
+	chatReq := chatRequest(req)
+	blocked, _, err := rules.Input(messagesRequestFor(string(body)), chatReq)
+	require.NoError(t, err)
+	require.False(t, blocked)
+	redacted, err := redactedBody(body, req, chatReq.Messages)
+	require.NoError(t, err)
+
+	var sent map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(redacted, &sent))
+	assert.JSONEq(t, `[{"type":"text","text":"Be brief"}]`, string(sent["system"]))
+	assert.JSONEq(t, `{"user_id":"u1"}`, string(sent["metadata"]))
+	assert.JSONEq(t, `[
+		{"role":"user","content":"My email is <EMAIL_ADDRESS>"},
+		{"role":"assistant","content":"Noted"},
+		{"role":"user","content":[{"type":"text","text":"Call <PHONE_NUMBER>\nplease"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]}
+	]`, string(sent["messages"]))
+}
+
+func TestCheckMessageURLs(t *testing.T) {
+	var req messagesRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"claude-3-5-sonnet","messages":[
+		{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}},{"type":"text","text":"Describe it"}]}
+	]}`), &req))
+	assert.NoError(t, checkMessageURLs(req))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"claude-3-5-sonnet","messages":[
+		{"role":"user","content":[{"type":"document","source":{"type":"url","url":"file:///etc/passwd"}},{"type":"text","text":"Summarize it"}]}
+	]}`), &req))
+	assert.ErrorContains(t, checkMessageURLs(req), "document url rejected")
+}
+
+func TestRelayStreamOutputRules(t *testing.T) {
+	withPIIRules(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"model":"claude-3-5-sonnet","usage":{"input_tokens":10}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Mail jane.doe@"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"example.com today"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`,
+			`{"type":"message_stop"}`,
+		} {
+			var typed struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &typed)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typed.Type, event)
+		}
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	r := messagesRequestFor(`{}`)
+	w := httptest.NewRecorder()
+	relayStream(w, r, resp, newStreamUsage(r.Context(), chatRequest(messagesRequest{Model: "claude-3-5-sonnet"})), time.Now())
+
+	var text strings.Builder
+	var types []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event streamEvent
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		types = append(types, event.Type)
+		text.WriteString(event.Delta.Text)
+	}
+	// The address split between deltas is checked once its block ended
+	assert.Equal(t, "Mail <EMAIL_ADDRESS> today", text.String())
+	assert.NotContains(t, w.Body.String(), "jane.doe")
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, types)
+
+	// Without output rules the events are relayed as they came
+	lib.AppConfig.Rules.Output = nil
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	w = httptest.NewRecorder()
+	relayStream(w, r, resp, newStreamUsage(r.Context(), chatRequest(messagesRequest{Model: "claude-3-5-sonnet"})), time.Now())
+	direct, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer direct.Body.Close()
+	expected, _ := io.ReadAll(direct.Body)
+	assert.Equal(t, string(expected), w.Body.String())
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	r := messagesRequestFor(`{}`)
+	r.Header.Set("Accept", lib.EnvelopeMediaType)
+
+	w := httptest.NewRecorder()
+	writeBlocked(w, r, "request blocked due to rule match", &rules.Violation{Rule: "injection", Message: "prompt injection detected"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var envelope lib.EnvelopeError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, lib.EnvelopeRuleViolation, envelope.Error.Code)
+	assert.Equal(t, providerName, envelope.Error.Provider)
+
+	w = httptest.NewRecorder()
+	writeError(w, r, http.StatusBadGateway, "api_error", "failed to create message")
+	envelope = lib.EnvelopeError{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "api_error", envelope.Error.Code)
+	assert.Equal(t, http.StatusBadGateway, envelope.Error.Status)
+
+	w = httptest.NewRecorder()
+	writeStreamError(w, r, http.StatusBadGateway, "api_error", "stream cut short")
+	data, ok := strings.CutPrefix(w.Body.String(), "event: error\ndata: ")
+	require.True(t, ok)
+	envelope = lib.EnvelopeError{}
+	require.NoError(t, json.Unmarshal([]byte(data), &envelope))
+	assert.Equal(t, "stream cut short", envelope.Error.Message)
+
+	// Clients of the Messages API keep its error format
+	r.Header.Del("Accept")
+	w = httptest.NewRecorder()
+	writeBlocked(w, r, "request blocked due to rule match", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"type": "error", "error": {"type": "invalid_request_error", "message": "request blocked due to rule match"}}`, w.Body.String())
+}
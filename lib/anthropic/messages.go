@@ -0,0 +1,181 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// messagesRequest holds the fields of a Messages API request the gateway checks.
+// System and the message contents are a string or a list of content blocks.
+type messagesRequest struct {
+	Model       string          `json:"model"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float32         `json:"temperature,omitempty"`
+	System      json.RawMessage `json:"system,omitempty"`
+	Messages    []message       `json:"messages"`
+	Stream      bool            `json:"stream,omitempty"`
+}
+
+type message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// contentBlock is a block of a message, only text blocks are checked by the
+// rules. Images and documents with a URL source are checked against the URL policy.
+type contentBlock struct {
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *blockSource `json:"source,omitempty"`
+}
+
+type blockSource struct {
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+}
+
+// messagesResponse holds the fields of a Messages API response the gateway reads
+type messagesResponse struct {
+	ID         string         `json:"id"`
+	Model      string         `json:"model"`
+	Content    []contentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      usage          `json:"usage"`
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// text returns the text of a string content or of the text blocks of a block list
+func text(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var blocks []contentBlock
+	json.Unmarshal(content, &blocks)
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatRequest returns the chat completion a Messages API request amounts to, so
+// it goes through the same rules as the OpenAI routes
+func chatRequest(req messagesRequest) openai.ChatCompletionRequest {
+	chatReq := openai.ChatCompletionRequest{Model: req.Model, MaxTokens: req.MaxTokens, Stream: req.Stream}
+	if len(req.System) > 0 {
+		chatReq.Messages = append(chatReq.Messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: text(req.System)})
+	}
+	for _, m := range req.Messages {
+		chatReq.Messages = append(chatReq.Messages, openai.ChatCompletionMessage{Role: m.Role, Content: text(m.Content)})
+	}
+	return chatReq
+}
+
+// checkMessageURLs checks the URLs of the image and document blocks of the
+// messages against the URL policy, the provider fetches them
+func checkMessageURLs(req messagesRequest) error {
+	for _, m := range req.Messages {
+		var blocks []contentBlock
+		if json.Unmarshal(m.Content, &blocks) != nil {
+			continue
+		}
+		for _, block := range blocks {
+			if block.Source == nil || block.Source.Type != "url" {
+				continue
+			}
+			if err := lib.CheckURL(block.Source.URL); err != nil {
+				return fmt.Errorf("%s url rejected: %v", block.Type, err)
+			}
+		}
+	}
+	return nil
+}
+
+// redactedBody returns body with the system prompt and the messages the input
+// rules redacted replaced, in the order chatRequest converted them. The text
+// blocks of a redacted message are replaced by one block with its redacted
+// text, its other blocks and every other field are kept as they came.
+func redactedBody(body []byte, req messagesRequest, redacted []openai.ChatCompletionMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	original := chatRequest(req).Messages
+
+	i := 0
+	if len(req.System) > 0 {
+		if redacted[i].Content != original[i].Content {
+			system, err := redactedContent(req.System, redacted[i].Content)
+			if err != nil {
+				return nil, err
+			}
+			fields["system"] = system
+		}
+		i++
+	}
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["messages"], &messages); err != nil {
+		return nil, err
+	}
+	for j := range messages {
+		if redacted[i].Content != original[i].Content {
+			content, err := redactedContent(req.Messages[j].Content, redacted[i].Content)
+			if err != nil {
+				return nil, err
+			}
+			messages[j]["content"] = content
+		}
+		i++
+	}
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	fields["messages"] = encoded
+	return json.Marshal(fields)
+}
+
+// redactedContent returns content, a string or a list of blocks, with its text
+// replaced by text
+func redactedContent(content json.RawMessage, text string) (json.RawMessage, error) {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return json.Marshal(text)
+	}
+	var blocks []map[string]json.RawMessage
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return nil, err
+	}
+	kept := make([]map[string]json.RawMessage, 0, len(blocks))
+	replaced := false
+	for _, block := range blocks {
+		var blockType string
+		json.Unmarshal(block["type"], &blockType)
+		if blockType != "text" {
+			kept = append(kept, block)
+			continue
+		}
+		if replaced {
+			continue
+		}
+		encoded, err := json.Marshal(text)
+		if err != nil {
+			return nil, err
+		}
+		block["text"] = encoded
+		kept = append(kept, block)
+		replaced = true
+	}
+	return json.Marshal(kept)
+}
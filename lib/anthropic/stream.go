@@ -0,0 +1,272 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamEvent holds the fields of the stream events the usage is read from
+type streamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Model string `json:"model"`
+		Usage usage  `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage usage `json:"usage"`
+}
+
+// streamUsage accumulates the usage of a streamed message. Anthropic reports
+// the input tokens when the message starts and the output tokens when it ends,
+// so when a stream ends early the output is counted from the text received.
+type streamUsage struct {
+	ctx        context.Context
+	req        openai.ChatCompletionRequest
+	model      string
+	content    strings.Builder
+	stopReason string
+	started    *usage
+	reported   *usage
+	hashes     *lib.ContentHashes
+}
+
+func newStreamUsage(ctx context.Context, req openai.ChatCompletionRequest) *streamUsage {
+	return &streamUsage{ctx: ctx, req: req, model: req.Model}
+}
+
+func (u *streamUsage) add(event streamEvent) {
+	switch event.Type {
+	case "message_start":
+		if event.Message.Model != "" {
+			u.model = event.Message.Model
+		}
+		u.started = &event.Message.Usage
+	case "content_block_delta":
+		u.content.WriteString(event.Delta.Text)
+	case "message_delta":
+		u.stopReason = event.Delta.StopReason
+		u.reported = &event.Usage
+	}
+}
+
+// record logs the usage. Streams without a stop reason were cut short and are
+// recorded with the client_disconnect finish reason if the client went away,
+// a null one otherwise.
+func (u *streamUsage) record() {
+	var finishReason string
+	if u.stopReason == "" {
+		finishReason = lib.CutShortFinishReason(u.ctx, u.model)
+	} else {
+		finishReason = u.stopReason
+	}
+
+	promptTokens := lib.CountPromptTokens(u.req)
+	if u.started != nil {
+		promptTokens = u.started.InputTokens
+	}
+	completionTokens := lib.CountTokens(u.model, u.content.String())
+	if u.reported != nil {
+		completionTokens = u.reported.OutputTokens
+	}
+	lib.ProviderUsage(u.ctx, models.Anthropic, u.hashes, u.model, 0, promptTokens, completionTokens, promptTokens+completionTokens, finishReason, "anthropic_messages_stream")
+}
+
+// streamInspector applies the output rules to the text blocks of a streamed
+// message. Like for chat completions, the text of each block is held back
+// until a window of window_bytes arrived, then checked on its own and sent with
+// the changes the rules made. Windows end at whitespace so words aren't split
+// between them.
+type streamInspector struct {
+	r       *http.Request
+	window  int
+	model   string
+	pending map[int]*strings.Builder
+}
+
+// newStreamInspector returns nil when no output rule applies to the stream, so
+// its events are passed through as they arrive
+func newStreamInspector(r *http.Request, model string) *streamInspector {
+	if !rules.StreamOutputActive(r) {
+		return nil
+	}
+	return &streamInspector{r: r, window: rules.StreamOutputWindow(), model: model, pending: map[int]*strings.Builder{}}
+}
+
+// apply holds back text of the block at index and returns the text that passed
+// the rules, all of the block's text once it ended. It returns the message of
+// the rule that blocked a window.
+func (s *streamInspector) apply(index int, text string, ended bool) (string, string, bool) {
+	pending, ok := s.pending[index]
+	if !ok {
+		pending = &strings.Builder{}
+		s.pending[index] = pending
+	}
+	pending.WriteString(text)
+
+	held := pending.String()
+	cut := len(held)
+	if !ended {
+		if len(held) < s.window {
+			return "", "", false
+		}
+		if cut = strings.LastIndexFunc(held, unicode.IsSpace); cut < 0 {
+			return "", "", false
+		}
+		cut++
+	}
+	if held[:cut] == "" {
+		return "", "", false
+	}
+	resp := openai.ChatCompletionResponse{
+		Model: s.model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index:   index,
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: held[:cut]},
+		}},
+	}
+	if blocked, message, _ := rules.Output(s.r, &resp); blocked {
+		return "", message, true
+	}
+	pending.Reset()
+	pending.WriteString(held[cut:])
+	return resp.Choices[0].Message.Content, "", false
+}
+
+// relayStream sends the events of a streamed message to the client as they
+// come, feeding their data to the usage of the stream. When output rules apply,
+// text deltas are replaced by the windows that passed them, and a blocked
+// window ends the stream with an error event.
+func relayStream(w http.ResponseWriter, r *http.Request, resp *http.Response, usage *streamUsage, start time.Time) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "api_error", "streaming unsupported")
+		return
+	}
+	defer usage.record()
+
+	var firstToken time.Duration
+	defer func() {
+		lib.RecordLatency(usage.req.Model, firstToken, time.Since(start))
+	}()
+	inspector := newStreamInspector(r, usage.req.Model)
+	writeDelta := func(index int, text string) {
+		data, _ := json.Marshal(map[string]interface{}{"type": "content_block_delta", "index": index, "delta": map[string]string{"type": "text_delta", "text": text}})
+		fmt.Fprintf(w, "event: content_block_delta\ndata: %s\n\n", data)
+	}
+	// Text already sent stays with the client
+	blockStream := func(message string) {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		if lib.WantsEnvelope(r) {
+			lib.WriteEnvelopeStreamError(w, providerName, http.StatusBadRequest, lib.EnvelopeContentBlocked, message)
+		} else {
+			writeStreamError(w, r, http.StatusBadRequest, "invalid_request_error", message)
+		}
+		flusher.Flush()
+	}
+
+	// Lines are held until the blank line ending their event, so an event can be
+	// replaced as a whole
+	var lines bytes.Buffer
+	var event streamEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) != 0 {
+			if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				event = streamEvent{}
+				if json.Unmarshal(bytes.TrimSpace(data), &event) == nil {
+					if event.Type == "content_block_delta" && firstToken == 0 {
+						firstToken = time.Since(start)
+					}
+					usage.add(event)
+				}
+			}
+			lines.Write(line)
+			lines.WriteByte('\n')
+			continue
+		}
+
+		switch {
+		case inspector == nil:
+		case event.Type == "content_block_delta" && event.Delta.Type == "text_delta":
+			text, message, blocked := inspector.apply(event.Index, event.Delta.Text, false)
+			if blocked {
+				blockStream(message)
+				return
+			}
+			if text != "" {
+				writeDelta(event.Index, text)
+				flusher.Flush()
+			}
+			lines.Reset()
+			continue
+		case event.Type == "content_block_stop":
+			text, message, blocked := inspector.apply(event.Index, "", true)
+			if blocked {
+				blockStream(message)
+				return
+			}
+			if text != "" {
+				writeDelta(event.Index, text)
+			}
+		}
+		w.Write(lines.Bytes())
+		fmt.Fprint(w, "\n")
+		flusher.Flush()
+		lines.Reset()
+		event = streamEvent{}
+	}
+	if err := scanner.Err(); err != nil {
+		writeStreamError(w, r, http.StatusBadGateway, "api_error", err.Error())
+		flusher.Flush()
+		return
+	}
+	// A stream cut short ends without the stop of its last block
+	if inspector != nil {
+		for index := range inspector.pending {
+			text, message, blocked := inspector.apply(index, "", true)
+			if blocked {
+				blockStream(message)
+				return
+			}
+			if text != "" {
+				writeDelta(index, text)
+			}
+		}
+	}
+	w.Write(lines.Bytes())
+	flusher.Flush()
+}
+
+// writeStreamError writes an error event, which ends a stream of the Messages
+// API, as an error envelope if the client asked for one
+func writeStreamError(w http.ResponseWriter, r *http.Request, status int, errorType string, message string) {
+	if lib.WantsEnvelope(r) {
+		lib.WriteEnvelopeStreamError(w, providerName, status, errorType, message)
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{"type": "error", "error": map[string]string{"type": errorType, "message": message}})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+}
@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrBlobNotFound is returned for blobs missing from the store, such as expired ones
+var ErrBlobNotFound = errors.New("blob not found")
+
+// archiveDateLayout is the part of the blob keys the lifecycle job reads the age from
+const archiveDateLayout = "2006/01/02"
+
+// BlobStore keeps archived bodies outside the database
+type BlobStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the keys starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+func init() {
+	RegisterJob(Job{
+		Name:     "archive_lifecycle",
+		Interval: 24 * time.Hour,
+		Run:      expireArchivedBodies,
+	})
+}
+
+// GetArchiveSettings returns the archive settings with defaults applied
+func GetArchiveSettings() Archive {
+	settings := Archive{}
+	if archive := GetConfig().Settings.Archive; archive != nil {
+		settings = *archive
+	}
+	if settings.Backend == "" {
+		settings.Backend = "s3"
+	}
+	if settings.MinBytes <= 0 {
+		settings.MinBytes = 64 << 10
+	}
+	return settings
+}
+
+// ArchiveStore returns the blob store of the archive settings
+func ArchiveStore() (BlobStore, error) {
+	settings := GetArchiveSettings()
+	if settings.Bucket == "" {
+		return nil, fmt.Errorf("archive bucket is not set")
+	}
+	secrets := GetConfig().Secrets
+	switch settings.Backend {
+	case "s3":
+		return newS3Store(settings, secrets)
+	case "gcs":
+		return newGCSStore(settings, secrets)
+	case "azure":
+		return newAzureStore(settings, secrets)
+	case "file":
+		return fileStore{dir: settings.Bucket}, nil
+	}
+	return nil, fmt.Errorf("unknown archive backend %q", settings.Backend)
+}
+
+// archiveBody stores a body of at least min_bytes in the archive and returns
+// its key. It reports false for smaller bodies, or when the archive is disabled
+// or fails, so the body stays in the database.
+func archiveBody(ctx context.Context, kind string, body string) (string, bool) {
+	settings := GetArchiveSettings()
+	if !settings.Enabled || len(body) < settings.MinBytes {
+		return "", false
+	}
+	store, err := ArchiveStore()
+	if err != nil {
+		log.Printf("Error archiving body: %v", err)
+		return "", false
+	}
+	key := path.Join(settings.Prefix, kind, time.Now().UTC().Format(archiveDateLayout), uuid.NewString())
+	if err := store.Put(ctx, key, []byte(body)); err != nil {
+		log.Printf("Error archiving body: %v", err)
+		return "", false
+	}
+	return key, true
+}
+
+// ArchivedBody returns a body stored in the archive
+func ArchivedBody(ctx context.Context, key string) (string, error) {
+	store, err := ArchiveStore()
+	if err != nil {
+		return "", err
+	}
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// expireArchivedBodies deletes the archived bodies older than expire_after_days
+func expireArchivedBodies(ctx context.Context) error {
+	settings := GetArchiveSettings()
+	if !settings.Enabled || settings.ExpireAfterDays <= 0 {
+		return nil
+	}
+	store, err := ArchiveStore()
+	if err != nil {
+		return err
+	}
+	prefix := settings.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -settings.ExpireAfterDays)
+	for _, key := range keys {
+		if archived, ok := archivedAt(key); ok && archived.Before(cutoff) {
+			if err := store.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+				return fmt.Errorf("failed to delete %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// archivedAt reads the day a body was archived from its key, <kind>/<yyyy>/<mm>/<dd>/<id>
+func archivedAt(key string) (time.Time, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(archiveDateLayout, strings.Join(parts[len(parts)-4:len(parts)-1], "/"))
+	return day, err == nil
+}
+
+// fileStore keeps blobs in a local directory, for development and single replicas
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s fileStore) Put(_ context.Context, key string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path(key)), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(key), body, 0o600)
+}
+
+func (s fileStore) Get(_ context.Context, key string) ([]byte, error) {
+	body, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return body, err
+}
+
+func (s fileStore) Delete(_ context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func (s fileStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		key, _ := filepath.Rel(s.dir, file)
+		if key = filepath.ToSlash(key); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return keys, err
+}
@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureStorageVersion = "2021-08-06"
+
+// azureStore keeps blobs in a container of an Azure storage account, with
+// requests signed with the shared key of the account
+type azureStore struct {
+	client     *http.Client
+	account    string
+	container  string
+	endpoint   string
+	key        []byte
+	accessTier string
+	encryption ArchiveEncryption
+}
+
+func newAzureStore(settings Archive, secrets Secrets) (*azureStore, error) {
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Account == "" {
+		return nil, fmt.Errorf("archive account is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(secrets.ArchiveSecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage account key: %v", err)
+	}
+	store := &azureStore{
+		client:     client,
+		account:    settings.Account,
+		container:  settings.Bucket,
+		endpoint:   strings.TrimSuffix(settings.Endpoint, "/"),
+		key:        key,
+		accessTier: settings.StorageClass,
+	}
+	if store.endpoint == "" {
+		store.endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", settings.Account)
+	}
+	if settings.Encryption != nil {
+		store.encryption = *settings.Encryption
+	}
+	return store, nil
+}
+
+func (s *azureStore) do(ctx context.Context, method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	target := s.endpoint + "/" + s.container
+	if key != "" {
+		target += "/" + awsEscape(key, false)
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, len(body), time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, message)
+	}
+	return resp, nil
+}
+
+func (s *azureStore) Put(ctx context.Context, key string, body []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	if s.accessTier != "" {
+		header.Set("X-Ms-Access-Tier", s.accessTier)
+	}
+	// Storage accounts always encrypt with Microsoft managed keys, "kms" picks an encryption scope
+	if s.encryption.Mode == "kms" && s.encryption.KMSKey != "" {
+		header.Set("X-Ms-Encryption-Scope", s.encryption.KMSKey)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *azureStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *azureStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// azureListResult is a page of a List Blobs response
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name string `xml:"Name"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (s *azureStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page azureListResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range page.Blobs.Blob {
+			keys = append(keys, blob.Name)
+		}
+		if page.NextMarker == "" {
+			return keys, nil
+		}
+		query.Set("marker", page.NextMarker)
+	}
+}
+
+// sign adds the Shared Key authorization of the account to a request
+func (s *azureStore) sign(req *http.Request, contentLength int, now time.Time) {
+	req.Header.Set("X-Ms-Date", now.Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+
+	length := ""
+	if contentLength > 0 {
+		length = strconv.Itoa(contentLength)
+	}
+	var headers []string
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/" + s.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-Md5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(headers, "\n"),
+		resource,
+	}, "\n")
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsEndpoint      = "https://storage.googleapis.com"
+	gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// gcsMetadataTimeout bounds the token requests to the metadata server
+	gcsMetadataTimeout = 10 * time.Second
+)
+
+// gcsToken caches the access token of the metadata server
+var gcsToken struct {
+	sync.Mutex
+	value     string
+	expiresAt time.Time
+}
+
+// gcsStore keeps blobs in a Cloud Storage bucket through its XML API, with the
+// token of the archive_token secret or of the metadata server
+type gcsStore struct {
+	client       *http.Client
+	bucket       string
+	endpoint     string
+	token        string
+	storageClass string
+	encryption   ArchiveEncryption
+}
+
+func newGCSStore(settings Archive, secrets Secrets) (*gcsStore, error) {
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return nil, err
+	}
+	store := &gcsStore{
+		client:       client,
+		bucket:       settings.Bucket,
+		endpoint:     strings.TrimSuffix(settings.Endpoint, "/"),
+		token:        secrets.ArchiveToken,
+		storageClass: settings.StorageClass,
+	}
+	if store.endpoint == "" {
+		store.endpoint = gcsEndpoint
+	}
+	if settings.Encryption != nil {
+		store.encryption = *settings.Encryption
+	}
+	return store, nil
+}
+
+// accessToken returns the configured token, or one of the metadata server.
+// The token is requested with the egress client of the store, so an egress
+// allowlist must list metadata.google.internal.
+func (s *gcsStore) accessToken(ctx context.Context) (string, error) {
+	if s.token != "" {
+		return s.token, nil
+	}
+	gcsToken.Lock()
+	defer gcsToken.Unlock()
+	if gcsToken.value != "" && time.Now().Before(gcsToken.expiresAt) {
+		return gcsToken.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gcsMetadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token from the metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("failed to get a token from the metadata server: %s", resp.Status)
+	}
+	// Tokens are renewed a minute before they expire
+	gcsToken.value, gcsToken.expiresAt = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn-60)*time.Second)
+	return gcsToken.value, nil
+}
+
+func (s *gcsStore) do(ctx context.Context, method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target := s.endpoint + "/" + s.bucket
+	if key != "" {
+		target += "/" + awsEscape(key, false)
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, message)
+	}
+	return resp, nil
+}
+
+func (s *gcsStore) Put(ctx context.Context, key string, body []byte) error {
+	header := http.Header{}
+	if s.storageClass != "" {
+		header.Set("X-Goog-Storage-Class", s.storageClass)
+	}
+	// Cloud Storage always encrypts with its own keys, "kms" picks a customer managed key
+	if s.encryption.Mode == "kms" && s.encryption.KMSKey != "" {
+		header.Set("X-Goog-Encryption-Kms-Key-Name", s.encryption.KMSKey)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *gcsStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *gcsStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *gcsStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return listXMLBucket(func(query url.Values) (*http.Response, error) {
+		return s.do(ctx, http.MethodGet, "", query, nil, nil)
+	}, prefix)
+}
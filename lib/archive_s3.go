@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store keeps blobs in an S3 bucket, or a bucket of an S3 compatible store
+// at the endpoint setting, with requests signed with AWS Signature Version 4
+type s3Store struct {
+	client       *http.Client
+	bucket       string
+	region       string
+	endpoint     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	storageClass string
+	encryption   ArchiveEncryption
+}
+
+func newS3Store(settings Archive, secrets Secrets) (*s3Store, error) {
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return nil, err
+	}
+	store := &s3Store{
+		client:       client,
+		bucket:       settings.Bucket,
+		region:       settings.Region,
+		endpoint:     strings.TrimSuffix(settings.Endpoint, "/"),
+		accessKey:    secrets.ArchiveAccessKey,
+		secretKey:    secrets.ArchiveSecretKey,
+		sessionToken: secrets.ArchiveToken,
+		storageClass: settings.StorageClass,
+	}
+	if store.region == "" {
+		store.region = "us-east-1"
+	}
+	if settings.Encryption != nil {
+		store.encryption = *settings.Encryption
+	}
+	return store, nil
+}
+
+// url returns the URL of a key, path style at a custom endpoint and virtual
+// hosted style on AWS
+func (s *s3Store) url(key string, query url.Values) string {
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+	if s.endpoint != "" {
+		base = s.endpoint + "/" + s.bucket
+	}
+	target := base + "/" + awsEscape(key, false)
+	if key == "" && s.endpoint != "" {
+		target = base
+	}
+	if len(query) > 0 {
+		target += "?" + awsQuery(query)
+	}
+	return target
+}
+
+func (s *s3Store) do(ctx context.Context, method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, message)
+	}
+	return resp, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, body []byte) error {
+	header := http.Header{}
+	if s.storageClass != "" {
+		header.Set("X-Amz-Storage-Class", s.storageClass)
+	}
+	switch s.encryption.Mode {
+	case "provider":
+		header.Set("X-Amz-Server-Side-Encryption", "AES256")
+	case "kms":
+		header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		if s.encryption.KMSKey != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.encryption.KMSKey)
+		}
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	return listXMLBucket(func(query url.Values) (*http.Response, error) {
+		return s.do(ctx, http.MethodGet, "", query, nil, nil)
+	}, prefix)
+}
+
+// listBucketResult is a page of a ListObjectsV2 response, which the XML API of GCS answers too
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listXMLBucket pages through a ListObjectsV2 listing of prefix
+func listXMLBucket(get func(query url.Values) (*http.Response, error), prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := get(query)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// sign adds the AWS Signature Version 4 headers to a request. The host and
+// every x-amz header are signed.
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		awsQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, and
+// slashes unless encodeSlash is set, as Signature Version 4 requires
+func awsEscape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// awsQuery returns the query sorted by name and encoded for Signature Version 4
+func awsQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
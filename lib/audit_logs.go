@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
@@ -9,10 +10,18 @@ import (
 	"github.com/openshieldai/openshield/models"
 )
 
-func AuditLogs(message string, logType string, apiKeyID uuid.UUID, messageType string, r *http.Request) {
-	config := GetConfig()
+// RestrictedMessageType marks audit logs with sensitive content, such as crisis
+// interceptions, that only the database itself gives access to
+const RestrictedMessageType = "restricted"
+
+// AuditLoggingActive reports whether audit logs are written for the request, so
+// callers can skip encoding messages that aren't logged
+func AuditLoggingActive(r *http.Request) bool {
+	return GetConfig().Settings.AuditLogging.Enabled || burnInLogging(r)
+}
 
-	if config.Settings.AuditLogging.Enabled {
+func AuditLogs(message string, logType string, apiKeyID uuid.UUID, messageType string, r *http.Request) {
+	if AuditLoggingActive(r) {
 		auditLog := models.AuditLogs{
 			Message:     message,
 			Type:        logType,
@@ -23,15 +32,42 @@ func AuditLogs(message string, logType string, apiKeyID uuid.UUID, messageType s
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
 		}
+		// Large bodies are kept in the archive, the row holds their key
+		if key, ok := archiveBody(r.Context(), "audit_logs", message); ok {
+			auditLog.Message, auditLog.ArchiveKey = "", key
+		}
 
-		db := DB()
-		db.Create(&auditLog)
+		createOrQueue(queuedAuditLog, &auditLog)
 	} else {
 		log.Println("Audit log is disabled")
 		return
 	}
 }
 
+// SecurityAuditLog writes an audit log of a security event, a login for
+// example, whether audit logging is on or not
+func SecurityAuditLog(message string, logType string, messageType string, r *http.Request) {
+	createOrQueue(queuedAuditLog, &models.AuditLogs{
+		Message:     message,
+		Type:        logType,
+		MessageType: messageType,
+		ApiKeyID:    uuid.Nil,
+		IPAddress:   getIPAddress(r),
+		RequestId:   getRequestID(r),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	})
+}
+
+// AuditLogMessage returns the message of an audit log, read from the archive
+// when its body was archived
+func AuditLogMessage(ctx context.Context, auditLog models.AuditLogs) (string, error) {
+	if auditLog.ArchiveKey == "" {
+		return auditLog.Message, nil
+	}
+	return ArchivedBody(ctx, auditLog.ArchiveKey)
+}
+
 func getIPAddress(r *http.Request) string {
 	ip := r.Header.Get("X-Real-IP")
 	if ip == "" {
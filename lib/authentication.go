@@ -6,32 +6,36 @@ import (
 	"crypto/subtle"
 	"log"
 	"net/http"
-	"strings"
 
 	"github.com/openshieldai/openshield/models"
 )
 
 func AuthOpenShieldMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
-			return
+		// Callers without a key may authenticate by their workload identity
+		if _, err := bearerToken(r); err != nil {
+			if apiKey, ok, err := workloadApiKey(r.Context(), r); ok {
+				if err != nil {
+					log.Println("Error: ", err)
+					writeAuthError(w, err.Error())
+					return
+				}
+				authenticated(w, r, next, apiKey)
+				return
+			}
 		}
 
-		splitToken := strings.Split(authHeader, "Bearer ")
-		if len(splitToken) != 2 {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+		key, err := bearerToken(r)
+		if err != nil {
+			writeAuthError(w, err.Error())
 			return
 		}
 
-		key := splitToken[1]
-
 		var apiKey = models.ApiKeys{ApiKey: key, Status: models.Active}
 		result := DB().Where(&apiKey).First(&apiKey)
 		if result.Error != nil {
 			log.Println("Error: ", result.Error)
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			writeAuthError(w, "Invalid API key")
 			return
 		}
 
@@ -39,17 +43,35 @@ func AuthOpenShieldMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		hashedKey := sha256.Sum256([]byte(apiKey.ApiKey))
 
 		if subtle.ConstantTimeCompare(hashedAPIKey[:], hashedKey[:]) == 1 {
-			// Store the API key ID in the request context
-			ctx := r.Context()
-			ctx = context.WithValue(ctx, "apiKeyId", apiKey.Id)
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
+			authenticated(w, r, next, apiKey)
 		} else {
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			writeAuthError(w, "Invalid API key")
 		}
 	}
 }
 
+// authenticated serves a request of apiKey
+func authenticated(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, apiKey models.ApiKeys) {
+	// Store the API key ID in the request context
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, "apiKeyId", apiKey.Id)
+	ctx, err := withProductProfile(ctx, r, apiKey)
+	if err != nil {
+		log.Printf("Error authenticating API key %s: %v", apiKey.Id, err)
+		writeProfileError(w)
+		return
+	}
+	ctx = withKeyBurnIn(ctx, apiKey)
+	ctx = context.WithValue(ctx, "apiKeyScopes", KeyScopes(apiKey))
+	ctx, err = withPolicyOverride(ctx, r, apiKey)
+	if err != nil {
+		writeAuthError(w, err.Error())
+		return
+	}
+	r = r.WithContext(ctx)
+	next.ServeHTTP(w, r)
+}
+
 //func AuthHeaderParser(c *fiber.Ctx) (string, error) {
 //	authHeader := c.Get("Authorization")
 //	if authHeader == "" {
@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// azureCognitiveScope is the scope of Entra ID tokens for Azure OpenAI
+	azureCognitiveScope = "https://cognitiveservices.azure.com/.default"
+	// azureIMDSEndpoint is the managed identity endpoint of Azure VMs and AKS nodes
+	azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// entraTokenRefresh is how long before it expires a token is replaced
+	entraTokenRefresh = 5 * time.Minute
+)
+
+// entraTokenClient fetches tokens from Entra ID and metadata endpoints, which
+// the egress policy of provider requests would refuse
+var entraTokenClient = &http.Client{Timeout: 10 * time.Second}
+
+type entraToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// entraTokenSource hands out the Entra ID token of the gateway, fetched again
+// shortly before it expires. Requests waiting for a token share the fetch.
+type entraTokenSource struct {
+	mu    sync.Mutex
+	token entraToken
+	fetch func(ctx context.Context) (entraToken, error)
+}
+
+var entraTokens = &entraTokenSource{fetch: fetchEntraToken}
+
+// Token returns a valid token, fetching one when the current one is about to expire
+func (s *entraTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.value != "" && time.Now().Before(s.token.expiresAt.Add(-entraTokenRefresh)) {
+		return s.token.value, nil
+	}
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting an Entra ID token: %v", err)
+	}
+	s.token = token
+	return token.value, nil
+}
+
+// fetchEntraToken gets a token with the client secret of a service principal
+// when AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET are set, and
+// from the managed identity of the host otherwise. AZURE_CLIENT_ID picks a user
+// assigned identity.
+func fetchEntraToken(ctx context.Context) (entraToken, error) {
+	tenantID, clientID, clientSecret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if tenantID != "" && clientID != "" && clientSecret != "" {
+		authority := os.Getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com"
+		}
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {clientSecret},
+			"scope":         {azureCognitiveScope},
+		}
+		tokenURL := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return entraToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return requestEntraToken(req)
+	}
+
+	resource := strings.TrimSuffix(azureCognitiveScope, "/.default")
+	query := url.Values{"resource": {resource}}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	// App Service and Container Apps serve the identity of the app on their own endpoint
+	if endpoint, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && secret != "" {
+		query.Set("api-version", "2019-08-01")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return entraToken{}, err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", secret)
+		return requestEntraToken(req)
+	}
+	query.Set("api-version", "2018-02-01")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return entraToken{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	return requestEntraToken(req)
+}
+
+// requestEntraToken sends a token request. Entra ID answers expires_in as a
+// number, the managed identity endpoints as strings and some only expires_on.
+func requestEntraToken(req *http.Request) (entraToken, error) {
+	resp, err := entraTokenClient.Do(req)
+	if err != nil {
+		return entraToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return entraToken{}, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var body struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return entraToken{}, fmt.Errorf("invalid token response: %v", err)
+	}
+	if body.AccessToken == "" {
+		return entraToken{}, fmt.Errorf("token response has no access_token")
+	}
+	token := entraToken{value: body.AccessToken, expiresAt: time.Now().Add(entraTokenRefresh + time.Minute)}
+	if expiresIn, err := body.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	} else if expiresOn, err := body.ExpiresOn.Int64(); err == nil && expiresOn > 0 {
+		token.expiresAt = time.Unix(expiresOn, 0)
+	}
+	return token, nil
+}
+
+// entraTransport sets the Entra ID token of every request
+type entraTransport struct {
+	next   http.RoundTripper
+	tokens *entraTokenSource
+}
+
+func (t *entraTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
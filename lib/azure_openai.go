@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// azureDeploymentPaths are the OpenAI paths Azure serves per deployment, the
+// others are served for the whole resource
+var azureDeploymentPaths = map[string]bool{
+	"/chat/completions":     true,
+	"/completions":          true,
+	"/embeddings":           true,
+	"/audio/transcriptions": true,
+	"/audio/translations":   true,
+}
+
+// GetAzureOpenAI returns the Azure OpenAI settings of the OpenAI provider with
+// defaults applied, nil when the provider calls OpenAI
+func GetAzureOpenAI(config Configuration) *AzureOpenAI {
+	if config.Providers.OpenAI == nil || config.Providers.OpenAI.Azure == nil {
+		return nil
+	}
+	azure := *config.Providers.OpenAI.Azure
+	if azure.Resource == "" && azure.Endpoint == "" {
+		return nil
+	}
+	if azure.APIVersion == "" {
+		azure.APIVersion = "2024-10-21"
+	}
+	if azure.Auth == "" {
+		azure.Auth = "api_key"
+	}
+	return &azure
+}
+
+// BaseURL returns the endpoint of the resource
+func (a *AzureOpenAI) BaseURL() string {
+	if a.Endpoint != "" {
+		return strings.TrimSuffix(a.Endpoint, "/")
+	}
+	return "https://" + a.Resource + ".openai.azure.com"
+}
+
+// Deployment returns the deployment ID serving a model
+func (a *AzureOpenAI) Deployment(model string) string {
+	if deployment, ok := a.Deployments[model]; ok {
+		return deployment
+	}
+	return model
+}
+
+// URL returns the Azure URL of an OpenAI API path, at the deployment of the
+// model for the paths served per deployment
+func (a *AzureOpenAI) URL(model string, path string) string {
+	target := a.BaseURL() + "/openai"
+	if azureDeploymentPaths[path] {
+		target += "/deployments/" + url.PathEscape(a.Deployment(model))
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return target + path + separator + "api-version=" + url.QueryEscape(a.APIVersion)
+}
+
+// SetAuth sets the credential header Azure expects in place of the OpenAI bearer token
+func (a *AzureOpenAI) SetAuth(header http.Header, secret string) {
+	if a.Auth == "entra_id" {
+		header.Set("Authorization", "Bearer "+secret)
+		return
+	}
+	header.Del("Authorization")
+	header.Set(goopenai.AzureAPIKeyHeader, secret)
+}
+
+// AuthClient returns client authenticating its requests with an Entra ID token
+// that is renewed before it expires, for resources with auth entra_id and no
+// static token in AZURE_OPENAI_AD_TOKEN. Other clients are returned as they are.
+func (a *AzureOpenAI) AuthClient(client *http.Client, secret string) *http.Client {
+	if a.Auth != "entra_id" || secret != "" {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &http.Client{Transport: &entraTransport{next: next, tokens: entraTokens}, Timeout: client.Timeout}
+}
+
+// ClientConfig returns the client library configuration for the resource
+func (a *AzureOpenAI) ClientConfig(secret string) goopenai.ClientConfig {
+	clientConfig := goopenai.DefaultAzureConfig(secret, a.BaseURL())
+	clientConfig.APIVersion = a.APIVersion
+	if a.Auth == "entra_id" {
+		clientConfig.APIType = goopenai.APITypeAzureAD
+	}
+	clientConfig.AzureModelMapperFunc = a.Deployment
+	return clientConfig
+}
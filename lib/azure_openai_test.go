@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureOpenAIURL(t *testing.T) {
+	assert.Nil(t, GetAzureOpenAI(Configuration{Providers: Providers{OpenAI: &ProviderConfig{}}}))
+
+	azure := GetAzureOpenAI(Configuration{Providers: Providers{OpenAI: &ProviderConfig{Azure: &AzureOpenAI{
+		Resource:    "contoso",
+		Deployments: map[string]string{"gpt-4o": "prod-gpt4o"},
+	}}}})
+	require.NotNil(t, azure)
+	assert.Equal(t, "https://contoso.openai.azure.com/openai/deployments/prod-gpt4o/chat/completions?api-version=2024-10-21",
+		azure.URL("gpt-4o", "/chat/completions"))
+	assert.Equal(t, "https://contoso.openai.azure.com/openai/deployments/gpt-4o-mini/completions?api-version=2024-10-21",
+		azure.URL("gpt-4o-mini", "/completions"), "unmapped models use the deployment of the same name")
+	assert.Equal(t, "https://contoso.openai.azure.com/openai/fine_tuning/jobs?limit=5&api-version=2024-10-21",
+		azure.URL("", "/fine_tuning/jobs?limit=5"))
+
+	header := http.Header{"Authorization": {"Bearer old"}}
+	azure.SetAuth(header, "secret")
+	assert.Empty(t, header.Get("Authorization"))
+	assert.Equal(t, "secret", header.Get("api-key"))
+
+	azure.Auth = "entra_id"
+	azure.SetAuth(header, "token")
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+}
+
+func TestAzureOpenAIClient(t *testing.T) {
+	var path, apiVersion, apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiVersion, apiKey = r.URL.Path, r.URL.Query().Get("api-version"), r.Header.Get("api-key")
+		json.NewEncoder(w).Encode(goopenai.ChatCompletionResponse{Model: "gpt-4o"})
+	}))
+	defer server.Close()
+
+	azure := &AzureOpenAI{Endpoint: server.URL, APIVersion: "2024-06-01", Deployments: map[string]string{"gpt-4o": "prod-gpt4o"}}
+	_, err := goopenai.NewClientWithConfig(azure.ClientConfig("secret")).CreateChatCompletion(context.Background(), goopenai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []goopenai.ChatCompletionMessage{{Role: "user", Content: "hi"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/openai/deployments/prod-gpt4o/chat/completions", path)
+	assert.Equal(t, "2024-06-01", apiVersion)
+	assert.Equal(t, "secret", apiKey)
+}
+
+func TestEntraTokenSource(t *testing.T) {
+	var fetches int
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		r.ParseForm()
+		form = r.PostForm
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		// Expiring within the refresh margin, the token is fetched again on every call
+		expiresIn := 3600
+		if fetches > 1 {
+			expiresIn = 60
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": fmt.Sprintf("token-%d", fetches), "expires_in": expiresIn})
+	}))
+	defer server.Close()
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	tokens := &entraTokenSource{fetch: fetchEntraToken}
+	for range 2 {
+		token, err := tokens.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token-1", token)
+	}
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, "client_credentials", form.Get("grant_type"))
+	assert.Equal(t, azureCognitiveScope, form.Get("scope"))
+
+	tokens.token.expiresAt = time.Now()
+	token, err := tokens.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+	token, err = tokens.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-3", token)
+}
+
+func TestEntraManagedIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "identity-secret", r.Header.Get("X-IDENTITY-HEADER"))
+		assert.Equal(t, "https://cognitiveservices.azure.com", r.URL.Query().Get("resource"))
+		// Managed identity endpoints answer the expiry as a string
+		w.Write([]byte(`{"access_token": "managed", "expires_in": "3600"}`))
+	}))
+	defer server.Close()
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	t.Setenv("IDENTITY_ENDPOINT", server.URL)
+	t.Setenv("IDENTITY_HEADER", "identity-secret")
+
+	token, err := fetchEntraToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "managed", token.value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.expiresAt, time.Minute)
+}
+
+func TestAzureAuthClient(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	tokens := entraTokens
+	defer func() { entraTokens = tokens }()
+	entraTokens = &entraTokenSource{fetch: func(context.Context) (entraToken, error) {
+		return entraToken{value: "fetched", expiresAt: time.Now().Add(time.Hour)}, nil
+	}}
+
+	azure := &AzureOpenAI{Endpoint: server.URL, Auth: "entra_id"}
+	_, err := azure.AuthClient(http.DefaultClient, "").Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer fetched", authorization)
+
+	// A static AZURE_OPENAI_AD_TOKEN and key authentication are sent as they are
+	assert.Same(t, http.DefaultClient, azure.AuthClient(http.DefaultClient, "static"))
+	azure.Auth = "api_key"
+	assert.Same(t, http.DefaultClient, azure.AuthClient(http.DefaultClient, ""))
+}
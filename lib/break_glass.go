@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const breakGlassRedisKey = "openshield:break_glass"
+
+// breakGlassCheckInterval bounds how often the proxy reads the break-glass state from Redis
+const breakGlassCheckInterval = 5 * time.Second
+
+// BreakGlassState is an active emergency bypass, signed with the break-glass key
+type BreakGlassState struct {
+	Reason    string    `json:"reason"`
+	Operator  string    `json:"operator"`
+	EnabledAt time.Time `json:"enabled_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Signature string    `json:"signature"`
+}
+
+var breakGlassCache struct {
+	sync.Mutex
+	state     BreakGlassState
+	active    bool
+	checkedAt time.Time
+}
+
+// EnableBreakGlass disables non-critical rules on every replica until duration
+// has passed. The state expires in Redis, so rules are re-enabled automatically.
+func EnableBreakGlass(reason string, operator string, duration time.Duration) (BreakGlassState, error) {
+	config := GetConfig()
+	if config.Settings.BreakGlass == nil || !config.Settings.BreakGlass.Enabled {
+		return BreakGlassState{}, fmt.Errorf("break-glass mode is not enabled in settings.break_glass")
+	}
+	if config.Secrets.BreakGlassKey == "" {
+		return BreakGlassState{}, fmt.Errorf("break-glass key is not set")
+	}
+	if reason == "" {
+		return BreakGlassState{}, fmt.Errorf("a reason is required")
+	}
+
+	maxDuration := time.Duration(config.Settings.BreakGlass.MaxDuration) * time.Second
+	if maxDuration <= 0 {
+		maxDuration = time.Hour
+	}
+	if duration <= 0 || duration > maxDuration {
+		return BreakGlassState{}, fmt.Errorf("duration must be between 0 and %v", maxDuration)
+	}
+
+	now := time.Now().UTC()
+	state := BreakGlassState{
+		Reason:    reason,
+		Operator:  operator,
+		EnabledAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+	state.Signature = signBreakGlass(state, []byte(config.Secrets.BreakGlassKey))
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return BreakGlassState{}, err
+	}
+	if err := RedisClient().Set(context.Background(), breakGlassRedisKey, data, duration).Err(); err != nil {
+		return BreakGlassState{}, fmt.Errorf("failed to store break-glass state: %v", err)
+	}
+
+	recordBreakGlassEvent("enabled", state)
+	return state, nil
+}
+
+// DisableBreakGlass ends an active emergency bypass before it expires
+func DisableBreakGlass(operator string) error {
+	if err := RedisClient().Del(context.Background(), breakGlassRedisKey).Err(); err != nil {
+		return fmt.Errorf("failed to remove break-glass state: %v", err)
+	}
+	recordBreakGlassEvent("disabled", BreakGlassState{Operator: operator, ExpiresAt: time.Now().UTC()})
+	return nil
+}
+
+// BreakGlassActive reports whether an emergency bypass with a valid signature is in effect
+func BreakGlassActive() (BreakGlassState, bool) {
+	config := GetConfig()
+	if config.Settings.BreakGlass == nil || !config.Settings.BreakGlass.Enabled {
+		return BreakGlassState{}, false
+	}
+
+	breakGlassCache.Lock()
+	defer breakGlassCache.Unlock()
+
+	if time.Since(breakGlassCache.checkedAt) < breakGlassCheckInterval {
+		return breakGlassCache.state, breakGlassCache.active && time.Now().Before(breakGlassCache.state.ExpiresAt)
+	}
+
+	state, active := readBreakGlass(config)
+	if breakGlassCache.active && !active {
+		go recordBreakGlassEvent("ended", breakGlassCache.state)
+	}
+	breakGlassCache.state = state
+	breakGlassCache.active = active
+	breakGlassCache.checkedAt = time.Now()
+	return state, active
+}
+
+func readBreakGlass(config Configuration) (BreakGlassState, bool) {
+	data, err := RedisClient().Get(context.Background(), breakGlassRedisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return BreakGlassState{}, false
+	} else if err != nil {
+		log.Printf("Error reading break-glass state: %v", err)
+		return BreakGlassState{}, false
+	}
+
+	var state BreakGlassState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Error decoding break-glass state: %v", err)
+		return BreakGlassState{}, false
+	}
+
+	expected := signBreakGlass(state, []byte(config.Secrets.BreakGlassKey))
+	if config.Secrets.BreakGlassKey == "" || !hmac.Equal([]byte(expected), []byte(state.Signature)) {
+		log.Printf("Ignoring break-glass state with an invalid signature")
+		return BreakGlassState{}, false
+	}
+	if !time.Now().Before(state.ExpiresAt) {
+		return BreakGlassState{}, false
+	}
+	return state, true
+}
+
+func signBreakGlass(state BreakGlassState, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%d", state.Reason, state.Operator, state.EnabledAt.UnixNano(), state.ExpiresAt.UnixNano())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordBreakGlassEvent writes an audit log entry regardless of settings.audit_logging
+// and sends an alert to the configured webhook
+func recordBreakGlassEvent(event string, state BreakGlassState) {
+	log.Printf("BREAK-GLASS %s: operator=%s reason=%q expires_at=%v", event, state.Operator, state.Reason, state.ExpiresAt)
+
+	message, _ := json.Marshal(map[string]interface{}{
+		"event":      event,
+		"operator":   state.Operator,
+		"reason":     state.Reason,
+		"enabled_at": state.EnabledAt,
+		"expires_at": state.ExpiresAt,
+	})
+
+	if err := DB().Create(&models.AuditLogs{
+		Message:     string(message),
+		Type:        "break_glass",
+		MessageType: event,
+		ApiKeyID:    uuid.Nil,
+		IPAddress:   "",
+		RequestId:   uuid.New().String(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}).Error; err != nil {
+		log.Printf("Error writing break-glass audit log: %v", err)
+	}
+
+	webhook := GetConfig().Settings.BreakGlass.AlertWebhook
+	if webhook == "" {
+		return
+	}
+	if err := CheckURL(webhook); err != nil {
+		log.Printf("Break-glass alert webhook rejected: %v", err)
+		return
+	}
+
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		log.Printf("Error sending break-glass alert: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(message))
+	if err != nil {
+		log.Printf("Error sending break-glass alert: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error sending break-glass alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Publish sends payload to every replica subscribed to channel
+func Publish(ctx context.Context, channel string, payload string) error {
+	return RedisClient().Publish(ctx, channel, payload).Err()
+}
+
+// Subscribe calls handler for every message published to channel until ctx is
+// done, resubscribing after connection errors.
+func Subscribe(ctx context.Context, channel string, handler func(payload string)) {
+	for {
+		subscribe(ctx, channel, handler)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// subscribe relays the messages of one subscription to handler until ctx is
+// done or the connection is lost
+func subscribe(ctx context.Context, channel string, handler func(payload string)) {
+	pubsub := RedisClient().Subscribe(ctx, channel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.Printf("Error subscribing to %s: %v", channel, err)
+		return
+	}
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			handler(message.Payload)
+		}
+	}
+}
+
+// RedisConfigured reports whether a Redis server is configured
+func RedisConfigured() bool {
+	redis := GetConfig().Settings.Redis
+	return redis != nil && redis.URI != ""
+}
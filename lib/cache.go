@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"net/http"
+	"path"
 	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/cespare/xxhash/v2"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -18,16 +20,7 @@ var redisClient *redis.Client
 func initRedisClient(config *Configuration) {
 	var redisTlsCfg *tls.Config
 	if config.Settings.Redis.SSL {
-		redisTlsCfg = &tls.Config{
-			MinVersion:       tls.VersionTLS12,
-			CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
-		}
+		redisTlsCfg = TLSConfig()
 	}
 
 	opt, err := redis.ParseURL(config.Settings.Redis.URI)
@@ -36,11 +29,64 @@ func initRedisClient(config *Configuration) {
 	}
 
 	opt.TLSConfig = redisTlsCfg
+	opt.Dialer = redisEgressDialer(opt)
 
 	redisClient = redis.NewClient(opt)
 }
 
-func GetCache(key string) ([]byte, bool, error) {
+// RedisClient returns the shared Redis client
+func RedisClient() *redis.Client {
+	if redisClient == nil {
+		config := GetConfig()
+		initRedisClient(&config)
+	}
+	return redisClient
+}
+
+// responseCachePrefix is the prefix of the Redis keys of cached responses
+const responseCachePrefix = "openshield:cache:"
+
+// CacheStats counts the lookups of the response cache of this instance
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+var cacheHits, cacheMisses atomic.Int64
+
+// GetCacheStats returns the hits and misses of the response cache since the start
+func GetCacheStats() CacheStats {
+	return CacheStats{Hits: cacheHits.Load(), Misses: cacheMisses.Load()}
+}
+
+// cacheRedisKey returns the Redis key of key in scope, the model of a completion
+func cacheRedisKey(scope string, key string) string {
+	return responseCachePrefix + scope + ":" + hashKey(key)
+}
+
+// CacheTTL returns how long responses of the route of r are cached, from the
+// first matching route of the cache settings or their ttl, false if they
+// aren't cached. Routes and requests of the /v1 alias are matched by their
+// /openai/v1 path.
+func CacheTTL(r *http.Request) (time.Duration, bool) {
+	cache := GetConfig().Settings.Cache
+	if cache == nil || !cache.Enabled {
+		return 0, false
+	}
+	ttl := cache.TTL
+	requestPath := CanonicalPath(r.URL.Path)
+	for _, route := range cache.Routes {
+		routePath := CanonicalPath(route.Path)
+		if matched, _ := path.Match(routePath, requestPath); matched || routePath == requestPath {
+			ttl = route.TTL
+			break
+		}
+	}
+	return time.Duration(ttl) * time.Second, ttl > 0
+}
+
+// GetCache looks key of scope up in the cache, in a span of the trace of the request of ctx
+func GetCache(ctx context.Context, scope string, key string) ([]byte, bool, error) {
 	config := GetConfig()
 
 	if redisClient == nil {
@@ -48,16 +94,22 @@ func GetCache(key string) ([]byte, bool, error) {
 	}
 
 	if config.Settings.Cache.Enabled {
-		hashedKey := hashKey(key)
-
-		ctx := context.Background()
-		value, err := redisClient.Get(ctx, hashedKey).Bytes()
+		_, span := StartSpan(ctx, "cache.get")
+		value, err := redisClient.Get(context.Background(), cacheRedisKey(scope, key)).Bytes()
+		span.SetAttribute("openshield.cache_hit", strconv.FormatBool(err == nil))
 		if errors.Is(err, redis.Nil) {
+			span.End(nil)
+			cacheMisses.Add(1)
+			IncrCounter("cache.lookups", 1, "result:miss", "scope:"+scope)
 			log.Println("Cache miss")
 			return nil, false, nil
 		} else if err != nil {
+			span.End(err)
 			return nil, false, err
 		}
+		span.End(nil)
+		cacheHits.Add(1)
+		IncrCounter("cache.lookups", 1, "result:hit", "scope:"+scope)
 
 		log.Printf("Cache hit: %s", string(value))
 		return value, true, nil
@@ -67,7 +119,8 @@ func GetCache(key string) ([]byte, bool, error) {
 	}
 }
 
-func SetCache(key string, value interface{}) error {
+// SetCache caches value as key of scope for ttl
+func SetCache(ctx context.Context, scope string, key string, value interface{}, ttl time.Duration) error {
 	config := GetConfig()
 
 	if redisClient == nil {
@@ -75,8 +128,6 @@ func SetCache(key string, value interface{}) error {
 	}
 
 	if config.Settings.Cache.Enabled {
-		hashedKey := hashKey(key)
-
 		// Convert value to JSON if it's not already a []byte
 		var jsonValue []byte
 		var err error
@@ -90,8 +141,7 @@ func SetCache(key string, value interface{}) error {
 			}
 		}
 
-		ctx := context.Background()
-		err = redisClient.Set(ctx, hashedKey, jsonValue, time.Duration(config.Settings.Cache.TTL)*time.Second).Err()
+		err = redisClient.Set(context.WithoutCancel(ctx), cacheRedisKey(scope, key), jsonValue, ttl).Err()
 		if err != nil {
 			return err
 		}
@@ -103,6 +153,36 @@ func SetCache(key string, value interface{}) error {
 	}
 }
 
-func hashKey(key string) string {
-	return strconv.FormatUint(xxhash.Sum64([]byte(key)), 10)
+// InvalidateCache deletes the cached responses of scope, of every scope if it
+// is empty, and returns how many were deleted
+func InvalidateCache(ctx context.Context, scope string) (int64, error) {
+	pattern := responseCachePrefix + "*"
+	if scope != "" {
+		pattern = responseCachePrefix + scope + ":*"
+	}
+	var deleted int64
+	iter := RedisClient().Scan(ctx, 0, pattern, 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 1000 {
+			n, err := RedisClient().Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += n
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	if len(keys) > 0 {
+		n, err := RedisClient().Del(ctx, keys...).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
 }
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheTTL(t *testing.T) {
+	defer func(cache *CacheConfig) { AppConfig.Settings.Cache = cache }(AppConfig.Settings.Cache)
+	completions := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+
+	AppConfig.Settings.Cache = &CacheConfig{Enabled: false, TTL: 60}
+	_, ok := CacheTTL(completions)
+	assert.False(t, ok)
+
+	AppConfig.Settings.Cache = &CacheConfig{Enabled: true, TTL: 60, Routes: []CacheRoute{
+		{Path: "/openai/v1/models/*", TTL: 86400},
+		{Path: "/openai/v1/chat/*", TTL: 0},
+	}}
+	ttl, ok := CacheTTL(httptest.NewRequest("GET", "/openai/v1/models/gpt-4o", nil))
+	assert.True(t, ok)
+	assert.Equal(t, 24*time.Hour, ttl)
+	ttl, ok = CacheTTL(httptest.NewRequest("GET", "/openai/v1/models", nil))
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+	// A route with ttl 0 isn't cached
+	_, ok = CacheTTL(completions)
+	assert.False(t, ok)
+
+	defer func(compatibility *SDKCompatibility) { AppConfig.Settings.SDKCompatibility = compatibility }(AppConfig.Settings.SDKCompatibility)
+	AppConfig.Settings.SDKCompatibility = &SDKCompatibility{Enabled: true}
+	ttl, ok = CacheTTL(httptest.NewRequest("GET", "/v1/models/gpt-4o", nil))
+	assert.True(t, ok)
+	assert.Equal(t, 24*time.Hour, ttl)
+	_, ok = CacheTTL(httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	assert.False(t, ok)
+}
+
+func TestCacheRedisKey(t *testing.T) {
+	assert.Equal(t, cacheRedisKey("gpt-4o", "a"), cacheRedisKey("gpt-4o", "a"))
+	assert.NotEqual(t, cacheRedisKey("gpt-4o", "a"), cacheRedisKey("gpt-4o-mini", "a"))
+	assert.Regexp(t, "^openshield:cache:gpt-4o:", cacheRedisKey("gpt-4o", "a"))
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrCapacityExhausted is returned when a workspace used its share of the upstream capacity and the shared pool is empty
+var ErrCapacityExhausted = errors.New("upstream capacity of the workspace exhausted")
+
+// GetCapacityPartitionSettings returns the capacity partition settings
+func GetCapacityPartitionSettings() CapacityPartitions {
+	if partitions := GetConfig().Settings.CapacityPartitions; partitions != nil {
+		return *partitions
+	}
+	return CapacityPartitions{}
+}
+
+// capacityPartition returns the part of limit guaranteed to a workspace, the
+// unreserved part that forms the shared pool and whether the workspace may draw
+// from the pool
+func capacityPartition(settings CapacityPartitions, limit int, workspaceID uuid.UUID) (int, int, bool) {
+	guaranteed, shared, burst := 0, limit, true
+	for _, workspace := range settings.Workspaces {
+		reserved := int(float64(limit) * workspace.Share)
+		shared -= reserved
+		if workspaceID != uuid.Nil && strings.EqualFold(workspace.Workspace, workspaceID.String()) {
+			guaranteed, burst = reserved, workspace.Burst
+		}
+	}
+	if shared < 0 {
+		shared = 0
+	}
+	return guaranteed, shared, burst
+}
+
+// reserveCapacity counts amount against the guaranteed part of limit of the
+// workspace and what exceeds it against the shared pool. Nothing stays counted
+// when the reservation fails, the returned function gives back a reservation
+// that succeeded.
+func reserveCapacity(ctx context.Context, unit string, limit int, workspaceID uuid.UUID, amount int, minute int64) (func(), bool, error) {
+	guaranteed, shared, burst := capacityPartition(GetCapacityPartitionSettings(), limit, workspaceID)
+
+	workspaceKey := fmt.Sprintf("capacity:%s:%s", unit, workspaceID)
+	poolKey := fmt.Sprintf("capacity:%s:shared", unit)
+	count, err := addMinuteCount(ctx, workspaceKey, minute, amount)
+	if err != nil {
+		return nil, false, err
+	}
+	overflow := count - guaranteed
+	if overflow > amount {
+		overflow = amount
+	}
+	release := func() {
+		addMinuteCount(ctx, workspaceKey, minute, -amount)
+		if overflow > 0 {
+			addMinuteCount(ctx, poolKey, minute, -overflow)
+		}
+	}
+	if overflow <= 0 {
+		return release, true, nil
+	}
+
+	if burst {
+		pooled, err := addMinuteCount(ctx, poolKey, minute, overflow)
+		if err != nil {
+			addMinuteCount(ctx, workspaceKey, minute, -amount)
+			return nil, false, err
+		}
+		if pooled <= shared {
+			return release, true, nil
+		}
+		addMinuteCount(ctx, poolKey, minute, -overflow)
+	}
+	addMinuteCount(ctx, workspaceKey, minute, -amount)
+	return nil, false, nil
+}
+
+// ReserveCapacity takes a request and its estimated tokens from the upstream
+// capacity of the workspace of the request. Errors counting the capacity let
+// the request through.
+func ReserveCapacity(r *http.Request, tokens int) error {
+	settings := GetCapacityPartitionSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+	minute := time.Now().Unix() / 60
+
+	releaseRequest := func() {}
+	if settings.RequestsPerMinute > 0 {
+		release, ok, err := reserveCapacity(r.Context(), "requests", settings.RequestsPerMinute, workspaceID, 1, minute)
+		if err != nil {
+			log.Printf("Error reserving capacity of workspace %s: %v", workspaceID, err)
+			return nil
+		}
+		if !ok {
+			return fmt.Errorf("%w: requests per minute", ErrCapacityExhausted)
+		}
+		releaseRequest = release
+	}
+	if settings.TokensPerMinute > 0 && tokens > 0 {
+		_, ok, err := reserveCapacity(r.Context(), "tokens", settings.TokensPerMinute, workspaceID, tokens, minute)
+		if err != nil {
+			log.Printf("Error reserving capacity of workspace %s: %v", workspaceID, err)
+			return nil
+		}
+		if !ok {
+			// The request isn't sent, so it doesn't use up a request slot either
+			releaseRequest()
+			return fmt.Errorf("%w: tokens per minute", ErrCapacityExhausted)
+		}
+	}
+	return nil
+}
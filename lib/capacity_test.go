@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReserveCapacity(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(partitions *CapacityPartitions) { AppConfig.Settings.CapacityPartitions = partitions }(AppConfig.Settings.CapacityPartitions)
+	AppConfig.Settings.Redis = nil
+	reserved, bursting, noisy := uuid.New(), uuid.New(), uuid.New()
+	AppConfig.Settings.CapacityPartitions = &CapacityPartitions{
+		Enabled:           true,
+		RequestsPerMinute: 10,
+		Workspaces: []WorkspaceCapacity{
+			{Workspace: reserved.String(), Share: 0.3},
+			{Workspace: bursting.String(), Share: 0.2, Burst: true},
+		},
+	}
+	request := func(workspaceID uuid.UUID) error {
+		r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "workspaceId", workspaceID))
+		return ReserveCapacity(r, 0)
+	}
+
+	// The noisy workspace uses up the shared pool of 5 requests
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, request(noisy))
+	}
+	assert.True(t, errors.Is(request(noisy), ErrCapacityExhausted))
+
+	// Reserved shares stay available, without burst beyond them
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, request(reserved))
+	}
+	assert.True(t, errors.Is(request(reserved), ErrCapacityExhausted))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, request(bursting))
+	}
+	assert.True(t, errors.Is(request(bursting), ErrCapacityExhausted))
+}
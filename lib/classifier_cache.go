@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const classifierCacheRedisPrefix = "openshield:classifier:"
+
+// maxLocalClassifierVerdicts bounds the verdicts kept without Redis
+const maxLocalClassifierVerdicts = 10000
+
+type classifierVerdict struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// classifierVerdicts holds the verdicts when Redis isn't configured
+var classifierVerdicts = struct {
+	sync.Mutex
+	entries map[string]classifierVerdict
+}{entries: map[string]classifierVerdict{}}
+
+// GetClassifierCacheSettings returns the classifier cache settings with defaults applied
+func GetClassifierCacheSettings() ClassifierCache {
+	settings := ClassifierCache{}
+	if cache := GetConfig().Settings.ClassifierCache; cache != nil {
+		settings = *cache
+	}
+	if settings.TTL <= 0 {
+		settings.TTL = 3600
+	}
+	return settings
+}
+
+// GetClassifierVerdict returns the cached verdict of a classifier call, content
+// being everything the classifier was sent
+func GetClassifierVerdict(ctx context.Context, content []byte) ([]byte, bool) {
+	if !GetClassifierCacheSettings().Enabled {
+		return nil, false
+	}
+	key := classifierCacheRedisPrefix + hashKey(string(content))
+
+	if RedisConfigured() {
+		value, err := RedisClient().Get(ctx, key).Bytes()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Printf("Error reading classifier verdict: %v", err)
+			}
+			return nil, false
+		}
+		IncrCounter("classifier_cache.hits", 1)
+		return value, true
+	}
+
+	classifierVerdicts.Lock()
+	defer classifierVerdicts.Unlock()
+	verdict, ok := classifierVerdicts.entries[key]
+	if !ok || time.Now().After(verdict.expiresAt) {
+		delete(classifierVerdicts.entries, key)
+		return nil, false
+	}
+	IncrCounter("classifier_cache.hits", 1)
+	return verdict.value, true
+}
+
+// SetClassifierVerdict caches the verdict of a classifier call for the ttl setting
+func SetClassifierVerdict(ctx context.Context, content []byte, value []byte) {
+	settings := GetClassifierCacheSettings()
+	if !settings.Enabled {
+		return
+	}
+	key := classifierCacheRedisPrefix + hashKey(string(content))
+	ttl := time.Duration(settings.TTL) * time.Second
+
+	if RedisConfigured() {
+		if err := RedisClient().Set(ctx, key, value, ttl).Err(); err != nil {
+			log.Printf("Error caching classifier verdict: %v", err)
+		}
+		return
+	}
+
+	classifierVerdicts.Lock()
+	defer classifierVerdicts.Unlock()
+	if len(classifierVerdicts.entries) >= maxLocalClassifierVerdicts {
+		now := time.Now()
+		for k, verdict := range classifierVerdicts.entries {
+			if now.After(verdict.expiresAt) {
+				delete(classifierVerdicts.entries, k)
+			}
+		}
+		if len(classifierVerdicts.entries) >= maxLocalClassifierVerdicts {
+			return
+		}
+	}
+	classifierVerdicts.entries[key] = classifierVerdict{value: value, expiresAt: time.Now().Add(ttl)}
+}
@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifierVerdicts(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(cache *ClassifierCache) { AppConfig.Settings.ClassifierCache = cache }(AppConfig.Settings.ClassifierCache)
+	AppConfig.Settings.Redis = nil
+	ctx := context.Background()
+
+	AppConfig.Settings.ClassifierCache = &ClassifierCache{Enabled: false}
+	SetClassifierVerdict(ctx, []byte("prompt"), []byte(`{"match":true}`))
+	_, ok := GetClassifierVerdict(ctx, []byte("prompt"))
+	assert.False(t, ok)
+
+	AppConfig.Settings.ClassifierCache = &ClassifierCache{Enabled: true}
+	SetClassifierVerdict(ctx, []byte("prompt"), []byte(`{"match":true}`))
+	verdict, ok := GetClassifierVerdict(ctx, []byte("prompt"))
+	assert.True(t, ok)
+	assert.Equal(t, `{"match":true}`, string(verdict))
+
+	_, ok = GetClassifierVerdict(ctx, []byte("another prompt"))
+	assert.False(t, ok)
+}
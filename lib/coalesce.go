@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	goopenai "github.com/sashabaranov/go-openai"
+	"golang.org/x/sync/singleflight"
+)
+
+var completionGroup singleflight.Group
+
+// RequestCoalescingEnabled reports whether identical concurrent completions share one upstream call
+func RequestCoalescingEnabled() bool {
+	coalescing := GetConfig().Settings.RequestCoalescing
+	return coalescing != nil && coalescing.Enabled
+}
+
+// CoalesceKey hashes the normalized request, scoped to the API key of the
+// request so coalesced responses never cross tenants
+func CoalesceKey(r *http.Request, req goopenai.ChatCompletionRequest) (string, error) {
+	apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	// Encoding the decoded request drops whitespace and key order differences of the body
+	normalized, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(apiKeyId.String()+":"), normalized...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CoalesceCompletion runs create once for concurrent calls with the same key and
+// hands each caller its own copy of the response. It reports whether the
+// response was shared. The upstream call isn't canceled when the caller that
+// started it goes away, the others are still waiting for it.
+func CoalesceCompletion(ctx context.Context, key string, create func(context.Context) (goopenai.ChatCompletionResponse, error)) (goopenai.ChatCompletionResponse, bool, error) {
+	results := completionGroup.DoChan(key, func() (interface{}, error) {
+		callCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			defer cancel()
+		}
+		resp, err := create(callCtx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return goopenai.ChatCompletionResponse{}, result.Shared, result.Err
+		}
+		// Callers scrub and redact their response, so each decodes a copy
+		var resp goopenai.ChatCompletionResponse
+		if err := json.Unmarshal(result.Val.([]byte), &resp); err != nil {
+			return goopenai.ChatCompletionResponse{}, result.Shared, err
+		}
+		return resp, result.Shared, nil
+	case <-ctx.Done():
+		return goopenai.ChatCompletionResponse{}, false, ctx.Err()
+	}
+}
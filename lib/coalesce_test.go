@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	req := goopenai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []goopenai.ChatCompletionMessage{{Role: goopenai.ChatMessageRoleUser, Content: "Hello"}},
+	}
+	first := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	first = first.WithContext(context.WithValue(first.Context(), "apiKeyId", uuid.New()))
+	second := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	second = second.WithContext(context.WithValue(second.Context(), "apiKeyId", uuid.New()))
+
+	key, err := CoalesceKey(first, req)
+	assert.NoError(t, err)
+	same, _ := CoalesceKey(first, req)
+	assert.Equal(t, key, same)
+	other, _ := CoalesceKey(second, req)
+	assert.NotEqual(t, key, other)
+
+	req.Temperature = 0.5
+	changed, _ := CoalesceKey(first, req)
+	assert.NotEqual(t, key, changed)
+}
+
+func TestCoalesceCompletion(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	create := func(ctx context.Context) (goopenai.ChatCompletionResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return goopenai.ChatCompletionResponse{
+			ID:      "chatcmpl-1",
+			Choices: []goopenai.ChatCompletionChoice{{Message: goopenai.ChatCompletionMessage{Content: "Hi"}}},
+		}, nil
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]goopenai.ChatCompletionResponse, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _, err := CoalesceCompletion(context.Background(), "coalesce-test", create)
+			assert.NoError(t, err)
+			responses[i] = resp
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	responses[0].Choices[0].Message.Content = "redacted"
+	assert.Equal(t, "Hi", responses[1].Choices[0].Message.Content)
+	assert.Equal(t, "Hi", responses[2].Choices[0].Message.Content)
+}
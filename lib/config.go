@@ -26,27 +26,133 @@ type Configuration struct {
 
 // Providers section contains all the providers
 type Providers struct {
-	OpenAI      *FeatureToggle `mapstructure:"openai"`
-	HuggingFace *FeatureToggle `mapstructure:"huggingface"`
+	OpenAI      *ProviderConfig `mapstructure:"openai"`
+	HuggingFace *ProviderConfig `mapstructure:"huggingface"`
+	Cohere      *ProviderConfig `mapstructure:"cohere"`
+	Voyage      *ProviderConfig `mapstructure:"voyage"`
+	Anthropic   *ProviderConfig `mapstructure:"anthropic"`
+}
+
+// ProviderConfig holds the configuration of an upstream provider. BaseURL
+// replaces the default API endpoint of the provider. Azure, only read for the
+// OpenAI provider, serves its routes from Azure OpenAI deployments instead.
+type ProviderConfig struct {
+	Enabled       bool                `mapstructure:"enabled,default=false"`
+	BaseURL       string              `mapstructure:"base_url,omitempty"`
+	Proxy         string              `mapstructure:"proxy,omitempty"`
+	CABundle      string              `mapstructure:"ca_bundle,omitempty"`
+	Timeouts      *Timeouts           `mapstructure:"timeouts"`
+	ModelTimeouts map[string]Timeouts `mapstructure:"model_timeouts"`
+	Azure         *AzureOpenAI        `mapstructure:"azure"`
+}
+
+// AzureOpenAI holds the Azure OpenAI resource behind the OpenAI routes. Endpoint
+// replaces https://<resource>.openai.azure.com, Deployments maps model names to
+// deployment IDs, unmapped models are sent to the deployment of the same name.
+// Auth is "api_key", sent in the api-key header, or "entra_id" for a bearer token,
+// AZURE_OPENAI_AD_TOKEN or one fetched for a service principal or managed identity.
+type AzureOpenAI struct {
+	Resource    string            `mapstructure:"resource,omitempty"`
+	Endpoint    string            `mapstructure:"endpoint,omitempty"`
+	APIVersion  string            `mapstructure:"api_version,default=2024-10-21"`
+	Deployments map[string]string `mapstructure:"deployments"`
+	Auth        string            `mapstructure:"auth,default=api_key"`
+}
+
+// Timeouts holds upstream request timeouts in seconds, zero means no limit.
+// StreamIdle is the longest wait between two chunks of a streamed response.
+type Timeouts struct {
+	Connect        int `mapstructure:"connect,omitempty"`
+	ResponseHeader int `mapstructure:"response_header,omitempty"`
+	Total          int `mapstructure:"total,omitempty"`
+	StreamIdle     int `mapstructure:"stream_idle,omitempty"`
 }
 
 // Secrets section contains all the secrets
 type Secrets struct {
 	OpenAIApiKey      string `mapstructure:"openai_api_key"`
 	HuggingFaceAPIKey string `mapstructure:"huggingface_api_key"`
+	SCIMToken         string `mapstructure:"scim_token"`
+	BreakGlassKey     string `mapstructure:"break_glass_key"`
+	AlternateAPIKey   string `mapstructure:"alternate_api_key"`
+	ProbeAPIKey       string `mapstructure:"probe_api_key"`
+	CohereAPIKey      string `mapstructure:"cohere_api_key"`
+	VoyageAPIKey      string `mapstructure:"voyage_api_key"`
+	PolicyOverrideKey string `mapstructure:"policy_override_key"`
+	AnthropicAPIKey   string `mapstructure:"anthropic_api_key"`
+	ArchiveAccessKey  string `mapstructure:"archive_access_key"`
+	ArchiveSecretKey  string `mapstructure:"archive_secret_key"`
+	ArchiveToken      string `mapstructure:"archive_token"`
 }
 
 // Setting can include various configurations like database, cache, and different logging types
 type Setting struct {
-	Redis               *RedisConfig    `mapstructure:"redis"`
-	Database            *DatabaseConfig `mapstructure:"database"`
-	Cache               *CacheConfig    `mapstructure:"cache"`
-	AuditLogging        *FeatureToggle  `mapstructure:"audit_logging,default=false"`
-	UsageLogging        *FeatureToggle  `mapstructure:"usage_logging,default=false"`
-	Network             *Network        `mapstructure:"network"`
-	RateLimit           *RateLimiting   `mapstructure:"rate_limiting"`
-	RuleServer          *RuleServer     `mapstructure:"rule_server"`
-	EnglishDetectionURL string          `mapstructure:"english_detection_url"`
+	Redis                 *RedisConfig           `mapstructure:"redis"`
+	Database              *DatabaseConfig        `mapstructure:"database"`
+	Cache                 *CacheConfig           `mapstructure:"cache"`
+	AuditLogging          *FeatureToggle         `mapstructure:"audit_logging,default=false"`
+	UsageLogging          *FeatureToggle         `mapstructure:"usage_logging,default=false"`
+	Network               *Network               `mapstructure:"network"`
+	RateLimit             *RateLimiting          `mapstructure:"rate_limiting"`
+	RuleServer            *RuleServer            `mapstructure:"rule_server"`
+	EnglishDetectionURL   string                 `mapstructure:"english_detection_url"`
+	URLPolicy             *URLPolicy             `mapstructure:"url_policy"`
+	Egress                *Egress                `mapstructure:"egress"`
+	Crypto                *Crypto                `mapstructure:"crypto"`
+	SCIM                  *FeatureToggle         `mapstructure:"scim"`
+	Admin                 *AdminSettings         `mapstructure:"admin"`
+	ConfigSync            *FeatureToggle         `mapstructure:"config_sync"`
+	ConfigRollout         *ConfigRollout         `mapstructure:"config_rollout"`
+	Jobs                  map[string]JobConfig   `mapstructure:"jobs"`
+	LatencySLO            *LatencySLO            `mapstructure:"latency_slo"`
+	WriteQueue            *WriteQueue            `mapstructure:"write_queue"`
+	Outbox                *Outbox                `mapstructure:"outbox"`
+	BreakGlass            *BreakGlass            `mapstructure:"break_glass"`
+	AdaptiveConcurrency   *AdaptiveConcurrency   `mapstructure:"adaptive_concurrency"`
+	ResponseLimits        *ResponseLimits        `mapstructure:"response_limits"`
+	ScrubProviderMetadata *FeatureToggle         `mapstructure:"scrub_provider_metadata"`
+	StrictContent         *StrictContent         `mapstructure:"strict_content"`
+	RAGContext            *RAGContext            `mapstructure:"rag_context"`
+	VectorStores          map[string]VectorStore `mapstructure:"vector_stores"`
+	RAGPipelines          map[string]RAGPipeline `mapstructure:"rag_pipelines"`
+	ToolPolicy            *ToolPolicy            `mapstructure:"tool_policy"`
+	SessionRisk           *SessionRisk           `mapstructure:"session_risk"`
+	Schedules             []Schedule             `mapstructure:"schedules"`
+	KeyBurnIn             *KeyBurnIn             `mapstructure:"key_burn_in"`
+	ModelApproval         *ModelApproval         `mapstructure:"model_approval"`
+	ModelCatalog          []ModelMetadata        `mapstructure:"model_catalog"`
+	ContextWindow         *ContextWindow         `mapstructure:"context_window"`
+	PromptCompression     *PromptCompression     `mapstructure:"prompt_compression"`
+	RequestCoalescing     *FeatureToggle         `mapstructure:"request_coalescing"`
+	Racing                []RaceRoute            `mapstructure:"racing"`
+	SpendingCaps          []SpendingCap          `mapstructure:"spending_caps"`
+	CapacityPartitions    *CapacityPartitions    `mapstructure:"capacity_partitions"`
+	StreamingInspection   *StreamingInspection   `mapstructure:"streaming_inspection"`
+	UploadBuffering       *UploadBuffering       `mapstructure:"upload_buffering"`
+	Watchdog              *Watchdog              `mapstructure:"watchdog"`
+	ErrorTracking         *ErrorTracking         `mapstructure:"error_tracking"`
+	Tracing               *Tracing               `mapstructure:"tracing"`
+	AccessLog             *AccessLog             `mapstructure:"access_log"`
+	LogFile               *LogFile               `mapstructure:"log_file"`
+	Observability         *Observability         `mapstructure:"observability"`
+	SyntheticProbes       *SyntheticProbes       `mapstructure:"synthetic_probes"`
+	SDKCompatibility      *SDKCompatibility      `mapstructure:"sdk_compatibility"`
+	FineTuning            *FineTuning            `mapstructure:"fine_tuning"`
+	Rerank                *Rerank                `mapstructure:"rerank"`
+	ClassifierCache       *ClassifierCache       `mapstructure:"classifier_cache"`
+	Detectors             *Detectors             `mapstructure:"detectors"`
+	PolicyOverrides       *PolicyOverrides       `mapstructure:"policy_overrides"`
+	RequestDiffs          *RequestDiffs          `mapstructure:"request_diffs"`
+	ContentHashes         *ContentHashing        `mapstructure:"content_hashes"`
+	Archive               *Archive               `mapstructure:"archive"`
+	UsageTiering          *UsageTiering          `mapstructure:"usage_tiering"`
+	StreamOutputRules     *StreamOutputRules     `mapstructure:"stream_output_rules"`
+	UsageAggregates       *UsageAggregates       `mapstructure:"usage_aggregates"`
+	ExtAuthz              *ExtAuthz              `mapstructure:"ext_authz"`
+	SemanticCache         *SemanticCache         `mapstructure:"semantic_cache"`
+	WorkloadIdentity      *WorkloadIdentity      `mapstructure:"workload_identity"`
+	FinishReasons         *FinishReasons         `mapstructure:"finish_reasons"`
+	RuleExecutions        *RuleExecutions        `mapstructure:"rule_executions"`
 }
 
 type RuleServer struct {
@@ -58,6 +164,10 @@ type RateLimiting struct {
 	Window     int `mapstructure:"window"`
 	Max        int `mapstructure:"max"`
 	Expiration int `mapstructure:"expiration"`
+	// KeyBy counts the requests per ip, api_key, product or workspace
+	KeyBy string `mapstructure:"key_by,default=ip"`
+	// Tiers are the limits per window of API keys with their rate_limit_tier
+	Tiers map[string]int `mapstructure:"tiers"`
 }
 
 // RedisConfig holds configuration for the redis cache
@@ -66,9 +176,617 @@ type RedisConfig struct {
 	SSL bool   `mapstructure:"ssl,default=false"`
 }
 
-// Network holds configuration for network settings
+// URLPolicy holds the policy applied to URLs the gateway fetches or forwards
+type URLPolicy struct {
+	AllowedDomains       []string `mapstructure:"allowed_domains"`
+	AllowPrivateNetworks bool     `mapstructure:"allow_private_networks,default=false"`
+}
+
+// Egress holds the allowlist of destinations the gateway may connect to
+type Egress struct {
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+}
+
+// Crypto selects the crypto profile, either "default" or "fips"
+type Crypto struct {
+	Profile string `mapstructure:"profile,default=default"`
+}
+
+// AdminSettings holds configuration for admin logins, durations are in seconds
+type AdminSettings struct {
+	SessionTTL      int `mapstructure:"session_ttl,default=3600"`
+	MaxFailedLogins int `mapstructure:"max_failed_logins,default=5"`
+	LockoutDuration int `mapstructure:"lockout_duration,default=900"`
+}
+
+// AdaptiveConcurrency holds the AIMD limits on concurrent requests to each upstream host.
+// The latency threshold and queue timeout are in milliseconds. Requests waiting
+// for a slot are served by weighted fair queuing across API keys.
+type AdaptiveConcurrency struct {
+	Enabled            bool          `mapstructure:"enabled,default=false"`
+	InitialLimit       int           `mapstructure:"initial_limit,default=20"`
+	MinLimit           int           `mapstructure:"min_limit,default=1"`
+	MaxLimit           int           `mapstructure:"max_limit,default=200"`
+	BackoffRatio       float64       `mapstructure:"backoff_ratio,default=0.9"`
+	LatencyThresholdMs int           `mapstructure:"latency_threshold_ms,default=10000"`
+	QueueTimeoutMs     int           `mapstructure:"queue_timeout_ms,default=5000"`
+	QueueWeights       []QueueWeight `mapstructure:"queue_weights"`
+}
+
+// QueueWeight is the fair queuing weight of an API key, keys that aren't listed weigh 1
+type QueueWeight struct {
+	APIKey string  `mapstructure:"api_key"`
+	Weight float64 `mapstructure:"weight"`
+}
+
+// ResponseLimits caps the size of each completion returned to clients. The policy
+// is "truncate", which cuts the completion and appends the marker, or "error".
+type ResponseLimits struct {
+	MaxBytes  int    `mapstructure:"max_bytes,omitempty"`
+	MaxTokens int    `mapstructure:"max_tokens,omitempty"`
+	Policy    string `mapstructure:"policy,default=truncate"`
+	Marker    string `mapstructure:"marker,default=[truncated]"`
+}
+
+// StrictContent is the content profile of products exposed to minors. Their rules
+// use the strict threshold, block on every match and apply during break-glass,
+// and the listed models and request parameters are rejected.
+type StrictContent struct {
+	BlockedModels      []string `mapstructure:"blocked_models"`
+	DisabledParameters []string `mapstructure:"disabled_parameters"`
+	MaxTemperature     float64  `mapstructure:"max_temperature,omitempty"`
+}
+
+// RAGContext detects retrieved documents in prompts, either wrapped in
+// <tag id="..." collection="..."> blocks or listed in a request body field
+// as objects with id, collection and content
+type RAGContext struct {
+	Enabled bool   `mapstructure:"enabled,default=false"`
+	Tag     string `mapstructure:"tag,default=document"`
+	Field   string `mapstructure:"field"`
+}
+
+// VectorStore is a vector database whose query endpoints are proxied at
+// /vectordb/{name}/. Type is "pinecone", "qdrant" or "weaviate", the API key is
+// read from the APIKeyEnv environment variable.
+type VectorStore struct {
+	Type           string   `mapstructure:"type"`
+	URL            string   `mapstructure:"url"`
+	APIKeyEnv      string   `mapstructure:"api_key_env"`
+	Products       []string `mapstructure:"products"`
+	IsolateTenants bool     `mapstructure:"isolate_tenants,default=false"`
+	TenantKey      string   `mapstructure:"tenant_key,omitempty"`
+	Proxy          string   `mapstructure:"proxy,omitempty"`
+	CABundle       string   `mapstructure:"ca_bundle,omitempty"`
+}
+
+// RAGPipeline is a retrieval pipeline of the /openshield/v1/rag/completions endpoint.
+// Template is a text/template of the system prompt, it gets .Question and .Documents.
+type RAGPipeline struct {
+	VectorStore    string   `mapstructure:"vector_store"`
+	Collection     string   `mapstructure:"collection"`
+	ContentField   string   `mapstructure:"content_field,default=text"`
+	EmbeddingModel string   `mapstructure:"embedding_model"`
+	Model          string   `mapstructure:"model"`
+	TopK           int      `mapstructure:"top_k,default=4"`
+	Template       string   `mapstructure:"template"`
+	Products       []string `mapstructure:"products"`
+}
+
+// ToolPolicy checks the tool calls proposed by models before they reach the client.
+// Actions are "allow", "deny" or "approve", which holds the response until an
+// admin decides or ApprovalTimeout seconds pass. The timeout has to stay below
+// the 60 second request timeout of the server.
+type ToolPolicy struct {
+	Enabled         bool       `mapstructure:"enabled,default=false"`
+	DefaultAction   string     `mapstructure:"default_action,default=allow"`
+	ApprovalTimeout int        `mapstructure:"approval_timeout,default=45"`
+	Tools           []ToolRule `mapstructure:"tools"`
+}
+
+// ToolRule is the policy of one tool. Schema is a JSON schema of the arguments
+// as a JSON string, Arguments are regular expressions for single arguments.
+type ToolRule struct {
+	Name      string         `mapstructure:"name"`
+	Action    string         `mapstructure:"action,default=allow"`
+	Arguments []ArgumentRule `mapstructure:"arguments"`
+	Schema    string         `mapstructure:"schema"`
+}
+
+// ArgumentRule is a regular expression the value of a tool argument must match
+type ArgumentRule struct {
+	Name    string `mapstructure:"name"`
+	Pattern string `mapstructure:"pattern"`
+}
+
+// SessionRisk adds up the rule hits of an API key and escalates once the score
+// crosses a threshold. The session header only names the conversation in the
+// audit log. The score expires TTL seconds after the last hit.
+type SessionRisk struct {
+	Enabled    bool            `mapstructure:"enabled,default=false"`
+	Header     string          `mapstructure:"header,default=OS-Session-ID"`
+	TTL        int             `mapstructure:"ttl,default=3600"`
+	Thresholds []RiskThreshold `mapstructure:"thresholds"`
+}
+
+// RiskThreshold is an escalation of a session, the action is "strict", "review" or "terminate"
+type RiskThreshold struct {
+	Score  float64 `mapstructure:"score"`
+	Action string  `mapstructure:"action"`
+}
+
+// Schedule changes the rules and limits of requests inside a weekly time window.
+// Start and End are "15:04" times in the timezone, a window that ends before it
+// starts runs past midnight. Days are weekdays like "mon" on which the window
+// starts, every day if empty. RateLimit is in requests per minute and API key.
+type Schedule struct {
+	Enabled    bool     `mapstructure:"enabled,default=false"`
+	Name       string   `mapstructure:"name"`
+	Workspaces []string `mapstructure:"workspaces"`
+	Timezone   string   `mapstructure:"timezone,default=UTC"`
+	Days       []string `mapstructure:"days"`
+	Start      string   `mapstructure:"start"`
+	End        string   `mapstructure:"end"`
+	Strict     bool     `mapstructure:"strict,default=false"`
+	Review     bool     `mapstructure:"review,default=false"`
+	RateLimit  int      `mapstructure:"rate_limit,omitempty"`
+}
+
+// KeyBurnIn restricts API keys for Duration seconds after they were created.
+// RateLimit is in requests per minute, LogRequests audit logs their requests and
+// responses even when audit logging is disabled.
+type KeyBurnIn struct {
+	Enabled     bool `mapstructure:"enabled,default=false"`
+	Duration    int  `mapstructure:"duration,default=604800"`
+	RateLimit   int  `mapstructure:"rate_limit,default=10"`
+	Strict      bool `mapstructure:"strict,default=true"`
+	LogRequests bool `mapstructure:"log_requests,default=true"`
+}
+
+// ModelApproval keeps models discovered by the catalog sync pending until an
+// admin approves them for a workspace. BlockUnknown also rejects models that
+// aren't in the catalog at all.
+type ModelApproval struct {
+	Enabled      bool `mapstructure:"enabled,default=false"`
+	BlockUnknown bool `mapstructure:"block_unknown,default=false"`
+}
+
+// ModelMetadata describes a model in the catalog. It overrides the built-in
+// metadata of the model and of its dated snapshots. Prices are in USD per million
+// tokens and the deprecation date is formatted as 2006-01-02.
+type ModelMetadata struct {
+	Model           string   `mapstructure:"model"`
+	ContextWindow   int      `mapstructure:"context_window,omitempty"`
+	InputPrice      float64  `mapstructure:"input_price,omitempty"`
+	OutputPrice     float64  `mapstructure:"output_price,omitempty"`
+	Modalities      []string `mapstructure:"modalities"`
+	DeprecationDate string   `mapstructure:"deprecation_date,omitempty"`
+}
+
+// ContextWindow checks that requests fit the context window of their model
+// before they are sent. The policy is "reject", "truncate", which drops the
+// oldest messages except system messages, or "summarize", which replaces the
+// messages before the last KeepMessages with a summary by SummaryModel.
+type ContextWindow struct {
+	Enabled      bool   `mapstructure:"enabled,default=false"`
+	Policy       string `mapstructure:"policy,default=reject"`
+	SummaryModel string `mapstructure:"summary_model,default=gpt-4o-mini"`
+	KeepMessages int    `mapstructure:"keep_messages,default=4"`
+}
+
+// PromptCompression shrinks the prompts of the listed products, all products if
+// empty. Strategies are "whitespace" and "dedupe", which drops paragraphs that
+// repeat earlier ones.
+type PromptCompression struct {
+	Enabled    bool     `mapstructure:"enabled,default=false"`
+	Products   []string `mapstructure:"products"`
+	Strategies []string `mapstructure:"strategies"`
+}
+
+// RaceRoute sends the non-streamed requests for a model to the OpenAI provider
+// and an OpenAI compatible alternate at once, the first successful response
+// wins. The alternate uses secrets.alternate_api_key, or the OpenAI key if unset.
+type RaceRoute struct {
+	Enabled        bool     `mapstructure:"enabled,default=false"`
+	Model          string   `mapstructure:"model"`
+	BaseURL        string   `mapstructure:"base_url"`
+	AlternateModel string   `mapstructure:"alternate_model,omitempty"`
+	Products       []string `mapstructure:"products"`
+}
+
+// SpendingCap routes the requests for the listed models of a provider to their
+// cheaper fallback once the monthly spend, in USD from the prices of the model
+// catalog, reaches Threshold of MonthlyCap (0.9 if unset). Requests are never
+// refused for the spend.
+type SpendingCap struct {
+	Enabled    bool             `mapstructure:"enabled,default=false"`
+	Provider   string           `mapstructure:"provider"`
+	MonthlyCap float64          `mapstructure:"monthly_cap"`
+	Threshold  float64          `mapstructure:"threshold,default=0.9"`
+	Downgrades []ModelDowngrade `mapstructure:"downgrades"`
+}
+
+// ModelDowngrade is the cheaper model requests for Model are sent to
+type ModelDowngrade struct {
+	Model    string `mapstructure:"model"`
+	Fallback string `mapstructure:"fallback"`
+}
+
+// CapacityPartitions splits the upstream capacity per minute between workspaces.
+// Each listed workspace is guaranteed its share of the limits, what isn't
+// reserved forms a shared pool that unlisted workspaces and listed ones with
+// burst draw from. A zero limit isn't enforced.
+type CapacityPartitions struct {
+	Enabled           bool                `mapstructure:"enabled,default=false"`
+	RequestsPerMinute int                 `mapstructure:"requests_per_minute"`
+	TokensPerMinute   int                 `mapstructure:"tokens_per_minute"`
+	Workspaces        []WorkspaceCapacity `mapstructure:"workspaces"`
+}
+
+// WorkspaceCapacity is the share of the upstream capacity reserved for a workspace, between 0 and 1
+type WorkspaceCapacity struct {
+	Workspace string  `mapstructure:"workspace"`
+	Share     float64 `mapstructure:"share"`
+	Burst     bool    `mapstructure:"burst,default=false"`
+}
+
+// StreamingInspection inspects chat completions larger than ThresholdBytes with
+// a streaming scanner over their messages, leaving inline media out of what the
+// rules see. Requests the gateway doesn't change are then sent as they came.
+// The body is held once, bodies over MaxBodyBytes are refused, 0 for no limit.
+type StreamingInspection struct {
+	Enabled        bool  `mapstructure:"enabled,default=false"`
+	MaxBodyBytes   int64 `mapstructure:"max_body_bytes,default=0"`
+	ThresholdBytes int   `mapstructure:"threshold_bytes,default=1048576"`
+}
+
+// UploadBuffering limits the file uploads held by the gateway. Uploads larger
+// than MemoryBytes are spooled to temporary files in Dir, and MaxSpoolBytes is
+// the disk space all spooled uploads may take together.
+type UploadBuffering struct {
+	MemoryBytes   int64  `mapstructure:"memory_bytes,default=4194304"`
+	MaxBytes      int64  `mapstructure:"max_bytes,default=26214400"`
+	MaxSpoolBytes int64  `mapstructure:"max_spool_bytes,default=1073741824"`
+	Dir           string `mapstructure:"dir,omitempty"`
+}
+
+// Watchdog sheds traffic while the heap or the goroutine count of the process is
+// over its limit. Shed lists route prefixes from the lowest priority: the first
+// is shed at the limit, one more for every tenth over it.
+type Watchdog struct {
+	Enabled        bool     `mapstructure:"enabled,default=false"`
+	MaxHeapBytes   uint64   `mapstructure:"max_heap_bytes,default=2147483648"`
+	MaxGoroutines  int      `mapstructure:"max_goroutines,default=10000"`
+	Interval       int      `mapstructure:"interval,default=5"`
+	RetryAfter     int      `mapstructure:"retry_after,default=10"`
+	Shed           []string `mapstructure:"shed"`
+	DiagnosticsDir string   `mapstructure:"diagnostics_dir,omitempty"`
+}
+
+// ErrorTracking sends the crash reports of recovered panics to a webhook
+type ErrorTracking struct {
+	Enabled bool   `mapstructure:"enabled,default=false"`
+	Webhook string `mapstructure:"webhook,omitempty"`
+}
+
+// Tracing samples SampleRate of the requests when they start, and keeps the
+// traces of requests that failed or were blocked by a rule on top of those.
+type Tracing struct {
+	Enabled          bool    `mapstructure:"enabled,default=false"`
+	SampleRate       float64 `mapstructure:"sample_rate,default=0.1"`
+	SampleErrors     bool    `mapstructure:"sample_errors,default=true"`
+	SampleViolations bool    `mapstructure:"sample_violations,default=true"`
+}
+
+// AccessLog writes a line per request to Path, "stdout" or "stderr", in the
+// common, combined, json or w3c format. The file is reopened on SIGHUP.
+type AccessLog struct {
+	Enabled  bool         `mapstructure:"enabled,default=false"`
+	Path     string       `mapstructure:"path,default=stdout"`
+	Format   string       `mapstructure:"format,default=combined"`
+	Rotation *LogRotation `mapstructure:"rotation"`
+}
+
+// LogFile sends the application and request logs to Path instead of the console
+type LogFile struct {
+	Path     string       `mapstructure:"path,omitempty"`
+	Rotation *LogRotation `mapstructure:"rotation"`
+}
+
+// LogRotation rotates a log file once it reaches MaxSizeMB. Rotated files are
+// removed after MaxAgeDays or past MaxBackups, 0 keeps them.
+type LogRotation struct {
+	MaxSizeMB  int  `mapstructure:"max_size_mb,default=100"`
+	MaxAgeDays int  `mapstructure:"max_age_days,default=30"`
+	MaxBackups int  `mapstructure:"max_backups,default=10"`
+	Compress   bool `mapstructure:"compress,default=true"`
+}
+
+// Observability selects where metrics and kept traces are pushed
+type Observability struct {
+	Metrics *MetricsExporter `mapstructure:"metrics"`
+	Traces  *TracesExporter  `mapstructure:"traces"`
+}
+
+// MetricsExporter pushes metrics to a DogStatsD agent at Address. Tags are
+// added to every metric.
+type MetricsExporter struct {
+	Exporter string   `mapstructure:"exporter,omitempty"`
+	Address  string   `mapstructure:"address,default=127.0.0.1:8125"`
+	Prefix   string   `mapstructure:"prefix,default=openshield"`
+	Tags     []string `mapstructure:"tags"`
+}
+
+// TracesExporter pushes the traces kept by the sampler to a Datadog agent at
+// AgentURL, or with the otlp exporter to the OTLP/HTTP collector at Endpoint,
+// sent Headers such as an authentication token
+type TracesExporter struct {
+	Exporter string            `mapstructure:"exporter,omitempty"`
+	AgentURL string            `mapstructure:"agent_url,default=http://127.0.0.1:8126"`
+	Endpoint string            `mapstructure:"endpoint,default=http://127.0.0.1:4318"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Service  string            `mapstructure:"service,default=openshield"`
+	Env      string            `mapstructure:"env,omitempty"`
+}
+
+// SyntheticProbes send canned chat completions through the gateway every
+// Interval seconds with the probe API key of the secrets
+type SyntheticProbes struct {
+	Enabled  bool             `mapstructure:"enabled,default=false"`
+	Interval int              `mapstructure:"interval,default=300"`
+	Timeout  int              `mapstructure:"timeout,default=30"`
+	Probes   []SyntheticProbe `mapstructure:"probes"`
+}
+
+// SyntheticProbe is a canned prompt for a model. Expect is "allowed" when the
+// provider must answer it, or "blocked" when a rule must block it.
+type SyntheticProbe struct {
+	Name   string `mapstructure:"name"`
+	Model  string `mapstructure:"model"`
+	Prompt string `mapstructure:"prompt"`
+	Expect string `mapstructure:"expect,default=allowed"`
+}
+
+// SDKCompatibility serves the OpenAI routes at /v1 as well, so the gateway
+// root can be the base_url of the official OpenAI SDKs
+type SDKCompatibility struct {
+	Enabled bool `mapstructure:"enabled,default=false"`
+}
+
+// FineTuning limits the scan of the training files of fine-tuning jobs. Every
+// example of a file is checked by the rules, and files with more than
+// MaxExamples examples or MaxFileBytes bytes are refused.
+type FineTuning struct {
+	MaxExamples  int   `mapstructure:"max_examples,default=1000"`
+	MaxFileBytes int64 `mapstructure:"max_file_bytes,default=104857600"`
+}
+
+// Rerank picks the provider of the rerank route by model, "cohere" or
+// "voyage". Models not listed go to Provider.
+type Rerank struct {
+	Provider string            `mapstructure:"provider,default=cohere"`
+	Models   map[string]string `mapstructure:"models"`
+}
+
+// ClassifierCache reuses the verdicts of the rule server for the same rule and
+// prompt for TTL seconds, in Redis when it is configured
+type ClassifierCache struct {
+	Enabled bool `mapstructure:"enabled,default=false"`
+	TTL     int  `mapstructure:"ttl,default=3600"`
+}
+
+// Detectors are models scoring text in the gateway process, for rules of the
+// detector type. SharedLibrary is the path of the ONNX Runtime library.
+type Detectors struct {
+	SharedLibrary string          `mapstructure:"shared_library,omitempty"`
+	Models        []DetectorModel `mapstructure:"models"`
+}
+
+// DetectorModel is a text classifier exported to ONNX with its WordPiece
+// vocabulary. Label is the output class that counts as a detection.
+type DetectorModel struct {
+	Name      string `mapstructure:"name"`
+	Format    string `mapstructure:"format,default=onnx"`
+	Path      string `mapstructure:"path"`
+	Vocab     string `mapstructure:"vocab"`
+	MaxLength int    `mapstructure:"max_length,default=256"`
+	Label     int    `mapstructure:"label,default=1"`
+	Output    string `mapstructure:"output,default=logits"`
+}
+
+// PolicyOverrides holds configuration for the signed tokens letting trusted
+// services skip named rules, MaxDuration is the longest validity in seconds
+type PolicyOverrides struct {
+	Enabled     bool `mapstructure:"enabled,default=false"`
+	MaxDuration int  `mapstructure:"max_duration,default=3600"`
+}
+
+// RequestDiffs keeps the client and upstream payloads of chat completions for
+// TTL seconds, with the changes the gateway made, in Redis when it is configured
+type RequestDiffs struct {
+	Enabled bool `mapstructure:"enabled,default=false"`
+	TTL     int  `mapstructure:"ttl,default=3600"`
+}
+
+// ContentHashing stores salted hashes of the exact provider request and
+// response with each usage row, so disputed outputs can be checked later
+type ContentHashing struct {
+	Enabled bool `mapstructure:"enabled,default=false"`
+}
+
+// Archive moves audit log bodies of at least MinBytes out of the database into
+// a blob store. Bucket is the S3 or GCS bucket, the Azure container or the
+// directory of the file backend. StorageClass is the storage class, or access
+// tier on Azure, of new blobs, the archive_lifecycle job deletes blobs older
+// than ExpireAfterDays.
+type Archive struct {
+	Enabled         bool               `mapstructure:"enabled,default=false"`
+	Backend         string             `mapstructure:"backend,default=s3"`
+	Bucket          string             `mapstructure:"bucket"`
+	Prefix          string             `mapstructure:"prefix,omitempty"`
+	Region          string             `mapstructure:"region,omitempty"`
+	Endpoint        string             `mapstructure:"endpoint,omitempty"`
+	Account         string             `mapstructure:"account,omitempty"`
+	MinBytes        int                `mapstructure:"min_bytes,default=65536"`
+	StorageClass    string             `mapstructure:"storage_class,omitempty"`
+	ExpireAfterDays int                `mapstructure:"expire_after_days,omitempty"`
+	Encryption      *ArchiveEncryption `mapstructure:"encryption"`
+}
+
+// ArchiveEncryption selects the server-side encryption of archived bodies. Mode
+// "provider" uses the keys of the provider, "kms" the KMS key, or Azure
+// encryption scope, named by KMSKey.
+type ArchiveEncryption struct {
+	Mode   string `mapstructure:"mode,omitempty"`
+	KMSKey string `mapstructure:"kms_key,omitempty"`
+}
+
+// UsageTiering moves usage rows older than HotDays out of the database. The
+// usage_tiering job exports them to Parquet files in the archive blob store
+// and keeps daily rollups for historical queries.
+type UsageTiering struct {
+	Enabled bool `mapstructure:"enabled,default=false"`
+	HotDays int  `mapstructure:"hot_days,default=90"`
+}
+
+// StreamOutputRules applies the output rules to streamed chat completions and
+// Anthropic messages. The content of each choice or text block is held back
+// until WindowBytes arrived, cut at the last whitespace, and every window is
+// checked before it is sent.
+type StreamOutputRules struct {
+	WindowBytes int `mapstructure:"window_bytes,default=512"`
+}
+
+// UsageAggregates keeps hourly and daily usage totals per API key, workspace
+// and model for the analytics API. Each run of the refresh_usage_aggregates job
+// recomputes the buckets of the last LookbackMinutes.
+type UsageAggregates struct {
+	Enabled         bool `mapstructure:"enabled,default=false"`
+	LookbackMinutes int  `mapstructure:"lookback_minutes,default=120"`
+}
+
+// ExtAuthz serves /ext-authz, which Envoy ext_authz and NGINX auth_request call
+// to check the API key of requests to other services. Routes limit the paths
+// of those requests to keys with scopes or of products, the longest matching
+// prefix applies and other paths only need a valid key.
+type ExtAuthz struct {
+	Enabled bool            `mapstructure:"enabled,default=false"`
+	Routes  []ExtAuthzRoute `mapstructure:"routes"`
+}
+
+// ExtAuthzRoute is the policy of the paths starting with Prefix. A key needs
+// every scope of Scopes and, if Products isn't empty, to be of one of them.
+type ExtAuthzRoute struct {
+	Prefix   string   `mapstructure:"prefix"`
+	Scopes   []string `mapstructure:"scopes"`
+	Products []string `mapstructure:"products"`
+}
+
+// SemanticCache answers chat completions from earlier completions of similar
+// prompts of the same product and model. Prompts are compared by the cosine
+// similarity of their EmbeddingModel embeddings, kept for TTL seconds in Redis
+// with RediSearch or Postgres with pgvector. Routes are path patterns the cache
+// is enabled for, all chat completion routes if it is empty.
+type SemanticCache struct {
+	Enabled        bool     `mapstructure:"enabled,default=false"`
+	Store          string   `mapstructure:"store,default=redis"`
+	EmbeddingModel string   `mapstructure:"embedding_model,default=text-embedding-3-small"`
+	Threshold      float64  `mapstructure:"threshold,default=0.95"`
+	TTL            int      `mapstructure:"ttl,default=3600"`
+	Routes         []string `mapstructure:"routes"`
+}
+
+// WorkloadIdentity authenticates in-cluster callers by their SPIFFE ID instead
+// of an API key, from the URI SAN of a verified client certificate or, with
+// Header, from a header the mesh sidecar sets. The header is only read from
+// peers in the TrustedProxies CIDR ranges. Each identity acts as its API key,
+// which gives it a product and a workspace.
+type WorkloadIdentity struct {
+	Enabled        bool                  `mapstructure:"enabled,default=false"`
+	Header         string                `mapstructure:"header,omitempty"`
+	Identities     []WorkloadIdentityKey `mapstructure:"identities"`
+	TrustedProxies []string              `mapstructure:"trusted_proxies"`
+}
+
+// WorkloadIdentityKey maps the SPIFFE IDs matching the path pattern SpiffeID to an API key
+type WorkloadIdentityKey struct {
+	SpiffeID string `mapstructure:"spiffe_id"`
+	ApiKeyID string `mapstructure:"api_key_id"`
+}
+
+// FinishReasons extends the mapping of provider finish reasons to the ones
+// usage is recorded with. Mappings are keyed by provider and lowercased
+// provider value, Fallback is recorded for values without a mapping.
+type FinishReasons struct {
+	Fallback string                       `mapstructure:"fallback,default=error"`
+	Mappings map[string]map[string]string `mapstructure:"mappings"`
+}
+
+// RuleExecutions saves the detection results of rules to the rule_executions
+// table, independently of audit logging, as they hold counts and no content
+type RuleExecutions struct {
+	Enabled bool `mapstructure:"enabled,default=true"`
+}
+
+// BreakGlass holds configuration for the emergency rule bypass mode
+type BreakGlass struct {
+	Enabled      bool   `mapstructure:"enabled,default=false"`
+	MaxDuration  int    `mapstructure:"max_duration,default=3600"`
+	AlertWebhook string `mapstructure:"alert_webhook,omitempty"`
+}
+
+// ConfigRollout holds the thresholds for rolling back a canary config, the window is in seconds.
+// The rate increases compare the canary traffic against the traffic on the stable config.
+type ConfigRollout struct {
+	ObservationWindow    int     `mapstructure:"observation_window,default=300"`
+	MinRequests          int     `mapstructure:"min_requests,default=50"`
+	MaxErrorRateIncrease float64 `mapstructure:"max_error_rate_increase,default=0.05"`
+	MaxBlockRateIncrease float64 `mapstructure:"max_block_rate_increase,default=0.1"`
+	AutoPromote          bool    `mapstructure:"auto_promote,default=false"`
+}
+
+// LatencySLO holds the latency objectives of upstream requests. Thresholds are in
+// milliseconds and the window is in seconds.
+type LatencySLO struct {
+	Objective    float64 `mapstructure:"objective,default=0.99"`
+	FirstTokenMs int     `mapstructure:"first_token_ms,default=2000"`
+	TotalMs      int     `mapstructure:"total_ms,default=30000"`
+	Window       int     `mapstructure:"window,default=3600"`
+}
+
+// JobConfig overrides the schedule of a background job, the interval is in seconds
+type JobConfig struct {
+	Interval int  `mapstructure:"interval,omitempty"`
+	Disabled bool `mapstructure:"disabled,default=false"`
+}
+
+// WriteQueue holds configuration for the local queue of failed usage and audit log writes.
+// Writes the database rejected MaxAttempts times are parked next to the queue file.
+type WriteQueue struct {
+	Path          string `mapstructure:"path,omitempty"`
+	MaxAttempts   int    `mapstructure:"max_attempts,default=10"`
+	MaxEntries    int    `mapstructure:"max_entries,default=10000"`
+	RetryInterval int    `mapstructure:"retry_interval,default=30"`
+}
+
+// Outbox holds configuration for relaying usage events to a webhook. Only
+// usage.created events, one per usage row, go through the outbox.
+type Outbox struct {
+	Enabled     bool   `mapstructure:"enabled,default=false"`
+	WebhookURL  string `mapstructure:"webhook_url"`
+	MaxAttempts int    `mapstructure:"max_attempts,default=10"`
+}
+
+// Network holds configuration for network settings, the drain timeout is in seconds
 type Network struct {
-	Port int `mapstructure:"port,default=8080"`
+	Port         int  `mapstructure:"port,default=8080"`
+	ReusePort    bool `mapstructure:"reuse_port,default=false"`
+	DrainTimeout int  `mapstructure:"drain_timeout,default=15"`
+	// With TLSCertFile and TLSKeyFile the gateway serves TLS, and asks for
+	// client certificates signed by TLSClientCAFile if it is set
+	TLSCertFile     string `mapstructure:"tls_cert_file,omitempty"`
+	TLSKeyFile      string `mapstructure:"tls_key_file,omitempty"`
+	TLSClientCAFile string `mapstructure:"tls_client_ca_file,omitempty"`
 }
 
 // DatabaseConfig holds configuration for the database
@@ -76,10 +794,18 @@ type DatabaseConfig struct {
 	URI string `mapstructure:"uri"`
 }
 
-// CacheConfig holds configuration for cache settings
+// CacheConfig holds configuration for cache settings. Routes set the TTL of
+// the paths matching their patterns, 0 turns the cache off for them.
 type CacheConfig struct {
-	Enabled bool `mapstructure:"enabled,default=false"`
-	TTL     int  `mapstructure:"ttl,default=60"`
+	Enabled bool         `mapstructure:"enabled,default=false"`
+	TTL     int          `mapstructure:"ttl,default=60"`
+	Routes  []CacheRoute `mapstructure:"routes"`
+}
+
+// CacheRoute is the cache TTL in seconds of the paths matching the pattern Path
+type CacheRoute struct {
+	Path string `mapstructure:"path"`
+	TTL  int    `mapstructure:"ttl"`
 }
 
 // Rules section contains input and output rule configurations
@@ -90,11 +816,31 @@ type Rules struct {
 
 // Rule defines a rule configuration
 type Rule struct {
-	Enabled bool   `mapstructure:"enabled,default=false"`
-	Name    string `mapstructure:"name"`
-	Type    string `mapstructure:"type"`
-	Config  Config `mapstructure:"config"`
-	Action  Action `mapstructure:"action"`
+	Enabled  bool   `mapstructure:"enabled,default=false"`
+	Critical bool   `mapstructure:"critical,default=false"`
+	Name     string `mapstructure:"name"`
+	Type     string `mapstructure:"type"`
+	Config   Config `mapstructure:"config"`
+	Action   Action `mapstructure:"action"`
+	// Products limits the rule to these product IDs, it applies to all products if empty
+	Products []string `mapstructure:"products"`
+	// Schedules limits the rule to the times one of these schedules is active
+	Schedules []string `mapstructure:"schedules"`
+	// Routes limits the rule to these request paths or path.Match patterns, it applies to all routes if empty
+	Routes []string `mapstructure:"routes"`
+	// RiskWeight scales what a hit of the rule adds to the session risk score, 1 if unset
+	RiskWeight float64 `mapstructure:"risk_weight,omitempty"`
+	// Sampling limits the rule to a share of the traffic, it checks every request if unset
+	Sampling *RuleSampling `mapstructure:"sampling"`
+}
+
+// RuleSampling runs an expensive rule on Rate of the requests, a share between 0
+// and 1. Routes sets the share of route prefixes instead, the longest matching
+// prefix wins. Rate is 1 if only Routes is set, a "/" route lowers it. Requests
+// an earlier rule of the same stage hit are always checked.
+type RuleSampling struct {
+	Rate   float64            `mapstructure:"rate"`
+	Routes map[string]float64 `mapstructure:"routes"`
 }
 
 // Config holds the configuration specifics of a filter
@@ -104,6 +850,49 @@ type Config struct {
 	Url        string      `mapstructure:"url,omitempty"`
 	ApiKey     string      `mapstructure:"api_key,omitempty"`
 	PIIService interface{} `mapstructure:"piiservice,omitempty"`
+	// StrictThreshold replaces Threshold for products with the strict content profile
+	StrictThreshold int `mapstructure:"strict_threshold,omitempty"`
+	// MinConfidence is the lowest geometric mean token probability a low confidence rule accepts
+	MinConfidence float64 `mapstructure:"min_confidence,omitempty"`
+	// AllowedDomains are link domains a url validation rule accepts without checking them
+	AllowedDomains []string `mapstructure:"allowed_domains,omitempty"`
+	// CacheTTL is how long a url validation rule reuses a link check, in seconds
+	CacheTTL int `mapstructure:"cache_ttl,omitempty"`
+	// Locales selects the built-in word lists of a profanity rule, all of them if empty
+	Locales []string `mapstructure:"locales,omitempty"`
+	// Words are matched by a profanity rule in addition to the built-in lists
+	Words []string `mapstructure:"words,omitempty"`
+	// ResponseTemplate is the text/template a self harm rule answers with, it gets .Helplines
+	ResponseTemplate string `mapstructure:"response_template,omitempty"`
+	// Helplines are the crisis resources listed in the self harm response
+	Helplines []string `mapstructure:"helplines,omitempty"`
+	// Keywords put a prompt or completion on the topic of a topic rule
+	Keywords []string `mapstructure:"keywords,omitempty"`
+	// Topic is the label the classifier plugin of a topic rule checks for
+	Topic string `mapstructure:"topic,omitempty"`
+	// Collections are the document collections a rag collections rule allows
+	Collections []string `mapstructure:"collections,omitempty"`
+	// Detector is the in-process detector of a detector rule, from settings.detectors
+	Detector string `mapstructure:"detector,omitempty"`
+	// Signals are the scores a combined rule joins
+	Signals []Signal `mapstructure:"signals,omitempty"`
+	// Combine is how a combined rule joins its signals: and, or or weighted
+	Combine string `mapstructure:"combine,omitempty"`
+	// Patterns are regular expressions a prompt injection rule matches before its plugin or
+	// classifier and a PII scanner rule detects in addition to its entities
+	Patterns []string `mapstructure:"patterns,omitempty"`
+	// Entities are the built-in entities of a PII scanner rule: email, phone, ssn and credit_card, all if empty
+	Entities []string `mapstructure:"entities,omitempty"`
+}
+
+// Signal is one score of a combined rule, from an in-process detector or a rule
+// server plugin. It matches at Threshold in percent, Weight scales its score in
+// a weighted combination and is 1 if unset.
+type Signal struct {
+	Detector   string  `mapstructure:"detector"`
+	PluginName string  `mapstructure:"plugin_name"`
+	Threshold  int     `mapstructure:"threshold"`
+	Weight     float64 `mapstructure:"weight"`
 }
 
 type ActionType string
@@ -115,8 +904,10 @@ type Action struct {
 
 var AppConfig Configuration
 
+var viperCfg *viper.Viper
+
 func init() {
-	viperCfg := viper.New()
+	viperCfg = viper.New()
 
 	viperCfg.SetConfigName("config")
 	viperCfg.SetConfigType("yaml")
@@ -135,10 +926,19 @@ func init() {
 	}
 
 	if viperCfg.Get("providers.openai.enabled") == true && os.Getenv("ENV") != "test" {
-		if os.Getenv("OPENAI_API_KEY") == "" {
-			log.Fatal("OPENAI_API_KEY Environment variable is not set")
+		// Azure OpenAI takes a key of the resource, or an Entra ID token, in place of the OpenAI key
+		// and without a token, gets one from Entra ID
+		variable, required := "OPENAI_API_KEY", true
+		if viperCfg.IsSet("providers.openai.azure") {
+			variable = "AZURE_OPENAI_API_KEY"
+			if viperCfg.GetString("providers.openai.azure.auth") == "entra_id" {
+				variable, required = "AZURE_OPENAI_AD_TOKEN", false
+			}
 		}
-		viperCfg.Set("secrets.openai_api_key", os.Getenv("OPENAI_API_KEY"))
+		if required && os.Getenv(variable) == "" {
+			log.Fatalf("%s Environment variable is not set", variable)
+		}
+		viperCfg.Set("secrets.openai_api_key", os.Getenv(variable))
 	}
 
 	if viperCfg.Get("providers.huggingface.enabled") == true && os.Getenv("ENV") != "test" {
@@ -148,7 +948,77 @@ func init() {
 		viperCfg.Set("secrets.huggingface_api_key", os.Getenv("HUGGINGFACE_API_KEY"))
 	}
 
-	if viperCfg.Get("settings.cache.enabled") == true || viperCfg.Get("settings.rate_limiting.enabled") == true {
+	if viperCfg.Get("providers.cohere.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("COHERE_API_KEY") == "" {
+			log.Fatal("COHERE_API_KEY Environment variable is not set")
+		}
+		viperCfg.Set("secrets.cohere_api_key", os.Getenv("COHERE_API_KEY"))
+	}
+
+	if viperCfg.Get("providers.voyage.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("VOYAGE_API_KEY") == "" {
+			log.Fatal("VOYAGE_API_KEY Environment variable is not set")
+		}
+		viperCfg.Set("secrets.voyage_api_key", os.Getenv("VOYAGE_API_KEY"))
+	}
+
+	if viperCfg.Get("providers.anthropic.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("ANTHROPIC_API_KEY") == "" {
+			log.Fatal("ANTHROPIC_API_KEY Environment variable is not set")
+		}
+		viperCfg.Set("secrets.anthropic_api_key", os.Getenv("ANTHROPIC_API_KEY"))
+	}
+
+	if viperCfg.Get("settings.archive.enabled") == true && os.Getenv("ENV") != "test" {
+		switch viperCfg.GetString("settings.archive.backend") {
+		case "", "s3":
+			if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+				log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY Environment variables are not set")
+			}
+			viperCfg.Set("secrets.archive_access_key", os.Getenv("AWS_ACCESS_KEY_ID"))
+			viperCfg.Set("secrets.archive_secret_key", os.Getenv("AWS_SECRET_ACCESS_KEY"))
+			viperCfg.Set("secrets.archive_token", os.Getenv("AWS_SESSION_TOKEN"))
+		case "azure":
+			if os.Getenv("AZURE_STORAGE_KEY") == "" {
+				log.Fatal("AZURE_STORAGE_KEY Environment variable is not set")
+			}
+			viperCfg.Set("secrets.archive_secret_key", os.Getenv("AZURE_STORAGE_KEY"))
+		case "gcs":
+			// Without a token, one is fetched from the metadata server
+			viperCfg.Set("secrets.archive_token", os.Getenv("GCS_ACCESS_TOKEN"))
+		}
+	}
+
+	if viperCfg.Get("settings.scim.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("SCIM_TOKEN") == "" {
+			log.Fatal("SCIM_TOKEN Environment variable is not set")
+		}
+		viperCfg.Set("secrets.scim_token", os.Getenv("SCIM_TOKEN"))
+	}
+
+	if viperCfg.Get("settings.break_glass.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("BREAK_GLASS_KEY") == "" {
+			log.Fatal("BREAK_GLASS_KEY Environment variable is not set")
+		}
+		viperCfg.Set("secrets.break_glass_key", os.Getenv("BREAK_GLASS_KEY"))
+	}
+
+	if viperCfg.Get("settings.policy_overrides.enabled") == true && os.Getenv("ENV") != "test" {
+		if os.Getenv("POLICY_OVERRIDE_KEY") == "" {
+			log.Fatal("POLICY_OVERRIDE_KEY Environment variable is not set")
+		}
+		viperCfg.Set("secrets.policy_override_key", os.Getenv("POLICY_OVERRIDE_KEY"))
+	}
+
+	// Race routes fall back to the OpenAI key, so the alternate key is optional
+	if key := os.Getenv("ALTERNATE_API_KEY"); key != "" {
+		viperCfg.Set("secrets.alternate_api_key", key)
+	}
+	if key := os.Getenv("PROBE_API_KEY"); key != "" {
+		viperCfg.Set("secrets.probe_api_key", key)
+	}
+
+	if viperCfg.Get("settings.cache.enabled") == true || viperCfg.Get("settings.break_glass.enabled") == true || viperCfg.Get("settings.rate_limiting.enabled") == true {
 		if viperCfg.Get("settings.redis.uri") == "" || viperCfg.Get("settings.redis.uri") == nil {
 			log.Fatal("settings.redis.uri is not set")
 		}
@@ -159,11 +1029,20 @@ func init() {
 		panic(err)
 	}
 
+	if raw, err := os.ReadFile(viperCfg.ConfigFileUsed()); err == nil {
+		setConfigRaw(raw)
+	}
+
 	viperCfg.WatchConfig()
 	viperCfg.OnConfigChange(func(e fsnotify.Event) {
 		fmt.Println("Config file changed:", e.Name)
 		if err = viperCfg.Unmarshal(&AppConfig); err != nil {
 			fmt.Println(err)
+			return
+		}
+		if raw, err := os.ReadFile(e.Name); err == nil {
+			setConfigRaw(raw)
+			go publishConfig()
 		}
 	})
 
@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const configBundleVersion = 1
+
+// QuotaConfigKey is the config file list of the capacity shares of the workspaces
+const QuotaConfigKey = "settings.capacity_partitions.workspaces"
+
+// ConfigBundle holds the configuration entities moved between environments
+type ConfigBundle struct {
+	Version    int                 `json:"version"`
+	ExportedAt time.Time           `json:"exported_at"`
+	Workspaces []models.Workspaces `json:"workspaces"`
+	Products   []models.Products   `json:"products"`
+	AiModels   []models.AiModels   `json:"ai_models"`
+	Tags       []models.Tags       `json:"tags"`
+	ApiKeys    []ApiKeyExport      `json:"api_keys"`
+	Rules      interface{}         `json:"rules"`
+	Quotas     interface{}         `json:"quotas"`
+}
+
+// ApiKeyExport describes an API key without its value
+type ApiKeyExport struct {
+	Id         uuid.UUID     `json:"id"`
+	ProductID  uuid.UUID     `json:"product_id"`
+	ApiKeyHash string        `json:"api_key_hash"`
+	Status     models.Status `json:"status"`
+	Tags       string        `json:"tags"`
+	Scopes     string        `json:"scopes"`
+	CreatedBy  string        `json:"created_by"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// ImportResult counts what an import changed
+type ImportResult struct {
+	Workspaces     int  `json:"workspaces"`
+	Products       int  `json:"products"`
+	AiModels       int  `json:"ai_models"`
+	Tags           int  `json:"tags"`
+	ApiKeysUpdated int  `json:"api_keys_updated"`
+	ApiKeysSkipped int  `json:"api_keys_skipped"`
+	RulesImported  bool `json:"rules_imported"`
+	QuotasImported bool `json:"quotas_imported"`
+}
+
+// ExportConfigBundle reads the configuration entities from the database and the config file
+func ExportConfigBundle() (ConfigBundle, error) {
+	bundle := ConfigBundle{
+		Version:    configBundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Rules:      viperCfg.Get("rules"),
+		Quotas:     viperCfg.Get(QuotaConfigKey),
+	}
+
+	db := DB()
+	for _, target := range []interface{}{&bundle.Workspaces, &bundle.Products, &bundle.AiModels, &bundle.Tags} {
+		if err := db.Find(target).Error; err != nil {
+			return ConfigBundle{}, err
+		}
+	}
+
+	var apiKeys []models.ApiKeys
+	if err := db.Find(&apiKeys).Error; err != nil {
+		return ConfigBundle{}, err
+	}
+	for _, apiKey := range apiKeys {
+		bundle.ApiKeys = append(bundle.ApiKeys, ApiKeyExport{
+			Id:         apiKey.Id,
+			ProductID:  apiKey.ProductID,
+			ApiKeyHash: hashApiKey(apiKey.ApiKey),
+			Status:     apiKey.Status,
+			Tags:       apiKey.Tags,
+			Scopes:     apiKey.Scopes,
+			CreatedBy:  apiKey.CreatedBy,
+			CreatedAt:  apiKey.CreatedAt,
+		})
+	}
+
+	return bundle, nil
+}
+
+// ImportConfigBundle upserts the entities of a bundle and replaces the rules and
+// the workspace quotas in the config file. API key values are never exported,
+// so only keys that already exist with the same hash are updated.
+func ImportConfigBundle(bundle ConfigBundle) (ImportResult, error) {
+	if bundle.Version != configBundleVersion {
+		return ImportResult{}, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	if err := checkBundleQuotas(bundle.Quotas); err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	var updatedKeys []uuid.UUID
+	err := DB().Transaction(func(tx *gorm.DB) error {
+		upsert := tx.Clauses(clause.OnConflict{UpdateAll: true})
+		if len(bundle.Workspaces) > 0 {
+			if err := upsert.Create(&bundle.Workspaces).Error; err != nil {
+				return fmt.Errorf("failed to import workspaces: %v", err)
+			}
+		}
+		if len(bundle.Products) > 0 {
+			if err := upsert.Create(&bundle.Products).Error; err != nil {
+				return fmt.Errorf("failed to import products: %v", err)
+			}
+		}
+		if len(bundle.AiModels) > 0 {
+			if err := upsert.Create(&bundle.AiModels).Error; err != nil {
+				return fmt.Errorf("failed to import models: %v", err)
+			}
+		}
+		if len(bundle.Tags) > 0 {
+			if err := upsert.Create(&bundle.Tags).Error; err != nil {
+				return fmt.Errorf("failed to import tags: %v", err)
+			}
+		}
+		result.Workspaces = len(bundle.Workspaces)
+		result.Products = len(bundle.Products)
+		result.AiModels = len(bundle.AiModels)
+		result.Tags = len(bundle.Tags)
+
+		for _, export := range bundle.ApiKeys {
+			var apiKey models.ApiKeys
+			if err := tx.Where("id = ?", export.Id).First(&apiKey).Error; err != nil || hashApiKey(apiKey.ApiKey) != export.ApiKeyHash {
+				result.ApiKeysSkipped++
+				continue
+			}
+			err := tx.Model(&apiKey).Updates(map[string]interface{}{
+				"product_id": export.ProductID,
+				"status":     export.Status,
+				"scopes":     export.Scopes,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to import api key %s: %v", export.Id, err)
+			}
+			updatedKeys = append(updatedKeys, export.Id)
+			result.ApiKeysUpdated++
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	for _, id := range updatedKeys {
+		ForgetRateLimitIdentity(context.Background(), id)
+	}
+
+	sections := map[string]interface{}{}
+	if bundle.Rules != nil {
+		sections["rules"] = bundle.Rules
+	}
+	if bundle.Quotas != nil {
+		sections[QuotaConfigKey] = bundle.Quotas
+	}
+	if len(sections) > 0 {
+		if err := writeConfigSections(sections); err != nil {
+			return result, fmt.Errorf("failed to import rules and quotas: %v", err)
+		}
+		result.RulesImported = bundle.Rules != nil
+		result.QuotasImported = bundle.Quotas != nil
+	}
+	return result, nil
+}
+
+// checkBundleQuotas checks the workspace quotas of a bundle like the quota API
+// does: shares above 0 and at most 1 that add up to at most 1
+func checkBundleQuotas(quotas interface{}) error {
+	if quotas == nil {
+		return nil
+	}
+	v := viper.New()
+	v.Set("quotas", quotas)
+	var capacities []WorkspaceCapacity
+	if err := v.UnmarshalKey("quotas", &capacities); err != nil {
+		return fmt.Errorf("invalid quotas: %v", err)
+	}
+	total := 0.0
+	for _, capacity := range capacities {
+		if _, err := uuid.Parse(capacity.Workspace); err != nil {
+			return fmt.Errorf("invalid quota workspace %q", capacity.Workspace)
+		}
+		if capacity.Share <= 0 || capacity.Share > 1 {
+			return fmt.Errorf("quota share of workspace %s must be above 0 and at most 1", capacity.Workspace)
+		}
+		total += capacity.Share
+	}
+	if total > 1+1e-9 {
+		return fmt.Errorf("the quota shares of the workspaces add up to %.2f", total)
+	}
+	return nil
+}
+
+// writeConfigSections replaces sections of the config file, keyed by their
+// path. A separate viper instance is used so secrets loaded from the
+// environment aren't written out.
+func writeConfigSections(sections map[string]interface{}) error {
+	configWrites.Lock()
+	defer configWrites.Unlock()
+
+	v, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	for key, value := range sections {
+		v.Set(key, value)
+	}
+	return writeConfigFile(v)
+}
+
+// MarshalConfigBundle encodes a bundle as "json" or "yaml"
+func MarshalConfigBundle(bundle ConfigBundle, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil || strings.ToLower(format) == "json" {
+		return data, err
+	}
+
+	// Go through JSON so the YAML keys match the JSON ones
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+// UnmarshalConfigBundle decodes a JSON or YAML bundle
+func UnmarshalConfigBundle(data []byte) (ConfigBundle, error) {
+	var bundle ConfigBundle
+	if err := json.Unmarshal(data, &bundle); err == nil {
+		return bundle, nil
+	}
+
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return ConfigBundle{}, fmt.Errorf("bundle is neither JSON nor YAML: %v", err)
+	}
+	jsonData, err := json.Marshal(generic)
+	if err != nil {
+		return ConfigBundle{}, err
+	}
+	if err := json.Unmarshal(jsonData, &bundle); err != nil {
+		return ConfigBundle{}, err
+	}
+	return bundle, nil
+}
+
+func hashApiKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigBundleRoundTrip(t *testing.T) {
+	bundle := ConfigBundle{
+		Version:    configBundleVersion,
+		ExportedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Products:   []models.Products{{Name: "product"}},
+		ApiKeys:    []ApiKeyExport{{Id: uuid.New(), ApiKeyHash: hashApiKey("key"), Status: models.Active}},
+		Rules:      map[string]interface{}{"input": []interface{}{map[string]interface{}{"name": "rule", "enabled": true}}},
+		Quotas:     []interface{}{map[string]interface{}{"workspace": uuid.New().String(), "share": 0.5, "burst": true, "version": 2.0}},
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		data, err := MarshalConfigBundle(bundle, format)
+		assert.NoError(t, err)
+
+		decoded, err := UnmarshalConfigBundle(data)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle.ApiKeys, decoded.ApiKeys, format)
+		assert.Equal(t, bundle.Rules, decoded.Rules, format)
+		assert.Equal(t, bundle.Quotas, decoded.Quotas, format)
+		assert.NoError(t, checkBundleQuotas(decoded.Quotas), format)
+		assert.Equal(t, "product", decoded.Products[0].Name, format)
+		assert.True(t, bundle.ExportedAt.Equal(decoded.ExportedAt), format)
+	}
+}
+
+func TestCheckBundleQuotas(t *testing.T) {
+	quota := func(workspace string, share float64) map[string]interface{} {
+		return map[string]interface{}{"workspace": workspace, "share": share}
+	}
+	assert.NoError(t, checkBundleQuotas(nil))
+	assert.NoError(t, checkBundleQuotas([]interface{}{}))
+	assert.NoError(t, checkBundleQuotas([]interface{}{quota(uuid.NewString(), 0.4), quota(uuid.NewString(), 0.6)}))
+	assert.Error(t, checkBundleQuotas([]interface{}{quota(uuid.NewString(), 0.6), quota(uuid.NewString(), 0.6)}))
+	assert.Error(t, checkBundleQuotas([]interface{}{quota(uuid.NewString(), 0)}))
+	assert.Error(t, checkBundleQuotas([]interface{}{quota("workspace", 0.5)}))
+	assert.Error(t, checkBundleQuotas("quotas"))
+}
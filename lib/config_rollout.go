@@ -0,0 +1,420 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+const (
+	configRolloutRedisKey = "openshield:config_rollout"
+	configRolloutChannel  = "openshield:config_rollout"
+)
+
+type RolloutStatus string
+
+const (
+	RolloutNone       RolloutStatus = "none"
+	RolloutActive     RolloutStatus = "active"
+	RolloutPassed     RolloutStatus = "passed"
+	RolloutRolledBack RolloutStatus = "rolled_back"
+	RolloutPromoted   RolloutStatus = "promoted"
+)
+
+// CanaryConfig is a candidate configuration served to a percentage of the traffic
+type CanaryConfig struct {
+	Version   string    `json:"version"`
+	Content   []byte    `json:"content"`
+	Percent   int       `json:"percent"`
+	Operator  string    `json:"operator"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// RolloutCounts holds the outcomes of the requests served on one side of a rollout
+type RolloutCounts struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+	Blocks   int `json:"blocks"`
+}
+
+// ConfigRolloutReport describes the current or last rollout on this replica
+type ConfigRolloutReport struct {
+	Status    RolloutStatus `json:"status"`
+	Reason    string        `json:"reason,omitempty"`
+	Version   string        `json:"version,omitempty"`
+	Percent   int           `json:"percent,omitempty"`
+	Operator  string        `json:"operator,omitempty"`
+	StartedAt time.Time     `json:"started_at,omitempty"`
+	Stable    RolloutCounts `json:"stable"`
+	Canary    RolloutCounts `json:"canary"`
+}
+
+// rolloutRequest tracks which config a request was served with
+type rolloutRequest struct {
+	canary  bool
+	blocked bool
+}
+
+var rolloutState = struct {
+	sync.Mutex
+	canary    *CanaryConfig
+	candidate Configuration
+	version   string
+	status    RolloutStatus
+	reason    string
+	stable    RolloutCounts
+	counts    RolloutCounts
+}{status: RolloutNone}
+
+// GetConfigRolloutSettings returns the rollout thresholds with defaults applied
+func GetConfigRolloutSettings() ConfigRollout {
+	settings := ConfigRollout{}
+	if rollout := GetConfig().Settings.ConfigRollout; rollout != nil {
+		settings = *rollout
+	}
+	if settings.ObservationWindow <= 0 {
+		settings.ObservationWindow = 300
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = 50
+	}
+	if settings.MaxErrorRateIncrease <= 0 {
+		settings.MaxErrorRateIncrease = 0.05
+	}
+	if settings.MaxBlockRateIncrease <= 0 {
+		settings.MaxBlockRateIncrease = 0.1
+	}
+	return settings
+}
+
+// StartConfigRollout serves content, a complete config file, to percent of the
+// traffic. With Redis the rollout is shared by all replicas.
+func StartConfigRollout(content []byte, percent int, operator string) (CanaryConfig, error) {
+	if percent < 1 || percent > 100 {
+		return CanaryConfig{}, fmt.Errorf("percent must be between 1 and 100")
+	}
+	if _, err := parseConfig(content); err != nil {
+		return CanaryConfig{}, fmt.Errorf("invalid config: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	canary := CanaryConfig{
+		Version:   hex.EncodeToString(sum[:]),
+		Content:   content,
+		Percent:   percent,
+		Operator:  operator,
+		StartedAt: time.Now().UTC(),
+	}
+
+	if RedisConfigured() {
+		data, err := json.Marshal(canary)
+		if err != nil {
+			return CanaryConfig{}, err
+		}
+		ctx := context.Background()
+		if err := RedisClient().Set(ctx, configRolloutRedisKey, data, 0).Err(); err != nil {
+			return CanaryConfig{}, fmt.Errorf("failed to store rollout: %v", err)
+		}
+		if err := Publish(ctx, configRolloutChannel, canary.Version); err != nil {
+			log.Printf("Error publishing config rollout: %v", err)
+		}
+	}
+
+	if err := applyCanary(&canary); err != nil {
+		return CanaryConfig{}, err
+	}
+	log.Printf("Started config rollout %s to %d%% of traffic by %s", canary.Version, percent, operator)
+	return canary, nil
+}
+
+// RollbackConfigRollout sends all traffic back to the stable config
+func RollbackConfigRollout(reason string) error {
+	rolloutState.Lock()
+	if rolloutState.canary == nil {
+		rolloutState.Unlock()
+		return fmt.Errorf("no config rollout in progress")
+	}
+	version := rolloutState.canary.Version
+	rolloutState.Unlock()
+
+	if err := endRollout(RolloutRolledBack, reason); err != nil {
+		return err
+	}
+	log.Printf("Rolled back config rollout %s: %s", version, reason)
+	return nil
+}
+
+// PromoteConfigRollout writes the canary config to the config file so it serves all
+// traffic. With settings.config_sync the file change reaches the other replicas.
+func PromoteConfigRollout() error {
+	rolloutState.Lock()
+	canary := rolloutState.canary
+	rolloutState.Unlock()
+	if canary == nil {
+		return fmt.Errorf("no config rollout in progress")
+	}
+
+	if err := os.WriteFile(viperCfg.ConfigFileUsed(), canary.Content, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	if err := endRollout(RolloutPromoted, ""); err != nil {
+		return err
+	}
+	log.Printf("Promoted config rollout %s", canary.Version)
+	return nil
+}
+
+// ConfigRolloutStatus returns the state and counters of the rollout on this replica
+func ConfigRolloutStatus() ConfigRolloutReport {
+	rolloutState.Lock()
+	defer rolloutState.Unlock()
+
+	report := ConfigRolloutReport{
+		Status:  rolloutState.status,
+		Reason:  rolloutState.reason,
+		Version: rolloutState.version,
+		Stable:  rolloutState.stable,
+		Canary:  rolloutState.counts,
+	}
+	if canary := rolloutState.canary; canary != nil {
+		report.Percent = canary.Percent
+		report.Operator = canary.Operator
+		report.StartedAt = canary.StartedAt
+	}
+	return report
+}
+
+func endRollout(status RolloutStatus, reason string) error {
+	if RedisConfigured() {
+		ctx := context.Background()
+		if err := RedisClient().Del(ctx, configRolloutRedisKey).Err(); err != nil {
+			return fmt.Errorf("failed to remove rollout: %v", err)
+		}
+		if err := Publish(ctx, configRolloutChannel, ""); err != nil {
+			log.Printf("Error publishing config rollout: %v", err)
+		}
+	}
+
+	rolloutState.Lock()
+	rolloutState.canary = nil
+	rolloutState.status = status
+	rolloutState.reason = reason
+	rolloutState.Unlock()
+	return nil
+}
+
+func applyCanary(canary *CanaryConfig) error {
+	rolloutState.Lock()
+	defer rolloutState.Unlock()
+
+	if canary == nil {
+		if rolloutState.canary != nil {
+			rolloutState.canary = nil
+			rolloutState.status = RolloutNone
+			rolloutState.reason = "ended on another replica"
+		}
+		return nil
+	}
+	if rolloutState.canary != nil && rolloutState.canary.Version == canary.Version {
+		return nil
+	}
+
+	candidate, err := parseConfig(canary.Content)
+	if err != nil {
+		return err
+	}
+	rolloutState.canary = canary
+	rolloutState.candidate = candidate
+	rolloutState.version = canary.Version
+	rolloutState.status = RolloutActive
+	rolloutState.reason = ""
+	rolloutState.stable = RolloutCounts{}
+	rolloutState.counts = RolloutCounts{}
+	return nil
+}
+
+// parseConfig decodes a config file. Secrets come from the environment, so the
+// ones currently loaded are kept.
+func parseConfig(content []byte) (Configuration, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return Configuration{}, err
+	}
+	var config Configuration
+	if err := v.Unmarshal(&config); err != nil {
+		return Configuration{}, err
+	}
+	config.Secrets = GetConfig().Secrets
+	return config, nil
+}
+
+func loadSharedRollout(ctx context.Context) error {
+	data, err := RedisClient().Get(ctx, configRolloutRedisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return applyCanary(nil)
+	} else if err != nil {
+		return err
+	}
+
+	var canary CanaryConfig
+	if err := json.Unmarshal(data, &canary); err != nil {
+		return err
+	}
+	return applyCanary(&canary)
+}
+
+// WatchConfigRollout follows rollouts started and ended on other replicas until ctx is done
+func WatchConfigRollout(ctx context.Context) {
+	if err := loadSharedRollout(ctx); err != nil {
+		log.Printf("Error loading config rollout: %v", err)
+	}
+
+	Subscribe(ctx, configRolloutChannel, func(string) {
+		if err := loadSharedRollout(ctx); err != nil {
+			log.Printf("Error loading config rollout: %v", err)
+		}
+	})
+}
+
+// GetRequestConfig returns the configuration a request is served with, which is
+// the canary config for requests picked by an active rollout
+func GetRequestConfig(r *http.Request) Configuration {
+	if config, ok := r.Context().Value("config").(Configuration); ok {
+		return config
+	}
+	return GetConfig()
+}
+
+// MarkRolloutBlocked records that a rule blocked the request, for the block rate of the rollout
+func MarkRolloutBlocked(r *http.Request) {
+	if request, ok := r.Context().Value("rolloutRequest").(*rolloutRequest); ok {
+		request.blocked = true
+	}
+}
+
+// ConfigRolloutMiddleware sends the configured percentage of requests to the canary
+// config and rolls the rollout back if the canary error or block rate spikes
+func ConfigRolloutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rolloutState.Lock()
+		canary := rolloutState.canary
+		candidate := rolloutState.candidate
+		rolloutState.Unlock()
+
+		if canary == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		request := &rolloutRequest{canary: rand.Intn(100) < canary.Percent}
+		ctx := context.WithValue(r.Context(), "rolloutRequest", request)
+		if request.canary {
+			ctx = context.WithValue(ctx, "config", candidate)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		recordRolloutResult(canary.Version, request, recorder.status)
+	})
+}
+
+func recordRolloutResult(version string, request *rolloutRequest, status int) {
+	settings := GetConfigRolloutSettings()
+
+	rolloutState.Lock()
+	if rolloutState.canary == nil || rolloutState.canary.Version != version {
+		rolloutState.Unlock()
+		return
+	}
+
+	counts := &rolloutState.stable
+	if request.canary {
+		counts = &rolloutState.counts
+	}
+	counts.Requests++
+	if status >= http.StatusInternalServerError {
+		counts.Errors++
+	}
+	if request.blocked {
+		counts.Blocks++
+	}
+
+	reason := rolloutRegression(settings, rolloutState.stable, rolloutState.counts)
+	observed := rolloutState.status == RolloutActive &&
+		rolloutState.counts.Requests >= settings.MinRequests &&
+		time.Since(rolloutState.canary.StartedAt) >= time.Duration(settings.ObservationWindow)*time.Second
+	if reason == "" && observed {
+		rolloutState.status = RolloutPassed
+	}
+	rolloutState.Unlock()
+
+	if reason != "" {
+		go func() {
+			if err := RollbackConfigRollout(reason); err != nil {
+				log.Printf("Error rolling back config rollout: %v", err)
+			}
+		}()
+	} else if observed && settings.AutoPromote {
+		go func() {
+			if err := PromoteConfigRollout(); err != nil {
+				log.Printf("Error promoting config rollout: %v", err)
+			}
+		}()
+	}
+}
+
+// rolloutRegression returns why the canary should be rolled back, or an empty string
+func rolloutRegression(settings ConfigRollout, stable RolloutCounts, canary RolloutCounts) string {
+	if canary.Requests < settings.MinRequests {
+		return ""
+	}
+
+	errorIncrease := rate(canary.Errors, canary.Requests) - rate(stable.Errors, stable.Requests)
+	if errorIncrease > settings.MaxErrorRateIncrease {
+		return fmt.Sprintf("error rate increased by %.3f over %d requests", errorIncrease, canary.Requests)
+	}
+	blockIncrease := rate(canary.Blocks, canary.Requests) - rate(stable.Blocks, stable.Requests)
+	if blockIncrease > settings.MaxBlockRateIncrease {
+		return fmt.Sprintf("block rate increased by %.3f over %d requests", blockIncrease, canary.Requests)
+	}
+	return ""
+}
+
+func rate(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,29 @@
+package lib
+
+import "testing"
+
+func TestRolloutRegression(t *testing.T) {
+	settings := ConfigRollout{MinRequests: 10, MaxErrorRateIncrease: 0.05, MaxBlockRateIncrease: 0.1}
+
+	tests := []struct {
+		name     string
+		stable   RolloutCounts
+		canary   RolloutCounts
+		rollback bool
+	}{
+		{"too few requests", RolloutCounts{Requests: 100}, RolloutCounts{Requests: 5, Errors: 5}, false},
+		{"healthy", RolloutCounts{Requests: 100, Errors: 2}, RolloutCounts{Requests: 50, Errors: 2}, false},
+		{"error spike", RolloutCounts{Requests: 100, Errors: 1}, RolloutCounts{Requests: 20, Errors: 4}, true},
+		{"block spike", RolloutCounts{Requests: 100, Blocks: 5}, RolloutCounts{Requests: 20, Blocks: 6}, true},
+		{"errors match stable", RolloutCounts{Requests: 100, Errors: 20}, RolloutCounts{Requests: 20, Errors: 4}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := rolloutRegression(settings, tt.stable, tt.canary)
+			if (reason != "") != tt.rollback {
+				t.Errorf("rolloutRegression() = %q, want rollback %v", reason, tt.rollback)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	configRedisKey = "openshield:config"
+	configChannel  = "openshield:config"
+)
+
+// sharedConfig is the configuration file content shared between replicas through Redis
+type sharedConfig struct {
+	Version   string    `json:"version"`
+	Content   []byte    `json:"content"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var configState struct {
+	sync.RWMutex
+	version   string
+	raw       []byte
+	updatedAt time.Time
+}
+
+func setConfigRaw(raw []byte) {
+	sum := sha256.Sum256(raw)
+
+	configState.Lock()
+	configState.version = hex.EncodeToString(sum[:])
+	configState.raw = raw
+	configState.updatedAt = time.Now().UTC()
+	configState.Unlock()
+}
+
+// ConfigVersion returns the hash of the configuration in effect
+func ConfigVersion() string {
+	configState.RLock()
+	defer configState.RUnlock()
+	return configState.version
+}
+
+func configSyncEnabled() bool {
+	configSync := GetConfig().Settings.ConfigSync
+	return configSync != nil && configSync.Enabled && RedisConfigured()
+}
+
+// publishConfig stores the local configuration in Redis and tells the other
+// replicas to apply it
+func publishConfig() {
+	if !configSyncEnabled() {
+		return
+	}
+
+	configState.RLock()
+	shared := sharedConfig{Version: configState.version, Content: configState.raw, UpdatedAt: configState.updatedAt}
+	configState.RUnlock()
+
+	data, err := json.Marshal(shared)
+	if err != nil {
+		log.Printf("Error encoding config: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if err := RedisClient().Set(ctx, configRedisKey, data, 0).Err(); err != nil {
+		log.Printf("Error storing config: %v", err)
+		return
+	}
+	if err := Publish(ctx, configChannel, shared.Version); err != nil {
+		log.Printf("Error publishing config: %v", err)
+		return
+	}
+	log.Printf("Published config version %s", shared.Version)
+}
+
+// applySharedConfig loads the configuration stored in Redis if it differs from the local one
+func applySharedConfig(ctx context.Context) error {
+	data, err := RedisClient().Get(ctx, configRedisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var shared sharedConfig
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return err
+	}
+	if shared.Version == ConfigVersion() {
+		return nil
+	}
+
+	if err := viperCfg.ReadConfig(bytes.NewReader(shared.Content)); err != nil {
+		return err
+	}
+	var config Configuration
+	if err := viperCfg.Unmarshal(&config); err != nil {
+		return err
+	}
+	AppConfig = config
+
+	configState.Lock()
+	configState.version = shared.Version
+	configState.raw = shared.Content
+	configState.updatedAt = shared.UpdatedAt
+	configState.Unlock()
+
+	log.Printf("Applied config version %s from another replica", shared.Version)
+	return nil
+}
+
+// WatchConfigBroadcast applies configuration published by other replicas until ctx is done
+func WatchConfigBroadcast(ctx context.Context) {
+	if !configSyncEnabled() {
+		return
+	}
+
+	if err := applySharedConfig(ctx); err != nil {
+		log.Printf("Error applying shared config: %v", err)
+	}
+
+	Subscribe(ctx, configChannel, func(version string) {
+		if version == ConfigVersion() {
+			return
+		}
+		if err := applySharedConfig(ctx); err != nil {
+			log.Printf("Error applying shared config: %v", err)
+		}
+	})
+}
+
+// VersionHandler reports the configuration and kill switch versions of this
+// replica, so operators can check that all replicas have converged
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := os.Hostname()
+
+	configState.RLock()
+	updatedAt := configState.updatedAt
+	configState.RUnlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instance":            hostname,
+		"config_version":      ConfigVersion(),
+		"config_updated_at":   updatedAt,
+		"kill_switch_version": KillSwitchVersion(),
+	})
+}
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxHashedDrain bounds what is read from a response body left unread when it
+// is closed, so the hash covers trailing newlines a JSON decoder left behind
+const maxHashedDrain = 4 << 10
+
+// ContentHashes records salted SHA-256 hashes of the exact request a provider
+// client sent and the response it read, for the usage row of the request. The
+// latest exchange of the context is kept.
+type ContentHashes struct {
+	mu       sync.Mutex
+	salt     []byte
+	request  []byte
+	response hash.Hash
+}
+
+// WithContentHashes returns a context whose provider requests are hashed, and
+// the hashes, when settings.content_hashes is enabled. The hashes are nil otherwise.
+func WithContentHashes(ctx context.Context) (context.Context, *ContentHashes) {
+	settings := GetConfig().Settings.ContentHashes
+	if settings == nil || !settings.Enabled {
+		return ctx, nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return ctx, nil
+	}
+	hashes := &ContentHashes{salt: salt}
+	return context.WithValue(ctx, "contentHashes", hashes), hashes
+}
+
+func (h *ContentHashes) newHash() hash.Hash {
+	sum := sha256.New()
+	sum.Write(h.salt)
+	return sum
+}
+
+// Sums returns the hex encoded salt and the request and response hashes, or
+// empty strings when no provider exchange was recorded
+func (h *ContentHashes) Sums() (string, string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.response == nil {
+		return "", "", ""
+	}
+	return hex.EncodeToString(h.salt), hex.EncodeToString(h.request), hex.EncodeToString(h.response.Sum(nil))
+}
+
+// VerifyContentHash reports whether content has the salted hash of a usage row
+func VerifyContentHash(salt string, expected string, content []byte) bool {
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil || expected == "" {
+		return false
+	}
+	sum := sha256.New()
+	sum.Write(saltBytes)
+	sum.Write(content)
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum.Sum(nil))), []byte(expected)) == 1
+}
+
+// hashingTransport hashes the requests and responses of contexts with content hashes
+type hashingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *hashingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hashes, ok := req.Context().Value("contentHashes").(*ContentHashes)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	requestHash := hashes.newHash()
+	requestHash.Write(body)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	responseHash := hashes.newHash()
+	hashes.mu.Lock()
+	hashes.request, hashes.response = requestHash.Sum(nil), responseHash
+	hashes.mu.Unlock()
+	// Event streams aren't drained, they can stay open long after a client stopped reading
+	drain := !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+	resp.Body = &hashingBody{ReadCloser: resp.Body, hashes: hashes, hash: responseHash, drain: drain}
+	return resp, nil
+}
+
+// hashingBody adds what is read from a response body to its hash
+type hashingBody struct {
+	io.ReadCloser
+	hashes *ContentHashes
+	hash   hash.Hash
+	drain  bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hashes.mu.Lock()
+	b.hash.Write(p[:n])
+	b.hashes.mu.Unlock()
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	if b.drain {
+		io.Copy(io.Discard, io.LimitReader(b, maxHashedDrain))
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentHashes(t *testing.T) {
+	defer func(settings *ContentHashing) {
+		AppConfig.Settings.ContentHashes = settings
+	}(AppConfig.Settings.ContentHashes)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1"}`+"\n")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &hashingTransport{next: http.DefaultTransport}}
+	send := func(ctx context.Context) {
+		req, _ := http.NewRequestWithContext(ctx, "POST", upstream.URL, strings.NewReader(`{"model":"gpt-4o"}`))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		// Only part of the body is read, the rest is hashed on close
+		resp.Body.Read(make([]byte, 4))
+		resp.Body.Close()
+	}
+
+	AppConfig.Settings.ContentHashes = &ContentHashing{Enabled: false}
+	_, hashes := WithContentHashes(context.Background())
+	assert.Nil(t, hashes)
+
+	AppConfig.Settings.ContentHashes = &ContentHashing{Enabled: true}
+	ctx, hashes := WithContentHashes(context.Background())
+	require.NotNil(t, hashes)
+	salt, requestHash, responseHash := hashes.Sums()
+	assert.Empty(t, salt+requestHash+responseHash, "no exchange recorded yet")
+	send(ctx)
+
+	salt, requestHash, responseHash = hashes.Sums()
+	assert.True(t, VerifyContentHash(salt, requestHash, []byte(`{"model":"gpt-4o"}`)))
+	assert.True(t, VerifyContentHash(salt, responseHash, []byte(`{"id":"chatcmpl-1"}`+"\n")))
+	assert.False(t, VerifyContentHash(salt, responseHash, []byte(`{"id":"chatcmpl-2"}`+"\n")))
+
+	// Another request gets another salt, so equal content doesn't give equal hashes
+	ctx, other := WithContentHashes(context.Background())
+	send(ctx)
+	otherSalt, otherRequestHash, _ := other.Sums()
+	assert.NotEqual(t, salt, otherSalt)
+	assert.NotEqual(t, requestHash, otherRequestHash)
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	ContextReject    = "reject"
+	ContextTruncate  = "truncate"
+	ContextSummarize = "summarize"
+
+	// ContextLengthExceededCode is the error code of requests that don't fit the context window
+	ContextLengthExceededCode = "context_length_exceeded"
+
+	// Tokens the chat format adds per message and to prime the reply
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// ContextLengthError is returned for requests whose prompt and completion don't fit the context window of the model
+type ContextLengthError struct {
+	Model  string
+	Limit  int
+	Tokens int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("the maximum context length of %s is %d tokens, this request needs %d tokens", e.Model, e.Limit, e.Tokens)
+}
+
+type contextWindowEntry struct {
+	tokens    int
+	expiresAt time.Time
+}
+
+var contextWindows sync.Map
+
+// GetContextWindowSettings returns the context window settings with defaults applied
+func GetContextWindowSettings() ContextWindow {
+	settings := ContextWindow{}
+	if window := GetConfig().Settings.ContextWindow; window != nil {
+		settings = *window
+	}
+	if settings.Policy == "" {
+		settings.Policy = ContextReject
+	}
+	if settings.SummaryModel == "" {
+		settings.SummaryModel = "gpt-4o-mini"
+	}
+	if settings.KeepMessages <= 0 {
+		settings.KeepMessages = 4
+	}
+	return settings
+}
+
+// ModelContextWindow returns the context window of a model from the synced
+// catalog or the built-in metadata, 0 if it isn't known
+func ModelContextWindow(ctx context.Context, model string) int {
+	if cached, ok := contextWindows.Load(model); ok && time.Now().Before(cached.(contextWindowEntry).expiresAt) {
+		return cached.(contextWindowEntry).tokens
+	}
+
+	tokens := 0
+	catalog, err := CatalogModels(ctx, model)
+	if err != nil {
+		log.Printf("Error loading model %s: %v", model, err)
+	}
+	if len(catalog) > 0 {
+		tokens = catalog[0].ContextWindow
+	}
+	if tokens == 0 {
+		metadata, _ := ModelMetadataFor(model)
+		tokens = metadata.ContextWindow
+	}
+	contextWindows.Store(model, contextWindowEntry{tokens: tokens, expiresAt: time.Now().Add(modelApprovalTTL)})
+	return tokens
+}
+
+// CountMessageTokens counts the tokens of a chat message in the chat format
+func CountMessageTokens(model string, message goopenai.ChatCompletionMessage) int {
+	tokens := tokensPerMessage + CountTokens(model, message.Role) + CountTokens(model, message.Content) + CountTokens(model, message.Name)
+	for _, part := range message.MultiContent {
+		tokens += CountTokens(model, part.Text)
+	}
+	for _, call := range message.ToolCalls {
+		tokens += CountTokens(model, call.Function.Name) + CountTokens(model, call.Function.Arguments)
+	}
+	return tokens
+}
+
+// CountPromptTokens estimates the prompt tokens of a chat completion request,
+// counting tool definitions by their JSON encoding
+func CountPromptTokens(req goopenai.ChatCompletionRequest) int {
+	tokens := tokensPerReply
+	for _, message := range req.Messages {
+		tokens += CountMessageTokens(req.Model, message)
+	}
+	if len(req.Tools) > 0 {
+		tools, _ := json.Marshal(req.Tools)
+		tokens += CountTokens(req.Model, string(tools))
+	}
+	return tokens
+}
+
+// FitContextWindow checks that the prompt and the requested completion tokens
+// fit the context window of the model. With the truncate policy the oldest
+// messages are dropped, keeping system messages and the last message. It
+// returns the number of dropped messages. Requests that need a summary fail
+// with a ContextLengthError, see SummaryCandidates.
+func FitContextWindow(ctx context.Context, req *goopenai.ChatCompletionRequest) (int, error) {
+	settings := GetContextWindowSettings()
+	if !settings.Enabled {
+		return 0, nil
+	}
+	limit := ModelContextWindow(ctx, req.Model)
+	if limit == 0 {
+		return 0, nil
+	}
+
+	tokens := CountPromptTokens(*req) + req.MaxTokens
+	if tokens <= limit {
+		return 0, nil
+	}
+	if settings.Policy != ContextTruncate {
+		return 0, &ContextLengthError{Model: req.Model, Limit: limit, Tokens: tokens}
+	}
+
+	messages := req.Messages
+	dropped := 0
+	for tokens > limit {
+		oldest := -1
+		for i := 0; i < len(messages)-1; i++ {
+			if messages[i].Role != goopenai.ChatMessageRoleSystem {
+				oldest = i
+				break
+			}
+		}
+		if oldest < 0 {
+			return 0, &ContextLengthError{Model: req.Model, Limit: limit, Tokens: tokens}
+		}
+		// Tool results go with the assistant message that made the calls
+		end := oldest + 1
+		for end < len(messages)-1 && messages[end].Role == goopenai.ChatMessageRoleTool {
+			end++
+		}
+		for _, message := range messages[oldest:end] {
+			tokens -= CountMessageTokens(req.Model, message)
+		}
+		messages = append(messages[:oldest:oldest], messages[end:]...)
+		dropped += end - oldest
+	}
+	req.Messages = messages
+	return dropped, nil
+}
+
+// SummaryCandidates returns the range of messages the summarize policy replaces:
+// everything after the leading system messages up to the last KeepMessages. The
+// range is empty when there is nothing to summarize.
+func SummaryCandidates(messages []goopenai.ChatCompletionMessage, keep int) (int, int) {
+	start := 0
+	for start < len(messages) && messages[start].Role == goopenai.ChatMessageRoleSystem {
+		start++
+	}
+	end := len(messages) - keep
+	// Tool results stay with the assistant message that made the calls
+	for end > start && end < len(messages) && messages[end].Role == goopenai.ChatMessageRoleTool {
+		end--
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
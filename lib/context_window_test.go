@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitContextWindow(t *testing.T) {
+	defer func(window *ContextWindow) { AppConfig.Settings.ContextWindow = window }(AppConfig.Settings.ContextWindow)
+	AppConfig.Settings.ContextWindow = &ContextWindow{Enabled: true, Policy: ContextReject}
+	contextWindows.Store("gpt-4-small", contextWindowEntry{tokens: 100, expiresAt: time.Now().Add(time.Minute)})
+	defer contextWindows.Delete("gpt-4-small")
+
+	long := strings.Repeat("word ", 60)
+	newRequest := func() goopenai.ChatCompletionRequest {
+		return goopenai.ChatCompletionRequest{
+			Model: "gpt-4-small",
+			Messages: []goopenai.ChatCompletionMessage{
+				{Role: goopenai.ChatMessageRoleSystem, Content: "You are helpful."},
+				{Role: goopenai.ChatMessageRoleUser, Content: long},
+				{Role: goopenai.ChatMessageRoleAssistant, Content: long},
+				{Role: goopenai.ChatMessageRoleUser, Content: "And now?"},
+			},
+		}
+	}
+
+	req := newRequest()
+	_, err := FitContextWindow(context.Background(), &req)
+	var lengthErr *ContextLengthError
+	assert.ErrorAs(t, err, &lengthErr)
+	assert.Equal(t, 100, lengthErr.Limit)
+
+	AppConfig.Settings.ContextWindow.Policy = ContextTruncate
+	req = newRequest()
+	dropped, err := FitContextWindow(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Len(t, req.Messages, 3)
+	assert.Equal(t, goopenai.ChatMessageRoleSystem, req.Messages[0].Role)
+	assert.Equal(t, goopenai.ChatMessageRoleAssistant, req.Messages[1].Role)
+	assert.LessOrEqual(t, CountPromptTokens(req), 100)
+
+	// The last message alone doesn't fit
+	req = newRequest()
+	req.Messages[3].Content = strings.Repeat(long, 4)
+	_, err = FitContextWindow(context.Background(), &req)
+	assert.ErrorAs(t, err, &lengthErr)
+}
+
+func TestSummaryCandidates(t *testing.T) {
+	messages := []goopenai.ChatCompletionMessage{
+		{Role: goopenai.ChatMessageRoleSystem, Content: "You are helpful."},
+		{Role: goopenai.ChatMessageRoleUser, Content: "one"},
+		{Role: goopenai.ChatMessageRoleAssistant, Content: "two"},
+		{Role: goopenai.ChatMessageRoleUser, Content: "three"},
+		{Role: goopenai.ChatMessageRoleAssistant, ToolCalls: []goopenai.ToolCall{{ID: "call_1"}}},
+		{Role: goopenai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "result"},
+		{Role: goopenai.ChatMessageRoleUser, Content: "four"},
+	}
+
+	start, end := SummaryCandidates(messages, 3)
+	assert.Equal(t, 1, start)
+	assert.Equal(t, 4, end)
+
+	// The kept messages don't start with a tool result
+	start, end = SummaryCandidates(messages, 2)
+	assert.Equal(t, 1, start)
+	assert.Equal(t, 4, end)
+
+	start, end = SummaryCandidates(messages, 10)
+	assert.Equal(t, start, end)
+}
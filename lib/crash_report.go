@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+const maxCrashReports = 50
+
+// redactedHeaders are left out of crash reports, they carry credentials
+var redactedHeaders = []string{"Authorization", "Cookie", "Api-Key", "X-Api-Key", "Proxy-Authorization"}
+
+// CrashReport describes a panic in a handler. The request body is never included.
+type CrashReport struct {
+	ID            string              `json:"id"`
+	Time          time.Time           `json:"time"`
+	Panic         string              `json:"panic"`
+	Stack         string              `json:"stack"`
+	Route         string              `json:"route"`
+	Method        string              `json:"method"`
+	Path          string              `json:"path"`
+	RequestID     string              `json:"request_id,omitempty"`
+	APIKeyID      string              `json:"api_key_id,omitempty"`
+	RemoteAddr    string              `json:"remote_addr"`
+	Headers       map[string][]string `json:"headers"`
+	ConfigVersion string              `json:"config_version"`
+}
+
+var crashReports struct {
+	sync.Mutex
+	reports []CrashReport
+}
+
+// Recoverer turns a panic in a handler into a crash report and the standard
+// error response, so one broken route doesn't take down the others or the
+// connection. Reports are kept in memory and sent to the error tracking webhook.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			report := newCrashReport(r, recovered, debug.Stack())
+			log.Printf("Panic in %s %s (crash report %s): %v", report.Method, report.Route, report.ID, recovered)
+			storeCrashReport(report)
+
+			message := fmt.Sprintf("internal server error, crash report %s", report.ID)
+			if WantsEnvelope(r) {
+				WriteEnvelopeError(w, "openshield", http.StatusInternalServerError, "internal_server_error", message)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": message,
+					"type":    "server_error",
+					"param":   "server",
+					"code":    "internal_server_error",
+				},
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newCrashReport(r *http.Request, recovered interface{}, stack []byte) CrashReport {
+	report := CrashReport{
+		ID:            uuid.New().String(),
+		Time:          time.Now().UTC(),
+		Panic:         fmt.Sprint(recovered),
+		Stack:         string(stack),
+		Route:         r.URL.Path,
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		RequestID:     middleware.GetReqID(r.Context()),
+		RemoteAddr:    r.RemoteAddr,
+		Headers:       r.Header.Clone(),
+		ConfigVersion: ConfigVersion(),
+	}
+	if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+		report.Route = routeContext.RoutePattern()
+	}
+	if apiKeyID, ok := r.Context().Value("apiKeyId").(uuid.UUID); ok {
+		report.APIKeyID = apiKeyID.String()
+	}
+	for _, header := range redactedHeaders {
+		if _, ok := report.Headers[header]; ok {
+			report.Headers[header] = []string{"[redacted]"}
+		}
+	}
+	return report
+}
+
+func storeCrashReport(report CrashReport) {
+	crashReports.Lock()
+	crashReports.reports = append(crashReports.reports, report)
+	if len(crashReports.reports) > maxCrashReports {
+		crashReports.reports = crashReports.reports[len(crashReports.reports)-maxCrashReports:]
+	}
+	crashReports.Unlock()
+
+	if tracking := GetConfig().Settings.ErrorTracking; tracking != nil && tracking.Enabled && tracking.Webhook != "" {
+		go sendCrashReport(tracking.Webhook, report)
+	}
+}
+
+// sendCrashReport posts a crash report to the error tracking webhook
+func sendCrashReport(webhook string, report CrashReport) {
+	if err := CheckURL(webhook); err != nil {
+		log.Printf("Error tracking webhook rejected: %v", err)
+		return
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Error sending crash report: %v", err)
+		return
+	}
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		log.Printf("Error sending crash report: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Error sending crash report: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error sending crash report: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		log.Printf("Error tracking webhook answered %s", strings.TrimSpace(resp.Status))
+	}
+}
+
+// ListCrashReports returns the latest crash reports of this replica, newest first
+func ListCrashReports() []CrashReport {
+	crashReports.Lock()
+	defer crashReports.Unlock()
+	reports := make([]CrashReport, 0, len(crashReports.reports))
+	for i := len(crashReports.reports) - 1; i >= 0; i-- {
+		reports = append(reports, crashReports.reports[i])
+	}
+	return reports
+}
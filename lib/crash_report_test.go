@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverer(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(Recoverer)
+	router.Get("/broken/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})
+	router.Get("/working", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/broken/42", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "internal_server_error", body.Error.Code)
+
+	reports := ListCrashReports()
+	assert.NotEmpty(t, reports)
+	assert.Equal(t, "/broken/{id}", reports[0].Route)
+	assert.Equal(t, "nil map", reports[0].Panic)
+	assert.Equal(t, []string{"[redacted]"}, reports[0].Headers["Authorization"])
+	assert.Contains(t, reports[0].Stack, "crash_report_test.go")
+	assert.Contains(t, rec.Body.String(), reports[0].ID)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/working", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	CryptoProfileDefault = "default"
+	CryptoProfileFIPS    = "fips"
+)
+
+// ActiveCryptoProfile returns the crypto profile in effect. Binaries built with
+// GOEXPERIMENT=boringcrypto always run with the FIPS profile.
+func ActiveCryptoProfile() string {
+	if fipsBuild {
+		return CryptoProfileFIPS
+	}
+	crypto := GetConfig().Settings.Crypto
+	if crypto == nil || crypto.Profile == "" {
+		return CryptoProfileDefault
+	}
+	return crypto.Profile
+}
+
+// ValidateCryptoProfile checks that the configuration is compatible with the
+// active crypto profile and logs the profile in use.
+func ValidateCryptoProfile() error {
+	config := GetConfig()
+	profile := ActiveCryptoProfile()
+
+	switch profile {
+	case CryptoProfileDefault:
+	case CryptoProfileFIPS:
+		cached := config.Settings.Cache != nil && config.Settings.Cache.Enabled
+		rateLimited := config.Settings.RateLimit != nil && config.Settings.RateLimit.FeatureToggle != nil && config.Settings.RateLimit.Enabled
+		redisUsed := RedisConfigured() || cached || rateLimited
+		if redisUsed && (config.Settings.Redis == nil || !config.Settings.Redis.SSL) {
+			return fmt.Errorf("crypto profile %s requires settings.redis.ssl to be enabled", profile)
+		}
+	default:
+		return fmt.Errorf("unknown crypto profile: %s", profile)
+	}
+
+	log.Printf("Active crypto profile: %s (boringcrypto build: %v)", profile, fipsBuild)
+	return nil
+}
+
+// TLSConfig returns the client TLS configuration for the active crypto profile.
+func TLSConfig() *tls.Config {
+	if ActiveCryptoProfile() == CryptoProfileFIPS {
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{tls.CurveP384, tls.CurveP256},
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			},
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		},
+	}
+}
+
+// hashKey derives a cache key. The FIPS profile uses SHA-256 instead of xxhash.
+func hashKey(key string) string {
+	if ActiveCryptoProfile() == CryptoProfileFIPS {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	return strconv.FormatUint(xxhash.Sum64([]byte(key)), 10)
+}
@@ -0,0 +1,5 @@
+//go:build !boringcrypto
+
+package lib
+
+const fipsBuild = false
@@ -0,0 +1,7 @@
+//go:build boringcrypto
+
+package lib
+
+import _ "crypto/tls/fipsonly"
+
+const fipsBuild = true
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCryptoProfile(t *testing.T) {
+	settings := AppConfig.Settings
+	defer func() { AppConfig.Settings = settings }()
+
+	AppConfig.Settings.Crypto = &Crypto{Profile: CryptoProfileFIPS}
+	AppConfig.Settings.Cache = nil
+	AppConfig.Settings.RateLimit = nil
+	AppConfig.Settings.Redis = nil
+	assert.NoError(t, ValidateCryptoProfile(), "settings without cache or redis are valid")
+
+	AppConfig.Settings.Cache = &CacheConfig{Enabled: true}
+	assert.ErrorContains(t, ValidateCryptoProfile(), "settings.redis.ssl")
+
+	AppConfig.Settings.Cache = nil
+	AppConfig.Settings.Redis = &RedisConfig{URI: "redis://localhost:6379"}
+	assert.ErrorContains(t, ValidateCryptoProfile(), "settings.redis.ssl")
+
+	AppConfig.Settings.Redis.SSL = true
+	AppConfig.Settings.RateLimit = &RateLimiting{FeatureToggle: &FeatureToggle{Enabled: true}}
+	assert.NoError(t, ValidateCryptoProfile())
+
+	AppConfig.Settings.Crypto = &Crypto{Profile: "legacy"}
+	assert.ErrorContains(t, ValidateCryptoProfile(), "unknown crypto profile")
+}
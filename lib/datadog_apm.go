@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Traces exporters
+const (
+	TracesDatadog = "datadog"
+	TracesOTLP    = "otlp"
+)
+
+const traceExportBuffer = 1000
+
+var exportedTraces = make(chan Trace, traceExportBuffer)
+
+// datadogSpan is a span of the Datadog agent trace API
+type datadogSpan struct {
+	TraceID  uint64             `json:"trace_id"`
+	SpanID   uint64             `json:"span_id"`
+	ParentID uint64             `json:"parent_id"`
+	Name     string             `json:"name"`
+	Resource string             `json:"resource"`
+	Service  string             `json:"service"`
+	Type     string             `json:"type"`
+	Start    int64              `json:"start"`
+	Duration int64              `json:"duration"`
+	Error    int32              `json:"error"`
+	Meta     map[string]string  `json:"meta"`
+	Metrics  map[string]float64 `json:"metrics"`
+}
+
+// GetTracesExporterSettings returns the traces exporter settings with defaults
+// applied. Exporter is empty when no exporter is configured.
+func GetTracesExporterSettings() TracesExporter {
+	settings := TracesExporter{}
+	if observability := GetConfig().Settings.Observability; observability != nil && observability.Traces != nil {
+		settings = *observability.Traces
+	}
+	if settings.AgentURL == "" {
+		settings.AgentURL = "http://127.0.0.1:8126"
+	}
+	if settings.Endpoint == "" {
+		settings.Endpoint = "http://127.0.0.1:4318"
+	}
+	if settings.Service == "" {
+		settings.Service = "openshield"
+	}
+	return settings
+}
+
+// tracesExported reports whether a traces exporter is configured
+func tracesExported() bool {
+	exporter := GetTracesExporterSettings().Exporter
+	return exporter == TracesDatadog || exporter == TracesOTLP
+}
+
+// exportTrace queues a kept trace for the exporter. Traces are dropped when the
+// exporter falls behind.
+func exportTrace(trace Trace) {
+	if !tracesExported() {
+		return
+	}
+	select {
+	case exportedTraces <- trace:
+	default:
+	}
+}
+
+// RunTraceExporter sends the queued traces to the Datadog agent or the OTLP
+// collector every second until ctx is done
+func RunTraceExporter(ctx context.Context) {
+	if !tracesExported() {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var traces []Trace
+		drain:
+			for len(traces) < traceExportBuffer {
+				select {
+				case trace := <-exportedTraces:
+					traces = append(traces, trace)
+				default:
+					break drain
+				}
+			}
+			if len(traces) == 0 {
+				continue
+			}
+			settings := GetTracesExporterSettings()
+			if settings.Exporter == TracesOTLP {
+				if err := sendOTLPTraces(ctx, settings, traces); err != nil {
+					log.Printf("Error sending traces to the OTLP collector: %v", err)
+				}
+			} else if err := sendDatadogTraces(ctx, settings, traces); err != nil {
+				log.Printf("Error sending traces to Datadog: %v", err)
+			}
+		}
+	}
+}
+
+// datadogSpanID returns the 64-bit span ID of the agent API for a hex span ID
+func datadogSpanID(spanID string) uint64 {
+	if id, err := hex.DecodeString(spanID); err == nil && len(id) == 8 {
+		return binary.BigEndian.Uint64(id)
+	}
+	return 0
+}
+
+// datadogTrace converts a trace to the spans of the agent API, the request span
+// and its children. The sampling priority makes the agent keep it, the gateway
+// already sampled it.
+func datadogTrace(settings TracesExporter, trace Trace) []datadogSpan {
+	var traceID uint64
+	if id, err := hex.DecodeString(trace.TraceID); err == nil && len(id) == 16 {
+		traceID = binary.BigEndian.Uint64(id[8:])
+	}
+	spanID := datadogSpanID(trace.SpanID)
+	if spanID == 0 {
+		spanID = rand.Uint64()
+	}
+	span := datadogSpan{
+		TraceID:  traceID,
+		SpanID:   spanID,
+		ParentID: datadogSpanID(trace.ParentID),
+		Name:     "openshield.request",
+		Resource: trace.Method + " " + trace.Route,
+		Service:  settings.Service,
+		Type:     "web",
+		Start:    trace.Start.UnixNano(),
+		Duration: trace.Duration.Nanoseconds(),
+		Meta: map[string]string{
+			"http.method":      trace.Method,
+			"http.route":       trace.Route,
+			"http.status_code": fmt.Sprint(trace.Status),
+			"sampling.reason":  trace.Reason,
+		},
+		Metrics: map[string]float64{"_sampling_priority_v1": 2},
+	}
+	if settings.Env != "" {
+		span.Meta["env"] = settings.Env
+	}
+	if trace.Status >= http.StatusInternalServerError {
+		span.Error = 1
+	}
+	if trace.Workspace != "" {
+		span.Meta["openshield.workspace_id"] = trace.Workspace
+		span.Meta["openshield.product_id"] = trace.Product
+	}
+	if trace.Violation {
+		span.Meta["openshield.rule_violation"] = "true"
+	}
+
+	spans := []datadogSpan{span}
+	for _, child := range trace.Spans {
+		operation, _, _ := strings.Cut(child.Name, " ")
+		childSpan := datadogSpan{
+			TraceID:  traceID,
+			SpanID:   datadogSpanID(child.SpanID),
+			ParentID: datadogSpanID(child.ParentID),
+			Name:     "openshield." + operation,
+			Resource: child.Name,
+			Service:  settings.Service,
+			Start:    child.Start.UnixNano(),
+			Duration: child.Duration.Nanoseconds(),
+			Meta:     map[string]string{},
+		}
+		if child.Client {
+			childSpan.Type = "http"
+		}
+		for key, value := range child.Attributes {
+			childSpan.Meta[key] = value
+		}
+		if child.Error != "" {
+			childSpan.Error = 1
+			childSpan.Meta["error.message"] = child.Error
+		}
+		if child.ParentID == trace.SpanID {
+			childSpan.ParentID = spanID
+		}
+		spans = append(spans, childSpan)
+	}
+	return spans
+}
+
+func sendDatadogTraces(ctx context.Context, settings TracesExporter, traces []Trace) error {
+	payload := make([][]datadogSpan, 0, len(traces))
+	for _, trace := range traces {
+		payload = append(payload, datadogTrace(settings, trace))
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(settings.AgentURL, "/")+"/v0.3/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Datadog-Trace-Count", fmt.Sprint(len(traces)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("agent answered %s", resp.Status)
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package lib
 import (
 	"log"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/openshieldai/openshield/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -12,26 +14,37 @@ var (
 	db *gorm.DB
 )
 
+// migrationModels are the tables created and migrated when the database is opened
+var migrationModels = []interface{}{
+	&models.Tags{},
+	&models.AiModels{},
+	&models.ApiKeys{},
+	&models.AuditLogs{},
+	&models.Products{},
+	&models.Usage{},
+	&models.Workspaces{},
+	&models.AdminUsers{},
+	&models.AdminSessions{},
+	&models.OutboxEvents{},
+	&models.ModelApprovals{},
+	&models.FineTuneJobs{},
+	&models.UsageRollups{},
+	&models.UsageAggregates{},
+	&models.RuleExecution{},
+	&models.BulkJobs{},
+}
+
 func SetDB(customDB *gorm.DB) {
 	db = customDB
 }
 
 func DB() *gorm.DB {
 	if db == nil {
-		config := GetConfig()
-		connection, err := gorm.Open(postgres.Open(config.Settings.Database.URI), &gorm.Config{})
+		connection, err := openDB()
 		if err != nil {
 			panic(err)
 		}
-		err = connection.AutoMigrate(
-			&models.Tags{},
-			&models.AiModels{},
-			&models.ApiKeys{},
-			&models.AuditLogs{},
-			&models.Products{},
-			&models.Usage{},
-			&models.Workspaces{},
-		)
+		err = connection.AutoMigrate(migrationModels...)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -39,3 +52,16 @@ func DB() *gorm.DB {
 	}
 	return db
 }
+
+func openDB() (*gorm.DB, error) {
+	config := GetConfig()
+	pgxConfig, err := pgx.ParseConfig(config.Settings.Database.URI)
+	if err != nil {
+		return nil, err
+	}
+	pgxConfig.DialFunc = EgressDialContext
+
+	return gorm.Open(postgres.New(postgres.Config{
+		Conn: stdlib.OpenDB(*pgxConfig),
+	}), &gorm.Config{})
+}
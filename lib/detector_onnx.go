@@ -0,0 +1,92 @@
+//go:build onnx
+
+package lib
+
+import (
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// Builds with the onnx tag load ONNX detectors, they need the ONNX Runtime
+// shared library at run time
+func init() {
+	RegisterDetectorLoader("onnx", loadONNXDetector)
+}
+
+var onnxEnvironment struct {
+	sync.Mutex
+	err error
+}
+
+// initONNXEnvironment initializes the runtime once for all detectors
+func initONNXEnvironment() error {
+	onnxEnvironment.Lock()
+	defer onnxEnvironment.Unlock()
+	if ort.IsInitialized() || onnxEnvironment.err != nil {
+		return onnxEnvironment.err
+	}
+	if settings := GetConfig().Settings.Detectors; settings != nil && settings.SharedLibrary != "" {
+		ort.SetSharedLibraryPath(settings.SharedLibrary)
+	}
+	onnxEnvironment.err = ort.InitializeEnvironment()
+	return onnxEnvironment.err
+}
+
+type onnxDetector struct {
+	model     DetectorModel
+	tokenizer *WordPiece
+	session   *ort.DynamicAdvancedSession
+}
+
+func loadONNXDetector(model DetectorModel) (Detector, error) {
+	if err := initONNXEnvironment(); err != nil {
+		return nil, fmt.Errorf("error initializing ONNX Runtime: %v", err)
+	}
+	tokenizer, err := LoadWordPiece(model.Vocab)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vocabulary: %v", err)
+	}
+	session, err := ort.NewDynamicAdvancedSession(model.Path, []string{"input_ids", "attention_mask"}, []string{model.Output}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &onnxDetector{model: model, tokenizer: tokenizer, session: session}, nil
+}
+
+// Score runs the model on the tokens of text and returns the softmax
+// probability of the detection label
+func (d *onnxDetector) Score(text string) (float64, error) {
+	ids := d.tokenizer.Encode(text, d.model.MaxLength)
+	mask := make([]int64, len(ids))
+	for i := range mask {
+		mask[i] = 1
+	}
+	shape := ort.NewShape(1, int64(len(ids)))
+	inputIDs, err := ort.NewTensor(shape, ids)
+	if err != nil {
+		return 0, err
+	}
+	defer inputIDs.Destroy()
+	attention, err := ort.NewTensor(shape, mask)
+	if err != nil {
+		return 0, err
+	}
+	defer attention.Destroy()
+
+	outputs := []ort.Value{nil}
+	if err := d.session.Run([]ort.Value{inputIDs, attention}, outputs); err != nil {
+		return 0, err
+	}
+	defer outputs[0].Destroy()
+	logits, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return 0, fmt.Errorf("output %s isn't a float32 tensor", d.model.Output)
+	}
+	return labelProbability(logits.GetData(), d.model.Label)
+}
+
+func (d *onnxDetector) Close() error {
+	return d.session.Destroy()
+}
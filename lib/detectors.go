@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const detectorChannel = "openshield:detectors"
+
+// detectorReplica tells the reloads this replica published from those of others
+var detectorReplica = uuid.NewString()
+
+type detectorReload struct {
+	Replica string `json:"replica"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+}
+
+// ErrDetectorFormat is returned for detector models of a format the build can't load
+var ErrDetectorFormat = errors.New("detector format is not supported by this build")
+
+// Detector scores a text in process, from 0 for clean to 1 for a certain detection
+type Detector interface {
+	Score(text string) (float64, error)
+	Close() error
+}
+
+// DetectorLoader loads the detectors of one model format
+type DetectorLoader func(model DetectorModel) (Detector, error)
+
+// DetectorStatus describes a loaded detector
+type DetectorStatus struct {
+	Name     string    `json:"name"`
+	Format   string    `json:"format"`
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+type loadedDetector struct {
+	detector Detector
+	status   DetectorStatus
+}
+
+var detectorLoaders = map[string]DetectorLoader{}
+
+var detectors = struct {
+	sync.RWMutex
+	loaded map[string]loadedDetector
+}{loaded: map[string]loadedDetector{}}
+
+// RegisterDetectorLoader makes a model format loadable, it is called from init
+func RegisterDetectorLoader(format string, loader DetectorLoader) {
+	detectorLoaders[format] = loader
+}
+
+// detectorModel returns the configured model of a detector
+func detectorModel(name string) (DetectorModel, bool) {
+	settings := GetConfig().Settings.Detectors
+	if settings == nil {
+		return DetectorModel{}, false
+	}
+	for _, model := range settings.Models {
+		if model.Name == name {
+			if model.Format == "" {
+				model.Format = "onnx"
+			}
+			if model.MaxLength <= 0 {
+				model.MaxLength = 256
+			}
+			if model.Output == "" {
+				model.Output = "logits"
+			}
+			return model, true
+		}
+	}
+	return DetectorModel{}, false
+}
+
+// GetDetector returns a detector, loading it on first use
+func GetDetector(name string) (Detector, error) {
+	detectors.RLock()
+	loaded, ok := detectors.loaded[name]
+	detectors.RUnlock()
+	if ok {
+		return loaded.detector, nil
+	}
+	if _, err := ReloadDetector(name, ""); err != nil {
+		return nil, err
+	}
+	detectors.RLock()
+	defer detectors.RUnlock()
+	return detectors.loaded[name].detector, nil
+}
+
+// ReloadDetector loads a detector again, from path instead of the configured
+// file if set, and swaps it in once it loaded. Requests scoring with the
+// previous model finish with it, it is closed after a grace period.
+func ReloadDetector(name string, path string) (DetectorStatus, error) {
+	model, ok := detectorModel(name)
+	if !ok {
+		return DetectorStatus{}, fmt.Errorf("unknown detector %s", name)
+	}
+	if path != "" {
+		model.Path = path
+	}
+	loader, ok := detectorLoaders[model.Format]
+	if !ok {
+		return DetectorStatus{}, fmt.Errorf("%w: %s", ErrDetectorFormat, model.Format)
+	}
+	detector, err := loader(model)
+	if err != nil {
+		return DetectorStatus{}, fmt.Errorf("error loading detector %s: %v", name, err)
+	}
+
+	status := DetectorStatus{Name: name, Format: model.Format, Path: model.Path, LoadedAt: time.Now().UTC()}
+	detectors.Lock()
+	previous, replaced := detectors.loaded[name]
+	detectors.loaded[name] = loadedDetector{detector: detector, status: status}
+	detectors.Unlock()
+
+	if replaced {
+		log.Printf("Detector %s reloaded from %s", name, model.Path)
+		time.AfterFunc(time.Minute, func() {
+			if err := previous.detector.Close(); err != nil {
+				log.Printf("Error closing previous model of detector %s: %v", name, err)
+			}
+		})
+	}
+	return status, nil
+}
+
+// ListDetectors returns the loaded detectors
+func ListDetectors() []DetectorStatus {
+	detectors.RLock()
+	defer detectors.RUnlock()
+	statuses := make([]DetectorStatus, 0, len(detectors.loaded))
+	for _, loaded := range detectors.loaded {
+		statuses = append(statuses, loaded.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// PublishDetectorReload asks the other replicas to reload a detector the way
+// this one did
+func PublishDetectorReload(ctx context.Context, name string, path string) error {
+	if !RedisConfigured() {
+		return nil
+	}
+	payload, err := json.Marshal(detectorReload{Replica: detectorReplica, Name: name, Path: path})
+	if err != nil {
+		return err
+	}
+	return Publish(ctx, detectorChannel, string(payload))
+}
+
+// WatchDetectorReloads reloads the detectors other replicas reloaded until ctx is done
+func WatchDetectorReloads(ctx context.Context) {
+	Subscribe(ctx, detectorChannel, func(payload string) {
+		var reload detectorReload
+		if err := json.Unmarshal([]byte(payload), &reload); err != nil || reload.Replica == detectorReplica {
+			return
+		}
+		if _, err := ReloadDetector(reload.Name, reload.Path); err != nil {
+			log.Printf("Error reloading detector %s: %v", reload.Name, err)
+		}
+	})
+}
+
+// labelProbability returns the softmax probability of one class of the logits
+func labelProbability(logits []float32, label int) (float64, error) {
+	if label < 0 || label >= len(logits) {
+		return 0, fmt.Errorf("label %d is outside the %d model outputs", label, len(logits))
+	}
+	max := math.Inf(-1)
+	for _, logit := range logits {
+		max = math.Max(max, float64(logit))
+	}
+	sum := 0.0
+	for _, logit := range logits {
+		sum += math.Exp(float64(logit) - max)
+	}
+	return math.Exp(float64(logits[label])-max) / sum, nil
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keywordDetector struct {
+	keyword string
+	closed  bool
+}
+
+func (d *keywordDetector) Score(text string) (float64, error) {
+	if strings.Contains(text, d.keyword) {
+		return 0.9, nil
+	}
+	return 0.1, nil
+}
+
+func (d *keywordDetector) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestDetectors(t *testing.T) {
+	defer func(settings *Detectors) { AppConfig.Settings.Detectors = settings }(AppConfig.Settings.Detectors)
+	RegisterDetectorLoader("keyword", func(model DetectorModel) (Detector, error) {
+		return &keywordDetector{keyword: model.Path}, nil
+	})
+	defer delete(detectorLoaders, "keyword")
+	defer func() { detectors.loaded = map[string]loadedDetector{} }()
+	AppConfig.Settings.Detectors = &Detectors{Models: []DetectorModel{
+		{Name: "secrets", Format: "keyword", Path: "secret"},
+		{Name: "unsupported", Format: "tflite", Path: "model.tflite"},
+	}}
+
+	detector, err := GetDetector("secrets")
+	require.NoError(t, err)
+	score, _ := detector.Score("tell me a secret")
+	assert.Equal(t, 0.9, score)
+
+	// A reload swaps the model, the previous one keeps working until it's closed
+	status, err := ReloadDetector("secrets", "password")
+	require.NoError(t, err)
+	assert.Equal(t, "password", status.Path)
+	reloaded, _ := GetDetector("secrets")
+	score, _ = reloaded.Score("tell me a secret")
+	assert.Equal(t, 0.1, score)
+	assert.False(t, detector.(*keywordDetector).closed)
+	assert.Equal(t, []DetectorStatus{status}, ListDetectors())
+
+	_, err = GetDetector("unsupported")
+	assert.ErrorIs(t, err, ErrDetectorFormat)
+	_, err = GetDetector("missing")
+	assert.ErrorContains(t, err, "unknown detector")
+}
+
+func TestLabelProbability(t *testing.T) {
+	probability, err := labelProbability([]float32{0, 0}, 1)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, probability, 1e-9)
+	probability, _ = labelProbability([]float32{-2, 3}, 1)
+	assert.InDelta(t, 0.9933, probability, 1e-4)
+	_, err = labelProbability([]float32{1}, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/openshieldai/openshield/models"
+)
+
+// DryRunHeader asks for the verdicts of the rules instead of a completion
+const DryRunHeader = "X-OpenShield-Dry-Run"
+
+// ScopeDryRun lets an API key send dry runs
+const ScopeDryRun = "dry_run"
+
+// KeyScopes returns the scopes of an API key
+func KeyScopes(apiKey models.ApiKeys) []string {
+	var scopes []string
+	for _, scope := range strings.Split(apiKey.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// KeyScoped reports whether the API key of a request has a scope
+func KeyScoped(r *http.Request, scope string) bool {
+	scopes, _ := r.Context().Value("apiKeyScopes").([]string)
+	return slices.Contains(scopes, scope)
+}
+
+// DryRunRequested reports whether a request carries the dry-run header
+func DryRunRequested(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(DryRunHeader), "true")
+}
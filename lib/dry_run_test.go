@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyScoped(t *testing.T) {
+	scopes := KeyScopes(models.ApiKeys{Scopes: " dry_run, ,admin_read"})
+	assert.Equal(t, []string{"dry_run", "admin_read"}, scopes)
+	assert.Empty(t, KeyScopes(models.ApiKeys{}))
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	assert.False(t, KeyScoped(req, ScopeDryRun))
+	req = req.WithContext(context.WithValue(req.Context(), "apiKeyScopes", scopes))
+	assert.True(t, KeyScoped(req, ScopeDryRun))
+	assert.False(t, KeyScoped(req, "other"))
+
+	assert.False(t, DryRunRequested(req))
+	req.Header.Set(DryRunHeader, "True")
+	assert.True(t, DryRunRequested(req))
+	req.Header.Set(DryRunHeader, "1")
+	assert.False(t, DryRunRequested(req))
+}
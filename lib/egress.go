@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var egressDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// EgressDialContext dials addr only if its host is on the egress allowlist.
+// The host is resolved once and the connection is made to the resolved
+// address, so a later DNS change cannot redirect an allowed hostname.
+// Without settings.egress.allowed_hosts every destination is allowed.
+func EgressDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return egressDial(ctx, egressDialer, network, addr)
+}
+
+// egressDial dials addr with dialer if its host is on the egress allowlist
+func egressDial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	egress := GetConfig().Settings.Egress
+	if egress == nil || len(egress.AllowedHosts) == 0 {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		if !egressAllowed(host, ip, egress.AllowedHosts) {
+			lastErr = fmt.Errorf("egress to %s (%s) is not allowed", host, ip)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found for %s", host)
+	}
+	return nil, lastErr
+}
+
+// egressAllowed reports whether host, resolved to ip, matches an allowlist entry.
+// Entries are hostnames (a leading "*." also matches subdomains), IPs or CIDR ranges.
+func egressAllowed(host string, ip net.IP, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range allowedHosts {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return true
+			}
+		case net.ParseIP(entry) != nil:
+			if net.ParseIP(entry).Equal(ip) {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		default:
+			if host == entry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redisEgressDialer returns a go-redis dialer that goes through EgressDialContext
+// and performs the TLS handshake itself when opt has a TLS config.
+func redisEgressDialer(opt *redis.Options) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := EgressDialContext(ctx, network, addr)
+		if err != nil || opt.TLSConfig == nil {
+			return conn, err
+		}
+
+		tlsConfig := opt.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
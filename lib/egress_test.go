@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEgressAllowed(t *testing.T) {
+	allowedHosts := []string{"api.openai.com", "*.internal.example.com", "10.1.0.0/16", "192.0.2.10"}
+
+	testCases := []struct {
+		host     string
+		ip       string
+		expected bool
+	}{
+		{host: "api.openai.com", ip: "203.0.113.5", expected: true},
+		{host: "API.OpenAI.com", ip: "203.0.113.5", expected: true},
+		{host: "evil.com", ip: "203.0.113.5", expected: false},
+		{host: "redis.internal.example.com", ip: "203.0.113.9", expected: true},
+		{host: "db.example.com", ip: "10.1.2.3", expected: true},
+		{host: "db.example.com", ip: "10.2.0.1", expected: false},
+		{host: "192.0.2.10", ip: "192.0.2.10", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host+"/"+tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.expected, egressAllowed(tc.host, net.ParseIP(tc.ip), allowedHosts))
+		})
+	}
+}
+
+func TestEgressDialContextBlocked(t *testing.T) {
+	defer func(egress *Egress) { AppConfig.Settings.Egress = egress }(AppConfig.Settings.Egress)
+	AppConfig.Settings.Egress = &Egress{AllowedHosts: []string{"api.openai.com"}}
+
+	_, err := EgressDialContext(context.Background(), "tcp", "127.0.0.1:1")
+	assert.ErrorContains(t, err, "is not allowed")
+}
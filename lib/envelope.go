@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/models"
+)
+
+// EnvelopeMediaType is the Accept value that selects the gateway-native response envelope
+const EnvelopeMediaType = "application/vnd.openshield+json"
+
+// Normalized finish reasons of the envelope
+const (
+	FinishStop          = string(models.Stop)
+	FinishLength        = string(models.Length)
+	FinishToolCalls     = string(models.ToolCalls)
+	FinishContentFilter = string(models.ContentFilter)
+	FinishUnknown       = "unknown"
+)
+
+// Envelope is a completion in the same shape for every provider
+type Envelope struct {
+	ID       string           `json:"id"`
+	Provider string           `json:"provider"`
+	Model    string           `json:"model"`
+	Created  int64            `json:"created"`
+	Choices  []EnvelopeChoice `json:"choices"`
+	Usage    EnvelopeUsage    `json:"usage"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// EnvelopeChoice is one completion choice. ProviderFinishReason keeps the original value.
+type EnvelopeChoice struct {
+	Index                int                `json:"index"`
+	Content              string             `json:"content"`
+	ToolCalls            []EnvelopeToolCall `json:"tool_calls,omitempty"`
+	FinishReason         string             `json:"finish_reason"`
+	ProviderFinishReason string             `json:"provider_finish_reason"`
+}
+
+// EnvelopeToolCall is a tool call requested by the model, arguments are JSON
+type EnvelopeToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// EnvelopeUsage holds the token counts of a completion
+type EnvelopeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// EnvelopeError is an error in the same shape for every provider
+type EnvelopeError struct {
+	Error struct {
+		Message  string `json:"message"`
+		Code     string `json:"code"`
+		Provider string `json:"provider,omitempty"`
+		Status   int    `json:"status"`
+	} `json:"error"`
+}
+
+// WantsEnvelope reports whether the client asked for the gateway-native envelope
+func WantsEnvelope(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), EnvelopeMediaType)
+}
+
+// WriteEnvelope writes a completion envelope
+func WriteEnvelope(w http.ResponseWriter, envelope Envelope) {
+	w.Header().Set("Content-Type", EnvelopeMediaType)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+// Error codes of the envelopes of requests and streams the rules stopped
+const (
+	EnvelopeRuleViolation  = "rule_violation"
+	EnvelopeContentBlocked = "content_blocked"
+)
+
+func newEnvelopeError(provider string, status int, code string, message string) EnvelopeError {
+	var envelope EnvelopeError
+	envelope.Error.Message = message
+	envelope.Error.Code = code
+	envelope.Error.Provider = provider
+	envelope.Error.Status = status
+	return envelope
+}
+
+// WriteEnvelopeError writes an error envelope with the given status
+func WriteEnvelopeError(w http.ResponseWriter, provider string, status int, code string, message string) {
+	w.Header().Set("Content-Type", EnvelopeMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newEnvelopeError(provider, status, code, message))
+}
+
+// WriteEnvelopeStreamError writes an error envelope as the error event ending a
+// stream. Status is the one the error would have been answered with before the
+// stream started.
+func WriteEnvelopeStreamError(w io.Writer, provider string, status int, code string, message string) {
+	data, _ := json.Marshal(newEnvelopeError(provider, status, code, message))
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+}
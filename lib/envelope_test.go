@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteEnvelopeStreamError(t *testing.T) {
+	var buf bytes.Buffer
+	WriteEnvelopeStreamError(&buf, "openai", http.StatusBadRequest, EnvelopeContentBlocked, `blocked by "profanity"`)
+
+	data, ok := strings.CutPrefix(buf.String(), "event: error\ndata: ")
+	require.True(t, ok)
+	assert.True(t, strings.HasSuffix(data, "\n\n"))
+	var envelope EnvelopeError
+	require.NoError(t, json.Unmarshal([]byte(data), &envelope))
+	assert.Equal(t, `blocked by "profanity"`, envelope.Error.Message)
+	assert.Equal(t, EnvelopeContentBlocked, envelope.Error.Code)
+	assert.Equal(t, "openai", envelope.Error.Provider)
+	assert.Equal(t, http.StatusBadRequest, envelope.Error.Status)
+}
@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// EvidenceManifest describes the contents of an evidence archive
+type EvidenceManifest struct {
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	GeneratedAt   time.Time         `json:"generated_at"`
+	CryptoProfile string            `json:"crypto_profile"`
+	Files         map[string]string `json:"files"`
+}
+
+// apiKeyReview is the access review entry for an API key. The key itself is never exported.
+type apiKeyReview struct {
+	Id        uuid.UUID     `json:"id"`
+	ProductID uuid.UUID     `json:"product_id"`
+	Status    models.Status `json:"status"`
+	CreatedBy string        `json:"created_by"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// redactedValue replaces the secrets of exported rules
+const redactedValue = "[redacted]"
+
+// policyActions are the admin actions that change the rules or the configuration
+var policyActions = []string{"config_import", "config_rollout_", "rule_put", "rule_delete"}
+
+// policyVersions are the policy versions of an evidence archive: the version of
+// the configuration in effect, the last rollout and the policy changes
+// between from and to
+type policyVersions struct {
+	Version   string              `json:"version"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	Rollout   ConfigRolloutReport `json:"rollout"`
+	Changes   []models.AuditLogs  `json:"changes"`
+}
+
+// evidenceFile is a JSON file of an evidence archive
+type evidenceFile struct {
+	name    string
+	content interface{}
+}
+
+// redactedRules returns a copy of rules with the credentials of their classifier services redacted
+func redactedRules(rules Rules) Rules {
+	redact := func(rules []Rule) []Rule {
+		redacted := make([]Rule, len(rules))
+		copy(redacted, rules)
+		for i := range redacted {
+			if redacted[i].Config.ApiKey != "" {
+				redacted[i].Config.ApiKey = redactedValue
+			}
+		}
+		return redacted
+	}
+	return Rules{Input: redact(rules.Input), Output: redact(rules.Output)}
+}
+
+// policyChanges returns the admin audit logs of policy changes
+func policyChanges(auditLogs []models.AuditLogs) []models.AuditLogs {
+	changes := []models.AuditLogs{}
+	for _, auditLog := range auditLogs {
+		if auditLog.MessageType != "admin_action" {
+			continue
+		}
+		var message struct {
+			Action string `json:"action"`
+		}
+		if json.Unmarshal([]byte(auditLog.Message), &message) != nil {
+			continue
+		}
+		for _, action := range policyActions {
+			if strings.HasPrefix(message.Action, action) {
+				changes = append(changes, auditLog)
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// ExportEvidence writes a gzipped tar archive with the audit logs between from
+// and to, the active rule configuration with its credentials redacted, the
+// policy versions and an API key access review. The archive contains a
+// manifest with the SHA-256 of every file and an HMAC-SHA256 signature of the
+// manifest made with signingKey.
+func ExportEvidence(w io.Writer, from time.Time, to time.Time, signingKey []byte) error {
+	if len(signingKey) == 0 {
+		return fmt.Errorf("signing key is empty")
+	}
+
+	var auditLogs []models.AuditLogs
+	if err := DB().Where("created_at >= ? AND created_at < ?", from, to).Order("created_at").Find(&auditLogs).Error; err != nil {
+		return fmt.Errorf("failed to read audit logs: %v", err)
+	}
+	for i := range auditLogs {
+		if auditLogs[i].MessageType == RestrictedMessageType {
+			auditLogs[i].Message = "[restricted]"
+			continue
+		}
+		message, err := AuditLogMessage(context.Background(), auditLogs[i])
+		if errors.Is(err, ErrBlobNotFound) {
+			message = "[expired]"
+		} else if err != nil {
+			return fmt.Errorf("failed to read archived audit log %s: %v", auditLogs[i].Id, err)
+		}
+		auditLogs[i].Message = message
+	}
+
+	var apiKeys []models.ApiKeys
+	if err := DB().Find(&apiKeys).Error; err != nil {
+		return fmt.Errorf("failed to read api keys: %v", err)
+	}
+	reviews := make([]apiKeyReview, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		reviews = append(reviews, apiKeyReview{
+			Id:        apiKey.Id,
+			ProductID: apiKey.ProductID,
+			Status:    apiKey.Status,
+			CreatedBy: apiKey.CreatedBy,
+			CreatedAt: apiKey.CreatedAt,
+			UpdatedAt: apiKey.UpdatedAt,
+		})
+	}
+
+	configState.RLock()
+	versions := policyVersions{Version: configState.version, UpdatedAt: configState.updatedAt}
+	configState.RUnlock()
+	versions.Rollout = ConfigRolloutStatus()
+	versions.Changes = policyChanges(auditLogs)
+
+	return writeEvidence(w, from, to, signingKey, []evidenceFile{
+		{name: "audit_logs.json", content: auditLogs},
+		{name: "rules.json", content: redactedRules(GetConfig().Rules)},
+		{name: "policy_versions.json", content: versions},
+		{name: "access_review.json", content: reviews},
+	})
+}
+
+// writeEvidence writes the evidence archive of files with its signed manifest
+func writeEvidence(w io.Writer, from time.Time, to time.Time, signingKey []byte, files []evidenceFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now().UTC()
+
+	manifest := EvidenceManifest{
+		From:          from,
+		To:            to,
+		GeneratedAt:   now,
+		CryptoProfile: ActiveCryptoProfile(),
+		Files:         map[string]string{},
+	}
+
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", file.name, err)
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files[file.name] = hex.EncodeToString(sum[:])
+		if err := writeTarFile(tw, file.name, data, now); err != nil {
+			return err
+		}
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := writeTarFile(tw, "manifest.json", manifestData, now); err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write(manifestData)
+	signature := []byte(hex.EncodeToString(mac.Sum(nil)) + "\n")
+	if err := writeTarFile(tw, "manifest.json.sig", signature, now); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
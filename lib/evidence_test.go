@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvidence(t *testing.T, archive []byte) map[string][]byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+}
+
+func TestRedactedRules(t *testing.T) {
+	rules := Rules{
+		Input:  []Rule{{Name: "classifier", Config: Config{Url: "https://classifier.example.com", ApiKey: "classifier-secret"}}},
+		Output: []Rule{{Name: "pii"}},
+	}
+	redacted := redactedRules(rules)
+
+	assert.Equal(t, redactedValue, redacted.Input[0].Config.ApiKey)
+	assert.Equal(t, "https://classifier.example.com", redacted.Input[0].Config.Url)
+	assert.Empty(t, redacted.Output[0].Config.ApiKey)
+	// The live rules keep their credentials
+	assert.Equal(t, "classifier-secret", rules.Input[0].Config.ApiKey)
+}
+
+func TestWriteEvidence(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	auditLogs := []models.AuditLogs{
+		{MessageType: "admin_action", Message: `{"action":"rule_put","payload":{"name":"classifier"}}`},
+		{MessageType: "admin_action", Message: `{"action":"api_key_create"}`},
+		{MessageType: "input", Message: `{"action":"rule_put"}`},
+	}
+	versions := policyVersions{Version: "abc123", Changes: policyChanges(auditLogs)}
+	assert.Len(t, versions.Changes, 1)
+
+	var archive bytes.Buffer
+	rules := Rules{Input: []Rule{{Name: "classifier", Config: Config{ApiKey: "classifier-secret"}}}}
+	err := writeEvidence(&archive, from, to, []byte("signing-key"), []evidenceFile{
+		{name: "rules.json", content: redactedRules(rules)},
+		{name: "policy_versions.json", content: versions},
+	})
+	require.NoError(t, err)
+
+	files := readEvidence(t, archive.Bytes())
+	assert.ElementsMatch(t, []string{"rules.json", "policy_versions.json", "manifest.json", "manifest.json.sig"}, evidenceNames(files))
+	assert.NotContains(t, string(files["rules.json"]), "classifier-secret")
+	assert.Contains(t, string(files["rules.json"]), redactedValue)
+	assert.Contains(t, string(files["policy_versions.json"]), `"version": "abc123"`)
+
+	var manifest EvidenceManifest
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	sum := sha256.Sum256(files["rules.json"])
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest.Files["rules.json"])
+	mac := hmac.New(sha256.New, []byte("signing-key"))
+	mac.Write(files["manifest.json"])
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil))+"\n", string(files["manifest.json.sig"]))
+}
+
+func evidenceNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ExtAuthzPrefix is the path Envoy and NGINX send their authorization checks to
+const ExtAuthzPrefix = "/ext-authz"
+
+// Headers of an allowed check, which the proxy passes on to the service
+const (
+	ExtAuthzApiKeyHeader    = "X-OpenShield-Api-Key-Id"
+	ExtAuthzProductHeader   = "X-OpenShield-Product-Id"
+	ExtAuthzWorkspaceHeader = "X-OpenShield-Workspace-Id"
+)
+
+// GetExtAuthzSettings returns the external authorization settings
+func GetExtAuthzSettings() ExtAuthz {
+	if extAuthz := GetConfig().Settings.ExtAuthz; extAuthz != nil {
+		return *extAuthz
+	}
+	return ExtAuthz{}
+}
+
+// extAuthzPath returns the path of the request a check is made for. NGINX
+// sends it in X-Original-URI, Envoy appends it to the path of the check. The
+// path is unescaped and cleaned, so dot segments and encoded ones can't reach
+// a route its prefix doesn't allow.
+func extAuthzPath(r *http.Request) (string, error) {
+	original := "/" + chi.URLParam(r, "*")
+	if header := r.Header.Get("X-Original-URI"); header != "" {
+		original, _, _ = strings.Cut(header, "?")
+	}
+	unescaped, err := url.PathUnescape(original)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %v", err)
+	}
+	return path.Clean("/" + unescaped), nil
+}
+
+// extAuthzPrefixMatch reports whether prefix matches path on a segment boundary,
+// so /internal matches /internal/users but not /internalfoo
+func extAuthzPrefixMatch(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// extAuthzRoute returns the route of the longest prefix of path, false if none matches
+func extAuthzRoute(routes []ExtAuthzRoute, path string) (ExtAuthzRoute, bool) {
+	var matched ExtAuthzRoute
+	found := false
+	for _, route := range routes {
+		if extAuthzPrefixMatch(path, route.Prefix) && (!found || len(route.Prefix) > len(matched.Prefix)) {
+			matched, found = route, true
+		}
+	}
+	return matched, found
+}
+
+// extAuthzDenial returns why a key of productID with scopes is denied path,
+// empty if it is allowed
+func extAuthzDenial(settings ExtAuthz, path string, productID uuid.UUID, scopes []string) string {
+	route, ok := extAuthzRoute(settings.Routes, path)
+	if !ok {
+		return ""
+	}
+	for _, scope := range route.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return fmt.Sprintf("API key lacks the %s scope required for %s", scope, route.Prefix)
+		}
+	}
+	if !ProductListed(route.Products, productID) {
+		return fmt.Sprintf("product is not allowed to access %s", route.Prefix)
+	}
+	return ""
+}
+
+// ExtAuthzHandler answers an authorization check of Envoy ext_authz or NGINX
+// auth_request for a request to another service, after AuthOpenShieldMiddleware
+// answered 401 for a missing or invalid key. Allowed checks are answered 200
+// with the key, product and workspace of the request in headers, denied ones
+// 403, and paths disabled by a route kill switch 503.
+func ExtAuthzHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := extAuthzPath(r)
+	if err != nil {
+		IncrCounter("ext_authz.checks", 1, "verdict:denied")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": err.Error(),
+				"type":    "permission_error",
+				"code":    "access_denied",
+			},
+		})
+		return
+	}
+	if killSwitch, ok := ActiveRouteKillSwitch(path); ok {
+		IncrCounter("ext_authz.checks", 1, "verdict:unavailable")
+		KillSwitchResponse(w, killSwitch)
+		return
+	}
+
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	scopes, _ := r.Context().Value("apiKeyScopes").([]string)
+	if denial := extAuthzDenial(GetExtAuthzSettings(), path, productID, scopes); denial != "" {
+		IncrCounter("ext_authz.checks", 1, "verdict:denied")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": denial,
+				"type":    "permission_error",
+				"param":   path,
+				"code":    "access_denied",
+			},
+		})
+		return
+	}
+
+	IncrCounter("ext_authz.checks", 1, "verdict:allowed")
+	if apiKeyID, ok := r.Context().Value("apiKeyId").(uuid.UUID); ok {
+		w.Header().Set(ExtAuthzApiKeyHeader, apiKeyID.String())
+	}
+	w.Header().Set(ExtAuthzProductHeader, productID.String())
+	if workspaceID, ok := r.Context().Value("workspaceId").(uuid.UUID); ok && workspaceID != uuid.Nil {
+		w.Header().Set(ExtAuthzWorkspaceHeader, workspaceID.String())
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtAuthzDenial(t *testing.T) {
+	product := uuid.New()
+	settings := ExtAuthz{Routes: []ExtAuthzRoute{
+		{Prefix: "/internal", Scopes: []string{"internal"}},
+		{Prefix: "/internal/reports", Scopes: []string{"reports"}, Products: []string{product.String()}},
+	}}
+
+	assert.Empty(t, extAuthzDenial(settings, "/public/status", uuid.New(), nil))
+	assert.Contains(t, extAuthzDenial(settings, "/internal/users", product, []string{"reports"}), "internal scope")
+	assert.Empty(t, extAuthzDenial(settings, "/internal/users", product, []string{"internal"}))
+	// The longest prefix applies, not every matching one
+	assert.Empty(t, extAuthzDenial(settings, "/internal/reports/daily", product, []string{"reports"}))
+	assert.Contains(t, extAuthzDenial(settings, "/internal/reports/daily", uuid.New(), []string{"reports"}), "product is not allowed")
+}
+
+func TestExtAuthzPath(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ext-authz", nil)
+	r.Header.Set("X-Original-URI", "/internal/reports?day=today")
+	path, err := extAuthzPath(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "/internal/reports", path)
+
+	r = httptest.NewRequest("POST", "/ext-authz/internal/reports", nil)
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add("*", "internal/reports")
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+	path, err = extAuthzPath(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "/internal/reports", path)
+}
+
+func TestExtAuthzPathTraversal(t *testing.T) {
+	settings := ExtAuthz{Routes: []ExtAuthzRoute{
+		{Prefix: "/public"},
+		{Prefix: "/internal", Scopes: []string{"internal"}},
+	}}
+	for original, expected := range map[string]string{
+		"/public/../internal/users":       "/internal/users",
+		"/public/%2e%2e/internal/users":   "/internal/users",
+		"/public/%2E%2E%2Finternal/users": "/internal/users",
+		"/public//./status":               "/public/status",
+		"public/status":                   "/public/status",
+	} {
+		r := httptest.NewRequest("GET", "/ext-authz", nil)
+		r.Header.Set("X-Original-URI", original)
+		path, err := extAuthzPath(r)
+		assert.NoError(t, err, original)
+		assert.Equal(t, expected, path, original)
+	}
+
+	r := httptest.NewRequest("GET", "/ext-authz", nil)
+	r.Header.Set("X-Original-URI", "/public/%zz")
+	_, err := extAuthzPath(r)
+	assert.Error(t, err)
+
+	// A traversal out of /public is checked against the scopes of /internal
+	r = httptest.NewRequest("GET", "/ext-authz", nil)
+	r.Header.Set("X-Original-URI", "/public/%2e%2e/internal/users")
+	path, _ := extAuthzPath(r)
+	assert.Contains(t, extAuthzDenial(settings, path, uuid.New(), nil), "internal scope")
+}
+
+func TestExtAuthzPrefixBoundary(t *testing.T) {
+	routes := []ExtAuthzRoute{{Prefix: "/internal", Scopes: []string{"internal"}}, {Prefix: "/api/"}}
+
+	route, ok := extAuthzRoute(routes, "/internal")
+	assert.True(t, ok)
+	assert.Equal(t, "/internal", route.Prefix)
+	_, ok = extAuthzRoute(routes, "/internal/users")
+	assert.True(t, ok)
+	_, ok = extAuthzRoute(routes, "/internalfoo")
+	assert.False(t, ok)
+	_, ok = extAuthzRoute(routes, "/api/v2")
+	assert.True(t, ok)
+	_, ok = extAuthzRoute(routes, "/apis")
+	assert.False(t, ok)
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// ErrTrainingFileTooLarge is returned for training files larger than max_file_bytes
+var ErrTrainingFileTooLarge = errors.New("training file is too large to scan")
+
+// ErrTooManyTrainingExamples is returned for training files with more examples
+// than max_examples, which would otherwise reach the provider unchecked
+var ErrTooManyTrainingExamples = errors.New("training file has more examples than can be scanned")
+
+// GetFineTuningSettings returns the fine-tuning settings with defaults applied
+func GetFineTuningSettings() FineTuning {
+	settings := FineTuning{}
+	if fineTuning := GetConfig().Settings.FineTuning; fineTuning != nil {
+		settings = *fineTuning
+	}
+	if settings.MaxExamples <= 0 {
+		settings.MaxExamples = 1000
+	}
+	if settings.MaxFileBytes <= 0 {
+		settings.MaxFileBytes = 100 << 20
+	}
+	return settings
+}
+
+// trainingExample holds the fields of the chat, preference and prompt-completion
+// formats of fine-tuning examples
+type trainingExample struct {
+	Messages []trainingMessage `json:"messages"`
+	Input    *struct {
+		Messages []trainingMessage `json:"messages"`
+	} `json:"input"`
+	PreferredOutput    []trainingMessage `json:"preferred_output"`
+	NonPreferredOutput []trainingMessage `json:"non_preferred_output"`
+	Prompt             *string           `json:"prompt"`
+	Completion         *string           `json:"completion"`
+}
+
+type trainingMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type trainingContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// ScanTrainingFile reads a JSONL training file and calls check with the text of
+// every example, its messages joined. Files with more than max_examples examples
+// are refused. Image URLs of the examples are checked against the URL policy. It
+// returns the number of examples checked.
+func ScanTrainingFile(file io.Reader, check func(line int, text string) error) (int, error) {
+	settings := GetFineTuningSettings()
+	limited := &io.LimitedReader{R: file, N: settings.MaxFileBytes + 1}
+	scanner := bufio.NewScanner(limited)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	scanned := 0
+	var read int64
+	for line := 1; scanner.Scan(); line++ {
+		// Counted by lines as the scanner reads ahead, so a line cut by the
+		// size limit isn't reported as invalid JSON
+		if read += int64(len(scanner.Bytes())) + 1; read > settings.MaxFileBytes+1 {
+			return scanned, ErrTrainingFileTooLarge
+		}
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if scanned == settings.MaxExamples {
+			return scanned, ErrTooManyTrainingExamples
+		}
+		text, err := trainingExampleText(data)
+		if err != nil {
+			return scanned, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := check(line, text); err != nil {
+			return scanned, err
+		}
+		scanned++
+	}
+	if err := scanner.Err(); err != nil {
+		return scanned, fmt.Errorf("error reading training file: %v", err)
+	}
+	return scanned, nil
+}
+
+// trainingExampleText returns the texts of an example joined by blank lines
+func trainingExampleText(data []byte) (string, error) {
+	var example trainingExample
+	if err := json.Unmarshal(data, &example); err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	messages := example.Messages
+	if example.Input != nil {
+		messages = append(messages, example.Input.Messages...)
+	}
+	messages = append(messages, example.PreferredOutput...)
+	messages = append(messages, example.NonPreferredOutput...)
+
+	var texts []string
+	for _, message := range messages {
+		text, err := trainingMessageText(message.Content)
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	if example.Prompt != nil {
+		texts = append(texts, *example.Prompt)
+	}
+	if example.Completion != nil {
+		texts = append(texts, *example.Completion)
+	}
+	if len(texts) == 0 {
+		return "", fmt.Errorf("no messages, prompt or completion found")
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+func trainingMessageText(content json.RawMessage) (string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+	var parts []trainingContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", fmt.Errorf("message content must be a string or a list of content parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.ImageURL != nil {
+			if err := CheckURL(part.ImageURL.URL); err != nil {
+				return "", fmt.Errorf("image_url rejected: %v", err)
+			}
+		}
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// RecordFineTuneJob stores a fine-tuning job created by a workspace
+func RecordFineTuneJob(job *models.FineTuneJobs) error {
+	return DB().Create(job).Error
+}
+
+// FineTuneJobs returns the fine-tuning jobs of a workspace, the latest first
+func FineTuneJobs(workspaceID uuid.UUID) ([]models.FineTuneJobs, error) {
+	var jobs []models.FineTuneJobs
+	query := DB().Order("created_at desc")
+	if workspaceID != uuid.Nil {
+		query = query.Where("workspace_id = ?", workspaceID)
+	}
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+// WorkspaceFineTuneJob returns a fine-tuning job if the workspace created it
+func WorkspaceFineTuneJob(workspaceID uuid.UUID, jobID string) (models.FineTuneJobs, bool, error) {
+	var job models.FineTuneJobs
+	err := DB().Where("job_id = ? AND workspace_id = ?", jobID, workspaceID).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return job, false, nil
+	}
+	return job, err == nil, err
+}
+
+// UpdateFineTuneJobStatus records the latest status the provider reported for a job
+func UpdateFineTuneJobStatus(jobID, status, fineTunedModel string) error {
+	updates := map[string]interface{}{"status": status}
+	if fineTunedModel != "" {
+		updates["fine_tuned_model"] = fineTunedModel
+	}
+	return DB().Model(&models.FineTuneJobs{}).Where("job_id = ?", jobID).Updates(updates).Error
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanTrainingFile(t *testing.T) {
+	file := strings.Join([]string{
+		`{"messages": [{"role": "user", "content": "hello"}, {"role": "assistant", "content": "hi"}]}`,
+		``,
+		`{"messages": [{"role": "user", "content": [{"type": "text", "text": "look"}]}, {"role": "assistant", "content": "ok"}]}`,
+		`{"prompt": "say", "completion": "something"}`,
+		`{"input": {"messages": [{"role": "user", "content": "pick"}]}, "preferred_output": [{"role": "assistant", "content": "good"}], "non_preferred_output": [{"role": "assistant", "content": "bad"}]}`,
+	}, "\n")
+
+	var texts []string
+	var lines []int
+	scanned, err := ScanTrainingFile(strings.NewReader(file), func(line int, text string) error {
+		lines = append(lines, line)
+		texts = append(texts, text)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, scanned)
+	assert.Equal(t, []int{1, 3, 4, 5}, lines)
+	assert.Equal(t, []string{"hello\n\nhi", "look\n\nok", "say\n\nsomething", "pick\n\ngood\n\nbad"}, texts)
+}
+
+func TestScanTrainingFileErrors(t *testing.T) {
+	settings := AppConfig.Settings.FineTuning
+	defer func() { AppConfig.Settings.FineTuning = settings }()
+
+	refused := errors.New("refused")
+	_, err := ScanTrainingFile(strings.NewReader("{\"prompt\": \"a\"}\n{\"prompt\": \"secret\"}\n"), func(line int, text string) error {
+		if text == "secret" {
+			return refused
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, refused)
+
+	_, err = ScanTrainingFile(strings.NewReader("{\"prompt\": \"a\"}\nnot json\n"), func(int, string) error { return nil })
+	assert.ErrorContains(t, err, "line 2")
+
+	AppConfig.Settings.FineTuning = &FineTuning{MaxExamples: 2, MaxFileBytes: 1024}
+	scanned, err := ScanTrainingFile(strings.NewReader("{\"prompt\": \"a\"}\n\n{\"prompt\": \"b\"}\n\n"), func(int, string) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, 2, scanned)
+
+	// Examples past max_examples would reach the provider unchecked
+	checked := 0
+	scanned, err = ScanTrainingFile(strings.NewReader(strings.Repeat("{\"prompt\": \"a\"}\n", 10)), func(int, string) error {
+		checked++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrTooManyTrainingExamples)
+	assert.Equal(t, 2, scanned)
+	assert.Equal(t, 2, checked)
+
+	AppConfig.Settings.FineTuning = &FineTuning{MaxExamples: 100, MaxFileBytes: 64}
+	_, err = ScanTrainingFile(strings.NewReader(strings.Repeat("{\"prompt\": \"a\"}\n", 10)), func(int, string) error { return nil })
+	assert.ErrorIs(t, err, ErrTrainingFileTooLarge)
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"log"
+	"strings"
+
+	"github.com/openshieldai/openshield/models"
+)
+
+// finishReasons maps provider finish reasons to the normalized ones
+var finishReasons = map[string]map[string]models.FinishReason{
+	"openai": {
+		"stop":           models.Stop,
+		"length":         models.Length,
+		"tool_calls":     models.ToolCalls,
+		"function_call":  models.ToolCalls,
+		"content_filter": models.ContentFilter,
+	},
+	"anthropic": {
+		"end_turn":      models.Stop,
+		"stop_sequence": models.Stop,
+		"max_tokens":    models.Length,
+		"tool_use":      models.ToolCalls,
+		"refusal":       models.ContentFilter,
+	},
+	"gemini": {
+		"STOP":       models.Stop,
+		"MAX_TOKENS": models.Length,
+		"SAFETY":     models.ContentFilter,
+		"RECITATION": models.ContentFilter,
+	},
+}
+
+// GetFinishReasonsSettings returns the finish reason settings
+func GetFinishReasonsSettings() FinishReasons {
+	settings := FinishReasons{}
+	if finishReasons := GetConfig().Settings.FinishReasons; finishReasons != nil {
+		settings = *finishReasons
+	}
+	if !models.FinishReason(settings.Fallback).Valid() {
+		settings.Fallback = string(models.Error)
+	}
+	return settings
+}
+
+// mapFinishReason returns the normalized finish reason of a provider value,
+// from the mappings of the settings or the built-in ones, false if neither
+// maps it. Mappings to values outside of the taxonomy are ignored.
+func mapFinishReason(settings FinishReasons, provider string, reason string) (models.FinishReason, bool) {
+	// Viper lowercases map keys
+	if mapped, ok := settings.Mappings[strings.ToLower(provider)][strings.ToLower(reason)]; ok && models.FinishReason(mapped).Valid() {
+		return models.FinishReason(mapped), true
+	}
+	mapped, ok := finishReasons[provider][reason]
+	return mapped, ok
+}
+
+// NormalizeFinishReason maps a provider finish reason to the envelope value
+func NormalizeFinishReason(provider string, reason string) string {
+	if normalized, ok := mapFinishReason(GetFinishReasonsSettings(), provider, reason); ok {
+		return string(normalized)
+	}
+	return FinishUnknown
+}
+
+// UsageFinishReason returns the finish reason usage of a provider is recorded
+// with. Values of the taxonomy are kept, provider values are mapped, and values
+// without a mapping are recorded as the fallback of the settings and counted.
+func UsageFinishReason(provider string, reason string) models.FinishReason {
+	if reason == "" {
+		return models.Null
+	}
+	if models.FinishReason(reason).Valid() {
+		return models.FinishReason(reason)
+	}
+	settings := GetFinishReasonsSettings()
+	if mapped, ok := mapFinishReason(settings, provider, reason); ok {
+		return mapped
+	}
+	log.Printf("Finish reason %s of %s has no mapping, recording %s", reason, provider, settings.Fallback)
+	IncrCounter("usage.finish_reason_unmapped", 1, "provider:"+provider)
+	return models.FinishReason(settings.Fallback)
+}
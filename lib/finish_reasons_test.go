@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageFinishReason(t *testing.T) {
+	defer func(finishReasons *FinishReasons) { AppConfig.Settings.FinishReasons = finishReasons }(AppConfig.Settings.FinishReasons)
+	AppConfig.Settings.FinishReasons = nil
+
+	assert.Equal(t, models.Null, UsageFinishReason("openai", ""))
+	assert.Equal(t, models.ClientDisconnect, UsageFinishReason("openai", "client_disconnect"))
+	assert.Equal(t, models.ToolCalls, UsageFinishReason("openai", "function_call"))
+	assert.Equal(t, models.Stop, UsageFinishReason("anthropic", "end_turn"))
+	assert.Equal(t, models.Length, UsageFinishReason("anthropic", "max_tokens"))
+	assert.Equal(t, models.Error, UsageFinishReason("anthropic", "pause_turn"))
+
+	AppConfig.Settings.FinishReasons = &FinishReasons{
+		Fallback: "null",
+		Mappings: map[string]map[string]string{"anthropic": {"pause_turn": "stop", "max_tokens": "not_a_reason"}},
+	}
+	assert.Equal(t, models.Stop, UsageFinishReason("anthropic", "pause_turn"))
+	// Mappings outside of the taxonomy are ignored
+	assert.Equal(t, models.Length, UsageFinishReason("anthropic", "max_tokens"))
+	assert.Equal(t, models.Null, UsageFinishReason("anthropic", "something_new"))
+
+	AppConfig.Settings.FinishReasons = &FinishReasons{Fallback: "whatever"}
+	assert.Equal(t, models.Error, UsageFinishReason("gemini", "OTHER"))
+}
+
+func TestNormalizeFinishReason(t *testing.T) {
+	assert.Equal(t, FinishStop, NormalizeFinishReason("gemini", "STOP"))
+	assert.Equal(t, FinishContentFilter, NormalizeFinishReason("anthropic", "refusal"))
+	assert.Equal(t, FinishUnknown, NormalizeFinishReason("openai", "something_new"))
+}
@@ -12,6 +12,23 @@ func TokenizerHandler(c *fiber.Ctx) error {
 
 	modelID := c.Params("model")
 	body := string(c.Body())
+	enc, err := tokenizer.ForModel(tokenizerModel(modelID))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return c.Status(500).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ids, _, _ := enc.Encode(body)
+	return c.JSON(fiber.Map{
+		"model":   modelID,
+		"prompts": body,
+		"tokens":  len(ids),
+	})
+}
+
+func tokenizerModel(modelID string) tokenizer.Model {
 	var model tokenizer.Model
 	if strings.Contains(modelID, "davinci") {
 		model = tokenizer.Davinci
@@ -31,19 +48,42 @@ func TokenizerHandler(c *fiber.Ctx) error {
 	if strings.Contains(modelID, "gpt-4") {
 		model = tokenizer.GPT4
 	}
+	return model
+}
 
-	enc, err := tokenizer.ForModel(model)
+// codecForModel returns the tokenizer of a model, using the cl100k_base
+// encoding for models without a known tokenizer
+func codecForModel(modelID string) (tokenizer.Codec, error) {
+	enc, err := tokenizer.ForModel(tokenizerModel(modelID))
 	if err != nil {
-		fmt.Printf("Error: %v\n", err.Error())
-		return c.Status(500).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return tokenizer.Get(tokenizer.Cl100kBase)
 	}
+	return enc, nil
+}
 
-	ids, _, _ := enc.Encode(body)
-	return c.JSON(fiber.Map{
-		"model":   modelID,
-		"prompts": body,
-		"tokens":  len(ids),
-	})
+// CountTokens returns the number of tokens in text for a model
+func CountTokens(modelID string, text string) int {
+	enc, err := codecForModel(modelID)
+	if err != nil {
+		return 0
+	}
+	ids, _, _ := enc.Encode(text)
+	return len(ids)
+}
+
+// TruncateTokens returns the first n tokens of text for a model
+func TruncateTokens(modelID string, text string, n int) string {
+	enc, err := codecForModel(modelID)
+	if err != nil {
+		return text
+	}
+	ids, _, _ := enc.Encode(text)
+	if len(ids) <= n {
+		return text
+	}
+	truncated, err := enc.Decode(ids[:n])
+	if err != nil {
+		return text
+	}
+	return truncated
 }
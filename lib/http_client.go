@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var providerClients sync.Map
+
+// ProviderHTTPClient returns the HTTP client used for requests to an upstream
+// provider. Requests go through the configured egress proxy (or the proxy from
+// the environment) and trust the configured CA bundle in addition to the system pool.
+func ProviderHTTPClient(provider *ProviderConfig) (*http.Client, error) {
+	return ModelHTTPClient(provider, "")
+}
+
+// ModelHTTPClient returns the provider HTTP client with the timeouts configured for model
+func ModelHTTPClient(provider *ProviderConfig, model string) (*http.Client, error) {
+	if provider == nil {
+		provider = &ProviderConfig{}
+	}
+	timeouts := provider.TimeoutsFor(model)
+
+	key := fmt.Sprintf("%s|%s|%s|%d|%d|%d", ActiveCryptoProfile(), provider.Proxy, provider.CABundle,
+		timeouts.Connect, timeouts.ResponseHeader, timeouts.Total)
+	if client, ok := providerClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = EgressDialContext
+	if timeouts.Connect > 0 {
+		connectTimeout := time.Duration(timeouts.Connect) * time.Second
+		transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+			defer cancel()
+			return EgressDialContext(ctx, network, addr)
+		}
+	}
+	transport.ResponseHeaderTimeout = time.Duration(timeouts.ResponseHeader) * time.Second
+	if ActiveCryptoProfile() == CryptoProfileFIPS {
+		transport.TLSClientConfig = TLSConfig()
+	}
+
+	if provider.Proxy != "" {
+		proxyURL, err := url.Parse(provider.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if provider.CABundle != "" {
+		pem, err := os.ReadFile(provider.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", provider.CABundle)
+		}
+
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	client := &http.Client{Transport: &tracingTransport{next: &adaptiveTransport{next: &hashingTransport{next: transport}}}, Timeout: time.Duration(timeouts.Total) * time.Second}
+	actual, _ := providerClients.LoadOrStore(key, client)
+	return actual.(*http.Client), nil
+}
+
+// TimeoutsFor returns the provider timeouts with the non-zero overrides for model applied
+func (p *ProviderConfig) TimeoutsFor(model string) Timeouts {
+	var timeouts Timeouts
+	if p == nil {
+		return timeouts
+	}
+	if p.Timeouts != nil {
+		timeouts = *p.Timeouts
+	}
+
+	// Viper lowercases map keys
+	override, ok := p.ModelTimeouts[strings.ToLower(model)]
+	if !ok {
+		return timeouts
+	}
+	if override.Connect > 0 {
+		timeouts.Connect = override.Connect
+	}
+	if override.ResponseHeader > 0 {
+		timeouts.ResponseHeader = override.ResponseHeader
+	}
+	if override.Total > 0 {
+		timeouts.Total = override.Total
+	}
+	if override.StreamIdle > 0 {
+		timeouts.StreamIdle = override.StreamIdle
+	}
+	return timeouts
+}
@@ -0,0 +1,331 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	jobsLeaderKey      = "openshield:jobs:leader"
+	jobsLastRunKey     = "openshield:jobs:last_run"
+	jobsTriggerChannel = "openshield:jobs:trigger"
+
+	leaderTTL           = 15 * time.Second
+	leaderRenewInterval = 5 * time.Second
+)
+
+// renewLeaderScript extends the leader lock only if this instance still holds it
+var renewLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaderScript deletes the leader lock only if this instance holds it
+var releaseLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Job is a background task that runs on a single replica at a time
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus reports the runs of a job on the current leader
+type JobStatus struct {
+	Name         string        `json:"name"`
+	Interval     time.Duration `json:"interval"`
+	Enabled      bool          `json:"enabled"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	Running      bool          `json:"running"`
+	Runs         int           `json:"runs"`
+	Failures     int           `json:"failures"`
+}
+
+var jobs = struct {
+	sync.Mutex
+	registered map[string]Job
+	status     map[string]*JobStatus
+	leader     bool
+	instanceID string
+}{
+	registered: map[string]Job{},
+	status:     map[string]*JobStatus{},
+	instanceID: newInstanceID(),
+}
+
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// RegisterJob adds a job to the runner. The interval can be overridden in settings.jobs.
+func RegisterJob(job Job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	jobs.registered[job.Name] = job
+	jobs.status[job.Name] = &JobStatus{Name: job.Name, Interval: job.Interval, Enabled: true}
+}
+
+// JobStatuses returns the status of all registered jobs
+func JobStatuses() []JobStatus {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	statuses := make([]JobStatus, 0, len(jobs.status))
+	for name, status := range jobs.status {
+		s := *status
+		s.Interval, s.Enabled = jobSchedule(jobs.registered[name])
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// IsJobLeader reports whether this replica currently runs the background jobs
+func IsJobLeader() bool {
+	jobs.Lock()
+	defer jobs.Unlock()
+	return jobs.leader
+}
+
+// TriggerJob asks the leader to run a job immediately
+func TriggerJob(ctx context.Context, name string) error {
+	jobs.Lock()
+	_, ok := jobs.registered[name]
+	jobs.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown job: %s", name)
+	}
+
+	if !RedisConfigured() {
+		go runJob(ctx, name)
+		return nil
+	}
+	return Publish(ctx, jobsTriggerChannel, name)
+}
+
+// RunJobs runs the registered jobs on schedule while this replica holds the
+// leader lock. Without Redis the replica is always the leader.
+func RunJobs(ctx context.Context) {
+	if RedisConfigured() {
+		go Subscribe(ctx, jobsTriggerChannel, func(name string) {
+			if IsJobLeader() {
+				go runJob(ctx, name)
+			}
+		})
+	}
+
+	ticker := time.NewTicker(leaderRenewInterval)
+	defer ticker.Stop()
+	for {
+		leader := electLeader(ctx)
+		jobs.Lock()
+		elected := leader && !jobs.leader
+		if leader != jobs.leader {
+			log.Printf("Background job leadership changed: leader=%v instance=%s", leader, jobs.instanceID)
+		}
+		jobs.leader = leader
+		jobs.Unlock()
+
+		if elected {
+			loadJobLastRuns(ctx)
+		}
+
+		if leader {
+			runDueJobs(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			if leader && RedisConfigured() {
+				releaseLeaderScript.Run(context.Background(), RedisClient(), []string{jobsLeaderKey}, jobs.instanceID)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func electLeader(ctx context.Context) bool {
+	if !RedisConfigured() {
+		return true
+	}
+
+	acquired, err := RedisClient().SetNX(ctx, jobsLeaderKey, jobs.instanceID, leaderTTL).Result()
+	if err != nil {
+		log.Printf("Error acquiring job leader lock: %v", err)
+		return false
+	}
+	if acquired {
+		return true
+	}
+
+	return renewLeaderLock(ctx)
+}
+
+// renewLeaderLock extends the leader lock, false if this instance lost it
+func renewLeaderLock(ctx context.Context) bool {
+	renewed, err := renewLeaderScript.Run(ctx, RedisClient(), []string{jobsLeaderKey}, jobs.instanceID, leaderTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("Error renewing job leader lock: %v", err)
+		return false
+	}
+	return renewed == 1
+}
+
+// keepLeaderLock renews the leader lock until ctx is done, so a job running
+// longer than leaderTTL doesn't let another replica take the lock and run the
+// same jobs. The job is cancelled when the lock can't be renewed.
+func keepLeaderLock(ctx context.Context, name string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(leaderRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if renewLeaderLock(ctx) {
+				continue
+			}
+			log.Printf("Job %s cancelled, the job leader lock was lost", name)
+			jobs.Lock()
+			jobs.leader = false
+			jobs.Unlock()
+			cancel()
+			return
+		}
+	}
+}
+
+// loadJobLastRuns reads the last runs of the jobs from Redis, so a new leader
+// doesn't run every job again right after a failover
+func loadJobLastRuns(ctx context.Context) {
+	values, err := RedisClient().HGetAll(ctx, jobsLastRunKey).Result()
+	if err != nil {
+		log.Printf("Error reading job last runs: %v", err)
+		return
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	for name, value := range values {
+		status, ok := jobs.status[name]
+		if !ok {
+			continue
+		}
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if lastRun := time.UnixMilli(millis); lastRun.After(status.LastRun) {
+			status.LastRun = lastRun
+		}
+	}
+}
+
+func runDueJobs(ctx context.Context) {
+	jobs.Lock()
+	var due []string
+	for name, job := range jobs.registered {
+		interval, enabled := jobSchedule(job)
+		if enabled && time.Since(jobs.status[name].LastRun) >= interval {
+			due = append(due, name)
+		}
+	}
+	jobs.Unlock()
+
+	for _, name := range due {
+		runJob(ctx, name)
+	}
+}
+
+// runJob runs a job unless it is still running, from its schedule or a trigger
+func runJob(ctx context.Context, name string) {
+	jobs.Lock()
+	job := jobs.registered[name]
+	status := jobs.status[name]
+	if status.Running {
+		jobs.Unlock()
+		log.Printf("Job %s is still running, skipping this run", name)
+		return
+	}
+	status.Running = true
+	jobs.Unlock()
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	if RedisConfigured() {
+		go keepLeaderLock(jobCtx, name, cancel)
+	}
+	start := time.Now()
+	err := runJobSafely(jobCtx, job)
+	duration := time.Since(start)
+	lockLost := jobCtx.Err() != nil && ctx.Err() == nil
+	cancel()
+
+	jobs.Lock()
+	status.Running = false
+	status.LastRun = start
+	status.LastDuration = duration
+	status.Runs++
+	status.LastError = ""
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+	}
+	jobs.Unlock()
+
+	// The replica that took over the lock runs a job cancelled with it again
+	if RedisConfigured() && !lockLost {
+		if err := RedisClient().HSet(ctx, jobsLastRunKey, name, start.UnixMilli()).Err(); err != nil {
+			log.Printf("Error storing last run of job %s: %v", name, err)
+		}
+	}
+	if err != nil {
+		log.Printf("Job %s failed after %v: %v", name, duration, err)
+	} else {
+		log.Printf("Job %s finished in %v", name, duration)
+	}
+}
+
+// runJobSafely runs a job, turning a panic into an error so it can't take the server down
+func runJobSafely(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// jobSchedule returns the interval of a job and whether it is enabled, applying settings.jobs
+func jobSchedule(job Job) (time.Duration, bool) {
+	if jobConfig, ok := GetConfig().Settings.Jobs[job.Name]; ok {
+		interval := job.Interval
+		if jobConfig.Interval > 0 {
+			interval = time.Duration(jobConfig.Interval) * time.Second
+		}
+		return interval, !jobConfig.Disabled
+	}
+	return job.Interval, true
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunJobSkipsRunningJob(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	AppConfig.Settings.Redis = nil
+
+	var runs atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	RegisterJob(Job{Name: "test_running_guard", Interval: time.Hour, Run: func(ctx context.Context) error {
+		runs.Add(1)
+		close(started)
+		<-release
+		return nil
+	}})
+	defer func() {
+		jobs.Lock()
+		delete(jobs.registered, "test_running_guard")
+		delete(jobs.status, "test_running_guard")
+		jobs.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runJob(context.Background(), "test_running_guard")
+	}()
+	<-started
+	// A trigger while the scheduled run is going is skipped
+	runJob(context.Background(), "test_running_guard")
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), runs.Load())
+	for _, status := range JobStatuses() {
+		if status.Name == "test_running_guard" {
+			assert.False(t, status.Running)
+			assert.Equal(t, 1, status.Runs)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// GetStreamingInspectionSettings returns the streaming inspection settings with defaults applied
+func GetStreamingInspectionSettings() StreamingInspection {
+	settings := StreamingInspection{}
+	if inspection := GetConfig().Settings.StreamingInspection; inspection != nil {
+		settings = *inspection
+	}
+	if settings.ThresholdBytes <= 0 {
+		settings.ThresholdBytes = 1 << 20
+	}
+	return settings
+}
+
+// ReadChatCompletionBody reads the body of a chat completion request into a
+// buffer of its content length, so it is held once, and refuses bodies over
+// max_body_bytes of the streaming inspection settings with an
+// *http.MaxBytesError before reading them in full
+func ReadChatCompletionBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	settings := GetStreamingInspectionSettings()
+	body := r.Body
+	if settings.MaxBodyBytes > 0 {
+		if r.ContentLength > settings.MaxBodyBytes {
+			return nil, &http.MaxBytesError{Limit: settings.MaxBodyBytes}
+		}
+		body = http.MaxBytesReader(w, r.Body, settings.MaxBodyBytes)
+	}
+	var buffer bytes.Buffer
+	if r.ContentLength > 0 {
+		buffer.Grow(int(r.ContentLength))
+	}
+	if _, err := buffer.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ScanChatCompletion decodes a chat completion request for inspection with a
+// streaming scanner that takes its messages one at a time. The inline media of
+// content parts, such as base64 images, is left out of the result, so a large
+// upload is only held once, as the raw body. It reports whether anything was
+// left out, in which case the result must not be sent upstream.
+func ScanChatCompletion(body []byte) (goopenai.ChatCompletionRequest, bool, error) {
+	var req goopenai.ChatCompletionRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return req, false, err
+	}
+
+	partial := false
+	fields := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return req, false, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return req, false, fmt.Errorf("invalid request body: expected a key, got %v", token)
+		}
+		if key != "messages" {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return req, false, err
+			}
+			fields[key] = value
+			continue
+		}
+
+		if err := expectDelim(decoder, '['); err != nil {
+			return req, false, err
+		}
+		for decoder.More() {
+			var message goopenai.ChatCompletionMessage
+			if err := decoder.Decode(&message); err != nil {
+				return req, false, err
+			}
+			partial = stripInlineMedia(&message) || partial
+			req.Messages = append(req.Messages, message)
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return req, false, err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return req, false, err
+	}
+
+	// The other fields are small, they are decoded the usual way
+	messages := req.Messages
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return req, false, err
+	}
+	if err := json.Unmarshal(rest, &req); err != nil {
+		return req, false, err
+	}
+	req.Messages = messages
+	return req, partial, nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("invalid request body: expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// stripInlineMedia replaces data URLs of image parts by their media type prefix
+// and reports whether it did. The prefix is copied so the data can be freed.
+func stripInlineMedia(message *goopenai.ChatCompletionMessage) bool {
+	stripped := false
+	for i, part := range message.MultiContent {
+		if part.ImageURL == nil || !strings.HasPrefix(part.ImageURL.URL, "data:") {
+			continue
+		}
+		prefix := part.ImageURL.URL
+		if comma := strings.IndexByte(prefix, ','); comma >= 0 {
+			prefix = prefix[:comma+1]
+		}
+		imageURL := *part.ImageURL
+		imageURL.URL = strings.Clone(prefix)
+		message.MultiContent[i].ImageURL = &imageURL
+		stripped = true
+	}
+	return stripped
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanChatCompletion(t *testing.T) {
+	image := "data:image/png;base64," + strings.Repeat("A", 4096)
+	body := `{"model":"gpt-4o","temperature":0.5,"messages":[` +
+		`{"role":"system","content":"Be brief."},` +
+		`{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"` + image + `"}}]}` +
+		`],"max_tokens":100}`
+
+	req, partial, err := ScanChatCompletion([]byte(body))
+	assert.NoError(t, err)
+	assert.True(t, partial)
+	assert.Equal(t, "gpt-4o", req.Model)
+	assert.Equal(t, float32(0.5), req.Temperature)
+	assert.Equal(t, 100, req.MaxTokens)
+	assert.Len(t, req.Messages, 2)
+	assert.Equal(t, "Be brief.", req.Messages[0].Content)
+	assert.Equal(t, "What is this?", req.Messages[1].MultiContent[0].Text)
+	assert.Equal(t, "data:image/png;base64,", req.Messages[1].MultiContent[1].ImageURL.URL)
+
+	var full goopenai.ChatCompletionRequest
+	assert.NoError(t, json.Unmarshal([]byte(body), &full))
+	assert.Equal(t, image, full.Messages[1].MultiContent[1].ImageURL.URL)
+
+	_, partial, err = ScanChatCompletion([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`))
+	assert.NoError(t, err)
+	assert.False(t, partial)
+
+	_, _, err = ScanChatCompletion([]byte(`{"model":"gpt-4o","messages":{}}`))
+	assert.Error(t, err)
+}
+
+func TestReadChatCompletionBody(t *testing.T) {
+	defer func(inspection *StreamingInspection) { AppConfig.Settings.StreamingInspection = inspection }(AppConfig.Settings.StreamingInspection)
+	AppConfig.Settings.StreamingInspection = &StreamingInspection{MaxBodyBytes: 16}
+
+	body, err := ReadChatCompletionBody(httptest.NewRecorder(), httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"m"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"model":"m"}`, string(body))
+
+	var tooLarge *http.MaxBytesError
+	_, err = ReadChatCompletionBody(httptest.NewRecorder(), httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o-mini"}`)))
+	assert.True(t, errors.As(err, &tooLarge))
+
+	// Bodies without a content length are cut off at the limit while they are read
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", io.NopCloser(strings.NewReader(`{"model":"gpt-4o-mini"}`)))
+	r.ContentLength = -1
+	_, err = ReadChatCompletionBody(httptest.NewRecorder(), r)
+	assert.True(t, errors.As(err, &tooLarge))
+}
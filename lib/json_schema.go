@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema checks value, decoded from JSON, against a schema. It
+// supports the subset of JSON Schema used for tool arguments: type, enum,
+// properties, required, additionalProperties, items, pattern, minLength,
+// maxLength, minimum and maximum.
+func ValidateJSONSchema(schema map[string]interface{}, value interface{}) error {
+	return validateSchema(schema, value, "$")
+}
+
+func validateSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if expected, ok := schema["type"].(string); ok && !schemaTypeMatches(expected, value) {
+		return fmt.Errorf("%s must be of type %s", path, expected)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not one of the allowed values", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := v[fmt.Sprint(name)]; !ok {
+					return fmt.Errorf("%s.%v is required", path, name)
+				}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := validateSchema(propertySchema, v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern for %s: %v", path, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s does not match %s", path, pattern)
+			}
+		}
+		if minLength, ok := schema["minLength"].(float64); ok && float64(utf8.RuneCountInString(v)) < minLength {
+			return fmt.Errorf("%s is shorter than %v", path, minLength)
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && float64(utf8.RuneCountInString(v)) > maxLength {
+			return fmt.Errorf("%s is longer than %v", path, maxLength)
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && v < minimum {
+			return fmt.Errorf("%s is below %v", path, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && v > maximum {
+			return fmt.Errorf("%s is above %v", path, maximum)
+		}
+	}
+	return nil
+}
+
+func schemaTypeMatches(expected string, value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return expected == "object"
+	case []interface{}:
+		return expected == "array"
+	case string:
+		return expected == "string"
+	case bool:
+		return expected == "boolean"
+	case nil:
+		return expected == "null"
+	case float64:
+		return expected == "number" || (expected == "integer" && v == math.Trunc(v))
+	case json.Number:
+		return expected == "number" || expected == "integer"
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// ErrBurnInRateLimited is returned when an API key in its burn-in period exceeds the burn-in rate limit
+var ErrBurnInRateLimited = errors.New("rate limit of new API keys exceeded")
+
+// GetKeyBurnInSettings returns the burn-in settings of new API keys with defaults applied
+func GetKeyBurnInSettings() KeyBurnIn {
+	settings := KeyBurnIn{}
+	if burnIn := GetConfig().Settings.KeyBurnIn; burnIn != nil {
+		settings = *burnIn
+	}
+	if settings.Duration <= 0 {
+		settings.Duration = 7 * 24 * 3600
+	}
+	if settings.RateLimit <= 0 {
+		settings.RateLimit = 10
+	}
+	return settings
+}
+
+// BurnInEnds returns when an API key graduates from its burn-in period, and
+// false if it already did or burn-in is disabled
+func BurnInEnds(apiKey models.ApiKeys, now time.Time) (time.Time, bool) {
+	settings := GetKeyBurnInSettings()
+	if !settings.Enabled {
+		return time.Time{}, false
+	}
+	ends := apiKey.CreatedAt.Add(time.Duration(settings.Duration) * time.Second)
+	return ends, now.Before(ends)
+}
+
+// withKeyBurnIn marks requests of API keys in their burn-in period in the context
+func withKeyBurnIn(ctx context.Context, apiKey models.ApiKeys) context.Context {
+	ends, ok := BurnInEnds(apiKey, time.Now())
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, "keyBurnIn", ends)
+}
+
+// KeyBurnInActive reports whether the API key of the request is in its burn-in period
+func KeyBurnInActive(r *http.Request) bool {
+	_, ok := r.Context().Value("keyBurnIn").(time.Time)
+	return ok
+}
+
+// ApplyKeyBurnIn restricts a request of an API key in its burn-in period: it
+// enforces the burn-in rate limit and switches to the strict content profile
+func ApplyKeyBurnIn(r *http.Request) (*http.Request, error) {
+	ends, ok := r.Context().Value("keyBurnIn").(time.Time)
+	if !ok {
+		return r, nil
+	}
+
+	settings := GetKeyBurnInSettings()
+	apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	log.Printf("API key %s is in its burn-in period until %v", apiKeyId, ends)
+
+	count, err := countMinuteRequest(r.Context(), "burn_in", apiKeyId)
+	if err != nil {
+		log.Printf("Error counting requests of API key %s: %v", apiKeyId, err)
+	} else if count > settings.RateLimit {
+		return r, fmt.Errorf("%w: %d requests per minute until %s", ErrBurnInRateLimited, settings.RateLimit, ends.UTC().Format(time.RFC3339))
+	}
+
+	if settings.Strict && !StrictContentActive(r) {
+		ctx := context.WithValue(r.Context(), "strictContent", true)
+		ctx = context.WithValue(ctx, "config", StrictConfig(GetRequestConfig(r)))
+		r = r.WithContext(ctx)
+	}
+	return r, nil
+}
+
+// burnInLogging reports whether the request is audit logged because its API key is in the burn-in period
+func burnInLogging(r *http.Request) bool {
+	return KeyBurnInActive(r) && GetKeyBurnInSettings().LogRequests
+}
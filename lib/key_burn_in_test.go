@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyBurnIn(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(burnIn *KeyBurnIn) { AppConfig.Settings.KeyBurnIn = burnIn }(AppConfig.Settings.KeyBurnIn)
+	AppConfig.Settings.Redis = nil
+	AppConfig.Settings.KeyBurnIn = &KeyBurnIn{Enabled: true, Duration: 3600, RateLimit: 1, Strict: true}
+
+	now := time.Now()
+	newKey := models.ApiKeys{Base: models.Base{Id: uuid.New(), CreatedAt: now.Add(-time.Minute)}}
+	oldKey := models.ApiKeys{Base: models.Base{Id: uuid.New(), CreatedAt: now.Add(-2 * time.Hour)}}
+
+	ends, ok := BurnInEnds(newKey, now)
+	assert.True(t, ok)
+	assert.Equal(t, newKey.CreatedAt.Add(time.Hour), ends)
+	_, ok = BurnInEnds(oldKey, now)
+	assert.False(t, ok)
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	ctx := context.WithValue(r.Context(), "apiKeyId", newKey.Id)
+	r = r.WithContext(withKeyBurnIn(ctx, newKey))
+	assert.True(t, KeyBurnInActive(r))
+
+	restricted, err := ApplyKeyBurnIn(r)
+	assert.NoError(t, err)
+	assert.True(t, StrictContentActive(restricted))
+	_, err = ApplyKeyBurnIn(r)
+	assert.ErrorIs(t, err, ErrBurnInRateLimited)
+
+	graduated := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	graduated = graduated.WithContext(withKeyBurnIn(graduated.Context(), oldKey))
+	assert.False(t, KeyBurnInActive(graduated))
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KillSwitchModel    = "model"
+	KillSwitchProvider = "provider"
+	KillSwitchRoute    = "route"
+
+	killSwitchRedisKey = "openshield:kill_switches"
+	killSwitchChannel  = "openshield:kill_switches"
+)
+
+// KillSwitch disables a model, provider or route on every replica
+type KillSwitch struct {
+	Scope     string    `json:"scope"`
+	Target    string    `json:"target"`
+	Message   string    `json:"message"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var killSwitches struct {
+	sync.RWMutex
+	active map[string]KillSwitch
+}
+
+func killSwitchKey(scope string, target string) string {
+	return scope + ":" + target
+}
+
+// ValidKillSwitchScope reports whether scope is a known kill switch scope
+func ValidKillSwitchScope(scope string) bool {
+	return scope == KillSwitchModel || scope == KillSwitchProvider || scope == KillSwitchRoute
+}
+
+// SetKillSwitch stores a kill switch and notifies all replicas
+func SetKillSwitch(ctx context.Context, killSwitch KillSwitch) error {
+	if !ValidKillSwitchScope(killSwitch.Scope) {
+		return fmt.Errorf("invalid kill switch scope: %s", killSwitch.Scope)
+	}
+	if killSwitch.Scope == KillSwitchRoute {
+		killSwitch.Target = routeKillSwitchTarget(killSwitch.Target)
+	}
+	if killSwitch.Target == "" {
+		return fmt.Errorf("kill switch target is required")
+	}
+	if killSwitch.Message == "" {
+		killSwitch.Message = fmt.Sprintf("%s %s is temporarily unavailable", killSwitch.Scope, killSwitch.Target)
+	}
+	killSwitch.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(killSwitch)
+	if err != nil {
+		return err
+	}
+	if err := RedisClient().HSet(ctx, killSwitchRedisKey, killSwitchKey(killSwitch.Scope, killSwitch.Target), data).Err(); err != nil {
+		return err
+	}
+	return publishKillSwitchChange(ctx)
+}
+
+// RemoveKillSwitch deletes a kill switch and notifies all replicas
+func RemoveKillSwitch(ctx context.Context, scope string, target string) error {
+	if scope == KillSwitchRoute {
+		target = routeKillSwitchTarget(target)
+	}
+	if err := RedisClient().HDel(ctx, killSwitchRedisKey, killSwitchKey(scope, target)).Err(); err != nil {
+		return err
+	}
+	return publishKillSwitchChange(ctx)
+}
+
+func publishKillSwitchChange(ctx context.Context) error {
+	if err := LoadKillSwitches(ctx); err != nil {
+		return err
+	}
+	return Publish(ctx, killSwitchChannel, "reload")
+}
+
+// LoadKillSwitches replaces the local kill switches with the ones stored in Redis
+func LoadKillSwitches(ctx context.Context) error {
+	values, err := RedisClient().HGetAll(ctx, killSwitchRedisKey).Result()
+	if err != nil {
+		return err
+	}
+
+	active := make(map[string]KillSwitch, len(values))
+	for key, value := range values {
+		var killSwitch KillSwitch
+		if err := json.Unmarshal([]byte(value), &killSwitch); err != nil {
+			log.Printf("Error decoding kill switch %s: %v", key, err)
+			continue
+		}
+		active[key] = killSwitch
+	}
+
+	killSwitches.Lock()
+	killSwitches.active = active
+	killSwitches.Unlock()
+	return nil
+}
+
+// WatchKillSwitches keeps the local kill switches in sync with Redis until ctx is done.
+// Changes arrive through pub/sub; a periodic reload covers missed messages.
+func WatchKillSwitches(ctx context.Context) {
+	if err := LoadKillSwitches(ctx); err != nil {
+		log.Printf("Error loading kill switches: %v", err)
+	}
+
+	go Subscribe(ctx, killSwitchChannel, func(payload string) {
+		if err := LoadKillSwitches(ctx); err != nil {
+			log.Printf("Error loading kill switches: %v", err)
+		}
+	})
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := LoadKillSwitches(ctx); err != nil {
+				log.Printf("Error loading kill switches: %v", err)
+			}
+		}
+	}
+}
+
+// ListKillSwitches returns the active kill switches
+func ListKillSwitches() []KillSwitch {
+	killSwitches.RLock()
+	defer killSwitches.RUnlock()
+
+	list := make([]KillSwitch, 0, len(killSwitches.active))
+	for _, killSwitch := range killSwitches.active {
+		list = append(list, killSwitch)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return killSwitchKey(list[i].Scope, list[i].Target) < killSwitchKey(list[j].Scope, list[j].Target)
+	})
+	return list
+}
+
+// KillSwitchVersion returns a hash of the active kill switches
+func KillSwitchVersion() string {
+	data, _ := json.Marshal(ListKillSwitches())
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ActiveKillSwitch returns the kill switch for scope and target, if any
+func ActiveKillSwitch(scope string, target string) (KillSwitch, bool) {
+	killSwitches.RLock()
+	defer killSwitches.RUnlock()
+
+	killSwitch, ok := killSwitches.active[killSwitchKey(scope, target)]
+	return killSwitch, ok
+}
+
+// routeKillSwitchTarget returns the target route kill switches of path are
+// stored under, its /openai/v1 path without a trailing slash, so a kill switch
+// applies to the /v1 alias of its route too
+func routeKillSwitchTarget(path string) string {
+	return strings.TrimSuffix(CanonicalPath(path), "/")
+}
+
+// ActiveRouteKillSwitch returns the route kill switch of path, if any
+func ActiveRouteKillSwitch(path string) (KillSwitch, bool) {
+	return ActiveKillSwitch(KillSwitchRoute, routeKillSwitchTarget(path))
+}
+
+// KillSwitchMiddleware rejects requests to a disabled provider or route
+func KillSwitchMiddleware(provider string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if killSwitch, ok := ActiveKillSwitch(KillSwitchProvider, provider); ok {
+				KillSwitchResponse(w, killSwitch)
+				return
+			}
+			if killSwitch, ok := ActiveRouteKillSwitch(r.URL.Path); ok {
+				KillSwitchResponse(w, killSwitch)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// KillSwitchResponse writes the 503 response for a disabled model, provider or route
+func KillSwitchResponse(w http.ResponseWriter, killSwitch KillSwitch) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "60")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": killSwitch.Message,
+			"type":    "service_unavailable",
+			"param":   killSwitch.Scope,
+			"code":    "kill_switch",
+		},
+	})
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillSwitchMiddlewareRouteAlias(t *testing.T) {
+	original := AppConfig.Settings.SDKCompatibility
+	AppConfig.Settings.SDKCompatibility = &SDKCompatibility{Enabled: true}
+	killSwitches.Lock()
+	active := killSwitches.active
+	killSwitches.active = map[string]KillSwitch{
+		killSwitchKey(KillSwitchRoute, "/openai/v1/chat/completions"): {Scope: KillSwitchRoute, Target: "/openai/v1/chat/completions"},
+	}
+	killSwitches.Unlock()
+	defer func() {
+		AppConfig.Settings.SDKCompatibility = original
+		killSwitches.Lock()
+		killSwitches.active = active
+		killSwitches.Unlock()
+	}()
+
+	handler := KillSwitchMiddleware("openai")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for path, expected := range map[string]int{
+		"/openai/v1/chat/completions":  http.StatusServiceUnavailable,
+		"/openai/v1/chat/completions/": http.StatusServiceUnavailable,
+		"/v1/chat/completions":         http.StatusServiceUnavailable,
+		"/v1/embeddings":               http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		assert.Equal(t, expected, w.Code, path)
+	}
+	assert.Equal(t, "/openai/v1/chat/completions", routeKillSwitchTarget("/v1/chat/completions/"))
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds the samples kept per model
+const maxLatencySamples = 10000
+
+// latencySample is one upstream request. FirstToken is zero for requests that weren't streamed.
+type latencySample struct {
+	at         time.Time
+	firstToken time.Duration
+	total      time.Duration
+}
+
+// LatencyPercentiles holds latency percentiles in milliseconds
+type LatencyPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// ModelLatency reports the latency of a model over the SLO window
+type ModelLatency struct {
+	Model      string             `json:"model"`
+	FirstToken LatencyPercentiles `json:"first_token"`
+	Total      LatencyPercentiles `json:"total"`
+}
+
+// BurnRate is how fast the error budget is spent, 1 spends it exactly over the window
+type BurnRate struct {
+	Requests int     `json:"requests"`
+	Slow     int     `json:"slow"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// ModelSLO reports the burn rate of the latency SLO of a model over the full
+// window and over a short window of a twelfth of it
+type ModelSLO struct {
+	Model       string   `json:"model"`
+	Objective   float64  `json:"objective"`
+	LongWindow  BurnRate `json:"long_window"`
+	ShortWindow BurnRate `json:"short_window"`
+}
+
+var latencies = struct {
+	sync.Mutex
+	samples map[string][]latencySample
+}{samples: map[string][]latencySample{}}
+
+// GetLatencySLOSettings returns the latency objectives with defaults applied
+func GetLatencySLOSettings() LatencySLO {
+	settings := LatencySLO{}
+	if slo := GetConfig().Settings.LatencySLO; slo != nil {
+		settings = *slo
+	}
+	if settings.Objective <= 0 || settings.Objective >= 1 {
+		settings.Objective = 0.99
+	}
+	if settings.FirstTokenMs <= 0 {
+		settings.FirstTokenMs = 2000
+	}
+	if settings.TotalMs <= 0 {
+		settings.TotalMs = 30000
+	}
+	if settings.Window <= 0 {
+		settings.Window = 3600
+	}
+	return settings
+}
+
+// RecordLatency records the latency of an upstream request. firstToken is zero
+// for requests that weren't streamed.
+func RecordLatency(model string, firstToken time.Duration, total time.Duration) {
+	window := time.Duration(GetLatencySLOSettings().Window) * time.Second
+	now := time.Now()
+
+	latencies.Lock()
+	defer latencies.Unlock()
+
+	samples := pruneLatencySamples(latencies.samples[model], now.Add(-window))
+	if len(samples) >= maxLatencySamples {
+		samples = samples[1:]
+	}
+	latencies.samples[model] = append(samples, latencySample{at: now, firstToken: firstToken, total: total})
+}
+
+// pruneLatencySamples drops the samples taken before cutoff, samples are in time order
+func pruneLatencySamples(samples []latencySample, cutoff time.Time) []latencySample {
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].at.Before(cutoff) })
+	return samples[i:]
+}
+
+// windowSamples returns a copy of the samples of every model taken after cutoff
+func windowSamples(cutoff time.Time) map[string][]latencySample {
+	latencies.Lock()
+	defer latencies.Unlock()
+
+	result := make(map[string][]latencySample, len(latencies.samples))
+	for model, samples := range latencies.samples {
+		samples = pruneLatencySamples(samples, cutoff)
+		latencies.samples[model] = samples
+		if len(samples) > 0 {
+			result[model] = append([]latencySample(nil), samples...)
+		}
+	}
+	return result
+}
+
+// LatencyReport returns the latency percentiles per model over the SLO window
+func LatencyReport() []ModelLatency {
+	window := time.Duration(GetLatencySLOSettings().Window) * time.Second
+
+	var report []ModelLatency
+	for model, samples := range windowSamples(time.Now().Add(-window)) {
+		var firstTokens, totals []time.Duration
+		for _, sample := range samples {
+			if sample.firstToken > 0 {
+				firstTokens = append(firstTokens, sample.firstToken)
+			}
+			totals = append(totals, sample.total)
+		}
+		report = append(report, ModelLatency{
+			Model:      model,
+			FirstToken: percentiles(firstTokens),
+			Total:      percentiles(totals),
+		})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Model < report[j].Model })
+	return report
+}
+
+// SLOReport returns the burn rate of the latency SLO per model. A request is
+// slow when its first token or its total latency exceeds the threshold.
+func SLOReport() []ModelSLO {
+	settings := GetLatencySLOSettings()
+	window := time.Duration(settings.Window) * time.Second
+	now := time.Now()
+	shortCutoff := now.Add(-window / 12)
+
+	var report []ModelSLO
+	for model, samples := range windowSamples(now.Add(-window)) {
+		slo := ModelSLO{Model: model, Objective: settings.Objective}
+		for _, sample := range samples {
+			slow := sample.total > time.Duration(settings.TotalMs)*time.Millisecond ||
+				sample.firstToken > time.Duration(settings.FirstTokenMs)*time.Millisecond
+			countSample(&slo.LongWindow, slow)
+			if !sample.at.Before(shortCutoff) {
+				countSample(&slo.ShortWindow, slow)
+			}
+		}
+		slo.LongWindow.BurnRate = burnRate(slo.LongWindow, settings.Objective)
+		slo.ShortWindow.BurnRate = burnRate(slo.ShortWindow, settings.Objective)
+		report = append(report, slo)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Model < report[j].Model })
+	return report
+}
+
+func countSample(rate *BurnRate, slow bool) {
+	rate.Requests++
+	if slow {
+		rate.Slow++
+	}
+}
+
+func burnRate(rate BurnRate, objective float64) float64 {
+	if rate.Requests == 0 {
+		return 0
+	}
+	return (float64(rate.Slow) / float64(rate.Requests)) / (1 - objective)
+}
+
+func percentiles(durations []time.Duration) LatencyPercentiles {
+	if len(durations) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return LatencyPercentiles{
+		Count: len(durations),
+		P50:   percentile(durations, 0.50),
+		P95:   percentile(durations, 0.95),
+		P99:   percentile(durations, 0.99),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted durations in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank].Microseconds()) / 1000
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	result := percentiles(durations)
+	assert.Equal(t, 100, result.Count)
+	assert.Equal(t, 50.0, result.P50)
+	assert.Equal(t, 95.0, result.P95)
+	assert.Equal(t, 99.0, result.P99)
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	model := "slo-test-model"
+	for i := 0; i < 98; i++ {
+		RecordLatency(model, 100*time.Millisecond, time.Second)
+	}
+	RecordLatency(model, 5*time.Second, 6*time.Second)
+	RecordLatency(model, 0, time.Minute)
+
+	for _, slo := range SLOReport() {
+		if slo.Model != model {
+			continue
+		}
+		assert.Equal(t, 100, slo.LongWindow.Requests)
+		assert.Equal(t, 2, slo.LongWindow.Slow)
+		assert.InDelta(t, 2.0, slo.LongWindow.BurnRate, 0.0001)
+		return
+	}
+	t.Fatal("model missing from SLO report")
+}
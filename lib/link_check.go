@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// linkCheckTimeout bounds the request made to verify a single link
+const linkCheckTimeout = 5 * time.Second
+
+// linkChecksTimeout bounds the time the links of one completion are checked in
+const linkChecksTimeout = 10 * time.Second
+
+// linkCheckConcurrency is how many links of a completion are checked at once
+const linkCheckConcurrency = 8
+
+// defaultLinkCacheTTL is how long a link check result is reused, in seconds
+const defaultLinkCacheTTL = 3600
+
+// transientLinkCacheTTL is how long a failure that may pass on a retry, a
+// timeout or a server error, is reused
+const transientLinkCacheTTL = time.Minute
+
+// maxLinkChecks bounds the cached results, the least recently used are dropped
+const maxLinkChecks = 10000
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]{}]+`)
+
+// linkDialer checks the address every connection is made to, so a host can't
+// pass the URL policy and then resolve to an internal address when it is
+// dialed. Links are checked without a proxy for the dialed address to be the
+// one of the link.
+var linkDialer = &net.Dialer{
+	Timeout: linkCheckTimeout,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if policy := GetConfig().Settings.URLPolicy; policy != nil && policy.AllowPrivateNetworks {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+			return fmt.Errorf("%w %s", errInternalLink, host)
+		}
+		return nil
+	},
+}
+
+var linkHTTPClient = &http.Client{
+	Timeout: linkCheckTimeout,
+	Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return egressDial(ctx, linkDialer, network, addr)
+	}},
+	// Redirects are followed only to destinations the URL policy allows
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		return CheckURL(req.URL.String())
+	},
+}
+
+type linkCheckResult struct {
+	link      string
+	err       error
+	expiresAt time.Time
+}
+
+// linkChecks caches the results of the checked links
+var linkChecks = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}{entries: map[string]*list.Element{}, order: list.New()}
+
+// cachedLinkCheck returns the cached result of link
+func cachedLinkCheck(link string) (error, bool) {
+	linkChecks.Lock()
+	defer linkChecks.Unlock()
+	element, ok := linkChecks.entries[link]
+	if !ok {
+		return nil, false
+	}
+	result := element.Value.(linkCheckResult)
+	if !time.Now().Before(result.expiresAt) {
+		linkChecks.order.Remove(element)
+		delete(linkChecks.entries, link)
+		return nil, false
+	}
+	linkChecks.order.MoveToFront(element)
+	return result.err, true
+}
+
+// cacheLinkCheck caches the result of link for ttl, dropping the least
+// recently used result once maxLinkChecks are cached
+func cacheLinkCheck(link string, err error, ttl time.Duration) {
+	linkChecks.Lock()
+	defer linkChecks.Unlock()
+	result := linkCheckResult{link: link, err: err, expiresAt: time.Now().Add(ttl)}
+	if element, ok := linkChecks.entries[link]; ok {
+		element.Value = result
+		linkChecks.order.MoveToFront(element)
+		return
+	}
+	linkChecks.entries[link] = linkChecks.order.PushFront(result)
+	if linkChecks.order.Len() > maxLinkChecks {
+		oldest := linkChecks.order.Back()
+		linkChecks.order.Remove(oldest)
+		delete(linkChecks.entries, oldest.Value.(linkCheckResult).link)
+	}
+}
+
+// errInternalLink is returned for links dialed at an internal address
+var errInternalLink = errors.New("link resolves to an internal address")
+
+// transientLinkError is the error of a link check that may pass on a retry
+type transientLinkError struct {
+	err error
+}
+
+func (e *transientLinkError) Error() string {
+	return e.err.Error()
+}
+
+// ExtractLinks returns the distinct http and https URLs in text, in order of appearance
+func ExtractLinks(text string) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,;:!?*_`")
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// CheckLink verifies that a link from a completion exists. Links on allowedDomains
+// pass without a request, others must pass the URL policy and answer a HEAD
+// request without an error status. Results are cached for ttl seconds, failures
+// that may pass on a retry for a minute at most.
+func CheckLink(ctx context.Context, link string, allowedDomains []string, ttl int) error {
+	if ttl <= 0 {
+		ttl = defaultLinkCacheTTL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return fmt.Errorf("invalid link: %v", err)
+	}
+	if len(allowedDomains) > 0 && domainAllowed(req.URL.Hostname(), allowedDomains) {
+		return nil
+	}
+
+	if err, ok := cachedLinkCheck(link); ok {
+		return err
+	}
+
+	err = headLink(req)
+	cacheTTL := time.Duration(ttl) * time.Second
+	var transient *transientLinkError
+	if errors.As(err, &transient) && cacheTTL > transientLinkCacheTTL {
+		cacheTTL = transientLinkCacheTTL
+	}
+	// Checks cut short by the deadline of the request say nothing about the link
+	if ctx.Err() == nil {
+		cacheLinkCheck(link, err, cacheTTL)
+	}
+	return err
+}
+
+// CheckLinks checks links concurrently, within linkChecksTimeout in total, and
+// returns the error of each link, in the order of links. Links not checked in
+// time are reported as such.
+func CheckLinks(ctx context.Context, links []string, allowedDomains []string, ttl int) []error {
+	ctx, cancel := context.WithTimeout(ctx, linkChecksTimeout)
+	defer cancel()
+
+	errs := make([]error, len(links))
+	slots := make(chan struct{}, linkCheckConcurrency)
+	var wg sync.WaitGroup
+	for i, link := range links {
+		wg.Add(1)
+		go func(i int, link string) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("link not checked in time: %v", ctx.Err())
+				return
+			}
+			errs[i] = CheckLink(ctx, link, allowedDomains, ttl)
+		}(i, link)
+	}
+	wg.Wait()
+	return errs
+}
+
+func headLink(req *http.Request) error {
+	if err := CheckURL(req.URL.String()); err != nil {
+		return err
+	}
+
+	resp, err := linkHTTPClient.Do(req)
+	if err != nil {
+		return linkRequestError(err)
+	}
+	resp.Body.Close()
+
+	// Some servers don't implement HEAD, fall back to a GET without reading the body
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		getReq := req.Clone(req.Context())
+		getReq.Method = http.MethodGet
+		resp, err = linkHTTPClient.Do(getReq)
+		if err != nil {
+			return linkRequestError(err)
+		}
+		resp.Body.Close()
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return &transientLinkError{fmt.Errorf("link returned status %d", resp.StatusCode)}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("link returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// linkRequestError returns the error of a failed link request, transient
+// unless the link resolved to an address it may not be checked at
+func linkRequestError(err error) error {
+	if errors.Is(err, errInternalLink) {
+		return fmt.Errorf("link does not resolve: %v", err)
+	}
+	return &transientLinkError{fmt.Errorf("link does not resolve: %v", err)}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractLinks(t *testing.T) {
+	text := "See https://example.com/docs. Also (http://example.org/a?b=1) and https://example.com/docs again."
+	assert.Equal(t, []string{"https://example.com/docs", "http://example.org/a?b=1"}, ExtractLinks(text))
+}
+
+func TestCheckLink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer func(policy *URLPolicy) { AppConfig.Settings.URLPolicy = policy }(AppConfig.Settings.URLPolicy)
+	AppConfig.Settings.URLPolicy = &URLPolicy{AllowPrivateNetworks: true}
+
+	assert.NoError(t, CheckLink(context.Background(), server.URL+"/found", nil, 0))
+	assert.Error(t, CheckLink(context.Background(), server.URL+"/missing", nil, 0))
+	assert.NoError(t, CheckLink(context.Background(), "https://docs.example.com/made-up", []string{"example.com"}, 0))
+}
+
+func TestCheckLinkRejectsInternal(t *testing.T) {
+	assert.Error(t, CheckLink(context.Background(), "http://127.0.0.1:1/", nil, 0))
+}
+
+func TestLinkDialerRejectsInternal(t *testing.T) {
+	// The address is checked when it is dialed, after the host resolved
+	assert.ErrorIs(t, linkDialer.Control("tcp", "127.0.0.1:80", nil), errInternalLink)
+	assert.ErrorIs(t, linkDialer.Control("tcp", "[fd00::1]:443", nil), errInternalLink)
+	assert.NoError(t, linkDialer.Control("tcp", "93.184.216.34:443", nil))
+
+	defer func(policy *URLPolicy) { AppConfig.Settings.URLPolicy = policy }(AppConfig.Settings.URLPolicy)
+	AppConfig.Settings.URLPolicy = &URLPolicy{AllowPrivateNetworks: true}
+	assert.NoError(t, linkDialer.Control("tcp", "127.0.0.1:80", nil))
+}
+
+func TestLinkCheckCache(t *testing.T) {
+	defer func() {
+		linkChecks.entries, linkChecks.order = map[string]*list.Element{}, list.New()
+	}()
+	for i := 0; i < maxLinkChecks; i++ {
+		cacheLinkCheck(fmt.Sprintf("https://example.com/%d", i), nil, time.Hour)
+	}
+	// The least recently used result is dropped once the cache is full
+	_, ok := cachedLinkCheck("https://example.com/0")
+	assert.True(t, ok)
+	cacheLinkCheck("https://example.com/new", nil, time.Hour)
+	assert.Equal(t, maxLinkChecks, linkChecks.order.Len())
+	_, ok = cachedLinkCheck("https://example.com/0")
+	assert.True(t, ok)
+	_, ok = cachedLinkCheck("https://example.com/1")
+	assert.False(t, ok)
+
+	cacheLinkCheck("https://example.com/expired", nil, -time.Second)
+	_, ok = cachedLinkCheck("https://example.com/expired")
+	assert.False(t, ok)
+}
+
+func TestCheckLinkTransient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(policy *URLPolicy) { AppConfig.Settings.URLPolicy = policy }(AppConfig.Settings.URLPolicy)
+	AppConfig.Settings.URLPolicy = &URLPolicy{AllowPrivateNetworks: true}
+
+	// Server errors may pass on a retry and are cached for a minute at most
+	assert.Error(t, CheckLink(context.Background(), server.URL+"/unavailable", nil, 0))
+	assert.Error(t, CheckLink(context.Background(), server.URL+"/missing", nil, 0))
+	linkChecks.Lock()
+	unavailable := linkChecks.entries[server.URL+"/unavailable"].Value.(linkCheckResult)
+	missing := linkChecks.entries[server.URL+"/missing"].Value.(linkCheckResult)
+	linkChecks.Unlock()
+	assert.WithinDuration(t, time.Now().Add(transientLinkCacheTTL), unavailable.expiresAt, 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(defaultLinkCacheTTL*time.Second), missing.expiresAt, 5*time.Second)
+}
+
+func TestCheckLinks(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			current := maxInFlight.Load()
+			if n <= current || maxInFlight.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(policy *URLPolicy) { AppConfig.Settings.URLPolicy = policy }(AppConfig.Settings.URLPolicy)
+	AppConfig.Settings.URLPolicy = &URLPolicy{AllowPrivateNetworks: true}
+
+	var links []string
+	for i := 0; i < 12; i++ {
+		path := "/found"
+		if i%3 == 0 {
+			path = "/missing"
+		}
+		links = append(links, fmt.Sprintf("%s%s/%d", server.URL, path, i))
+	}
+	errs := CheckLinks(context.Background(), links, nil, 0)
+	require.Len(t, errs, len(links))
+	for i, err := range errs {
+		if i%3 == 0 {
+			assert.Error(t, err, links[i])
+		} else {
+			assert.NoError(t, err, links[i])
+		}
+	}
+	assert.Greater(t, maxInFlight.Load(), int32(1))
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(linkCheckConcurrency))
+}
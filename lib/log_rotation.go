@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log file that is rotated once it reaches MaxSizeMB. Rotated
+// files are renamed with their rotation time, compressed if Compress is set,
+// and removed past MaxBackups or MaxAgeDays.
+type RotatingFile struct {
+	path     string
+	rotation LogRotation
+	header   string
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// retainMu runs the retention of one rotation at a time
+	retainMu sync.Mutex
+}
+
+// NewRotatingFile opens a rotating log file. A header is written at the start
+// of every new file.
+func NewRotatingFile(path string, rotation *LogRotation, header string) (*RotatingFile, error) {
+	file := &RotatingFile{path: path, header: header}
+	if rotation != nil {
+		file.rotation = *rotation
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.size == 0 && f.header != "" {
+		n, _ := io.WriteString(file, f.header)
+		f.size += int64(n)
+	}
+	return nil
+}
+
+// Write appends to the file, rotating it first if the write doesn't fit
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	maxSize := int64(f.rotation.MaxSizeMB) << 20
+	if maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > maxSize {
+		if err := f.rotate(); err != nil {
+			log.Printf("Error rotating %s: %v", f.path, err)
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file, so it is opened again at its path on the next write.
+// It lets external tools move the file away.
+func (f *RotatingFile) Reopen() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate renames the current file and opens a new one. The caller holds the lock.
+func (f *RotatingFile) rotate() error {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	rotated := f.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go f.retain(rotated)
+	return nil
+}
+
+// retain compresses a rotated file and removes the backups past the retention
+func (f *RotatingFile) retain(rotated string) {
+	f.retainMu.Lock()
+	defer f.retainMu.Unlock()
+	if f.rotation.Compress {
+		if err := compressFile(rotated); err != nil {
+			log.Printf("Error compressing %s: %v", rotated, err)
+		}
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	// Rotation times sort as strings, newest last
+	sort.Strings(backups)
+	cutoff := time.Now().UTC().AddDate(0, 0, -f.rotation.MaxAgeDays)
+	for i, backup := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(backup, f.path+"."), ".gz")
+		rotatedAt, err := time.Parse(rotatedTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		expired := f.rotation.MaxAgeDays > 0 && rotatedAt.Before(cutoff)
+		extra := f.rotation.MaxBackups > 0 && i < len(backups)-f.rotation.MaxBackups
+		if expired || extra {
+			os.Remove(backup)
+		}
+	}
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+var logFile *RotatingFile
+
+// SetupLogFile sends the application logs and the request logs to the
+// configured log file. It must run before the router is built.
+func SetupLogFile() error {
+	settings := GetConfig().Settings.LogFile
+	if settings == nil || settings.Path == "" {
+		return nil
+	}
+	file, err := NewRotatingFile(settings.Path, settings.Rotation, "")
+	if err != nil {
+		return err
+	}
+	logFile = file
+	log.SetOutput(file)
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{
+		Logger:  log.New(file, "", log.LstdFlags),
+		NoColor: true,
+	})
+	return nil
+}
+
+// ReopenLogFiles reopens the log file and the access log, sent on SIGHUP
+func ReopenLogFiles() {
+	if logFile != nil {
+		logFile.Reopen()
+	}
+	ReopenAccessLog()
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openshield.log")
+	file, err := NewRotatingFile(path, &LogRotation{MaxSizeMB: 1, MaxBackups: 2, Compress: true}, "#header\n")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	line := strings.Repeat("x", 1<<19) + "\n"
+	for i := 0; i < 8; i++ {
+		_, err := file.Write([]byte(line))
+		assert.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	assert.Eventually(t, func() bool {
+		backups, _ := filepath.Glob(path + ".*")
+		for _, backup := range backups {
+			if !strings.HasSuffix(backup, ".gz") {
+				return false
+			}
+		}
+		return len(backups) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "#header\n"))
+	assert.LessOrEqual(t, len(content), 1<<20)
+}
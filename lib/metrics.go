@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Metrics exporters
+const (
+	MetricsDogStatsD = "dogstatsd"
+)
+
+var statsd struct {
+	sync.Mutex
+	address string
+	conn    net.Conn
+}
+
+// GetMetricsExporterSettings returns the metrics exporter settings with defaults
+// applied. Exporter is empty when no exporter is configured.
+func GetMetricsExporterSettings() MetricsExporter {
+	settings := MetricsExporter{}
+	if observability := GetConfig().Settings.Observability; observability != nil && observability.Metrics != nil {
+		settings = *observability.Metrics
+	}
+	if settings.Address == "" {
+		settings.Address = "127.0.0.1:8125"
+	}
+	if settings.Prefix == "" {
+		settings.Prefix = "openshield"
+	}
+	return settings
+}
+
+// IncrCounter adds to a counter of the metrics exporter
+func IncrCounter(name string, value int64, tags ...string) {
+	sendMetric(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+// SetGauge sets a gauge of the metrics exporter
+func SetGauge(name string, value float64, tags ...string) {
+	sendMetric(name, fmt.Sprintf("%g|g", value), tags)
+}
+
+// RecordTiming records a duration with the metrics exporter
+func RecordTiming(name string, duration time.Duration, tags ...string) {
+	sendMetric(name, fmt.Sprintf("%g|ms", float64(duration.Microseconds())/1000), tags)
+}
+
+// FormatStatsD formats a metric as a DogStatsD datagram
+func FormatStatsD(prefix string, name string, value string, tags []string) string {
+	line := prefix + "." + name + ":" + value
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// sendMetric sends a metric to the exporter. Datagrams are fire and forget, a
+// missing agent never slows requests down.
+func sendMetric(name string, value string, tags []string) {
+	settings := GetMetricsExporterSettings()
+	if settings.Exporter != MetricsDogStatsD {
+		return
+	}
+	datagram := FormatStatsD(settings.Prefix, name, value, append(append([]string{}, settings.Tags...), tags...))
+
+	statsd.Lock()
+	defer statsd.Unlock()
+	if statsd.conn == nil || statsd.address != settings.Address {
+		if statsd.conn != nil {
+			statsd.conn.Close()
+		}
+		conn, err := net.Dial("udp", settings.Address)
+		if err != nil {
+			log.Printf("Error connecting to DogStatsD: %v", err)
+			statsd.conn = nil
+			return
+		}
+		statsd.conn, statsd.address = conn, settings.Address
+	}
+	statsd.conn.Write([]byte(datagram))
+}
+
+// MetricsMiddleware counts and times requests by route and status
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetMetricsExporterSettings().Exporter == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := "unmatched"
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			route = routeContext.RoutePattern()
+		}
+		tags := append([]string{"route:" + route, "method:" + r.Method, fmt.Sprintf("status:%d", status)}, TenantTags(r)...)
+		IncrCounter("requests", 1, tags...)
+		RecordTiming("request.duration", time.Since(start), tags...)
+	})
+}
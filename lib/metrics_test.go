@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatStatsD(t *testing.T) {
+	assert.Equal(t, "openshield.requests:1|c", FormatStatsD("openshield", "requests", "1|c", nil))
+	assert.Equal(t, "openshield.request.duration:12.5|ms|#route:/openai/v1/chat/completions,status:200",
+		FormatStatsD("openshield", "request.duration", "12.5|ms", []string{"route:/openai/v1/chat/completions", "status:200"}))
+}
+
+func TestIncrCounter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	observability := AppConfig.Settings.Observability
+	defer func() { AppConfig.Settings.Observability = observability }()
+	AppConfig.Settings.Observability = &Observability{Metrics: &MetricsExporter{
+		Exporter: MetricsDogStatsD,
+		Address:  conn.LocalAddr().String(),
+		Tags:     []string{"env:test"},
+	}}
+
+	IncrCounter("requests", 3, "status:200")
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "openshield.requests:3|c|#env:test,status:200", string(buf[:n]))
+}
+
+func TestDatadogTrace(t *testing.T) {
+	trace := Trace{
+		TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		Route:    "/openai/v1/chat/completions",
+		Method:   "POST",
+		Status:   502,
+		Start:    time.Unix(1700000000, 0),
+		Duration: 250 * time.Millisecond,
+		Reason:   "error",
+	}
+	spans := datadogTrace(TracesExporter{Service: "openshield", Env: "prod"}, trace)
+	assert.Len(t, spans, 1)
+	assert.Equal(t, uint64(0xa3ce929d0e0e4736), spans[0].TraceID)
+	assert.Equal(t, "POST /openai/v1/chat/completions", spans[0].Resource)
+	assert.Equal(t, int32(1), spans[0].Error)
+	assert.Equal(t, int64(250*time.Millisecond), spans[0].Duration)
+	assert.Equal(t, "prod", spans[0].Meta["env"])
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// modelApprovalTTL is how long the catalog status and approvals of a model are
+// cached, approvals made on other replicas take up to this long to apply
+const modelApprovalTTL = 30 * time.Second
+
+// ErrModelNotApproved is returned for models that aren't enabled for the workspace of the request
+var ErrModelNotApproved = errors.New("model is not approved for this workspace")
+
+type modelStatus struct {
+	known    bool
+	id       uuid.UUID
+	status   models.Status
+	approved map[uuid.UUID]bool
+	// deprecation is set for models an admin deprecated
+	deprecation *ModelDeprecation
+	expiresAt   time.Time
+}
+
+var modelStatuses sync.Map
+
+// GetModelApprovalSettings returns the model approval settings
+func GetModelApprovalSettings() ModelApproval {
+	if approval := GetConfig().Settings.ModelApproval; approval != nil {
+		return *approval
+	}
+	return ModelApproval{}
+}
+
+// loadModelStatus returns the catalog status of a model and the workspaces it is approved for
+func loadModelStatus(model string) (modelStatus, error) {
+	if cached, ok := modelStatuses.Load(model); ok && time.Now().Before(cached.(modelStatus).expiresAt) {
+		return cached.(modelStatus), nil
+	}
+
+	status := modelStatus{approved: map[uuid.UUID]bool{}, expiresAt: time.Now().Add(modelApprovalTTL)}
+	var aiModel models.AiModels
+	err := DB().Where("model = ?", model).First(&aiModel).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return modelStatus{}, err
+	}
+	if err == nil {
+		status.known = true
+		status.id = aiModel.Id
+		status.status = aiModel.Status
+		if aiModel.DeprecatedAt != nil {
+			status.deprecation = &ModelDeprecation{DeprecatedAt: *aiModel.DeprecatedAt, SunsetAt: aiModel.SunsetAt, Replacement: aiModel.Replacement}
+		}
+		var approvals []models.ModelApprovals
+		if err := DB().Where("ai_model_id = ?", aiModel.Id).Find(&approvals).Error; err != nil {
+			return modelStatus{}, err
+		}
+		for _, approval := range approvals {
+			status.approved[approval.WorkspaceID] = true
+		}
+	}
+	modelStatuses.Store(model, status)
+	return status, nil
+}
+
+// ModelAllowed reports whether the workspace of the request may use a model.
+// Active models are available to every workspace, pending models only to the
+// workspaces they were approved for.
+func ModelAllowed(r *http.Request, model string) bool {
+	settings := GetModelApprovalSettings()
+	if !settings.Enabled {
+		return true
+	}
+
+	status, err := loadModelStatus(model)
+	if err != nil {
+		log.Printf("Error loading model %s: %v", model, err)
+		return false
+	}
+	if !status.known {
+		return !settings.BlockUnknown
+	}
+	switch status.status {
+	case models.Active:
+		return true
+	case models.Pending:
+		workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+		return status.approved[workspaceID]
+	default:
+		return false
+	}
+}
+
+// SyncModelCatalog adds the upstream models missing from the catalog and updates
+// the metadata of every upstream model. With model approval enabled new models
+// are pending until an admin approves them.
+func SyncModelCatalog(ctx context.Context, family models.AiFamily, upstream []string) ([]string, error) {
+	var existing []models.AiModels
+	if err := DB().WithContext(ctx).Where("family = ?", family).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]*models.AiModels, len(existing))
+	for i := range existing {
+		known[existing[i].Model] = &existing[i]
+	}
+
+	status := models.Active
+	if GetModelApprovalSettings().Enabled {
+		status = models.Pending
+	}
+	var added []string
+	for _, model := range upstream {
+		metadata, _ := ModelMetadataFor(model)
+		if aiModel, ok := known[model]; ok {
+			changed, err := applyModelMetadata(aiModel, metadata)
+			if err != nil {
+				log.Printf("Error enriching model %s: %v", model, err)
+				continue
+			}
+			if changed {
+				if err := DB().WithContext(ctx).Save(aiModel).Error; err != nil {
+					return added, fmt.Errorf("failed to update model %s: %v", model, err)
+				}
+			}
+			continue
+		}
+
+		aiModel := models.AiModels{Family: family, ModelType: "LLM", Model: model, Status: status}
+		if _, err := applyModelMetadata(&aiModel, metadata); err != nil {
+			log.Printf("Error enriching model %s: %v", model, err)
+		}
+		if err := DB().WithContext(ctx).Create(&aiModel).Error; err != nil {
+			return added, fmt.Errorf("failed to add model %s: %v", model, err)
+		}
+		known[model] = &aiModel
+		added = append(added, model)
+	}
+	if len(added) > 0 {
+		log.Printf("Added %d models to the catalog with status %s: %v", len(added), status, added)
+	}
+	return added, nil
+}
+
+// PendingModels returns the models waiting for approval with the workspaces they are approved for
+func PendingModels(ctx context.Context) ([]models.AiModels, map[uuid.UUID][]uuid.UUID, error) {
+	var pending []models.AiModels
+	if err := DB().WithContext(ctx).Where("status = ?", models.Pending).Order("model").Find(&pending).Error; err != nil {
+		return nil, nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(pending))
+	for _, model := range pending {
+		ids = append(ids, model.Id)
+	}
+
+	workspaces := map[uuid.UUID][]uuid.UUID{}
+	if len(ids) == 0 {
+		return pending, workspaces, nil
+	}
+	var approvals []models.ModelApprovals
+	if err := DB().WithContext(ctx).Where("ai_model_id IN ?", ids).Find(&approvals).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, approval := range approvals {
+		workspaces[approval.AiModelID] = append(workspaces[approval.AiModelID], approval.WorkspaceID)
+	}
+	return pending, workspaces, nil
+}
+
+// ApproveModel enables a pending model for a workspace
+func ApproveModel(ctx context.Context, modelID uuid.UUID, workspaceID uuid.UUID, operator string) (models.AiModels, error) {
+	var aiModel models.AiModels
+	if err := DB().WithContext(ctx).Where("id = ?", modelID).First(&aiModel).Error; err != nil {
+		return models.AiModels{}, err
+	}
+	approval := models.ModelApprovals{AiModelID: modelID, WorkspaceID: workspaceID, ApprovedBy: operator}
+	err := DB().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ai_model_id"}, {Name: "workspace_id"}},
+		DoNothing: true,
+	}).Create(&approval).Error
+	if err != nil {
+		return models.AiModels{}, err
+	}
+	modelStatuses.Delete(aiModel.Model)
+	return aiModel, nil
+}
+
+// RevokeModelApproval disables a pending model for a workspace again
+func RevokeModelApproval(ctx context.Context, modelID uuid.UUID, workspaceID uuid.UUID) (models.AiModels, error) {
+	var aiModel models.AiModels
+	if err := DB().WithContext(ctx).Where("id = ?", modelID).First(&aiModel).Error; err != nil {
+		return models.AiModels{}, err
+	}
+	err := DB().WithContext(ctx).Unscoped().Where("ai_model_id = ? AND workspace_id = ?", modelID, workspaceID).Delete(&models.ModelApprovals{}).Error
+	if err != nil {
+		return models.AiModels{}, err
+	}
+	modelStatuses.Delete(aiModel.Model)
+	return aiModel, nil
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelAllowed(t *testing.T) {
+	defer func(approval *ModelApproval) { AppConfig.Settings.ModelApproval = approval }(AppConfig.Settings.ModelApproval)
+	AppConfig.Settings.ModelApproval = &ModelApproval{Enabled: true, BlockUnknown: true}
+
+	approved := uuid.New()
+	expiresAt := time.Now().Add(time.Minute)
+	modelStatuses.Store("gpt-4o", modelStatus{known: true, status: models.Active, expiresAt: expiresAt})
+	modelStatuses.Store("gpt-5-preview", modelStatus{known: true, status: models.Pending, approved: map[uuid.UUID]bool{approved: true}, expiresAt: expiresAt})
+	modelStatuses.Store("gpt-3", modelStatus{known: true, status: models.Archived, expiresAt: expiresAt})
+	modelStatuses.Store("unknown-model", modelStatus{expiresAt: expiresAt})
+	defer func() {
+		for _, model := range []string{"gpt-4o", "gpt-5-preview", "gpt-3", "unknown-model"} {
+			modelStatuses.Delete(model)
+		}
+	}()
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	inWorkspace := r.WithContext(context.WithValue(r.Context(), "workspaceId", approved))
+	otherWorkspace := r.WithContext(context.WithValue(r.Context(), "workspaceId", uuid.New()))
+
+	assert.True(t, ModelAllowed(otherWorkspace, "gpt-4o"))
+	assert.True(t, ModelAllowed(inWorkspace, "gpt-5-preview"))
+	assert.False(t, ModelAllowed(otherWorkspace, "gpt-5-preview"))
+	assert.False(t, ModelAllowed(inWorkspace, "gpt-3"))
+	assert.False(t, ModelAllowed(inWorkspace, "unknown-model"))
+
+	AppConfig.Settings.ModelApproval.BlockUnknown = false
+	assert.True(t, ModelAllowed(inWorkspace, "unknown-model"))
+}
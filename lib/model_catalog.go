@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshieldai/openshield/models"
+)
+
+// builtinModelMetadata is the metadata of well-known models, used when
+// settings.model_catalog doesn't list them. Dated snapshots share the metadata
+// of their model unless they are listed themselves.
+var builtinModelMetadata = []ModelMetadata{
+	{Model: "gpt-4o", ContextWindow: 128000, InputPrice: 2.5, OutputPrice: 10, Modalities: []string{"text", "image"}},
+	{Model: "gpt-4o-mini", ContextWindow: 128000, InputPrice: 0.15, OutputPrice: 0.6, Modalities: []string{"text", "image"}},
+	{Model: "gpt-4-turbo", ContextWindow: 128000, InputPrice: 10, OutputPrice: 30, Modalities: []string{"text", "image"}},
+	{Model: "gpt-4", ContextWindow: 8192, InputPrice: 30, OutputPrice: 60, Modalities: []string{"text"}},
+	{Model: "gpt-4-32k", ContextWindow: 32768, InputPrice: 60, OutputPrice: 120, Modalities: []string{"text"}},
+	{Model: "gpt-4-0314", ContextWindow: 8192, InputPrice: 30, OutputPrice: 60, Modalities: []string{"text"}, DeprecationDate: "2024-06-13"},
+	{Model: "gpt-3.5-turbo", ContextWindow: 16385, InputPrice: 0.5, OutputPrice: 1.5, Modalities: []string{"text"}},
+	{Model: "gpt-3.5-turbo-0613", ContextWindow: 4096, InputPrice: 1.5, OutputPrice: 2, Modalities: []string{"text"}, DeprecationDate: "2024-09-13"},
+	{Model: "gpt-3.5-turbo-16k-0613", ContextWindow: 16385, InputPrice: 3, OutputPrice: 4, Modalities: []string{"text"}, DeprecationDate: "2024-09-13"},
+	{Model: "text-embedding-3-small", ContextWindow: 8191, InputPrice: 0.02, Modalities: []string{"text"}},
+	{Model: "text-embedding-3-large", ContextWindow: 8191, InputPrice: 0.13, Modalities: []string{"text"}},
+	{Model: "text-embedding-ada-002", ContextWindow: 8191, InputPrice: 0.1, Modalities: []string{"text"}},
+}
+
+// ModelMetadataFor returns the metadata of a model from settings.model_catalog or
+// the built-in list. A model without an entry of its own gets the metadata of the
+// longest listed name it starts with, so gpt-4o-2024-08-06 is described as gpt-4o.
+func ModelMetadataFor(model string) (ModelMetadata, bool) {
+	for _, catalog := range [][]ModelMetadata{GetConfig().Settings.ModelCatalog, builtinModelMetadata} {
+		var best ModelMetadata
+		for _, metadata := range catalog {
+			if metadata.Model == model {
+				return metadata, true
+			}
+			if strings.HasPrefix(model, metadata.Model+"-") && len(metadata.Model) > len(best.Model) {
+				best = metadata
+			}
+		}
+		if best.Model != "" {
+			return best, true
+		}
+	}
+	return ModelMetadata{}, false
+}
+
+// applyModelMetadata copies metadata into a catalog model and reports whether it changed
+func applyModelMetadata(aiModel *models.AiModels, metadata ModelMetadata) (bool, error) {
+	var deprecation *time.Time
+	if metadata.DeprecationDate != "" {
+		date, err := time.Parse("2006-01-02", metadata.DeprecationDate)
+		if err != nil {
+			return false, fmt.Errorf("invalid deprecation date of model %s: %v", metadata.Model, err)
+		}
+		deprecation = &date
+	}
+	modalities := strings.Join(metadata.Modalities, ",")
+
+	changed := aiModel.ContextWindow != metadata.ContextWindow ||
+		aiModel.InputPrice != metadata.InputPrice ||
+		aiModel.OutputPrice != metadata.OutputPrice ||
+		aiModel.Modalities != modalities ||
+		(aiModel.DeprecationDate == nil) != (deprecation == nil) ||
+		deprecation != nil && !aiModel.DeprecationDate.Equal(*deprecation)
+
+	aiModel.ContextWindow = metadata.ContextWindow
+	aiModel.InputPrice = metadata.InputPrice
+	aiModel.OutputPrice = metadata.OutputPrice
+	aiModel.Modalities = modalities
+	aiModel.DeprecationDate = deprecation
+	return changed, nil
+}
+
+// ModelMetadataOf returns the metadata stored in a catalog model
+func ModelMetadataOf(aiModel models.AiModels) ModelMetadata {
+	metadata := ModelMetadata{
+		Model:         aiModel.Model,
+		ContextWindow: aiModel.ContextWindow,
+		InputPrice:    aiModel.InputPrice,
+		OutputPrice:   aiModel.OutputPrice,
+	}
+	if aiModel.Modalities != "" {
+		metadata.Modalities = strings.Split(aiModel.Modalities, ",")
+	}
+	if aiModel.DeprecationDate != nil {
+		metadata.DeprecationDate = aiModel.DeprecationDate.Format("2006-01-02")
+	}
+	return metadata
+}
+
+// CatalogModels returns the catalog entries of the models, all of them if none are given
+func CatalogModels(ctx context.Context, names ...string) ([]models.AiModels, error) {
+	query := DB().WithContext(ctx).Order("model")
+	if len(names) > 0 {
+		query = query.Where("model IN ?", names)
+	}
+	var catalog []models.AiModels
+	return catalog, query.Find(&catalog).Error
+}
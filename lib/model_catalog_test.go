@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelMetadataFor(t *testing.T) {
+	metadata, ok := ModelMetadataFor("gpt-4o-mini-2024-07-18")
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o-mini", metadata.Model)
+
+	metadata, ok = ModelMetadataFor("gpt-4-0314")
+	assert.True(t, ok)
+	assert.Equal(t, "2024-06-13", metadata.DeprecationDate)
+
+	metadata, _ = ModelMetadataFor("gpt-4-0613")
+	assert.Equal(t, "gpt-4", metadata.Model)
+
+	_, ok = ModelMetadataFor("davinci")
+	assert.False(t, ok)
+}
+
+func TestApplyModelMetadata(t *testing.T) {
+	metadata, _ := ModelMetadataFor("gpt-3.5-turbo-0613")
+	aiModel := models.AiModels{Model: "gpt-3.5-turbo-0613"}
+
+	changed, err := applyModelMetadata(&aiModel, metadata)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 4096, aiModel.ContextWindow)
+	assert.Equal(t, "text", aiModel.Modalities)
+	assert.Equal(t, metadata, ModelMetadataOf(aiModel))
+
+	changed, _ = applyModelMetadata(&aiModel, metadata)
+	assert.False(t, changed)
+
+	_, err = applyModelMetadata(&aiModel, ModelMetadata{Model: "broken", DeprecationDate: "soon"})
+	assert.Error(t, err)
+}
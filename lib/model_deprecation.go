@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// ErrModelSunset is returned for models requested after their sunset date
+var ErrModelSunset = errors.New("model has been sunset")
+
+// ModelDeprecation is the deprecation an admin set on a catalog model
+type ModelDeprecation struct {
+	DeprecatedAt time.Time  `json:"deprecated_at"`
+	SunsetAt     *time.Time `json:"sunset_at,omitempty"`
+	Replacement  string     `json:"replacement,omitempty"`
+}
+
+// Sunset reports whether the model is no longer served at now
+func (d ModelDeprecation) Sunset(now time.Time) bool {
+	return d.SunsetAt != nil && !now.Before(*d.SunsetAt)
+}
+
+// Warning returns the message clients of a deprecated model are sent
+func (d ModelDeprecation) Warning(model string) string {
+	warning := fmt.Sprintf("model %s is deprecated", model)
+	if d.SunsetAt != nil {
+		warning += fmt.Sprintf(" and will be sunset on %s", d.SunsetAt.UTC().Format(time.RFC3339))
+	}
+	if d.Replacement != "" {
+		warning += fmt.Sprintf(", use %s instead", d.Replacement)
+	}
+	return warning
+}
+
+// sunsetError returns the error of a request for a model after its sunset
+func (d ModelDeprecation) sunsetError(model string) error {
+	err := fmt.Errorf("%w: %s was sunset on %s", ErrModelSunset, model, d.SunsetAt.UTC().Format(time.RFC3339))
+	if d.Replacement != "" {
+		err = fmt.Errorf("%w, use %s instead", err, d.Replacement)
+	}
+	return err
+}
+
+// DeprecateModel marks a catalog model deprecated. A zero DeprecatedAt marks
+// it deprecated from now.
+func DeprecateModel(ctx context.Context, modelID uuid.UUID, deprecation ModelDeprecation) (models.AiModels, error) {
+	var aiModel models.AiModels
+	if err := DB().WithContext(ctx).Where("id = ?", modelID).First(&aiModel).Error; err != nil {
+		return models.AiModels{}, err
+	}
+	if deprecation.DeprecatedAt.IsZero() {
+		deprecation.DeprecatedAt = time.Now().UTC()
+	}
+	aiModel.DeprecatedAt = &deprecation.DeprecatedAt
+	aiModel.SunsetAt = deprecation.SunsetAt
+	aiModel.Replacement = deprecation.Replacement
+	err := DB().WithContext(ctx).Model(&aiModel).Select("deprecated_at", "sunset_at", "replacement").Updates(&aiModel).Error
+	if err != nil {
+		return models.AiModels{}, err
+	}
+	modelStatuses.Delete(aiModel.Model)
+	return aiModel, nil
+}
+
+// UndeprecateModel removes the deprecation of a catalog model
+func UndeprecateModel(ctx context.Context, modelID uuid.UUID) (models.AiModels, error) {
+	var aiModel models.AiModels
+	if err := DB().WithContext(ctx).Where("id = ?", modelID).First(&aiModel).Error; err != nil {
+		return models.AiModels{}, err
+	}
+	aiModel.DeprecatedAt, aiModel.SunsetAt, aiModel.Replacement = nil, nil, ""
+	err := DB().WithContext(ctx).Model(&aiModel).Select("deprecated_at", "sunset_at", "replacement").Updates(&aiModel).Error
+	if err != nil {
+		return models.AiModels{}, err
+	}
+	modelStatuses.Delete(aiModel.Model)
+	return aiModel, nil
+}
+
+// setDeprecationHeaders sets the Deprecation header of RFC 9745 and the Sunset
+// header of RFC 8594
+func setDeprecationHeaders(header http.Header, deprecation ModelDeprecation) {
+	header.Set("Deprecation", fmt.Sprintf("@%d", deprecation.DeprecatedAt.Unix()))
+	if deprecation.SunsetAt != nil {
+		header.Set("Sunset", deprecation.SunsetAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// CheckModelDeprecation sets the deprecation headers for a deprecated model and
+// returns the request with its warning for the response body. Requests after
+// the sunset return ErrModelSunset. Errors loading the model let the request
+// through without headers.
+func CheckModelDeprecation(w http.ResponseWriter, r *http.Request, model string) (*http.Request, error) {
+	status, err := loadModelStatus(model)
+	if err != nil {
+		log.Printf("Error loading model %s: %v", model, err)
+		return r, nil
+	}
+	if status.deprecation == nil {
+		return r, nil
+	}
+	deprecation := *status.deprecation
+	setDeprecationHeaders(w.Header(), deprecation)
+	if deprecation.Sunset(time.Now()) {
+		return r, deprecation.sunsetError(model)
+	}
+	return r.WithContext(context.WithValue(r.Context(), "modelWarnings", []string{deprecation.Warning(model)})), nil
+}
+
+// ModelWarnings returns the warnings added to the response body of the request
+func ModelWarnings(r *http.Request) []string {
+	warnings, _ := r.Context().Value("modelWarnings").([]string)
+	return warnings
+}
+
+// AddModelWarnings adds the warnings of the request to a JSON response body as
+// a warnings field, bodies of requests without warnings are returned as they are
+func AddModelWarnings(r *http.Request, body []byte) []byte {
+	warnings := ModelWarnings(r)
+	if len(warnings) == 0 {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	fields["warnings"], _ = json.Marshal(warnings)
+	withWarnings, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return withWarnings
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckModelDeprecation(t *testing.T) {
+	deprecatedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunsetAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	pastSunset := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Minute)
+	modelStatuses.Store("gpt-4o", modelStatus{known: true, status: models.Active, expiresAt: expiresAt})
+	modelStatuses.Store("gpt-4", modelStatus{known: true, status: models.Active, expiresAt: expiresAt,
+		deprecation: &ModelDeprecation{DeprecatedAt: deprecatedAt, SunsetAt: &sunsetAt, Replacement: "gpt-4o"}})
+	modelStatuses.Store("gpt-3.5-turbo", modelStatus{known: true, status: models.Active, expiresAt: expiresAt,
+		deprecation: &ModelDeprecation{DeprecatedAt: deprecatedAt, SunsetAt: &pastSunset, Replacement: "gpt-4o-mini"}})
+	defer func() {
+		for _, model := range []string{"gpt-4o", "gpt-4", "gpt-3.5-turbo"} {
+			modelStatuses.Delete(model)
+		}
+	}()
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	w := httptest.NewRecorder()
+	current, err := CheckModelDeprecation(w, r, "gpt-4o")
+	assert.NoError(t, err)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.Empty(t, ModelWarnings(current))
+	assert.Equal(t, `{"id":"1"}`, string(AddModelWarnings(current, []byte(`{"id":"1"}`))))
+
+	w = httptest.NewRecorder()
+	deprecated, err := CheckModelDeprecation(w, r, "gpt-4")
+	assert.NoError(t, err)
+	assert.Equal(t, "@1767225600", w.Header().Get("Deprecation"))
+	assert.Equal(t, sunsetAt.Format("Mon, 02 Jan 2006 15:04:05 GMT"), w.Header().Get("Sunset"))
+	assert.Len(t, ModelWarnings(deprecated), 1)
+	assert.Contains(t, ModelWarnings(deprecated)[0], "use gpt-4o instead")
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(AddModelWarnings(deprecated, []byte(`{"id":"1"}`)), &body))
+	assert.Equal(t, "1", body["id"])
+	assert.Equal(t, []interface{}{ModelWarnings(deprecated)[0]}, body["warnings"])
+
+	w = httptest.NewRecorder()
+	_, err = CheckModelDeprecation(w, r, "gpt-3.5-turbo")
+	assert.True(t, errors.Is(err, ErrModelSunset))
+	assert.Contains(t, err.Error(), "use gpt-4o-mini instead")
+	assert.NotEmpty(t, w.Header().Get("Sunset"))
+}
@@ -0,0 +1,138 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// maxFormField is the largest form field kept for the model check and the audit log
+const maxFormField = 4096
+
+// AudioTranscriptionHandler forwards an audio transcription upload to the provider
+func AudioTranscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	forwardAudioUpload(w, r, "/audio/transcriptions")
+}
+
+// AudioTranslationHandler forwards an audio translation upload to the provider
+func AudioTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	forwardAudioUpload(w, r, "/audio/translations")
+}
+
+// forwardAudioUpload buffers a multipart upload with the upload buffering
+// settings, so large files go to disk rather than memory, checks its model and
+// sends it to the provider as it came. The form fields are audit logged, the
+// file isn't.
+func forwardAudioUpload(w http.ResponseWriter, r *http.Request, path string) {
+	config := lib.GetRequestConfig(r)
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		handleError(w, fmt.Errorf("request body must be multipart/form-data"), http.StatusBadRequest)
+		return
+	}
+
+	body, err := lib.BufferBody(r.Body, r.ContentLength)
+	switch {
+	case errors.Is(err, lib.ErrUploadTooLarge):
+		handleError(w, err, http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, lib.ErrSpoolFull):
+		w.Header().Set("Retry-After", "5")
+		handleError(w, err, http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	fields, err := formFields(body, params["boundary"])
+	if err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	model := fields["model"]
+	lib.SetRequestModel(r, model)
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+	if !lib.ModelAllowed(r, model) {
+		handleError(w, fmt.Errorf("%w: %s", lib.ErrModelNotApproved, model), http.StatusForbidden)
+		return
+	}
+
+	if lib.AuditLoggingActive(r) {
+		record, _ := json.Marshal(map[string]interface{}{"path": path, "fields": fields, "bytes": body.Size()})
+		apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+		lib.AuditLogs(string(record), "openai_audio", apiKeyId, "input", r)
+	}
+
+	upload, err := body.Reader()
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusInternalServerError)
+		return
+	}
+	upstream, err := http.NewRequestWithContext(r.Context(), http.MethodPost, providerURL(config, model, path), upload)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	upstream.ContentLength = body.Size()
+	upstream.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	setProviderAuth(config, upstream.Header)
+	lib.GetOpenAIAccount(r.Context()).SetHeaders(upstream.Header)
+
+	client, err := lib.ModelHTTPClient(config.Providers.OpenAI, model)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp, err := providerClient(config, client).Do(upstream)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to send upload: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// formFields reads the text fields of a multipart upload, skipping its files
+func formFields(body *lib.BufferedBody, boundary string) (map[string]string, error) {
+	upload, err := body.Reader()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	reader := multipart.NewReader(upload, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormField))
+		if err != nil {
+			return nil, err
+		}
+		fields[part.FormName()] = string(value)
+	}
+}
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// modelsCacheScope is the cache scope of model lists and models, completions
+// are cached in the scope of their model
+const modelsCacheScope = "models"
+
+// deterministicRequest reports whether a chat completion request sets
+// temperature 0. Requests without a temperature are sampled at the default
+// of the provider and aren't cached.
+func deterministicRequest(body []byte) bool {
+	var params struct {
+		Temperature *float64 `json:"temperature"`
+	}
+	return json.Unmarshal(body, &params) == nil && params.Temperature != nil && *params.Temperature == 0
+}
+
+// completionCacheKey returns the cache key of a chat completion body, its
+// product and every parameter but the end user, so keys are independent of the
+// formatting of the body and products don't share completions. The body is
+// keyed as it came, inline images included, as the request may have been
+// decoded without them.
+func completionCacheKey(r *http.Request, body []byte) string {
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	var params map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if decoder.Decode(&params) != nil {
+		params = map[string]interface{}{"body": string(body)}
+	}
+	delete(params, "user")
+	// Maps are encoded with sorted keys
+	canonical, _ := json.Marshal(params)
+	return productID.String() + ":" + string(canonical)
+}
@@ -0,0 +1,33 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionCacheKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "productId", uuid.New()))
+	image := func(data string) []byte {
+		return []byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,` + data + `"}}]}]}`)
+	}
+
+	// Requests differing only in their inline images don't share completions
+	assert.NotEqual(t, completionCacheKey(r, image("iVBORw0KGgo=")), completionCacheKey(r, image("R0lGODlhAQAB")))
+
+	// The formatting of the body and the end user don't change the key
+	assert.Equal(t,
+		completionCacheKey(r, []byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"Hi"}],"user":"alice"}`)),
+		completionCacheKey(r, []byte(`{ "messages": [{"content": "Hi", "role": "user"}], "temperature": 0, "model": "gpt-4o" }`)))
+	assert.NotEqual(t,
+		completionCacheKey(r, []byte(`{"model":"gpt-4o","temperature":0,"max_tokens":10,"messages":[{"role":"user","content":"Hi"}]}`)),
+		completionCacheKey(r, []byte(`{"model":"gpt-4o","temperature":0,"max_tokens":20,"messages":[{"role":"user","content":"Hi"}]}`)))
+
+	other := r.WithContext(context.WithValue(r.Context(), "productId", uuid.New()))
+	assert.NotEqual(t, completionCacheKey(r, image("iVBORw0KGgo=")), completionCacheKey(other, image("iVBORw0KGgo=")))
+}
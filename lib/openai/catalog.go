@@ -0,0 +1,143 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/sashabaranov/go-openai"
+)
+
+// modelPricing is the price of a model in USD per million tokens
+type modelPricing struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// catalogModel is a provider model with the metadata of the catalog
+type catalogModel struct {
+	openai.Model
+	ContextWindow   int           `json:"context_window,omitempty"`
+	Pricing         *modelPricing `json:"pricing,omitempty"`
+	Modalities      []string      `json:"modalities,omitempty"`
+	DeprecationDate string        `json:"deprecation_date,omitempty"`
+}
+
+type catalogModelsList struct {
+	Object string         `json:"object"`
+	Models []catalogModel `json:"data"`
+}
+
+func init() {
+	lib.RegisterJob(lib.Job{
+		Name:     "sync_model_catalog",
+		Interval: time.Hour,
+		Run:      syncModelCatalog,
+	})
+}
+
+// syncModelCatalog adds the models the provider lists to the catalog
+func syncModelCatalog(ctx context.Context) error {
+	config := lib.GetConfig()
+	if config.Providers.OpenAI == nil || !config.Providers.OpenAI.Enabled {
+		return nil
+	}
+
+	client, err := newClient(config, "")
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+	list, err := client.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	upstream := make([]string, 0, len(list.Models))
+	for _, model := range list.Models {
+		upstream = append(upstream, model.ID)
+	}
+	_, err = lib.SyncModelCatalog(ctx, models.OpenAI, upstream)
+	return err
+}
+
+// modelsCacheKey caches the model list per workspace while model approval is
+// enabled, since workspaces see different models
+func modelsCacheKey(r *http.Request) string {
+	if !lib.GetModelApprovalSettings().Enabled {
+		return r.URL.Path
+	}
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+	return r.URL.Path + "?workspace=" + workspaceID.String()
+}
+
+// approvedModels drops the models the workspace of the request may not use from a model list
+func approvedModels(r *http.Request, list *openai.ModelsList) {
+	allowed := list.Models[:0]
+	for _, model := range list.Models {
+		if lib.ModelAllowed(r, model.ID) {
+			allowed = append(allowed, model)
+		}
+	}
+	list.Models = allowed
+}
+
+// catalogMetadata returns the catalog metadata of the models, from the synced
+// catalog or, for models it doesn't have yet, the built-in metadata
+func catalogMetadata(r *http.Request, names []string) map[string]lib.ModelMetadata {
+	metadata := map[string]lib.ModelMetadata{}
+	if len(names) == 0 {
+		return metadata
+	}
+	catalog, err := lib.CatalogModels(r.Context(), names...)
+	if err != nil {
+		log.Printf("Error loading model catalog: %v", err)
+	}
+	for _, aiModel := range catalog {
+		metadata[aiModel.Model] = lib.ModelMetadataOf(aiModel)
+	}
+	for _, name := range names {
+		if _, ok := metadata[name]; !ok {
+			if builtin, ok := lib.ModelMetadataFor(name); ok {
+				metadata[name] = builtin
+			}
+		}
+	}
+	return metadata
+}
+
+func withMetadata(model openai.Model, metadata lib.ModelMetadata) catalogModel {
+	enriched := catalogModel{
+		Model:           model,
+		ContextWindow:   metadata.ContextWindow,
+		Modalities:      metadata.Modalities,
+		DeprecationDate: metadata.DeprecationDate,
+	}
+	if metadata.InputPrice != 0 || metadata.OutputPrice != 0 {
+		enriched.Pricing = &modelPricing{Input: metadata.InputPrice, Output: metadata.OutputPrice}
+	}
+	return enriched
+}
+
+// enrichModels adds the catalog metadata to a model list
+func enrichModels(r *http.Request, list openai.ModelsList) catalogModelsList {
+	names := make([]string, 0, len(list.Models))
+	for _, model := range list.Models {
+		names = append(names, model.ID)
+	}
+	metadata := catalogMetadata(r, names)
+
+	enriched := catalogModelsList{Object: "list", Models: make([]catalogModel, 0, len(list.Models))}
+	for _, model := range list.Models {
+		enriched.Models = append(enriched.Models, withMetadata(model, metadata[model.ID]))
+	}
+	return enriched
+}
+
+// enrichModel adds the catalog metadata to a model
+func enrichModel(r *http.Request, model openai.Model) catalogModel {
+	return withMetadata(model, catalogMetadata(r, []string{model.ID})[model.ID])
+}
@@ -0,0 +1,61 @@
+package openai
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSCoalescedHeader is set on responses shared with identical concurrent requests
+const OSCoalescedHeader = "OS-Coalesced"
+
+// createChatCompletion sends a non-streamed completion upstream, racing two
+// providers if the model has a race route and sharing the call with identical
+// requests in flight when request coalescing is enabled. A raw body is sent as
+// it is instead of encoding req. It reports whether the response came from a race.
+func createChatCompletion(w http.ResponseWriter, r *http.Request, config lib.Configuration, client *openai.Client, req openai.ChatCompletionRequest, raw []byte) (openai.ChatCompletionResponse, bool, error) {
+	// The winner is only read once the call returned, coalesced calls run it on another goroutine
+	var winner string
+	send := func(ctx context.Context) (openai.ChatCompletionResponse, error) {
+		route, ok := lib.RaceRouteFor(r, req.Model)
+		if !ok {
+			if raw != nil {
+				return createRawChatCompletion(ctx, config, req.Model, raw)
+			}
+			return client.CreateChatCompletion(ctx, req)
+		}
+		resp, provider, err := raceChatCompletion(ctx, config, route, client, req)
+		winner = provider
+		return resp, err
+	}
+
+	resp, err := coalesce(w, r, req, send)
+	if err != nil {
+		return resp, false, err
+	}
+	if winner != "" {
+		w.Header().Set(OSRaceWinnerHeader, winner)
+	}
+	return resp, winner != "", nil
+}
+
+// coalesce runs send once for identical requests in flight when request coalescing is enabled
+func coalesce(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest, send func(context.Context) (openai.ChatCompletionResponse, error)) (openai.ChatCompletionResponse, error) {
+	if !lib.RequestCoalescingEnabled() {
+		return send(r.Context())
+	}
+	key, err := lib.CoalesceKey(r, req)
+	if err != nil {
+		log.Printf("Error hashing request for coalescing: %v", err)
+		return send(r.Context())
+	}
+
+	resp, shared, err := lib.CoalesceCompletion(r.Context(), key, send)
+	if shared {
+		w.Header().Set(OSCoalescedHeader, "true")
+	}
+	return resp, err
+}
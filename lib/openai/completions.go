@@ -0,0 +1,200 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+var completionsAPI = textAPI{
+	path:      "/completions",
+	logType:   "openai_completion",
+	usageType: "completion",
+	intercept: writeCompletionInterception,
+	streamChunk: func(usage *streamUsage, data []byte) {
+		var chunk openai.CompletionResponse
+		if json.Unmarshal(data, &chunk) != nil {
+			return
+		}
+		usage.add(completionChunk(chunk))
+	},
+}
+
+// CompletionHandler answers the legacy completions API. The prompts are checked
+// like the user message of a chat completion and the texts of the choices like
+// its output.
+func CompletionHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req openai.CompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	prompts, err := completionPrompts(req.Prompt)
+	if err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// The rules check the first user message, so the prompts are checked as one
+	chatReq := openai.ChatCompletionRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		N:           req.N,
+		Stream:      req.Stream,
+		Messages:    []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: strings.Join(prompts, "\n\n")}},
+	}
+	r, body, ok := checkTextRequest(w, r, completionsAPI, body, chatReq, func(messages []openai.ChatCompletionMessage) ([]byte, error) {
+		return redactedCompletionBody(body, prompts, messages[0].Content)
+	})
+	if !ok {
+		return
+	}
+
+	ctx, hashes := lib.WithContentHashes(r.Context())
+	resp, err := sendTextRequest(ctx, config, completionsAPI, req.Model, body, req.Stream)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to create completion: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+
+	if req.Stream {
+		usage := newStreamUsage(r.Context(), chatReq)
+		usage.hashes = hashes
+		usage.requestType = completionsAPI.usageType + "_stream"
+		relayTextStream(w, r, completionsAPI, resp, usage)
+		return
+	}
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+	var completion openai.CompletionResponse
+	if err := json.Unmarshal(response, &completion); err != nil {
+		handleProviderError(w, r, fmt.Errorf("error decoding response: %v", err), http.StatusBadGateway)
+		return
+	}
+	texts := make([]string, len(completion.Choices))
+	for i, choice := range completion.Choices {
+		texts[i] = choice.Text
+	}
+	checked, ok := checkTextOutput(w, r, completion.Model, texts)
+	if !ok {
+		return
+	}
+	// The response is only encoded again when the gateway changed a text
+	changed := false
+	for i := range completion.Choices {
+		if checked[i] != texts[i] {
+			completion.Choices[i].Text = checked[i]
+			changed = true
+		}
+	}
+	if changed {
+		response, _ = json.Marshal(completion)
+	}
+
+	performTextAuditLogging(r, completionsAPI, response)
+	finishReason := ""
+	if len(completion.Choices) > 0 {
+		finishReason = completion.Choices[0].FinishReason
+	}
+	lib.HashedUsage(r.Context(), hashes, completion.Model, 0, completion.Usage.PromptTokens, completion.Usage.CompletionTokens, completion.Usage.TotalTokens, finishReason, completionsAPI.usageType)
+	w.Write(response)
+}
+
+// completionPrompts returns the prompts of a completion request, a string or a
+// list of strings. Token arrays can't be checked by the rules and are refused.
+func completionPrompts(prompt any) ([]string, error) {
+	switch prompt := prompt.(type) {
+	case string:
+		return []string{prompt}, nil
+	case []any:
+		prompts := make([]string, 0, len(prompt))
+		for _, p := range prompt {
+			text, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("prompt must be a string or a list of strings")
+			}
+			prompts = append(prompts, text)
+		}
+		return prompts, nil
+	case nil:
+		return nil, fmt.Errorf("prompt is required")
+	}
+	return nil, fmt.Errorf("prompt must be a string or a list of strings")
+}
+
+// redactedCompletionBody returns body with prompt set to the prompts in the
+// redacted text they were checked as. Prompts that can't be told apart in it
+// anymore are refused, as they can't be sent with only the redactions applied.
+func redactedCompletionBody(body []byte, prompts []string, redacted string) ([]byte, error) {
+	if len(prompts) == 1 {
+		return replaceJSONField(body, "prompt", redacted)
+	}
+	split := strings.Split(redacted, "\n\n")
+	if len(split) != len(prompts) {
+		return nil, fmt.Errorf("the prompts redacted by the input rules can't be separated, send one prompt per request")
+	}
+	return replaceJSONField(body, "prompt", split)
+}
+
+// completionChunk converts a streamed completion for the usage of its stream
+func completionChunk(chunk openai.CompletionResponse) openai.ChatCompletionStreamResponse {
+	converted := openai.ChatCompletionStreamResponse{Model: chunk.Model}
+	if chunk.Usage.TotalTokens > 0 {
+		usage := chunk.Usage
+		converted.Usage = &usage
+	}
+	for _, choice := range chunk.Choices {
+		converted.Choices = append(converted.Choices, openai.ChatCompletionStreamChoice{
+			Index:        choice.Index,
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Content: choice.Text},
+			FinishReason: openai.FinishReason(choice.FinishReason),
+		})
+	}
+	return converted
+}
+
+// writeCompletionInterception answers a completion with the content of the
+// intercepting rule
+func writeCompletionInterception(w http.ResponseWriter, model string, stream bool, interception *rules.Interception) {
+	completion := openai.CompletionResponse{
+		ID:      "cmpl-os-" + uuid.NewString(),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.CompletionChoice{{Text: interception.Content, FinishReason: string(openai.FinishReasonContentFilter)}},
+	}
+	data, _ := json.Marshal(completion)
+	if !stream {
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+}
@@ -0,0 +1,74 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSTokensSavedHeader is the number of prompt tokens removed by prompt compression
+const OSTokensSavedHeader = "OS-Tokens-Saved"
+
+// OSContextTruncatedHeader is the number of messages dropped to fit the context window
+const OSContextTruncatedHeader = "OS-Context-Truncated"
+
+// fitContextWindow compresses the prompt of a request and makes it fit the
+// context window of its model by the configured policy, reporting what changed
+// in the response headers. It returns whether the request was changed.
+func fitContextWindow(w http.ResponseWriter, r *http.Request, config lib.Configuration, req *openai.ChatCompletionRequest) (bool, error) {
+	changed := false
+	if saved := lib.ApplyPromptCompression(r, req); saved > 0 {
+		w.Header().Set(OSTokensSavedHeader, strconv.Itoa(saved))
+		lib.RecordTransformation(r, "prompt_compression", fmt.Sprintf("%d tokens saved", saved))
+		changed = true
+	}
+
+	dropped, err := lib.FitContextWindow(r.Context(), req)
+	var lengthErr *lib.ContextLengthError
+	if errors.As(err, &lengthErr) && lib.GetContextWindowSettings().Policy == lib.ContextSummarize {
+		summarized, summaryErr := summarizeConversation(r.Context(), config, req)
+		if summaryErr != nil {
+			return changed, summaryErr
+		}
+		if summarized > 0 {
+			w.Header().Set(OSContextSummarizedHeader, strconv.Itoa(summarized))
+			lib.RecordTransformation(r, "context_summarization", fmt.Sprintf("%d messages summarized to fit the context window", summarized))
+			changed = true
+			dropped, err = lib.FitContextWindow(r.Context(), req)
+		}
+	}
+	if err != nil {
+		return changed, err
+	}
+	if dropped > 0 {
+		w.Header().Set(OSContextTruncatedHeader, strconv.Itoa(dropped))
+		lib.RecordTransformation(r, "context_truncation", fmt.Sprintf("%d messages dropped to fit the context window", dropped))
+		changed = true
+	}
+	return changed, nil
+}
+
+// writeContextLengthError rejects a request that doesn't fit the context window
+// of its model with the error code OpenAI uses, instead of the upstream 400
+func writeContextLengthError(w http.ResponseWriter, r *http.Request, err *lib.ContextLengthError) {
+	if lib.WantsEnvelope(r) {
+		lib.WriteEnvelopeError(w, providerName, http.StatusBadRequest, lib.ContextLengthExceededCode, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+			"param":   "messages",
+			"code":    lib.ContextLengthExceededCode,
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// DryRunResponse holds the verdicts of the input rules for a dry run and the
+// request as the gateway would have sent it
+type DryRunResponse struct {
+	Object   string                       `json:"object"`
+	Blocked  bool                         `json:"blocked"`
+	Verdicts []rules.Verdict              `json:"verdicts"`
+	Request  openai.ChatCompletionRequest `json:"request"`
+}
+
+// dryRun answers a request with the dry-run header with the verdicts of the
+// input rules, without calling the provider. Only API keys with the dry_run
+// scope can send dry runs. It reports false for other requests.
+func dryRun(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest) bool {
+	if !lib.DryRunRequested(r) {
+		return false
+	}
+	if !lib.KeyScoped(r, lib.ScopeDryRun) {
+		handleError(w, fmt.Errorf("dry runs need an API key with the %s scope", lib.ScopeDryRun), http.StatusForbidden)
+		return true
+	}
+
+	response := DryRunResponse{Object: "openshield.dry_run", Verdicts: rules.EvaluateInput(r, req), Request: req}
+	for _, verdict := range response.Verdicts {
+		response.Blocked = response.Blocked || verdict.Blocked
+	}
+	body, _ := json.Marshal(response)
+	if lib.AuditLoggingActive(r) {
+		apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+		lib.AuditLogs(string(body), "openshield_dry_run", apiKeyId, "output", r)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(lib.DryRunHeader, "true")
+	w.Write(body)
+	return true
+}
@@ -0,0 +1,199 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+var embeddingsAPI = textAPI{
+	path:      "/embeddings",
+	logType:   "openai_embeddings",
+	usageType: "embeddings",
+}
+
+// embeddingsRequest holds the fields of an embeddings request the gateway checks
+type embeddingsRequest struct {
+	Model string          `json:"model"`
+	Input json.RawMessage `json:"input"`
+}
+
+// embeddingsResponse holds the usage of an embeddings response
+type embeddingsResponse struct {
+	Model string `json:"model"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// embeddingsInput returns the texts of an embeddings input, a string or an
+// array of strings, and whether it was an array. Token inputs return no texts.
+func embeddingsInput(input json.RawMessage) ([]string, bool, error) {
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []string{text}, false, nil
+	}
+	var texts []string
+	if err := json.Unmarshal(input, &texts); err == nil {
+		return texts, true, nil
+	}
+	var tokens []json.RawMessage
+	if err := json.Unmarshal(input, &tokens); err == nil && len(tokens) > 0 {
+		return nil, true, nil
+	}
+	return nil, false, fmt.Errorf("input must be a string or an array of strings or tokens")
+}
+
+// inputRulesEnabled reports whether an input rule is enabled for the request
+func inputRulesEnabled(r *http.Request) bool {
+	for _, rule := range lib.GetRequestConfig(r).Rules.Input {
+		if rule.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// EmbeddingsHandler forwards an embeddings request to the provider. Every input
+// text is checked by the input rules as a user message of its own, and texts
+// the rules anonymized are sent as they left them. Token inputs can't be read
+// by the rules and are refused while input rules are enabled.
+func EmbeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req embeddingsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Model == "" || len(req.Input) == 0 {
+		handleError(w, fmt.Errorf("model and input are required"), http.StatusBadRequest)
+		return
+	}
+	texts, array, err := embeddingsInput(req.Input)
+	if err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+	r, ok := applyRequestPolicies(w, r, req.Model)
+	if !ok {
+		return
+	}
+	if texts == nil && inputRulesEnabled(r) {
+		handleError(w, fmt.Errorf("token inputs can't be checked by the input rules, send text"), http.StatusBadRequest)
+		return
+	}
+
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	changed := false
+	promptTokens := 0
+	for i, text := range texts {
+		chatReq := openai.ChatCompletionRequest{Model: req.Model, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: text}}}
+		if filtered, errorMessage, err := rules.Input(r, chatReq); filtered {
+			// Embeddings have no text to answer with, an intercepting rule refuses them
+			var interception *rules.Interception
+			if errors.As(err, &interception) {
+				w.Header().Set(OSInterceptedHeader, interception.Rule)
+				errorMessage = interception.Content
+			}
+			lib.AuditLogs(string(body), embeddingsAPI.logType, apiKeyId, "input", r)
+			lib.MarkRolloutBlocked(r)
+			lib.MarkRuleViolation(r)
+			handleBlocked(w, r, errorMessage, err)
+			return
+		}
+		if content := chatReq.Messages[0].Content; content != text {
+			texts[i], changed = content, true
+		}
+		promptTokens += lib.CountTokens(req.Model, texts[i])
+	}
+	if changed {
+		var input interface{} = texts[0]
+		if array {
+			input = texts
+		}
+		if body, err = replaceJSONField(body, "input", input); err != nil {
+			handleError(w, fmt.Errorf("error encoding request body: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	lib.AuditLogs(string(body), embeddingsAPI.logType, apiKeyId, "input", r)
+	lib.SaveRequestDiff(r, body)
+
+	if err := lib.ReserveCapacity(r, promptTokens); err != nil {
+		handleError(w, err, http.StatusTooManyRequests)
+		return
+	}
+	config := lib.GetRequestConfig(r)
+	resp, err := sendTextRequest(r.Context(), config, embeddingsAPI, req.Model, body, false)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to create embeddings: %w", newMetadataScrubber(config).error(err)), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	// The vectors aren't audit logged, only the usage of the request
+	var usage embeddingsResponse
+	json.Unmarshal(response, &usage)
+	model := usage.Model
+	if model == "" {
+		model = req.Model
+	}
+	lib.Usage(r.Context(), model, 0, usage.Usage.PromptTokens, 0, usage.Usage.TotalTokens, "", embeddingsAPI.usageType)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// recordUsage records the usage of a request, replaced in tests
+var recordUsage = lib.Usage
+
+// recordEmbeddingUsage records the usage of an embedding the gateway created on
+// its own for a request, the retrieval query of a RAG pipeline or the prompt of
+// a semantic cache lookup, under requestType
+func recordEmbeddingUsage(ctx context.Context, model string, resp openai.EmbeddingResponse, requestType string) {
+	if resp.Model != "" {
+		model = string(resp.Model)
+	}
+	recordUsage(ctx, model, 0, resp.Usage.PromptTokens, 0, resp.Usage.TotalTokens, "", requestType)
+}
+
+// replaceJSONField returns a JSON object with one field set to value and the
+// others kept as they came
+func replaceJSONField(body []byte, field string, value interface{}) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields[field] = encoded
+	return json.Marshal(fields)
+}
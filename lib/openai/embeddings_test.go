@@ -0,0 +1,33 @@
+package openai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordEmbeddingUsage(t *testing.T) {
+	type recorded struct {
+		model         string
+		prompt, total int
+		requestType   string
+	}
+	var got []recorded
+	saved := recordUsage
+	defer func() { recordUsage = saved }()
+	recordUsage = func(ctx context.Context, modelName string, predicted int, prompt int, completion int, total int, finishReason string, requestType string) {
+		got = append(got, recorded{modelName, prompt, total, requestType})
+	}
+
+	resp := openai.EmbeddingResponse{Model: openai.AdaEmbeddingV2}
+	resp.Usage.PromptTokens, resp.Usage.TotalTokens = 7, 7
+	recordEmbeddingUsage(context.Background(), "embedding-deployment", resp, "rag_embedding")
+	recordEmbeddingUsage(context.Background(), "embedding-deployment", openai.EmbeddingResponse{}, "semantic_cache_embedding")
+
+	assert.Equal(t, []recorded{
+		{string(openai.AdaEmbeddingV2), 7, 7, "rag_embedding"},
+		{"embedding-deployment", 0, 0, "semantic_cache_embedding"},
+	}, got)
+}
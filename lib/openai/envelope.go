@@ -0,0 +1,104 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+const providerName = "openai"
+
+// toEnvelope converts a chat completion to the gateway-native envelope
+func toEnvelope(resp openai.ChatCompletionResponse) lib.Envelope {
+	envelope := lib.Envelope{
+		ID:       resp.ID,
+		Provider: providerName,
+		Model:    resp.Model,
+		Created:  resp.Created,
+		Choices:  make([]lib.EnvelopeChoice, 0, len(resp.Choices)),
+		Usage: lib.EnvelopeUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		},
+	}
+
+	for _, choice := range resp.Choices {
+		envelopeChoice := lib.EnvelopeChoice{
+			Index:                choice.Index,
+			Content:              choice.Message.Content,
+			FinishReason:         lib.NormalizeFinishReason(providerName, string(choice.FinishReason)),
+			ProviderFinishReason: string(choice.FinishReason),
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			envelopeChoice.ToolCalls = append(envelopeChoice.ToolCalls, lib.EnvelopeToolCall{
+				ID:        toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+		if call := choice.Message.FunctionCall; call != nil {
+			envelopeChoice.ToolCalls = append(envelopeChoice.ToolCalls, lib.EnvelopeToolCall{Name: call.Name, Arguments: call.Arguments})
+		}
+		envelope.Choices = append(envelope.Choices, envelopeChoice)
+	}
+	return envelope
+}
+
+// writeCompletion writes a chat completion in the format the client asked for
+func writeCompletion(w http.ResponseWriter, r *http.Request, resp openai.ChatCompletionResponse) {
+	if lib.WantsEnvelope(r) {
+		envelope := toEnvelope(resp)
+		envelope.Warnings = lib.ModelWarnings(r)
+		lib.WriteEnvelope(w, envelope)
+		return
+	}
+	response, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, fmt.Errorf("error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Write(append(lib.AddModelWarnings(r, response), '\n'))
+}
+
+// writeCachedCompletion writes a cached chat completion in the format the client asked for
+func writeCachedCompletion(w http.ResponseWriter, r *http.Request, cached []byte) {
+	var resp openai.ChatCompletionResponse
+	if lib.WantsEnvelope(r) && json.Unmarshal(cached, &resp) == nil {
+		envelope := toEnvelope(resp)
+		envelope.Warnings = lib.ModelWarnings(r)
+		lib.WriteEnvelope(w, envelope)
+		return
+	}
+	w.Write(lib.AddModelWarnings(r, cached))
+}
+
+// handleProviderError writes an upstream error, as an error envelope if the client asked for one
+func handleProviderError(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+	statusCode = lib.DeadlineStatus(r, statusCode)
+	if !lib.WantsEnvelope(r) {
+		handleError(w, err, statusCode)
+		return
+	}
+
+	code := "provider_error"
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.HTTPStatusCode != 0 {
+			statusCode = apiErr.HTTPStatusCode
+		}
+		if apiErr.Type != "" {
+			code = apiErr.Type
+		}
+		if c, ok := apiErr.Code.(string); ok && c != "" {
+			code = c
+		}
+	} else if errors.Is(err, lib.ErrResponseTooLarge) {
+		code = "response_too_large"
+	}
+	lib.WriteEnvelopeError(w, providerName, statusCode, code, err.Error())
+}
@@ -0,0 +1,63 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleBlockedEnvelope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	r.Header.Set("Accept", lib.EnvelopeMediaType)
+
+	w := httptest.NewRecorder()
+	handleBlocked(w, r, "request blocked due to rule match", &rules.Violation{Rule: "injection", Type: "prompt_injection", Message: "prompt injection detected"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, lib.EnvelopeMediaType, w.Header().Get("Content-Type"))
+	var envelope lib.EnvelopeError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, lib.EnvelopeRuleViolation, envelope.Error.Code)
+	assert.Equal(t, "prompt injection detected", envelope.Error.Message)
+	assert.Equal(t, http.StatusForbidden, envelope.Error.Status)
+
+	w = httptest.NewRecorder()
+	handleBlocked(w, r, "request blocked due to rule match", errors.New("blocked"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	envelope = lib.EnvelopeError{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, lib.EnvelopeContentBlocked, envelope.Error.Code)
+
+	// Without the envelope, violations keep the 403 body of the gateway
+	r.Header.Del("Accept")
+	w = httptest.NewRecorder()
+	handleBlocked(w, r, "request blocked due to rule match", &rules.Violation{Rule: "injection", Type: "prompt_injection", Message: "prompt injection detected"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"type":"rule_violation"`)
+}
+
+func TestWriteStreamError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	var buf bytes.Buffer
+	writeStreamError(&buf, r, http.StatusBadGateway, "provider_error", `unexpected "EOF"`)
+	assert.Equal(t, "data: {\"error\":\"unexpected \\\"EOF\\\"\"}\n\n", buf.String())
+
+	r.Header.Set("Accept", lib.EnvelopeMediaType)
+	buf.Reset()
+	writeStreamError(&buf, r, http.StatusBadGateway, "provider_error", "unexpected EOF")
+	data, ok := strings.CutPrefix(buf.String(), "event: error\ndata: ")
+	require.True(t, ok)
+	var envelope lib.EnvelopeError
+	require.NoError(t, json.Unmarshal([]byte(data), &envelope))
+	assert.Equal(t, "provider_error", envelope.Error.Code)
+	assert.Equal(t, http.StatusBadGateway, envelope.Error.Status)
+	assert.Equal(t, providerName, envelope.Error.Provider)
+}
@@ -0,0 +1,82 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+)
+
+// maxEvaluateTexts is the largest batch the evaluate API takes
+const maxEvaluateTexts = 100
+
+// EvaluateRequest is a batch of texts checked by the rules of one direction,
+// "input" as prompts, the default, or "output" as completions
+type EvaluateRequest struct {
+	Texts     []string `json:"texts"`
+	Direction string   `json:"direction"`
+}
+
+// EvaluateResult holds the verdicts of the rules for one text of a batch
+type EvaluateResult struct {
+	Index    int             `json:"index"`
+	Blocked  bool            `json:"blocked"`
+	Content  string          `json:"content"`
+	Verdicts []rules.Verdict `json:"verdicts"`
+}
+
+// EvaluateHandler answers the rule verdicts of a batch of texts without calling
+// a provider, so other services can reuse the guardrails of the gateway
+func EvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	var req EvaluateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Texts) == 0 {
+		handleError(w, fmt.Errorf("texts are required"), http.StatusBadRequest)
+		return
+	}
+	if len(req.Texts) > maxEvaluateTexts {
+		handleError(w, fmt.Errorf("at most %d texts can be evaluated at once", maxEvaluateTexts), http.StatusBadRequest)
+		return
+	}
+	if req.Direction == "" {
+		req.Direction = "input"
+	}
+	if req.Direction != "input" && req.Direction != "output" {
+		handleError(w, fmt.Errorf("direction must be input or output"), http.StatusBadRequest)
+		return
+	}
+
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	results := make([]EvaluateResult, 0, len(req.Texts))
+	blocked := false
+	for i, text := range req.Texts {
+		verdicts, content, err := rules.Evaluate(r, req.Direction, text)
+		if err != nil {
+			handleError(w, err, http.StatusBadRequest)
+			return
+		}
+		result := EvaluateResult{Index: i, Content: content, Verdicts: verdicts}
+		for _, verdict := range verdicts {
+			result.Blocked = result.Blocked || verdict.Blocked
+		}
+		blocked = blocked || result.Blocked
+		results = append(results, result)
+	}
+	if blocked {
+		lib.MarkRuleViolation(r)
+	}
+
+	response, _ := json.Marshal(map[string]interface{}{"object": "list", "data": results})
+	if lib.AuditLoggingActive(r) {
+		body, _ := json.Marshal(req)
+		lib.AuditLogs(string(body), "openshield_evaluate", apiKeyId, "input", r)
+		lib.AuditLogs(string(response), "openshield_evaluate", apiKeyId, "output", r)
+	}
+	w.Write(response)
+}
@@ -0,0 +1,308 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fineTuningJobRequest holds the fields of a fine-tuning job request the gateway checks
+type fineTuningJobRequest struct {
+	Model          string `json:"model"`
+	TrainingFile   string `json:"training_file"`
+	ValidationFile string `json:"validation_file"`
+}
+
+// fineTuningJob holds the fields of a fine-tuning job the gateway records
+type fineTuningJob struct {
+	ID             string `json:"id"`
+	Model          string `json:"model"`
+	Status         string `json:"status"`
+	FineTunedModel string `json:"fine_tuned_model"`
+}
+
+// CreateFineTuningJobHandler scans the training and validation files of a job
+// with the input rules before creating it, and records the job for the
+// workspace of the request
+func CreateFineTuningJobHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req fineTuningJobRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.TrainingFile == "" {
+		handleError(w, fmt.Errorf("training_file is required"), http.StatusBadRequest)
+		return
+	}
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+	r, ok := applyRequestPolicies(w, r, req.Model)
+	if !ok {
+		return
+	}
+
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	lib.AuditLogs(string(body), "openai_fine_tuning_job", apiKeyId, "input", r)
+	scanned := 0
+	for _, file := range []string{req.TrainingFile, req.ValidationFile} {
+		if file == "" {
+			continue
+		}
+		n, err := scanTrainingFile(r, config, file)
+		var violation *trainingViolation
+		switch {
+		case errors.As(err, &violation):
+			lib.MarkRolloutBlocked(r)
+			lib.MarkRuleViolation(r)
+			handleError(w, err, http.StatusBadRequest)
+			return
+		case errors.Is(err, lib.ErrTrainingFileTooLarge), errors.Is(err, lib.ErrTooManyTrainingExamples):
+			handleError(w, fmt.Errorf("%s: %w", file, err), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			handleProviderError(w, r, fmt.Errorf("error scanning %s: %v", file, err), http.StatusBadGateway)
+			return
+		}
+		scanned += n
+	}
+
+	resp, err := sendFineTuningRequest(r.Context(), config, http.MethodPost, "/fine_tuning/jobs", body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to create fine-tuning job: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var job fineTuningJob
+	if err := json.Unmarshal(response, &job); err == nil && job.ID != "" {
+		workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+		productID, _ := r.Context().Value("productId").(uuid.UUID)
+		err := lib.RecordFineTuneJob(&models.FineTuneJobs{
+			JobID:           job.ID,
+			WorkspaceID:     workspaceID,
+			ProductID:       productID,
+			ApiKeyID:        apiKeyId,
+			Model:           req.Model,
+			TrainingFile:    req.TrainingFile,
+			ValidationFile:  req.ValidationFile,
+			ExamplesScanned: scanned,
+			Status:          job.Status,
+		})
+		if err != nil {
+			log.Printf("Error recording fine-tuning job %s: %v", job.ID, err)
+		}
+	}
+	lib.AuditLogs(string(response), "openai_fine_tuning_job", apiKeyId, "output", r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(response)
+}
+
+// trainingViolation is a training example refused by the input rules
+type trainingViolation struct {
+	file    string
+	line    int
+	message string
+}
+
+func (v *trainingViolation) Error() string {
+	return fmt.Sprintf("%s line %d: %s", v.file, v.line, v.message)
+}
+
+// scanTrainingFile downloads a file from the provider and checks its examples
+// with the input rules, each as the user message of a chat completion
+func scanTrainingFile(r *http.Request, config lib.Configuration, file string) (int, error) {
+	resp, err := sendFineTuningRequest(r.Context(), config, http.MethodGet, "/files/"+file+"/content", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, rawResponseError(resp)
+	}
+
+	return lib.ScanTrainingFile(resp.Body, func(line int, text string) error {
+		req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: text}}}
+		filtered, errorMessage, err := rules.Input(r, req)
+		if !filtered {
+			return nil
+		}
+		// A rule intercepting an example refuses it like a blocking rule
+		var interception *rules.Interception
+		if errors.As(err, &interception) {
+			errorMessage = interception.Rule
+		}
+		return &trainingViolation{file: file, line: line, message: errorMessage}
+	})
+}
+
+// ListFineTuningJobsHandler lists the fine-tuning jobs of the provider that the
+// workspace of the request created
+func ListFineTuningJobsHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+
+	path := "/fine_tuning/jobs"
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	resp, err := sendFineTuningRequest(r.Context(), config, http.MethodGet, path, nil)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to list fine-tuning jobs: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+
+	var list struct {
+		Object  string            `json:"object"`
+		Data    []json.RawMessage `json:"data"`
+		HasMore bool              `json:"has_more"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		handleProviderError(w, r, fmt.Errorf("error decoding response: %v", err), http.StatusBadGateway)
+		return
+	}
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+	jobs, err := lib.FineTuneJobs(workspaceID)
+	if err != nil {
+		handleError(w, fmt.Errorf("error loading fine-tuning jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	owned := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		owned[job.JobID] = true
+	}
+
+	data := []json.RawMessage{}
+	for _, item := range list.Data {
+		var job fineTuningJob
+		if json.Unmarshal(item, &job) == nil && owned[job.ID] {
+			data = append(data, item)
+		}
+	}
+	list.Data = data
+	if list.Object == "" {
+		list.Object = "list"
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// FineTuningJobHandler forwards the requests about one fine-tuning job, its
+// retrieval, cancellation, events and checkpoints, for jobs the workspace of the
+// request created. The status the provider reports is recorded.
+func FineTuningJobHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+	jobID := chi.URLParam(r, "job")
+
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+	_, found, err := lib.WorkspaceFineTuneJob(workspaceID, jobID)
+	if err != nil {
+		handleError(w, fmt.Errorf("error loading fine-tuning job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		writeJobNotFound(w, jobID)
+		return
+	}
+
+	path := strings.TrimPrefix(lib.CanonicalPath(r.URL.Path), lib.OpenAIPrefix)
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	resp, err := sendFineTuningRequest(r.Context(), config, r.Method, path, nil)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to send fine-tuning request: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var job fineTuningJob
+	if json.Unmarshal(response, &job) == nil && job.ID == jobID && job.Status != "" {
+		if err := lib.UpdateFineTuneJobStatus(jobID, job.Status, job.FineTunedModel); err != nil {
+			log.Printf("Error recording status of fine-tuning job %s: %v", jobID, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(response)
+}
+
+// writeJobNotFound answers like the provider does for jobs it doesn't know, so
+// the jobs of other workspaces can't be told apart from missing ones
+func writeJobNotFound(w http.ResponseWriter, jobID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": fmt.Sprintf("Could not find fine tune: %s", jobID),
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "fine_tune_not_found",
+		},
+	})
+}
+
+// sendFineTuningRequest sends a request to the fine-tuning or files API of the provider
+func sendFineTuningRequest(ctx context.Context, config lib.Configuration, method, path string, body []byte) (*http.Response, error) {
+	httpClient, err := lib.ProviderHTTPClient(config.Providers.OpenAI)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, providerURL(config, "", path), reader)
+	if err != nil {
+		return nil, err
+	}
+	setProviderAuth(config, req.Header)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	lib.GetOpenAIAccount(ctx).SetHeaders(req.Header)
+	return providerClient(config, httpClient).Do(req)
+}
@@ -1,29 +1,56 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
 	"github.com/openshieldai/openshield/rules"
 	"github.com/sashabaranov/go-openai"
 )
 
-var client *openai.Client
-
 const OSCacheStatusHeader = "OS-Cache-Status"
 
+// OSDowngradedFromHeader is the requested model of requests sent to a cheaper model for the spending cap of the provider
+const OSDowngradedFromHeader = "OS-Downgraded-From"
+
+// newClient returns an OpenAI client using the timeouts configured for model.
+// Its requests carry the OpenAI account of the API key.
+func newClient(config lib.Configuration, model string) (*openai.Client, error) {
+	httpClient, err := lib.ModelHTTPClient(config.Providers.OpenAI, model)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := openai.DefaultConfig(config.Secrets.OpenAIApiKey)
+	if azure := lib.GetAzureOpenAI(config); azure != nil {
+		clientConfig = azure.ClientConfig(config.Secrets.OpenAIApiKey)
+	} else if config.Providers.OpenAI != nil && config.Providers.OpenAI.BaseURL != "" {
+		clientConfig.BaseURL = config.Providers.OpenAI.BaseURL
+	}
+	clientConfig.HTTPClient = lib.OpenAIAccountClient(providerClient(config, httpClient))
+	return openai.NewClientWithConfig(clientConfig), nil
+}
+
 func ListModelsHandler(w http.ResponseWriter, r *http.Request) {
-	config := lib.GetConfig()
-	openAIAPIKey := config.Secrets.OpenAIApiKey
-	client = openai.NewClient(openAIAPIKey)
+	config := lib.GetRequestConfig(r)
+	client, err := newClient(config, "")
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	getCache, cacheStatus, err := lib.GetCache(r.URL.Path)
+	getCache, cacheStatus, err := lib.GetCache(r.Context(), modelsCacheScope, modelsCacheKey(r))
 	if err != nil {
 		log.Printf("Error getting cache: %v", err)
 	}
@@ -35,15 +62,30 @@ func ListModelsHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Cache miss for %v", cacheStatus)
 	res, err := client.ListModels(r.Context())
-	handleModelResponse(w, r, res, err)
+	approvedModels(r, &res)
+	newMetadataScrubber(config).models(&res)
+	handleModelResponse(w, r, modelsCacheKey(r), enrichModels(r, res), err)
 }
 
 func GetModelHandler(w http.ResponseWriter, r *http.Request) {
-	config := lib.GetConfig()
-	openAIAPIKey := config.Secrets.OpenAIApiKey
-	client = openai.NewClient(openAIAPIKey)
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, chi.URLParam(r, "model")); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+
+	if !lib.ModelAllowed(r, chi.URLParam(r, "model")) {
+		handleError(w, lib.ErrModelNotApproved, http.StatusNotFound)
+		return
+	}
+
+	config := lib.GetRequestConfig(r)
+	client, err := newClient(config, chi.URLParam(r, "model"))
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	getCache, cacheStatus, err := lib.GetCache(r.URL.Path)
+	getCache, cacheStatus, err := lib.GetCache(r.Context(), modelsCacheScope, r.URL.Path)
 	if err != nil {
 		log.Printf("Error getting cache: %v", err)
 	}
@@ -56,69 +98,296 @@ func GetModelHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Cache miss for %v", cacheStatus)
 	modelName := chi.URLParam(r, "model")
 	res, err := client.GetModel(r.Context(), modelName)
-	handleModelResponse(w, r, res, err)
+	newMetadataScrubber(config).model(&res)
+	handleModelResponse(w, r, r.URL.Path, enrichModel(r, res), err)
 }
 
 func ChatCompletionHandler(w http.ResponseWriter, r *http.Request) {
-	config := lib.GetConfig()
-	openAIAPIKey := config.Secrets.OpenAIApiKey
+	config := lib.GetRequestConfig(r)
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
+	body, err := lib.ReadChatCompletionBody(w, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		handleError(w, fmt.Errorf("request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	var req openai.ChatCompletionRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	req, partial, err := decodeChatCompletion(body)
+	if err != nil {
 		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
 		return
 	}
+	r = lib.StartRequestDiff(r, body)
+
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+
+	processChatCompletion(w, r, body, req, partial, config)
+}
+
+// processChatCompletion applies the request checks and input rules to a chat
+// completion and sends it to the provider. Requests the gateway doesn't change
+// are sent as the body they came with, without encoding them again. A partial
+// request, from the streaming scanner, is decoded in full once it passed the
+// rules and only if it has to be.
+func processChatCompletion(w http.ResponseWriter, r *http.Request, body []byte, req openai.ChatCompletionRequest, partial bool, config lib.Configuration) {
+	r, ok := applyRequestPolicies(w, r, req.Model)
+	if !ok {
+		return
+	}
+	modified := false
+	if fallback, ok := lib.SpendingDowngrade(r.Context(), string(models.OpenAI), req.Model); ok {
+		w.Header().Set(OSDowngradedFromHeader, req.Model)
+		lib.RecordTransformation(r, "model_downgrade", fmt.Sprintf("%s to %s for the spending cap", req.Model, fallback))
+		req.Model = fallback
+		modified = true
+	}
+	config = lib.GetRequestConfig(r)
+
+	if err := checkStrictContent(r, body, req); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := checkMessageURLs(req); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := checkToolStreaming(req); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Documents retrieved by the gateway itself are already in the context
+	if len(lib.RAGDocuments(r)) == 0 {
+		if documents := lib.ExtractRAGDocuments(body); len(documents) > 0 {
+			performProvenanceLogging(r, documents)
+			r = lib.WithRAGDocuments(r, documents)
+		}
+	}
+
+	if dryRun(w, r, req) {
+		return
+	}
 
+	// Intercepted requests are only logged under the restricted category, so the
+	// input is logged once the rules ran
+	contents := messageContents(req)
+	if filtered, errorMessage, err := rules.Input(r, req); filtered {
+		var interception *rules.Interception
+		if errors.As(err, &interception) {
+			performRestrictedAuditLogging(r, body, interception)
+			writeInterception(w, req, interception)
+			return
+		}
+		performAuditLogging(r, body)
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleBlocked(w, r, errorMessage, err)
+		return
+	}
 	performAuditLogging(r, body)
+	// Messages anonymized by the rules are sent as they left them
+	changed := changedMessages(req, contents)
+	if len(changed) > 0 {
+		modified = true
+	}
 
-	if filtered, errorMessage, _ := rules.Input(r, req); filtered {
-		handleError(w, fmt.Errorf(errorMessage), http.StatusBadRequest)
+	if partial && needsFullRequest(r, req, modified) {
+		var full openai.ChatCompletionRequest
+		if err := json.Unmarshal(body, &full); err != nil {
+			handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		// The model may have been downgraded
+		full.Model = req.Model
+		for _, i := range changed {
+			if i < len(full.Messages) {
+				full.Messages[i].Content = req.Messages[i].Content
+			}
+		}
+		req = full
+	}
+
+	fitted, err := fitContextWindow(w, r, config, &req)
+	if err != nil {
+		var lengthErr *lib.ContextLengthError
+		if errors.As(err, &lengthErr) {
+			writeContextLengthError(w, r, lengthErr)
+			return
+		}
+		handleProviderError(w, r, err, http.StatusBadGateway)
+		return
+	}
+	if err := lib.ReserveCapacity(r, lib.CountPromptTokens(req)+req.MaxTokens); err != nil {
+		handleError(w, err, http.StatusTooManyRequests)
 		return
 	}
 
 	if req.Stream {
-		handleStreamingRequest(w, r, req, openAIAPIKey)
+		handleStreamingRequest(w, r, req, config)
 	} else {
-		handleNonStreamingRequest(w, r, body, req, config, openAIAPIKey)
+		handleNonStreamingRequest(w, r, body, req, config, !modified && !fitted)
+	}
+}
+
+// messageContents returns the text of every message of a request
+func messageContents(req openai.ChatCompletionRequest) []string {
+	contents := make([]string, len(req.Messages))
+	for i, message := range req.Messages {
+		contents[i] = message.Content
+	}
+	return contents
+}
+
+// changedMessages returns the indexes of the messages whose text differs from before
+func changedMessages(req openai.ChatCompletionRequest, before []string) []int {
+	var changed []int
+	for i, message := range req.Messages {
+		if i < len(before) && message.Content != before[i] {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+// applyRequestPolicies applies the request policies of the API key to a request
+// for model. It reports false once it answered the request.
+func applyRequestPolicies(w http.ResponseWriter, r *http.Request, model string) (*http.Request, bool) {
+	r, status, err := lib.ApplyRequestPolicies(w, r, model)
+	if err != nil {
+		handleError(w, err, status)
+		return r, false
 	}
+	return r, true
+}
+
+func checkMessageURLs(req openai.ChatCompletionRequest) error {
+	for _, message := range req.Messages {
+		for _, part := range message.MultiContent {
+			if part.ImageURL == nil {
+				continue
+			}
+			if err := lib.CheckURL(part.ImageURL.URL); err != nil {
+				return fmt.Errorf("image_url rejected: %v", err)
+			}
+		}
+	}
+	return nil
 }
 
 func performAuditLogging(r *http.Request, body []byte) {
+	// The body is only copied into a message when it is logged
+	if !lib.AuditLoggingActive(r) {
+		return
+	}
 	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
 	lib.AuditLogs(string(body), "openai_chat_completion", apiKeyId, "input", r)
 }
 
-func handleNonStreamingRequest(w http.ResponseWriter, r *http.Request, body []byte, req openai.ChatCompletionRequest, config lib.Configuration, openAIAPIKey string) {
-	getCache, cacheStatus, err := lib.GetCache(string(body))
-	if err != nil {
-		log.Printf("Error getting cache: %v", err)
+func handleNonStreamingRequest(w http.ResponseWriter, r *http.Request, body []byte, req openai.ChatCompletionRequest, config lib.Configuration, unchanged bool) {
+	// Cached completions may come from products without the strict output rules
+	var getCache []byte
+	var cacheStatus bool
+	cacheTTL, cached := lib.CacheTTL(r)
+	cached = cached && deterministicRequest(body) && !lib.StrictContentActive(r)
+	var cacheKey string
+	if cached {
+		cacheKey = completionCacheKey(r, body)
+		var err error
+		getCache, cacheStatus, err = lib.GetCache(r.Context(), req.Model, cacheKey)
+		if err != nil {
+			log.Printf("Error getting cache: %v", err)
+		}
 	}
 	if cacheStatus {
+		if !checkCachedToolCalls(w, r, req, getCache) {
+			return
+		}
 		w.Header().Set(OSCacheStatusHeader, "HIT")
-		w.Write(getCache)
+		writeCachedCompletion(w, r, getCache)
+		return
+	}
+	// Hits of the semantic cache are answered without usage, nothing was sent to the model
+	semanticCached, semanticHit, promptEmbedding := semanticCacheLookup(r, config, req)
+	if semanticHit {
+		if !checkCachedToolCalls(w, r, req, semanticCached) {
+			return
+		}
+		w.Header().Set(lib.SemanticCacheHeader, "hit")
+		writeCachedCompletion(w, r, semanticCached)
+		return
+	}
+
+	client, err := newClient(config, req.Model)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// Output rules that consume logprobs get them even if the client didn't ask
+	stripLogProbs := !req.LogProbs && rules.OutputNeedsLogProbs(r)
+	if stripLogProbs {
+		req.LogProbs = true
+		unchanged = false
+		lib.RecordTransformation(r, "logprobs", "requested for the output rules, removed from the response")
+	}
+	var raw []byte
+	if unchanged {
+		raw = body
+		lib.SaveRequestDiff(r, raw)
+	} else if payload, err := json.Marshal(req); err == nil {
+		lib.SaveRequestDiff(r, payload)
+	}
 
-	client := openai.NewClient(openAIAPIKey)
-	resp, err := client.CreateChatCompletion(r.Context(), req)
+	scrubber := newMetadataScrubber(config)
+	start := time.Now()
+	ctx, hashes := lib.WithContentHashes(r.Context())
+	resp, raced, err := createChatCompletion(w, r.WithContext(ctx), config, client, req, raw)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to create chat completion: %v", err), http.StatusInternalServerError)
+		handleProviderError(w, r, fmt.Errorf("failed to create chat completion: %w", scrubber.error(err)), http.StatusInternalServerError)
+		return
+	}
+	scrubber.completion(&resp)
+	lib.RecordLatency(req.Model, 0, time.Since(start))
+
+	if err := limitResponse(&resp); err != nil {
+		handleProviderError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	if blocked, errorMessage, _ := rules.Output(r, &resp); blocked {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleBlocked(w, r, errorMessage, nil)
+		return
+	}
+	if err := checkToolCalls(r, req, resp); err != nil {
+		handleToolCallError(w, r, err)
 		return
 	}
+	if stripLogProbs {
+		for i := range resp.Choices {
+			resp.Choices[i].LogProbs = nil
+		}
+	}
 
-	if config.Settings.Cache.Enabled {
+	// Tool calls are checked and approved for each request, they aren't cached
+	toolCalls := hasToolCalls(resp)
+	if cached && toolCalls {
+		w.Header().Set(OSCacheStatusHeader, "BYPASS")
+	} else if cached {
 		w.Header().Set(OSCacheStatusHeader, "MISS")
 		resJson, err := json.Marshal(resp)
 		if err != nil {
 			log.Printf("Error marshalling response to JSON: %v", err)
 		} else {
-			err = lib.SetCache(string(body), resJson)
+			err = lib.SetCache(r.Context(), req.Model, cacheKey, resJson, cacheTTL)
 			if err != nil {
 				log.Printf("Error setting cache: %v", err)
 			}
@@ -126,16 +395,36 @@ func handleNonStreamingRequest(w http.ResponseWriter, r *http.Request, body []by
 	} else {
 		w.Header().Set(OSCacheStatusHeader, "BYPASS")
 	}
+	if promptEmbedding != nil && !toolCalls {
+		w.Header().Set(lib.SemanticCacheHeader, "miss")
+		if resJson, err := json.Marshal(resp); err == nil {
+			setSemanticCache(r, req, promptEmbedding, resJson)
+		}
+	}
 
-	performResponseAuditLogging(r, resp)
-	json.NewEncoder(w).Encode(resp)
+	performResponseAuditLogging(r, resp, raced, hashes)
+	writeCompletion(w, r, resp)
 }
 
-func handleStreamingRequest(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest, openAIAPIKey string) {
-	client := openai.NewClient(openAIAPIKey)
-	stream, err := client.CreateChatCompletionStream(r.Context(), req)
+func handleStreamingRequest(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest, config lib.Configuration) {
+	client, err := newClient(config, req.Model)
 	if err != nil {
-		handleError(w, fmt.Errorf("failed to create chat completion stream: %v", err), http.StatusInternalServerError)
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if payload, err := json.Marshal(req); err == nil {
+		lib.SaveRequestDiff(r, payload)
+	}
+
+	ctx, hashes := lib.WithContentHashes(r.Context())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scrubber := newMetadataScrubber(config)
+	start := time.Now()
+	stream, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create chat completion stream: %w", scrubber.error(err)), http.StatusInternalServerError)
 		return
 	}
 	defer stream.Close()
@@ -150,20 +439,88 @@ func handleStreamingRequest(w http.ResponseWriter, r *http.Request, req openai.C
 		return
 	}
 
+	// A stalled stream is cancelled once no chunk arrived within the idle timeout
+	var idle atomic.Bool
+	idleTimeout := time.Duration(config.Providers.OpenAI.TimeoutsFor(req.Model).StreamIdle) * time.Second
+	var idleTimer *time.Timer
+	if idleTimeout > 0 {
+		idleTimer = time.AfterFunc(idleTimeout, func() {
+			idle.Store(true)
+			cancel()
+		})
+		defer idleTimer.Stop()
+	}
+
+	usage := newStreamUsage(r.Context(), req)
+	usage.hashes = hashes
+	limiter := newStreamLimiter(req)
+	inspector := newStreamInspector(r)
+	var firstToken time.Duration
+	defer func() {
+		lib.RecordLatency(req.Model, firstToken, time.Since(start))
+	}()
+	// Content already sent stays with the client, a blocked stream ends with an error event
+	blockStream := func(message string) {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		writeStreamError(w, r, http.StatusBadRequest, lib.EnvelopeContentBlocked, message)
+		flusher.Flush()
+		usage.record()
+	}
 	for {
 		response, err := stream.Recv()
+		if idleTimer != nil {
+			idleTimer.Reset(idleTimeout)
+		}
 		if err == io.EOF {
+			last, message, blocked := inspector.flush()
+			if blocked {
+				blockStream(message)
+				return
+			}
+			if last != nil {
+				if data, err := json.Marshal(last); err == nil {
+					fmt.Fprintf(w, "data: %s\n\n", string(data))
+				}
+			}
 			fmt.Fprintf(w, "data: [DONE]\n\n")
 			flusher.Flush()
+			usage.record()
 			return
 		}
 		if err != nil {
+			// The provider stream is cancelled with the request of a client that went away
+			if errors.Is(r.Context().Err(), context.Canceled) {
+				usage.record()
+				return
+			}
+			if idle.Load() {
+				err = fmt.Errorf("no data received from provider for %v", idleTimeout)
+			}
+			err = scrubber.error(err)
 			log.Printf("Error receiving stream: %v", err)
-			fmt.Fprintf(w, "data: {\"error\": \"%v\"}\n\n", err)
+			writeStreamError(w, r, http.StatusBadGateway, "provider_error", err.Error())
+			flusher.Flush()
+			usage.record()
+			return
+		}
+		usage.add(response)
+		scrubber.chunk(&response)
+		limitReached, err := limiter.apply(&response)
+		if err != nil {
+			writeStreamError(w, r, http.StatusBadGateway, "response_too_large", err.Error())
 			flusher.Flush()
+			usage.record()
+			return
+		}
+		if message, blocked := inspector.apply(&response); blocked {
+			blockStream(message)
 			return
 		}
 		if len(response.Choices) > 0 && response.Choices[0].Delta.Content != "" {
+			if firstToken == 0 {
+				firstToken = time.Since(start)
+			}
 			data, err := json.Marshal(response)
 			if err != nil {
 				log.Printf("Error marshaling response: %v", err)
@@ -172,14 +529,44 @@ func handleStreamingRequest(w http.ResponseWriter, r *http.Request, req openai.C
 			fmt.Fprintf(w, "data: %s\n\n", string(data))
 			flusher.Flush()
 		}
+		if limitReached {
+			fmt.Fprintf(w, "data: [DONE]\n\n")
+			flusher.Flush()
+			usage.record()
+			return
+		}
 	}
 }
 
-func performResponseAuditLogging(r *http.Request, resp openai.ChatCompletionResponse) {
+// writeStreamError writes the error event ending a stream, as an error envelope
+// if the client asked for one
+func writeStreamError(w io.Writer, r *http.Request, status int, code string, message string) {
+	if lib.WantsEnvelope(r) {
+		lib.WriteEnvelopeStreamError(w, providerName, status, code, message)
+		return
+	}
+	data, _ := json.Marshal(map[string]string{"error": message})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// performProvenanceLogging logs the retrieved documents of a request separately from its input
+func performProvenanceLogging(r *http.Request, documents []lib.RAGDocument) {
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	provenance, _ := json.Marshal(documents)
+	lib.AuditLogs(string(provenance), "rag_context", apiKeyId, "provenance", r)
+}
+
+func performResponseAuditLogging(r *http.Request, resp openai.ChatCompletionResponse, raced bool, hashes *lib.ContentHashes) {
 	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
-	responseJSON, _ := json.Marshal(resp)
-	lib.AuditLogs(string(responseJSON), "openai_chat_completion", apiKeyId, "output", r)
-	lib.Usage(resp.Model, 0, resp.Usage.TotalTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens, string(resp.Choices[0].FinishReason), "chat_completion")
+	if lib.AuditLoggingActive(r) {
+		responseJSON, _ := json.Marshal(resp)
+		lib.AuditLogs(string(responseJSON), "openai_chat_completion", apiKeyId, "output", r)
+	}
+	if raced {
+		lib.RacedUsage(r.Context(), resp.Model, 0, resp.Usage.TotalTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens, string(resp.Choices[0].FinishReason), "chat_completion")
+		return
+	}
+	lib.HashedUsage(r.Context(), hashes, resp.Model, 0, resp.Usage.TotalTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens, string(resp.Choices[0].FinishReason), "chat_completion")
 }
 
 func handleError(w http.ResponseWriter, err error, statusCode int) {
@@ -187,14 +574,38 @@ func handleError(w http.ResponseWriter, err error, statusCode int) {
 	http.Error(w, err.Error(), statusCode)
 }
 
-func handleModelResponse(w http.ResponseWriter, r *http.Request, res interface{}, err error) {
+// handleBlocked answers a request a rule blocked, with the 403 error response
+// of a rule violation or the 400 of other blocks, as an error envelope if the
+// client asked for one
+func handleBlocked(w http.ResponseWriter, r *http.Request, errorMessage string, err error) {
+	var violation *rules.Violation
+	isViolation := errors.As(err, &violation)
+	if lib.WantsEnvelope(r) {
+		log.Printf("Error: %s", errorMessage)
+		if isViolation {
+			lib.WriteEnvelopeError(w, providerName, http.StatusForbidden, lib.EnvelopeRuleViolation, violation.Message)
+			return
+		}
+		lib.WriteEnvelopeError(w, providerName, http.StatusBadRequest, lib.EnvelopeContentBlocked, errorMessage)
+		return
+	}
+	if !isViolation {
+		handleError(w, fmt.Errorf(errorMessage), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Rule violation: %s", violation.Rule)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(violation.Response())
+}
+
+func handleModelResponse(w http.ResponseWriter, r *http.Request, cacheKey string, res interface{}, err error) {
 	if err != nil {
 		lib.ErrorResponse(w, err)
 		return
 	}
 
-	config := lib.GetConfig()
-	if config.Settings.Cache.Enabled {
+	if ttl, ok := lib.CacheTTL(r); ok {
 		w.Header().Set(OSCacheStatusHeader, "MISS")
 		resJson, err := json.Marshal(res)
 		if err != nil {
@@ -203,7 +614,7 @@ func handleModelResponse(w http.ResponseWriter, r *http.Request, res interface{}
 			return
 		}
 
-		err = lib.SetCache(r.URL.Path, resJson)
+		err = lib.SetCache(r.Context(), modelsCacheScope, cacheKey, resJson, ttl)
 		if err != nil {
 			log.Printf("Error setting cache: %v", err)
 		}
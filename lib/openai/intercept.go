@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSInterceptedHeader names the rule that answered the request instead of the provider
+const OSInterceptedHeader = "OS-Intercepted"
+
+// writeInterception answers the request with the content of the intercepting rule,
+// shaped like a completion of the requested model
+func writeInterception(w http.ResponseWriter, req openai.ChatCompletionRequest, interception *rules.Interception) {
+	w.Header().Set(OSInterceptedHeader, interception.Rule)
+	id := "chatcmpl-os-" + uuid.NewString()
+	created := time.Now().Unix()
+
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		chunk, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{
+				Delta:        openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: interception.Content},
+				FinishReason: openai.FinishReasonContentFilter,
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+		return
+	}
+
+	json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: interception.Content},
+			FinishReason: openai.FinishReasonContentFilter,
+		}},
+	})
+}
+
+// performRestrictedAuditLogging logs an intercepted request under the restricted
+// category, whose messages are left out of evidence exports
+func performRestrictedAuditLogging(r *http.Request, body []byte, interception *rules.Interception) {
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	lib.AuditLogs(string(body), interception.Rule, apiKeyId, lib.RestrictedMessageType, r)
+}
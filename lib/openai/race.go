@@ -0,0 +1,101 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSRaceWinnerHeader tells which provider answered a raced request, "primary" or "alternate"
+const OSRaceWinnerHeader = "OS-Race-Winner"
+
+type raceAttempt struct {
+	provider string
+	model    string
+	resp     openai.ChatCompletionResponse
+	err      error
+}
+
+// newAlternateClient returns a client for the OpenAI compatible alternate of a race route
+func newAlternateClient(config lib.Configuration, route lib.RaceRoute) (*openai.Client, error) {
+	httpClient, err := lib.ModelHTTPClient(config.Providers.OpenAI, route.AlternateModel)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := config.Secrets.AlternateAPIKey
+	if apiKey == "" {
+		apiKey = config.Secrets.OpenAIApiKey
+	}
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = route.BaseURL
+	clientConfig.HTTPClient = httpClient
+	return openai.NewClientWithConfig(clientConfig), nil
+}
+
+// raceChatCompletion sends the request to the primary client and the alternate
+// of the route at once and returns the first successful response with the
+// provider that won, canceling the other attempt. The losing attempt is
+// recorded in usage as raced, the winner is recorded with the response.
+func raceChatCompletion(ctx context.Context, config lib.Configuration, route lib.RaceRoute, primary *openai.Client, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, string, error) {
+	alternate, err := newAlternateClient(config, route)
+	if err != nil {
+		log.Printf("Error creating alternate client for %s, not racing: %v", route.Model, err)
+		resp, err := primary.CreateChatCompletion(ctx, req)
+		return resp, "", err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	alternateReq := req
+	alternateReq.Model = route.AlternateModel
+	attempts := make(chan raceAttempt, 2)
+	send := func(provider string, client *openai.Client, req openai.ChatCompletionRequest) {
+		resp, err := client.CreateChatCompletion(ctx, req)
+		attempts <- raceAttempt{provider: provider, model: req.Model, resp: resp, err: err}
+	}
+	go send("primary", primary, req)
+	go send("alternate", alternate, alternateReq)
+
+	promptTokens := lib.CountPromptTokens(req)
+	var failed []raceAttempt
+	for len(failed) < 2 {
+		attempt := <-attempts
+		if attempt.err != nil {
+			failed = append(failed, attempt)
+			continue
+		}
+		if len(failed) == 1 {
+			recordLosingAttempt(ctx, failed[0], promptTokens)
+		} else {
+			// The other attempt finishes once it sees the cancellation
+			go func() { recordLosingAttempt(ctx, <-attempts, promptTokens) }()
+		}
+		return attempt.resp, attempt.provider, nil
+	}
+
+	for _, attempt := range failed {
+		if attempt.provider == "primary" {
+			return openai.ChatCompletionResponse{}, "", attempt.err
+		}
+	}
+	return openai.ChatCompletionResponse{}, "", fmt.Errorf("both raced providers failed")
+}
+
+// recordLosingAttempt records the usage of the attempt that lost the race. A
+// canceled attempt has no usage of its own and is recorded with its estimated
+// prompt tokens.
+func recordLosingAttempt(ctx context.Context, attempt raceAttempt, promptTokens int) {
+	if attempt.err == nil && len(attempt.resp.Choices) > 0 {
+		usage := attempt.resp.Usage
+		lib.RacedUsage(ctx, attempt.resp.Model, 0, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, string(attempt.resp.Choices[0].FinishReason), "chat_completion")
+		return
+	}
+	log.Printf("Raced attempt at the %s provider lost: %v", attempt.provider, attempt.err)
+	lib.RacedUsage(ctx, attempt.model, promptTokens, promptTokens, 0, promptTokens, string(models.Null), "chat_completion")
+}
@@ -0,0 +1,156 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/lib/vectordb"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSRAGDocumentsHeader lists the IDs of the documents a RAG completion was grounded on
+const OSRAGDocumentsHeader = "OS-RAG-Documents"
+
+// defaultRAGTemplate is the system prompt of pipelines without a template. The
+// documents are tagged so the RAG context rules can find them.
+const defaultRAGTemplate = `Answer the question using only the documents below. If they don't contain the answer, say that you don't know.
+{{range .Documents}}
+<document id="{{.ID}}" collection="{{.Collection}}">{{.Content}}</document>{{end}}`
+
+type RAGCompletionRequest struct {
+	Pipeline string `json:"pipeline"`
+	Query    string `json:"query"`
+}
+
+type ragDocument struct {
+	ID         string
+	Collection string
+	Content    string
+}
+
+// RAGCompletionHandler retrieves the documents nearest to the query from the
+// vector store of the pipeline, assembles the prompt from the pipeline template
+// and answers it like a chat completion, with the same rules and logging
+func RAGCompletionHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+
+	var ragReq RAGCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&ragReq); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if ragReq.Query == "" {
+		handleError(w, fmt.Errorf("query is required"), http.StatusBadRequest)
+		return
+	}
+	original, _ := json.Marshal(ragReq)
+	r = lib.StartRequestDiff(r, original)
+
+	pipeline, ok := config.Settings.RAGPipelines[ragReq.Pipeline]
+	if !ok {
+		handleError(w, fmt.Errorf("rag pipeline %s is not configured", ragReq.Pipeline), http.StatusNotFound)
+		return
+	}
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	if !lib.ProductListed(pipeline.Products, productID) {
+		handleError(w, fmt.Errorf("rag pipeline %s is not available for this product", ragReq.Pipeline), http.StatusForbidden)
+		return
+	}
+	store, ok := config.Settings.VectorStores[pipeline.VectorStore]
+	if !ok {
+		handleError(w, fmt.Errorf("vector store %s of rag pipeline %s is not configured", pipeline.VectorStore, ragReq.Pipeline), http.StatusInternalServerError)
+		return
+	}
+
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, pipeline.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+
+	client, err := newClient(config, pipeline.EmbeddingModel)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	embeddings, err := client.CreateEmbeddings(r.Context(), openai.EmbeddingRequestStrings{
+		Input: []string{ragReq.Query},
+		Model: openai.EmbeddingModel(pipeline.EmbeddingModel),
+	})
+	if err != nil || len(embeddings.Data) == 0 {
+		handleProviderError(w, r, fmt.Errorf("failed to create query embedding: %w", newMetadataScrubber(config).error(err)), http.StatusBadGateway)
+		return
+	}
+	recordEmbeddingUsage(r.Context(), pipeline.EmbeddingModel, embeddings, "rag_embedding")
+
+	topK := pipeline.TopK
+	if topK <= 0 {
+		topK = 4
+	}
+	contentField := pipeline.ContentField
+	if contentField == "" {
+		contentField = "text"
+	}
+	retrieved, err := vectordb.Search(r.Context(), store, pipeline.Collection, contentField, embeddings.Data[0].Embedding, topK, productID.String())
+	if err != nil {
+		handleError(w, err, http.StatusBadGateway)
+		return
+	}
+
+	documents := make([]ragDocument, 0, len(retrieved))
+	provenance := make([]lib.RAGDocument, 0, len(retrieved))
+	ids := make([]string, 0, len(retrieved))
+	for _, document := range retrieved {
+		documents = append(documents, ragDocument{ID: document.ID, Collection: pipeline.Collection, Content: document.Content})
+		provenance = append(provenance, lib.NewRAGDocument(document.ID, pipeline.Collection, document.Content, 0))
+		ids = append(ids, document.ID)
+	}
+
+	prompt, err := renderRAGPrompt(pipeline.Template, ragReq.Query, documents)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to render rag template: %v", err), http.StatusInternalServerError)
+		return
+	}
+	req := openai.ChatCompletionRequest{
+		Model: pipeline.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: prompt},
+			{Role: openai.ChatMessageRoleUser, Content: ragReq.Query},
+		},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to assemble request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	lib.RecordTransformation(r, "system_prompt", fmt.Sprintf("rendered by rag pipeline %s with %d documents", ragReq.Pipeline, len(documents)))
+
+	if len(provenance) > 0 {
+		performProvenanceLogging(r, provenance)
+		r = lib.WithRAGDocuments(r, provenance)
+	}
+	w.Header().Set(OSRAGDocumentsHeader, strings.Join(ids, ","))
+	processChatCompletion(w, r, body, req, false, config)
+}
+
+func renderRAGPrompt(text string, question string, documents []ragDocument) (string, error) {
+	if text == "" {
+		text = defaultRAGTemplate
+	}
+	tmpl, err := template.New("rag").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var prompt bytes.Buffer
+	err = tmpl.Execute(&prompt, struct {
+		Question  string
+		Documents []ragDocument
+	}{question, documents})
+	return prompt.String(), err
+}
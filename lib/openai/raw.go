@@ -0,0 +1,95 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+const defaultBaseURL = "https://api.openai.com/v1"
+
+// providerURL returns the URL of an OpenAI API path at the provider, the Azure
+// OpenAI resource when one is configured
+func providerURL(config lib.Configuration, model string, path string) string {
+	if azure := lib.GetAzureOpenAI(config); azure != nil {
+		return azure.URL(model, path)
+	}
+	baseURL := defaultBaseURL
+	if config.Providers.OpenAI != nil && config.Providers.OpenAI.BaseURL != "" {
+		baseURL = config.Providers.OpenAI.BaseURL
+	}
+	return strings.TrimSuffix(baseURL, "/") + path
+}
+
+// setProviderAuth sets the provider credential of an upstream request
+func setProviderAuth(config lib.Configuration, header http.Header) {
+	if azure := lib.GetAzureOpenAI(config); azure != nil {
+		azure.SetAuth(header, config.Secrets.OpenAIApiKey)
+		return
+	}
+	header.Set("Authorization", "Bearer "+config.Secrets.OpenAIApiKey)
+}
+
+// providerClient returns httpClient authenticating with the Entra ID token of
+// the Azure OpenAI resource when it needs one
+func providerClient(config lib.Configuration, httpClient *http.Client) *http.Client {
+	if azure := lib.GetAzureOpenAI(config); azure != nil {
+		return azure.AuthClient(httpClient, config.Secrets.OpenAIApiKey)
+	}
+	return httpClient
+}
+
+// createRawChatCompletion sends a request body to the chat completions endpoint
+// as it is and decodes the response straight from the connection. Errors are
+// returned as the client library returns them, so they are handled the same.
+func createRawChatCompletion(ctx context.Context, config lib.Configuration, model string, body []byte) (openai.ChatCompletionResponse, error) {
+	httpClient, err := lib.ModelHTTPClient(config.Providers.OpenAI, model)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, providerURL(config, model, "/chat/completions"), bytes.NewReader(body))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	setProviderAuth(config, req.Header)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	lib.GetOpenAIAccount(ctx).SetHeaders(req.Header)
+
+	resp, err := providerClient(config, httpClient).Do(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return openai.ChatCompletionResponse{}, rawResponseError(resp)
+	}
+	var completion openai.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("error decoding response: %v", err)
+	}
+	return completion, nil
+}
+
+// rawResponseError turns an error response into an APIError, or a RequestError
+// when the body isn't an OpenAI error
+func rawResponseError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: err}
+	}
+	var errResp openai.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+		return &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: fmt.Errorf("%s", body)}
+	}
+	errResp.Error.HTTPStatusCode = resp.StatusCode
+	return errResp.Error
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// rerankRequest holds the fields of a Cohere or Voyage rerank request the gateway checks
+type rerankRequest struct {
+	Model     string            `json:"model"`
+	Query     string            `json:"query"`
+	Documents []json.RawMessage `json:"documents"`
+}
+
+// rerankResponse holds the usage of a rerank response. Voyage reports tokens,
+// Cohere bills by search units of up to 100 documents and reports none.
+type rerankResponse struct {
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// RerankHandler forwards a rerank request to the Cohere or Voyage provider of
+// its model. The query is checked by the input rules like a user message, the
+// documents are retrieved content and aren't.
+func RerankHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req rerankRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" || len(req.Documents) == 0 {
+		handleError(w, fmt.Errorf("query and documents are required"), http.StatusBadRequest)
+		return
+	}
+	target, err := lib.RerankProvider(req.Model)
+	if err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return
+	}
+	r, ok := applyRequestPolicies(w, r, req.Model)
+	if !ok {
+		return
+	}
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	chatReq := openai.ChatCompletionRequest{Model: req.Model, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: req.Query}}}
+	if filtered, errorMessage, err := rules.Input(r, chatReq); filtered {
+		// A rerank has no text to answer with, an intercepting rule refuses it
+		var interception *rules.Interception
+		if errors.As(err, &interception) {
+			w.Header().Set(OSInterceptedHeader, interception.Rule)
+			errorMessage = interception.Content
+		}
+		lib.AuditLogs(string(body), "rerank", apiKeyId, "input", r)
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleBlocked(w, r, errorMessage, err)
+		return
+	}
+	lib.AuditLogs(string(body), "rerank", apiKeyId, "input", r)
+
+	httpClient, err := lib.ModelHTTPClient(target.Config, req.Model)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	upstream, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	upstream.Header.Set("Authorization", "Bearer "+target.APIKey)
+	upstream.Header.Set("Content-Type", "application/json")
+	upstream.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(upstream)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to rerank: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var usage rerankResponse
+	json.Unmarshal(response, &usage)
+	if lib.AuditLoggingActive(r) {
+		lib.AuditLogs(string(response), "rerank", apiKeyId, "output", r)
+	}
+	lib.RerankUsage(r.Context(), req.Model, len(req.Documents), usage.Usage.TotalTokens)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// limitResponse applies the response limits to every choice of a completion
+func limitResponse(resp *openai.ChatCompletionResponse) error {
+	for i := range resp.Choices {
+		choice := &resp.Choices[i]
+		content, reached, err := lib.NewResponseLimiter(resp.Model).Limit(choice.Message.Content)
+		if err != nil {
+			return err
+		}
+		if reached {
+			choice.Message.Content = content
+			choice.FinishReason = openai.FinishReasonLength
+		}
+	}
+	return nil
+}
+
+// streamLimiter applies the response limits to each choice of a streamed completion
+type streamLimiter struct {
+	model    string
+	choices  int
+	limiters map[int]*lib.ResponseLimiter
+	done     map[int]bool
+}
+
+func newStreamLimiter(req openai.ChatCompletionRequest) *streamLimiter {
+	choices := req.N
+	if choices < 1 {
+		choices = 1
+	}
+	return &streamLimiter{model: req.Model, choices: choices, limiters: map[int]*lib.ResponseLimiter{}, done: map[int]bool{}}
+}
+
+// apply limits the content of a chunk in place. It reports when every choice
+// reached the limit, so the stream can be ended.
+func (s *streamLimiter) apply(response *openai.ChatCompletionStreamResponse) (bool, error) {
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		if s.done[choice.Index] {
+			choice.Delta.Content = ""
+			continue
+		}
+
+		limiter, ok := s.limiters[choice.Index]
+		if !ok {
+			limiter = lib.NewResponseLimiter(s.model)
+			if limiter == nil {
+				return false, nil
+			}
+			s.limiters[choice.Index] = limiter
+		}
+
+		content, reached, err := limiter.Limit(choice.Delta.Content)
+		if err != nil {
+			return true, err
+		}
+		choice.Delta.Content = content
+		if reached {
+			choice.FinishReason = openai.FinishReasonLength
+			s.done[choice.Index] = true
+		}
+	}
+	return len(s.done) >= s.choices, nil
+}
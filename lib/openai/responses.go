@@ -0,0 +1,442 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+var responsesAPI = textAPI{
+	path:      "/responses",
+	logType:   "openai_response",
+	usageType: "response",
+	intercept: writeResponseInterception,
+	streamChunk: func(usage *streamUsage, data []byte) {
+		var event struct {
+			Type     string           `json:"type"`
+			Delta    string           `json:"delta"`
+			Response responsesSummary `json:"response"`
+		}
+		if json.Unmarshal(data, &event) != nil {
+			return
+		}
+		switch event.Type {
+		case "response.output_text.delta":
+			usage.add(openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: event.Delta}}}})
+		case "response.completed", "response.incomplete":
+			usage.add(openai.ChatCompletionStreamResponse{
+				Model:   event.Response.Model,
+				Usage:   event.Response.Usage.chat(),
+				Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReason(event.Response.finishReason())}},
+			})
+		}
+	},
+}
+
+// responsesRequest holds the fields of a responses API request the gateway checks
+type responsesRequest struct {
+	Model           string          `json:"model"`
+	Input           json.RawMessage `json:"input"`
+	Instructions    string          `json:"instructions"`
+	MaxOutputTokens int             `json:"max_output_tokens"`
+	Temperature     float32         `json:"temperature"`
+	Stream          bool            `json:"stream"`
+}
+
+// responsesInputItem is an input message, or the output of a tool call
+type responsesInputItem struct {
+	Type    string          `json:"type"`
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Output  string          `json:"output"`
+}
+
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL string `json:"image_url"`
+}
+
+// responsesSummary holds the fields of a response the gateway records
+type responsesSummary struct {
+	Model             string `json:"model"`
+	Status            string `json:"status"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Usage responsesUsage `json:"usage"`
+}
+
+type responsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+func (u responsesUsage) chat() *openai.Usage {
+	if u.TotalTokens == 0 {
+		return nil
+	}
+	return &openai.Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+}
+
+// finishReason maps the status of a response to the finish reasons of usage
+func (s responsesSummary) finishReason() string {
+	switch {
+	case s.Status == "completed":
+		return string(models.Stop)
+	case s.IncompleteDetails != nil && s.IncompleteDetails.Reason == "max_output_tokens":
+		return string(models.Length)
+	case s.IncompleteDetails != nil && s.IncompleteDetails.Reason == "content_filter":
+		return string(models.ContentFilter)
+	}
+	return ""
+}
+
+// ResponsesHandler answers the responses API. The instructions and input are
+// checked like the messages of a chat completion and the output texts like its
+// output.
+func ResponsesHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req responsesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		handleError(w, fmt.Errorf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	messages, err := responsesMessages(req)
+	if err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	chatReq := openai.ChatCompletionRequest{Model: req.Model, MaxTokens: req.MaxOutputTokens, Temperature: req.Temperature, Stream: req.Stream, Messages: messages}
+	r, body, ok := checkTextRequest(w, r, responsesAPI, body, chatReq, func(redacted []openai.ChatCompletionMessage) ([]byte, error) {
+		return redactedResponsesBody(body, req, messages, redacted)
+	})
+	if !ok {
+		return
+	}
+
+	ctx, hashes := lib.WithContentHashes(r.Context())
+	resp, err := sendTextRequest(ctx, config, responsesAPI, req.Model, body, req.Stream)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("failed to create response: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		relayProviderError(w, resp)
+		return
+	}
+
+	if req.Stream {
+		usage := newStreamUsage(r.Context(), chatReq)
+		usage.hashes = hashes
+		usage.requestType = responsesAPI.usageType + "_stream"
+		relayTextStream(w, r, responsesAPI, resp, usage)
+		return
+	}
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error reading response: %v", err), http.StatusBadGateway)
+		return
+	}
+	var summary responsesSummary
+	if err := json.Unmarshal(response, &summary); err != nil {
+		handleProviderError(w, r, fmt.Errorf("error decoding response: %v", err), http.StatusBadGateway)
+		return
+	}
+	output, err := newResponsesOutput(response)
+	if err != nil {
+		handleProviderError(w, r, fmt.Errorf("error decoding response: %v", err), http.StatusBadGateway)
+		return
+	}
+	texts := output.texts()
+	checked, ok := checkTextOutput(w, r, summary.Model, texts)
+	if !ok {
+		return
+	}
+	// The response is only encoded again when the gateway changed a text
+	for i := range texts {
+		if checked[i] != texts[i] {
+			if response, err = output.replace(checked); err != nil {
+				handleError(w, fmt.Errorf("error encoding response: %v", err), http.StatusInternalServerError)
+				return
+			}
+			break
+		}
+	}
+
+	performTextAuditLogging(r, responsesAPI, response)
+	lib.HashedUsage(r.Context(), hashes, summary.Model, 0, summary.Usage.InputTokens, summary.Usage.OutputTokens, summary.Usage.TotalTokens, summary.finishReason(), responsesAPI.usageType)
+	w.Write(response)
+}
+
+// responsesMessages returns the instructions and input of a request as chat
+// messages. The text parts of a message are joined, as the rules check text.
+func responsesMessages(req responsesRequest) ([]openai.ChatCompletionMessage, error) {
+	var messages []openai.ChatCompletionMessage
+	if req.Instructions != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: req.Instructions})
+	}
+
+	var text string
+	if err := json.Unmarshal(req.Input, &text); err == nil {
+		return append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: text}), nil
+	}
+	var items []responsesInputItem
+	if err := json.Unmarshal(req.Input, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or a list of input items")
+	}
+	for _, item := range items {
+		switch {
+		case item.Type == "function_call_output":
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, Content: item.Output})
+		case item.Role != "":
+			content, err := responsesContent(item.Content)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, openai.ChatCompletionMessage{Role: item.Role, Content: content})
+		}
+	}
+	return messages, nil
+}
+
+// redactedResponsesBody returns body with the instructions and input items of
+// the messages the rules redacted replaced, walking the input the way
+// responsesMessages does. The text parts of a redacted message are replaced by
+// one part with its redacted text, its other parts are kept.
+func redactedResponsesBody(body []byte, req responsesRequest, messages []openai.ChatCompletionMessage, redacted []openai.ChatCompletionMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	setField := func(fields map[string]json.RawMessage, field string, value interface{}) error {
+		encoded, err := json.Marshal(value)
+		fields[field] = encoded
+		return err
+	}
+
+	i := 0
+	if req.Instructions != "" {
+		if err := setField(fields, "instructions", redacted[i].Content); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	var text string
+	if json.Unmarshal(req.Input, &text) == nil {
+		if err := setField(fields, "input", redacted[i].Content); err != nil {
+			return nil, err
+		}
+		return json.Marshal(fields)
+	}
+
+	var items []map[string]json.RawMessage
+	var typed []responsesInputItem
+	if err := json.Unmarshal(req.Input, &items); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(req.Input, &typed); err != nil {
+		return nil, err
+	}
+	for j, item := range typed {
+		if item.Type != "function_call_output" && item.Role == "" {
+			continue
+		}
+		content := redacted[i].Content
+		changed := content != messages[i].Content
+		i++
+		if !changed {
+			continue
+		}
+		if item.Type == "function_call_output" {
+			if err := setField(items[j], "output", content); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if json.Unmarshal(item.Content, &text) == nil {
+			if err := setField(items[j], "content", content); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var parts []map[string]json.RawMessage
+		if err := json.Unmarshal(item.Content, &parts); err != nil {
+			return nil, err
+		}
+		kept := make([]map[string]json.RawMessage, 0, len(parts))
+		replaced := false
+		for _, part := range parts {
+			var partText string
+			json.Unmarshal(part["text"], &partText)
+			if partText == "" {
+				kept = append(kept, part)
+				continue
+			}
+			if replaced {
+				continue
+			}
+			if err := setField(part, "text", content); err != nil {
+				return nil, err
+			}
+			kept = append(kept, part)
+			replaced = true
+		}
+		if err := setField(items[j], "content", kept); err != nil {
+			return nil, err
+		}
+	}
+	if err := setField(fields, "input", items); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// responsesContent returns the text of message content, a string or a list of
+// parts. Image URLs are checked against the URL policy.
+func responsesContent(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []responsesContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("message content must be a string or a list of content parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.ImageURL != "" {
+			if err := lib.CheckURL(part.ImageURL); err != nil {
+				return "", fmt.Errorf("image_url rejected: %v", err)
+			}
+		}
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// responsesOutput is a decoded response whose output texts can be replaced
+// while keeping every other field as the provider sent it
+type responsesOutput struct {
+	response map[string]json.RawMessage
+	items    []map[string]json.RawMessage
+	contents map[int][]map[string]json.RawMessage
+}
+
+func newResponsesOutput(body []byte) (*responsesOutput, error) {
+	output := &responsesOutput{contents: map[int][]map[string]json.RawMessage{}}
+	if err := json.Unmarshal(body, &output.response); err != nil {
+		return nil, err
+	}
+	if raw, ok := output.response["output"]; ok {
+		if err := json.Unmarshal(raw, &output.items); err != nil {
+			return nil, err
+		}
+	}
+	for i, item := range output.items {
+		var itemType string
+		json.Unmarshal(item["type"], &itemType)
+		if itemType != "message" {
+			continue
+		}
+		var content []map[string]json.RawMessage
+		if err := json.Unmarshal(item["content"], &content); err != nil {
+			return nil, err
+		}
+		output.contents[i] = content
+	}
+	return output, nil
+}
+
+// each calls fn with every output_text part, in order
+func (o *responsesOutput) each(fn func(part map[string]json.RawMessage)) {
+	for i := range o.items {
+		for _, part := range o.contents[i] {
+			var partType string
+			json.Unmarshal(part["type"], &partType)
+			if partType == "output_text" {
+				fn(part)
+			}
+		}
+	}
+}
+
+func (o *responsesOutput) texts() []string {
+	texts := []string{}
+	o.each(func(part map[string]json.RawMessage) {
+		var text string
+		json.Unmarshal(part["text"], &text)
+		texts = append(texts, text)
+	})
+	return texts
+}
+
+// replace sets the output texts and returns the encoded response
+func (o *responsesOutput) replace(texts []string) ([]byte, error) {
+	n := 0
+	o.each(func(part map[string]json.RawMessage) {
+		part["text"], _ = json.Marshal(texts[n])
+		n++
+	})
+	var err error
+	for i, content := range o.contents {
+		if o.items[i]["content"], err = json.Marshal(content); err != nil {
+			return nil, err
+		}
+	}
+	if o.response["output"], err = json.Marshal(o.items); err != nil {
+		return nil, err
+	}
+	return json.Marshal(o.response)
+}
+
+// writeResponseInterception answers a response with the content of the
+// intercepting rule
+func writeResponseInterception(w http.ResponseWriter, model string, stream bool, interception *rules.Interception) {
+	response := map[string]interface{}{
+		"id":         "resp_os_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"object":     "response",
+		"created_at": time.Now().Unix(),
+		"model":      model,
+		"status":     "completed",
+		"output": []map[string]interface{}{{
+			"type":    "message",
+			"id":      "msg_os_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+			"role":    openai.ChatMessageRoleAssistant,
+			"status":  "completed",
+			"content": []map[string]interface{}{{"type": "output_text", "text": interception.Content, "annotations": []interface{}{}}},
+		}},
+	}
+	if !stream {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	delta, _ := json.Marshal(map[string]interface{}{"type": "response.output_text.delta", "output_index": 0, "content_index": 0, "delta": interception.Content})
+	fmt.Fprintf(w, "event: response.output_text.delta\ndata: %s\n\n", delta)
+	completed, _ := json.Marshal(map[string]interface{}{"type": "response.completed", "response": response})
+	fmt.Fprintf(w, "event: response.completed\ndata: %s\n\n", completed)
+}
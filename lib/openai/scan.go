@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// decodeChatCompletion decodes a chat completion request, large ones with the
+// streaming scanner when streaming inspection is enabled. It reports whether
+// the request is only partially decoded.
+func decodeChatCompletion(body []byte) (openai.ChatCompletionRequest, bool, error) {
+	settings := lib.GetStreamingInspectionSettings()
+	if settings.Enabled && len(body) > settings.ThresholdBytes {
+		return lib.ScanChatCompletion(body)
+	}
+	var req openai.ChatCompletionRequest
+	err := json.Unmarshal(body, &req)
+	return req, false, err
+}
+
+// needsFullRequest reports whether a request is changed or encoded again before
+// it is sent, so a partially decoded one has to be decoded in full
+func needsFullRequest(r *http.Request, req openai.ChatCompletionRequest, modified bool) bool {
+	if modified || req.Stream || lib.RequestCoalescingEnabled() {
+		return true
+	}
+	if _, raced := lib.RaceRouteFor(r, req.Model); raced {
+		return true
+	}
+	compression := lib.GetPromptCompressionSettings()
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	if compression.Enabled && lib.ProductListed(compression.Products, productID) {
+		return true
+	}
+	window := lib.GetContextWindowSettings()
+	if window.Enabled && window.Policy != lib.ContextReject {
+		return true
+	}
+	return !req.LogProbs && rules.OutputNeedsLogProbs(r)
+}
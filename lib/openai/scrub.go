@@ -0,0 +1,91 @@
+package openai
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// providerIdentifiers matches organization and request IDs in provider error messages
+var providerIdentifiers = regexp.MustCompile(`\b(org-[A-Za-z0-9]{8,}|req_[A-Za-z0-9]{8,})\b`)
+
+// metadataScrubber removes the fields that identify the upstream provider from
+// responses when settings.scrub_provider_metadata is enabled. Provider response
+// headers are never passed on to clients.
+type metadataScrubber struct {
+	enabled bool
+	id      string
+}
+
+func newMetadataScrubber(config lib.Configuration) *metadataScrubber {
+	scrub := config.Settings.ScrubProviderMetadata
+	return &metadataScrubber{
+		enabled: scrub != nil && scrub.Enabled,
+		// One ID per response, so all chunks of a stream share it
+		id: "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+	}
+}
+
+func (s *metadataScrubber) completion(resp *openai.ChatCompletionResponse) {
+	if !s.enabled {
+		return
+	}
+	resp.ID = s.id
+	resp.SystemFingerprint = ""
+}
+
+func (s *metadataScrubber) chunk(resp *openai.ChatCompletionStreamResponse) {
+	if !s.enabled {
+		return
+	}
+	resp.ID = s.id
+	resp.SystemFingerprint = ""
+	resp.PromptAnnotations = nil
+	resp.PromptFilterResults = nil
+}
+
+func (s *metadataScrubber) model(model *openai.Model) {
+	if !s.enabled {
+		return
+	}
+	model.OwnedBy = "openshield"
+	model.Permission = nil
+}
+
+func (s *metadataScrubber) models(list *openai.ModelsList) {
+	for i := range list.Models {
+		s.model(&list.Models[i])
+	}
+}
+
+// error removes organization and request IDs from an upstream error. API and
+// request errors keep their type, status and code, so they are still answered
+// with the status of the provider.
+func (s *metadataScrubber) error(err error) error {
+	if !s.enabled || err == nil {
+		return err
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		scrubbed := *apiErr
+		scrubbed.Message = scrubIdentifiers(apiErr.Message)
+		return &scrubbed
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		scrubbed := *requestErr
+		if requestErr.Err != nil {
+			scrubbed.Err = errors.New(scrubIdentifiers(requestErr.Err.Error()))
+		}
+		return &scrubbed
+	}
+	return errors.New(scrubIdentifiers(err.Error()))
+}
+
+func scrubIdentifiers(message string) string {
+	return providerIdentifiers.ReplaceAllString(message, "[redacted]")
+}
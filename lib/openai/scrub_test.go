@@ -0,0 +1,34 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataScrubberError(t *testing.T) {
+	scrubber := &metadataScrubber{enabled: true}
+
+	apiErr := &openai.APIError{Code: "rate_limit_exceeded", Message: "Rate limit reached for org-abcdefgh1234 on requests", Type: "requests", HTTPStatusCode: http.StatusTooManyRequests}
+	var scrubbed *openai.APIError
+	require.ErrorAs(t, scrubber.error(fmt.Errorf("call failed: %w", apiErr)), &scrubbed)
+	assert.Equal(t, "Rate limit reached for [redacted] on requests", scrubbed.Message)
+	assert.Equal(t, http.StatusTooManyRequests, scrubbed.HTTPStatusCode)
+	assert.Equal(t, "rate_limit_exceeded", scrubbed.Code)
+	assert.Contains(t, apiErr.Message, "org-abcdefgh1234", "the upstream error is left as it was")
+
+	var requestErr *openai.RequestError
+	require.ErrorAs(t, scrubber.error(&openai.RequestError{HTTPStatusCode: http.StatusBadGateway, Err: errors.New("upstream req_12345678abcd failed")}), &requestErr)
+	assert.Equal(t, http.StatusBadGateway, requestErr.HTTPStatusCode)
+	assert.Equal(t, "upstream [redacted] failed", requestErr.Err.Error())
+
+	assert.EqualError(t, scrubber.error(errors.New("dial failed for req_12345678abcd")), "dial failed for [redacted]")
+
+	disabled := &metadataScrubber{}
+	assert.Same(t, apiErr, disabled.error(apiErr))
+}
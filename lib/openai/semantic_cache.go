@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// semanticCachePrompt returns the text of the messages of req the prompt embedding is made of
+func semanticCachePrompt(req openai.ChatCompletionRequest) string {
+	var prompt strings.Builder
+	for _, message := range req.Messages {
+		prompt.WriteString(message.Role)
+		prompt.WriteString(": ")
+		prompt.WriteString(message.Content)
+		for _, part := range message.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText {
+				prompt.WriteString(part.Text)
+			}
+		}
+		prompt.WriteString("\n")
+	}
+	return prompt.String()
+}
+
+// semanticCacheParams returns the parameters of req that change the shape of
+// its completion, so only prompts asked with the same ones share completions
+func semanticCacheParams(req openai.ChatCompletionRequest) string {
+	params, _ := json.Marshal(struct {
+		Tools          []openai.Tool                        `json:"tools,omitempty"`
+		ToolChoice     any                                  `json:"tool_choice,omitempty"`
+		Functions      []openai.FunctionDefinition          `json:"functions,omitempty"`
+		FunctionCall   any                                  `json:"function_call,omitempty"`
+		ResponseFormat *openai.ChatCompletionResponseFormat `json:"response_format,omitempty"`
+		N              int                                  `json:"n,omitempty"`
+		MaxTokens      int                                  `json:"max_tokens,omitempty"`
+		LogProbs       bool                                 `json:"logprobs,omitempty"`
+		TopLogProbs    int                                  `json:"top_logprobs,omitempty"`
+		Temperature    float32                              `json:"temperature,omitempty"`
+		TopP           float32                              `json:"top_p,omitempty"`
+		Stop           []string                             `json:"stop,omitempty"`
+	}{req.Tools, req.ToolChoice, req.Functions, req.FunctionCall, req.ResponseFormat, req.N, req.MaxTokens, req.LogProbs, req.TopLogProbs, req.Temperature, req.TopP, req.Stop})
+	return string(params)
+}
+
+// semanticCacheScope returns the scope the completion of req is cached in
+func semanticCacheScope(r *http.Request, req openai.ChatCompletionRequest) string {
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	return lib.SemanticCacheScope(productID, req.Model, semanticCacheParams(req))
+}
+
+// semanticCacheLookup returns the cached completion of a prompt similar to the
+// one of req, and the embedding of the prompt to cache the completion of a
+// miss with. The embedding is nil if the semantic cache isn't used for r.
+func semanticCacheLookup(r *http.Request, config lib.Configuration, req openai.ChatCompletionRequest) ([]byte, bool, []float32) {
+	if !lib.SemanticCacheEnabled(r) || lib.StrictContentActive(r) {
+		return nil, false, nil
+	}
+	settings := lib.GetSemanticCacheSettings()
+	client, err := newClient(config, settings.EmbeddingModel)
+	if err != nil {
+		log.Printf("Error creating semantic cache client: %v", err)
+		return nil, false, nil
+	}
+	embeddings, err := client.CreateEmbeddings(r.Context(), openai.EmbeddingRequestStrings{
+		Input: []string{semanticCachePrompt(req)},
+		Model: openai.EmbeddingModel(settings.EmbeddingModel),
+	})
+	if err != nil || len(embeddings.Data) == 0 {
+		log.Printf("Error creating semantic cache embedding: %v", err)
+		return nil, false, nil
+	}
+	recordEmbeddingUsage(r.Context(), settings.EmbeddingModel, embeddings, "semantic_cache_embedding")
+
+	vector := embeddings.Data[0].Embedding
+	cached, hit, err := lib.GetSemanticCache(r.Context(), semanticCacheScope(r, req), vector)
+	if err != nil {
+		log.Printf("Error getting semantic cache: %v", err)
+	}
+	return cached, hit, vector
+}
+
+// setSemanticCache caches the completion of the prompt of req under its embedding
+func setSemanticCache(r *http.Request, req openai.ChatCompletionRequest, vector []float32, resJson []byte) {
+	if err := lib.SetSemanticCache(r.Context(), semanticCacheScope(r, req), vector, resJson); err != nil {
+		log.Printf("Error setting semantic cache: %v", err)
+	}
+}
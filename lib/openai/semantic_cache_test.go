@@ -0,0 +1,47 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemanticCacheScope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "productId", uuid.New()))
+	base := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "What is the weather?"}}}
+	scope := semanticCacheScope(r, base)
+
+	// The prompt and the end user are matched by the embedding, not the scope
+	same := base
+	same.Messages = []openai.ChatCompletionMessage{{Role: "user", Content: "How is the weather?"}}
+	same.User = "alice"
+	assert.Equal(t, scope, semanticCacheScope(r, same))
+
+	for name, change := range map[string]func(req *openai.ChatCompletionRequest){
+		"tools": func(req *openai.ChatCompletionRequest) {
+			req.Tools = []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "get_weather"}}}
+		},
+		"tool_choice": func(req *openai.ChatCompletionRequest) { req.ToolChoice = "required" },
+		"response_format": func(req *openai.ChatCompletionRequest) {
+			req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: "json_object"}
+		},
+		"n":           func(req *openai.ChatCompletionRequest) { req.N = 2 },
+		"max_tokens":  func(req *openai.ChatCompletionRequest) { req.MaxTokens = 16 },
+		"logprobs":    func(req *openai.ChatCompletionRequest) { req.LogProbs = true },
+		"temperature": func(req *openai.ChatCompletionRequest) { req.Temperature = 0.7 },
+		"model":       func(req *openai.ChatCompletionRequest) { req.Model = "gpt-4o-mini" },
+	} {
+		changed := base
+		change(&changed)
+		assert.NotEqual(t, scope, semanticCacheScope(r, changed), name)
+	}
+
+	other := r.WithContext(context.WithValue(r.Context(), "productId", uuid.New()))
+	assert.NotEqual(t, scope, semanticCacheScope(other, base))
+}
@@ -0,0 +1,115 @@
+package openai
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamInspector applies the output rules to a streamed chat completion. The
+// content of each choice is held back until a window of window_bytes arrived,
+// then checked on its own and sent with the changes the rules made, such as
+// masked words. Windows end at whitespace so words aren't split between them.
+type streamInspector struct {
+	r       *http.Request
+	window  int
+	last    openai.ChatCompletionStreamResponse
+	pending map[int]*strings.Builder
+}
+
+// newStreamInspector returns nil when no output rule applies to the stream, so
+// its chunks are passed through as they arrive
+func newStreamInspector(r *http.Request) *streamInspector {
+	if !rules.StreamOutputActive(r) {
+		return nil
+	}
+	return &streamInspector{r: r, window: rules.StreamOutputWindow(), pending: map[int]*strings.Builder{}}
+}
+
+// apply holds back the content of a chunk and replaces it with the windows
+// that passed the rules. It returns the message of the rule that blocked one.
+func (s *streamInspector) apply(response *openai.ChatCompletionStreamResponse) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.last = *response
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		pending, ok := s.pending[choice.Index]
+		if !ok {
+			pending = &strings.Builder{}
+			s.pending[choice.Index] = pending
+		}
+		pending.WriteString(choice.Delta.Content)
+		choice.Delta.Content = ""
+
+		text := pending.String()
+		cut := len(text)
+		if choice.FinishReason == "" {
+			if len(text) < s.window {
+				continue
+			}
+			if cut = strings.LastIndexFunc(text, unicode.IsSpace); cut < 0 {
+				continue
+			}
+			cut++
+		}
+		content, blocked, message := s.check(choice.Index, text[:cut])
+		if blocked {
+			return message, true
+		}
+		pending.Reset()
+		pending.WriteString(text[cut:])
+		choice.Delta.Content = content
+	}
+	return "", false
+}
+
+// flush checks the content still held back when the stream ended without a
+// finish reason, and returns it as a last chunk
+func (s *streamInspector) flush() (*openai.ChatCompletionStreamResponse, string, bool) {
+	if s == nil {
+		return nil, "", false
+	}
+	response := s.last
+	response.Choices = nil
+	for index, pending := range s.pending {
+		if pending.Len() == 0 {
+			continue
+		}
+		content, blocked, message := s.check(index, pending.String())
+		if blocked {
+			return nil, message, true
+		}
+		pending.Reset()
+		response.Choices = append(response.Choices, openai.ChatCompletionStreamChoice{
+			Index: index,
+			Delta: openai.ChatCompletionStreamChoiceDelta{Content: content},
+		})
+	}
+	if len(response.Choices) == 0 {
+		return nil, "", false
+	}
+	return &response, "", false
+}
+
+func (s *streamInspector) check(index int, content string) (string, bool, string) {
+	if content == "" {
+		return "", false, ""
+	}
+	resp := openai.ChatCompletionResponse{
+		ID:    s.last.ID,
+		Model: s.last.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index:   index,
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+		}},
+	}
+	if blocked, message, _ := rules.Output(s.r, &resp); blocked {
+		return "", true, message
+	}
+	return resp.Choices[0].Message.Content, false, ""
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"context"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamUsage accumulates the usage of a streamed chat completion. The provider
+// only reports usage in the last chunk, so when a stream ends early the usage
+// is counted from the content received so far.
+type streamUsage struct {
+	ctx          context.Context
+	req          openai.ChatCompletionRequest
+	model        string
+	content      strings.Builder
+	finishReason openai.FinishReason
+	reported     *openai.Usage
+	requestType  string
+	hashes       *lib.ContentHashes
+}
+
+func newStreamUsage(ctx context.Context, req openai.ChatCompletionRequest) *streamUsage {
+	return &streamUsage{ctx: ctx, req: req, model: req.Model, requestType: "chat_completion_stream"}
+}
+
+func (u *streamUsage) add(response openai.ChatCompletionStreamResponse) {
+	if response.Model != "" {
+		u.model = response.Model
+	}
+	if response.Usage != nil {
+		u.reported = response.Usage
+	}
+	for _, choice := range response.Choices {
+		u.content.WriteString(choice.Delta.Content)
+		if choice.FinishReason != "" {
+			u.finishReason = choice.FinishReason
+		}
+	}
+}
+
+// record logs the usage. Streams without a finish reason were cut short and
+// are recorded with the client_disconnect finish reason if the client went
+// away, a null one otherwise.
+func (u *streamUsage) record() {
+	var finishReason string
+	if u.finishReason == "" {
+		finishReason = lib.CutShortFinishReason(u.ctx, u.model)
+	} else {
+		finishReason = string(u.finishReason)
+	}
+
+	if u.reported != nil {
+		lib.HashedUsage(u.ctx, u.hashes, u.model, 0, u.reported.PromptTokens, u.reported.CompletionTokens, u.reported.TotalTokens, finishReason, u.requestType)
+		return
+	}
+
+	var prompt strings.Builder
+	for _, message := range u.req.Messages {
+		prompt.WriteString(message.Content)
+		for _, part := range message.MultiContent {
+			prompt.WriteString(part.Text)
+		}
+	}
+	promptTokens := lib.CountTokens(u.model, prompt.String())
+	completionTokens := lib.CountTokens(u.model, u.content.String())
+	lib.HashedUsage(u.ctx, u.hashes, u.model, 0, promptTokens, completionTokens, promptTokens+completionTokens, finishReason, u.requestType)
+}
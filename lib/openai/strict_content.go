@@ -0,0 +1,14 @@
+package openai
+
+import (
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// checkStrictContent rejects models and parameters disabled by the strict content
+// profile
+func checkStrictContent(r *http.Request, body []byte, req openai.ChatCompletionRequest) error {
+	return lib.CheckStrictContent(r, body, req.Model, req.Temperature)
+}
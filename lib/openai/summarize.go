@@ -0,0 +1,66 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OSContextSummarizedHeader is the number of messages replaced by a summary to fit the context window
+const OSContextSummarizedHeader = "OS-Context-Summarized"
+
+const summaryPrompt = "Summarize the following conversation between a user and an assistant for the assistant to continue it. " +
+	"Keep names, facts, decisions and open questions. Answer with the summary only."
+
+// summarizeConversation replaces the older messages of a request with a summary
+// written by the summary model and returns the number of replaced messages. The
+// summary request is recorded in usage like any other completion.
+func summarizeConversation(ctx context.Context, config lib.Configuration, req *openai.ChatCompletionRequest) (int, error) {
+	settings := lib.GetContextWindowSettings()
+	start, end := lib.SummaryCandidates(req.Messages, settings.KeepMessages)
+	if end-start < 2 {
+		return 0, nil
+	}
+
+	var transcript strings.Builder
+	for _, message := range req.Messages[start:end] {
+		content := message.Content
+		for _, part := range message.MultiContent {
+			content += part.Text
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, content)
+	}
+
+	client, err := newClient(config, settings.SummaryModel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create client: %v", err)
+	}
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: settings.SummaryModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to summarize the conversation: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		return 0, fmt.Errorf("failed to summarize the conversation: no choices")
+	}
+	lib.Usage(ctx, resp.Model, 0, resp.Usage.PromptTokens, resp.Usage.CompletionTokens, resp.Usage.TotalTokens, string(resp.Choices[0].FinishReason), "summarization")
+
+	summary := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: "Summary of the earlier conversation: " + resp.Choices[0].Message.Content,
+	}
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.Messages)-(end-start)+1)
+	messages = append(messages, req.Messages[:start]...)
+	messages = append(messages, summary)
+	messages = append(messages, req.Messages[end:]...)
+	req.Messages = messages
+	return end - start, nil
+}
@@ -0,0 +1,194 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/rules"
+	"github.com/sashabaranov/go-openai"
+)
+
+// textAPI is an endpoint answering prompts with text other than chat
+// completions: the legacy completions and the responses API. Its requests are
+// checked as the chat completion they amount to, so they go through the same
+// policies and rules, and are then sent to the provider with the texts the rules
+// redacted.
+type textAPI struct {
+	path        string
+	logType     string
+	usageType   string
+	intercept   func(w http.ResponseWriter, model string, stream bool, interception *rules.Interception)
+	streamChunk func(usage *streamUsage, data []byte)
+}
+
+// checkTextRequest applies the request checks and input rules of chat
+// completions to req, the chat completion a text API request amounts to. When
+// the rules redacted a message, encode returns the body with the redacted
+// messages. It returns the body to send to the provider and reports false once
+// it answered the request.
+func checkTextRequest(w http.ResponseWriter, r *http.Request, api textAPI, body []byte, req openai.ChatCompletionRequest, encode func(messages []openai.ChatCompletionMessage) ([]byte, error)) (*http.Request, []byte, bool) {
+	if killSwitch, ok := lib.ActiveKillSwitch(lib.KillSwitchModel, req.Model); ok {
+		lib.KillSwitchResponse(w, killSwitch)
+		return r, nil, false
+	}
+	r, ok := applyRequestPolicies(w, r, req.Model)
+	if !ok {
+		return r, nil, false
+	}
+	if err := checkStrictContent(r, body, req); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return r, nil, false
+	}
+	if err := checkMessageURLs(req); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return r, nil, false
+	}
+
+	if dryRun(w, r, req) {
+		return r, nil, false
+	}
+
+	// The rules redact the messages in place, the ones of the caller are kept
+	original := req.Messages
+	req.Messages = slices.Clone(req.Messages)
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	if filtered, errorMessage, err := rules.Input(r, req); filtered {
+		var interception *rules.Interception
+		if errors.As(err, &interception) {
+			performRestrictedAuditLogging(r, body, interception)
+			w.Header().Set(OSInterceptedHeader, interception.Rule)
+			api.intercept(w, req.Model, req.Stream, interception)
+			return r, nil, false
+		}
+		lib.AuditLogs(string(body), api.logType, apiKeyId, "input", r)
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleBlocked(w, r, errorMessage, err)
+		return r, nil, false
+	}
+	if !slices.EqualFunc(original, req.Messages, func(a, b openai.ChatCompletionMessage) bool { return a.Content == b.Content }) {
+		redacted, err := encode(req.Messages)
+		if err != nil {
+			handleError(w, err, http.StatusBadRequest)
+			return r, nil, false
+		}
+		body = redacted
+	}
+	lib.AuditLogs(string(body), api.logType, apiKeyId, "input", r)
+	lib.SaveRequestDiff(r, body)
+
+	if err := lib.ReserveCapacity(r, lib.CountPromptTokens(req)+req.MaxTokens); err != nil {
+		handleError(w, err, http.StatusTooManyRequests)
+		return r, nil, false
+	}
+	return r, body, true
+}
+
+// sendTextRequest sends a text API request body to the provider
+func sendTextRequest(ctx context.Context, config lib.Configuration, api textAPI, model string, body []byte, stream bool) (*http.Response, error) {
+	httpClient, err := lib.ModelHTTPClient(config.Providers.OpenAI, model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, providerURL(config, model, api.path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	setProviderAuth(config, req.Header)
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	lib.GetOpenAIAccount(ctx).SetHeaders(req.Header)
+	return providerClient(config, httpClient).Do(req)
+}
+
+// relayProviderError writes an error response of the provider as it came
+func relayProviderError(w http.ResponseWriter, resp *http.Response) {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// checkTextOutput applies the response limits and output rules to the texts of
+// a response, one per choice, and returns them as the client may see them. It
+// reports false once it answered the request.
+func checkTextOutput(w http.ResponseWriter, r *http.Request, model string, texts []string) ([]string, bool) {
+	resp := openai.ChatCompletionResponse{Model: model}
+	for i, text := range texts {
+		resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{
+			Index:   i,
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text},
+		})
+	}
+	if err := limitResponse(&resp); err != nil {
+		handleProviderError(w, r, err, http.StatusBadGateway)
+		return nil, false
+	}
+	if blocked, errorMessage, _ := rules.Output(r, &resp); blocked {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleBlocked(w, r, errorMessage, nil)
+		return nil, false
+	}
+
+	checked := make([]string, len(resp.Choices))
+	for i, choice := range resp.Choices {
+		checked[i] = choice.Message.Content
+	}
+	return checked, true
+}
+
+// performTextAuditLogging logs the response of a text API request
+func performTextAuditLogging(r *http.Request, api textAPI, response []byte) {
+	if lib.AuditLoggingActive(r) {
+		apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+		lib.AuditLogs(string(response), api.logType, apiKeyId, "output", r)
+	}
+}
+
+// relayTextStream sends the events of a streamed response to the client as they
+// come, feeding the data of every event to the usage of the stream
+func relayTextStream(w http.ResponseWriter, r *http.Request, api textAPI, resp *http.Response, usage *streamUsage) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, fmt.Errorf("streaming unsupported"), http.StatusInternalServerError)
+		return
+	}
+	defer usage.record()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		fmt.Fprintf(w, "%s\n", line)
+		if len(line) == 0 {
+			flusher.Flush()
+			continue
+		}
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if data = bytes.TrimSpace(data); !bytes.Equal(data, []byte("[DONE]")) {
+				api.streamChunk(usage, data)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		writeStreamError(w, r, http.StatusBadGateway, "provider_error", err.Error())
+	}
+	flusher.Flush()
+}
@@ -0,0 +1,69 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactedCompletionBody(t *testing.T) {
+	body := []byte(`{"model":"gpt-3.5-turbo-instruct","prompt":"Call John at 555-0100","max_tokens":16}`)
+	redacted, err := redactedCompletionBody(body, []string{"Call John at 555-0100"}, "Call <PERSON> at <PHONE>")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-3.5-turbo-instruct","prompt":"Call <PERSON> at <PHONE>","max_tokens":16}`, string(redacted))
+
+	body = []byte(`{"model":"gpt-3.5-turbo-instruct","prompt":["Hi John","Hi Jane"]}`)
+	redacted, err = redactedCompletionBody(body, []string{"Hi John", "Hi Jane"}, "Hi <PERSON>\n\nHi <PERSON>")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-3.5-turbo-instruct","prompt":["Hi <PERSON>","Hi <PERSON>"]}`, string(redacted))
+
+	_, err = redactedCompletionBody(body, []string{"Hi John", "Hi Jane"}, "Hi <PERSON>")
+	assert.Error(t, err)
+}
+
+func TestRedactedResponsesBody(t *testing.T) {
+	redact := func(t *testing.T, body string, redactions map[string]string) string {
+		var req responsesRequest
+		require.NoError(t, json.Unmarshal([]byte(body), &req))
+		messages, err := responsesMessages(req)
+		require.NoError(t, err)
+		redacted := make([]openai.ChatCompletionMessage, len(messages))
+		for i, message := range messages {
+			redacted[i] = message
+			if content, ok := redactions[message.Content]; ok {
+				redacted[i].Content = content
+			}
+		}
+		encoded, err := redactedResponsesBody([]byte(body), req, messages, redacted)
+		require.NoError(t, err)
+		return string(encoded)
+	}
+
+	t.Run("string input", func(t *testing.T) {
+		body := redact(t, `{"model":"gpt-4o","instructions":"Be brief","input":"My email is john@example.com"}`,
+			map[string]string{"My email is john@example.com": "My email is <EMAIL>"})
+		assert.JSONEq(t, `{"model":"gpt-4o","instructions":"Be brief","input":"My email is <EMAIL>"}`, body)
+	})
+
+	t.Run("input items", func(t *testing.T) {
+		body := redact(t, `{"model":"gpt-4o","input":[
+			{"role":"user","content":"Hi, I am John"},
+			{"type":"function_call_output","call_id":"call_1","output":"John lives in Berlin"},
+			{"role":"user","content":[{"type":"input_text","text":"Unchanged"}]},
+			{"role":"user","content":[{"type":"input_text","text":"Call John"},{"type":"input_image","image_url":"data:image/png;base64,iVBORw0KGgo="},{"type":"input_text","text":"at 555-0100"}]}
+		]}`, map[string]string{
+			"Hi, I am John":          "Hi, I am <PERSON>",
+			"John lives in Berlin":   "<PERSON> lives in <LOCATION>",
+			"Call John\nat 555-0100": "Call <PERSON>\nat <PHONE>",
+		})
+		assert.JSONEq(t, `{"model":"gpt-4o","input":[
+			{"role":"user","content":"Hi, I am <PERSON>"},
+			{"type":"function_call_output","call_id":"call_1","output":"<PERSON> lives in <LOCATION>"},
+			{"role":"user","content":[{"type":"input_text","text":"Unchanged"}]},
+			{"role":"user","content":[{"type":"input_text","text":"Call <PERSON>\nat <PHONE>"},{"type":"input_image","image_url":"data:image/png;base64,iVBORw0KGgo="}]}
+		]}`, body)
+	})
+}
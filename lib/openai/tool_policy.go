@@ -0,0 +1,125 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// proposedToolCall is a tool or function call from a completion choice
+type proposedToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// errToolCallRejected is returned when a policy or an admin rejected a tool call
+type errToolCallRejected struct {
+	reason string
+}
+
+func (e *errToolCallRejected) Error() string {
+	return e.reason
+}
+
+// checkToolCalls records every tool call of a completion and applies the tool
+// policy. Calls that need approval are held until an admin decides.
+func checkToolCalls(r *http.Request, req openai.ChatCompletionRequest, resp openai.ChatCompletionResponse) error {
+	policy := lib.GetToolPolicySettings()
+	if !policy.Enabled {
+		return nil
+	}
+
+	var calls []proposedToolCall
+	for _, choice := range resp.Choices {
+		for _, toolCall := range choice.Message.ToolCalls {
+			calls = append(calls, proposedToolCall{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments})
+		}
+		if call := choice.Message.FunctionCall; call != nil {
+			calls = append(calls, proposedToolCall{Name: call.Name, Arguments: call.Arguments})
+		}
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	for _, call := range calls {
+		record, _ := json.Marshal(call)
+		lib.AuditLogs(string(record), "tool_call", apiKeyId, "output", r)
+
+		action, err := lib.CheckToolCall(policy, call.Name, call.Arguments)
+		switch action {
+		case lib.ToolDeny:
+			log.Printf("Tool call %s denied: %v", call.Name, err)
+			return &errToolCallRejected{reason: fmt.Sprintf("tool call rejected by policy: %v", err)}
+		case lib.ToolApprove:
+			log.Printf("Tool call %s waiting for approval", call.Name)
+			status, err := lib.RequestToolApproval(r.Context(), lib.ToolApproval{
+				Tool:      call.Name,
+				Arguments: call.Arguments,
+				Model:     req.Model,
+				ApiKeyID:  apiKeyId,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to wait for tool approval: %v", err)
+			}
+			if status != lib.ToolApprovalApproved {
+				return &errToolCallRejected{reason: fmt.Sprintf("tool call %s was not approved: %s", call.Name, status)}
+			}
+		}
+	}
+	return nil
+}
+
+// handleToolCallError answers a request whose tool calls didn't pass checkToolCalls
+func handleToolCallError(w http.ResponseWriter, r *http.Request, err error) {
+	var rejected *errToolCallRejected
+	if errors.As(err, &rejected) {
+		lib.MarkRolloutBlocked(r)
+		lib.MarkRuleViolation(r)
+		handleError(w, err, http.StatusForbidden)
+		return
+	}
+	handleError(w, err, http.StatusInternalServerError)
+}
+
+// hasToolCalls reports whether a completion proposes tool or function calls.
+// They aren't cached, so every call goes through the tool policy and approval.
+func hasToolCalls(resp openai.ChatCompletionResponse) bool {
+	for _, choice := range resp.Choices {
+		if len(choice.Message.ToolCalls) > 0 || choice.Message.FunctionCall != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCachedToolCalls applies the tool policy to the calls of a cached
+// completion, for entries cached before completions with calls no longer were.
+// It reports false once it answered the request.
+func checkCachedToolCalls(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest, cached []byte) bool {
+	var resp openai.ChatCompletionResponse
+	if json.Unmarshal(cached, &resp) != nil {
+		return true
+	}
+	if err := checkToolCalls(r, req, resp); err != nil {
+		handleToolCallError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// checkToolStreaming rejects streams that may return tool calls, the tool policy
+// needs the complete calls
+func checkToolStreaming(req openai.ChatCompletionRequest) error {
+	if req.Stream && lib.GetToolPolicySettings().Enabled && (len(req.Tools) > 0 || len(req.Functions) > 0) {
+		return fmt.Errorf("streaming is not available for requests with tools while the tool policy is enabled")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasToolCalls(t *testing.T) {
+	assert.False(t, hasToolCalls(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hi"}}}}))
+	assert.True(t, hasToolCalls(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+		{Message: openai.ChatCompletionMessage{Content: "Hi"}},
+		{Message: openai.ChatCompletionMessage{ToolCalls: []openai.ToolCall{{Function: openai.FunctionCall{Name: "delete_repo"}}}}},
+	}}))
+	assert.True(t, hasToolCalls(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{FunctionCall: &openai.FunctionCall{Name: "delete_repo"}}}}}))
+}
+
+func TestCheckCachedToolCalls(t *testing.T) {
+	defer func(policy *lib.ToolPolicy) { lib.AppConfig.Settings.ToolPolicy = policy }(lib.AppConfig.Settings.ToolPolicy)
+	lib.AppConfig.Settings.ToolPolicy = &lib.ToolPolicy{Enabled: true, DefaultAction: lib.ToolAllow, Tools: []lib.ToolRule{{Name: "delete_repo", Action: lib.ToolDeny}}}
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "apiKeyId", uuid.New()))
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+	cached := func(tool string) []byte {
+		data, err := json.Marshal(openai.ChatCompletionResponse{Model: "gpt-4o", Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: tool, Arguments: "{}"}}}},
+		}}})
+		require.NoError(t, err)
+		return data
+	}
+
+	// A cached call the policy denies isn't answered from the cache
+	w := httptest.NewRecorder()
+	assert.False(t, checkCachedToolCalls(w, r, req, cached("delete_repo")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "tool call rejected by policy")
+
+	w = httptest.NewRecorder()
+	assert.True(t, checkCachedToolCalls(w, r, req, cached("get_weather")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, checkCachedToolCalls(w, r, req, []byte(`{"choices":[{"message":{"content":"Hi"}}]}`)))
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+)
+
+// OpenAI headers selecting the organization and project a request is billed to
+const (
+	OpenAIOrganizationHeader = "OpenAI-Organization"
+	OpenAIProjectHeader      = "OpenAI-Project"
+)
+
+// OpenAIAccount is the OpenAI organization and project of a product, or of its
+// workspace when the product doesn't set them
+type OpenAIAccount struct {
+	Organization string
+	Project      string
+}
+
+// GetOpenAIAccount returns the OpenAI account of the API key of a request context
+func GetOpenAIAccount(ctx context.Context) OpenAIAccount {
+	account, _ := ctx.Value("openaiAccount").(OpenAIAccount)
+	return account
+}
+
+// SetHeaders sets the organization and project headers of the account that are set
+func (a OpenAIAccount) SetHeaders(header http.Header) {
+	if a.Organization != "" {
+		header.Set(OpenAIOrganizationHeader, a.Organization)
+	}
+	if a.Project != "" {
+		header.Set(OpenAIProjectHeader, a.Project)
+	}
+}
+
+// OpenAIAccountClient wraps a provider client so its requests carry the OpenAI
+// account of their context, letting provider-side billing follow the tenancy
+func OpenAIAccountClient(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &http.Client{Transport: &openAIAccountTransport{next: next}, Timeout: client.Timeout}
+}
+
+type openAIAccountTransport struct {
+	next http.RoundTripper
+}
+
+func (t *openAIAccountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	account := GetOpenAIAccount(req.Context())
+	if account == (OpenAIAccount{}) {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	account.SetHeaders(req.Header)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAIAccountClient(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+	client := OpenAIAccountClient(&http.Client{})
+
+	ctx := context.WithValue(context.Background(), "openaiAccount", OpenAIAccount{Organization: "org-billing", Project: "proj_support"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "org-billing", received.Get(OpenAIOrganizationHeader))
+	assert.Equal(t, "proj_support", received.Get(OpenAIProjectHeader))
+	assert.Empty(t, req.Header.Get(OpenAIOrganizationHeader), "the request of the caller is left as it is")
+
+	ctx = context.WithValue(context.Background(), "openaiAccount", OpenAIAccount{Project: "proj_support"})
+	req, _ = http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, received.Get(OpenAIOrganizationHeader))
+	assert.Equal(t, "proj_support", received.Get(OpenAIProjectHeader))
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL, nil)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, received.Get(OpenAIProjectHeader))
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Span kinds and status codes of OTLP
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpStatusError      = 2
+)
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// otlpAttributes returns attributes in the OTLP format, ordered by key
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		attribute := otlpAttribute{Key: key}
+		attribute.Value.StringValue = attributes[key]
+		result = append(result, attribute)
+	}
+	return result
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpSpans converts a trace to OTLP spans, the server span of the request and
+// its children
+func otlpSpans(trace Trace) []otlpSpan {
+	attributes := map[string]string{
+		"http.request.method":       trace.Method,
+		"http.route":                trace.Route,
+		"http.response.status_code": strconv.Itoa(trace.Status),
+		"sampling.reason":           trace.Reason,
+	}
+	if trace.Workspace != "" {
+		attributes["openshield.workspace_id"] = trace.Workspace
+		attributes["openshield.product_id"] = trace.Product
+	}
+	if trace.Violation {
+		attributes["openshield.rule_violation"] = "true"
+	}
+	request := otlpSpan{
+		TraceID:           trace.TraceID,
+		SpanID:            trace.SpanID,
+		ParentSpanID:      trace.ParentID,
+		Name:              trace.Method + " " + trace.Route,
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: otlpTime(trace.Start),
+		EndTimeUnixNano:   otlpTime(trace.Start.Add(trace.Duration)),
+		Attributes:        otlpAttributes(attributes),
+	}
+	if trace.Status >= http.StatusInternalServerError {
+		request.Status.Code = otlpStatusError
+	}
+
+	spans := []otlpSpan{request}
+	for _, child := range trace.Spans {
+		span := otlpSpan{
+			TraceID:           trace.TraceID,
+			SpanID:            child.SpanID,
+			ParentSpanID:      child.ParentID,
+			Name:              child.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: otlpTime(child.Start),
+			EndTimeUnixNano:   otlpTime(child.Start.Add(child.Duration)),
+			Attributes:        otlpAttributes(child.Attributes),
+		}
+		if child.Client {
+			span.Kind = otlpSpanKindClient
+		}
+		if child.Error != "" {
+			span.Status.Code, span.Status.Message = otlpStatusError, child.Error
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// otlpRequest returns the OTLP/HTTP JSON export request of traces
+func otlpRequest(settings TracesExporter, traces []Trace) map[string]interface{} {
+	resource := map[string]string{"service.name": settings.Service}
+	if settings.Env != "" {
+		resource["deployment.environment"] = settings.Env
+	}
+	spans := []otlpSpan{}
+	for _, trace := range traces {
+		spans = append(spans, otlpSpans(trace)...)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "openshield"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func sendOTLPTraces(ctx context.Context, settings TracesExporter, traces []Trace) error {
+	body, err := json.Marshal(otlpRequest(settings, traces))
+	if err != nil {
+		return err
+	}
+
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(settings.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range settings.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
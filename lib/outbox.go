@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxBatchSize       = 100
+	outboxDeliveryTimeout = 10 * time.Second
+)
+
+func init() {
+	RegisterJob(Job{
+		Name:     "relay_outbox",
+		Interval: 10 * time.Second,
+		Run:      relayOutbox,
+	})
+}
+
+func outboxEnabled() bool {
+	outbox := GetConfig().Settings.Outbox
+	return outbox != nil && outbox.Enabled
+}
+
+// insertUsage stores a usage row. With the outbox enabled the row and its
+// usage.created event are written in the same transaction, so an event exists
+// for every usage row and for nothing else.
+func insertUsage(usage *models.Usage) error {
+	if !outboxEnabled() {
+		return DB().Create(usage).Error
+	}
+
+	return DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(usage).Error; err != nil {
+			return err
+		}
+		payload, err := json.Marshal(usage)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.OutboxEvents{
+			EventType:     "usage.created",
+			AggregateID:   usage.Id,
+			Payload:       string(payload),
+			Status:        models.OutboxPending,
+			NextAttemptAt: time.Now(),
+		}).Error
+	})
+}
+
+// relayOutbox delivers pending outbox events to the webhook. A batch is claimed
+// in a short transaction by moving its next attempt past the time its delivery
+// can take, so other replicas skip it and no lock is held while webhooks answer.
+// Events are then delivered and marked one by one. Every request carries the
+// event ID as Idempotency-Key, so receivers can drop redeliveries that happen
+// when the gateway stops between delivering and marking an event as sent.
+func relayOutbox(ctx context.Context) error {
+	if !outboxEnabled() {
+		return nil
+	}
+	outbox := GetConfig().Settings.Outbox
+	if outbox.WebhookURL == "" {
+		return fmt.Errorf("settings.outbox.webhook_url is not set")
+	}
+	if err := CheckURL(outbox.WebhookURL); err != nil {
+		return fmt.Errorf("outbox webhook rejected: %v", err)
+	}
+	maxAttempts := outbox.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+
+	client, err := ProviderHTTPClient(nil)
+	if err != nil {
+		return err
+	}
+
+	events, err := claimOutboxEvents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		// Events left when the job stops are due again once their claim ends
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		updates := map[string]interface{}{}
+		if err := deliverOutboxEvent(ctx, client, outbox.WebhookURL, event); err != nil {
+			attempts := event.Attempts + 1
+			updates["attempts"] = attempts
+			updates["last_error"] = err.Error()
+			updates["next_attempt_at"] = time.Now().Add(outboxBackoff(attempts))
+			if attempts >= maxAttempts {
+				updates["status"] = models.OutboxFailed
+				log.Printf("Giving up on outbox event %s after %d attempts: %v", event.Id, attempts, err)
+			}
+		} else {
+			updates["status"] = models.OutboxSent
+			updates["sent_at"] = time.Now()
+		}
+		// Marked without the job context, so a delivered event isn't left to be sent again
+		if err := DB().Model(&models.OutboxEvents{}).Where("id = ?", event.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimOutboxEvents returns a batch of due events and delays their next attempt
+// until the batch can have been delivered, after which a stopped replica's
+// events are due again
+func claimOutboxEvents(ctx context.Context) ([]models.OutboxEvents, error) {
+	var events []models.OutboxEvents
+	err := DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.OutboxPending, time.Now()).
+			Order("created_at").
+			Limit(outboxBatchSize).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		ids := make([]uuid.UUID, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.Id)
+		}
+		lease := time.Now().Add(outboxBatchSize * outboxDeliveryTimeout)
+		return tx.Model(&models.OutboxEvents{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	return events, err
+}
+
+func deliverOutboxEvent(ctx context.Context, client *http.Client, webhookURL string, event models.OutboxEvents) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":           event.Id,
+		"type":         event.EventType,
+		"aggregate_id": event.AggregateID,
+		"created_at":   event.CreatedAt,
+		"data":         json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, outboxDeliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", event.Id.String())
+	req.Header.Set("X-OpenShield-Event-Type", event.EventType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Duration(1<<uint(attempts)) * time.Second
+	if backoff > time.Hour || backoff <= 0 {
+		return time.Hour
+	}
+	return backoff
+}
@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// Physical and converted types of the Parquet format used by the gateway
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// ParquetColumn is a required column of a Parquet file. Exactly one of the
+// value slices is set, timestamps are Unix milliseconds in Int64s.
+type ParquetColumn struct {
+	Name      string
+	Timestamp bool
+	Int64s    []int64
+	Strings   []string
+	Bools     []bool
+}
+
+func (c ParquetColumn) physicalType() int32 {
+	switch {
+	case c.Strings != nil:
+		return parquetByteArray
+	case c.Bools != nil:
+		return parquetBoolean
+	}
+	return parquetInt64
+}
+
+func (c ParquetColumn) len() int {
+	return max(len(c.Int64s), len(c.Strings), len(c.Bools))
+}
+
+// plain returns the values of the column in the PLAIN encoding
+func (c ParquetColumn) plain() []byte {
+	var values bytes.Buffer
+	switch c.physicalType() {
+	case parquetByteArray:
+		for _, s := range c.Strings {
+			binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+			values.WriteString(s)
+		}
+	case parquetBoolean:
+		packed := make([]byte, (len(c.Bools)+7)/8)
+		for i, b := range c.Bools {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	default:
+		for _, v := range c.Int64s {
+			binary.Write(&values, binary.LittleEndian, v)
+		}
+	}
+	return values.Bytes()
+}
+
+// WriteParquet writes the columns as an uncompressed Parquet file with a single
+// row group and one data page per column
+func WriteParquet(w io.Writer, columns []ParquetColumn) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns")
+	}
+	rows := columns[0].len()
+	for _, column := range columns {
+		if column.len() != rows {
+			return fmt.Errorf("column %s has %d values, expected %d", column.Name, column.len(), rows)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	offsets := make([]int64, len(columns))
+	sizes := make([]int64, len(columns))
+	for i, column := range columns {
+		data := column.plain()
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(rows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3) // RLE
+		header.endStruct()
+		header.stop()
+
+		offsets[i] = int64(file.Len())
+		file.Write(header.Bytes())
+		file.Write(data)
+		sizes[i] = int64(file.Len()) - offsets[i]
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, column := range columns {
+		meta.beginElement()
+		meta.i32(1, column.physicalType())
+		meta.i32(3, 0) // REQUIRED
+		meta.binary(4, column.Name)
+		switch {
+		case column.Strings != nil:
+			meta.i32(6, parquetUTF8)
+		case column.Timestamp:
+			meta.i32(6, parquetTimestampMillis)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(rows))
+	meta.beginList(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.beginList(1, thriftStruct, len(columns))
+	var total int64
+	for i, column := range columns {
+		total += sizes[i]
+		meta.beginElement()
+		meta.i64(2, offsets[i])
+		meta.beginStruct(3)
+		meta.i32(1, column.physicalType())
+		meta.beginList(2, thriftI32, 1)
+		meta.listI32(0) // PLAIN
+		meta.beginList(3, thriftBinary, 1)
+		meta.listBinary(column.Name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(rows))
+		meta.i64(6, sizes[i])
+		meta.i64(7, sizes[i])
+		meta.i64(9, offsets[i])
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(rows))
+	meta.endStruct()
+	meta.binary(6, "openshield")
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(len(meta.Bytes())))
+	file.WriteString(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// Types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the structs of the Parquet metadata with the Thrift
+// compact protocol. Nested structs are opened with beginStruct, or
+// beginElement in lists, and closed with endStruct.
+type thriftWriter struct {
+	bytes.Buffer
+	lastField []int16
+	current   int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) field(id int16, fieldType byte) {
+	if delta := id - t.current; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.WriteByte(fieldType)
+		t.varint(uint64((int64(id) << 1) ^ (int64(id) >> 63)))
+	}
+	t.current = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(uint64((int64(v) << 1) ^ (int64(v) >> 63)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) listBinary(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(uint64((int64(v) << 1) ^ (int64(v) >> 63)))
+}
+
+func (t *thriftWriter) beginList(id int16, elementType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		t.WriteByte(0xf0 | elementType)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) beginElement() {
+	t.lastField = append(t.lastField, t.current)
+	t.current = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.current = t.lastField[len(t.lastField)-1]
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.WriteByte(0)
+}
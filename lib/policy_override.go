@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// PolicyOverrideHeader carries a policy override token on a request
+const PolicyOverrideHeader = "X-OpenShield-Override"
+
+// ErrInvalidPolicyOverride is returned for override tokens with a bad signature,
+// an unknown format or past their expiry
+var ErrInvalidPolicyOverride = errors.New("invalid policy override token")
+
+// PolicyOverride lets the requests of a trusted service skip the named rules
+// until it expires. ApiKeyID limits it to the requests of one API key, it
+// applies to every key if nil.
+type PolicyOverride struct {
+	ID        string    `json:"id"`
+	Service   string    `json:"service"`
+	Rules     []string  `json:"rules"`
+	ApiKeyID  uuid.UUID `json:"api_key_id"`
+	IssuedBy  string    `json:"issued_by"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssuePolicyOverride signs an override of rules for a service, valid for duration
+func IssuePolicyOverride(service string, rules []string, apiKeyID uuid.UUID, issuedBy string, duration time.Duration) (string, PolicyOverride, error) {
+	config := GetConfig()
+	if config.Settings.PolicyOverrides == nil || !config.Settings.PolicyOverrides.Enabled {
+		return "", PolicyOverride{}, fmt.Errorf("policy overrides are not enabled in settings.policy_overrides")
+	}
+	if config.Secrets.PolicyOverrideKey == "" {
+		return "", PolicyOverride{}, fmt.Errorf("policy override key is not set")
+	}
+	if service == "" || len(rules) == 0 {
+		return "", PolicyOverride{}, fmt.Errorf("a service and the rules to override are required")
+	}
+
+	maxDuration := time.Duration(config.Settings.PolicyOverrides.MaxDuration) * time.Second
+	if maxDuration <= 0 {
+		maxDuration = time.Hour
+	}
+	if duration <= 0 || duration > maxDuration {
+		return "", PolicyOverride{}, fmt.Errorf("duration must be between 0 and %v", maxDuration)
+	}
+
+	now := time.Now().UTC()
+	override := PolicyOverride{
+		ID:        uuid.NewString(),
+		Service:   service,
+		Rules:     rules,
+		ApiKeyID:  apiKeyID,
+		IssuedBy:  issuedBy,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(duration),
+	}
+	claims, err := json.Marshal(override)
+	if err != nil {
+		return "", PolicyOverride{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + signPolicyOverride(payload, []byte(config.Secrets.PolicyOverrideKey)), override, nil
+}
+
+// ParsePolicyOverride checks the signature and expiry of an override token
+func ParsePolicyOverride(token string) (PolicyOverride, error) {
+	config := GetConfig()
+	if config.Settings.PolicyOverrides == nil || !config.Settings.PolicyOverrides.Enabled || config.Secrets.PolicyOverrideKey == "" {
+		return PolicyOverride{}, fmt.Errorf("policy overrides are not enabled")
+	}
+
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return PolicyOverride{}, ErrInvalidPolicyOverride
+	}
+	expected := signPolicyOverride(payload, []byte(config.Secrets.PolicyOverrideKey))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return PolicyOverride{}, ErrInvalidPolicyOverride
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return PolicyOverride{}, ErrInvalidPolicyOverride
+	}
+	var override PolicyOverride
+	if err := json.Unmarshal(claims, &override); err != nil {
+		return PolicyOverride{}, ErrInvalidPolicyOverride
+	}
+	if !time.Now().Before(override.ExpiresAt) {
+		return PolicyOverride{}, fmt.Errorf("%w: expired at %v", ErrInvalidPolicyOverride, override.ExpiresAt)
+	}
+	return override, nil
+}
+
+func signPolicyOverride(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// withPolicyOverride stores the override token of a request in the context. A
+// token that fails its checks, or belongs to another API key, refuses the request.
+func withPolicyOverride(ctx context.Context, r *http.Request, apiKey models.ApiKeys) (context.Context, error) {
+	token := r.Header.Get(PolicyOverrideHeader)
+	if token == "" {
+		return ctx, nil
+	}
+	override, err := ParsePolicyOverride(token)
+	if err != nil {
+		return ctx, err
+	}
+	if override.ApiKeyID != uuid.Nil && override.ApiKeyID != apiKey.Id {
+		return ctx, fmt.Errorf("%w: issued for another API key", ErrInvalidPolicyOverride)
+	}
+	return context.WithValue(ctx, "policyOverride", override), nil
+}
+
+// PolicyOverridden reports whether the override token of a request skips a
+// rule. Every skip is written to the audit log, even with audit logging off.
+func PolicyOverridden(r *http.Request, rule Rule) bool {
+	override, ok := r.Context().Value("policyOverride").(PolicyOverride)
+	if !ok || !slices.Contains(override.Rules, rule.Name) || !time.Now().Before(override.ExpiresAt) {
+		return false
+	}
+
+	log.Printf("Policy override %s of %s skipping rule: %s", override.ID, override.Service, rule.Name)
+	message, _ := json.Marshal(map[string]interface{}{
+		"override_id": override.ID,
+		"service":     override.Service,
+		"rule":        rule.Name,
+		"issued_by":   override.IssuedBy,
+		"expires_at":  override.ExpiresAt,
+	})
+	apiKeyID, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	createOrQueue(queuedAuditLog, &models.AuditLogs{
+		Message:     string(message),
+		Type:        "policy_override",
+		MessageType: "used",
+		ApiKeyID:    apiKeyID,
+		IPAddress:   getIPAddress(r),
+		RequestId:   getRequestID(r),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	})
+	return true
+}
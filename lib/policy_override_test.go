@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyOverride(t *testing.T) {
+	defer func(settings *PolicyOverrides, key string) {
+		AppConfig.Settings.PolicyOverrides, AppConfig.Secrets.PolicyOverrideKey = settings, key
+	}(AppConfig.Settings.PolicyOverrides, AppConfig.Secrets.PolicyOverrideKey)
+	AppConfig.Settings.PolicyOverrides = &PolicyOverrides{Enabled: true, MaxDuration: 600}
+	AppConfig.Secrets.PolicyOverrideKey = "override-key"
+
+	apiKeyID := uuid.New()
+	token, override, err := IssuePolicyOverride("batch-import", []string{"pii_example"}, apiKeyID, "admin", time.Minute)
+	assert.NoError(t, err)
+	parsed, err := ParsePolicyOverride(token)
+	assert.NoError(t, err)
+	assert.Equal(t, override.ID, parsed.ID)
+	assert.Equal(t, []string{"pii_example"}, parsed.Rules)
+
+	_, _, err = IssuePolicyOverride("batch-import", []string{"pii_example"}, uuid.Nil, "admin", time.Hour)
+	assert.Error(t, err, "longer than max_duration")
+	_, _, err = IssuePolicyOverride("batch-import", nil, uuid.Nil, "admin", time.Minute)
+	assert.Error(t, err, "no rules")
+
+	// A token with changed claims or from another key doesn't verify
+	payload, signature, _ := strings.Cut(token, ".")
+	_, err = ParsePolicyOverride(payload + "x." + signature)
+	assert.ErrorIs(t, err, ErrInvalidPolicyOverride)
+	AppConfig.Secrets.PolicyOverrideKey = "other-key"
+	_, err = ParsePolicyOverride(token)
+	assert.ErrorIs(t, err, ErrInvalidPolicyOverride)
+	AppConfig.Secrets.PolicyOverrideKey = "override-key"
+
+	// The token only applies to the API key it was issued for
+	req := httptest.NewRequest("POST", "/test", nil)
+	req.Header.Set(PolicyOverrideHeader, token)
+	ctx, err := withPolicyOverride(req.Context(), req, models.ApiKeys{Base: models.Base{Id: apiKeyID}})
+	assert.NoError(t, err)
+	assert.Equal(t, override.ID, ctx.Value("policyOverride").(PolicyOverride).ID)
+	_, err = withPolicyOverride(req.Context(), req, models.ApiKeys{Base: models.Base{Id: uuid.New()}})
+	assert.ErrorIs(t, err, ErrInvalidPolicyOverride)
+
+	// Rules the token doesn't name aren't skipped
+	assert.False(t, PolicyOverridden(req.WithContext(ctx), Rule{Name: "prompt_injection_example"}))
+
+	AppConfig.Settings.PolicyOverrides.Enabled = false
+	_, err = ParsePolicyOverride(token)
+	assert.Error(t, err)
+}
+
+func TestPolicyOverrideExpired(t *testing.T) {
+	defer func(settings *PolicyOverrides, key string) {
+		AppConfig.Settings.PolicyOverrides, AppConfig.Secrets.PolicyOverrideKey = settings, key
+	}(AppConfig.Settings.PolicyOverrides, AppConfig.Secrets.PolicyOverrideKey)
+	AppConfig.Settings.PolicyOverrides = &PolicyOverrides{Enabled: true}
+	AppConfig.Secrets.PolicyOverrideKey = "override-key"
+
+	token, _, err := IssuePolicyOverride("batch-import", []string{"pii_example"}, uuid.Nil, "admin", time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = ParsePolicyOverride(token)
+	assert.ErrorIs(t, err, ErrInvalidPolicyOverride)
+}
@@ -0,0 +1,300 @@
+package lib
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "PASS"
+	PreflightWarn PreflightStatus = "WARN"
+	PreflightFail PreflightStatus = "FAIL"
+)
+
+// preflightTimeout bounds each network check
+const preflightTimeout = 10 * time.Second
+
+// certificateExpiryWarning is how long before expiry a certificate is reported
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// PreflightResult is the outcome of a single preflight check
+type PreflightResult struct {
+	Check  string          `json:"check"`
+	Status PreflightStatus `json:"status"`
+	Detail string          `json:"detail"`
+}
+
+// RunPreflight checks that the service can reach its dependencies with the
+// current configuration. It does not migrate the database.
+func RunPreflight(ctx context.Context) []PreflightResult {
+	results := []PreflightResult{preflightConfig(), preflightDatabase(ctx), preflightRedis(ctx)}
+	results = append(results, preflightProviders(ctx)...)
+	return append(results, preflightCertificates())
+}
+
+// PreflightPassed reports whether none of the checks failed
+func PreflightPassed(results []PreflightResult) bool {
+	for _, result := range results {
+		if result.Status == PreflightFail {
+			return false
+		}
+	}
+	return true
+}
+
+func preflightConfig() PreflightResult {
+	result := PreflightResult{Check: "config"}
+	if err := ValidateCryptoProfile(); err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+
+	config := GetConfig()
+	if config.Settings.Database == nil || config.Settings.Database.URI == "" {
+		result.Status, result.Detail = PreflightFail, "settings.database.uri is not set"
+		return result
+	}
+
+	// Rule configs are passed on to the rule plugins, so only unknown keys
+	// elsewhere are reported
+	var exact Configuration
+	var unknown []string
+	if err := viperCfg.UnmarshalExact(&exact); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+			if strings.Contains(line, "invalid keys") && !strings.HasPrefix(line, "'rules.") {
+				unknown = append(unknown, line)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		result.Status, result.Detail = PreflightWarn, strings.Join(unknown, "; ")
+		return result
+	}
+
+	result.Status, result.Detail = PreflightPass, viperCfg.ConfigFileUsed()
+	return result
+}
+
+func preflightDatabase(ctx context.Context) (result PreflightResult) {
+	result = PreflightResult{Check: "database"}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Status, result.Detail = PreflightFail, fmt.Sprint(r)
+		}
+	}()
+
+	connection, err := openDB()
+	if err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+	sqlDB, err := connection.DB()
+	if err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+	defer sqlDB.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+
+	var missing []string
+	for _, model := range migrationModels {
+		if !connection.WithContext(ctx).Migrator().HasTable(model) {
+			missing = append(missing, connection.NamingStrategy.TableName(reflect.TypeOf(model).Elem().Name()))
+		}
+	}
+	if len(missing) > 0 {
+		result.Status = PreflightFail
+		result.Detail = fmt.Sprintf("missing tables: %s (run openshield db create-tables)", strings.Join(missing, ", "))
+		return result
+	}
+
+	result.Status, result.Detail = PreflightPass, fmt.Sprintf("connected, %d tables present", len(migrationModels))
+	return result
+}
+
+func preflightRedis(ctx context.Context) PreflightResult {
+	result := PreflightResult{Check: "redis"}
+	if !RedisConfigured() {
+		result.Status, result.Detail = PreflightPass, "not configured"
+		return result
+	}
+
+	if _, err := redis.ParseURL(GetConfig().Settings.Redis.URI); err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if err := RedisClient().Ping(ctx).Err(); err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+
+	result.Status, result.Detail = PreflightPass, "connected"
+	return result
+}
+
+func preflightProviders(ctx context.Context) []PreflightResult {
+	config := GetConfig()
+	var results []PreflightResult
+
+	if config.Providers.OpenAI != nil && config.Providers.OpenAI.Enabled {
+		results = append(results, preflightOpenAI(ctx, config))
+	}
+	if config.Providers.HuggingFace != nil && config.Providers.HuggingFace.Enabled {
+		result := PreflightResult{Check: "provider huggingface", Status: PreflightWarn, Detail: "API key is set, not verified"}
+		if config.Secrets.HuggingFaceAPIKey == "" {
+			result.Status, result.Detail = PreflightFail, "HUGGINGFACE_API_KEY is not set"
+		}
+		results = append(results, result)
+	}
+	for _, provider := range []struct {
+		name, env string
+		config    *ProviderConfig
+		key       string
+	}{
+		{"cohere", "COHERE_API_KEY", config.Providers.Cohere, config.Secrets.CohereAPIKey},
+		{"voyage", "VOYAGE_API_KEY", config.Providers.Voyage, config.Secrets.VoyageAPIKey},
+		{"anthropic", "ANTHROPIC_API_KEY", config.Providers.Anthropic, config.Secrets.AnthropicAPIKey},
+	} {
+		if provider.config == nil || !provider.config.Enabled {
+			continue
+		}
+		result := PreflightResult{Check: "provider " + provider.name, Status: PreflightWarn, Detail: "API key is set, not verified"}
+		if provider.key == "" {
+			result.Status, result.Detail = PreflightFail, provider.env+" is not set"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// preflightOpenAI verifies the OpenAI credentials with a list models call
+func preflightOpenAI(ctx context.Context, config Configuration) PreflightResult {
+	result := PreflightResult{Check: "provider openai"}
+	if azure := GetAzureOpenAI(config); config.Secrets.OpenAIApiKey == "" && (azure == nil || azure.Auth != "entra_id") {
+		result.Status, result.Detail = PreflightFail, "OPENAI_API_KEY is not set"
+		return result
+	}
+
+	httpClient, err := ProviderHTTPClient(config.Providers.OpenAI)
+	if err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+	clientConfig := goopenai.DefaultConfig(config.Secrets.OpenAIApiKey)
+	if azure := GetAzureOpenAI(config); azure != nil {
+		clientConfig = azure.ClientConfig(config.Secrets.OpenAIApiKey)
+		httpClient = azure.AuthClient(httpClient, config.Secrets.OpenAIApiKey)
+	}
+	clientConfig.HTTPClient = httpClient
+
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	models, err := goopenai.NewClientWithConfig(clientConfig).ListModels(ctx)
+	if err != nil {
+		result.Status, result.Detail = PreflightFail, err.Error()
+		return result
+	}
+
+	result.Status, result.Detail = PreflightPass, fmt.Sprintf("%d models available", len(models.Models))
+	return result
+}
+
+// preflightCertificates checks the validity of the certificates in the provider CA bundles
+func preflightCertificates() PreflightResult {
+	result := PreflightResult{Check: "tls certificates"}
+	config := GetConfig()
+
+	var bundles []string
+	for _, provider := range []*ProviderConfig{config.Providers.OpenAI, config.Providers.HuggingFace, config.Providers.Cohere, config.Providers.Voyage, config.Providers.Anthropic} {
+		if provider != nil && provider.Enabled && provider.CABundle != "" {
+			bundles = append(bundles, provider.CABundle)
+		}
+	}
+	if len(bundles) == 0 {
+		result.Status, result.Detail = PreflightPass, "no CA bundles configured"
+		return result
+	}
+
+	now := time.Now()
+	var problems []string
+	count := 0
+	for _, bundle := range bundles {
+		certs, err := readCertificates(bundle)
+		if err != nil {
+			result.Status, result.Detail = PreflightFail, err.Error()
+			return result
+		}
+		for _, cert := range certs {
+			count++
+			switch {
+			case now.After(cert.NotAfter):
+				result.Status = PreflightFail
+				problems = append(problems, fmt.Sprintf("%s in %s expired on %s", cert.Subject.CommonName, bundle, cert.NotAfter.Format(time.DateOnly)))
+			case now.Before(cert.NotBefore):
+				result.Status = PreflightFail
+				problems = append(problems, fmt.Sprintf("%s in %s is not valid before %s", cert.Subject.CommonName, bundle, cert.NotBefore.Format(time.DateOnly)))
+			case cert.NotAfter.Sub(now) < certificateExpiryWarning:
+				if result.Status != PreflightFail {
+					result.Status = PreflightWarn
+				}
+				problems = append(problems, fmt.Sprintf("%s in %s expires on %s", cert.Subject.CommonName, bundle, cert.NotAfter.Format(time.DateOnly)))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		result.Detail = strings.Join(problems, "; ")
+		return result
+	}
+	result.Status, result.Detail = PreflightPass, fmt.Sprintf("%d certificates valid", count)
+	return result
+}
+
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return certs, nil
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// ProbeResult is the latest run of a synthetic probe
+type ProbeResult struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Expect    string    `json:"expect"`
+	Outcome   string    `json:"outcome"`
+	Status    int       `json:"status"`
+	Passed    bool      `json:"passed"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	RanAt     time.Time `json:"ran_at"`
+}
+
+var probeResults struct {
+	sync.Mutex
+	results map[string]ProbeResult
+}
+
+// GetSyntheticProbesSettings returns the synthetic probe settings with defaults applied
+func GetSyntheticProbesSettings() SyntheticProbes {
+	settings := SyntheticProbes{}
+	if probes := GetConfig().Settings.SyntheticProbes; probes != nil {
+		settings = *probes
+	}
+	if settings.Interval <= 0 {
+		settings.Interval = 300
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = 30
+	}
+	return settings
+}
+
+// RegisterProbeJob registers the job sending the synthetic probes through
+// handler, the router of the gateway, so they pass every middleware and rule
+func RegisterProbeJob(handler http.Handler) {
+	settings := GetSyntheticProbesSettings()
+	if !settings.Enabled {
+		return
+	}
+	RegisterJob(Job{
+		Name:     "synthetic_probes",
+		Interval: time.Duration(settings.Interval) * time.Second,
+		Run: func(ctx context.Context) error {
+			settings := GetSyntheticProbesSettings()
+			failed := 0
+			for _, probe := range settings.Probes {
+				result := RunProbe(ctx, handler, settings, probe)
+				RecordProbeResult(result)
+				if !result.Passed {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d probes failed", failed, len(settings.Probes))
+			}
+			return nil
+		},
+	})
+}
+
+// RunProbe sends a canned chat completion through handler and compares what
+// happened to it with what the probe expects: "allowed" or "blocked"
+func RunProbe(ctx context.Context, handler http.Handler, settings SyntheticProbes, probe SyntheticProbe) ProbeResult {
+	result := ProbeResult{Name: probe.Name, Model: probe.Model, Expect: probe.Expect, RanAt: time.Now().UTC()}
+	if result.Expect == "" {
+		result.Expect = VerdictAllowed
+	}
+
+	body, err := json.Marshal(goopenai.ChatCompletionRequest{
+		Model:    probe.Model,
+		Messages: []goopenai.ChatCompletionMessage{{Role: goopenai.ChatMessageRoleUser, Content: probe.Prompt}},
+	})
+	if err != nil {
+		result.Outcome, result.Error = VerdictError, err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(settings.Timeout)*time.Second)
+	defer cancel()
+	tenant := &RequestTenant{}
+	req := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(ctx, "requestTenant", tenant))
+	req.Header.Set("Authorization", "Bearer "+GetConfig().Secrets.ProbeAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(recorder, req)
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	result.Status = recorder.Code
+
+	switch {
+	case tenant.Blocked:
+		result.Outcome = VerdictBlocked
+	case recorder.Code == http.StatusOK:
+		result.Outcome = VerdictAllowed
+	default:
+		result.Outcome = VerdictError
+		result.Error = fmt.Sprintf("status %d: %s", recorder.Code, bytes.TrimSpace(recorder.Body.Bytes()))
+	}
+	result.Passed = result.Outcome == result.Expect
+	return result
+}
+
+// RecordProbeResult keeps the result of a probe and exports it as metrics
+func RecordProbeResult(result ProbeResult) {
+	probeResults.Lock()
+	if probeResults.results == nil {
+		probeResults.results = map[string]ProbeResult{}
+	}
+	probeResults.results[result.Name] = result
+	probeResults.Unlock()
+
+	tags := []string{"probe:" + result.Name, "model:" + result.Model, "outcome:" + result.Outcome}
+	passed := 0.0
+	if result.Passed {
+		passed = 1
+	}
+	SetGauge("probe.passed", passed, tags...)
+	RecordTiming("probe.latency", time.Duration(result.LatencyMs*float64(time.Millisecond)), tags...)
+}
+
+// ListProbeResults returns the latest result of every probe
+func ListProbeResults() []ProbeResult {
+	probeResults.Lock()
+	defer probeResults.Unlock()
+	results := make([]ProbeResult, 0, len(probeResults.results))
+	for _, result := range probeResults.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	CompressWhitespace = "whitespace"
+	CompressDedupe     = "dedupe"
+
+	// minDuplicateParagraph keeps short paragraphs like "Thanks!" from being deduplicated
+	minDuplicateParagraph = 40
+)
+
+var (
+	inlineWhitespace = regexp.MustCompile(`[ \t]{2,}`)
+	blankLines       = regexp.MustCompile(`\n{3,}`)
+)
+
+// CompressionStats reports the tokens prompt compression saved for a product
+type CompressionStats struct {
+	ProductID    uuid.UUID `json:"product_id"`
+	Requests     int       `json:"requests"`
+	TokensBefore int       `json:"tokens_before"`
+	TokensAfter  int       `json:"tokens_after"`
+	TokensSaved  int       `json:"tokens_saved"`
+}
+
+var compressionStats = struct {
+	sync.Mutex
+	products map[uuid.UUID]*CompressionStats
+}{products: map[uuid.UUID]*CompressionStats{}}
+
+// GetPromptCompressionSettings returns the prompt compression settings with defaults applied
+func GetPromptCompressionSettings() PromptCompression {
+	settings := PromptCompression{}
+	if compression := GetConfig().Settings.PromptCompression; compression != nil {
+		settings = *compression
+	}
+	if len(settings.Strategies) == 0 {
+		settings.Strategies = []string{CompressWhitespace, CompressDedupe}
+	}
+	return settings
+}
+
+// compressWhitespace collapses runs of spaces and blank lines. Indentation and
+// fenced code blocks are kept as they are.
+func compressWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	fenced := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(trimmed)]
+		lines[i] = indent + strings.TrimRight(inlineWhitespace.ReplaceAllString(trimmed, " "), " \t")
+	}
+	return blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
+
+// dedupeParagraphs drops paragraphs that already appeared earlier in the conversation
+func dedupeParagraphs(text string, seen map[string]bool) string {
+	paragraphs := strings.Split(text, "\n\n")
+	kept := paragraphs[:0]
+	for _, paragraph := range paragraphs {
+		key := strings.TrimSpace(paragraph)
+		if len(key) >= minDuplicateParagraph {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, paragraph)
+	}
+	// A message that only repeats earlier ones keeps its content, so it isn't empty
+	if len(kept) == 0 {
+		return text
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// CompressPrompt applies the compression strategies to the messages of a
+// request and returns the prompt tokens before and after
+func CompressPrompt(req *goopenai.ChatCompletionRequest, strategies []string) (int, int) {
+	before := CountPromptTokens(*req)
+	seen := map[string]bool{}
+	messages := make([]goopenai.ChatCompletionMessage, len(req.Messages))
+	for i, message := range req.Messages {
+		for _, strategy := range strategies {
+			switch strategy {
+			case CompressWhitespace:
+				message.Content = compressWhitespace(message.Content)
+			case CompressDedupe:
+				message.Content = dedupeParagraphs(message.Content, seen)
+			}
+		}
+		messages[i] = message
+	}
+	req.Messages = messages
+	return before, CountPromptTokens(*req)
+}
+
+// ApplyPromptCompression compresses the prompt of a request when compression is
+// enabled for its product and returns the tokens saved
+func ApplyPromptCompression(r *http.Request, req *goopenai.ChatCompletionRequest) int {
+	settings := GetPromptCompressionSettings()
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	if !settings.Enabled || !ProductListed(settings.Products, productID) {
+		return 0
+	}
+
+	before, after := CompressPrompt(req, settings.Strategies)
+
+	compressionStats.Lock()
+	defer compressionStats.Unlock()
+	stats, ok := compressionStats.products[productID]
+	if !ok {
+		stats = &CompressionStats{ProductID: productID}
+		compressionStats.products[productID] = stats
+	}
+	stats.Requests++
+	stats.TokensBefore += before
+	stats.TokensAfter += after
+	stats.TokensSaved += before - after
+	return before - after
+}
+
+// CompressionReport returns the prompt compression stats per product since the start of the process
+func CompressionReport() []CompressionStats {
+	compressionStats.Lock()
+	defer compressionStats.Unlock()
+
+	report := make([]CompressionStats, 0, len(compressionStats.products))
+	for _, stats := range compressionStats.products {
+		report = append(report, *stats)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].TokensSaved > report[j].TokensSaved })
+	return report
+}
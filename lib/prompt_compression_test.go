@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressWhitespace(t *testing.T) {
+	text := "Hello    there,  \n\n\n\n  indented   line\n```\nx  =  1\n```"
+	assert.Equal(t, "Hello there,\n\n  indented line\n```\nx  =  1\n```", compressWhitespace(text))
+}
+
+func TestCompressPrompt(t *testing.T) {
+	document := "The quarterly report covers revenue, churn and the hiring plan for next year."
+	req := goopenai.ChatCompletionRequest{
+		Model: "gpt-4o",
+		Messages: []goopenai.ChatCompletionMessage{
+			{Role: goopenai.ChatMessageRoleUser, Content: document + "\n\nSummarize it."},
+			{Role: goopenai.ChatMessageRoleAssistant, Content: "Done."},
+			{Role: goopenai.ChatMessageRoleUser, Content: document + "\n\nNow   list    the risks."},
+			{Role: goopenai.ChatMessageRoleUser, Content: document},
+		},
+	}
+
+	before, after := CompressPrompt(&req, []string{CompressWhitespace, CompressDedupe})
+	assert.Less(t, after, before)
+	assert.Equal(t, "Now list the risks.", req.Messages[2].Content)
+	assert.Equal(t, document, req.Messages[3].Content)
+	assert.True(t, strings.HasPrefix(req.Messages[0].Content, document))
+	assert.Equal(t, after, CountPromptTokens(req))
+}
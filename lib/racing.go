@@ -0,0 +1,21 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RaceRouteFor returns the enabled race route of a model for the product of the request
+func RaceRouteFor(r *http.Request, model string) (RaceRoute, bool) {
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	for _, route := range GetConfig().Settings.Racing {
+		if route.Enabled && route.Model == model && ProductListed(route.Products, productID) {
+			if route.AlternateModel == "" {
+				route.AlternateModel = route.Model
+			}
+			return route, true
+		}
+	}
+	return RaceRoute{}, false
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRaceRouteFor(t *testing.T) {
+	defer func(racing []RaceRoute) { AppConfig.Settings.Racing = racing }(AppConfig.Settings.Racing)
+	product := uuid.New()
+	AppConfig.Settings.Racing = []RaceRoute{
+		{Enabled: false, Model: "gpt-4o", BaseURL: "https://disabled.example.com/v1"},
+		{Enabled: true, Model: "gpt-4o", BaseURL: "https://alternate.example.com/v1", Products: []string{product.String()}},
+		{Enabled: true, Model: "gpt-4o-mini", BaseURL: "https://mini.example.com/v1", AlternateModel: "gpt-4o-mini-fast"},
+	}
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	_, ok := RaceRouteFor(r, "gpt-4o")
+	assert.False(t, ok)
+
+	route, ok := RaceRouteFor(r, "gpt-4o-mini")
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o-mini-fast", route.AlternateModel)
+
+	r = r.WithContext(context.WithValue(r.Context(), "productId", product))
+	route, ok = RaceRouteFor(r, "gpt-4o")
+	assert.True(t, ok)
+	assert.Equal(t, "https://alternate.example.com/v1", route.BaseURL)
+	assert.Equal(t, "gpt-4o", route.AlternateModel)
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+// RAGDocument is a retrieved document a client injected into a prompt
+type RAGDocument struct {
+	ID         string `json:"id"`
+	Collection string `json:"collection"`
+	SHA256     string `json:"sha256,omitempty"`
+	Bytes      int    `json:"bytes"`
+	// MessageIndex is the message the document was found in, -1 for the request field
+	MessageIndex int `json:"message_index"`
+}
+
+var attributePattern = regexp.MustCompile(`([\w-]+)\s*=\s*"([^"]*)"`)
+
+// GetRAGContextSettings returns the RAG context settings with defaults applied
+func GetRAGContextSettings() RAGContext {
+	settings := RAGContext{}
+	if rag := GetConfig().Settings.RAGContext; rag != nil {
+		settings = *rag
+	}
+	if settings.Tag == "" {
+		settings.Tag = "document"
+	}
+	return settings
+}
+
+// ExtractRAGDocuments finds the retrieved documents of a chat completion body
+func ExtractRAGDocuments(body []byte) []RAGDocument {
+	settings := GetRAGContextSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil
+	}
+
+	var documents []RAGDocument
+	var messages []struct {
+		Content json.RawMessage `json:"content"`
+	}
+	json.Unmarshal(request["messages"], &messages)
+	pattern := regexp.MustCompile(`(?s)<` + regexp.QuoteMeta(settings.Tag) + `\b([^>]*)>(.*?)</` + regexp.QuoteMeta(settings.Tag) + `>`)
+	for i, message := range messages {
+		for _, text := range messageTexts(message.Content) {
+			for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+				document := NewRAGDocument("", "", match[2], i)
+				for _, attribute := range attributePattern.FindAllStringSubmatch(match[1], -1) {
+					switch attribute[1] {
+					case "id":
+						document.ID = attribute[2]
+					case "collection":
+						document.Collection = attribute[2]
+					}
+				}
+				documents = append(documents, document)
+			}
+		}
+	}
+
+	if settings.Field != "" {
+		var listed []struct {
+			ID         string `json:"id"`
+			Collection string `json:"collection"`
+			Content    string `json:"content"`
+		}
+		json.Unmarshal(request[settings.Field], &listed)
+		for _, entry := range listed {
+			documents = append(documents, NewRAGDocument(entry.ID, entry.Collection, entry.Content, -1))
+		}
+	}
+	return documents
+}
+
+// NewRAGDocument describes a document by its ID, collection and the hash of its content
+func NewRAGDocument(id string, collection string, content string, messageIndex int) RAGDocument {
+	document := RAGDocument{ID: id, Collection: collection, Bytes: len(content), MessageIndex: messageIndex}
+	if content != "" {
+		sum := sha256.Sum256([]byte(content))
+		document.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return document
+}
+
+// messageTexts returns the text of a message content, a string or a list of parts
+func messageTexts(content json.RawMessage) []string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return []string{text}
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return texts
+}
+
+// WithRAGDocuments stores the retrieved documents of the request for the rules
+func WithRAGDocuments(r *http.Request, documents []RAGDocument) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "ragDocuments", documents))
+}
+
+// RAGDocuments returns the retrieved documents of the request
+func RAGDocuments(r *http.Request) []RAGDocument {
+	documents, _ := r.Context().Value("ragDocuments").([]RAGDocument)
+	return documents
+}
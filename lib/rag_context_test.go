@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractRAGDocuments(t *testing.T) {
+	defer func(rag *RAGContext) { AppConfig.Settings.RAGContext = rag }(AppConfig.Settings.RAGContext)
+	AppConfig.Settings.RAGContext = &RAGContext{Enabled: true, Field: "documents"}
+
+	body := []byte(`{
+		"model": "gpt-4",
+		"messages": [
+			{"role": "system", "content": "Answer from the context."},
+			{"role": "user", "content": [{"type": "text", "text": "<document id=\"kb-1\" collection=\"support-kb\">Reset the router.</document> How do I fix it?"}]}
+		],
+		"documents": [{"id": "faq-7", "collection": "public-docs", "content": "Call support."}]
+	}`)
+
+	documents := ExtractRAGDocuments(body)
+	assert.Len(t, documents, 2)
+	assert.Equal(t, "kb-1", documents[0].ID)
+	assert.Equal(t, "support-kb", documents[0].Collection)
+	assert.Equal(t, 1, documents[0].MessageIndex)
+	assert.Equal(t, len("Reset the router."), documents[0].Bytes)
+	assert.Len(t, documents[0].SHA256, 64)
+	assert.Equal(t, "faq-7", documents[1].ID)
+	assert.Equal(t, -1, documents[1].MessageIndex)
+
+	AppConfig.Settings.RAGContext.Enabled = false
+	assert.Empty(t, ExtractRAGDocuments(body))
+}
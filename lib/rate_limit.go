@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// rateLimitIdentityTTL is how long the tenant of an API key is cached for the
+// rate limits. Changes made through the admin API drop the key from the cache
+// of every replica right away.
+const rateLimitIdentityTTL = 30 * time.Second
+
+const rateLimitIdentityChannel = "openshield:rate_limit_identities"
+
+// rateLimitMissTTL is how long a token without an active API key is
+// remembered, so invalid tokens don't each cost a database lookup. A key
+// created in the meantime is counted per IP until then.
+const rateLimitMissTTL = 5 * time.Second
+
+// maxRateLimitMisses bounds the remembered tokens, random ones can't grow the
+// cache without limit
+const maxRateLimitMisses = 10000
+
+// rateLimitIdentity is the tenant and the tier of an API key
+type rateLimitIdentity struct {
+	apiKeyID    uuid.UUID
+	productID   uuid.UUID
+	workspaceID uuid.UUID
+	tier        string
+	expiresAt   time.Time
+}
+
+var rateLimitIdentities sync.Map
+
+// rateLimitMisses holds the expiry of the cache keys no active API key matched
+var rateLimitMisses = struct {
+	sync.Mutex
+	entries map[string]time.Time
+}{entries: map[string]time.Time{}}
+
+// rateLimitMissed reports whether no active API key matched key a moment ago
+func rateLimitMissed(key string) bool {
+	rateLimitMisses.Lock()
+	defer rateLimitMisses.Unlock()
+	expiresAt, ok := rateLimitMisses.entries[key]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// rememberRateLimitMiss remembers that no active API key matched key. When
+// the cache is full the expired entries are dropped, or all of them if none is.
+func rememberRateLimitMiss(key string) {
+	rateLimitMisses.Lock()
+	defer rateLimitMisses.Unlock()
+	now := time.Now()
+	if len(rateLimitMisses.entries) >= maxRateLimitMisses {
+		for missed, expiresAt := range rateLimitMisses.entries {
+			if !now.Before(expiresAt) {
+				delete(rateLimitMisses.entries, missed)
+			}
+		}
+		if len(rateLimitMisses.entries) >= maxRateLimitMisses {
+			rateLimitMisses.entries = map[string]time.Time{}
+		}
+	}
+	rateLimitMisses.entries[key] = now.Add(rateLimitMissTTL)
+}
+
+// loadRateLimitIdentity returns the tenant and tier of the API key of r, false
+// if r has no active key. The key is the authenticated one of the request
+// context, the bearer token, cached by its hash and not its value, or the key
+// the workload identity of r maps to.
+func loadRateLimitIdentity(r *http.Request) (rateLimitIdentity, bool) {
+	if id, ok := r.Context().Value("apiKeyId").(uuid.UUID); ok && id != uuid.Nil {
+		return cachedRateLimitIdentity("id:"+id.String(), &models.ApiKeys{Base: models.Base{Id: id}, Status: models.Active})
+	}
+	if token, err := bearerToken(r); err == nil {
+		return cachedRateLimitIdentity(hashKey(token), &models.ApiKeys{ApiKey: token, Status: models.Active})
+	}
+	if settings := GetWorkloadIdentitySettings(); settings.Enabled {
+		if spiffeID, ok := requestSpiffeID(r, settings); ok {
+			if id, ok := workloadApiKeyID(settings, spiffeID); ok {
+				return cachedRateLimitIdentity("id:"+id.String(), &models.ApiKeys{Base: models.Base{Id: id}, Status: models.Active})
+			}
+		}
+	}
+	return rateLimitIdentity{}, false
+}
+
+// cachedRateLimitIdentity returns the identity cached under key, or loads the
+// API key matching query and caches it. Keys no active API key matches are
+// cached for a moment too.
+func cachedRateLimitIdentity(key string, query *models.ApiKeys) (rateLimitIdentity, bool) {
+	if cached, ok := rateLimitIdentities.Load(key); ok && time.Now().Before(cached.(rateLimitIdentity).expiresAt) {
+		return cached.(rateLimitIdentity), true
+	}
+	if rateLimitMissed(key) {
+		return rateLimitIdentity{}, false
+	}
+
+	var apiKey models.ApiKeys
+	if err := DB().Select("id", "product_id", "rate_limit_tier").Where(query).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			rememberRateLimitMiss(key)
+		}
+		return rateLimitIdentity{}, false
+	}
+	profile, err := loadProductProfile(apiKey.ProductID)
+	if err != nil {
+		return rateLimitIdentity{}, false
+	}
+	identity := rateLimitIdentity{
+		apiKeyID:    apiKey.Id,
+		productID:   apiKey.ProductID,
+		workspaceID: profile.workspaceID,
+		tier:        strings.ToLower(apiKey.RateLimitTier),
+		expiresAt:   time.Now().Add(rateLimitIdentityTTL),
+	}
+	rateLimitIdentities.Store(key, identity)
+	return identity, true
+}
+
+// forgetRateLimitIdentity drops the cached identities of an API key on this replica
+func forgetRateLimitIdentity(apiKeyID uuid.UUID) {
+	rateLimitIdentities.Range(func(key, value interface{}) bool {
+		if value.(rateLimitIdentity).apiKeyID == apiKeyID {
+			rateLimitIdentities.Delete(key)
+		}
+		return true
+	})
+}
+
+// ForgetRateLimitIdentity drops the cached tenant and tier of an API key that
+// changed or was deleted, on every replica, so its new limit applies right away
+func ForgetRateLimitIdentity(ctx context.Context, apiKeyID uuid.UUID) {
+	forgetRateLimitIdentity(apiKeyID)
+	if !RedisConfigured() {
+		return
+	}
+	if err := Publish(ctx, rateLimitIdentityChannel, apiKeyID.String()); err != nil {
+		log.Printf("Error publishing rate limit identity change: %v", err)
+	}
+}
+
+// WatchRateLimitIdentities drops the API keys other replicas changed from the
+// cache until ctx is done
+func WatchRateLimitIdentities(ctx context.Context) {
+	Subscribe(ctx, rateLimitIdentityChannel, func(payload string) {
+		if id, err := uuid.Parse(payload); err == nil {
+			forgetRateLimitIdentity(id)
+		}
+	})
+}
+
+// rateLimitKey returns the key the requests of identity are counted under for keyBy
+func rateLimitKey(identity rateLimitIdentity, keyBy string) (string, bool) {
+	switch keyBy {
+	case "api_key":
+		return "api_key:" + identity.apiKeyID.String(), true
+	case "product":
+		return "product:" + identity.productID.String(), true
+	case "workspace":
+		if identity.workspaceID == uuid.Nil {
+			return "", false
+		}
+		return "workspace:" + identity.workspaceID.String(), true
+	}
+	return "", false
+}
+
+// RateLimitKey is the httprate key function of the route rate limits. With
+// key_by set to api_key, product or workspace, requests are counted per
+// tenant of their API key, and requests without a valid key per client IP.
+func RateLimitKey(r *http.Request) (string, error) {
+	rateLimit := GetConfig().Settings.RateLimit
+	if rateLimit != nil && rateLimit.KeyBy != "" && rateLimit.KeyBy != "ip" {
+		if identity, ok := loadRateLimitIdentity(r); ok {
+			if key, ok := rateLimitKey(identity, rateLimit.KeyBy); ok {
+				return key, nil
+			}
+		}
+	}
+	ip, err := httprate.KeyByIP(r)
+	return "ip:" + ip, err
+}
+
+// rateLimitTierLimit returns the limit of tier, false if it has none and the
+// limit of the route applies
+func rateLimitTierLimit(rateLimit *RateLimiting, tier string) (int, bool) {
+	if rateLimit == nil || tier == "" {
+		return 0, false
+	}
+	// Viper lowercases map keys
+	limit, ok := rateLimit.Tiers[tier]
+	return limit, ok && limit > 0
+}
+
+// RateLimitTierMiddleware applies the limit of the tier of the API key of a
+// request to the httprate limiter after it
+func RateLimitTierMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rateLimit := GetConfig().Settings.RateLimit
+		if rateLimit == nil || len(rateLimit.Tiers) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if identity, ok := loadRateLimitIdentity(r); ok {
+			if limit, ok := rateLimitTierLimit(rateLimit, identity.tier); ok {
+				r = r.WithContext(httprate.WithRequestLimit(r.Context(), limit))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitTierExists reports whether tier is a tier of the rate limiting settings
+func RateLimitTierExists(tier string) bool {
+	rateLimit := GetConfig().Settings.RateLimit
+	if rateLimit == nil {
+		return false
+	}
+	_, ok := rateLimit.Tiers[strings.ToLower(tier)]
+	return ok
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	identity := rateLimitIdentity{apiKeyID: uuid.New(), productID: uuid.New()}
+
+	key, ok := rateLimitKey(identity, "api_key")
+	assert.True(t, ok)
+	assert.Equal(t, "api_key:"+identity.apiKeyID.String(), key)
+	key, ok = rateLimitKey(identity, "product")
+	assert.True(t, ok)
+	assert.Equal(t, "product:"+identity.productID.String(), key)
+	// Products without a workspace fall back to the client IP
+	_, ok = rateLimitKey(identity, "workspace")
+	assert.False(t, ok)
+	_, ok = rateLimitKey(identity, "ip")
+	assert.False(t, ok)
+
+	defer func(rateLimit *RateLimiting) { AppConfig.Settings.RateLimit = rateLimit }(AppConfig.Settings.RateLimit)
+	AppConfig.Settings.RateLimit = &RateLimiting{KeyBy: "ip"}
+	r := httptest.NewRequest("POST", "/openai/v1/embeddings", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	key, err := RateLimitKey(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "ip:10.0.0.1", key)
+}
+
+func TestRateLimitTierLimit(t *testing.T) {
+	rateLimit := &RateLimiting{Max: 100, Tiers: map[string]int{"premium": 1000, "paused": 0}}
+
+	limit, ok := rateLimitTierLimit(rateLimit, "premium")
+	assert.True(t, ok)
+	assert.Equal(t, 1000, limit)
+	_, ok = rateLimitTierLimit(rateLimit, "unknown")
+	assert.False(t, ok)
+	_, ok = rateLimitTierLimit(rateLimit, "")
+	assert.False(t, ok)
+	_, ok = rateLimitTierLimit(rateLimit, "paused")
+	assert.False(t, ok)
+}
+
+func TestRateLimitIdentityOfWorkload(t *testing.T) {
+	defer func(rateLimit *RateLimiting, identity *WorkloadIdentity, redis *RedisConfig) {
+		AppConfig.Settings.RateLimit, AppConfig.Settings.WorkloadIdentity, AppConfig.Settings.Redis = rateLimit, identity, redis
+	}(AppConfig.Settings.RateLimit, AppConfig.Settings.WorkloadIdentity, AppConfig.Settings.Redis)
+	apiKeyID := uuid.New()
+	AppConfig.Settings.RateLimit = &RateLimiting{KeyBy: "api_key"}
+	AppConfig.Settings.Redis = nil
+	AppConfig.Settings.WorkloadIdentity = &WorkloadIdentity{
+		Enabled:        true,
+		Header:         "X-Spiffe-Id",
+		Identities:     []WorkloadIdentityKey{{SpiffeID: "spiffe://cluster.local/ns/billing/sa/*", ApiKeyID: apiKeyID.String()}},
+		TrustedProxies: []string{"192.0.2.0/24"},
+	}
+	rateLimitIdentities.Store("id:"+apiKeyID.String(), rateLimitIdentity{apiKeyID: apiKeyID, expiresAt: time.Now().Add(time.Minute)})
+
+	r := httptest.NewRequest("POST", "/openai/v1/embeddings", nil)
+	r.Header.Set("X-Spiffe-Id", "spiffe://cluster.local/ns/billing/sa/worker")
+	key, err := RateLimitKey(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "api_key:"+apiKeyID.String(), key)
+
+	// A peer outside the trusted proxies can't take the limit of the identity
+	r = httptest.NewRequest("POST", "/openai/v1/embeddings", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	r.Header.Set("X-Spiffe-Id", "spiffe://cluster.local/ns/billing/sa/worker")
+	key, err = RateLimitKey(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "ip:203.0.113.7", key)
+
+	// An authenticated request is counted under the key of its context
+	r = httptest.NewRequest("POST", "/openai/v1/embeddings", nil)
+	key, err = RateLimitKey(r.WithContext(context.WithValue(r.Context(), "apiKeyId", apiKeyID)))
+	assert.NoError(t, err)
+	assert.Equal(t, "api_key:"+apiKeyID.String(), key)
+
+	ForgetRateLimitIdentity(context.Background(), apiKeyID)
+	_, ok := rateLimitIdentities.Load("id:" + apiKeyID.String())
+	assert.False(t, ok)
+}
+
+func TestRateLimitMisses(t *testing.T) {
+	defer func() { rateLimitMisses.entries = map[string]time.Time{} }()
+
+	// A remembered miss is answered without a database lookup
+	assert.False(t, rateLimitMissed("invalid"))
+	rememberRateLimitMiss("invalid")
+	assert.True(t, rateLimitMissed("invalid"))
+	identity, ok := cachedRateLimitIdentity("invalid", nil)
+	assert.False(t, ok)
+	assert.Equal(t, rateLimitIdentity{}, identity)
+
+	rateLimitMisses.entries["expired"] = time.Now().Add(-time.Second)
+	assert.False(t, rateLimitMissed("expired"))
+
+	// A full cache drops its expired entries first, then everything
+	for i := len(rateLimitMisses.entries); i < maxRateLimitMisses; i++ {
+		rateLimitMisses.entries[uuid.NewString()] = time.Now().Add(time.Minute)
+	}
+	rememberRateLimitMiss("next")
+	assert.Len(t, rateLimitMisses.entries, maxRateLimitMisses)
+	assert.NotContains(t, rateLimitMisses.entries, "expired")
+	rememberRateLimitMiss("last")
+	assert.Len(t, rateLimitMisses.entries, 1)
+	assert.True(t, rateLimitMissed("last"))
+}
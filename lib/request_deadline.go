@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestTimeoutHeader carries the deadline of the client, in seconds or as a
+// duration like 1500ms
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// grpcTimeoutHeader carries the deadline of gRPC clients through gRPC-Web and
+// transcoding proxies
+const grpcTimeoutHeader = "Grpc-Timeout"
+
+// grpcTimeoutUnits are the units of grpc-timeout values
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// clientTimeout returns the timeout the client of r set, false if it set none
+func clientTimeout(r *http.Request) (time.Duration, bool) {
+	if value := strings.TrimSpace(r.Header.Get(RequestTimeoutHeader)); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			timeout := time.Duration(seconds * float64(time.Second))
+			return timeout, timeout > 0
+		}
+		timeout, err := time.ParseDuration(value)
+		return timeout, err == nil && timeout > 0
+	}
+	if value := strings.TrimSpace(r.Header.Get(grpcTimeoutHeader)); len(value) >= 2 {
+		unit, ok := grpcTimeoutUnits[value[len(value)-1]]
+		amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if !ok || err != nil || amount <= 0 {
+			return 0, false
+		}
+		return time.Duration(amount) * unit, true
+	}
+	return 0, false
+}
+
+// RequestDeadlineMiddleware cancels the context of a request at the deadline
+// of its client, so provider calls made with it time out with the client
+// instead of running on for a response nobody reads. The context of a request
+// is also cancelled when the client disconnects. Requests past their deadline
+// are answered 504 if the handler didn't answer.
+func RequestDeadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := clientTimeout(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			countCancelled(r.Context())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			cancel()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.Context().Err() == nil {
+				IncrCounter("requests.deadline_exceeded", 1)
+				if ww.Status() == 0 {
+					ww.WriteHeader(http.StatusGatewayTimeout)
+				}
+			}
+		}()
+		next.ServeHTTP(ww, r.WithContext(ctx))
+		countCancelled(r.Context())
+	})
+}
+
+// countCancelled counts a request whose client disconnected before it was answered
+func countCancelled(ctx context.Context) {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		IncrCounter("requests.client_disconnected", 1)
+	}
+}
+
+// DeadlineStatus returns 504 for the errors of a request past its deadline,
+// status for other errors
+func DeadlineStatus(r *http.Request, status int) int {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return status
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTimeout(t *testing.T) {
+	cases := map[string]struct {
+		header  string
+		value   string
+		timeout time.Duration
+		ok      bool
+	}{
+		"seconds":       {RequestTimeoutHeader, "2.5", 2500 * time.Millisecond, true},
+		"duration":      {RequestTimeoutHeader, "1500ms", 1500 * time.Millisecond, true},
+		"zero":          {RequestTimeoutHeader, "0", 0, false},
+		"invalid":       {RequestTimeoutHeader, "soon", 0, false},
+		"grpc":          {"grpc-timeout", "300m", 300 * time.Millisecond, true},
+		"grpc seconds":  {"grpc-timeout", "10S", 10 * time.Second, true},
+		"grpc bad unit": {"grpc-timeout", "10x", 0, false},
+	}
+	for name, c := range cases {
+		r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+		r.Header.Set(c.header, c.value)
+		timeout, ok := clientTimeout(r)
+		assert.Equal(t, c.ok, ok, name)
+		assert.Equal(t, c.timeout, timeout, name)
+	}
+}
+
+func TestRequestDeadlineMiddleware(t *testing.T) {
+	handler := RequestDeadlineMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(20*time.Millisecond), deadline, 10*time.Millisecond)
+		<-r.Context().Done()
+	}))
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	r.Header.Set(RequestTimeoutHeader, "20ms")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestRequestDeadlineMiddlewareAnswered(t *testing.T) {
+	// A handler that answered before the deadline passed keeps its answer
+	handler := RequestDeadlineMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream failed"))
+		<-r.Context().Done()
+	}))
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	r.Header.Set(RequestTimeoutHeader, "20ms")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "upstream failed", w.Body.String())
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const requestDiffRedisPrefix = "openshield:request_diff:"
+
+// maxLocalRequestDiffs bounds the diffs kept without Redis
+const maxLocalRequestDiffs = 1000
+
+// Transformation is a change the gateway made to a request before sending it
+type Transformation struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// RequestDiff holds the payload a client sent, the payload the gateway sent
+// upstream and the transformations between them
+type RequestDiff struct {
+	RequestID       string           `json:"request_id"`
+	Route           string           `json:"route"`
+	WorkspaceID     uuid.UUID        `json:"workspace_id"`
+	Original        json.RawMessage  `json:"original"`
+	Upstream        json.RawMessage  `json:"upstream"`
+	Transformations []Transformation `json:"transformations"`
+	CreatedAt       time.Time        `json:"created_at"`
+
+	mu sync.Mutex
+}
+
+type localRequestDiff struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// requestDiffs holds the diffs when Redis isn't configured
+var requestDiffs = struct {
+	sync.Mutex
+	entries map[string]localRequestDiff
+}{entries: map[string]localRequestDiff{}}
+
+// GetRequestDiffSettings returns the request diff settings with defaults applied
+func GetRequestDiffSettings() RequestDiffs {
+	settings := RequestDiffs{}
+	if diffs := GetConfig().Settings.RequestDiffs; diffs != nil {
+		settings = *diffs
+	}
+	if settings.TTL <= 0 {
+		settings.TTL = 3600
+	}
+	return settings
+}
+
+// StartRequestDiff starts recording the diff of a request with the body the
+// client sent, when request diffs are enabled
+func StartRequestDiff(r *http.Request, body []byte) *http.Request {
+	requestID := middleware.GetReqID(r.Context())
+	if !GetRequestDiffSettings().Enabled || requestID == "" {
+		return r
+	}
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+	diff := &RequestDiff{
+		RequestID:       requestID,
+		Route:           CanonicalPath(r.URL.Path),
+		WorkspaceID:     workspaceID,
+		Original:        jsonPayload(body),
+		Transformations: []Transformation{},
+		CreatedAt:       time.Now().UTC(),
+	}
+	return r.WithContext(context.WithValue(r.Context(), "requestDiff", diff))
+}
+
+// RecordTransformation adds a change of the gateway to the diff of a request
+func RecordTransformation(r *http.Request, transformationType string, detail string) {
+	diff, ok := r.Context().Value("requestDiff").(*RequestDiff)
+	if !ok {
+		return
+	}
+	diff.mu.Lock()
+	defer diff.mu.Unlock()
+	diff.Transformations = append(diff.Transformations, Transformation{Type: transformationType, Detail: detail})
+}
+
+// SaveRequestDiff stores the diff of a request with the payload sent upstream for the ttl setting
+func SaveRequestDiff(r *http.Request, upstream []byte) {
+	diff, ok := r.Context().Value("requestDiff").(*RequestDiff)
+	if !ok {
+		return
+	}
+	diff.mu.Lock()
+	diff.Upstream = jsonPayload(upstream)
+	value, err := json.Marshal(diff)
+	diff.mu.Unlock()
+	if err != nil {
+		log.Printf("Error encoding request diff: %v", err)
+		return
+	}
+
+	key := requestDiffRedisPrefix + diff.RequestID
+	ttl := time.Duration(GetRequestDiffSettings().TTL) * time.Second
+	if RedisConfigured() {
+		if err := RedisClient().Set(r.Context(), key, value, ttl).Err(); err != nil {
+			log.Printf("Error storing request diff: %v", err)
+		}
+		return
+	}
+
+	requestDiffs.Lock()
+	defer requestDiffs.Unlock()
+	if len(requestDiffs.entries) >= maxLocalRequestDiffs {
+		now := time.Now()
+		for k, entry := range requestDiffs.entries {
+			if now.After(entry.expiresAt) {
+				delete(requestDiffs.entries, k)
+			}
+		}
+		if len(requestDiffs.entries) >= maxLocalRequestDiffs {
+			return
+		}
+	}
+	requestDiffs.entries[key] = localRequestDiff{value: value, expiresAt: time.Now().Add(ttl)}
+}
+
+// GetRequestDiff returns the stored diff of a request
+func GetRequestDiff(ctx context.Context, requestID string) (*RequestDiff, bool, error) {
+	key := requestDiffRedisPrefix + requestID
+	var value []byte
+	if RedisConfigured() {
+		var err error
+		value, err = RedisClient().Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+	} else {
+		requestDiffs.Lock()
+		entry, ok := requestDiffs.entries[key]
+		requestDiffs.Unlock()
+		if !ok || time.Now().After(entry.expiresAt) {
+			return nil, false, nil
+		}
+		value = entry.value
+	}
+
+	var diff RequestDiff
+	if err := json.Unmarshal(value, &diff); err != nil {
+		return nil, false, err
+	}
+	return &diff, true, nil
+}
+
+// jsonPayload keeps a JSON body as it is, and anything else as a JSON string
+func jsonPayload(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	encoded, _ := json.Marshal(string(body))
+	return encoded
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDiff(t *testing.T) {
+	defer func(settings *RequestDiffs, redis *RedisConfig) {
+		AppConfig.Settings.RequestDiffs, AppConfig.Settings.Redis = settings, redis
+	}(AppConfig.Settings.RequestDiffs, AppConfig.Settings.Redis)
+	AppConfig.Settings.Redis = nil
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "diff-test-1"))
+
+	// Nothing is kept while request diffs are disabled
+	AppConfig.Settings.RequestDiffs = &RequestDiffs{Enabled: false}
+	disabled := StartRequestDiff(req, []byte(`{"model":"gpt-4o"}`))
+	RecordTransformation(disabled, "model_downgrade", "gpt-4o to gpt-4o-mini")
+	SaveRequestDiff(disabled, []byte(`{"model":"gpt-4o-mini"}`))
+	_, found, err := GetRequestDiff(context.Background(), "diff-test-1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	AppConfig.Settings.RequestDiffs = &RequestDiffs{Enabled: true}
+	enabled := StartRequestDiff(req, []byte(`{"model":"gpt-4o"}`))
+	RecordTransformation(enabled, "model_downgrade", "gpt-4o to gpt-4o-mini")
+	SaveRequestDiff(enabled, []byte(`{"model":"gpt-4o-mini"}`))
+
+	diff, found, err := GetRequestDiff(context.Background(), "diff-test-1")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "/openai/v1/chat/completions", diff.Route)
+	assert.JSONEq(t, `{"model":"gpt-4o"}`, string(diff.Original))
+	assert.JSONEq(t, `{"model":"gpt-4o-mini"}`, string(diff.Upstream))
+	assert.Equal(t, []Transformation{{Type: "model_downgrade", Detail: "gpt-4o to gpt-4o-mini"}}, diff.Transformations)
+
+	_, found, _ = GetRequestDiff(context.Background(), "unknown")
+	assert.False(t, found)
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ApplyRequestPolicies applies the model approval and deprecation, session risk,
+// schedules and key burn-in of the API key to a request for model, whatever API
+// it came in. A refused request is answered with the returned status in the
+// error format of its API.
+func ApplyRequestPolicies(w http.ResponseWriter, r *http.Request, model string) (*http.Request, int, error) {
+	SetRequestModel(r, model)
+	if !ModelAllowed(r, model) {
+		return r, http.StatusForbidden, fmt.Errorf("%w: %s", ErrModelNotApproved, model)
+	}
+	r, err := CheckModelDeprecation(w, r, model)
+	if err != nil {
+		return r, http.StatusGone, err
+	}
+
+	if r, err = ApplySessionRisk(r); err != nil {
+		return r, http.StatusForbidden, err
+	}
+	if r, err = ApplySchedules(r); err != nil {
+		return r, http.StatusTooManyRequests, err
+	}
+	if r, err = ApplyKeyBurnIn(r); err != nil {
+		return r, http.StatusTooManyRequests, err
+	}
+	return r, http.StatusOK, nil
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openshieldai/openshield/models"
+)
+
+// RerankTarget is the provider a rerank request is sent to
+type RerankTarget struct {
+	Provider string
+	Config   *ProviderConfig
+	APIKey   string
+	URL      string
+}
+
+// rerankEndpoints are the default base URLs and rerank paths of the providers
+var rerankEndpoints = map[string]struct{ baseURL, path string }{
+	"cohere": {"https://api.cohere.com", "/v2/rerank"},
+	"voyage": {"https://api.voyageai.com", "/v1/rerank"},
+}
+
+// GetRerankSettings returns the rerank settings with defaults applied
+func GetRerankSettings() Rerank {
+	settings := Rerank{}
+	if rerank := GetConfig().Settings.Rerank; rerank != nil {
+		settings = *rerank
+	}
+	if settings.Provider == "" {
+		settings.Provider = "cohere"
+	}
+	return settings
+}
+
+// RerankProvider returns the enabled provider that reranks with a model
+func RerankProvider(model string) (RerankTarget, error) {
+	settings := GetRerankSettings()
+	provider := settings.Provider
+	if p, ok := settings.Models[model]; ok {
+		provider = p
+	}
+
+	config := GetConfig()
+	target := RerankTarget{Provider: provider}
+	switch provider {
+	case "cohere":
+		target.Config, target.APIKey = config.Providers.Cohere, config.Secrets.CohereAPIKey
+	case "voyage":
+		target.Config, target.APIKey = config.Providers.Voyage, config.Secrets.VoyageAPIKey
+	default:
+		return target, fmt.Errorf("unknown rerank provider %q for model %s", provider, model)
+	}
+	if target.Config == nil || !target.Config.Enabled {
+		return target, fmt.Errorf("rerank provider %s of model %s is not enabled", provider, model)
+	}
+
+	endpoint := rerankEndpoints[provider]
+	baseURL := endpoint.baseURL
+	if target.Config.BaseURL != "" {
+		baseURL = target.Config.BaseURL
+	}
+	target.URL = strings.TrimSuffix(baseURL, "/") + endpoint.path
+	return target, nil
+}
+
+// RerankUsage records the usage of a rerank request, counted by the documents
+// ranked as the providers bill them. Providers reporting tokens also give
+// totalTokens.
+func RerankUsage(ctx context.Context, modelName string, documents int, totalTokens int) {
+	IncrCounter("rerank.documents", int64(documents), "model:"+modelName)
+	recordUsage(ctx, models.OpenAI, modelName, models.Usage{
+		PromptTokensCount: totalTokens,
+		TotalTokens:       totalTokens,
+		RequestType:       "rerank",
+		DocumentsCount:    documents,
+	})
+}
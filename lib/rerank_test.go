@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRerankProvider(t *testing.T) {
+	providers, secrets, settings := AppConfig.Providers, AppConfig.Secrets, AppConfig.Settings.Rerank
+	defer func() {
+		AppConfig.Providers, AppConfig.Secrets, AppConfig.Settings.Rerank = providers, secrets, settings
+	}()
+	AppConfig.Providers.Cohere = &ProviderConfig{Enabled: true}
+	AppConfig.Providers.Voyage = &ProviderConfig{Enabled: true, BaseURL: "http://voyage.internal/"}
+	AppConfig.Secrets.CohereAPIKey, AppConfig.Secrets.VoyageAPIKey = "cohere-key", "voyage-key"
+	AppConfig.Settings.Rerank = &Rerank{Models: map[string]string{"rerank-2": "voyage", "rerank-x": "other"}}
+
+	target, err := RerankProvider("rerank-v3.5")
+	require.NoError(t, err)
+	assert.Equal(t, "cohere", target.Provider)
+	assert.Equal(t, "cohere-key", target.APIKey)
+	assert.Equal(t, "https://api.cohere.com/v2/rerank", target.URL)
+
+	target, err = RerankProvider("rerank-2")
+	require.NoError(t, err)
+	assert.Equal(t, "voyage-key", target.APIKey)
+	assert.Equal(t, "http://voyage.internal/v1/rerank", target.URL)
+
+	_, err = RerankProvider("rerank-x")
+	assert.ErrorContains(t, err, "unknown rerank provider")
+
+	AppConfig.Providers.Voyage.Enabled = false
+	_, err = RerankProvider("rerank-2")
+	assert.ErrorContains(t, err, "not enabled")
+}
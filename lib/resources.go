@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// ErrPreconditionFailed is returned for writes whose If-Match or If-None-Match
+// header doesn't hold for the current resource
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// ResourceETag returns the strong ETag of the JSON representation of a resource
+func ResourceETag(resource interface{}) string {
+	data, _ := json.Marshal(resource)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// CheckPreconditions checks the If-Match and If-None-Match headers of a write
+// against the ETag of the current resource, empty if it doesn't exist. If-Match
+// makes a write fail unless the resource is unchanged, If-None-Match: * makes a
+// create fail if the resource exists.
+func CheckPreconditions(r *http.Request, etag string) error {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (etag == "" || !etagListed(ifMatch, etag)) {
+		return ErrPreconditionFailed
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etag != "" && etagListed(ifNoneMatch, etag) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// etagListed reports whether a list of ETags of a header holds etag or is *
+func etagListed(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Page is a page of a list endpoint of the admin API. NextCursor is passed as
+// the cursor of the next page, it is empty on the last one.
+type Page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// PageQuery is what a list request asks for: at most Limit items ordered by
+// ID, after the ID After
+type PageQuery struct {
+	Limit int
+	After string
+}
+
+// ParsePageQuery reads the limit and cursor query parameters of a list request.
+// The limit is 100 if unset and at most 1000.
+func ParsePageQuery(r *http.Request) (PageQuery, error) {
+	query := PageQuery{Limit: defaultPageLimit}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return PageQuery{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return PageQuery{}, errors.New("invalid cursor")
+		}
+		query.After = string(after)
+	}
+	return query, nil
+}
+
+// PageCursor returns the cursor of the page after the item with ID id
+func PageCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// configWrites serializes the changes of the config file made through the admin API
+var configWrites sync.Mutex
+
+// readConfigFile reads the config file into its own viper instance, without
+// the secrets loaded from the environment
+func readConfigFile() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(viperCfg.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// writeConfigFile writes v to the config file and applies it right away, so a
+// read following the write sees it without waiting for the file watcher
+func writeConfigFile(v *viper.Viper) error {
+	if err := v.WriteConfig(); err != nil {
+		return err
+	}
+	if err := viperCfg.ReadInConfig(); err != nil {
+		return err
+	}
+	var config Configuration
+	if err := viperCfg.Unmarshal(&config); err != nil {
+		return err
+	}
+	AppConfig = config
+	if raw, err := os.ReadFile(viperCfg.ConfigFileUsed()); err == nil {
+		setConfigRaw(raw)
+		go publishConfig()
+	}
+	return nil
+}
+
+// configEntries returns the entries of a list of the config file
+func configEntries(v *viper.Viper, key string) []map[string]interface{} {
+	items, _ := v.Get(key).([]interface{})
+	entries := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if entry, ok := item.(map[string]interface{}); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ConfigEntries returns the entries of a list of the config file, such as
+// rules.input, ordered by their idField
+func ConfigEntries(key string, idField string) ([]map[string]interface{}, error) {
+	v, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	entries := configEntries(v, key)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return fmt.Sprint(entries[i][idField]) < fmt.Sprint(entries[j][idField])
+	})
+	return entries, nil
+}
+
+// ConfigEntry returns the entry of a list of the config file whose idField is id
+func ConfigEntry(key string, idField string, id string) (map[string]interface{}, bool, error) {
+	v, err := readConfigFile()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, entry := range configEntries(v, key) {
+		if fmt.Sprint(entry[idField]) == id {
+			return entry, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// UpdateConfigEntry replaces the entry of a list of the config file whose
+// idField is id, appending it if there is none, or removes it if entry is nil.
+// check gets the current entry, nil if there is none, and the other entries of
+// the list, and stops the change with its error. It returns the entry as the
+// config file holds it afterwards.
+func UpdateConfigEntry(key string, idField string, id string, entry map[string]interface{}, check func(current map[string]interface{}, others []map[string]interface{}) error) (map[string]interface{}, error) {
+	configWrites.Lock()
+	defer configWrites.Unlock()
+
+	v, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	entries := configEntries(v, key)
+	index := -1
+	for i, current := range entries {
+		if fmt.Sprint(current[idField]) == id {
+			index = i
+			break
+		}
+	}
+	var current map[string]interface{}
+	others := make([]map[string]interface{}, 0, len(entries))
+	for i, existing := range entries {
+		if i == index {
+			current = existing
+		} else {
+			others = append(others, existing)
+		}
+	}
+	if err := check(current, others); err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0, len(entries)+1)
+	for i, existing := range entries {
+		if i != index {
+			items = append(items, existing)
+		} else if entry != nil {
+			items = append(items, entry)
+		}
+	}
+	if index < 0 && entry != nil {
+		items = append(items, entry)
+	}
+	v.Set(key, items)
+	if err := writeConfigFile(v); err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	stored, _, err := ConfigEntry(key, idField, id)
+	return stored, err
+}
+
+// ConfigEntryVersion returns the version of an entry of the config file. An
+// entry written before it had one is at version 1, no entry at version 0.
+func ConfigEntryVersion(entry map[string]interface{}) int {
+	if entry == nil {
+		return 0
+	}
+	switch version := entry["version"].(type) {
+	case int:
+		return version
+	case int64:
+		return int(version)
+	case float64:
+		return int(version)
+	}
+	return 1
+}
+
+// DecodeConfigEntry decodes an entry of a list of the config file into target
+// like the config file is decoded
+func DecodeConfigEntry(entry map[string]interface{}, target interface{}) error {
+	v := viper.New()
+	v.Set("entry", entry)
+	return v.UnmarshalKey("entry", target)
+}
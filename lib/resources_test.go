@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := ResourceETag(map[string]string{"name": "rule"})
+	assert.Equal(t, etag, ResourceETag(map[string]string{"name": "rule"}))
+
+	r := httptest.NewRequest("PUT", "/admin/rules/input/rule", nil)
+	assert.NoError(t, CheckPreconditions(r, etag))
+	assert.NoError(t, CheckPreconditions(r, ""))
+
+	r.Header.Set("If-Match", etag)
+	assert.NoError(t, CheckPreconditions(r, etag))
+	assert.ErrorIs(t, CheckPreconditions(r, ResourceETag("changed")), ErrPreconditionFailed)
+	assert.ErrorIs(t, CheckPreconditions(r, ""), ErrPreconditionFailed)
+
+	r.Header.Set("If-Match", "*")
+	assert.NoError(t, CheckPreconditions(r, etag))
+
+	r.Header.Del("If-Match")
+	r.Header.Set("If-None-Match", "*")
+	assert.NoError(t, CheckPreconditions(r, ""))
+	assert.ErrorIs(t, CheckPreconditions(r, etag), ErrPreconditionFailed)
+}
+
+func TestParsePageQuery(t *testing.T) {
+	page, err := ParsePageQuery(httptest.NewRequest("GET", "/admin/products", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, PageQuery{Limit: defaultPageLimit}, page)
+
+	page, err = ParsePageQuery(httptest.NewRequest("GET", "/admin/products?limit=10&cursor="+PageCursor("rule_b"), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, PageQuery{Limit: 10, After: "rule_b"}, page)
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=ten", "cursor=%25%25"} {
+		_, err = ParsePageQuery(httptest.NewRequest("GET", "/admin/products?"+query, nil))
+		assert.Error(t, err, query)
+	}
+}
+
+func TestUpdateConfigEntry(t *testing.T) {
+	original := viperCfg.ConfigFileUsed()
+	defer func() {
+		viperCfg.SetConfigFile(original)
+		viperCfg.ReadInConfig()
+		viperCfg.Unmarshal(&AppConfig)
+	}()
+	raw, err := os.ReadFile(original)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, raw, 0o600))
+	viperCfg.SetConfigFile(path)
+
+	noCheck := func(current map[string]interface{}, others []map[string]interface{}) error { return nil }
+	stored, err := UpdateConfigEntry("rules.output", "name", "pii_terraform", map[string]interface{}{
+		"name": "pii_terraform", "type": "pii_scanner", "enabled": true, "action": map[string]interface{}{"type": "redact"},
+	}, noCheck)
+	assert.NoError(t, err)
+	assert.Equal(t, "pii_scanner", stored["type"])
+
+	// The change is applied without waiting for the file watcher
+	last := GetConfig().Rules.Output[len(GetConfig().Rules.Output)-1]
+	assert.Equal(t, "pii_terraform", last.Name)
+	assert.Equal(t, ActionType("redact"), last.Action.Type)
+
+	entries, err := ConfigEntries("rules.output", "name")
+	assert.NoError(t, err)
+	count := len(entries)
+
+	_, err = UpdateConfigEntry("rules.output", "name", "pii_terraform", map[string]interface{}{
+		"name": "pii_terraform", "type": "pii_scanner", "enabled": false,
+	}, func(current map[string]interface{}, others []map[string]interface{}) error {
+		assert.Equal(t, true, current["enabled"])
+		assert.Len(t, others, count-1)
+		return nil
+	})
+	assert.NoError(t, err)
+	entry, ok, err := ConfigEntry("rules.output", "name", "pii_terraform")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, false, entry["enabled"])
+
+	_, err = UpdateConfigEntry("rules.output", "name", "pii_terraform", nil, func(current map[string]interface{}, others []map[string]interface{}) error {
+		return ErrPreconditionFailed
+	})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	_, err = UpdateConfigEntry("rules.output", "name", "pii_terraform", nil, noCheck)
+	assert.NoError(t, err)
+	_, ok, err = ConfigEntry("rules.output", "name", "pii_terraform")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	entries, _ = ConfigEntries("rules.output", "name")
+	assert.Len(t, entries, count-1)
+}
+
+func TestConfigEntryVersion(t *testing.T) {
+	assert.Equal(t, 0, ConfigEntryVersion(nil))
+	assert.Equal(t, 1, ConfigEntryVersion(map[string]interface{}{"name": "rule"}))
+	assert.Equal(t, 3, ConfigEntryVersion(map[string]interface{}{"version": 3}))
+	assert.Equal(t, 4, ConfigEntryVersion(map[string]interface{}{"version": float64(4)}))
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	ResponseLimitTruncate = "truncate"
+	ResponseLimitError    = "error"
+)
+
+// ErrResponseTooLarge is returned when a completion exceeds the limits and the policy is "error"
+var ErrResponseTooLarge = errors.New("completion exceeds the configured response size limit")
+
+// ResponseLimiter counts the content of one completion against the response limits.
+// Streamed content is passed chunk by chunk.
+type ResponseLimiter struct {
+	settings ResponseLimits
+	model    string
+	bytes    int
+	tokens   int
+	reached  bool
+}
+
+// NewResponseLimiter returns a limiter for a completion of model, or nil when no limit is set
+func NewResponseLimiter(model string) *ResponseLimiter {
+	settings := GetConfig().Settings.ResponseLimits
+	if settings == nil || (settings.MaxBytes <= 0 && settings.MaxTokens <= 0) {
+		return nil
+	}
+	limiter := &ResponseLimiter{settings: *settings, model: model}
+	if limiter.settings.Policy == "" {
+		limiter.settings.Policy = ResponseLimitTruncate
+	}
+	if limiter.settings.Marker == "" {
+		limiter.settings.Marker = "[truncated]"
+	}
+	return limiter
+}
+
+// Limit returns the part of content that fits in the limits. Once the limit is
+// reached, the truncated content ends with the marker, or ErrResponseTooLarge is
+// returned under the error policy. Content after the limit is dropped.
+func (l *ResponseLimiter) Limit(content string) (string, bool, error) {
+	if l == nil || content == "" {
+		return content, false, nil
+	}
+	if l.reached {
+		return "", true, nil
+	}
+
+	kept := content
+	if l.settings.MaxBytes > 0 && l.bytes+len(kept) > l.settings.MaxBytes {
+		kept = truncateBytes(kept, l.settings.MaxBytes-l.bytes)
+		l.reached = true
+	}
+	if l.settings.MaxTokens > 0 {
+		tokens := CountTokens(l.model, kept)
+		if l.tokens+tokens > l.settings.MaxTokens {
+			kept = TruncateTokens(l.model, kept, l.settings.MaxTokens-l.tokens)
+			tokens = CountTokens(l.model, kept)
+			l.reached = true
+		}
+		l.tokens += tokens
+	}
+	l.bytes += len(kept)
+
+	if !l.reached {
+		return content, false, nil
+	}
+	if l.settings.Policy == ResponseLimitError {
+		return "", true, ErrResponseTooLarge
+	}
+	return kept + l.settings.Marker, true, nil
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a UTF-8 sequence
+func truncateBytes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseLimiterBytes(t *testing.T) {
+	limiter := &ResponseLimiter{settings: ResponseLimits{MaxBytes: 10, Policy: ResponseLimitTruncate, Marker: "…"}}
+
+	content, reached, err := limiter.Limit("hello ")
+	assert.NoError(t, err)
+	assert.False(t, reached)
+	assert.Equal(t, "hello ", content)
+
+	content, reached, err = limiter.Limit("wörld!")
+	assert.NoError(t, err)
+	assert.True(t, reached)
+	assert.Equal(t, "wör…", content)
+
+	content, reached, _ = limiter.Limit("more")
+	assert.True(t, reached)
+	assert.Equal(t, "", content)
+}
+
+func TestResponseLimiterErrorPolicy(t *testing.T) {
+	limiter := &ResponseLimiter{settings: ResponseLimits{MaxTokens: 3, Policy: ResponseLimitError}, model: "gpt-4"}
+
+	_, reached, err := limiter.Limit("one two three four five")
+	assert.True(t, reached)
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+}
+
+func TestTruncateBytes(t *testing.T) {
+	assert.Equal(t, "ab", truncateBytes("abc", 2))
+	assert.Equal(t, "a", truncateBytes("aé", 2))
+	assert.Equal(t, "", truncateBytes("abc", 0))
+}
@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// RuleExecutionsEnabled reports whether the results of rules are saved, which
+// they are unless settings.rule_executions disables it
+func RuleExecutionsEnabled() bool {
+	ruleExecutions := GetConfig().Settings.RuleExecutions
+	return ruleExecutions == nil || ruleExecutions.Enabled
+}
+
+// RecordRuleExecution saves the result of a rule that ran on the request, whether
+// audit logging is on or not. Executions hold counts, never the content checked.
+func RecordRuleExecution(r *http.Request, execution models.RuleExecution) {
+	if !RuleExecutionsEnabled() {
+		return
+	}
+	execution.RequestId = getRequestID(r)
+	execution.ApiKeyID, _ = r.Context().Value("apiKeyId").(uuid.UUID)
+	createOrQueue(queuedRuleExecution, &execution)
+}
@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const minuteQuotaRedisPrefix = "openshield:minute_quota:"
+
+// ErrScheduleRateLimited is returned when an API key exceeds the rate limit of an active schedule
+var ErrScheduleRateLimited = errors.New("rate limit of the current schedule exceeded")
+
+// minuteQuotas counts the requests per minute when Redis isn't configured
+var minuteQuotas = struct {
+	sync.Mutex
+	minute int64
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// minuteOfDay parses a "15:04" time
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// ScheduleActive reports whether the window of a schedule contains now. The part
+// of a window after midnight belongs to the day the window started.
+func ScheduleActive(schedule Schedule, now time.Time) (bool, error) {
+	location := time.UTC
+	if schedule.Timezone != "" {
+		loaded, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return false, fmt.Errorf("schedule %s: %v", schedule.Name, err)
+		}
+		location = loaded
+	}
+	start, err := minuteOfDay(schedule.Start)
+	if err != nil {
+		return false, fmt.Errorf("schedule %s: %v", schedule.Name, err)
+	}
+	end, err := minuteOfDay(schedule.End)
+	if err != nil {
+		return false, fmt.Errorf("schedule %s: %v", schedule.Name, err)
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	switch {
+	case start < end:
+		if minute < start || minute >= end {
+			return false, nil
+		}
+	case minute >= start:
+	case minute < end:
+		day = (day + 6) % 7
+	default:
+		return false, nil
+	}
+
+	if len(schedule.Days) == 0 {
+		return true, nil
+	}
+	for _, d := range schedule.Days {
+		if strings.EqualFold(d, day.String()[:3]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ActiveSchedules returns the schedules of the workspace of the request that are active at now
+func ActiveSchedules(r *http.Request, now time.Time) []Schedule {
+	workspaceID, _ := r.Context().Value("workspaceId").(uuid.UUID)
+
+	var active []Schedule
+	for _, schedule := range GetRequestConfig(r).Settings.Schedules {
+		if !schedule.Enabled || len(schedule.Workspaces) > 0 && !ProductListed(schedule.Workspaces, workspaceID) {
+			continue
+		}
+		ok, err := ScheduleActive(schedule, now)
+		if err != nil {
+			log.Printf("Error checking schedule: %v", err)
+			continue
+		}
+		if ok {
+			active = append(active, schedule)
+		}
+	}
+	return active
+}
+
+// ApplySchedules applies the active schedules to a request: it enforces their
+// rate limits, flags the request for review and switches to the strict content
+// profile. The names of the active schedules are stored in the context.
+func ApplySchedules(r *http.Request) (*http.Request, error) {
+	active := ActiveSchedules(r, time.Now())
+	if len(active) == 0 {
+		return r, nil
+	}
+
+	apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	names := make([]string, 0, len(active))
+	strict := false
+	for _, schedule := range active {
+		names = append(names, schedule.Name)
+		if schedule.RateLimit > 0 {
+			count, err := countMinuteRequest(r.Context(), "schedule:"+schedule.Name, apiKeyId)
+			if err != nil {
+				log.Printf("Error counting requests of schedule %s: %v", schedule.Name, err)
+			} else if count > schedule.RateLimit {
+				return r, fmt.Errorf("%w: %d requests per minute during %s", ErrScheduleRateLimited, schedule.RateLimit, schedule.Name)
+			}
+		}
+		if schedule.Review {
+			AuditLogs(fmt.Sprintf("request during schedule %s", schedule.Name), "schedule", apiKeyId, "review", r)
+		}
+		strict = strict || schedule.Strict
+	}
+
+	ctx := context.WithValue(r.Context(), "schedules", names)
+	if strict && !StrictContentActive(r) {
+		ctx = context.WithValue(ctx, "strictContent", true)
+		ctx = context.WithValue(ctx, "config", StrictConfig(GetRequestConfig(r)))
+	}
+	return r.WithContext(ctx), nil
+}
+
+// ScheduleListed reports whether one of the schedules is active for the request.
+// An empty list is always active.
+func ScheduleListed(r *http.Request, schedules []string) bool {
+	if len(schedules) == 0 {
+		return true
+	}
+	active, _ := r.Context().Value("schedules").([]string)
+	for _, name := range schedules {
+		for _, activeName := range active {
+			if name == activeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countMinuteRequest counts a request of an API key against the per minute limit
+// of scope and returns the count of the current minute
+func countMinuteRequest(ctx context.Context, scope string, apiKeyId uuid.UUID) (int, error) {
+	return addMinuteCount(ctx, fmt.Sprintf("%s:%s", scope, apiKeyId), time.Now().Unix()/60, 1)
+}
+
+// addMinuteCount adds amount to the counter of name for a minute since the epoch
+// and returns the new count. Negative amounts give back what was counted.
+func addMinuteCount(ctx context.Context, name string, minute int64, amount int) (int, error) {
+	key := fmt.Sprintf("%s%s:%d", minuteQuotaRedisPrefix, name, minute)
+	if RedisConfigured() {
+		count, err := RedisClient().IncrBy(ctx, key, int64(amount)).Result()
+		if err != nil {
+			return 0, err
+		}
+		if count == int64(amount) {
+			RedisClient().Expire(ctx, key, 2*time.Minute)
+		}
+		return int(count), nil
+	}
+
+	minuteQuotas.Lock()
+	defer minuteQuotas.Unlock()
+	if minute > minuteQuotas.minute {
+		minuteQuotas.minute = minute
+		minuteQuotas.counts = map[string]int{}
+	}
+	if minute < minuteQuotas.minute {
+		// Only the current minute is kept in memory
+		return 0, nil
+	}
+	minuteQuotas.counts[key] += amount
+	return minuteQuotas.counts[key], nil
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleActive(t *testing.T) {
+	afterHours := Schedule{Name: "after_hours", Timezone: "Europe/Berlin", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "18:00", End: "08:00"}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	cases := []struct {
+		at     time.Time
+		active bool
+	}{
+		{time.Date(2024, 6, 3, 12, 0, 0, 0, berlin), false},   // Monday noon
+		{time.Date(2024, 6, 3, 19, 0, 0, 0, berlin), true},    // Monday evening
+		{time.Date(2024, 6, 4, 7, 59, 0, 0, berlin), true},    // Tuesday morning, started Monday
+		{time.Date(2024, 6, 8, 3, 0, 0, 0, berlin), true},     // Saturday night, started Friday
+		{time.Date(2024, 6, 9, 3, 0, 0, 0, berlin), false},    // Sunday night, started Saturday
+		{time.Date(2024, 6, 3, 17, 30, 0, 0, time.UTC), true}, // 19:30 in Berlin
+	}
+	for _, c := range cases {
+		active, err := ScheduleActive(afterHours, c.at)
+		assert.NoError(t, err)
+		assert.Equal(t, c.active, active, c.at.String())
+	}
+
+	_, err := ScheduleActive(Schedule{Name: "broken", Start: "9am", End: "17:00"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestApplySchedules(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(schedules []Schedule) { AppConfig.Settings.Schedules = schedules }(AppConfig.Settings.Schedules)
+	AppConfig.Settings.Redis = nil
+	AppConfig.Settings.Schedules = []Schedule{{Enabled: true, Name: "always", Start: "00:00", End: "00:00", Strict: true, RateLimit: 2}}
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	scheduled, err := ApplySchedules(r)
+	assert.NoError(t, err)
+	assert.True(t, StrictContentActive(scheduled))
+	assert.True(t, ScheduleListed(scheduled, []string{"always"}))
+	assert.False(t, ScheduleListed(scheduled, []string{"weekend"}))
+	assert.True(t, ScheduleListed(r, nil))
+
+	_, err = ApplySchedules(r)
+	assert.NoError(t, err)
+	_, err = ApplySchedules(r)
+	assert.ErrorIs(t, err, ErrScheduleRateLimited)
+}
@@ -0,0 +1,462 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	contentType = "application/scim+json"
+)
+
+var userNameFilter = regexp.MustCompile(`^userName eq "([^"]*)"$`)
+
+type MultiValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Roles       []MultiValue `json:"roles,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []User   `json:"Resources"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	ScimType string   `json:"scimType,omitempty"`
+	Status   string   `json:"status"`
+	Detail   string   `json:"detail"`
+}
+
+// AuthSCIMMiddleware authenticates the identity provider with the configured SCIM bearer token
+func AuthSCIMMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := lib.GetConfig().Secrets.SCIMToken
+		splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || len(splitToken) != 2 || subtle.ConstantTimeCompare([]byte(splitToken[1]), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid SCIM token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := lib.DB().Model(&models.AdminUsers{})
+
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		match := userNameFilter.FindStringSubmatch(filter)
+		if match == nil {
+			writeError(w, http.StatusBadRequest, "unsupported filter, only 'userName eq \"...\"' is supported")
+			return
+		}
+		query = query.Where("user_name = ?", match[1])
+	}
+
+	startIndex, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 || count > 100 {
+		count = 100
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var users []models.AdminUsers
+	if err := query.Order("created_at").Offset(startIndex - 1).Limit(count).Find(&users).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resources := make([]User, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, toSCIMUser(user))
+	}
+
+	writeJSON(w, http.StatusOK, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: int(total),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toSCIMUser(user))
+}
+
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var scimUser User
+	if err := json.NewDecoder(r.Body).Decode(&scimUser); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if scimUser.UserName == "" {
+		writeError(w, http.StatusBadRequest, "userName is required")
+		return
+	}
+
+	// Deleted users keep their userName in the unique index, so provisioning
+	// one again restores it as a new account
+	var existing models.AdminUsers
+	result := lib.DB().Unscoped().Where("user_name = ?", scimUser.UserName).First(&existing)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	deleted := result.Error == nil && existing.DeletedAt != nil && existing.DeletedAt.Valid
+	if result.Error == nil && !deleted {
+		writeConflict(w)
+		return
+	}
+
+	user := models.AdminUsers{Role: models.AdminRoleViewer, Status: models.Active}
+	if deleted {
+		user.Base = models.Base{Id: existing.Id, CreatedAt: existing.CreatedAt}
+	}
+	if err := applySCIMUser(&user, scimUser); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := lib.DB().Transaction(func(tx *gorm.DB) error {
+		if !deleted {
+			return tx.Create(&user).Error
+		}
+		if err := tx.Unscoped().Model(&models.AdminUsers{}).Where("id = ?", user.Id).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toSCIMUser(user))
+}
+
+func ReplaceUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	var scimUser User
+	if err := json.NewDecoder(r.Body).Decode(&scimUser); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+	if scimUser.UserName == "" {
+		writeError(w, http.StatusBadRequest, "userName is required")
+		return
+	}
+
+	user.Role = models.AdminRoleViewer
+	user.Status = models.Active
+	if err := applySCIMUser(&user, scimUser); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := lib.DB().Save(&user).Error; err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toSCIMUser(user))
+}
+
+func PatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	var patch PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("error decoding request body: %v", err))
+		return
+	}
+
+	for _, operation := range patch.Operations {
+		if err := applyPatchOperation(&user, operation); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := lib.DB().Save(&user).Error; err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toSCIMUser(user))
+}
+
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	user.Status = models.Archived
+	if err := lib.DB().Save(&user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := lib.DB().Delete(&user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findUser(w http.ResponseWriter, r *http.Request) (models.AdminUsers, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return models.AdminUsers{}, false
+	}
+
+	var user models.AdminUsers
+	result := lib.DB().Where("id = ?", id).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return models.AdminUsers{}, false
+	} else if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error.Error())
+		return models.AdminUsers{}, false
+	}
+	return user, true
+}
+
+func applySCIMUser(user *models.AdminUsers, scimUser User) error {
+	user.UserName = scimUser.UserName
+	user.ExternalID = scimUser.ExternalID
+	user.DisplayName = scimUser.DisplayName
+	user.Email = primaryValue(scimUser.Emails)
+	if scimUser.Active != nil {
+		user.Status = activeStatus(*scimUser.Active)
+	}
+	if len(scimUser.Roles) > 0 {
+		role, err := parseRole(primaryValue(scimUser.Roles))
+		if err != nil {
+			return err
+		}
+		user.Role = role
+	}
+	return nil
+}
+
+func applyPatchOperation(user *models.AdminUsers, operation PatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "replace" && op != "add" {
+		return fmt.Errorf("unsupported patch operation: %s", operation.Op)
+	}
+
+	// Without a path the value is an object of attributes to replace
+	if operation.Path == "" {
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+			return fmt.Errorf("invalid patch value: %v", err)
+		}
+		for path, value := range attributes {
+			if err := applyPatchOperation(user, PatchOperation{Op: op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch operation.Path {
+	case "active":
+		var active bool
+		if err := unmarshalBool(operation.Value, &active); err != nil {
+			return fmt.Errorf("invalid value for active: %v", err)
+		}
+		user.Status = activeStatus(active)
+	case "displayName":
+		return json.Unmarshal(operation.Value, &user.DisplayName)
+	case "userName":
+		return json.Unmarshal(operation.Value, &user.UserName)
+	case "externalId":
+		return json.Unmarshal(operation.Value, &user.ExternalID)
+	case "roles":
+		var roles []MultiValue
+		if err := json.Unmarshal(operation.Value, &roles); err != nil {
+			return fmt.Errorf("invalid value for roles: %v", err)
+		}
+		role, err := parseRole(primaryValue(roles))
+		if err != nil {
+			return err
+		}
+		user.Role = role
+	default:
+		return fmt.Errorf("unsupported patch path: %s", operation.Path)
+	}
+	return nil
+}
+
+// unmarshalBool accepts both JSON booleans and the string form some identity providers send
+func unmarshalBool(data json.RawMessage, v *bool) error {
+	if err := json.Unmarshal(data, v); err == nil {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = b
+	return nil
+}
+
+func parseRole(role string) (models.AdminRole, error) {
+	switch models.AdminRole(role) {
+	case models.AdminRoleAdmin, models.AdminRoleViewer:
+		return models.AdminRole(role), nil
+	default:
+		return "", fmt.Errorf("unknown role: %s", role)
+	}
+}
+
+func primaryValue(values []MultiValue) string {
+	for _, value := range values {
+		if value.Primary {
+			return value.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+func activeStatus(active bool) models.Status {
+	if active {
+		return models.Active
+	}
+	return models.Inactive
+}
+
+func toSCIMUser(user models.AdminUsers) User {
+	active := user.Status == models.Active
+	scimUser := User{
+		Schemas:     []string{UserSchema},
+		Id:          user.Id.String(),
+		ExternalID:  user.ExternalID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Active:      &active,
+		Roles:       []MultiValue{{Value: string(user.Role), Primary: true}},
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + user.Id.String(),
+		},
+	}
+	if user.Email != "" {
+		scimUser.Emails = []MultiValue{{Value: user.Email, Primary: true}}
+	}
+	return scimUser
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeConflict answers a userName taken by another user, deleted ones included
+func writeConflict(w http.ResponseWriter) {
+	writeJSON(w, http.StatusConflict, Error{
+		Schemas:  []string{ErrorSchema},
+		ScimType: "uniqueness",
+		Status:   strconv.Itoa(http.StatusConflict),
+		Detail:   "userName already exists",
+	})
+}
+
+// writeSaveError answers an error saving a user, a conflict if the database
+// refused its userName
+func writeSaveError(w http.ResponseWriter, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		writeConflict(w)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, statusCode int, detail string) {
+	writeJSON(w, statusCode, Error{
+		Schemas: []string{ErrorSchema},
+		Status:  strconv.Itoa(statusCode),
+		Detail:  detail,
+	})
+}
@@ -0,0 +1,29 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSaveError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeSaveError(w, fmt.Errorf("save: %w", &pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var scimErr Error
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scimErr))
+	assert.Equal(t, "uniqueness", scimErr.ScimType)
+	assert.Equal(t, "409", scimErr.Status)
+
+	w = httptest.NewRecorder()
+	writeSaveError(w, errors.New("connection refused"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "scimType")
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// OpenAIPrefix is where the OpenAI routes are served, SDKPrefix is their alias
+// with SDK compatibility enabled
+const (
+	OpenAIPrefix = "/openai/v1"
+	SDKPrefix    = "/v1"
+)
+
+// AnthropicPrefix is where the Anthropic routes are served
+const AnthropicPrefix = "/anthropic/v1"
+
+// publicPrefixes are the route prefixes listed in 404 responses. Admin and SCIM
+// routes are left out, clients have no use for them.
+var publicPrefixes = []string{OpenAIPrefix, SDKPrefix, AnthropicPrefix, "/openshield/v1/rag", "/openshield/v1/evaluate", "/vectordb", "/version"}
+
+// SDKCompatibilityEnabled reports whether the OpenAI routes are served at /v1 too
+func SDKCompatibilityEnabled() bool {
+	compatibility := GetConfig().Settings.SDKCompatibility
+	return compatibility != nil && compatibility.Enabled
+}
+
+// CanonicalPath returns the /openai/v1 path of a request to the /v1 alias, so
+// path based settings written for /openai/v1 apply to both
+func CanonicalPath(path string) string {
+	if SDKCompatibilityEnabled() && (path == SDKPrefix || strings.HasPrefix(path, SDKPrefix+"/")) {
+		return "/openai" + path
+	}
+	return path
+}
+
+// bearerToken returns the API key of an "Authorization: Bearer" header. The
+// scheme is case insensitive and surrounding whitespace is ignored, as SDKs and
+// proxies differ in both. Requests without the header may send the key in the
+// X-Api-Key header, as the Anthropic SDKs do.
+func bearerToken(r *http.Request) (string, error) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if key := strings.TrimSpace(r.Header.Get("X-Api-Key")); header == "" && key != "" {
+		return key, nil
+	}
+	if header == "" {
+		return "", fmt.Errorf("missing Authorization header, expected \"Authorization: Bearer <API key>\"")
+	}
+	scheme, token, found := strings.Cut(header, " ")
+	token = strings.TrimSpace(token)
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", fmt.Errorf("invalid Authorization header format, expected \"Authorization: Bearer <API key>\"")
+	}
+	return token, nil
+}
+
+// writeAuthError writes a 401 in the OpenAI error format, which the SDKs raise
+// as an authentication error
+func writeAuthError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "invalid_api_key",
+		},
+	})
+}
+
+// writeProfileError answers a request whose API key is valid but whose product
+// couldn't be loaded, which the client can retry
+func writeProfileError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": "the product of the API key could not be loaded, retry later",
+			"type":    "service_unavailable",
+			"param":   nil,
+			"code":    "product_unavailable",
+		},
+	})
+}
+
+// PublicRoutes lists the client routes of a router as "METHOD pattern". The
+// methods every route answers by proxying are left out.
+func PublicRoutes(router chi.Routes) []string {
+	seen := map[string]bool{}
+	chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		switch method {
+		case http.MethodConnect, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			return nil
+		}
+		for _, prefix := range publicPrefixes {
+			if route == prefix || strings.HasPrefix(route, prefix+"/") {
+				seen[method+" "+route] = true
+			}
+		}
+		return nil
+	})
+	routes := make([]string, 0, len(seen))
+	for route := range seen {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// NotFoundHandler answers unknown paths with an OpenAI-format 404 naming the
+// supported routes, rather than a bare "404 page not found" SDKs can't parse
+func NotFoundHandler(routes []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		message := fmt.Sprintf("Unknown request URL: %s %s. Supported routes: %s", r.Method, r.URL.Path, strings.Join(routes, ", "))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": message,
+				"type":    "invalid_request_error",
+				"param":   nil,
+				"code":    "unknown_url",
+			},
+			"supported_routes": routes,
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer sk-test":     "sk-test",
+		"bearer sk-test":     "sk-test",
+		"  Bearer  sk-test ": "sk-test",
+		"Basic c2stdGVzdA==": "",
+		"Bearer ":            "",
+		"sk-test":            "",
+		"":                   "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+		r.Header.Set("Authorization", header)
+		token, err := bearerToken(r)
+		assert.Equal(t, want, token, header)
+		assert.Equal(t, want == "", err != nil, header)
+	}
+
+	// The Anthropic SDKs send the key in X-Api-Key
+	r := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+	r.Header.Set("X-Api-Key", "sk-test")
+	token, err := bearerToken(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-test", token)
+	r.Header.Set("Authorization", "Bearer sk-other")
+	token, _ = bearerToken(r)
+	assert.Equal(t, "sk-other", token)
+}
+
+func TestCanonicalPath(t *testing.T) {
+	original := AppConfig.Settings.SDKCompatibility
+	defer func() { AppConfig.Settings.SDKCompatibility = original }()
+
+	AppConfig.Settings.SDKCompatibility = nil
+	assert.Equal(t, "/v1/chat/completions", CanonicalPath("/v1/chat/completions"))
+
+	AppConfig.Settings.SDKCompatibility = &SDKCompatibility{Enabled: true}
+	assert.Equal(t, "/openai/v1/chat/completions", CanonicalPath("/v1/chat/completions"))
+	assert.Equal(t, "/openai/v1/models", CanonicalPath("/openai/v1/models"))
+	assert.Equal(t, "/v1beta/models", CanonicalPath("/v1beta/models"))
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	router := chi.NewRouter()
+	router.Route(OpenAIPrefix, func(r chi.Router) {
+		r.Get("/models", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/chat/completions", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	router.Get("/admin/jobs", func(w http.ResponseWriter, r *http.Request) {})
+	routes := PublicRoutes(router)
+	assert.Equal(t, []string{"GET /openai/v1/models", "POST /openai/v1/chat/completions"}, routes)
+	router.NotFound(NotFoundHandler(routes))
+
+	for _, path := range []string{"/v1/embeddings", "/openai/v1/embeddings"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    string `json:"code"`
+			} `json:"error"`
+			SupportedRoutes []string `json:"supported_routes"`
+		}
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&body))
+		assert.Equal(t, "unknown_url", body.Error.Code)
+		assert.Contains(t, body.Error.Message, "POST "+path)
+		assert.Equal(t, routes, body.SupportedRoutes)
+	}
+}
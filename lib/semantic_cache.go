@@ -0,0 +1,300 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SemanticCacheHeader tells whether a chat completion was answered from the semantic cache
+const SemanticCacheHeader = "X-OpenShield-Cache"
+
+const (
+	semanticCacheIndex  = "openshield-semantic-cache"
+	semanticCachePrefix = "semcache:"
+)
+
+// GetSemanticCacheSettings returns the semantic cache settings
+func GetSemanticCacheSettings() SemanticCache {
+	settings := SemanticCache{}
+	if cache := GetConfig().Settings.SemanticCache; cache != nil {
+		settings = *cache
+	}
+	if settings.Store == "" {
+		settings.Store = "redis"
+	}
+	if settings.EmbeddingModel == "" {
+		settings.EmbeddingModel = "text-embedding-3-small"
+	}
+	if settings.Threshold <= 0 {
+		settings.Threshold = 0.95
+	}
+	if settings.TTL <= 0 {
+		settings.TTL = 3600
+	}
+	return settings
+}
+
+// SemanticCacheEnabled reports whether the semantic cache is enabled for the
+// route of r. Routes and requests of the /v1 alias are matched by their
+// /openai/v1 path.
+func SemanticCacheEnabled(r *http.Request) bool {
+	settings := GetSemanticCacheSettings()
+	if !settings.Enabled {
+		return false
+	}
+	if len(settings.Routes) == 0 {
+		return true
+	}
+	requestPath := CanonicalPath(r.URL.Path)
+	for _, route := range settings.Routes {
+		route = CanonicalPath(route)
+		if matched, _ := path.Match(route, requestPath); matched || route == requestPath {
+			return true
+		}
+	}
+	return false
+}
+
+// SemanticCacheScope returns the scope cached completions are shared in, the
+// product and model of the request and params, the encoded parameters that
+// change the shape of a completion
+func SemanticCacheScope(productID uuid.UUID, model string, params string) string {
+	return hashKey(productID.String() + "\x00" + model + "\x00" + params)
+}
+
+// semanticCacheStore keeps completions by the embeddings of their prompts
+type semanticCacheStore interface {
+	// nearest returns the completion of the prompt in scope most similar to
+	// vector and its cosine similarity, false if scope has none
+	nearest(ctx context.Context, scope string, vector []float32) ([]byte, float64, bool, error)
+	store(ctx context.Context, scope string, vector []float32, response []byte, ttl time.Duration) error
+}
+
+func semanticStore(settings SemanticCache) (semanticCacheStore, error) {
+	switch settings.Store {
+	case "redis":
+		return redisSemanticStore{}, nil
+	case "pgvector":
+		return pgvectorSemanticStore{}, nil
+	}
+	return nil, fmt.Errorf("unsupported semantic cache store %s", settings.Store)
+}
+
+// GetSemanticCache returns the cached completion of the prompt in scope most
+// similar to vector if its similarity reaches the threshold, in a span of the
+// trace of the request of ctx
+func GetSemanticCache(ctx context.Context, scope string, vector []float32) ([]byte, bool, error) {
+	settings := GetSemanticCacheSettings()
+	store, err := semanticStore(settings)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, span := StartSpan(ctx, "cache.semantic_get")
+	span.SetAttribute("openshield.cache_store", settings.Store)
+	response, similarity, found, err := store.nearest(ctx, scope, vector)
+	hit := found && similarity >= settings.Threshold
+	span.SetAttribute("openshield.cache_hit", strconv.FormatBool(hit))
+	if found {
+		span.SetAttribute("openshield.cache_similarity", strconv.FormatFloat(similarity, 'f', 4, 64))
+	}
+	span.End(err)
+	if err != nil || !hit {
+		return nil, false, err
+	}
+	IncrCounter("semantic_cache.hits", 1, "store:"+settings.Store)
+	return response, true, nil
+}
+
+// SetSemanticCache caches the completion of the prompt of vector in scope
+func SetSemanticCache(ctx context.Context, scope string, vector []float32, response []byte) error {
+	settings := GetSemanticCacheSettings()
+	store, err := semanticStore(settings)
+	if err != nil {
+		return err
+	}
+	return store.store(ctx, scope, vector, response, time.Duration(settings.TTL)*time.Second)
+}
+
+// redisSemanticStore keeps completions in hashes searched by a RediSearch
+// vector index, which is created with the dimension of the first embedding
+type redisSemanticStore struct{}
+
+var (
+	semanticIndexMu    sync.Mutex
+	semanticIndexReady bool
+)
+
+func (redisSemanticStore) ensureIndex(ctx context.Context, dimension int) error {
+	semanticIndexMu.Lock()
+	defer semanticIndexMu.Unlock()
+	if semanticIndexReady {
+		return nil
+	}
+	err := RedisClient().Do(ctx, "FT.CREATE", semanticCacheIndex, "ON", "HASH", "PREFIX", "1", semanticCachePrefix,
+		"SCHEMA", "scope", "TAG", "embedding", "VECTOR", "HNSW", "6",
+		"TYPE", "FLOAT32", "DIM", dimension, "DISTANCE_METRIC", "COSINE").Err()
+	if err != nil && !strings.Contains(err.Error(), "Index already exists") {
+		return fmt.Errorf("failed to create semantic cache index: %v", err)
+	}
+	semanticIndexReady = true
+	return nil
+}
+
+func (s redisSemanticStore) nearest(ctx context.Context, scope string, vector []float32) ([]byte, float64, bool, error) {
+	if err := s.ensureIndex(ctx, len(vector)); err != nil {
+		return nil, 0, false, err
+	}
+	reply, err := RedisClient().Do(ctx, "FT.SEARCH", semanticCacheIndex,
+		"(@scope:{"+scope+"})=>[KNN 1 @embedding $vector AS distance]",
+		"PARAMS", "2", "vector", string(float32Bytes(vector)),
+		"SORTBY", "distance", "RETURN", "2", "distance", "response", "DIALECT", "2").Result()
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to search semantic cache: %v", err)
+	}
+	fields, ok := firstSearchResult(reply)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	distance, err := strconv.ParseFloat(fields["distance"], 64)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("invalid semantic cache distance %q", fields["distance"])
+	}
+	// RediSearch reports the cosine distance, 1 - similarity
+	return []byte(fields["response"]), 1 - distance, true, nil
+}
+
+func (s redisSemanticStore) store(ctx context.Context, scope string, vector []float32, response []byte, ttl time.Duration) error {
+	if err := s.ensureIndex(ctx, len(vector)); err != nil {
+		return err
+	}
+	key := semanticCachePrefix + uuid.New().String()
+	pipe := RedisClient().TxPipeline()
+	pipe.HSet(ctx, key, "scope", scope, "embedding", float32Bytes(vector), "response", response)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// firstSearchResult returns the fields of the first document of an FT.SEARCH
+// reply, in the RESP2 array or the RESP3 map form
+func firstSearchResult(reply interface{}) (map[string]string, bool) {
+	switch reply := reply.(type) {
+	case []interface{}:
+		// [total, key, [field, value, ...], ...]
+		if len(reply) < 3 {
+			return nil, false
+		}
+		values, ok := reply[2].([]interface{})
+		if !ok {
+			return nil, false
+		}
+		fields := map[string]string{}
+		for i := 0; i+1 < len(values); i += 2 {
+			fields[fmt.Sprint(values[i])] = fmt.Sprint(values[i+1])
+		}
+		return fields, true
+	case map[interface{}]interface{}:
+		results, _ := reply["results"].([]interface{})
+		if len(results) == 0 {
+			return nil, false
+		}
+		result, _ := results[0].(map[interface{}]interface{})
+		attributes, _ := result["extra_attributes"].(map[interface{}]interface{})
+		fields := make(map[string]string, len(attributes))
+		for field, value := range attributes {
+			fields[fmt.Sprint(field)] = fmt.Sprint(value)
+		}
+		return fields, true
+	}
+	return nil, false
+}
+
+// float32Bytes encodes a vector as RediSearch reads FLOAT32 vectors
+func float32Bytes(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// pgvectorSemanticStore keeps completions in the semantic_cache table of the
+// database, which needs the pgvector extension
+type pgvectorSemanticStore struct{}
+
+var (
+	semanticTableMu    sync.Mutex
+	semanticTableReady bool
+)
+
+func (pgvectorSemanticStore) ensureTable(ctx context.Context) error {
+	semanticTableMu.Lock()
+	defer semanticTableMu.Unlock()
+	if semanticTableReady {
+		return nil
+	}
+	for _, statement := range []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		"CREATE TABLE IF NOT EXISTS semantic_cache (id uuid PRIMARY KEY, scope text NOT NULL, embedding vector NOT NULL, response bytea NOT NULL, expires_at timestamptz NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS idx_semantic_cache_scope ON semantic_cache (scope, expires_at)",
+	} {
+		if err := DB().WithContext(ctx).Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create semantic cache table: %v", err)
+		}
+	}
+	semanticTableReady = true
+	return nil
+}
+
+func (s pgvectorSemanticStore) nearest(ctx context.Context, scope string, vector []float32) ([]byte, float64, bool, error) {
+	if err := s.ensureTable(ctx); err != nil {
+		return nil, 0, false, err
+	}
+	literal := vectorLiteral(vector)
+	var row struct {
+		Response   []byte
+		Similarity float64
+	}
+	result := DB().WithContext(ctx).Raw(
+		"SELECT response, 1 - (embedding <=> ?::vector) AS similarity FROM semantic_cache WHERE scope = ? AND expires_at > now() ORDER BY embedding <=> ?::vector LIMIT 1",
+		literal, scope, literal).Scan(&row)
+	if result.Error != nil {
+		return nil, 0, false, fmt.Errorf("failed to search semantic cache: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, 0, false, nil
+	}
+	return row.Response, row.Similarity, true, nil
+}
+
+func (s pgvectorSemanticStore) store(ctx context.Context, scope string, vector []float32, response []byte, ttl time.Duration) error {
+	if err := s.ensureTable(ctx); err != nil {
+		return err
+	}
+	db := DB().WithContext(ctx)
+	if err := db.Exec("DELETE FROM semantic_cache WHERE scope = ? AND expires_at <= now()", scope).Error; err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO semantic_cache (id, scope, embedding, response, expires_at) VALUES (?, ?, ?::vector, ?, ?)",
+		uuid.New(), scope, vectorLiteral(vector), response, time.Now().Add(ttl)).Error
+}
+
+// vectorLiteral formats a vector as a pgvector literal
+func vectorLiteral(vector []float32) string {
+	values := make([]string, len(vector))
+	for i, value := range vector {
+		values[i] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemanticCacheEnabled(t *testing.T) {
+	defer func(cache *SemanticCache) { AppConfig.Settings.SemanticCache = cache }(AppConfig.Settings.SemanticCache)
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	AppConfig.Settings.SemanticCache = nil
+	assert.False(t, SemanticCacheEnabled(r))
+
+	AppConfig.Settings.SemanticCache = &SemanticCache{Enabled: true}
+	assert.True(t, SemanticCacheEnabled(r))
+
+	AppConfig.Settings.SemanticCache = &SemanticCache{Enabled: true, Routes: []string{"/v1/*/completions"}}
+	assert.False(t, SemanticCacheEnabled(r))
+	assert.True(t, SemanticCacheEnabled(httptest.NewRequest("POST", "/v1/chat/completions", nil)))
+
+	// With the /v1 alias served, its requests match the routes of /openai/v1 and the other way round
+	defer func(compatibility *SDKCompatibility) { AppConfig.Settings.SDKCompatibility = compatibility }(AppConfig.Settings.SDKCompatibility)
+	AppConfig.Settings.SDKCompatibility = &SDKCompatibility{Enabled: true}
+	AppConfig.Settings.SemanticCache = &SemanticCache{Enabled: true, Routes: []string{"/openai/v1/chat/completions"}}
+	assert.True(t, SemanticCacheEnabled(r))
+	assert.True(t, SemanticCacheEnabled(httptest.NewRequest("POST", "/v1/chat/completions", nil)))
+	AppConfig.Settings.SemanticCache = &SemanticCache{Enabled: true, Routes: []string{"/v1/*/completions"}}
+	assert.True(t, SemanticCacheEnabled(r))
+}
+
+func TestFirstSearchResult(t *testing.T) {
+	fields, ok := firstSearchResult([]interface{}{int64(1), "semcache:a", []interface{}{"distance", "0.02", "response", "{}"}})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"distance": "0.02", "response": "{}"}, fields)
+
+	fields, ok = firstSearchResult(map[interface{}]interface{}{
+		"total_results": int64(1),
+		"results": []interface{}{map[interface{}]interface{}{
+			"id":               "semcache:a",
+			"extra_attributes": map[interface{}]interface{}{"distance": "0.02", "response": "{}"},
+		}},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "0.02", fields["distance"])
+
+	_, ok = firstSearchResult([]interface{}{int64(0)})
+	assert.False(t, ok)
+	_, ok = firstSearchResult(map[interface{}]interface{}{"total_results": int64(0), "results": []interface{}{}})
+	assert.False(t, ok)
+}
+
+func TestVectorEncodings(t *testing.T) {
+	assert.Equal(t, "[0.5,-1,0.25]", vectorLiteral([]float32{0.5, -1, 0.25}))
+	assert.Equal(t, []byte{0, 0, 0x80, 0x3f, 0, 0, 0, 0xc0}, float32Bytes([]float32{1, -2}))
+}
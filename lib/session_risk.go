@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RiskStrict    = "strict"
+	RiskReview    = "review"
+	RiskTerminate = "terminate"
+
+	sessionRiskRedisPrefix = "openshield:session_risk:"
+
+	// maxLocalSessionScores bounds the scores kept without Redis
+	maxLocalSessionScores = 10000
+)
+
+// ErrSessionTerminated is returned for sessions whose risk score crossed a terminate threshold
+var ErrSessionTerminated = errors.New("session terminated due to its risk score")
+
+type sessionScore struct {
+	score     float64
+	expiresAt time.Time
+}
+
+// sessionScores holds the scores when Redis isn't configured
+var sessionScores = struct {
+	sync.Mutex
+	entries map[string]sessionScore
+}{entries: map[string]sessionScore{}}
+
+// GetSessionRiskSettings returns the session risk settings with defaults applied
+func GetSessionRiskSettings() SessionRisk {
+	settings := SessionRisk{}
+	if risk := GetConfig().Settings.SessionRisk; risk != nil {
+		settings = *risk
+	}
+	if settings.Header == "" {
+		settings.Header = "OS-Session-ID"
+	}
+	if settings.TTL <= 0 {
+		settings.TTL = 3600
+	}
+	return settings
+}
+
+// sessionRiskKey identifies the score of a request. The score is kept per API
+// key, the session header is chosen by the client and rotating it mustn't reset
+// the score, so the session ID only names the session in the audit log.
+func sessionRiskKey(r *http.Request, settings SessionRisk) (string, bool) {
+	apiKeyId, ok := r.Context().Value("apiKeyId").(uuid.UUID)
+	if !settings.Enabled || !ok || apiKeyId == uuid.Nil {
+		return "", false
+	}
+	return sessionRiskRedisPrefix + apiKeyId.String(), true
+}
+
+// SessionRiskScore returns the risk score of the session of the request
+func SessionRiskScore(r *http.Request) float64 {
+	key, ok := sessionRiskKey(r, GetSessionRiskSettings())
+	if !ok {
+		return 0
+	}
+	score, err := loadSessionScore(r.Context(), key)
+	if err != nil {
+		log.Printf("Error loading session risk score: %v", err)
+	}
+	return score
+}
+
+// SessionEscalations returns the actions of the thresholds a score has crossed
+func SessionEscalations(thresholds []RiskThreshold, score float64) map[string]bool {
+	actions := map[string]bool{}
+	for _, threshold := range thresholds {
+		if score >= threshold.Score {
+			actions[threshold.Action] = true
+		}
+	}
+	return actions
+}
+
+// ApplySessionRisk escalates a request of a risky session: terminated sessions
+// are refused and strict sessions get the strict content profile
+func ApplySessionRisk(r *http.Request) (*http.Request, error) {
+	settings := GetSessionRiskSettings()
+	if _, ok := sessionRiskKey(r, settings); !ok {
+		return r, nil
+	}
+
+	actions := SessionEscalations(settings.Thresholds, SessionRiskScore(r))
+	if actions[RiskTerminate] {
+		return r, ErrSessionTerminated
+	}
+	if actions[RiskStrict] && !StrictContentActive(r) {
+		ctx := context.WithValue(r.Context(), "strictContent", true)
+		ctx = context.WithValue(ctx, "config", StrictConfig(GetRequestConfig(r)))
+		r = r.WithContext(ctx)
+	}
+	return r, nil
+}
+
+// AddSessionRisk adds the points of a rule hit to the session of the request.
+// Crossing a review or terminate threshold is recorded in the audit log.
+func AddSessionRisk(r *http.Request, rule string, points float64) {
+	settings := GetSessionRiskSettings()
+	key, ok := sessionRiskKey(r, settings)
+	if !ok || points <= 0 {
+		return
+	}
+
+	score, err := addSessionScore(r.Context(), key, points, time.Duration(settings.TTL)*time.Second)
+	if err != nil {
+		log.Printf("Error updating session risk score: %v", err)
+		return
+	}
+	log.Printf("Session risk score %.2f after %.2f points from rule %s", score, points, rule)
+
+	for _, threshold := range settings.Thresholds {
+		if threshold.Action == RiskStrict || score < threshold.Score || score-points >= threshold.Score {
+			continue
+		}
+		apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+		message := fmt.Sprintf("API key %s crossed risk score %.2f (%s) with %.2f after rule %s",
+			apiKeyId, threshold.Score, threshold.Action, score, rule)
+		if session := r.Header.Get(settings.Header); session != "" {
+			message += fmt.Sprintf(" in session %s", session)
+		}
+		AuditLogs(message, "session_risk", apiKeyId, RiskReview, r)
+	}
+}
+
+func loadSessionScore(ctx context.Context, key string) (float64, error) {
+	if RedisConfigured() {
+		score, err := RedisClient().Get(ctx, key).Float64()
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return score, err
+	}
+
+	sessionScores.Lock()
+	defer sessionScores.Unlock()
+	entry, ok := sessionScores.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(sessionScores.entries, key)
+		return 0, nil
+	}
+	return entry.score, nil
+}
+
+func addSessionScore(ctx context.Context, key string, points float64, ttl time.Duration) (float64, error) {
+	if RedisConfigured() {
+		var incr *redis.FloatCmd
+		_, err := RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.IncrByFloat(ctx, key, points)
+			pipe.Expire(ctx, key, ttl)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return incr.Val(), nil
+	}
+
+	sessionScores.Lock()
+	defer sessionScores.Unlock()
+	entry, ok := sessionScores.entries[key]
+	if !ok && len(sessionScores.entries) >= maxLocalSessionScores {
+		now := time.Now()
+		for k, expired := range sessionScores.entries {
+			if now.After(expired.expiresAt) {
+				delete(sessionScores.entries, k)
+			}
+		}
+		if len(sessionScores.entries) >= maxLocalSessionScores {
+			return 0, fmt.Errorf("too many session risk scores, %d are kept without Redis", maxLocalSessionScores)
+		}
+	}
+	if time.Now().After(entry.expiresAt) {
+		entry.score = 0
+	}
+	entry.score += points
+	entry.expiresAt = time.Now().Add(ttl)
+	sessionScores.entries[key] = entry
+	return entry.score, nil
+}
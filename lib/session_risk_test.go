@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRisk(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(risk *SessionRisk) { AppConfig.Settings.SessionRisk = risk }(AppConfig.Settings.SessionRisk)
+	AppConfig.Settings.Redis = nil
+	AppConfig.Settings.SessionRisk = &SessionRisk{
+		Enabled: true,
+		Thresholds: []RiskThreshold{
+			{Score: 2, Action: RiskStrict},
+			{Score: 3, Action: RiskReview},
+			{Score: 4, Action: RiskTerminate},
+		},
+	}
+
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "apiKeyId", uuid.New()))
+	r.Header.Set("OS-Session-ID", "conversation-1")
+
+	AddSessionRisk(r, "prompt_injection", 1.5)
+	escalated, err := ApplySessionRisk(r)
+	assert.NoError(t, err)
+	assert.False(t, StrictContentActive(escalated))
+
+	AddSessionRisk(r, "prompt_injection", 1)
+	escalated, err = ApplySessionRisk(r)
+	assert.NoError(t, err)
+	assert.True(t, StrictContentActive(escalated))
+
+	// Another API key can't see the session
+	other := r.WithContext(context.WithValue(r.Context(), "apiKeyId", uuid.New()))
+	assert.Equal(t, 0.0, SessionRiskScore(other))
+
+	AddSessionRisk(r, "topic", 2)
+	assert.Equal(t, 4.5, SessionRiskScore(r))
+	_, err = ApplySessionRisk(r)
+	assert.ErrorIs(t, err, ErrSessionTerminated)
+
+	// Rotating or dropping the session header keeps the score of the API key
+	r.Header.Set("OS-Session-ID", "conversation-2")
+	_, err = ApplySessionRisk(r)
+	assert.ErrorIs(t, err, ErrSessionTerminated)
+	r.Header.Del("OS-Session-ID")
+	_, err = ApplySessionRisk(r)
+	assert.ErrorIs(t, err, ErrSessionTerminated)
+}
+
+func TestLocalSessionScoresBounded(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	AppConfig.Settings.Redis = nil
+	sessionScores.Lock()
+	saved := sessionScores.entries
+	sessionScores.entries = map[string]sessionScore{}
+	for i := 0; i < maxLocalSessionScores; i++ {
+		sessionScores.entries[fmt.Sprint(i)] = sessionScore{score: 1, expiresAt: time.Now().Add(time.Hour)}
+	}
+	sessionScores.Unlock()
+	defer func() { sessionScores.entries = saved }()
+
+	_, err := addSessionScore(context.Background(), "new", 1, time.Hour)
+	assert.Error(t, err)
+	score, err := addSessionScore(context.Background(), "0", 1, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, score)
+
+	// Expired scores make room
+	sessionScores.entries["1"] = sessionScore{score: 1, expiresAt: time.Now().Add(-time.Second)}
+	score, err = addSessionScore(context.Background(), "new", 1, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, score)
+	assert.NotContains(t, sessionScores.entries, "1")
+}
@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"crypto/tls"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -79,18 +78,11 @@ func GetRouteSettings() (RouteSettings, error) {
 	}
 
 	if config.Settings.Redis.SSL {
-		redisOptions.TLSConfig = &tls.Config{
-			MinVersion:       tls.VersionTLS12,
-			CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
-		}
+		redisOptions.TLSConfig = TLSConfig()
 	}
 
+	redisOptions.Dialer = redisEgressDialer(redisOptions)
+
 	return RouteSettings{
 		RateLimit: &RateLimiting{
 			Max:        config.Settings.RateLimit.Max,
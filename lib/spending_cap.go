@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const providerSpendRedisPrefix = "openshield:provider_spend:"
+
+var providerSpend = struct {
+	sync.Mutex
+	month string
+	spend map[string]float64
+}{spend: map[string]float64{}}
+
+// spendMonth is the calendar month spend is counted in, in UTC
+func spendMonth(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// spendingCapFor returns the enabled spending cap of a provider
+func spendingCapFor(provider string) (SpendingCap, bool) {
+	for _, spendingCap := range GetConfig().Settings.SpendingCaps {
+		if spendingCap.Enabled && spendingCap.Provider == provider {
+			if spendingCap.Threshold <= 0 || spendingCap.Threshold > 1 {
+				spendingCap.Threshold = 0.9
+			}
+			return spendingCap, true
+		}
+	}
+	return SpendingCap{}, false
+}
+
+// ModelCost returns the cost in USD of the tokens of a request from the prices of the model catalog
+func ModelCost(model string, promptTokens int, completionTokens int) float64 {
+	metadata, _ := ModelMetadataFor(model)
+	return (float64(promptTokens)*metadata.InputPrice + float64(completionTokens)*metadata.OutputPrice) / 1e6
+}
+
+// RecordSpend adds the cost of a request to the monthly spend of its provider,
+// providers without a spending cap aren't tracked
+func RecordSpend(ctx context.Context, provider string, model string, promptTokens int, completionTokens int) {
+	if _, ok := spendingCapFor(provider); !ok {
+		return
+	}
+	cost := ModelCost(model, promptTokens, completionTokens)
+	if cost == 0 {
+		return
+	}
+
+	month := spendMonth(time.Now())
+	if RedisConfigured() {
+		key := providerSpendRedisPrefix + provider + ":" + month
+		_, err := RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.IncrByFloat(ctx, key, cost)
+			pipe.Expire(ctx, key, 32*24*time.Hour)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error recording spend of provider %s: %v", provider, err)
+		}
+		return
+	}
+
+	providerSpend.Lock()
+	defer providerSpend.Unlock()
+	if providerSpend.month != month {
+		providerSpend.month = month
+		providerSpend.spend = map[string]float64{}
+	}
+	providerSpend.spend[provider] += cost
+}
+
+// ProviderSpend returns the spend of a provider in the current month
+func ProviderSpend(ctx context.Context, provider string) (float64, error) {
+	month := spendMonth(time.Now())
+	if RedisConfigured() {
+		spend, err := RedisClient().Get(ctx, providerSpendRedisPrefix+provider+":"+month).Float64()
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return spend, err
+	}
+
+	providerSpend.Lock()
+	defer providerSpend.Unlock()
+	if providerSpend.month != month {
+		return 0, nil
+	}
+	return providerSpend.spend[provider], nil
+}
+
+// SpendingDowngrade returns the cheaper model to send a request for model to once
+// the monthly spend of the provider reaches the threshold of its cap. Models
+// without a downgrade keep being served as they are.
+func SpendingDowngrade(ctx context.Context, provider string, model string) (string, bool) {
+	spendingCap, ok := spendingCapFor(provider)
+	if !ok {
+		return "", false
+	}
+	fallback := ""
+	for _, downgrade := range spendingCap.Downgrades {
+		if downgrade.Model == model {
+			fallback = downgrade.Fallback
+			break
+		}
+	}
+	if fallback == "" {
+		return "", false
+	}
+
+	spend, err := ProviderSpend(ctx, provider)
+	if err != nil {
+		log.Printf("Error getting spend of provider %s: %v", provider, err)
+		return "", false
+	}
+	if spend < spendingCap.MonthlyCap*spendingCap.Threshold {
+		return "", false
+	}
+	log.Printf("Provider %s spent $%.2f of its $%.2f cap, downgrading %s to %s", provider, spend, spendingCap.MonthlyCap, model, fallback)
+	return fallback, true
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelCost(t *testing.T) {
+	assert.InDelta(t, 0.0125, ModelCost("gpt-4o", 1000, 1000), 1e-9)
+	assert.Equal(t, 0.0, ModelCost("unknown-model", 1000, 1000))
+}
+
+func TestSpendingDowngrade(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	defer func(caps []SpendingCap) { AppConfig.Settings.SpendingCaps = caps }(AppConfig.Settings.SpendingCaps)
+	AppConfig.Settings.Redis = nil
+	AppConfig.Settings.SpendingCaps = []SpendingCap{{
+		Enabled:    true,
+		Provider:   "test-provider",
+		MonthlyCap: 1,
+		Downgrades: []ModelDowngrade{{Model: "gpt-4o", Fallback: "gpt-4o-mini"}},
+	}}
+	ctx := context.Background()
+
+	_, ok := SpendingDowngrade(ctx, "test-provider", "gpt-4o")
+	assert.False(t, ok)
+
+	// $0.85 is below 90% of the cap
+	RecordSpend(ctx, "test-provider", "gpt-4o", 100000, 60000)
+	_, ok = SpendingDowngrade(ctx, "test-provider", "gpt-4o")
+	assert.False(t, ok)
+
+	RecordSpend(ctx, "test-provider", "gpt-4o", 20000, 0)
+	fallback, ok := SpendingDowngrade(ctx, "test-provider", "gpt-4o")
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o-mini", fallback)
+
+	_, ok = SpendingDowngrade(ctx, "test-provider", "gpt-4-turbo")
+	assert.False(t, ok)
+	_, ok = SpendingDowngrade(ctx, "other-provider", "gpt-4o")
+	assert.False(t, ok)
+}
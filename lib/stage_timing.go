@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// StageRecorder receives the time an instrumented stage of a request spent,
+// not counting the instrumented stages it called
+type StageRecorder func(stage string, self time.Duration)
+
+var stageRecorder atomic.Value
+
+type stageSpan struct {
+	children time.Duration
+}
+
+// SetStageRecorder sets the recorder of the stages of routers built afterwards
+func SetStageRecorder(recorder StageRecorder) {
+	stageRecorder.Store(recorder)
+}
+
+// timeStage records the time next spends in the stage recorder
+func timeStage(recorder StageRecorder, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := r.Context().Value("stageSpan").(*stageSpan)
+		span := &stageSpan{}
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "stageSpan", span)))
+		total := time.Since(start)
+		recorder(name, total-span.children)
+		if parent != nil {
+			parent.children += total
+		}
+	})
+}
+
+// TimeStage instruments a middleware for the stage recorder. Without a recorder
+// when the router is built the middleware is returned as it is, so only
+// benchmarks pay for the timing.
+func TimeStage(name string, middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	recorder, _ := stageRecorder.Load().(StageRecorder)
+	if recorder == nil {
+		return middleware
+	}
+	return func(next http.Handler) http.Handler {
+		return timeStage(recorder, name, middleware(next))
+	}
+}
+
+// TimeHandler instruments a handler for the stage recorder, see TimeStage
+func TimeHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	recorder, _ := stageRecorder.Load().(StageRecorder)
+	if recorder == nil {
+		return handler
+	}
+	return timeStage(recorder, name, handler).ServeHTTP
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeStage(t *testing.T) {
+	sleeping := func(duration time.Duration) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(duration)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) { time.Sleep(20 * time.Millisecond) }
+
+	// Without a recorder nothing is instrumented
+	SetStageRecorder(nil)
+	assert.NotNil(t, TimeStage("outer", sleeping(0)))
+
+	var mu sync.Mutex
+	recorded := map[string]time.Duration{}
+	SetStageRecorder(func(stage string, self time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		recorded[stage] = self
+	})
+	defer SetStageRecorder(nil)
+
+	chain := TimeStage("outer", sleeping(10*time.Millisecond))(TimeHandler("handler", handler))
+	chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.GreaterOrEqual(t, recorded["handler"], 20*time.Millisecond)
+	assert.GreaterOrEqual(t, recorded["outer"], 10*time.Millisecond)
+	assert.Less(t, recorded["outer"], 20*time.Millisecond, "the handler is not counted in the outer stage")
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// productProfileTTL is how long the profile of a product is cached
+const productProfileTTL = 30 * time.Second
+
+type productProfile struct {
+	strict      bool
+	workspaceID uuid.UUID
+	account     OpenAIAccount
+	expiresAt   time.Time
+}
+
+var productProfiles sync.Map
+
+// loadProductProfile returns the strict content flag, the workspace and the
+// OpenAI account of a product. Profiles that can't be loaded aren't cached.
+func loadProductProfile(productID uuid.UUID) (productProfile, error) {
+	if cached, ok := productProfiles.Load(productID); ok && time.Now().Before(cached.(productProfile).expiresAt) {
+		return cached.(productProfile), nil
+	}
+
+	var product models.Products
+	if err := DB().Select("strict_content", "workspace_id", "openai_organization", "openai_project").Where("id = ?", productID).First(&product).Error; err != nil {
+		return productProfile{}, fmt.Errorf("error loading product %s: %v", productID, err)
+	}
+	profile := productProfile{
+		strict:      product.StrictContent,
+		workspaceID: product.WorkspaceID,
+		account:     OpenAIAccount{Organization: product.OpenAIOrganization, Project: product.OpenAIProject},
+		expiresAt:   time.Now().Add(productProfileTTL),
+	}
+	if profile.account.Organization == "" || profile.account.Project == "" {
+		var workspace models.Workspaces
+		if err := DB().Select("openai_organization", "openai_project").Where("id = ?", product.WorkspaceID).First(&workspace).Error; err != nil {
+			return productProfile{}, fmt.Errorf("error loading workspace %s: %v", product.WorkspaceID, err)
+		}
+		if profile.account.Organization == "" {
+			profile.account.Organization = workspace.OpenAIOrganization
+		}
+		if profile.account.Project == "" {
+			profile.account.Project = workspace.OpenAIProject
+		}
+	}
+	productProfiles.Store(productID, profile)
+	return profile, nil
+}
+
+// withProductProfile stores the product, workspace and OpenAI account of the API
+// key in the context and, for products with the strict content profile, the strict config
+// of the request. Without its profile the strict content profile of a product
+// can't be enforced, so an error loading it fails the request.
+func withProductProfile(ctx context.Context, r *http.Request, apiKey models.ApiKeys) (context.Context, error) {
+	profile, err := loadProductProfile(apiKey.ProductID)
+	if err != nil {
+		return ctx, err
+	}
+	ctx = context.WithValue(ctx, "productId", apiKey.ProductID)
+	ctx = context.WithValue(ctx, "workspaceId", profile.workspaceID)
+	setRequestTenant(ctx, profile.workspaceID, apiKey.ProductID, apiKey.Id)
+	if profile.account != (OpenAIAccount{}) {
+		ctx = context.WithValue(ctx, "openaiAccount", profile.account)
+	}
+	if !profile.strict {
+		return ctx, nil
+	}
+	ctx = context.WithValue(ctx, "strictContent", true)
+	return context.WithValue(ctx, "config", StrictConfig(GetRequestConfig(r))), nil
+}
+
+// ProductListed reports whether productID is in products. An empty list includes every product.
+func ProductListed(products []string, productID uuid.UUID) bool {
+	if len(products) == 0 {
+		return true
+	}
+	for _, product := range products {
+		if strings.EqualFold(product, productID.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// StrictContentActive reports whether the request is for a product with the strict content profile
+func StrictContentActive(r *http.Request) bool {
+	strict, _ := r.Context().Value("strictContent").(bool)
+	return strict
+}
+
+// CheckStrictContent rejects models and parameters disabled by the strict
+// content profile. Parameters are matched against the keys of the raw request
+// body, so it applies to the requests of every API.
+func CheckStrictContent(r *http.Request, body []byte, model string, temperature float32) error {
+	if !StrictContentActive(r) {
+		return nil
+	}
+	profile := StrictContentSettings()
+
+	for _, blocked := range profile.BlockedModels {
+		if blocked == model {
+			return fmt.Errorf("model %s is not available for this product", model)
+		}
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return fmt.Errorf("error decoding request body: %v", err)
+	}
+	for _, param := range profile.DisabledParameters {
+		if value, ok := params[param]; ok && string(value) != "null" && string(value) != "false" {
+			return fmt.Errorf("parameter %s is not available for this product", param)
+		}
+	}
+
+	if profile.MaxTemperature > 0 && temperature > float32(profile.MaxTemperature) {
+		return fmt.Errorf("temperature above %v is not available for this product", profile.MaxTemperature)
+	}
+	return nil
+}
+
+// StrictContentSettings returns the strict content profile
+func StrictContentSettings() StrictContent {
+	if strict := GetConfig().Settings.StrictContent; strict != nil {
+		return *strict
+	}
+	return StrictContent{}
+}
+
+// StrictConfig returns config with the rules turned to their most conservative
+// form: every rule blocks on a match, uses its strict threshold and stays
+// active during break-glass
+func StrictConfig(config Configuration) Configuration {
+	config.Rules.Input = strictRules(config.Rules.Input)
+	config.Rules.Output = strictRules(config.Rules.Output)
+	return config
+}
+
+func strictRules(rules []Rule) []Rule {
+	strict := make([]Rule, len(rules))
+	for i, rule := range rules {
+		rule.Critical = true
+		// Self harm rules keep answering with the crisis response
+		if rule.Type != "self_harm" {
+			rule.Action.Type = "block"
+		}
+		if rule.Config.StrictThreshold != 0 {
+			rule.Config.Threshold = rule.Config.StrictThreshold
+		}
+		strict[i] = rule
+	}
+	return strict
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictConfig(t *testing.T) {
+	config := Configuration{Rules: Rules{
+		Input: []Rule{{
+			Name:   "prompt_injection",
+			Config: Config{Threshold: 80, StrictThreshold: 40},
+			Action: Action{Type: "monitor"},
+		}},
+		Output: []Rule{{
+			Name:   "profanity",
+			Action: Action{Type: "mask"},
+		}},
+	}}
+
+	strict := StrictConfig(config)
+	assert.Equal(t, 40, strict.Rules.Input[0].Config.Threshold)
+	assert.Equal(t, ActionType("block"), strict.Rules.Input[0].Action.Type)
+	assert.True(t, strict.Rules.Input[0].Critical)
+	assert.Equal(t, ActionType("block"), strict.Rules.Output[0].Action.Type)
+
+	// The original config is left alone
+	assert.Equal(t, 80, config.Rules.Input[0].Config.Threshold)
+	assert.Equal(t, ActionType("mask"), config.Rules.Output[0].Action.Type)
+}
+
+func TestCheckStrictContent(t *testing.T) {
+	defer func(strict *StrictContent) { AppConfig.Settings.StrictContent = strict }(AppConfig.Settings.StrictContent)
+	AppConfig.Settings.StrictContent = &StrictContent{BlockedModels: []string{"gpt-4o-realtime"}, DisabledParameters: []string{"top_k"}, MaxTemperature: 0.5}
+	body := []byte(`{"model":"claude-3-5-sonnet","top_k":null,"stream":false}`)
+
+	req := httptest.NewRequest("POST", "/anthropic/v1/messages", nil)
+	assert.NoError(t, CheckStrictContent(req, []byte(`{"top_k":5}`), "gpt-4o-realtime", 1))
+
+	req = req.WithContext(context.WithValue(req.Context(), "strictContent", true))
+	assert.NoError(t, CheckStrictContent(req, body, "claude-3-5-sonnet", 0.5))
+	assert.ErrorContains(t, CheckStrictContent(req, body, "gpt-4o-realtime", 0), "model gpt-4o-realtime")
+	assert.ErrorContains(t, CheckStrictContent(req, []byte(`{"top_k":5}`), "claude-3-5-sonnet", 0), "parameter top_k")
+	assert.ErrorContains(t, CheckStrictContent(req, body, "claude-3-5-sonnet", 0.9), "temperature above 0.5")
+}
+
+func TestWithProductProfile(t *testing.T) {
+	productID := uuid.New()
+	workspaceID := uuid.New()
+	productProfiles.Store(productID, productProfile{strict: true, workspaceID: workspaceID, expiresAt: time.Now().Add(time.Minute)})
+	defer productProfiles.Delete(productID)
+
+	r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+	ctx, err := withProductProfile(r.Context(), r, models.ApiKeys{ProductID: productID})
+	require.NoError(t, err)
+	assert.Equal(t, workspaceID, ctx.Value("workspaceId"))
+	assert.Equal(t, true, ctx.Value("strictContent"))
+
+	// A product that can't be loaded is answered as unavailable, not served without its profile
+	w := httptest.NewRecorder()
+	writeProfileError(w)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "product_unavailable")
+}
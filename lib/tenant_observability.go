@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// RequestTenant is the workspace, product and API key of a request. The
+// middlewares around authentication put it in the context empty, authentication
+// and the handlers fill it.
+type RequestTenant struct {
+	WorkspaceID uuid.UUID
+	ProductID   uuid.UUID
+	APIKeyID    uuid.UUID
+	Model       string
+	Blocked     bool
+}
+
+// TenantTraffic is the traffic of a workspace seen by this replica
+type TenantTraffic struct {
+	WorkspaceID   string    `json:"workspace_id"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	Blocked       int64     `json:"blocked"`
+	MeanLatencyMs float64   `json:"mean_latency_ms"`
+	LastRequestAt time.Time `json:"last_request_at"`
+
+	totalLatency time.Duration
+}
+
+var tenantTraffic struct {
+	sync.Mutex
+	workspaces map[uuid.UUID]*TenantTraffic
+}
+
+// GetRequestTenant returns the tenant of the request, nil outside of TenantMiddleware
+func GetRequestTenant(r *http.Request) *RequestTenant {
+	tenant, _ := r.Context().Value("requestTenant").(*RequestTenant)
+	return tenant
+}
+
+// setRequestTenant fills in the tenant of the request once the API key is known
+func setRequestTenant(ctx context.Context, workspaceID uuid.UUID, productID uuid.UUID, apiKeyID uuid.UUID) {
+	if tenant, ok := ctx.Value("requestTenant").(*RequestTenant); ok {
+		tenant.WorkspaceID = workspaceID
+		tenant.ProductID = productID
+		tenant.APIKeyID = apiKeyID
+	}
+}
+
+// SetRequestModel records the model a request asked for
+func SetRequestModel(r *http.Request, model string) {
+	if tenant := GetRequestTenant(r); tenant != nil {
+		tenant.Model = model
+	}
+}
+
+// TenantTags returns the workspace and product tags of a request for metrics
+func TenantTags(r *http.Request) []string {
+	tenant := GetRequestTenant(r)
+	if tenant == nil || tenant.WorkspaceID == uuid.Nil {
+		return nil
+	}
+	return []string{"workspace:" + tenant.WorkspaceID.String(), "product:" + tenant.ProductID.String()}
+}
+
+// TenantMiddleware carries the tenant of requests to the observability
+// middlewares after it and counts the traffic of every workspace
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Synthetic probes bring their own, to read the verdict
+		tenant := GetRequestTenant(r)
+		if tenant == nil {
+			tenant = &RequestTenant{}
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), "requestTenant", tenant)))
+		latency := time.Since(start)
+		if tenant.WorkspaceID != uuid.Nil {
+			recordTenantTraffic(tenant, ww.Status(), latency)
+		}
+		publishTail(r, tenant, ww.Status(), latency)
+	})
+}
+
+func recordTenantTraffic(tenant *RequestTenant, status int, latency time.Duration) {
+	tenantTraffic.Lock()
+	defer tenantTraffic.Unlock()
+	if tenantTraffic.workspaces == nil {
+		tenantTraffic.workspaces = map[uuid.UUID]*TenantTraffic{}
+	}
+	traffic, ok := tenantTraffic.workspaces[tenant.WorkspaceID]
+	if !ok {
+		traffic = &TenantTraffic{WorkspaceID: tenant.WorkspaceID.String()}
+		tenantTraffic.workspaces[tenant.WorkspaceID] = traffic
+	}
+	traffic.Requests++
+	if status >= http.StatusInternalServerError {
+		traffic.Errors++
+	}
+	if tenant.Blocked {
+		traffic.Blocked++
+	}
+	traffic.totalLatency += latency
+	traffic.MeanLatencyMs = float64(traffic.totalLatency.Microseconds()) / 1000 / float64(traffic.Requests)
+	traffic.LastRequestAt = time.Now()
+}
+
+// ListTenantTraffic returns the traffic of a workspace, or of every workspace for uuid.Nil
+func ListTenantTraffic(workspaceID uuid.UUID) []TenantTraffic {
+	tenantTraffic.Lock()
+	defer tenantTraffic.Unlock()
+	traffic := []TenantTraffic{}
+	for id, workspace := range tenantTraffic.workspaces {
+		if workspaceID == uuid.Nil || id == workspaceID {
+			traffic = append(traffic, *workspace)
+		}
+	}
+	sort.Slice(traffic, func(i, j int) bool { return traffic[i].WorkspaceID < traffic[j].WorkspaceID })
+	return traffic
+}
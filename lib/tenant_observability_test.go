@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	defer func() { tenantTraffic.workspaces = nil }()
+	first, second := uuid.New(), uuid.New()
+	product := uuid.New()
+
+	var tags []string
+	handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/first":
+			setRequestTenant(r.Context(), first, product, uuid.Nil)
+			tags = TenantTags(r)
+		case "/blocked":
+			setRequestTenant(r.Context(), first, product, uuid.Nil)
+			MarkRuleViolation(r)
+			w.WriteHeader(http.StatusBadRequest)
+		case "/second":
+			setRequestTenant(r.Context(), second, product, uuid.Nil)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	for _, path := range []string{"/first", "/blocked", "/second", "/anonymous"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, []string{"workspace:" + first.String(), "product:" + product.String()}, tags)
+	traffic := ListTenantTraffic(first)
+	assert.Len(t, traffic, 1)
+	assert.Equal(t, int64(2), traffic[0].Requests)
+	assert.Equal(t, int64(1), traffic[0].Blocked)
+	assert.Equal(t, int64(0), traffic[0].Errors)
+	assert.Equal(t, int64(1), ListTenantTraffic(second)[0].Errors)
+	assert.Len(t, ListTenantTraffic(uuid.Nil), 2)
+
+	// Outside of the middleware there is no tenant to fill in
+	setRequestTenant(context.Background(), first, product, uuid.Nil)
+	assert.Nil(t, TenantTags(httptest.NewRequest("GET", "/", nil)))
+}
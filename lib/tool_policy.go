@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ToolAllow   = "allow"
+	ToolDeny    = "deny"
+	ToolApprove = "approve"
+
+	ToolApprovalPending  = "pending"
+	ToolApprovalApproved = "approved"
+	ToolApprovalRejected = "rejected"
+	ToolApprovalExpired  = "expired"
+
+	toolApprovalsRedisKey = "openshield:tool_approvals"
+)
+
+// toolApprovalPoll is how often a held response checks for a decision
+const toolApprovalPoll = 500 * time.Millisecond
+
+// ToolApproval is a proposed tool call waiting for, or decided by, an admin
+type ToolApproval struct {
+	ID        string    `json:"id"`
+	Tool      string    `json:"tool"`
+	Arguments string    `json:"arguments"`
+	Model     string    `json:"model"`
+	ApiKeyID  uuid.UUID `json:"api_key_id"`
+	Status    string    `json:"status"`
+	DecidedBy string    `json:"decided_by,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ErrToolApprovalNotFound is returned for unknown or already decided approvals
+var ErrToolApprovalNotFound = errors.New("tool approval not found or already decided")
+
+// toolApprovals holds the approvals when Redis isn't configured
+var toolApprovals = struct {
+	sync.Mutex
+	entries map[string]ToolApproval
+}{entries: map[string]ToolApproval{}}
+
+// GetToolPolicySettings returns the tool policy with defaults applied
+func GetToolPolicySettings() ToolPolicy {
+	settings := ToolPolicy{}
+	if policy := GetConfig().Settings.ToolPolicy; policy != nil {
+		settings = *policy
+	}
+	if settings.DefaultAction == "" {
+		settings.DefaultAction = ToolAllow
+	}
+	if settings.ApprovalTimeout <= 0 {
+		settings.ApprovalTimeout = 45
+	}
+	return settings
+}
+
+// CheckToolCall checks a proposed tool call against the policy of its tool and
+// returns the action to take. Calls whose arguments break the policy are denied.
+func CheckToolCall(policy ToolPolicy, name string, arguments string) (string, error) {
+	var rule *ToolRule
+	for i := range policy.Tools {
+		if policy.Tools[i].Name == name {
+			rule = &policy.Tools[i]
+			break
+		}
+	}
+	if rule == nil {
+		if policy.DefaultAction == ToolDeny {
+			return ToolDeny, fmt.Errorf("tool %s is not allowed", name)
+		}
+		return policy.DefaultAction, nil
+	}
+	if rule.Action == ToolDeny {
+		return ToolDeny, fmt.Errorf("tool %s is not allowed", name)
+	}
+
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return ToolDeny, fmt.Errorf("arguments of tool %s are not a JSON object", name)
+	}
+	for _, argument := range rule.Arguments {
+		value, ok := args[argument.Name]
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile(argument.Pattern)
+		if err != nil {
+			return ToolDeny, fmt.Errorf("invalid pattern for argument %s of tool %s: %v", argument.Name, name, err)
+		}
+		if !re.MatchString(fmt.Sprint(value)) {
+			return ToolDeny, fmt.Errorf("argument %s of tool %s does not match %s", argument.Name, name, argument.Pattern)
+		}
+	}
+	if rule.Schema != "" {
+		var schema map[string]interface{}
+		if err := json.Unmarshal([]byte(rule.Schema), &schema); err != nil {
+			return ToolDeny, fmt.Errorf("invalid schema for tool %s: %v", name, err)
+		}
+		if err := ValidateJSONSchema(schema, args); err != nil {
+			return ToolDeny, fmt.Errorf("arguments of tool %s: %v", name, err)
+		}
+	}
+
+	if rule.Action == "" {
+		return ToolAllow, nil
+	}
+	return rule.Action, nil
+}
+
+// RequestToolApproval stores a pending approval and waits until an admin decides
+// or the approval timeout passes. It returns the final status.
+func RequestToolApproval(ctx context.Context, approval ToolApproval) (string, error) {
+	timeout := time.Duration(GetToolPolicySettings().ApprovalTimeout) * time.Second
+	approval.ID = uuid.NewString()
+	approval.Status = ToolApprovalPending
+	approval.CreatedAt = time.Now().UTC()
+	approval.ExpiresAt = approval.CreatedAt.Add(timeout)
+	if err := saveToolApproval(ctx, approval); err != nil {
+		return "", err
+	}
+	defer deleteToolApproval(approval.ID)
+
+	ticker := time.NewTicker(toolApprovalPoll)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline.C:
+			return ToolApprovalExpired, nil
+		case <-ticker.C:
+			current, ok, err := loadToolApproval(ctx, approval.ID)
+			if err != nil {
+				return "", err
+			}
+			if ok && current.Status != ToolApprovalPending {
+				return current.Status, nil
+			}
+		}
+	}
+}
+
+// DecideToolApproval approves or rejects a pending tool call
+func DecideToolApproval(ctx context.Context, id string, approved bool, operator string, reason string) (ToolApproval, error) {
+	approval, ok, err := loadToolApproval(ctx, id)
+	if err != nil {
+		return ToolApproval{}, err
+	}
+	if !ok || approval.Status != ToolApprovalPending {
+		return ToolApproval{}, ErrToolApprovalNotFound
+	}
+
+	approval.Status = ToolApprovalRejected
+	if approved {
+		approval.Status = ToolApprovalApproved
+	}
+	approval.DecidedBy = operator
+	approval.Reason = reason
+	return approval, saveToolApproval(ctx, approval)
+}
+
+// PendingToolApprovals returns the tool calls waiting for a decision, oldest first
+func PendingToolApprovals(ctx context.Context) ([]ToolApproval, error) {
+	var approvals []ToolApproval
+	if RedisConfigured() {
+		entries, err := RedisClient().HGetAll(ctx, toolApprovalsRedisKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			var approval ToolApproval
+			if json.Unmarshal([]byte(entry), &approval) == nil {
+				approvals = append(approvals, approval)
+			}
+		}
+	} else {
+		toolApprovals.Lock()
+		for _, approval := range toolApprovals.entries {
+			approvals = append(approvals, approval)
+		}
+		toolApprovals.Unlock()
+	}
+
+	pending := approvals[:0]
+	now := time.Now()
+	for _, approval := range approvals {
+		if approval.Status == ToolApprovalPending && now.Before(approval.ExpiresAt) {
+			pending = append(pending, approval)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	return pending, nil
+}
+
+func saveToolApproval(ctx context.Context, approval ToolApproval) error {
+	if !RedisConfigured() {
+		toolApprovals.Lock()
+		toolApprovals.entries[approval.ID] = approval
+		toolApprovals.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return err
+	}
+	return RedisClient().HSet(ctx, toolApprovalsRedisKey, approval.ID, data).Err()
+}
+
+func loadToolApproval(ctx context.Context, id string) (ToolApproval, bool, error) {
+	if !RedisConfigured() {
+		toolApprovals.Lock()
+		defer toolApprovals.Unlock()
+		approval, ok := toolApprovals.entries[id]
+		return approval, ok, nil
+	}
+	data, err := RedisClient().HGet(ctx, toolApprovalsRedisKey, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return ToolApproval{}, false, nil
+	}
+	if err != nil {
+		return ToolApproval{}, false, err
+	}
+	var approval ToolApproval
+	if err := json.Unmarshal([]byte(data), &approval); err != nil {
+		return ToolApproval{}, false, err
+	}
+	return approval, true, nil
+}
+
+func deleteToolApproval(id string) {
+	if !RedisConfigured() {
+		toolApprovals.Lock()
+		delete(toolApprovals.entries, id)
+		toolApprovals.Unlock()
+		return
+	}
+	RedisClient().HDel(context.Background(), toolApprovalsRedisKey, id)
+}
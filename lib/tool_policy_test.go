@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckToolCall(t *testing.T) {
+	policy := ToolPolicy{
+		Enabled:       true,
+		DefaultAction: ToolDeny,
+		Tools: []ToolRule{
+			{Name: "run_shell", Action: ToolApprove, Arguments: []ArgumentRule{{Name: "command", Pattern: "^ls "}}},
+			{Name: "read_file", Schema: `{"type": "object", "required": ["path"], "additionalProperties": false, "properties": {"path": {"type": "string", "pattern": "^/srv/"}}}`},
+		},
+	}
+
+	action, err := CheckToolCall(policy, "read_file", `{"path": "/srv/data.csv"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, ToolAllow, action)
+
+	action, err = CheckToolCall(policy, "read_file", `{"path": "/etc/passwd"}`)
+	assert.Error(t, err)
+	assert.Equal(t, ToolDeny, action)
+
+	action, _ = CheckToolCall(policy, "read_file", `{"path": "/srv/a", "mode": "w"}`)
+	assert.Equal(t, ToolDeny, action)
+
+	action, err = CheckToolCall(policy, "run_shell", `{"command": "ls -la"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, ToolApprove, action)
+
+	action, _ = CheckToolCall(policy, "run_shell", `{"command": "rm -rf /"}`)
+	assert.Equal(t, ToolDeny, action)
+
+	action, _ = CheckToolCall(policy, "send_email", `{}`)
+	assert.Equal(t, ToolDeny, action)
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"count": map[string]interface{}{"type": "integer", "minimum": float64(1), "maximum": float64(10)},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"enum": []interface{}{"a", "b"}}},
+		},
+	}
+	assert.NoError(t, ValidateJSONSchema(schema, map[string]interface{}{"count": float64(3), "tags": []interface{}{"a"}}))
+	assert.Error(t, ValidateJSONSchema(schema, map[string]interface{}{"count": 2.5}))
+	assert.Error(t, ValidateJSONSchema(schema, map[string]interface{}{"count": float64(11)}))
+	assert.Error(t, ValidateJSONSchema(schema, map[string]interface{}{"tags": []interface{}{"c"}}))
+}
+
+func TestToolApproval(t *testing.T) {
+	defer func(redis *RedisConfig) { AppConfig.Settings.Redis = redis }(AppConfig.Settings.Redis)
+	AppConfig.Settings.Redis = nil
+
+	done := make(chan string)
+	go func() {
+		status, _ := RequestToolApproval(context.Background(), ToolApproval{Tool: "run_shell"})
+		done <- status
+	}()
+
+	var pending []ToolApproval
+	assert.Eventually(t, func() bool {
+		pending, _ = PendingToolApprovals(context.Background())
+		return len(pending) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := DecideToolApproval(context.Background(), pending[0].ID, true, "admin", "checked")
+	assert.NoError(t, err)
+	assert.Equal(t, ToolApprovalApproved, <-done)
+
+	_, err = DecideToolApproval(context.Background(), pending[0].ID, true, "admin", "")
+	assert.ErrorIs(t, err, ErrToolApprovalNotFound)
+}
@@ -0,0 +1,291 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const maxKeptTraces = 100
+
+// Trace is the trace of one request. It is kept when the head sampler picked
+// it, or at the end of the request when it failed or a rule blocked it.
+type Trace struct {
+	TraceID   string        `json:"trace_id"`
+	Route     string        `json:"route"`
+	Method    string        `json:"method"`
+	Status    int           `json:"status"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+	Sampled   bool          `json:"sampled"`
+	Violation bool          `json:"violation"`
+	Reason    string        `json:"reason"`
+	Workspace string        `json:"workspace_id,omitempty"`
+	Product   string        `json:"product_id,omitempty"`
+	// SpanID is the span of the request, ParentID the span of the caller from its traceparent header
+	SpanID   string `json:"span_id"`
+	ParentID string `json:"parent_id,omitempty"`
+	Spans    []Span `json:"spans,omitempty"`
+	children *traceSpans
+}
+
+// Span is a stage of a traced request, such as the input rules or the upstream
+// call, a child of the request span or of another span
+type Span struct {
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id"`
+	Name       string            `json:"name"`
+	Client     bool              `json:"client,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration"`
+	Error      string            `json:"error,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	trace      *Trace
+}
+
+// traceSpans collects the ended spans of a trace, which end concurrently when
+// a request calls upstreams in parallel
+type traceSpans struct {
+	sync.Mutex
+	spans []Span
+}
+
+// TracingStats counts the sampling decisions of this replica
+type TracingStats struct {
+	Requests  int64 `json:"requests"`
+	Sampled   int64 `json:"sampled"`
+	Errors    int64 `json:"errors"`
+	Violation int64 `json:"violations"`
+	Dropped   int64 `json:"dropped"`
+}
+
+var tracing struct {
+	sync.Mutex
+	stats  TracingStats
+	traces []Trace
+}
+
+// GetTracingSettings returns the tracing settings with defaults applied
+func GetTracingSettings() Tracing {
+	if settings := GetConfig().Settings.Tracing; settings != nil {
+		return *settings
+	}
+	return Tracing{SampleRate: 0.1, SampleErrors: true, SampleViolations: true}
+}
+
+// headSampled decides whether to keep a trace when the request starts. A
+// sampled flag from the caller's traceparent header is followed, so traces
+// aren't cut in half between services.
+func headSampled(r *http.Request, rate float64) bool {
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[3]) == 2 {
+		return parts[3][1]&1 == 1
+	}
+	return mathrand.Float64() < math.Min(rate, 1)
+}
+
+// traceID returns the trace ID of the caller's traceparent header or a new one
+func traceID(r *http.Request) string {
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	return randomHex(16)
+}
+
+// parentSpanID returns the span ID of the caller's traceparent header
+func parentSpanID(r *http.Request) string {
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		return parts[2]
+	}
+	return ""
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// StartSpan starts a span of the trace of ctx, a child of the span ctx is in.
+// It returns the context of the span, and the span to end. Without a trace the
+// span is nil, and its methods do nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	trace, ok := ctx.Value("trace").(*Trace)
+	if !ok {
+		return ctx, nil
+	}
+	parent, ok := ctx.Value("span").(string)
+	if !ok {
+		parent = trace.SpanID
+	}
+	span := &Span{SpanID: randomHex(8), ParentID: parent, Name: name, Start: time.Now(), trace: trace}
+	return context.WithValue(ctx, "span", span.SpanID), span
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// End ends the span, failed with err if it isn't nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.Duration = time.Since(s.Start)
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.trace.children.Lock()
+	s.trace.children.spans = append(s.trace.children.spans, *s)
+	s.trace.children.Unlock()
+}
+
+// Traceparent returns the traceparent header of a call made in span, which
+// continues the trace at the callee
+func (s *Span) Traceparent() string {
+	flags := "00"
+	if s.trace.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.trace.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// MarkRuleViolation records that a rule blocked the request, so its trace is
+// kept and the block counts in the traffic of its workspace
+func MarkRuleViolation(r *http.Request) {
+	if trace, ok := r.Context().Value("trace").(*Trace); ok {
+		trace.Violation = true
+	}
+	if tenant := GetRequestTenant(r); tenant != nil {
+		tenant.Blocked = true
+	}
+}
+
+// TracingMiddleware traces requests with head sampling at sample_rate, and
+// keeps the traces of failed and blocked requests whatever the head decision.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := GetTracingSettings()
+		if !settings.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		trace := &Trace{
+			TraceID:  traceID(r),
+			Method:   r.Method,
+			Start:    time.Now(),
+			Sampled:  headSampled(r, settings.SampleRate),
+			SpanID:   randomHex(8),
+			ParentID: parentSpanID(r),
+			children: &traceSpans{},
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(context.WithValue(r.Context(), "trace", trace))
+		next.ServeHTTP(recorder, r)
+
+		trace.Duration = time.Since(trace.Start)
+		trace.Status = recorder.status
+		trace.Route = r.URL.Path
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			trace.Route = routeContext.RoutePattern()
+		}
+		if tenant := GetRequestTenant(r); tenant != nil && tenant.WorkspaceID != uuid.Nil {
+			trace.Workspace, trace.Product = tenant.WorkspaceID.String(), tenant.ProductID.String()
+		}
+		finishTrace(settings, trace)
+	})
+}
+
+// finishTrace makes the tail sampling decision and keeps the trace if it passes
+func finishTrace(settings Tracing, trace *Trace) {
+	if trace.children != nil {
+		trace.children.Lock()
+		trace.Spans = append([]Span(nil), trace.children.spans...)
+		trace.children.Unlock()
+	}
+
+	tracing.Lock()
+	defer tracing.Unlock()
+	tracing.stats.Requests++
+	switch {
+	case trace.Sampled:
+		tracing.stats.Sampled++
+		trace.Reason = "head"
+	case settings.SampleErrors && trace.Status >= http.StatusInternalServerError:
+		tracing.stats.Errors++
+		trace.Reason = "error"
+	case settings.SampleViolations && trace.Violation:
+		tracing.stats.Violation++
+		trace.Reason = "violation"
+	default:
+		tracing.stats.Dropped++
+		return
+	}
+	tracing.traces = append(tracing.traces, *trace)
+	exportTrace(*trace)
+	if len(tracing.traces) > maxKeptTraces {
+		tracing.traces = tracing.traces[len(tracing.traces)-maxKeptTraces:]
+	}
+}
+
+// ListTraces returns the sampling counts and the latest kept traces, newest
+// first. With a workspace only its traces are returned.
+func ListTraces(workspaceID uuid.UUID) (TracingStats, []Trace) {
+	tracing.Lock()
+	defer tracing.Unlock()
+	traces := make([]Trace, 0, len(tracing.traces))
+	for i := len(tracing.traces) - 1; i >= 0; i-- {
+		if workspaceID != uuid.Nil && tracing.traces[i].Workspace != workspaceID.String() {
+			continue
+		}
+		traces = append(traces, tracing.traces[i])
+	}
+	return tracing.stats, traces
+}
+
+// tracingTransport records the calls to upstreams as client spans of the trace
+// of their request, and passes the trace on in the traceparent header
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartSpan(req.Context(), "upstream "+req.URL.Host)
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	span.Client = true
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Traceparent())
+
+	resp, err := t.next.RoundTrip(req)
+	spanErr := err
+	if resp != nil {
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+		if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+			spanErr = fmt.Errorf("upstream answered %s", resp.Status)
+		}
+	}
+	span.End(spanErr)
+	return resp, err
+}
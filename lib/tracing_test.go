@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	settings := AppConfig.Settings.Tracing
+	defer func() {
+		AppConfig.Settings.Tracing = settings
+		tracing.stats, tracing.traces = TracingStats{}, nil
+	}()
+	AppConfig.Settings.Tracing = &Tracing{Enabled: true, SampleRate: 0, SampleErrors: true, SampleViolations: true}
+
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/blocked":
+			MarkRuleViolation(r)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	send := func(path string, traceparent string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("/ok", "")
+	send("/error", "")
+	send("/blocked", "")
+	send("/ok", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	stats, traces := ListTraces(uuid.Nil)
+	assert.Equal(t, TracingStats{Requests: 4, Sampled: 1, Errors: 1, Violation: 1, Dropped: 1}, stats)
+	assert.Len(t, traces, 3)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traces[0].TraceID)
+	assert.Equal(t, "head", traces[0].Reason)
+	assert.Equal(t, "violation", traces[1].Reason)
+	assert.Equal(t, "error", traces[2].Reason)
+}
+
+func TestTraceSpans(t *testing.T) {
+	settings := AppConfig.Settings.Tracing
+	defer func() {
+		AppConfig.Settings.Tracing = settings
+		tracing.stats, tracing.traces = TracingStats{}, nil
+	}()
+	AppConfig.Settings.Tracing = &Tracing{Enabled: true, SampleRate: 0}
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &tracingTransport{next: http.DefaultTransport}}
+
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := StartSpan(r.Context(), "rules.input")
+		req, _ := http.NewRequestWithContext(ctx, "POST", upstream.URL+"/chat/completions", nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		span.End(nil)
+	}))
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	_, traces := ListTraces(uuid.Nil)
+	assert.Len(t, traces, 1)
+	trace := traces[0]
+	assert.Equal(t, "00f067aa0ba902b7", trace.ParentID)
+	assert.Len(t, trace.Spans, 2)
+	call, rules := trace.Spans[0], trace.Spans[1]
+	assert.Equal(t, "rules.input", rules.Name)
+	assert.Equal(t, trace.SpanID, rules.ParentID)
+	assert.True(t, call.Client)
+	assert.Equal(t, rules.SpanID, call.ParentID)
+	assert.Equal(t, "200", call.Attributes["http.status_code"])
+	// The provider continues the trace from the upstream call span
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+call.SpanID+"-01", upstreamTraceparent)
+
+	spans := otlpSpans(trace)
+	assert.Len(t, spans, 3)
+	assert.Equal(t, otlpSpanKindServer, spans[0].Kind)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+	assert.Equal(t, otlpSpanKindClient, spans[1].Kind)
+	assert.Equal(t, trace.TraceID, spans[2].TraceID)
+
+	// Without a trace spans record nothing
+	_, span := StartSpan(context.Background(), "cache.get")
+	assert.Nil(t, span)
+	span.SetAttribute("openshield.cache_hit", "false")
+	span.End(nil)
+}
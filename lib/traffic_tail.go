@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// Verdicts of tail events
+const (
+	VerdictAllowed = "allowed"
+	VerdictBlocked = "blocked"
+	VerdictError   = "error"
+)
+
+const tailBuffer = 100
+
+// TailEvent summarizes a finished request for the traffic tail. It never holds
+// content or credentials, the API key is its ID.
+type TailEvent struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Method      string    `json:"method"`
+	Route       string    `json:"route"`
+	Status      int       `json:"status"`
+	LatencyMs   float64   `json:"latency_ms"`
+	APIKeyID    string    `json:"api_key_id,omitempty"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	ProductID   string    `json:"product_id,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Verdict     string    `json:"verdict"`
+}
+
+// TailFilter selects the events of a tail subscription, empty fields match everything
+type TailFilter struct {
+	APIKeyID    string
+	WorkspaceID string
+	Model       string
+	Verdict     string
+	MinLatency  time.Duration
+}
+
+// TailSubscription receives the events matching its filter. Events are dropped
+// rather than slowing requests down when the subscriber falls behind.
+type TailSubscription struct {
+	Events  chan TailEvent
+	filter  TailFilter
+	dropped int64
+}
+
+var tail struct {
+	sync.Mutex
+	subscriptions map[*TailSubscription]struct{}
+}
+
+// Matches reports whether an event passes the filter
+func (f TailFilter) Matches(event TailEvent) bool {
+	return (f.APIKeyID == "" || f.APIKeyID == event.APIKeyID) &&
+		(f.WorkspaceID == "" || f.WorkspaceID == event.WorkspaceID) &&
+		(f.Model == "" || f.Model == event.Model) &&
+		(f.Verdict == "" || f.Verdict == event.Verdict) &&
+		float64(f.MinLatency.Microseconds())/1000 <= event.LatencyMs
+}
+
+// SubscribeTail starts a tail subscription, it must be ended with UnsubscribeTail
+func SubscribeTail(filter TailFilter) *TailSubscription {
+	subscription := &TailSubscription{Events: make(chan TailEvent, tailBuffer), filter: filter}
+	tail.Lock()
+	defer tail.Unlock()
+	if tail.subscriptions == nil {
+		tail.subscriptions = map[*TailSubscription]struct{}{}
+	}
+	tail.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+// UnsubscribeTail ends a tail subscription and returns the number of events it dropped
+func UnsubscribeTail(subscription *TailSubscription) int64 {
+	tail.Lock()
+	defer tail.Unlock()
+	delete(tail.subscriptions, subscription)
+	return subscription.dropped
+}
+
+// publishTail sends the summary of a finished request to the subscriptions
+func publishTail(r *http.Request, tenant *RequestTenant, status int, latency time.Duration) {
+	tail.Lock()
+	defer tail.Unlock()
+	if len(tail.subscriptions) == 0 {
+		return
+	}
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	event := TailEvent{
+		Time:      time.Now().UTC(),
+		RequestID: middleware.GetReqID(r.Context()),
+		Method:    r.Method,
+		Route:     r.URL.Path,
+		Status:    status,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		Model:     tenant.Model,
+		Verdict:   VerdictAllowed,
+	}
+	if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+		event.Route = routeContext.RoutePattern()
+	}
+	if tenant.APIKeyID != uuid.Nil {
+		event.APIKeyID = tenant.APIKeyID.String()
+	}
+	if tenant.WorkspaceID != uuid.Nil {
+		event.WorkspaceID, event.ProductID = tenant.WorkspaceID.String(), tenant.ProductID.String()
+	}
+	if tenant.Blocked {
+		event.Verdict = VerdictBlocked
+	} else if status >= http.StatusInternalServerError {
+		event.Verdict = VerdictError
+	}
+
+	for subscription := range tail.subscriptions {
+		if !subscription.filter.Matches(event) {
+			continue
+		}
+		select {
+		case subscription.Events <- event:
+		default:
+			subscription.dropped++
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficTail(t *testing.T) {
+	defer func() { tenantTraffic.workspaces = nil }()
+	workspace, apiKey := uuid.New(), uuid.New()
+	handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRequestTenant(r.Context(), workspace, uuid.New(), apiKey)
+		SetRequestModel(r, r.URL.Query().Get("model"))
+		if r.URL.Query().Get("block") != "" {
+			MarkRuleViolation(r)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	all := SubscribeTail(TailFilter{})
+	blocked := SubscribeTail(TailFilter{Verdict: VerdictBlocked, WorkspaceID: workspace.String()})
+	other := SubscribeTail(TailFilter{WorkspaceID: uuid.New().String()})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/chat?model=gpt-4o", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/chat?model=gpt-4o&block=1", nil))
+
+	assert.Len(t, all.Events, 2)
+	assert.Len(t, blocked.Events, 1)
+	assert.Len(t, other.Events, 0)
+	event := <-blocked.Events
+	assert.Equal(t, VerdictBlocked, event.Verdict)
+	assert.Equal(t, apiKey.String(), event.APIKeyID)
+	assert.Equal(t, "gpt-4o", event.Model)
+	assert.Equal(t, http.StatusBadRequest, event.Status)
+
+	assert.Zero(t, UnsubscribeTail(all))
+	UnsubscribeTail(blocked)
+	UnsubscribeTail(other)
+	assert.Empty(t, tail.subscriptions)
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	spoolFilePattern = "openshield-upload-*"
+	// spoolCleanInterval is how often each replica cleans its spool directory
+	spoolCleanInterval = 10 * time.Minute
+)
+
+var (
+	// ErrUploadTooLarge is returned for uploads larger than max_bytes
+	ErrUploadTooLarge = errors.New("upload is too large")
+	// ErrSpoolFull is returned when the spooled uploads take max_spool_bytes
+	ErrSpoolFull = errors.New("too many uploads in progress")
+)
+
+var spool struct {
+	mu    sync.Mutex
+	bytes int64
+}
+
+// GetUploadBufferingSettings returns the upload buffering settings with defaults applied
+func GetUploadBufferingSettings() UploadBuffering {
+	settings := UploadBuffering{}
+	if buffering := GetConfig().Settings.UploadBuffering; buffering != nil {
+		settings = *buffering
+	}
+	if settings.MemoryBytes <= 0 {
+		settings.MemoryBytes = 4 << 20
+	}
+	if settings.MaxBytes <= 0 {
+		settings.MaxBytes = 25 << 20
+	}
+	if settings.MaxSpoolBytes <= 0 {
+		settings.MaxSpoolBytes = 1 << 30
+	}
+	if settings.Dir == "" {
+		settings.Dir = os.TempDir()
+	}
+	return settings
+}
+
+// BufferedBody is an upload held in memory or, past memory_bytes, in a
+// temporary file. Close removes the file, it must be deferred by the caller.
+type BufferedBody struct {
+	memory   []byte
+	file     *os.File
+	size     int64
+	reserved int64
+}
+
+// BufferBody reads an upload, keeping at most memory_bytes of it in memory.
+// A larger upload is written to a temporary file, which reserves max_bytes of
+// the spool up front, or the content length when the client sent one.
+func BufferBody(body io.Reader, contentLength int64) (*BufferedBody, error) {
+	settings := GetUploadBufferingSettings()
+	if contentLength > settings.MaxBytes {
+		return nil, ErrUploadTooLarge
+	}
+
+	head, err := io.ReadAll(io.LimitReader(body, settings.MemoryBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(head)) <= settings.MemoryBytes {
+		return &BufferedBody{memory: head, size: int64(len(head))}, nil
+	}
+
+	reserved := settings.MaxBytes
+	if contentLength > 0 {
+		reserved = contentLength
+	}
+	if !reserveSpool(reserved, settings.MaxSpoolBytes) {
+		return nil, ErrSpoolFull
+	}
+	buffered := &BufferedBody{reserved: reserved}
+	buffered.file, err = os.CreateTemp(settings.Dir, spoolFilePattern)
+	if err != nil {
+		buffered.Close()
+		return nil, err
+	}
+
+	limit := reserved + 1
+	buffered.size, err = io.Copy(buffered.file, io.LimitReader(io.MultiReader(bytes.NewReader(head), body), limit))
+	if err == nil && buffered.size >= limit {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		buffered.Close()
+		return nil, err
+	}
+	return buffered, nil
+}
+
+// Size is the length of the upload in bytes
+func (b *BufferedBody) Size() int64 {
+	return b.size
+}
+
+// Spooled reports whether the upload is held in a temporary file
+func (b *BufferedBody) Spooled() bool {
+	return b.file != nil
+}
+
+// Reader returns a reader from the start of the upload. Readers share the
+// temporary file, so only the last one returned may be used.
+func (b *BufferedBody) Reader() (io.Reader, error) {
+	if b.file == nil {
+		return bytes.NewReader(b.memory), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(b.file, b.size), nil
+}
+
+// Close removes the temporary file and gives its space back to the spool
+func (b *BufferedBody) Close() error {
+	var err error
+	if b.file != nil {
+		b.file.Close()
+		err = os.Remove(b.file.Name())
+		b.file = nil
+	}
+	if b.reserved > 0 {
+		releaseSpool(b.reserved)
+		b.reserved = 0
+	}
+	b.memory = nil
+	return err
+}
+
+func reserveSpool(size int64, limit int64) bool {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+	if spool.bytes+size > limit {
+		return false
+	}
+	spool.bytes += size
+	return true
+}
+
+func releaseSpool(size int64) {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+	spool.bytes -= size
+}
+
+// RunUploadSpoolCleaner cleans the spool directory until ctx is done. Every
+// replica spools to its own disk, so it runs on each of them rather than as a
+// job of the leader.
+func RunUploadSpoolCleaner(ctx context.Context) {
+	ticker := time.NewTicker(spoolCleanInterval)
+	defer ticker.Stop()
+	for {
+		if err := cleanUploadSpool(); err != nil {
+			log.Printf("Error cleaning the upload spool: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanUploadSpool removes the spool files left behind by a process that didn't
+// get to close them. Uploads can't take longer than the request timeout, so
+// files older than an hour aren't in use.
+func cleanUploadSpool() error {
+	files, err := filepath.Glob(filepath.Join(GetUploadBufferingSettings().Dir, spoolFilePattern))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		os.Remove(file)
+	}
+	return nil
+}
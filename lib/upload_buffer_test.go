@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferBody(t *testing.T) {
+	settings := AppConfig.Settings.UploadBuffering
+	defer func() { AppConfig.Settings.UploadBuffering = settings }()
+	dir := t.TempDir()
+	AppConfig.Settings.UploadBuffering = &UploadBuffering{MemoryBytes: 8, MaxBytes: 32, MaxSpoolBytes: 40, Dir: dir}
+
+	small, err := BufferBody(strings.NewReader("tiny"), -1)
+	assert.NoError(t, err)
+	assert.False(t, small.Spooled())
+	small.Close()
+
+	large, err := BufferBody(strings.NewReader("a body larger than memory"), -1)
+	assert.NoError(t, err)
+	assert.True(t, large.Spooled())
+	assert.Equal(t, int64(25), large.Size())
+	reader, err := large.Reader()
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(reader)
+	assert.Equal(t, "a body larger than memory", string(content))
+
+	// The first upload reserved max_bytes, a second one doesn't fit the spool
+	_, err = BufferBody(strings.NewReader("another large body"), -1)
+	assert.ErrorIs(t, err, ErrSpoolFull)
+
+	assert.NoError(t, large.Close())
+	files, _ := os.ReadDir(dir)
+	assert.Empty(t, files)
+
+	_, err = BufferBody(bytes.NewReader(make([]byte, 33)), -1)
+	assert.ErrorIs(t, err, ErrUploadTooLarge)
+	_, err = BufferBody(strings.NewReader(""), 64)
+	assert.ErrorIs(t, err, ErrUploadTooLarge)
+	files, _ = os.ReadDir(dir)
+	assert.Empty(t, files)
+	assert.Zero(t, spool.bytes)
+}
+
+func TestRunUploadSpoolCleaner(t *testing.T) {
+	settings := AppConfig.Settings.UploadBuffering
+	defer func() { AppConfig.Settings.UploadBuffering = settings }()
+	dir := t.TempDir()
+	AppConfig.Settings.UploadBuffering = &UploadBuffering{Dir: dir}
+
+	stale := filepath.Join(dir, "openshield-upload-stale")
+	fresh := filepath.Join(dir, "openshield-upload-fresh")
+	for _, file := range []string{stale, fresh} {
+		require.NoError(t, os.WriteFile(file, []byte("upload"), 0o600))
+	}
+	require.NoError(t, os.Chtimes(stale, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+
+	// Each replica cleans its own spool when it starts, leader or not
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	RunUploadSpoolCleaner(ctx)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, fresh)
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// CheckURL validates a URL the gateway is about to fetch or forward against the
+// configured URL policy. Internal, loopback and link-local addresses are
+// rejected unless settings.url_policy.allow_private_networks is set.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+
+	switch u.Scheme {
+	case "data":
+		// Inline content is never fetched
+		return nil
+	case "http", "https":
+	default:
+		return fmt.Errorf("url scheme %q is not allowed", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("url has no host")
+	}
+
+	policy := GetConfig().Settings.URLPolicy
+	if policy != nil && len(policy.AllowedDomains) > 0 && !domainAllowed(host, policy.AllowedDomains) {
+		return fmt.Errorf("url host %s is not in the allowed domains", host)
+	}
+
+	if policy != nil && policy.AllowPrivateNetworks {
+		return nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve url host %s: %v", host, err)
+		}
+	}
+
+	for _, ip := range ips {
+		if isInternalIP(ip) {
+			return fmt.Errorf("url host %s resolves to an internal address", host)
+		}
+	}
+
+	return nil
+}
+
+func domainAllowed(host string, allowedDomains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckURL(t *testing.T) {
+	defer func(policy *URLPolicy) { AppConfig.Settings.URLPolicy = policy }(AppConfig.Settings.URLPolicy)
+
+	testCases := []struct {
+		name      string
+		policy    *URLPolicy
+		url       string
+		expectErr bool
+	}{
+		{name: "Public IP", url: "https://8.8.8.8/image.png"},
+		{name: "Data URL", url: "data:image/png;base64,iVBORw0KGgo="},
+		{name: "Loopback", url: "http://127.0.0.1/image.png", expectErr: true},
+		{name: "Private range", url: "http://10.0.0.5/image.png", expectErr: true},
+		{name: "Link-local metadata", url: "http://169.254.169.254/latest/meta-data", expectErr: true},
+		{name: "IPv6 loopback", url: "http://[::1]/image.png", expectErr: true},
+		{name: "Unsupported scheme", url: "file:///etc/passwd", expectErr: true},
+		{
+			name:   "Private allowed",
+			policy: &URLPolicy{AllowPrivateNetworks: true},
+			url:    "http://10.0.0.5/image.png",
+		},
+		{
+			name:      "Domain not allowlisted",
+			policy:    &URLPolicy{AllowedDomains: []string{"example.com"}, AllowPrivateNetworks: true},
+			url:       "https://images.other.org/a.png",
+			expectErr: true,
+		},
+		{
+			name:   "Subdomain allowlisted",
+			policy: &URLPolicy{AllowedDomains: []string{"*.example.com"}, AllowPrivateNetworks: true},
+			url:    "https://cdn.example.com/a.png",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			AppConfig.Settings.URLPolicy = tc.policy
+			err := CheckURL(tc.url)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -1,12 +1,77 @@
 package lib
 
 import (
-	"github.com/openshieldai/openshield/models"
+	"context"
+	"errors"
 	"log"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
 )
 
-func Usage(modelName string, predictedTokensCount int, promptTokensCount int, completionTokens int, totalTokens int, finishReason string, requestType string) {
+func Usage(ctx context.Context, modelName string, predictedTokensCount int, promptTokensCount int, completionTokens int, totalTokens int, finishReason string, requestType string) {
+	recordUsage(ctx, models.OpenAI, modelName, models.Usage{
+		PredictedTokensCount: predictedTokensCount,
+		PromptTokensCount:    promptTokensCount,
+		CompletionTokens:     completionTokens,
+		TotalTokens:          totalTokens,
+		FinishReason:         models.FinishReason(finishReason),
+		RequestType:          requestType,
+	})
+}
+
+// HashedUsage records usage with the content hashes of the provider exchange, when there are any
+func HashedUsage(ctx context.Context, hashes *ContentHashes, modelName string, predictedTokensCount int, promptTokensCount int, completionTokens int, totalTokens int, finishReason string, requestType string) {
+	ProviderUsage(ctx, models.OpenAI, hashes, modelName, predictedTokensCount, promptTokensCount, completionTokens, totalTokens, finishReason, requestType)
+}
+
+// ProviderUsage records the usage of a model of another provider than OpenAI
+func ProviderUsage(ctx context.Context, family models.AiFamily, hashes *ContentHashes, modelName string, predictedTokensCount int, promptTokensCount int, completionTokens int, totalTokens int, finishReason string, requestType string) {
+	usage := models.Usage{
+		PredictedTokensCount: predictedTokensCount,
+		PromptTokensCount:    promptTokensCount,
+		CompletionTokens:     completionTokens,
+		TotalTokens:          totalTokens,
+		FinishReason:         models.FinishReason(finishReason),
+		RequestType:          requestType,
+	}
+	if hashes != nil {
+		usage.HashSalt, usage.RequestHash, usage.ResponseHash = hashes.Sums()
+	}
+	recordUsage(ctx, family, modelName, usage)
+}
+
+// RacedUsage records the usage of one attempt of a request sent to two providers at once
+func RacedUsage(ctx context.Context, modelName string, predictedTokensCount int, promptTokensCount int, completionTokens int, totalTokens int, finishReason string, requestType string) {
+	recordUsage(ctx, models.OpenAI, modelName, models.Usage{
+		PredictedTokensCount: predictedTokensCount,
+		PromptTokensCount:    promptTokensCount,
+		CompletionTokens:     completionTokens,
+		TotalTokens:          totalTokens,
+		FinishReason:         models.FinishReason(finishReason),
+		RequestType:          requestType,
+		Raced:                true,
+	})
+}
+
+// CutShortFinishReason returns the finish reason of a stream of model that
+// ended without one: client_disconnect if the client of the request of ctx
+// went away, which is counted, null otherwise
+func CutShortFinishReason(ctx context.Context, model string) string {
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		return string(models.Null)
+	}
+	log.Printf("Client disconnected from a %s stream, recording the partial usage", model)
+	IncrCounter("streams.client_disconnected", 1, "model:"+model)
+	return string(models.ClientDisconnect)
+}
+
+func recordUsage(ctx context.Context, family models.AiFamily, modelName string, usage models.Usage) {
 	config := GetConfig()
+	usage.FinishReason = UsageFinishReason(string(family), string(usage.FinishReason))
+	RecordSpend(context.Background(), string(family), modelName, usage.PromptTokensCount, usage.CompletionTokens)
+	IncrCounter("tokens.prompt", int64(usage.PromptTokensCount), "model:"+modelName)
+	IncrCounter("tokens.completion", int64(usage.CompletionTokens), "model:"+modelName)
 
 	if config.Settings.UsageLogging.Enabled {
 		aiModel, err := GetModel(modelName)
@@ -15,17 +80,15 @@ func Usage(modelName string, predictedTokensCount int, promptTokensCount int, co
 			return
 		}
 
-		usage := models.Usage{
-			ModelID:              aiModel.Id,
-			PredictedTokensCount: predictedTokensCount,
-			PromptTokensCount:    promptTokensCount,
-			CompletionTokens:     completionTokens,
-			TotalTokens:          totalTokens,
-			FinishReason:         models.FinishReason(finishReason),
-			RequestType:          requestType,
+		usage.ModelID = aiModel.Id
+		// The API key and workspace are kept for the usage aggregates
+		if apiKeyID, ok := ctx.Value("apiKeyId").(uuid.UUID); ok {
+			usage.ApiKeyID = &apiKeyID
+		}
+		if workspaceID, ok := ctx.Value("workspaceId").(uuid.UUID); ok && workspaceID != uuid.Nil {
+			usage.WorkspaceID = &workspaceID
 		}
-		db := DB()
-		db.Create(&usage)
+		createOrQueue(queuedUsage, &usage)
 	} else {
 		log.Printf("Usage logs is disabled")
 		return
@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// ErrInvalidUsageQuery is returned for queries of unknown periods or dimensions
+var ErrInvalidUsageQuery = errors.New("invalid usage query")
+
+// usageAggregatePeriods are the bucket sizes of the usage aggregates
+var usageAggregatePeriods = []string{"hour", "day"}
+
+// usageAggregateDimensions maps the group_by names of the analytics API to columns
+var usageAggregateDimensions = map[string]string{
+	"api_key":   "api_key_id",
+	"workspace": "workspace_id",
+	"model":     "model_id",
+}
+
+// refreshUsageAggregatesSQL recomputes the buckets of a period starting at or
+// after @from. Buckets are replaced rather than incremented, so
+// runs overlapping earlier ones, or rows written late by the write queue, don't
+// count usage twice.
+const refreshUsageAggregatesSQL = `
+INSERT INTO usage_aggregates (period, bucket_start, api_key_id, workspace_id, model_id, requests, prompt_tokens, completion_tokens, total_tokens)
+SELECT @period, date_trunc(@period, created_at), COALESCE(api_key_id, @none), COALESCE(workspace_id, @none), model_id,
+	count(*), sum(prompt_tokens_count), sum(completion_tokens), sum(total_tokens)
+FROM usages
+WHERE deleted_at IS NULL AND created_at >= @from
+GROUP BY 2, 3, 4, 5
+ON CONFLICT (period, bucket_start, api_key_id, workspace_id, model_id) DO UPDATE SET
+	requests = EXCLUDED.requests,
+	prompt_tokens = EXCLUDED.prompt_tokens,
+	completion_tokens = EXCLUDED.completion_tokens,
+	total_tokens = EXCLUDED.total_tokens,
+	updated_at = now()`
+
+func init() {
+	RegisterJob(Job{
+		Name:     "refresh_usage_aggregates",
+		Interval: 5 * time.Minute,
+		Run:      refreshUsageAggregates,
+	})
+}
+
+// GetUsageAggregatesSettings returns the usage aggregates settings with defaults applied
+func GetUsageAggregatesSettings() UsageAggregates {
+	settings := UsageAggregates{}
+	if aggregates := GetConfig().Settings.UsageAggregates; aggregates != nil {
+		settings = *aggregates
+	}
+	if settings.LookbackMinutes <= 0 {
+		settings.LookbackMinutes = 120
+	}
+	return settings
+}
+
+// usageAggregateFrom returns the start of the first bucket of a period a refresh
+// at now recomputes
+func usageAggregateFrom(now time.Time, period string, lookback time.Duration) time.Time {
+	from := now.UTC().Add(-lookback)
+	if period == "day" {
+		return time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return from.Truncate(time.Hour)
+}
+
+// refreshUsageAggregates recomputes the hourly and daily buckets touched by the
+// usage rows of the last lookback_minutes. The first run, with no aggregates
+// yet, computes them from all the usage rows.
+func refreshUsageAggregates(ctx context.Context) error {
+	settings := GetUsageAggregatesSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	var existing int64
+	if err := DB().WithContext(ctx).Model(&models.UsageAggregates{}).Limit(1).Count(&existing).Error; err != nil {
+		return err
+	}
+	lookback := time.Duration(settings.LookbackMinutes) * time.Minute
+	now := time.Now()
+	for _, period := range usageAggregatePeriods {
+		from := usageAggregateFrom(now, period, lookback)
+		if existing == 0 {
+			from = time.Time{}
+		}
+		err := DB().WithContext(ctx).Exec(refreshUsageAggregatesSQL, map[string]interface{}{
+			"period": period,
+			"none":   uuid.Nil,
+			"from":   from,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to refresh %sly usage aggregates: %v", period, err)
+		}
+	}
+	return nil
+}
+
+// UsageAggregateQuery selects buckets of the usage aggregates. GroupBy lists
+// dimensions of usageAggregateDimensions the totals are split by, the others
+// are summed. WorkspaceID limits the buckets to a workspace unless it's uuid.Nil.
+type UsageAggregateQuery struct {
+	Period      string
+	From        time.Time
+	To          time.Time
+	GroupBy     []string
+	WorkspaceID uuid.UUID
+}
+
+// UsageAggregateRow is a bucket of the analytics API, with the dimensions it's split by
+type UsageAggregateRow struct {
+	BucketStart      time.Time  `json:"bucket_start"`
+	ApiKeyID         *uuid.UUID `json:"api_key_id,omitempty"`
+	WorkspaceID      *uuid.UUID `json:"workspace_id,omitempty"`
+	ModelID          *uuid.UUID `json:"model_id,omitempty"`
+	Requests         int64      `json:"requests"`
+	PromptTokens     int64      `json:"prompt_tokens"`
+	CompletionTokens int64      `json:"completion_tokens"`
+	TotalTokens      int64      `json:"total_tokens"`
+}
+
+// usageAggregateColumns returns the columns of the group_by dimensions
+func usageAggregateColumns(groupBy []string) ([]string, error) {
+	columns := []string{"bucket_start"}
+	for _, dimension := range groupBy {
+		column, ok := usageAggregateDimensions[strings.TrimSpace(dimension)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown group_by dimension %q", ErrInvalidUsageQuery, dimension)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// QueryUsageAggregates returns the buckets of a query in the order of their start
+func QueryUsageAggregates(ctx context.Context, query UsageAggregateQuery) ([]UsageAggregateRow, error) {
+	valid := false
+	for _, period := range usageAggregatePeriods {
+		valid = valid || period == query.Period
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w: period must be hour or day", ErrInvalidUsageQuery)
+	}
+	columns, err := usageAggregateColumns(query.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	db := DB().WithContext(ctx).Model(&models.UsageAggregates{}).
+		Select(strings.Join(columns, ", ")+", sum(requests) AS requests, sum(prompt_tokens) AS prompt_tokens, "+
+			"sum(completion_tokens) AS completion_tokens, sum(total_tokens) AS total_tokens").
+		Where("period = ? AND bucket_start >= ? AND bucket_start < ?", query.Period, query.From, query.To)
+	if query.WorkspaceID != uuid.Nil {
+		db = db.Where("workspace_id = ?", query.WorkspaceID)
+	}
+	var rows []UsageAggregateRow
+	err = db.Group(strings.Join(columns, ", ")).Order("bucket_start").Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageAggregateFrom(t *testing.T) {
+	now := time.Date(2024, 3, 1, 1, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC), usageAggregateFrom(now, "hour", 2*time.Hour))
+	assert.Equal(t, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), usageAggregateFrom(now, "day", 2*time.Hour),
+		"a lookback reaching into yesterday recomputes the whole day")
+}
+
+func TestUsageAggregateColumns(t *testing.T) {
+	columns, err := usageAggregateColumns([]string{"model", " api_key"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bucket_start", "model_id", "api_key_id"}, columns)
+
+	_, err = usageAggregateColumns([]string{"model_id; DROP TABLE usages"})
+	assert.ErrorIs(t, err, ErrInvalidUsageQuery)
+
+	_, err = QueryUsageAggregates(context.Background(), UsageAggregateQuery{Period: "week"})
+	assert.ErrorIs(t, err, ErrInvalidUsageQuery)
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// ImportedUsageArchive is the archive of the usage rollups imported from the
+// OpenAI usage API, which have no rows in cold storage
+const ImportedUsageArchive = "openai-usage-import"
+
+const defaultOpenAIUsageURL = "https://api.openai.com/v1"
+
+// openAIUsageEndpoints are the usage API endpoints imported, by the request
+// type their usage is recorded with
+var openAIUsageEndpoints = map[string]string{
+	"chat_completion": "/organization/usage/completions",
+	"embeddings":      "/organization/usage/embeddings",
+}
+
+// openAIUsagePage is a page of daily buckets of the OpenAI usage API
+type openAIUsagePage struct {
+	Data []struct {
+		StartTime int64 `json:"start_time"`
+		Results   []struct {
+			Model            string `json:"model"`
+			InputTokens      int64  `json:"input_tokens"`
+			OutputTokens     int64  `json:"output_tokens"`
+			NumModelRequests int64  `json:"num_model_requests"`
+		} `json:"results"`
+	} `json:"data"`
+	HasMore  bool   `json:"has_more"`
+	NextPage string `json:"next_page"`
+}
+
+// openAIUsage is the usage of a model on a day, as the OpenAI usage API reports it
+type openAIUsage struct {
+	Day          time.Time
+	Model        string
+	RequestType  string
+	Requests     int64
+	InputTokens  int64
+	OutputTokens int64
+}
+
+// UsageImportReport is the outcome of an import of OpenAI usage
+type UsageImportReport struct {
+	From    time.Time
+	To      time.Time
+	Rollups int
+	Models  []string
+	// Skipped is set when the import stopped at the first day OpenShield recorded usage
+	Skipped bool
+}
+
+// fetchOpenAIUsage reads the daily usage per model of an endpoint of the
+// OpenAI usage API from the first day up to the day before the last one
+func fetchOpenAIUsage(ctx context.Context, client *http.Client, baseURL string, adminKey string, requestType string, from time.Time, to time.Time) ([]openAIUsage, error) {
+	var usages []openAIUsage
+	page := ""
+	for {
+		query := url.Values{
+			"start_time":   {strconv.FormatInt(from.Unix(), 10)},
+			"end_time":     {strconv.FormatInt(to.Unix(), 10)},
+			"bucket_width": {"1d"},
+			"group_by":     {"model"},
+			"limit":        {"31"},
+		}
+		if page != "" {
+			query.Set("page", page)
+		}
+		endpoint := strings.TrimSuffix(baseURL, "/") + openAIUsageEndpoints[requestType] + "?" + query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("usage API answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+
+		var result openAIUsagePage
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("error decoding usage API response: %v", err)
+		}
+		for _, bucket := range result.Data {
+			day := time.Unix(bucket.StartTime, 0).UTC().Truncate(24 * time.Hour)
+			for _, usage := range bucket.Results {
+				if usage.Model == "" || usage.NumModelRequests == 0 {
+					continue
+				}
+				usages = append(usages, openAIUsage{
+					Day: day, Model: usage.Model, RequestType: requestType,
+					Requests: usage.NumModelRequests, InputTokens: usage.InputTokens, OutputTokens: usage.OutputTokens,
+				})
+			}
+		}
+		if !result.HasMore || result.NextPage == "" {
+			return usages, nil
+		}
+		page = result.NextPage
+	}
+}
+
+// firstRecordedUsageDay returns the first day OpenShield has usage of, from the
+// usage rows or the rollups of tiered rows, false if it has none
+func firstRecordedUsageDay(ctx context.Context) (time.Time, bool, error) {
+	var first struct{ Day *time.Time }
+	if err := DB().WithContext(ctx).Unscoped().Model(&models.Usage{}).Select("min(created_at) AS day").Scan(&first).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	var rolledUp struct{ Day *time.Time }
+	err := DB().WithContext(ctx).Model(&models.UsageRollups{}).Where("archive <> ?", ImportedUsageArchive).
+		Select("min(day) AS day").Scan(&rolledUp).Error
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if first.Day == nil || (rolledUp.Day != nil && rolledUp.Day.Before(*first.Day)) {
+		first.Day = rolledUp.Day
+	}
+	if first.Day == nil {
+		return time.Time{}, false, nil
+	}
+	return first.Day.UTC().Truncate(24 * time.Hour), true, nil
+}
+
+// ImportOpenAIUsage imports the daily usage of the OpenAI organization of
+// adminKey, an admin API key, from the first day to the day before the last one
+// as usage rollups, so reports show the usage from before the migration to
+// OpenShield. The import stops at the first day OpenShield recorded usage of,
+// the organization usage from then on includes the requests OpenShield sent.
+// Imported days are replaced when they are imported again, and models missing
+// from the catalog are added to it.
+func ImportOpenAIUsage(ctx context.Context, adminKey string, from time.Time, to time.Time) (UsageImportReport, error) {
+	report := UsageImportReport{From: from, To: to}
+	if first, ok, err := firstRecordedUsageDay(ctx); err != nil {
+		return report, err
+	} else if ok && first.Before(to) {
+		report.To, report.Skipped = first, true
+	}
+	if !report.From.Before(report.To) {
+		return report, nil
+	}
+
+	config := GetConfig()
+	client, err := ProviderHTTPClient(config.Providers.OpenAI)
+	if err != nil {
+		return report, err
+	}
+	baseURL := defaultOpenAIUsageURL
+	if config.Providers.OpenAI != nil && config.Providers.OpenAI.BaseURL != "" {
+		baseURL = config.Providers.OpenAI.BaseURL
+	}
+	var usages []openAIUsage
+	for _, requestType := range []string{"chat_completion", "embeddings"} {
+		fetched, err := fetchOpenAIUsage(ctx, client, baseURL, adminKey, requestType, report.From, report.To)
+		if err != nil {
+			return report, err
+		}
+		usages = append(usages, fetched...)
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, usage := range usages {
+		if !seen[usage.Model] {
+			seen[usage.Model] = true
+			names = append(names, usage.Model)
+		}
+	}
+	if report.Models, err = SyncModelCatalog(ctx, models.OpenAI, names); err != nil {
+		return report, err
+	}
+	catalog, err := CatalogModels(ctx, names...)
+	if err != nil {
+		return report, err
+	}
+	modelIDs := make(map[string]uuid.UUID, len(catalog))
+	for _, aiModel := range catalog {
+		modelIDs[aiModel.Model] = aiModel.Id
+	}
+
+	rollups := make([]models.UsageRollups, 0, len(usages))
+	for _, usage := range usages {
+		modelID, ok := modelIDs[usage.Model]
+		if !ok {
+			continue
+		}
+		rollups = append(rollups, models.UsageRollups{
+			Day: usage.Day, ModelID: modelID, RequestType: usage.RequestType,
+			Requests: usage.Requests, PromptTokens: usage.InputTokens, CompletionTokens: usage.OutputTokens,
+			TotalTokens: usage.InputTokens + usage.OutputTokens, Archive: ImportedUsageArchive,
+		})
+	}
+	err = DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("archive = ? AND day >= ? AND day < ?", ImportedUsageArchive, report.From, report.To).
+			Delete(&models.UsageRollups{}).Error
+		if err != nil || len(rollups) == 0 {
+			return err
+		}
+		return tx.CreateInBatches(&rollups, 500).Error
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Rollups = len(rollups)
+	return report, nil
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchOpenAIUsage(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/organization/usage/completions", r.URL.Path)
+		assert.Equal(t, "Bearer sk-admin", r.Header.Get("Authorization"))
+		assert.Equal(t, "1d", r.URL.Query().Get("bucket_width"))
+		assert.Equal(t, "model", r.URL.Query().Get("group_by"))
+		if r.URL.Query().Get("page") == "" {
+			w.Write([]byte(`{"object": "page", "has_more": true, "next_page": "page_2", "data": [{"start_time": 1714521600, "results": [
+				{"model": "gpt-4o-mini", "input_tokens": 100, "output_tokens": 20, "num_model_requests": 3},
+				{"model": "gpt-4o", "input_tokens": 0, "output_tokens": 0, "num_model_requests": 0}]}]}`))
+			return
+		}
+		assert.Equal(t, "page_2", r.URL.Query().Get("page"))
+		w.Write([]byte(`{"object": "page", "has_more": false, "data": [{"start_time": 1714608000, "results": [
+			{"model": "gpt-4o", "input_tokens": 50, "output_tokens": 5, "num_model_requests": 1}]}]}`))
+	}))
+	defer server.Close()
+
+	usages, err := fetchOpenAIUsage(context.Background(), server.Client(), server.URL, "sk-admin", "chat_completion", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []openAIUsage{
+		{Day: from, Model: "gpt-4o-mini", RequestType: "chat_completion", Requests: 3, InputTokens: 100, OutputTokens: 20},
+		{Day: from.AddDate(0, 0, 1), Model: "gpt-4o", RequestType: "chat_completion", Requests: 1, InputTokens: 50, OutputTokens: 5},
+	}, usages)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "admin key required"}}`, http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+	_, err = fetchOpenAIUsage(context.Background(), failing.Client(), failing.URL, "sk-proj", "chat_completion", from, to)
+	assert.ErrorContains(t, err, "401")
+}
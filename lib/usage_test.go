@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCutShortFinishReason(t *testing.T) {
+	assert.Equal(t, string(models.Null), CutShortFinishReason(context.Background(), "gpt-4o"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, string(models.ClientDisconnect), CutShortFinishReason(ctx, "gpt-4o"))
+
+	// Streams past the deadline of the client weren't aborted by it
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	assert.Equal(t, string(models.Null), CutShortFinishReason(ctx, "gpt-4o"))
+}
@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"gorm.io/gorm"
+)
+
+// usageDayLayout formats the days of usage exports and history answers
+const usageDayLayout = "2006-01-02"
+
+func init() {
+	RegisterJob(Job{
+		Name:     "usage_tiering",
+		Interval: 24 * time.Hour,
+		Run:      tierUsage,
+	})
+}
+
+// GetUsageTieringSettings returns the usage tiering settings with defaults applied
+func GetUsageTieringSettings() UsageTiering {
+	settings := UsageTiering{}
+	if tiering := GetConfig().Settings.UsageTiering; tiering != nil {
+		settings = *tiering
+	}
+	if settings.HotDays <= 0 {
+		settings.HotDays = 90
+	}
+	return settings
+}
+
+// UsageAggregate holds the usage totals of a model and request type on a day
+type UsageAggregate struct {
+	Day              string    `json:"day"`
+	ModelID          uuid.UUID `json:"model_id"`
+	RequestType      string    `json:"request_type"`
+	Requests         int64     `json:"requests"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens"`
+}
+
+// usageHotCutoff returns the first day whose usage rows stay in the database
+func usageHotCutoff(now time.Time, hotDays int) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(0, 0, -hotDays)
+}
+
+// tierUsage moves the usage rows older than hot_days out of the database, a day
+// at a time. The rows of a day are deleted only once their Parquet file is
+// stored, in the transaction writing the rollups of the day, so a failed run
+// leaves the rows in place for the next one.
+func tierUsage(ctx context.Context) error {
+	settings := GetUsageTieringSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	store, err := ArchiveStore()
+	if err != nil {
+		return err
+	}
+	cutoff := usageHotCutoff(time.Now().UTC(), settings.HotDays)
+
+	var oldest *time.Time
+	err = DB().WithContext(ctx).Unscoped().Model(&models.Usage{}).
+		Where("created_at < ?", cutoff).Select("min(created_at)").Scan(&oldest).Error
+	if err != nil || oldest == nil {
+		return err
+	}
+
+	tiered := 0
+	for day := usageHotCutoff(oldest.UTC(), 0); day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows, err := tierUsageDay(ctx, store, day)
+		if err != nil {
+			return fmt.Errorf("failed to tier usage of %s: %v", day.Format(usageDayLayout), err)
+		}
+		tiered += rows
+	}
+	log.Printf("Moved %d usage rows to cold storage", tiered)
+	return nil
+}
+
+// tierUsageDay exports and prunes the usage rows of a day, returning their count
+func tierUsageDay(ctx context.Context, store BlobStore, day time.Time) (int, error) {
+	next := day.AddDate(0, 0, 1)
+	var usages []models.Usage
+	err := DB().WithContext(ctx).Unscoped().
+		Where("created_at >= ? AND created_at < ?", day, next).
+		Order("created_at").Find(&usages).Error
+	if err != nil || len(usages) == 0 {
+		return 0, err
+	}
+
+	var file bytes.Buffer
+	if err := WriteParquet(&file, usageColumns(usages)); err != nil {
+		return 0, err
+	}
+	key := path.Join(GetArchiveSettings().Prefix, "usage", day.Format(archiveDateLayout)+".parquet")
+	if err := store.Put(ctx, key, file.Bytes()); err != nil {
+		return 0, err
+	}
+
+	err = DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Rollups of an earlier run of the day are replaced, the file holds every
+		// row again. Imported rollups of the day are kept.
+		if err := tx.Unscoped().Where("day = ? AND archive = ?", day, key).Delete(&models.UsageRollups{}).Error; err != nil {
+			return err
+		}
+		if rollups := rollupUsage(day, key, usages); len(rollups) > 0 {
+			if err := tx.Create(&rollups).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("created_at >= ? AND created_at < ?", day, next).Delete(&models.Usage{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(usages), nil
+}
+
+// rollupUsage totals the usage rows of a day per model and request type.
+// Soft-deleted rows are exported but left out of the totals, as they are of
+// the queries on the database.
+func rollupUsage(day time.Time, key string, usages []models.Usage) []models.UsageRollups {
+	type group struct {
+		model       uuid.UUID
+		requestType string
+	}
+	totals := map[group]*models.UsageRollups{}
+	var rollups []*models.UsageRollups
+	for _, usage := range usages {
+		if usage.DeletedAt != nil && usage.DeletedAt.Valid {
+			continue
+		}
+		g := group{usage.ModelID, usage.RequestType}
+		rollup, ok := totals[g]
+		if !ok {
+			rollup = &models.UsageRollups{Day: day, ModelID: usage.ModelID, RequestType: usage.RequestType, Archive: key}
+			totals[g] = rollup
+			rollups = append(rollups, rollup)
+		}
+		rollup.Requests++
+		rollup.PromptTokens += int64(usage.PromptTokensCount)
+		rollup.CompletionTokens += int64(usage.CompletionTokens)
+		rollup.TotalTokens += int64(usage.TotalTokens)
+	}
+	result := make([]models.UsageRollups, len(rollups))
+	for i, rollup := range rollups {
+		result[i] = *rollup
+	}
+	return result
+}
+
+// usageColumns returns the columns of the Parquet export of usage rows
+func usageColumns(usages []models.Usage) []ParquetColumn {
+	id, created, model, requestType := make([]string, len(usages)), make([]int64, len(usages)), make([]string, len(usages)), make([]string, len(usages))
+	predicted, prompt, completion, total := make([]int64, len(usages)), make([]int64, len(usages)), make([]int64, len(usages)), make([]int64, len(usages))
+	finish, documents, raced, deleted := make([]string, len(usages)), make([]int64, len(usages)), make([]bool, len(usages)), make([]bool, len(usages))
+	salt, requestHash, responseHash := make([]string, len(usages)), make([]string, len(usages)), make([]string, len(usages))
+	apiKey, workspace := make([]string, len(usages)), make([]string, len(usages))
+	for i, usage := range usages {
+		id[i] = usage.Id.String()
+		created[i] = usage.CreatedAt.UnixMilli()
+		model[i] = usage.ModelID.String()
+		requestType[i] = usage.RequestType
+		predicted[i] = int64(usage.PredictedTokensCount)
+		prompt[i] = int64(usage.PromptTokensCount)
+		completion[i] = int64(usage.CompletionTokens)
+		total[i] = int64(usage.TotalTokens)
+		finish[i] = string(usage.FinishReason)
+		documents[i] = int64(usage.DocumentsCount)
+		raced[i] = usage.Raced
+		deleted[i] = usage.DeletedAt != nil && usage.DeletedAt.Valid
+		salt[i], requestHash[i], responseHash[i] = usage.HashSalt, usage.RequestHash, usage.ResponseHash
+		if usage.ApiKeyID != nil {
+			apiKey[i] = usage.ApiKeyID.String()
+		}
+		if usage.WorkspaceID != nil {
+			workspace[i] = usage.WorkspaceID.String()
+		}
+	}
+	return []ParquetColumn{
+		{Name: "id", Strings: id},
+		{Name: "created_at", Timestamp: true, Int64s: created},
+		{Name: "model_id", Strings: model},
+		{Name: "request_type", Strings: requestType},
+		{Name: "predicted_tokens_count", Int64s: predicted},
+		{Name: "prompt_tokens_count", Int64s: prompt},
+		{Name: "completion_tokens", Int64s: completion},
+		{Name: "total_tokens", Int64s: total},
+		{Name: "finish_reason", Strings: finish},
+		{Name: "raced", Bools: raced},
+		{Name: "documents_count", Int64s: documents},
+		{Name: "hash_salt", Strings: salt},
+		{Name: "request_hash", Strings: requestHash},
+		{Name: "response_hash", Strings: responseHash},
+		{Name: "deleted", Bools: deleted},
+		{Name: "api_key_id", Strings: apiKey},
+		{Name: "workspace_id", Strings: workspace},
+	}
+}
+
+// UsageHistory returns the daily usage totals from the first to the last day,
+// answered from the rollups for days moved to cold storage and from the usage
+// rows for the others
+func UsageHistory(ctx context.Context, from time.Time, to time.Time) ([]UsageAggregate, error) {
+	var rollups []models.UsageRollups
+	err := DB().WithContext(ctx).Where("day >= ? AND day <= ?", from, to).Find(&rollups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var live []struct {
+		Day              time.Time
+		ModelID          uuid.UUID
+		RequestType      string
+		Requests         int64
+		PromptTokens     int64
+		CompletionTokens int64
+		TotalTokens      int64
+	}
+	err = DB().WithContext(ctx).Model(&models.Usage{}).
+		Select("date(created_at) AS day, model_id, request_type, count(*) AS requests, "+
+			"sum(prompt_tokens_count) AS prompt_tokens, sum(completion_tokens) AS completion_tokens, sum(total_tokens) AS total_tokens").
+		Where("created_at >= ? AND created_at < ?", from, to.AddDate(0, 0, 1)).
+		Group("date(created_at), model_id, request_type").Scan(&live).Error
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]UsageAggregate, 0, len(rollups)+len(live))
+	for _, rollup := range rollups {
+		aggregates = append(aggregates, UsageAggregate{
+			Day: rollup.Day.Format(usageDayLayout), ModelID: rollup.ModelID, RequestType: rollup.RequestType,
+			Requests: rollup.Requests, PromptTokens: rollup.PromptTokens, CompletionTokens: rollup.CompletionTokens, TotalTokens: rollup.TotalTokens,
+		})
+	}
+	for _, row := range live {
+		aggregates = append(aggregates, UsageAggregate{
+			Day: row.Day.Format(usageDayLayout), ModelID: row.ModelID, RequestType: row.RequestType,
+			Requests: row.Requests, PromptTokens: row.PromptTokens, CompletionTokens: row.CompletionTokens, TotalTokens: row.TotalTokens,
+		})
+	}
+	return mergeUsageAggregates(aggregates), nil
+}
+
+// mergeUsageAggregates sums the aggregates of the same day, model and request
+// type, such as a day with rollups and rows written after it was tiered,
+// and sorts them by day
+func mergeUsageAggregates(aggregates []UsageAggregate) []UsageAggregate {
+	type group struct {
+		day         string
+		model       uuid.UUID
+		requestType string
+	}
+	index := map[group]int{}
+	merged := make([]UsageAggregate, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		g := group{aggregate.Day, aggregate.ModelID, aggregate.RequestType}
+		i, ok := index[g]
+		if !ok {
+			index[g] = len(merged)
+			merged = append(merged, aggregate)
+			continue
+		}
+		merged[i].Requests += aggregate.Requests
+		merged[i].PromptTokens += aggregate.PromptTokens
+		merged[i].CompletionTokens += aggregate.CompletionTokens
+		merged[i].TotalTokens += aggregate.TotalTokens
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Day != merged[j].Day {
+			return merged[i].Day < merged[j].Day
+		}
+		if merged[i].ModelID != merged[j].ModelID {
+			return merged[i].ModelID.String() < merged[j].ModelID.String()
+		}
+		return merged[i].RequestType < merged[j].RequestType
+	})
+	return merged
+}
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestWriteParquet(t *testing.T) {
+	var file bytes.Buffer
+	require.NoError(t, WriteParquet(&file, []ParquetColumn{
+		{Name: "id", Strings: []string{"a", "bc"}},
+		{Name: "tokens", Int64s: []int64{1, 2}},
+		{Name: "raced", Bools: []bool{false, true}},
+	}))
+	data := file.Bytes()
+	assert.Equal(t, parquetMagic, string(data[:4]))
+	assert.Equal(t, parquetMagic, string(data[len(data)-4:]))
+
+	// The first page header is a DATA_PAGE followed by the PLAIN encoded strings
+	assert.Equal(t, []byte{0x15, 0x00}, data[4:6])
+	assert.True(t, bytes.Contains(data, []byte{1, 0, 0, 0, 'a', 2, 0, 0, 0, 'b', 'c'}))
+
+	length := binary.LittleEndian.Uint32(data[len(data)-8 : len(data)-4])
+	footer := data[len(data)-8-int(length) : len(data)-8]
+	// Version 1, then a list of the root and three column schema elements
+	assert.Equal(t, []byte{0x15, 0x02, 0x19, 0x4c}, footer[:4])
+	assert.True(t, bytes.HasSuffix(footer, []byte("openshield\x00")))
+
+	err := WriteParquet(&file, []ParquetColumn{{Name: "a", Int64s: []int64{1}}, {Name: "b", Int64s: nil}})
+	assert.Error(t, err, "columns of different lengths are rejected")
+}
+
+func TestRollupUsage(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	model := uuid.New()
+	usages := []models.Usage{
+		{ModelID: model, RequestType: "chat", PromptTokensCount: 10, CompletionTokens: 5, TotalTokens: 15},
+		{ModelID: model, RequestType: "chat", PromptTokensCount: 1, CompletionTokens: 2, TotalTokens: 3},
+		{ModelID: model, RequestType: "rerank", PromptTokensCount: 7, TotalTokens: 7},
+		{Base: models.Base{DeletedAt: &gorm.DeletedAt{Time: day, Valid: true}}, ModelID: model, RequestType: "chat", TotalTokens: 100},
+	}
+
+	rollups := rollupUsage(day, "usage/2024/01/02.parquet", usages)
+	require.Len(t, rollups, 2)
+	assert.Equal(t, int64(2), rollups[0].Requests)
+	assert.Equal(t, int64(11), rollups[0].PromptTokens)
+	assert.Equal(t, int64(18), rollups[0].TotalTokens, "soft-deleted rows are left out")
+	assert.Equal(t, "rerank", rollups[1].RequestType)
+	assert.Equal(t, "usage/2024/01/02.parquet", rollups[1].Archive)
+
+	// Exports aren't blob keys the archive lifecycle job expires
+	_, ok := archivedAt("usage/2024/01/02.parquet")
+	assert.False(t, ok)
+}
+
+func TestMergeUsageAggregates(t *testing.T) {
+	model := uuid.New()
+	merged := mergeUsageAggregates([]UsageAggregate{
+		{Day: "2024-01-03", ModelID: model, RequestType: "chat", Requests: 1, TotalTokens: 10},
+		{Day: "2024-01-02", ModelID: model, RequestType: "chat", Requests: 2, TotalTokens: 20},
+		{Day: "2024-01-03", ModelID: model, RequestType: "chat", Requests: 3, TotalTokens: 30},
+	})
+	require.Len(t, merged, 2)
+	assert.Equal(t, "2024-01-02", merged[0].Day)
+	assert.Equal(t, int64(4), merged[1].Requests)
+	assert.Equal(t, int64(40), merged[1].TotalTokens)
+}
+
+func TestUsageHotCutoff(t *testing.T) {
+	now := time.Date(2024, 4, 1, 15, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), usageHotCutoff(now, 90))
+}
@@ -0,0 +1,146 @@
+package vectordb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// maxQueryBody bounds the size of a proxied query
+const maxQueryBody = 10 << 20
+
+// queryRoute is a read endpoint of a vector database. Filterable routes accept
+// a tenant filter in their body.
+type queryRoute struct {
+	method     string
+	path       *regexp.Regexp
+	filterable bool
+}
+
+// queryRoutes are the endpoints proxied per vector database type. Writes and
+// admin endpoints are never proxied.
+var queryRoutes = map[string][]queryRoute{
+	"pinecone": {
+		{method: http.MethodPost, path: regexp.MustCompile(`^/query$`), filterable: true},
+		{method: http.MethodGet, path: regexp.MustCompile(`^/vectors/fetch$`), filterable: true},
+	},
+	"qdrant": {
+		{method: http.MethodPost, path: regexp.MustCompile(`^/collections/[^/]+/points/(search|search/batch|query|query/batch|scroll|recommend)$`), filterable: true},
+		{method: http.MethodPost, path: regexp.MustCompile(`^/collections/[^/]+/points$`)},
+	},
+	"weaviate": {
+		{method: http.MethodPost, path: regexp.MustCompile(`^/v1/graphql$`)},
+		{method: http.MethodGet, path: regexp.MustCompile(`^/v1/objects(/[^/]+){0,2}$`), filterable: true},
+	},
+}
+
+// ProxyHandler forwards a query to the configured vector store with the same
+// authentication and audit logging as completions. With isolate_tenants every
+// query is limited to the data of the product of the API key.
+func ProxyHandler(w http.ResponseWriter, r *http.Request) {
+	config := lib.GetRequestConfig(r)
+	name := chi.URLParam(r, "store")
+	store, ok := config.Settings.VectorStores[name]
+	if !ok {
+		handleError(w, fmt.Errorf("vector store %s is not configured", name), http.StatusNotFound)
+		return
+	}
+
+	productID, _ := r.Context().Value("productId").(uuid.UUID)
+	if !lib.ProductListed(store.Products, productID) {
+		handleError(w, fmt.Errorf("vector store %s is not available for this product", name), http.StatusForbidden)
+		return
+	}
+
+	path := "/" + chi.URLParam(r, "*")
+	route, ok := matchRoute(store.Type, r.Method, path)
+	if !ok {
+		handleError(w, fmt.Errorf("%s %s is not a query endpoint of %s", r.Method, path, store.Type), http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxQueryBody))
+	if err != nil {
+		handleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	if store.IsolateTenants {
+		if !route.filterable {
+			handleError(w, fmt.Errorf("%s %s can't be limited to a tenant", r.Method, path), http.StatusForbidden)
+			return
+		}
+		if body, err = isolateTenant(store, body, query, productID.String()); err != nil {
+			handleError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	apiKeyId := r.Context().Value("apiKeyId").(uuid.UUID)
+	lib.AuditLogs(string(body), "vectordb_"+store.Type, apiKeyId, "input", r)
+
+	upstream, err := http.NewRequestWithContext(r.Context(), r.Method, strings.TrimSuffix(store.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	upstream.URL.RawQuery = query.Encode()
+	upstream.Header.Set("Content-Type", "application/json")
+	setAuthentication(upstream, store)
+
+	client, err := lib.ProviderHTTPClient(&lib.ProviderConfig{Proxy: store.Proxy, CABundle: store.CABundle})
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp, err := client.Do(upstream)
+	if err != nil {
+		handleError(w, fmt.Errorf("failed to query vector store: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func matchRoute(storeType string, method string, path string) (queryRoute, bool) {
+	for _, route := range queryRoutes[storeType] {
+		if route.method == method && route.path.MatchString(path) {
+			return route, true
+		}
+	}
+	return queryRoute{}, false
+}
+
+func setAuthentication(req *http.Request, store lib.VectorStore) {
+	apiKey := os.Getenv(store.APIKeyEnv)
+	if store.APIKeyEnv == "" || apiKey == "" {
+		return
+	}
+	switch store.Type {
+	case "pinecone":
+		req.Header.Set("Api-Key", apiKey)
+	case "qdrant":
+		req.Header.Set("api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+func handleError(w http.ResponseWriter, err error, statusCode int) {
+	log.Printf("Error: %v", err)
+	http.Error(w, err.Error(), statusCode)
+}
@@ -0,0 +1,157 @@
+package vectordb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// Document is a document retrieved from a vector store
+type Document struct {
+	ID      string  `json:"id"`
+	Score   float64 `json:"score"`
+	Content string  `json:"-"`
+}
+
+// Search returns the topK documents of collection nearest to vector. The text of
+// a document is read from its contentField. With isolate_tenants only documents
+// of tenant are searched.
+func Search(ctx context.Context, store lib.VectorStore, collection string, contentField string, vector []float32, topK int, tenant string) ([]Document, error) {
+	switch store.Type {
+	case "qdrant":
+		return searchQdrant(ctx, store, collection, contentField, vector, topK, tenant)
+	case "pinecone":
+		return searchPinecone(ctx, store, contentField, vector, topK, tenant)
+	case "weaviate":
+		return searchWeaviate(ctx, store, collection, contentField, vector, topK, tenant)
+	}
+	return nil, fmt.Errorf("unsupported vector store type %s", store.Type)
+}
+
+func searchQdrant(ctx context.Context, store lib.VectorStore, collection string, contentField string, vector []float32, topK int, tenant string) ([]Document, error) {
+	request := map[string]interface{}{"vector": vector, "limit": topK, "with_payload": true}
+	if store.IsolateTenants {
+		key := store.TenantKey
+		if key == "" {
+			key = defaultTenantKey
+		}
+		addTenantFilter(request, key, tenant)
+	}
+
+	var response struct {
+		Result []struct {
+			ID      interface{}            `json:"id"`
+			Score   float64                `json:"score"`
+			Payload map[string]interface{} `json:"payload"`
+		} `json:"result"`
+	}
+	if err := query(ctx, store, "/collections/"+collection+"/points/search", request, &response); err != nil {
+		return nil, err
+	}
+
+	documents := make([]Document, 0, len(response.Result))
+	for _, point := range response.Result {
+		content, _ := point.Payload[contentField].(string)
+		documents = append(documents, Document{ID: fmt.Sprint(point.ID), Score: point.Score, Content: content})
+	}
+	return documents, nil
+}
+
+func searchPinecone(ctx context.Context, store lib.VectorStore, contentField string, vector []float32, topK int, tenant string) ([]Document, error) {
+	request := map[string]interface{}{"vector": vector, "topK": topK, "includeMetadata": true}
+	if store.IsolateTenants {
+		request["namespace"] = tenant
+	}
+
+	var response struct {
+		Matches []struct {
+			ID       string                 `json:"id"`
+			Score    float64                `json:"score"`
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"matches"`
+	}
+	if err := query(ctx, store, "/query", request, &response); err != nil {
+		return nil, err
+	}
+
+	documents := make([]Document, 0, len(response.Matches))
+	for _, match := range response.Matches {
+		content, _ := match.Metadata[contentField].(string)
+		documents = append(documents, Document{ID: match.ID, Score: match.Score, Content: content})
+	}
+	return documents, nil
+}
+
+func searchWeaviate(ctx context.Context, store lib.VectorStore, class string, contentField string, vector []float32, topK int, tenant string) ([]Document, error) {
+	vectorJSON, _ := json.Marshal(vector)
+	tenantArgument := ""
+	if store.IsolateTenants {
+		tenantJSON, _ := json.Marshal(tenant)
+		tenantArgument = ", tenant: " + string(tenantJSON)
+	}
+	graphQL := fmt.Sprintf("{ Get { %s(nearVector: {vector: %s}, limit: %d%s) { %s _additional { id certainty } } } }",
+		class, vectorJSON, topK, tenantArgument, contentField)
+
+	var response struct {
+		Data struct {
+			Get map[string][]map[string]interface{} `json:"Get"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := query(ctx, store, "/v1/graphql", map[string]string{"query": graphQL}, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		return nil, fmt.Errorf("weaviate query failed: %s", response.Errors[0].Message)
+	}
+
+	var documents []Document
+	for _, object := range response.Data.Get[class] {
+		content, _ := object[contentField].(string)
+		additional, _ := object["_additional"].(map[string]interface{})
+		id, _ := additional["id"].(string)
+		certainty, _ := additional["certainty"].(float64)
+		documents = append(documents, Document{ID: id, Score: certainty, Content: content})
+	}
+	return documents, nil
+}
+
+func query(ctx context.Context, store lib.VectorStore, path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(store.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthentication(req, store)
+
+	client, err := lib.ProviderHTTPClient(&lib.ProviderConfig{Proxy: store.Proxy, CABundle: store.CABundle})
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query vector store: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read vector store response: %v", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("vector store returned status %d: %s", resp.StatusCode, data)
+	}
+	return json.Unmarshal(data, response)
+}
@@ -0,0 +1,72 @@
+package vectordb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// defaultTenantKey is the Qdrant payload key holding the tenant of a point
+const defaultTenantKey = "tenant_id"
+
+// isolateTenant limits a query to the data of tenant. Pinecone queries are sent
+// to the tenant namespace, Qdrant queries get a filter on the tenant payload key
+// and Weaviate object reads use Weaviate multi-tenancy.
+func isolateTenant(store lib.VectorStore, body []byte, query url.Values, tenant string) ([]byte, error) {
+	switch store.Type {
+	case "pinecone":
+		if len(body) == 0 {
+			query.Set("namespace", tenant)
+			return body, nil
+		}
+		return setJSONField(body, func(request map[string]interface{}) error {
+			request["namespace"] = tenant
+			return nil
+		})
+	case "qdrant":
+		key := store.TenantKey
+		if key == "" {
+			key = defaultTenantKey
+		}
+		return setJSONField(body, func(request map[string]interface{}) error {
+			if searches, ok := request["searches"].([]interface{}); ok {
+				for _, search := range searches {
+					if search, ok := search.(map[string]interface{}); ok {
+						addTenantFilter(search, key, tenant)
+					}
+				}
+				return nil
+			}
+			addTenantFilter(request, key, tenant)
+			return nil
+		})
+	case "weaviate":
+		query.Set("tenant", tenant)
+		return body, nil
+	}
+	return nil, fmt.Errorf("tenant isolation is not supported for %s", store.Type)
+}
+
+// addTenantFilter wraps the filter of a Qdrant query so it only matches points of tenant
+func addTenantFilter(request map[string]interface{}, key string, tenant string) {
+	must := []interface{}{map[string]interface{}{"key": key, "match": map[string]interface{}{"value": tenant}}}
+	if filter, ok := request["filter"]; ok && filter != nil {
+		must = append(must, filter)
+	}
+	request["filter"] = map[string]interface{}{"must": must}
+}
+
+func setJSONField(body []byte, update func(map[string]interface{}) error) ([]byte, error) {
+	request := map[string]interface{}{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("error decoding request body: %v", err)
+		}
+	}
+	if err := update(request); err != nil {
+		return nil, err
+	}
+	return json.Marshal(request)
+}
@@ -0,0 +1,44 @@
+package vectordb
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsolateTenantQdrant(t *testing.T) {
+	store := lib.VectorStore{Type: "qdrant", IsolateTenants: true}
+	body, err := isolateTenant(store, []byte(`{"vector":[0.1],"filter":{"must":[{"key":"lang","match":{"value":"en"}}]}}`), url.Values{}, "product-1")
+	assert.NoError(t, err)
+
+	var request map[string]interface{}
+	json.Unmarshal(body, &request)
+	must := request["filter"].(map[string]interface{})["must"].([]interface{})
+	assert.Len(t, must, 2)
+	assert.Equal(t, "tenant_id", must[0].(map[string]interface{})["key"])
+	assert.Equal(t, "product-1", must[0].(map[string]interface{})["match"].(map[string]interface{})["value"])
+}
+
+func TestIsolateTenantPinecone(t *testing.T) {
+	store := lib.VectorStore{Type: "pinecone", IsolateTenants: true}
+	body, err := isolateTenant(store, []byte(`{"vector":[0.1],"namespace":"other"}`), url.Values{}, "product-1")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"vector":[0.1],"namespace":"product-1"}`, string(body))
+
+	query := url.Values{"namespace": {"other"}}
+	_, err = isolateTenant(store, nil, query, "product-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "product-1", query.Get("namespace"))
+}
+
+func TestMatchRoute(t *testing.T) {
+	_, ok := matchRoute("qdrant", "POST", "/collections/docs/points/search")
+	assert.True(t, ok)
+	_, ok = matchRoute("qdrant", "PUT", "/collections/docs/points")
+	assert.False(t, ok)
+	_, ok = matchRoute("pinecone", "POST", "/vectors/upsert")
+	assert.False(t, ok)
+}
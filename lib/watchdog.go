@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultShedOrder sheds the vector store proxy first and completions last.
+// Admin routes are never shed, they are needed to act on the overload.
+var defaultShedOrder = []string{"/vectordb", "/openshield/v1/rag", "/openai/v1"}
+
+// WatchdogStatus is the last sample of the watchdog
+type WatchdogStatus struct {
+	HeapBytes     uint64    `json:"heap_bytes"`
+	Goroutines    int       `json:"goroutines"`
+	Pressure      float64   `json:"pressure"`
+	Shedding      []string  `json:"shedding"`
+	Shed          int64     `json:"shed"`
+	OverloadedAt  time.Time `json:"overloaded_at,omitempty"`
+	SampledAt     time.Time `json:"sampled_at"`
+	LastDiagnosis string    `json:"last_diagnosis,omitempty"`
+}
+
+var watchdog struct {
+	mu     sync.Mutex
+	status WatchdogStatus
+}
+
+// GetWatchdogSettings returns the watchdog settings with defaults applied
+func GetWatchdogSettings() Watchdog {
+	settings := Watchdog{}
+	if w := GetConfig().Settings.Watchdog; w != nil {
+		settings = *w
+	}
+	if settings.MaxHeapBytes == 0 {
+		settings.MaxHeapBytes = 2 << 30
+	}
+	if settings.MaxGoroutines <= 0 {
+		settings.MaxGoroutines = 10000
+	}
+	if settings.Interval <= 0 {
+		settings.Interval = 5
+	}
+	if settings.RetryAfter <= 0 {
+		settings.RetryAfter = 10
+	}
+	if len(settings.Shed) == 0 {
+		settings.Shed = defaultShedOrder
+	}
+	return settings
+}
+
+// RunWatchdog samples the heap and the goroutine count until ctx is done
+func RunWatchdog(ctx context.Context) {
+	settings := GetWatchdogSettings()
+	if !settings.Enabled {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(settings.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			sampleWatchdog(GetWatchdogSettings(), stats.HeapAlloc, runtime.NumGoroutine())
+		}
+	}
+}
+
+// shedLevel is the number of route prefixes to shed at a pressure. At the
+// limit the first is shed, and one more for every tenth over it.
+func shedLevel(pressure float64, prefixes int) int {
+	if pressure < 1 {
+		return 0
+	}
+	level := 1 + int((pressure-1)*10)
+	if level > prefixes {
+		level = prefixes
+	}
+	return level
+}
+
+func sampleWatchdog(settings Watchdog, heap uint64, goroutines int) {
+	pressure := float64(heap) / float64(settings.MaxHeapBytes)
+	if p := float64(goroutines) / float64(settings.MaxGoroutines); p > pressure {
+		pressure = p
+	}
+	shedding := settings.Shed[:shedLevel(pressure, len(settings.Shed))]
+	SetGauge("runtime.heap_bytes", float64(heap))
+	SetGauge("runtime.goroutines", float64(goroutines))
+
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	overloaded := len(watchdog.status.Shedding) == 0 && len(shedding) > 0
+	watchdog.status.HeapBytes = heap
+	watchdog.status.Goroutines = goroutines
+	watchdog.status.Pressure = pressure
+	watchdog.status.SampledAt = time.Now()
+	if len(shedding) != len(watchdog.status.Shedding) {
+		log.Printf("Watchdog: heap %d bytes, %d goroutines, shedding %v", heap, goroutines, shedding)
+	}
+	watchdog.status.Shedding = shedding
+	if overloaded {
+		watchdog.status.OverloadedAt = time.Now()
+		if settings.DiagnosticsDir != "" {
+			dir, err := writeDiagnostics(settings.DiagnosticsDir)
+			if err != nil {
+				log.Printf("Watchdog: error writing diagnostics: %v", err)
+			} else {
+				watchdog.status.LastDiagnosis = dir
+			}
+		}
+	}
+}
+
+// writeDiagnostics writes the goroutine stacks and a heap profile to a new
+// directory, so the cause of an overload can be found after the fact
+func writeDiagnostics(root string) (string, error) {
+	dir := filepath.Join(root, "watchdog-"+time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	for name, debug := range map[string]int{"goroutine": 2, "heap": 0} {
+		file, err := os.Create(filepath.Join(dir, name+".pprof"))
+		if err != nil {
+			return "", err
+		}
+		err = pprof.Lookup(name).WriteTo(file, debug)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// GetWatchdogStatus returns the last sample of the watchdog
+func GetWatchdogStatus() WatchdogStatus {
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	status := watchdog.status
+	status.Shedding = append([]string{}, status.Shedding...)
+	return status
+}
+
+// WatchdogMiddleware answers the requests of the routes being shed with a 503
+func WatchdogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := CanonicalPath(r.URL.Path)
+		watchdog.mu.Lock()
+		shed := false
+		for _, prefix := range watchdog.status.Shedding {
+			if strings.HasPrefix(path, prefix) {
+				shed = true
+				watchdog.status.Shed++
+				break
+			}
+		}
+		watchdog.mu.Unlock()
+		if !shed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(GetWatchdogSettings().RetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": fmt.Sprintf("the gateway is overloaded, %s is not served for now", r.URL.Path),
+				"type":    "service_unavailable",
+				"code":    "overloaded",
+			},
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShedLevel(t *testing.T) {
+	assert.Equal(t, 0, shedLevel(0.95, 3))
+	assert.Equal(t, 1, shedLevel(1, 3))
+	assert.Equal(t, 2, shedLevel(1.15, 3))
+	assert.Equal(t, 3, shedLevel(2, 3))
+}
+
+func TestWatchdogMiddleware(t *testing.T) {
+	defer func() { watchdog.status = WatchdogStatus{} }()
+	settings := Watchdog{MaxHeapBytes: 1000, MaxGoroutines: 100, RetryAfter: 10, Shed: defaultShedOrder}
+	handler := WatchdogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	sampleWatchdog(settings, 500, 10)
+	assert.Equal(t, http.StatusOK, status("/vectordb/store/search"))
+
+	// Goroutines over the limit by a tenth shed the two lowest priorities
+	sampleWatchdog(settings, 500, 110)
+	assert.Equal(t, http.StatusServiceUnavailable, status("/vectordb/store/search"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/openshield/v1/rag/completions"))
+	assert.Equal(t, http.StatusOK, status("/openai/v1/chat/completions"))
+	assert.Equal(t, http.StatusOK, status("/admin/watchdog"))
+	assert.Equal(t, int64(2), GetWatchdogStatus().Shed)
+
+	sampleWatchdog(settings, 100, 10)
+	assert.Equal(t, http.StatusOK, status("/vectordb/store/search"))
+}
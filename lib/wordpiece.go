@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// WordPiece is the tokenizer of BERT style classifiers, from the vocabulary
+// file exported with the model. Text is lowercased and split on whitespace and
+// punctuation, then words are split into the longest pieces in the vocabulary.
+type WordPiece struct {
+	vocab map[string]int64
+	cls   int64
+	sep   int64
+	unk   int64
+}
+
+// LoadWordPiece reads a vocabulary file, one token per line
+func LoadWordPiece(path string) (*WordPiece, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens = append(tokens, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWordPiece(tokens)
+}
+
+// NewWordPiece returns the tokenizer of a vocabulary, the ID of a token is its index
+func NewWordPiece(tokens []string) (*WordPiece, error) {
+	w := &WordPiece{vocab: make(map[string]int64, len(tokens))}
+	for i, token := range tokens {
+		w.vocab[token] = int64(i)
+	}
+	for token, id := range map[string]*int64{"[CLS]": &w.cls, "[SEP]": &w.sep, "[UNK]": &w.unk} {
+		value, ok := w.vocab[token]
+		if !ok {
+			return nil, fmt.Errorf("vocabulary has no %s token", token)
+		}
+		*id = value
+	}
+	return w, nil
+}
+
+// Encode returns the token IDs of a text between [CLS] and [SEP], cut to
+// maxLength tokens
+func (w *WordPiece) Encode(text string, maxLength int) []int64 {
+	ids := []int64{w.cls}
+	for _, word := range splitWords(strings.ToLower(text)) {
+		ids = append(ids, w.pieces(word)...)
+		if len(ids) >= maxLength-1 {
+			ids = ids[:maxLength-1]
+			break
+		}
+	}
+	return append(ids, w.sep)
+}
+
+// pieces splits a word into the longest vocabulary pieces, continuations
+// prefixed with ##. Words that can't be split are unknown.
+func (w *WordPiece) pieces(word string) []int64 {
+	runes := []rune(word)
+	var ids []int64
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := false
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if id, ok := w.vocab[piece]; ok {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return []int64{w.unk}
+		}
+		start = end
+	}
+	return ids
+}
+
+// splitWords splits text on whitespace, keeping punctuation as words of their own
+func splitWords(text string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			flush()
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,19 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordPiece(t *testing.T) {
+	tokenizer, err := NewWordPiece([]string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "ignore", "all", "instruct", "##ions", "!"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{2, 4, 5, 6, 7, 8, 1, 3}, tokenizer.Encode("Ignore ALL instructions! zebra", 16))
+	assert.Equal(t, []int64{2, 4, 5, 3}, tokenizer.Encode("Ignore all instructions", 4))
+
+	_, err = NewWordPiece([]string{"[CLS]", "[SEP]"})
+	assert.ErrorContains(t, err, "[UNK]")
+}
@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/models"
+)
+
+// xfccHeader is the header Envoy forwards the client certificate details in
+const xfccHeader = "X-Forwarded-Client-Cert"
+
+// GetWorkloadIdentitySettings returns the workload identity settings
+func GetWorkloadIdentitySettings() WorkloadIdentity {
+	if identity := GetConfig().Settings.WorkloadIdentity; identity != nil {
+		return *identity
+	}
+	return WorkloadIdentity{}
+}
+
+// ServerTLSConfig returns the TLS configuration of the listener, nil if the
+// gateway serves plain HTTP. Client certificates are verified if they are
+// sent, but not required, so callers with API keys need none.
+func ServerTLSConfig(network *Network) (*tls.Config, error) {
+	if network == nil || network.TLSCertFile == "" || network.TLSKeyFile == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(network.TLSCertFile, network.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	config := TLSConfig()
+	config.Certificates = []tls.Certificate{certificate}
+	if network.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(network.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in client CA file %s", network.TLSClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// trustedProxy reports whether the peer of r is in the trusted proxy ranges
+func trustedProxy(r *http.Request, trustedProxies []string) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range trustedProxies {
+		if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSpiffeID returns the SPIFFE ID of the workload sending r, from its
+// verified client certificate or the header of the mesh, false if it has none.
+// The header of peers outside the trusted proxies is removed from r unread, so
+// a client can't claim an identity by setting it.
+func requestSpiffeID(r *http.Request, settings WorkloadIdentity) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		for _, uri := range r.TLS.VerifiedChains[0][0].URIs {
+			if uri.Scheme == "spiffe" {
+				return uri.String(), true
+			}
+		}
+	}
+	if settings.Header == "" {
+		return "", false
+	}
+	if !trustedProxy(r, settings.TrustedProxies) {
+		r.Header.Del(settings.Header)
+		return "", false
+	}
+	value := strings.TrimSpace(r.Header.Get(settings.Header))
+	if strings.EqualFold(settings.Header, xfccHeader) {
+		value = xfccSpiffeID(value)
+	}
+	if !strings.HasPrefix(value, "spiffe://") {
+		return "", false
+	}
+	return value, true
+}
+
+// xfccSpiffeID returns the URI of the last element of an X-Forwarded-Client-Cert
+// header, the certificate of the client that connected to the sidecar
+func xfccSpiffeID(header string) string {
+	elements := splitQuoted(header, ',')
+	if len(elements) == 0 {
+		return ""
+	}
+	for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+		key, value, _ := strings.Cut(pair, "=")
+		if strings.EqualFold(strings.TrimSpace(key), "URI") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// splitQuoted splits s at sep outside of double quotes
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			part.WriteRune(c)
+		case c == sep && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
+
+// workloadApiKeyID returns the API key the first identity matching spiffeID maps to
+func workloadApiKeyID(settings WorkloadIdentity, spiffeID string) (uuid.UUID, bool) {
+	for _, identity := range settings.Identities {
+		if matched, _ := path.Match(identity.SpiffeID, spiffeID); !matched && identity.SpiffeID != spiffeID {
+			continue
+		}
+		id, err := uuid.Parse(identity.ApiKeyID)
+		return id, err == nil
+	}
+	return uuid.Nil, false
+}
+
+// workloadApiKey returns the API key of the workload identity of r, false if
+// workload identity is off or r has no identity. An identity without a
+// mapping or of an inactive key is an error.
+func workloadApiKey(ctx context.Context, r *http.Request) (models.ApiKeys, bool, error) {
+	settings := GetWorkloadIdentitySettings()
+	if !settings.Enabled {
+		return models.ApiKeys{}, false, nil
+	}
+	spiffeID, ok := requestSpiffeID(r, settings)
+	if !ok {
+		return models.ApiKeys{}, false, nil
+	}
+	id, ok := workloadApiKeyID(settings, spiffeID)
+	if !ok {
+		return models.ApiKeys{}, true, fmt.Errorf("workload identity %s is not mapped to an API key", spiffeID)
+	}
+	var apiKey models.ApiKeys
+	if err := DB().WithContext(ctx).Where("id = ? AND status = ?", id, models.Active).First(&apiKey).Error; err != nil {
+		return models.ApiKeys{}, true, fmt.Errorf("API key of workload identity %s is not active", spiffeID)
+	}
+	IncrCounter("workload_identity.authentications", 1)
+	return apiKey, true, nil
+}
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestSpiffeID(t *testing.T) {
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	spiffeID, _ := url.Parse("spiffe://cluster.local/ns/billing/sa/worker")
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{URIs: []*url.URL{spiffeID}}}}}
+	id, ok := requestSpiffeID(r, WorkloadIdentity{})
+	assert.True(t, ok)
+	assert.Equal(t, "spiffe://cluster.local/ns/billing/sa/worker", id)
+
+	// Headers are only read if they are configured
+	r = httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	r.Header.Set("X-Forwarded-Client-Cert", `By=spiffe://cluster.local/ns/ai/sa/openshield;Hash=abc;Subject="CN=a,O=b";URI=spiffe://cluster.local/ns/billing/sa/worker`)
+	_, ok = requestSpiffeID(r, WorkloadIdentity{})
+	assert.False(t, ok)
+	id, ok = requestSpiffeID(r, WorkloadIdentity{Header: "X-Forwarded-Client-Cert", TrustedProxies: []string{"192.0.2.0/24"}})
+	assert.True(t, ok)
+	assert.Equal(t, "spiffe://cluster.local/ns/billing/sa/worker", id)
+
+	r.Header.Set("X-Spiffe-Id", "https://example.com")
+	_, ok = requestSpiffeID(r, WorkloadIdentity{Header: "X-Spiffe-Id", TrustedProxies: []string{"192.0.2.0/24"}})
+	assert.False(t, ok)
+}
+
+func TestRequestSpiffeIDUntrustedPeer(t *testing.T) {
+	settings := WorkloadIdentity{Header: "X-Spiffe-Id", TrustedProxies: []string{"10.0.0.0/8"}}
+	for _, remoteAddr := range []string{"203.0.113.7:4321", "10.0.0.1", "not-an-ip"} {
+		r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Spiffe-Id", "spiffe://cluster.local/ns/billing/sa/worker")
+		id, ok := requestSpiffeID(r, settings)
+		if remoteAddr == "10.0.0.1" {
+			// A peer given without a port is still matched
+			assert.True(t, ok, remoteAddr)
+			assert.Equal(t, "spiffe://cluster.local/ns/billing/sa/worker", id)
+			continue
+		}
+		assert.False(t, ok, remoteAddr)
+		// The spoofed header is stripped, it isn't forwarded upstream
+		assert.Empty(t, r.Header.Get("X-Spiffe-Id"), remoteAddr)
+	}
+
+	// Without trusted proxies the header is never read
+	r := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Spiffe-Id", "spiffe://cluster.local/ns/billing/sa/worker")
+	_, ok := requestSpiffeID(r, WorkloadIdentity{Header: "X-Spiffe-Id"})
+	assert.False(t, ok)
+}
+
+func TestXfccSpiffeID(t *testing.T) {
+	// The last element is the client of the sidecar
+	header := `URI=spiffe://cluster.local/ns/a/sa/first,By=spiffe://cluster.local/ns/ai/sa/openshield;URI="spiffe://cluster.local/ns/b/sa/second"`
+	assert.Equal(t, "spiffe://cluster.local/ns/b/sa/second", xfccSpiffeID(header))
+	assert.Empty(t, xfccSpiffeID(""))
+}
+
+func TestWorkloadApiKeyID(t *testing.T) {
+	billing, reports := uuid.New(), uuid.New()
+	settings := WorkloadIdentity{Identities: []WorkloadIdentityKey{
+		{SpiffeID: "spiffe://cluster.local/ns/billing/sa/*", ApiKeyID: billing.String()},
+		{SpiffeID: "spiffe://cluster.local/ns/reports/sa/exporter", ApiKeyID: reports.String()},
+	}}
+
+	id, ok := workloadApiKeyID(settings, "spiffe://cluster.local/ns/billing/sa/worker")
+	assert.True(t, ok)
+	assert.Equal(t, billing, id)
+	id, ok = workloadApiKeyID(settings, "spiffe://cluster.local/ns/reports/sa/exporter")
+	assert.True(t, ok)
+	assert.Equal(t, reports, id)
+	_, ok = workloadApiKeyID(settings, "spiffe://cluster.local/ns/reports/sa/other")
+	assert.False(t, ok)
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/openshieldai/openshield/models"
+)
+
+const (
+	queuedAuditLog      = "audit_logs"
+	queuedUsage         = "usage"
+	queuedRuleExecution = "rule_executions"
+)
+
+// queuedWrite is a database insert that failed and waits to be retried
+type queuedWrite struct {
+	Table    string          `json:"table"`
+	Record   json.RawMessage `json:"record"`
+	QueuedAt time.Time       `json:"queued_at"`
+	Attempts int             `json:"attempts"`
+}
+
+// WriteQueueStats reports the state of the local write queue
+type WriteQueueStats struct {
+	Path      string    `json:"path"`
+	Backlog   int       `json:"backlog"`
+	Queued    int       `json:"queued"`
+	Replayed  int       `json:"replayed"`
+	Parked    int       `json:"parked"`
+	Dropped   int       `json:"dropped"`
+	LastRetry time.Time `json:"last_retry"`
+}
+
+var writeQueue struct {
+	sync.Mutex
+	loaded bool
+	stats  WriteQueueStats
+}
+
+// GetWriteQueueSettings returns the write queue settings with defaults applied
+func GetWriteQueueSettings() WriteQueue {
+	settings := WriteQueue{}
+	if queue := GetConfig().Settings.WriteQueue; queue != nil {
+		settings = *queue
+	}
+	if settings.Path == "" {
+		settings.Path = filepath.Join(os.TempDir(), "openshield-write-queue.jsonl")
+	}
+	if settings.MaxAttempts <= 0 {
+		settings.MaxAttempts = 10
+	}
+	if settings.MaxEntries <= 0 {
+		settings.MaxEntries = 10000
+	}
+	if settings.RetryInterval <= 0 {
+		settings.RetryInterval = 30
+	}
+	return settings
+}
+
+// createOrQueue inserts record and queues it on disk for a later retry if the insert fails
+func createOrQueue(table string, record interface{}) {
+	err := tryInsert(table, record)
+	if err == nil {
+		return
+	}
+	log.Printf("Error writing %s, queueing for retry: %v", table, err)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error encoding %s for the write queue: %v", table, err)
+		return
+	}
+	enqueueWrite(queuedWrite{Table: table, Record: data, QueuedAt: time.Now().UTC()})
+}
+
+// tryInsert inserts record, also reporting a database that can't be opened as an error
+func tryInsert(table string, record interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("database unavailable: %v", r)
+		}
+	}()
+	if usage, ok := record.(*models.Usage); ok && table == queuedUsage {
+		return insertUsage(usage)
+	}
+	return DB().Create(record).Error
+}
+
+// rejectedWrite reports whether the database rejected a write, a constraint
+// violation for example, rather than being unreachable. Retrying a rejected
+// write fails the same way however long the queue waits.
+func rejectedWrite(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) < 2 {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	// Connection exceptions, insufficient resources, operator intervention and system errors
+	case "08", "53", "57", "58":
+		return false
+	}
+	return true
+}
+
+// dropWrite counts a write the queue lost. The caller must hold the writeQueue lock.
+func dropWrite(table string) {
+	writeQueue.stats.Dropped++
+	IncrCounter("write_queue.dropped", 1, "table:"+table)
+}
+
+// setBacklog sets the backlog of the queue. The caller must hold the writeQueue lock.
+func setBacklog(backlog int) {
+	writeQueue.stats.Backlog = backlog
+	SetGauge("write_queue.backlog", float64(backlog))
+}
+
+func enqueueWrite(write queuedWrite) {
+	settings := GetWriteQueueSettings()
+
+	writeQueue.Lock()
+	defer writeQueue.Unlock()
+	loadWriteQueueStats(settings)
+
+	if writeQueue.stats.Backlog >= settings.MaxEntries {
+		dropWrite(write.Table)
+		log.Printf("Write queue is full (%d entries), dropping %s write", settings.MaxEntries, write.Table)
+		return
+	}
+
+	line, err := json.Marshal(write)
+	if err != nil {
+		dropWrite(write.Table)
+		return
+	}
+
+	file, err := os.OpenFile(settings.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		dropWrite(write.Table)
+		log.Printf("Error opening write queue, dropping %s write: %v", write.Table, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		dropWrite(write.Table)
+		log.Printf("Error appending to write queue, dropping %s write: %v", write.Table, err)
+		return
+	}
+	setBacklog(writeQueue.stats.Backlog + 1)
+	writeQueue.stats.Queued++
+}
+
+// loadWriteQueueStats counts the entries left in the queue file by a previous run.
+// The caller must hold the writeQueue lock.
+func loadWriteQueueStats(settings WriteQueue) {
+	if writeQueue.loaded {
+		return
+	}
+	writeQueue.loaded = true
+	writeQueue.stats.Path = settings.Path
+
+	entries, err := readWriteQueue(settings.Path)
+	if err != nil {
+		log.Printf("Error reading write queue: %v", err)
+		return
+	}
+	setBacklog(len(entries))
+}
+
+func readWriteQueue(path string) ([]queuedWrite, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []queuedWrite
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry queuedWrite
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Skipping corrupt write queue entry: %v", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// GetWriteQueueStats returns the backlog and counters of the write queue
+func GetWriteQueueStats() WriteQueueStats {
+	settings := GetWriteQueueSettings()
+
+	writeQueue.Lock()
+	defer writeQueue.Unlock()
+	loadWriteQueueStats(settings)
+	return writeQueue.stats
+}
+
+// RunWriteQueue retries queued writes on the configured interval until ctx is done.
+// The queue is local to the replica, so every replica runs its own retry loop.
+func RunWriteQueue(ctx context.Context) {
+	for {
+		settings := GetWriteQueueSettings()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(settings.RetryInterval) * time.Second):
+		}
+
+		if err := replayWriteQueue(settings); err != nil {
+			log.Printf("Error replaying write queue: %v", err)
+		}
+	}
+}
+
+func replayWriteQueue(settings WriteQueue) error {
+	writeQueue.Lock()
+	defer writeQueue.Unlock()
+	loadWriteQueueStats(settings)
+	writeQueue.stats.LastRetry = time.Now().UTC()
+
+	if writeQueue.stats.Backlog == 0 {
+		return nil
+	}
+
+	entries, err := readWriteQueue(settings.Path)
+	if err != nil {
+		return err
+	}
+
+	var remaining []queuedWrite
+	for i, entry := range entries {
+		record, err := decodeQueuedWrite(entry)
+		if err != nil {
+			log.Printf("Dropping queued %s write: %v", entry.Table, err)
+			dropWrite(entry.Table)
+			continue
+		}
+		if err := tryInsert(entry.Table, record); err != nil {
+			entry.Attempts++
+			if !rejectedWrite(err) {
+				log.Printf("Retry of queued %s write failed: %v", entry.Table, err)
+				// The database is likely still down, keep the rest for the next retry
+				remaining = append(remaining, entry)
+				remaining = append(remaining, entries[i+1:]...)
+				break
+			}
+			if entry.Attempts < settings.MaxAttempts {
+				log.Printf("Queued %s write rejected (attempt %d of %d): %v", entry.Table, entry.Attempts, settings.MaxAttempts, err)
+				remaining = append(remaining, entry)
+				continue
+			}
+			log.Printf("Parking queued %s write rejected %d times: %v", entry.Table, entry.Attempts, err)
+			parkWrite(settings, entry)
+			continue
+		}
+		writeQueue.stats.Replayed++
+	}
+
+	tmpPath := settings.Path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, entry := range remaining {
+		line, _ := json.Marshal(entry)
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, settings.Path); err != nil {
+		return err
+	}
+
+	setBacklog(len(remaining))
+	return nil
+}
+
+// parkWrite moves a write the database keeps rejecting out of the queue, to
+// the .parked file next to it, so it doesn't hold up the writes after it.
+// The caller must hold the writeQueue lock.
+func parkWrite(settings WriteQueue, entry queuedWrite) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		dropWrite(entry.Table)
+		return
+	}
+	file, err := os.OpenFile(settings.Path+".parked", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Error opening parked writes, dropping %s write: %v", entry.Table, err)
+		dropWrite(entry.Table)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error parking %s write, dropping it: %v", entry.Table, err)
+		dropWrite(entry.Table)
+		return
+	}
+	writeQueue.stats.Parked++
+	IncrCounter("write_queue.parked", 1, "table:"+entry.Table)
+}
+
+func decodeQueuedWrite(entry queuedWrite) (interface{}, error) {
+	var record interface{}
+	switch entry.Table {
+	case queuedAuditLog:
+		record = &models.AuditLogs{}
+	case queuedUsage:
+		record = &models.Usage{}
+	case queuedRuleExecution:
+		record = &models.RuleExecution{}
+	default:
+		return nil, fmt.Errorf("unknown table %s", entry.Table)
+	}
+	if err := json.Unmarshal(entry.Record, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
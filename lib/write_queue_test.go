@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectedWrite(t *testing.T) {
+	assert.True(t, rejectedWrite(&pgconn.PgError{Code: "23505"}))
+	assert.True(t, rejectedWrite(fmt.Errorf("insert: %w", &pgconn.PgError{Code: "22001"})))
+	assert.False(t, rejectedWrite(&pgconn.PgError{Code: "08006"}))
+	assert.False(t, rejectedWrite(&pgconn.PgError{Code: "57P01"}))
+	assert.False(t, rejectedWrite(errors.New("database unavailable: connection refused")))
+}
+
+func TestParkWrite(t *testing.T) {
+	settings := WriteQueue{Path: filepath.Join(t.TempDir(), "queue.jsonl")}
+	entry := queuedWrite{Table: queuedUsage, Record: []byte(`{}`), QueuedAt: time.Now().UTC(), Attempts: 10}
+
+	writeQueue.Lock()
+	parked := writeQueue.stats.Parked
+	parkWrite(settings, entry)
+	assert.Equal(t, parked+1, writeQueue.stats.Parked)
+	writeQueue.Unlock()
+
+	entries, err := readWriteQueue(settings.Path + ".parked")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, queuedUsage, entries[0].Table)
+	assert.Equal(t, 10, entries[0].Attempts)
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AdminSessions struct {
+	Base        `gorm:"embedded"`
+	AdminUserID uuid.UUID `gorm:"admin_user_id;type:uuid;not null;index"`
+	TokenHash   string    `gorm:"token_hash;not null;uniqueIndex"`
+	IPAddress   string    `faker:"ipv4" gorm:"ip_address;not null"`
+	ExpiresAt   time.Time `gorm:"expires_at;not null"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AdminRole string
+
+const (
+	AdminRoleAdmin  AdminRole = "admin"
+	AdminRoleViewer AdminRole = "viewer"
+)
+
+type AdminUsers struct {
+	Base         `gorm:"embedded"`
+	ExternalID   string     `gorm:"external_id"`
+	UserName     string     `faker:"username" gorm:"user_name;not null;uniqueIndex"`
+	DisplayName  string     `faker:"name" gorm:"display_name"`
+	Email        string     `faker:"email" gorm:"email"`
+	Role         AdminRole  `gorm:"role;not null;default:'viewer'"`
+	Status       Status     `faker:"status" sql:"status;not null;type:enum('active', 'inactive', 'archived');default:'active'"`
+	PasswordHash string     `faker:"-" gorm:"password_hash"`
+	TOTPSecret   string     `faker:"-" gorm:"totp_secret"`
+	FailedLogins int        `faker:"-" gorm:"failed_logins;not null;default:0"`
+	LockedUntil  *time.Time `faker:"-" gorm:"locked_until"`
+	WorkspaceID  *uuid.UUID `faker:"-" gorm:"workspace_id;type:uuid"`
+}
@@ -1,20 +1,35 @@
 package models
 
+import (
+	"time"
+)
+
 type AiFamily string
 
-// CREATE TYPE aifamily AS ENUM ('openai');
+// CREATE TYPE aifamily AS ENUM ('openai', 'anthropic');
 
 const (
-	OpenAI AiFamily = "openai"
+	OpenAI    AiFamily = "openai"
+	Anthropic AiFamily = "anthropic"
 )
 
 type AiModels struct {
 	Base      `gorm:"embedded"`
-	Family    AiFamily `faker:"aifamily" sql:"family;not null;type:enum('openai')"`
+	Family    AiFamily `faker:"aifamily" sql:"family;not null;type:enum('openai', 'anthropic')"`
 	ModelType string   `faker:"oneof: LLM,imagegen" gorm:"model_type;not null"`
 	Model     string   `faker:"oneof: gpt3.5,gpt4" gorm:"model;not null"`
 	Encoding  string   `faker:"oneof: SHA,MD5" gorm:"encoding;not null"`
 	Size      string   `faker:"oneof: small,medium,large" gorm:"size;"`
 	Quality   string   `faker:"oneof: low,medium,high" gorm:"quality;"`
 	Status    Status   `faker:"status" sql:"status;not null;type:enum('active', 'inactive', 'archived');default:'active'"`
+	// Metadata filled in by the catalog sync, prices are in USD per million tokens
+	ContextWindow   int        `gorm:"context_window"`
+	InputPrice      float64    `gorm:"input_price"`
+	OutputPrice     float64    `gorm:"output_price"`
+	Modalities      string     `faker:"oneof: text,text+image" gorm:"modalities"`
+	DeprecationDate *time.Time `gorm:"deprecation_date"`
+	// Set by admins, requests after the sunset are refused and pointed to the replacement
+	DeprecatedAt *time.Time `gorm:"deprecated_at"`
+	SunsetAt     *time.Time `gorm:"sunset_at"`
+	Replacement  string     `faker:"-" gorm:"replacement"`
 }
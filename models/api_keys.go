@@ -11,4 +11,8 @@ type ApiKeys struct {
 	Status    Status    `faker:"status" sql:"status;not null;index:idx_api_keys_status,unique;type:enum('active', 'inactive', 'archived')"`
 	Tags      string    `faker:"tags" gorm:"tags;<-:false"`
 	CreatedBy string    `faker:"uuid_hyphenated" gorm:"created_by;not null"`
+	// Scopes are the comma separated extra permissions of the key, like dry_run
+	Scopes string `faker:"-" gorm:"scopes"`
+	// RateLimitTier names the rate limit of the key among the tiers of the rate limiting settings
+	RateLimitTier string `faker:"-" gorm:"rate_limit_tier"`
 }
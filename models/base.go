@@ -16,10 +16,12 @@ type Base struct {
 
 type Status string
 
-// CREATE TYPE status AS ENUM ('active', 'inactive', 'archived');
+// CREATE TYPE status AS ENUM ('active', 'inactive', 'archived', 'pending');
 
 const (
 	Active   Status = "active"
 	Inactive Status = "inactive"
 	Archived Status = "archived"
+	// Pending marks models discovered upstream that admins haven't approved yet
+	Pending Status = "pending"
 )
@@ -0,0 +1,13 @@
+package models
+
+// BulkJobs tracks a bulk operation of the admin API that runs in the
+// background. Results holds the outcome of every operation as JSON.
+type BulkJobs struct {
+	Base      Base   `gorm:"embedded"`
+	Status    string `gorm:"status;not null"`
+	Total     int    `gorm:"total;not null"`
+	Succeeded int    `gorm:"succeeded;not null"`
+	Failed    int    `gorm:"failed;not null"`
+	Results   string `gorm:"results;not null"`
+	CreatedBy string `gorm:"created_by"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// FineTuneJobs records a fine-tuning job created through the gateway, so jobs
+// are listed and reachable only from the workspace that created them
+type FineTuneJobs struct {
+	Base            Base      `gorm:"embedded"`
+	JobID           string    `gorm:"job_id;not null;uniqueIndex"`
+	WorkspaceID     uuid.UUID `gorm:"workspace_id;type:uuid;not null;index"`
+	ProductID       uuid.UUID `gorm:"product_id;type:uuid;not null"`
+	ApiKeyID        uuid.UUID `gorm:"api_key_id;type:uuid;not null"`
+	Model           string    `gorm:"model;not null"`
+	TrainingFile    string    `gorm:"training_file;not null"`
+	ValidationFile  string    `gorm:"validation_file"`
+	ExamplesScanned int       `gorm:"examples_scanned;not null"`
+	Status          string    `gorm:"status;not null"`
+	FineTunedModel  string    `gorm:"fine_tuned_model"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// ModelApprovals enables a model that is pending approval for one workspace
+type ModelApprovals struct {
+	Base        Base      `gorm:"embedded"`
+	AiModelID   uuid.UUID `gorm:"ai_model_id;type:uuid;not null;uniqueIndex:idx_model_approvals_workspace"`
+	WorkspaceID uuid.UUID `gorm:"workspace_id;type:uuid;not null;uniqueIndex:idx_model_approvals_workspace"`
+	ApprovedBy  string    `gorm:"approved_by;not null"`
+}
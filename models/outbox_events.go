@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSent    OutboxStatus = "sent"
+	OutboxFailed  OutboxStatus = "failed"
+)
+
+type OutboxEvents struct {
+	Base          `gorm:"embedded"`
+	EventType     string       `gorm:"event_type;not null"`
+	AggregateID   uuid.UUID    `gorm:"aggregate_id;type:uuid;not null"`
+	Payload       string       `gorm:"payload;not null"`
+	Status        OutboxStatus `gorm:"status;not null;default:'pending';index"`
+	Attempts      int          `gorm:"attempts;not null;default:0"`
+	LastError     string       `gorm:"last_error"`
+	NextAttemptAt time.Time    `gorm:"next_attempt_at;not null;default:now();index"`
+	SentAt        *time.Time   `gorm:"sent_at"`
+}
@@ -11,4 +11,11 @@ type Products struct {
 	WorkspaceID uuid.UUID `gorm:"workspace_id;type:uuid;not null"`
 	Tags        string    `faker:"tags" gorm:"tags;<-:false"`
 	CreatedBy   string    `faker:"uuid_hyphenated" gorm:"created_by;not null"`
+	// StrictContent applies the strict content profile for products exposed to minors
+	StrictContent bool `gorm:"strict_content;not null;default:false"`
+	// OpenAIOrganization and OpenAIProject override those of the workspace
+	OpenAIOrganization string `faker:"-" gorm:"column:openai_organization"`
+	OpenAIProject      string `faker:"-" gorm:"column:openai_project"`
+	// Version counts the changes of the product, a write of an older version is rejected
+	Version int `faker:"-" gorm:"version;not null;default:1"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// RuleExecution is the result of a rule that ran on a request or a response.
+// Findings holds the counts of the detected entities as JSON, never their values.
+type RuleExecution struct {
+	Base      `gorm:"embedded"`
+	RequestId string    `gorm:"request_id;index"`
+	ApiKeyID  uuid.UUID `gorm:"api_key_id;type:uuid"`
+	RuleName  string    `gorm:"rule_name;not null;index"`
+	RuleType  string    `gorm:"rule_type;not null"`
+	Direction string    `gorm:"direction;not null"`
+	Action    string    `gorm:"action;not null"`
+	Matches   int       `gorm:"matches;not null"`
+	Findings  string    `gorm:"findings;not null"`
+}
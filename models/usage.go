@@ -2,16 +2,37 @@ package models
 
 import "github.com/google/uuid"
 
+// FinishReason is why a model stopped generating, the same for every provider
 type FinishReason string
 
 const (
 	Stop          FinishReason = "stop"
 	Length        FinishReason = "length"
-	Null          FinishReason = "null"
-	FunctionCall  FinishReason = "function_call"
 	ContentFilter FinishReason = "content_filter"
+	ToolCalls     FinishReason = "tool_calls"
+	// Error is the finish reason of provider failures and of provider values without a mapping
+	Error FinishReason = "error"
+	// ClientDisconnect is the finish reason of streams the client aborted
+	ClientDisconnect FinishReason = "client_disconnect"
+	// Null is the finish reason of requests that ended without one
+	Null FinishReason = "null"
+	// FunctionCall is the finish reason of the legacy function calling, recorded as ToolCalls
+	FunctionCall FinishReason = "function_call"
 )
 
+// FinishReasons are the finish reasons usage is recorded with
+var FinishReasons = []FinishReason{Stop, Length, ContentFilter, ToolCalls, Error, ClientDisconnect, Null}
+
+// Valid reports whether r is one of FinishReasons
+func (r FinishReason) Valid() bool {
+	for _, reason := range FinishReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
 type Usage struct {
 	Base                 `gorm:"embedded"`
 	ModelID              uuid.UUID    `gorm:"model_id;<-:create;not null"`
@@ -21,4 +42,11 @@ type Usage struct {
 	TotalTokens          int          `gorm:"total_tokens;<-:create;not null"`
 	FinishReason         FinishReason `faker:"finishreason" gorm:"finish_reason;<-:create;not null"`
 	RequestType          string       `gorm:"request_type;<-:create;not null"`
+	Raced                bool         `gorm:"raced;<-:create;not null;default:false"`
+	DocumentsCount       int          `gorm:"documents_count;<-:create;not null;default:0"`
+	HashSalt             string       `faker:"-" gorm:"hash_salt;<-:create"`
+	RequestHash          string       `faker:"-" gorm:"request_hash;<-:create"`
+	ResponseHash         string       `faker:"-" gorm:"response_hash;<-:create"`
+	ApiKeyID             *uuid.UUID   `faker:"-" gorm:"api_key_id;type:uuid;<-:create"`
+	WorkspaceID          *uuid.UUID   `faker:"-" gorm:"workspace_id;type:uuid;<-:create"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UsageAggregates holds the usage totals of an API key, workspace and model in
+// an hour or a day, so dashboards don't scan the usage rows. Usage without an
+// API key or workspace is counted under uuid.Nil.
+type UsageAggregates struct {
+	Base             `gorm:"embedded"`
+	Period           string    `gorm:"period;not null;uniqueIndex:idx_usage_aggregates_bucket"`
+	BucketStart      time.Time `gorm:"bucket_start;not null;uniqueIndex:idx_usage_aggregates_bucket"`
+	ApiKeyID         uuid.UUID `gorm:"api_key_id;type:uuid;not null;uniqueIndex:idx_usage_aggregates_bucket"`
+	WorkspaceID      uuid.UUID `gorm:"workspace_id;type:uuid;not null;uniqueIndex:idx_usage_aggregates_bucket"`
+	ModelID          uuid.UUID `gorm:"model_id;type:uuid;not null;uniqueIndex:idx_usage_aggregates_bucket"`
+	Requests         int64     `gorm:"requests;not null"`
+	PromptTokens     int64     `gorm:"prompt_tokens;not null"`
+	CompletionTokens int64     `gorm:"completion_tokens;not null"`
+	TotalTokens      int64     `gorm:"total_tokens;not null"`
+}
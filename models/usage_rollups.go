@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UsageRollups holds the daily totals of usage rows moved to cold storage, per
+// model and request type, so historical aggregates don't need the rows
+type UsageRollups struct {
+	Base             `gorm:"embedded"`
+	Day              time.Time `gorm:"day;type:date;not null;index"`
+	ModelID          uuid.UUID `gorm:"model_id;type:uuid;not null"`
+	RequestType      string    `gorm:"request_type;not null"`
+	Requests         int64     `gorm:"requests;not null"`
+	PromptTokens     int64     `gorm:"prompt_tokens;not null"`
+	CompletionTokens int64     `gorm:"completion_tokens;not null"`
+	TotalTokens      int64     `gorm:"total_tokens;not null"`
+	// Archive is the key of the Parquet file holding the rows of the day
+	Archive string `gorm:"archive;not null"`
+}
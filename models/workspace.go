@@ -6,4 +6,8 @@ type Workspaces struct {
 	Name      string `gorm:"name;not null"`
 	Tags      string `faker:"tags" gorm:"tags;<-:false"`
 	CreatedBy string `faker:"uuid_hyphenated" gorm:"created_by;not null"`
+	// OpenAIOrganization and OpenAIProject are sent to OpenAI with the requests of
+	// the workspace, so provider-side billing is segmented like the gateway
+	OpenAIOrganization string `faker:"-" gorm:"column:openai_organization"`
+	OpenAIProject      string `faker:"-" gorm:"column:openai_project"`
 }
@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Combinations of the signals of a combined rule
+const (
+	combineAnd      = "and"
+	combineOr       = "or"
+	combineWeighted = "weighted"
+)
+
+// signalScore scores text with one signal of a combined rule, between 0 and 1
+func signalScore(signal lib.Signal, text string) (float64, error) {
+	switch {
+	case signal.Detector != "":
+		detector, err := lib.GetDetector(signal.Detector)
+		if err != nil {
+			return 0, err
+		}
+		score, err := detector.Score(text)
+		if err != nil {
+			return 0, fmt.Errorf("detector %s failed: %v", signal.Detector, err)
+		}
+		return score, nil
+	case signal.PluginName != "":
+		result, err := sendRequest(Rule{
+			Prompt: openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: text}}},
+			Config: lib.Config{PluginName: signal.PluginName},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return result.Inspection.Score, nil
+	}
+	return 0, fmt.Errorf("signal needs a detector or a plugin_name")
+}
+
+// signalName names a signal in the logs
+func signalName(signal lib.Signal) string {
+	if signal.Detector != "" {
+		return "detector " + signal.Detector
+	}
+	return "plugin " + signal.PluginName
+}
+
+// combinedMatch scores text with the signals of a combined rule. With and every
+// signal has to reach its threshold, with or one of them, and a weighted rule
+// matches when the weighted mean of the scores reaches the threshold of the rule.
+// And and or stop at the first signal that decides the result. The returned score
+// is what the match adds to the session risk.
+func combinedMatch(rule lib.Rule, text string) (bool, float64, error) {
+	signals := rule.Config.Signals
+	if len(signals) == 0 {
+		return false, 0, fmt.Errorf("combined rule %s has no signals", rule.Name)
+	}
+
+	combine := rule.Config.Combine
+	if combine == "" {
+		combine = combineAnd
+	}
+	var weighted, weights float64
+	lowest := 1.0
+	for _, signal := range signals {
+		score, err := signalScore(signal, text)
+		if err != nil {
+			return false, 0, err
+		}
+		threshold := signal.Threshold
+		if threshold <= 0 {
+			threshold = defaultDetectorThreshold
+		}
+		match := score*100 >= float64(threshold)
+		log.Printf("Combined rule %s, %s result: Match=%v, Score=%f", rule.Name, signalName(signal), match, score)
+
+		switch combine {
+		case combineAnd:
+			if !match {
+				return false, 0, nil
+			}
+			lowest = min(lowest, score)
+		case combineOr:
+			if match {
+				return true, score, nil
+			}
+		case combineWeighted:
+			weight := signal.Weight
+			if weight <= 0 {
+				weight = 1
+			}
+			weighted += weight * score
+			weights += weight
+		default:
+			return false, 0, fmt.Errorf("combined rule %s: combine must be and, or or weighted", rule.Name)
+		}
+	}
+
+	switch combine {
+	case combineAnd:
+		return true, lowest, nil
+	case combineOr:
+		return false, 0, nil
+	}
+	threshold := rule.Config.Threshold
+	if threshold <= 0 {
+		threshold = defaultDetectorThreshold
+	}
+	score := weighted / weights
+	log.Printf("Combined rule %s weighted score: %f", rule.Name, score)
+	return score*100 >= float64(threshold), score, nil
+}
+
+func handleCombinedInputRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	extractedPrompt, _, err := extractUserPrompt(userPrompt)
+	if err != nil {
+		log.Println(err)
+		return true, err.Error(), err
+	}
+
+	match, score, err := combinedMatch(inputConfig, extractedPrompt)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	if match {
+		recordRisk(r, inputConfig, score)
+		if inputConfig.Action.Type == "block" {
+			log.Printf("Blocking request due to combined rule %s.", inputConfig.Name)
+			return true, "request blocked due to rule match", nil
+		}
+		log.Printf("Monitoring request due to combined rule %s.", inputConfig.Name)
+	}
+	return false, "", nil
+}
+
+func handleCombinedOutputRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	for _, choice := range resp.Choices {
+		match, _, err := combinedMatch(outputConfig, choice.Message.Content)
+		if err != nil {
+			return true, err.Error(), err
+		}
+		if match && outputAction(r, outputConfig, "combined rule "+outputConfig.Name, fmt.Sprintf("completion matched combined rule %s", outputConfig.Name)) {
+			return true, "response blocked due to rule match", nil
+		}
+	}
+	return false, "", nil
+}
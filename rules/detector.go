@@ -0,0 +1,68 @@
+package rules
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultDetectorThreshold is the score in percent a detector rule matches at
+// when it sets no threshold
+const defaultDetectorThreshold = 50
+
+// detectorMatch scores text with the in-process detector of a rule, the rule
+// matches at its threshold in percent
+func detectorMatch(rule lib.Rule, text string) (bool, float64, error) {
+	detector, err := lib.GetDetector(rule.Config.Detector)
+	if err != nil {
+		return false, 0, err
+	}
+	score, err := detector.Score(text)
+	if err != nil {
+		return false, 0, fmt.Errorf("detector %s failed: %v", rule.Config.Detector, err)
+	}
+	threshold := rule.Config.Threshold
+	if threshold <= 0 {
+		threshold = defaultDetectorThreshold
+	}
+	log.Printf("Detector %s result: Score=%f", rule.Config.Detector, score)
+	return score*100 >= float64(threshold), score, nil
+}
+
+func handleDetectorInputRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	extractedPrompt, _, err := extractUserPrompt(userPrompt)
+	if err != nil {
+		log.Println(err)
+		return true, err.Error(), err
+	}
+
+	match, score, err := detectorMatch(inputConfig, extractedPrompt)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	if match {
+		recordRisk(r, inputConfig, score)
+		if inputConfig.Action.Type == "block" {
+			log.Printf("Blocking request due to detector %s.", inputConfig.Config.Detector)
+			return true, "request blocked due to rule match", nil
+		}
+		log.Printf("Monitoring request due to detector %s.", inputConfig.Config.Detector)
+	}
+	return false, "", nil
+}
+
+func handleDetectorOutputRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	for _, choice := range resp.Choices {
+		match, _, err := detectorMatch(outputConfig, choice.Message.Content)
+		if err != nil {
+			return true, err.Error(), err
+		}
+		if match && outputAction(r, outputConfig, "detector "+outputConfig.Config.Detector, fmt.Sprintf("completion matched detector %s", outputConfig.Config.Detector)) {
+			return true, "response blocked due to rule match", nil
+		}
+	}
+	return false, "", nil
+}
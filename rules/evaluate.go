@@ -0,0 +1,114 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Verdict is the result of one rule for a text. Matched is also set for rules
+// in monitoring, Blocked only for the rules that would refuse the request.
+type Verdict struct {
+	Rule        string `json:"rule"`
+	Type        string `json:"type"`
+	Matched     bool   `json:"matched"`
+	Blocked     bool   `json:"blocked"`
+	Intercepted bool   `json:"intercepted"`
+	Message     string `json:"message,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Evaluate runs every rule of a direction, "input" or "output", on a text
+// without calling a provider and returns the verdict of each rule with the text
+// as the rules changed it. Rules run one at a time in their configured order,
+// each on the text the previous left, and sampling doesn't apply. Flagging for
+// review only counts as a match.
+func Evaluate(r *http.Request, direction string, text string) ([]Verdict, string, error) {
+	switch direction {
+	case "input":
+		prompt := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: text}}}
+		verdicts := EvaluateInput(r, prompt)
+		return verdicts, prompt.Messages[0].Content, nil
+	case "output":
+		resp := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text},
+		}}}
+		verdicts := evaluateRules(r, lib.GetRequestConfig(r).Rules.Output, func(ruleRequest *http.Request) (bool, string, error) {
+			return Output(ruleRequest, &resp)
+		})
+		return verdicts, resp.Choices[0].Message.Content, nil
+	}
+	return nil, text, errors.New("direction must be input or output")
+}
+
+// EvaluateInput runs the input rules on a request like Evaluate and returns
+// their verdicts. The messages of the request are changed like the rules would.
+func EvaluateInput(r *http.Request, prompt openai.ChatCompletionRequest) []Verdict {
+	return evaluateRules(r, lib.GetRequestConfig(r).Rules.Input, func(ruleRequest *http.Request) (bool, string, error) {
+		return Input(ruleRequest, prompt)
+	})
+}
+
+// evaluateRules calls run once per rule, with a request whose config only has
+// that rule, and records its verdict
+func evaluateRules(r *http.Request, configured []lib.Rule, run func(ruleRequest *http.Request) (bool, string, error)) []Verdict {
+	config := lib.GetRequestConfig(r)
+	verdicts := []Verdict{}
+	for _, rule := range configured {
+		if !rule.Enabled || !appliesToProduct(r, rule) || !appliesToSchedule(r, rule) {
+			continue
+		}
+		// Evaluations run every rule, whatever the route or share of traffic it is limited to
+		rule.Sampling, rule.Routes = nil, nil
+		if rule.Action.Type == "flag" {
+			rule.Action.Type = "monitor"
+		}
+
+		evaluated := config
+		evaluated.Rules.Input, evaluated.Rules.Output = []lib.Rule{rule}, []lib.Rule{rule}
+		hits := 0
+		ctx := context.WithValue(r.Context(), "config", evaluated)
+		ctx = context.WithValue(ctx, "ruleHits", &hits)
+
+		var verdict Verdict
+		var err error
+		verdict.Blocked, verdict.Message, err = run(r.WithContext(ctx))
+		verdict.Rule, verdict.Type = rule.Name, rule.Type
+		var interception *Interception
+		switch {
+		case errors.As(err, &interception):
+			verdict.Intercepted = true
+		case err != nil && !verdict.Blocked:
+			verdict.Error = err.Error()
+		}
+		verdict.Matched = verdict.Blocked || hits > 0
+		if !verdict.Blocked {
+			verdict.Message = ""
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	return verdicts
+}
+
+// ValidType reports whether ruleType is a rule type of a direction, "input" or "output"
+func ValidType(direction string, ruleType string) bool {
+	var types reflect.Value
+	switch direction {
+	case "input":
+		types = reflect.ValueOf(inputTypes)
+	case "output":
+		types = reflect.ValueOf(outputTypes)
+	default:
+		return false
+	}
+	for i := 0; i < types.NumField(); i++ {
+		if types.Field(i).String() == ruleType {
+			return true
+		}
+	}
+	return false
+}
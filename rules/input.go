@@ -2,11 +2,13 @@ package rules
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/openshieldai/openshield/lib"
 	"github.com/sashabaranov/go-openai"
@@ -17,6 +19,12 @@ type InputTypes struct {
 	PromptInjection   string
 	PIIFilter         string
 	InvisibleChars    string
+	SelfHarm          string
+	Topic             string
+	RAGCollections    string
+	Detector          string
+	Combined          string
+	PIIScanner        string
 }
 
 type Rule struct {
@@ -45,6 +53,12 @@ var inputTypes = InputTypes{
 	PromptInjection:   "prompt_injection",
 	PIIFilter:         "pii_filter",
 	InvisibleChars:    "invisible_chars",
+	SelfHarm:          "self_harm",
+	Topic:             "topic",
+	RAGCollections:    "rag_collections",
+	Detector:          "detector",
+	Combined:          "combined",
+	PIIScanner:        "pii_scanner",
 }
 
 func sendRequest(data Rule) (RuleResult, error) {
@@ -52,6 +66,12 @@ func sendRequest(data Rule) (RuleResult, error) {
 	if err != nil {
 		return RuleResult{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
+	if cached, ok := lib.GetClassifierVerdict(context.Background(), jsonify); ok {
+		var rule RuleResult
+		if err := json.Unmarshal(cached, &rule); err == nil {
+			return rule, nil
+		}
+	}
 
 	req, err := http.NewRequest("POST", lib.GetConfig().Settings.RuleServer.Url+"/rule/execute", bytes.NewBuffer(jsonify))
 	if err != nil {
@@ -76,6 +96,9 @@ func sendRequest(data Rule) (RuleResult, error) {
 	if err != nil {
 		return RuleResult{}, fmt.Errorf("failed to decode response: %v", err)
 	}
+	if resp.StatusCode == http.StatusOK {
+		lib.SetClassifierVerdict(context.Background(), jsonify, body)
+	}
 
 	return rule, nil
 }
@@ -89,7 +112,7 @@ func extractUserPrompt(userPrompt openai.ChatCompletionRequest) (string, int, er
 	return "", -1, fmt.Errorf("no user message found in the request")
 }
 
-func handleRule(inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest, ruleType string) (bool, string, error) {
+func handleRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest, ruleType string) (bool, string, error) {
 	if !inputConfig.Enabled {
 		return false, "", nil
 	}
@@ -109,6 +132,7 @@ func handleRule(inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest, r
 	}
 
 	log.Printf("%s detection result: Match=%v, Score=%f", ruleType, rule.Match, rule.Inspection.Score)
+	recordRuleRisk(r, inputConfig, rule, ruleType)
 
 	switch ruleType {
 	case inputTypes.InvisibleChars:
@@ -116,15 +140,34 @@ func handleRule(inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest, r
 	case inputTypes.LanguageDetection:
 		return handleLanguageDetectionAction(rule)
 	case inputTypes.PIIFilter:
-		return handlePIIFilterAction(inputConfig, rule, userPrompt, userMessageIndex)
-	case inputTypes.PromptInjection:
-		return handlePromptInjectionAction(inputConfig, rule)
+		return handlePIIFilterAction(r, inputConfig, rule, userPrompt, userMessageIndex)
+	case inputTypes.SelfHarm:
+		return handleSelfHarmAction(inputConfig, rule)
 	default:
 		log.Printf("%s Rule Not Matched", ruleType)
 		return false, "", nil
 	}
 }
 
+// recordRuleRisk adds a hit of a rule server rule to the session risk score.
+// A language detection hit is a low English probability.
+func recordRuleRisk(r *http.Request, inputConfig lib.Rule, rule RuleResult, ruleType string) {
+	switch ruleType {
+	case inputTypes.LanguageDetection:
+		if !rule.Match {
+			recordRisk(r, inputConfig, 1-rule.Inspection.Score)
+		}
+	case inputTypes.PIIFilter:
+		if rule.Inspection.CheckResult {
+			recordRisk(r, inputConfig, rule.Inspection.Score)
+		}
+	default:
+		if rule.Match {
+			recordRisk(r, inputConfig, rule.Inspection.Score)
+		}
+	}
+}
+
 func handleInvisibleCharsAction(inputConfig lib.Rule, rule RuleResult) (bool, string, error) {
 	if rule.Match {
 		if inputConfig.Action.Type == "block" {
@@ -146,10 +189,11 @@ func handleLanguageDetectionAction(rule RuleResult) (bool, string, error) {
 	return false, "", nil
 }
 
-func handlePIIFilterAction(inputConfig lib.Rule, rule RuleResult, userPrompt openai.ChatCompletionRequest, userMessageIndex int) (bool, string, error) {
+func handlePIIFilterAction(r *http.Request, inputConfig lib.Rule, rule RuleResult, userPrompt openai.ChatCompletionRequest, userMessageIndex int) (bool, string, error) {
 	if rule.Inspection.CheckResult {
 		log.Println("PII detected, anonymizing content")
 		userPrompt.Messages[userMessageIndex].Content = rule.Inspection.AnonymizedContent
+		lib.RecordTransformation(r, "redaction", fmt.Sprintf("PII in the user message anonymized by rule %s", inputConfig.Name))
 		if inputConfig.Action.Type == "block" {
 			log.Println("Blocking request due to PII detection.")
 			return true, "request blocked due to PII detection", nil
@@ -161,25 +205,33 @@ func handlePIIFilterAction(inputConfig lib.Rule, rule RuleResult, userPrompt ope
 	return false, "", nil
 }
 
-func handlePromptInjectionAction(inputConfig lib.Rule, rule RuleResult) (bool, string, error) {
-	if rule.Match {
-		if inputConfig.Action.Type == "block" {
-			log.Println("Blocking request due to prompt injection detection.")
-			return true, "request blocked due to rule match", nil
-		}
-		log.Println("Monitoring request due to prompt injection detection.")
-	}
-	log.Println("Prompt Injection Rule Not Matched")
-	return false, "", nil
+// Input runs the input rules on a chat completion request, in a span of the
+// trace of the request
+func Input(r *http.Request, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	ctx, span := lib.StartSpan(r.Context(), "rules.input")
+	blocked, message, err := runInput(r.WithContext(ctx), userPrompt)
+	span.SetAttribute("openshield.blocked", strconv.FormatBool(blocked))
+	span.End(err)
+	return blocked, message, err
 }
 
-func Input(r *http.Request, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
-	config := lib.GetConfig()
+func runInput(r *http.Request, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	config := lib.GetRequestConfig(r)
 
 	log.Println("Starting Input function")
 
+	breakGlass, breakGlassActive := lib.BreakGlassActive()
+	r = withRuleHits(r)
+
 	for input := range config.Rules.Input {
 		inputConfig := config.Rules.Input[input]
+		if breakGlassActive && !inputConfig.Critical {
+			log.Printf("Break-glass active until %v, skipping non-critical input rule: %s", breakGlass.ExpiresAt, inputConfig.Name)
+			continue
+		}
+		if !appliesToProduct(r, inputConfig) || !appliesToRoute(r, inputConfig) || !appliesToSchedule(r, inputConfig) || !sampled(r, inputConfig) || (inputConfig.Enabled && lib.PolicyOverridden(r, inputConfig)) {
+			continue
+		}
 		log.Printf("Processing input rule: %s", inputConfig.Type)
 
 		var blocked bool
@@ -188,13 +240,35 @@ func Input(r *http.Request, userPrompt openai.ChatCompletionRequest) (bool, stri
 
 		switch inputConfig.Type {
 		case inputTypes.InvisibleChars:
-			blocked, message, err = handleRule(inputConfig, userPrompt, inputTypes.InvisibleChars)
+			blocked, message, err = handleRule(r, inputConfig, userPrompt, inputTypes.InvisibleChars)
 		case inputTypes.LanguageDetection:
-			blocked, message, err = handleRule(inputConfig, userPrompt, inputTypes.LanguageDetection)
+			blocked, message, err = handleRule(r, inputConfig, userPrompt, inputTypes.LanguageDetection)
 		case inputTypes.PIIFilter:
-			blocked, message, err = handleRule(inputConfig, userPrompt, inputTypes.PIIFilter)
+			blocked, message, err = handleRule(r, inputConfig, userPrompt, inputTypes.PIIFilter)
 		case inputTypes.PromptInjection:
-			blocked, message, err = handleRule(inputConfig, userPrompt, inputTypes.PromptInjection)
+			blocked, message, err = handlePromptInjectionRule(r, inputConfig, userPrompt)
+		case inputTypes.SelfHarm:
+			blocked, message, err = handleRule(r, inputConfig, userPrompt, inputTypes.SelfHarm)
+		case inputTypes.Topic:
+			if inputConfig.Enabled {
+				blocked, message, err = handleTopicInputRule(r, inputConfig, userPrompt)
+			}
+		case inputTypes.RAGCollections:
+			if inputConfig.Enabled {
+				blocked, message, err = handleRAGCollectionsRule(r, inputConfig)
+			}
+		case inputTypes.Detector:
+			if inputConfig.Enabled {
+				blocked, message, err = handleDetectorInputRule(r, inputConfig, userPrompt)
+			}
+		case inputTypes.Combined:
+			if inputConfig.Enabled {
+				blocked, message, err = handleCombinedInputRule(r, inputConfig, userPrompt)
+			}
+		case inputTypes.PIIScanner:
+			if inputConfig.Enabled {
+				blocked, message, err = handlePIIScannerInputRule(r, inputConfig, userPrompt)
+			}
 		default:
 			log.Printf("ERROR: Invalid input filter type %s", inputConfig.Type)
 		}
@@ -1 +1,252 @@
 package rules
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+type OutputTypes struct {
+	LowConfidence string
+	URLValidation string
+	Profanity     string
+	Topic         string
+	Detector      string
+	Combined      string
+	PIIScanner    string
+}
+
+var outputTypes = OutputTypes{
+	LowConfidence: "low_confidence",
+	URLValidation: "url_validation",
+	Profanity:     "profanity",
+	Topic:         "topic",
+	Detector:      "detector",
+	Combined:      "combined",
+	PIIScanner:    "pii_scanner",
+}
+
+// maxCheckedLinks bounds the links a url validation rule checks per completion
+const maxCheckedLinks = 20
+
+// defaultMinConfidence is used when a low confidence rule sets no min_confidence
+const defaultMinConfidence = 0.5
+
+// OutputNeedsLogProbs reports whether an enabled output rule consumes logprobs,
+// so they have to be requested from the provider even if the client didn't
+func OutputNeedsLogProbs(r *http.Request) bool {
+	for _, outputConfig := range lib.GetRequestConfig(r).Rules.Output {
+		if outputConfig.Enabled && outputConfig.Type == outputTypes.LowConfidence && appliesToProduct(r, outputConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamOutputActive reports whether an enabled output rule applies to streamed
+// completions, which carry no logprobs for the low confidence rule
+func StreamOutputActive(r *http.Request) bool {
+	for _, outputConfig := range lib.GetRequestConfig(r).Rules.Output {
+		if outputConfig.Enabled && outputConfig.Type != outputTypes.LowConfidence && appliesToProduct(r, outputConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamOutputWindow returns how many bytes of streamed content are held back
+// and checked at once by the output rules
+func StreamOutputWindow() int {
+	if settings := lib.GetConfig().Settings.StreamOutputRules; settings != nil && settings.WindowBytes > 0 {
+		return settings.WindowBytes
+	}
+	return 512
+}
+
+// Confidence returns the geometric mean probability of the tokens of a choice,
+// false if the choice carries no logprobs
+func Confidence(choice openai.ChatCompletionChoice) (float64, bool) {
+	if choice.LogProbs == nil || len(choice.LogProbs.Content) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, token := range choice.LogProbs.Content {
+		sum += token.LogProb
+	}
+	return math.Exp(sum / float64(len(choice.LogProbs.Content))), true
+}
+
+func handleLowConfidenceRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	minConfidence := outputConfig.Config.MinConfidence
+	if minConfidence <= 0 {
+		minConfidence = defaultMinConfidence
+	}
+
+	for _, choice := range resp.Choices {
+		confidence, ok := Confidence(choice)
+		if !ok {
+			log.Printf("Low confidence rule %s: no logprobs in choice %d", outputConfig.Name, choice.Index)
+			continue
+		}
+		log.Printf("Low confidence detection result: choice=%d, confidence=%.4f", choice.Index, confidence)
+		if confidence >= minConfidence {
+			continue
+		}
+
+		if outputAction(r, outputConfig, "low confidence", fmt.Sprintf("completion confidence too low: %.4f", confidence)) {
+			return true, "response blocked due to low confidence", nil
+		}
+	}
+	return false, "", nil
+}
+
+func handleURLValidationRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	var links []string
+	for _, choice := range resp.Choices {
+		for _, link := range lib.ExtractLinks(choice.Message.Content) {
+			if len(links) == maxCheckedLinks {
+				log.Printf("URL validation rule %s: more than %d links, skipping the rest", outputConfig.Name, maxCheckedLinks)
+				break
+			}
+			links = append(links, link)
+		}
+	}
+	var invalid []string
+	for i, err := range lib.CheckLinks(r.Context(), links, outputConfig.Config.AllowedDomains, outputConfig.Config.CacheTTL) {
+		if err != nil {
+			log.Printf("URL validation: %s is invalid: %v", links[i], err)
+			invalid = append(invalid, links[i])
+		}
+	}
+	log.Printf("URL validation result: checked=%d, invalid=%d", len(links), len(invalid))
+
+	if len(invalid) > 0 && outputAction(r, outputConfig, "invalid links", fmt.Sprintf("invalid links: %s", strings.Join(invalid, ", "))) {
+		return true, "response blocked due to invalid links", nil
+	}
+	return false, "", nil
+}
+
+// handleProfanityRule masks profanity in the completion, unless the action is
+// block, which rejects the whole response instead
+func handleProfanityRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	filter := newProfanityFilter(outputConfig.Config.Locales, outputConfig.Config.Words)
+
+	total := 0
+	for i, choice := range resp.Choices {
+		masked, count := filter.mask(choice.Message.Content)
+		if count == 0 {
+			continue
+		}
+		total += count
+		if outputConfig.Action.Type != "block" {
+			resp.Choices[i].Message.Content = masked
+		}
+	}
+	log.Printf("Profanity detection result: matches=%d", total)
+
+	if total == 0 {
+		return false, "", nil
+	}
+	recordRisk(r, outputConfig, 0)
+	switch outputConfig.Action.Type {
+	case "block":
+		log.Println("Blocking response due to profanity.")
+		return true, "response blocked due to profanity", nil
+	case "flag":
+		log.Println("Masking profanity and flagging response for review.")
+		flagForReview(r, outputConfig, fmt.Sprintf("%d profane words masked", total))
+	default:
+		log.Println("Masking profanity in response.")
+	}
+	return false, "", nil
+}
+
+// outputAction applies the action of a matched output rule and reports whether the response is blocked
+func outputAction(r *http.Request, outputConfig lib.Rule, reason string, message string) bool {
+	recordRisk(r, outputConfig, 0)
+	switch outputConfig.Action.Type {
+	case "block":
+		log.Printf("Blocking response due to %s.", reason)
+		return true
+	case "flag":
+		log.Printf("Flagging response for review due to %s.", reason)
+		flagForReview(r, outputConfig, message)
+	default:
+		log.Printf("Monitoring response due to %s.", reason)
+	}
+	return false
+}
+
+// flagForReview records a response that a rule flagged in the audit log
+func flagForReview(r *http.Request, outputConfig lib.Rule, message string) {
+	apiKeyId, _ := r.Context().Value("apiKeyId").(uuid.UUID)
+	lib.AuditLogs(fmt.Sprintf("%s: %s", outputConfig.Name, message), "openai_chat_completion", apiKeyId, "review", r)
+}
+
+// Output applies the output rules to a completion, in a span of the trace of
+// the request
+func Output(r *http.Request, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	ctx, span := lib.StartSpan(r.Context(), "rules.output")
+	blocked, message, err := runOutput(r.WithContext(ctx), resp)
+	span.SetAttribute("openshield.blocked", strconv.FormatBool(blocked))
+	span.End(err)
+	return blocked, message, err
+}
+
+func runOutput(r *http.Request, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	config := lib.GetRequestConfig(r)
+
+	breakGlass, breakGlassActive := lib.BreakGlassActive()
+	r = withRuleHits(r)
+
+	for output := range config.Rules.Output {
+		outputConfig := config.Rules.Output[output]
+		if !outputConfig.Enabled {
+			continue
+		}
+		if breakGlassActive && !outputConfig.Critical {
+			log.Printf("Break-glass active until %v, skipping non-critical output rule: %s", breakGlass.ExpiresAt, outputConfig.Name)
+			continue
+		}
+		if !appliesToProduct(r, outputConfig) || !appliesToRoute(r, outputConfig) || !appliesToSchedule(r, outputConfig) || !sampled(r, outputConfig) || lib.PolicyOverridden(r, outputConfig) {
+			continue
+		}
+		log.Printf("Processing output rule: %s", outputConfig.Type)
+
+		var blocked bool
+		var message string
+		var err error
+
+		switch outputConfig.Type {
+		case outputTypes.LowConfidence:
+			blocked, message, err = handleLowConfidenceRule(r, outputConfig, resp)
+		case outputTypes.URLValidation:
+			blocked, message, err = handleURLValidationRule(r, outputConfig, resp)
+		case outputTypes.Profanity:
+			blocked, message, err = handleProfanityRule(r, outputConfig, resp)
+		case outputTypes.Topic:
+			blocked, message, err = handleTopicOutputRule(r, outputConfig, resp)
+		case outputTypes.Detector:
+			blocked, message, err = handleDetectorOutputRule(r, outputConfig, resp)
+		case outputTypes.Combined:
+			blocked, message, err = handleCombinedOutputRule(r, outputConfig, resp)
+		case outputTypes.PIIScanner:
+			blocked, message, err = handlePIIScannerOutputRule(r, outputConfig, resp)
+		default:
+			log.Printf("ERROR: Invalid output filter type %s", outputConfig.Type)
+		}
+
+		if blocked {
+			return blocked, message, err
+		}
+	}
+
+	return false, "response is not blocked", nil
+}
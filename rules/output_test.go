@@ -0,0 +1,100 @@
+package rules
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func completionWithLogProbs(logProbs ...float64) *openai.ChatCompletionResponse {
+	content := make([]openai.LogProb, len(logProbs))
+	for i, logProb := range logProbs {
+		content[i] = openai.LogProb{Token: "t", LogProb: logProb}
+	}
+	return &openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{
+			Message:  openai.ChatCompletionMessage{Role: "assistant", Content: "answer"},
+			LogProbs: &openai.LogProbs{Content: content},
+		}},
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	confidence, ok := Confidence(completionWithLogProbs(math.Log(0.5), math.Log(0.5)).Choices[0])
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, confidence, 1e-9)
+
+	_, ok = Confidence(openai.ChatCompletionChoice{})
+	assert.False(t, ok)
+}
+
+func TestOutputLowConfidence(t *testing.T) {
+	lib.AppConfig.Rules.Output = []lib.Rule{{
+		Enabled: true,
+		Name:    "low_confidence",
+		Type:    outputTypes.LowConfidence,
+		Config:  lib.Config{MinConfidence: 0.4},
+		Action:  lib.Action{Type: "block"},
+	}}
+	defer func() { lib.AppConfig.Rules.Output = nil }()
+
+	req := httptest.NewRequest("POST", "/test", nil)
+	assert.True(t, OutputNeedsLogProbs(req))
+	assert.False(t, StreamOutputActive(req), "streams carry no logprobs to judge")
+
+	blocked, message, err := Output(req, completionWithLogProbs(math.Log(0.1), math.Log(0.2)))
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, "response blocked due to low confidence", message)
+
+	blocked, _, _ = Output(req, completionWithLogProbs(math.Log(0.9), math.Log(0.8)))
+	assert.False(t, blocked)
+
+	// Choices without logprobs can't be judged and pass
+	blocked, _, _ = Output(req, &openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{}}})
+	assert.False(t, blocked)
+}
+
+func TestProfanityFilter(t *testing.T) {
+	filter := newProfanityFilter([]string{"en-US"}, []string{"Heck"})
+
+	masked, count := filter.mask("Well shit, that's a heck of a Scheiße day.")
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "Well ****, that's a **** of a Scheiße day.", masked)
+
+	masked, count = newProfanityFilter([]string{"de"}, nil).mask("So eine Scheiße, Mistkerl!")
+	assert.Equal(t, 1, count)
+	assert.Equal(t, "So eine *******, Mistkerl!", masked)
+}
+
+func TestOutputProfanity(t *testing.T) {
+	lib.AppConfig.Rules.Output = []lib.Rule{{
+		Enabled: true,
+		Name:    "profanity",
+		Type:    outputTypes.Profanity,
+		Config:  lib.Config{Locales: []string{"en"}},
+		Action:  lib.Action{Type: "mask"},
+	}}
+	defer func() { lib.AppConfig.Rules.Output = nil }()
+
+	req := httptest.NewRequest("POST", "/test", nil)
+	assert.True(t, StreamOutputActive(req))
+	resp := &openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+		Message: openai.ChatCompletionMessage{Content: "This is bullshit."},
+	}}}
+	blocked, _, err := Output(req, resp)
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	assert.Equal(t, "This is ********.", resp.Choices[0].Message.Content)
+
+	lib.AppConfig.Rules.Output[0].Action.Type = "block"
+	blocked, message, _ := Output(req, &openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+		Message: openai.ChatCompletionMessage{Content: "This is bullshit."},
+	}}})
+	assert.True(t, blocked)
+	assert.Equal(t, "response blocked due to profanity", message)
+}
@@ -0,0 +1,206 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/models"
+	"github.com/sashabaranov/go-openai"
+)
+
+// piiEntity is a built-in entity of the PII scanner, replaced by its label when redacted
+type piiEntity struct {
+	name    string
+	label   string
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+// piiEntities are the built-in entities, in the order overlapping matches are
+// attributed to them
+var piiEntities = []piiEntity{
+	{name: "email", label: "EMAIL_ADDRESS", pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{name: "credit_card", label: "CREDIT_CARD", pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+	{name: "ssn", label: "US_SSN", pattern: regexp.MustCompile(`\b(?:00[1-9]|0[1-9]\d|[1-5]\d{2}|6[0-57-9]\d|66[0-57-9]|7\d{2}|8[0-8]\d)-(?:0[1-9]|[1-9]\d)-(?:000[1-9]|00[1-9]\d|0[1-9]\d{2}|[1-9]\d{3})\b`)},
+	{name: "phone", label: "PHONE_NUMBER", pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)|\b\d{3})[ .-]?\d{3}[ .-]?\d{4}\b`)},
+}
+
+// customPIILabel replaces the matches of the custom patterns of a rule
+const customPIILabel = "CUSTOM"
+
+// luhnValid reports whether the digits of a card number pass the Luhn check
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if double {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// piiMatch is a detected entity in a text
+type piiMatch struct {
+	start, end int
+	label      string
+}
+
+// piiScanner finds the entities and custom patterns of a rule in a text
+type piiScanner struct {
+	entities []piiEntity
+}
+
+// newPIIScanner builds the scanner of a rule from its entities, all built-in
+// entities if it lists none, and its custom patterns
+func newPIIScanner(config lib.Config) (*piiScanner, error) {
+	scanner := &piiScanner{}
+	for _, entity := range piiEntities {
+		if len(config.Entities) == 0 || slices.Contains(config.Entities, entity.name) {
+			scanner.entities = append(scanner.entities, entity)
+		}
+	}
+	for _, pattern := range config.Patterns {
+		compiled, err := rulePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		scanner.entities = append(scanner.entities, piiEntity{name: customPIILabel, label: customPIILabel, pattern: compiled})
+	}
+	return scanner, nil
+}
+
+// scan returns the matches in text ordered by position. A match overlapping an
+// earlier one is dropped, so each character is attributed to one entity.
+func (s *piiScanner) scan(text string) []piiMatch {
+	var matches []piiMatch
+	for _, entity := range s.entities {
+		for _, loc := range entity.pattern.FindAllStringIndex(text, -1) {
+			if entity.valid != nil && !entity.valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			overlaps := false
+			for _, match := range matches {
+				if loc[0] < match.end && match.start < loc[1] {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				matches = append(matches, piiMatch{start: loc[0], end: loc[1], label: entity.label})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	return matches
+}
+
+// redact replaces the matches in text with their labels in angle brackets
+func redact(text string, matches []piiMatch) string {
+	var redacted strings.Builder
+	last := 0
+	for _, match := range matches {
+		redacted.WriteString(text[last:match.start])
+		redacted.WriteString("<" + match.label + ">")
+		last = match.end
+	}
+	redacted.WriteString(text[last:])
+	return redacted.String()
+}
+
+// scanPII scans texts with the scanner of a rule and redacts them in place if
+// the action of the rule is redact. It saves the counts of the detected
+// entities as a rule execution and returns the number of matches.
+func scanPII(r *http.Request, config lib.Rule, direction string, texts []*string) (int, error) {
+	scanner, err := newPIIScanner(config.Config)
+	if err != nil {
+		return 0, fmt.Errorf("PII scanner %s: %v", config.Name, err)
+	}
+
+	findings := map[string]int{}
+	total := 0
+	for _, text := range texts {
+		matches := scanner.scan(*text)
+		if len(matches) == 0 {
+			continue
+		}
+		for _, match := range matches {
+			findings[match.label]++
+		}
+		total += len(matches)
+		if config.Action.Type == "redact" {
+			*text = redact(*text, matches)
+		}
+	}
+	log.Printf("PII scanner %s %s result: matches=%d", config.Name, direction, total)
+
+	action := string(config.Action.Type)
+	if action != "block" && action != "redact" {
+		action = "log-only"
+	}
+	encoded, _ := json.Marshal(findings)
+	lib.RecordRuleExecution(r, models.RuleExecution{
+		RuleName:  config.Name,
+		RuleType:  config.Type,
+		Direction: direction,
+		Action:    action,
+		Matches:   total,
+		Findings:  string(encoded),
+	})
+	if total > 0 {
+		recordRisk(r, config, 0)
+	}
+	return total, nil
+}
+
+// handlePIIScannerInputRule scans the user messages of a request for PII and
+// blocks the request, redacts the messages or only logs the findings
+func handlePIIScannerInputRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	var texts []*string
+	for i := range userPrompt.Messages {
+		if userPrompt.Messages[i].Role == openai.ChatMessageRoleUser {
+			texts = append(texts, &userPrompt.Messages[i].Content)
+		}
+	}
+	total, err := scanPII(r, inputConfig, "input", texts)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	if total > 0 && inputConfig.Action.Type == "block" {
+		log.Println("Blocking request due to PII detection.")
+		return true, "request blocked due to PII detection", nil
+	}
+	return false, "", nil
+}
+
+// handlePIIScannerOutputRule scans the choices of a completion for PII and
+// blocks the response, redacts the choices or only logs the findings
+func handlePIIScannerOutputRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	var texts []*string
+	for i := range resp.Choices {
+		texts = append(texts, &resp.Choices[i].Message.Content)
+	}
+	total, err := scanPII(r, outputConfig, "output", texts)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	if total > 0 && outputConfig.Action.Type == "block" {
+		log.Println("Blocking response due to PII detection.")
+		return true, "response blocked due to PII detection", nil
+	}
+	return false, "", nil
+}
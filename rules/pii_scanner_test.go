@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPIIScannerScan(t *testing.T) {
+	scanner, err := newPIIScanner(lib.Config{Patterns: []string{`EMP-\d{6}`}})
+	assert.NoError(t, err)
+
+	text := "Mail jane.doe@example.com or call (555) 123-4567, SSN 123-45-6789, card 4111 1111 1111 1111, badge EMP-004211."
+	assert.Equal(t, "Mail <EMAIL_ADDRESS> or call <PHONE_NUMBER>, SSN <US_SSN>, card <CREDIT_CARD>, badge <CUSTOM>.", redact(text, scanner.scan(text)))
+
+	// Card numbers failing the Luhn check and invalid SSNs aren't matched
+	assert.Empty(t, scanner.scan("order 4111 1111 1111 1112, ref 000-12-3456"))
+
+	scanner, err = newPIIScanner(lib.Config{Entities: []string{"email"}})
+	assert.NoError(t, err)
+	assert.Len(t, scanner.scan("jane.doe@example.com, 123-45-6789"), 1)
+
+	_, err = newPIIScanner(lib.Config{Patterns: []string{`(`}})
+	assert.Error(t, err)
+}
+
+func TestPIIScannerRules(t *testing.T) {
+	defer func(output []lib.Rule) { lib.AppConfig.Rules.Output = output }(lib.AppConfig.Rules.Output)
+	// Executions would be written to the database
+	defer func(executions *lib.RuleExecutions) { lib.AppConfig.Settings.RuleExecutions = executions }(lib.AppConfig.Settings.RuleExecutions)
+	lib.AppConfig.Settings.RuleExecutions = &lib.RuleExecutions{Enabled: false}
+	rule := lib.Rule{Enabled: true, Name: "pii", Type: inputTypes.PIIScanner, Action: lib.Action{Type: "redact"}}
+	lib.AppConfig.Rules.Input = []lib.Rule{rule}
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+
+	prompt := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{
+		{Role: "system", Content: "Support contact: help@example.com"},
+		{Role: "user", Content: "My email is jane.doe@example.com"},
+	}}
+	blocked, _, err := Input(req, prompt)
+	assert.False(t, blocked)
+	assert.NoError(t, err)
+	assert.Equal(t, "Support contact: help@example.com", prompt.Messages[0].Content)
+	assert.Equal(t, "My email is <EMAIL_ADDRESS>", prompt.Messages[1].Content)
+
+	lib.AppConfig.Rules.Input[0].Action.Type = "block"
+	blocked, message, _ := Input(req, openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "SSN 123-45-6789"}}})
+	assert.True(t, blocked)
+	assert.Equal(t, "request blocked due to PII detection", message)
+
+	rule.Action.Type = "log-only"
+	lib.AppConfig.Rules.Output = []lib.Rule{rule}
+	resp := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Call 555-123-4567"}}}}
+	blocked, _, err = Output(req, &resp)
+	assert.False(t, blocked)
+	assert.NoError(t, err)
+	assert.Equal(t, "Call 555-123-4567", resp.Choices[0].Message.Content)
+
+	lib.AppConfig.Rules.Output[0].Action.Type = "redact"
+	blocked, _, _ = Output(req, &resp)
+	assert.False(t, blocked)
+	assert.Equal(t, "Call <PHONE_NUMBER>", resp.Choices[0].Message.Content)
+}
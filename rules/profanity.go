@@ -0,0 +1,59 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// profanityWords are the built-in word lists by locale. They are deliberately
+// short, extra words are added per rule with config.words.
+var profanityWords = map[string][]string{
+	"en": {"asshole", "bastard", "bitch", "bullshit", "crap", "damn", "dick", "fuck", "fucker", "fucking", "motherfucker", "piss", "shit", "shitty", "slut", "whore"},
+	"de": {"arsch", "arschloch", "fick", "ficken", "fotze", "hure", "mist", "scheiße", "scheisse", "schlampe", "wichser"},
+	"es": {"cabrón", "cabron", "coño", "gilipollas", "hostia", "joder", "mierda", "puta", "puto"},
+	"fr": {"bordel", "connard", "connasse", "enculé", "merde", "putain", "salaud", "salope"},
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{M}]+`)
+
+// profanityFilter finds the words of the configured locales in a text
+type profanityFilter struct {
+	words map[string]bool
+}
+
+// newProfanityFilter builds a filter from the lists of locales, all built-in
+// lists if locales is empty, and the extra words
+func newProfanityFilter(locales []string, extra []string) *profanityFilter {
+	if len(locales) == 0 {
+		for locale := range profanityWords {
+			locales = append(locales, locale)
+		}
+	}
+
+	filter := &profanityFilter{words: map[string]bool{}}
+	for _, locale := range locales {
+		// A regional locale such as en-US uses the list of its language
+		language := strings.ToLower(strings.SplitN(strings.ReplaceAll(locale, "_", "-"), "-", 2)[0])
+		for _, word := range profanityWords[language] {
+			filter.words[word] = true
+		}
+	}
+	for _, word := range extra {
+		filter.words[strings.ToLower(word)] = true
+	}
+	return filter
+}
+
+// mask replaces every matched word with asterisks and returns the number of matches
+func (f *profanityFilter) mask(text string) (string, int) {
+	count := 0
+	masked := wordPattern.ReplaceAllStringFunc(text, func(word string) string {
+		if !f.words[strings.ToLower(word)] {
+			return word
+		}
+		count++
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+	return masked, count
+}
@@ -0,0 +1,194 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Violation is the error of a request an input rule blocked, which is answered
+// with a 403 rather than the plain 400 of other blocks
+type Violation struct {
+	Rule    string
+	Type    string
+	Message string
+	Score   float64
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// ViolationResponse is the 403 body of a Violation, in the ErrorResponse format
+// of the gateway. Param is the name of the rule and Code its type.
+type ViolationResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Param   string `json:"param"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+// Response returns the 403 body of the violation
+func (v *Violation) Response() ViolationResponse {
+	var response ViolationResponse
+	response.Error.Message = v.Message
+	response.Error.Type = "rule_violation"
+	response.Error.Param = v.Rule
+	response.Error.Code = v.Type
+	return response
+}
+
+// injectionClassifierTimeout bounds the calls to an external injection classifier
+const injectionClassifierTimeout = 5 * time.Second
+
+var rulePatterns sync.Map
+
+// rulePattern returns the compiled pattern of a rule, cached by its source
+func rulePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := rulePatterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rulePatterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// injectionScore scores text for prompt injection, from 0 to 1. Patterns of
+// the rule are checked first and match with score 1, then the rule server
+// plugin or the classifier service at the url of the rule, whichever is set.
+// Classifier scores match at the threshold of the rule, in percent and 50 if unset.
+func injectionScore(r *http.Request, rule lib.Rule, userPrompt openai.ChatCompletionRequest, text string) (float64, bool, error) {
+	for _, pattern := range rule.Config.Patterns {
+		compiled, err := rulePattern(pattern)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid pattern of rule %s: %v", rule.Name, err)
+		}
+		if compiled.MatchString(text) {
+			log.Printf("Prompt injection %s: pattern %q matched", rule.Name, pattern)
+			return 1, true, nil
+		}
+	}
+
+	switch {
+	case rule.Config.PluginName != "":
+		result, err := sendRequest(Rule{Prompt: userPrompt, Config: rule.Config})
+		if err != nil {
+			return 0, false, err
+		}
+		return result.Inspection.Score, result.Match, nil
+	case rule.Config.Url != "":
+		score, err := classifyInjection(r.Context(), rule.Config, text)
+		if err != nil {
+			return 0, false, err
+		}
+		threshold := rule.Config.Threshold
+		if threshold <= 0 {
+			threshold = 50
+		}
+		return score, score*100 >= float64(threshold), nil
+	}
+	return 0, false, nil
+}
+
+// classifyInjection posts text to an external classifier service, which answers
+// with the injection score of the text from 0 to 1. The call is made with the
+// provider HTTP client and cancelled with ctx, and verdicts are cached by url and text.
+func classifyInjection(ctx context.Context, config lib.Config, text string) (float64, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return 0, err
+	}
+	cacheKey := append([]byte(config.Url+"\n"), body...)
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	if cached, ok := lib.GetClassifierVerdict(ctx, cacheKey); ok && json.Unmarshal(cached, &result) == nil {
+		return result.Score, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, injectionClassifierTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create classifier request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.ApiKey)
+	}
+
+	client, err := lib.ProviderHTTPClient(nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call classifier: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("classifier returned status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	verdict, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read classifier response: %v", err)
+	}
+	if err := json.Unmarshal(verdict, &result); err != nil {
+		return 0, fmt.Errorf("failed to decode classifier response: %v", err)
+	}
+	lib.SetClassifierVerdict(ctx, cacheKey, verdict)
+	return result.Score, nil
+}
+
+// handlePromptInjectionRule checks the user message of a request for prompt
+// injection and blocks it with a Violation or flags it for review
+func handlePromptInjectionRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	if !inputConfig.Enabled {
+		return false, "", nil
+	}
+	extractedPrompt, _, err := extractUserPrompt(userPrompt)
+	if err != nil {
+		log.Println(err)
+		return true, err.Error(), err
+	}
+
+	score, matched, err := injectionScore(r, inputConfig, userPrompt, extractedPrompt)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	log.Printf("%s detection result: Match=%v, Score=%f", inputTypes.PromptInjection, matched, score)
+	if !matched {
+		log.Println("Prompt Injection Rule Not Matched")
+		return false, "", nil
+	}
+
+	recordRisk(r, inputConfig, score)
+	switch inputConfig.Action.Type {
+	case "block":
+		log.Println("Blocking request due to prompt injection detection.")
+		message := "request blocked due to rule match"
+		return true, message, &Violation{Rule: inputConfig.Name, Type: inputTypes.PromptInjection, Message: message, Score: score}
+	case "flag":
+		log.Println("Flagging request for review due to prompt injection detection.")
+		flagForReview(r, inputConfig, fmt.Sprintf("prompt injection score %.2f", score))
+	default:
+		log.Println("Monitoring request due to prompt injection detection.")
+	}
+	return false, "", nil
+}
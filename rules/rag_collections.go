@@ -0,0 +1,38 @@
+package rules
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// handleRAGCollectionsRule checks that every retrieved document of the request
+// comes from an allowed collection. Documents without a collection are rejected.
+func handleRAGCollectionsRule(r *http.Request, inputConfig lib.Rule) (bool, string, error) {
+	allowed := map[string]bool{}
+	for _, collection := range inputConfig.Config.Collections {
+		allowed[collection] = true
+	}
+
+	var rejected []string
+	for _, document := range lib.RAGDocuments(r) {
+		if !allowed[document.Collection] {
+			rejected = append(rejected, document.Collection)
+		}
+	}
+	if len(rejected) == 0 {
+		log.Println("RAG Collections Rule Not Matched")
+		return false, "", nil
+	}
+
+	log.Printf("Documents from collections not allowed: %s", strings.Join(rejected, ", "))
+	recordRisk(r, inputConfig, 0)
+	if inputConfig.Action.Type == "block" {
+		log.Println("Blocking request due to document collections.")
+		return true, "request blocked due to documents from collections that are not allowed", nil
+	}
+	log.Println("Monitoring request due to document collections.")
+	return false, "", nil
+}
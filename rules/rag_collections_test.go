@@ -0,0 +1,24 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRAGCollectionsRule(t *testing.T) {
+	rule := lib.Rule{Enabled: true, Name: "collections", Type: inputTypes.RAGCollections, Config: lib.Config{Collections: []string{"support-kb"}}, Action: lib.Action{Type: "block"}}
+
+	req := httptest.NewRequest("POST", "/test", nil)
+	allowed := lib.WithRAGDocuments(req, []lib.RAGDocument{{ID: "kb-1", Collection: "support-kb"}})
+	blocked, _, err := handleRAGCollectionsRule(allowed, rule)
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+
+	rejected := lib.WithRAGDocuments(req, []lib.RAGDocument{{ID: "kb-1", Collection: "support-kb"}, {ID: "hr-3", Collection: "hr"}})
+	blocked, message, _ := handleRAGCollectionsRule(rejected, rule)
+	assert.True(t, blocked)
+	assert.Contains(t, message, "collections that are not allowed")
+}
@@ -0,0 +1,23 @@
+package rules
+
+import (
+	"net/http"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// recordRisk adds a rule hit to the risk score of the session. Classifier
+// scores between 0 and 1 scale the points, other hits count fully.
+func recordRisk(r *http.Request, rule lib.Rule, score float64) {
+	weight := rule.RiskWeight
+	if weight <= 0 {
+		weight = 1
+	}
+	if score <= 0 || score > 1 {
+		score = 1
+	}
+	lib.AddSessionRisk(r, rule.Name, weight*score)
+	if hits, ok := r.Context().Value("ruleHits").(*int); ok {
+		*hits++
+	}
+}
@@ -0,0 +1,22 @@
+import torch
+from transformers import pipeline
+
+# Initialize the classifier once when the module is imported
+classifier = pipeline(
+    "zero-shot-classification",
+    model="facebook/bart-large-mnli",
+    device=torch.device("cuda" if torch.cuda.is_available() else "cpu"),
+)
+
+LABELS = ["self-harm or suicide", "other"]
+
+
+def handler(text: str, threshold: float, config: dict) -> dict:
+    results = classifier(text, candidate_labels=LABELS, hypothesis_template="This text is about {}.")
+    scores = dict(zip(results["labels"], results["scores"]))
+    self_harm_score = round(scores[LABELS[0]], 2)
+
+    return {
+        "check_result": self_harm_score > threshold,
+        "score": self_harm_score
+    }
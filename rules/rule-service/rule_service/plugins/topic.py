@@ -0,0 +1,23 @@
+import torch
+from transformers import pipeline
+
+# Initialize the classifier once when the module is imported
+classifier = pipeline(
+    "zero-shot-classification",
+    model="facebook/bart-large-mnli",
+    device=torch.device("cuda" if torch.cuda.is_available() else "cpu"),
+)
+
+
+def handler(text: str, threshold: float, config: dict) -> dict:
+    topic = config.get("Topic")
+    if not topic:
+        raise ValueError("topic rules need config.topic")
+
+    results = classifier(text, candidate_labels=[topic], hypothesis_template="This text is about {}.", multi_label=True)
+    topic_score = round(results["scores"][0], 2)
+
+    return {
+        "check_result": topic_score > threshold,
+        "score": topic_score
+    }
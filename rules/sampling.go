@@ -0,0 +1,55 @@
+package rules
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// withRuleHits counts the rule hits of one stage of the request, so the sampled
+// rules after a hit check it whatever the sample. A count the caller started is
+// kept.
+func withRuleHits(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value("ruleHits").(*int); ok {
+		return r
+	}
+	hits := 0
+	return r.WithContext(context.WithValue(r.Context(), "ruleHits", &hits))
+}
+
+// sampleRate returns the share of the requests to a path a rule checks. With
+// only routes set, other paths are all checked.
+func sampleRate(sampling *lib.RuleSampling, path string) float64 {
+	rate, longest := sampling.Rate, -1
+	if rate == 0 && len(sampling.Routes) > 0 {
+		rate = 1
+	}
+	for prefix, routeRate := range sampling.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			rate, longest = routeRate, len(prefix)
+		}
+	}
+	return rate
+}
+
+// sampled reports whether a rule checks the request. Rules without sampling
+// check every request, sampled rules also every request an earlier rule hit.
+// Disabled rules are left to skip themselves.
+func sampled(r *http.Request, rule lib.Rule) bool {
+	if rule.Sampling == nil || !rule.Enabled {
+		return true
+	}
+	if hits, ok := r.Context().Value("ruleHits").(*int); ok && *hits > 0 {
+		log.Printf("Rule %s forced after %d rule hits", rule.Name, *hits)
+		return true
+	}
+	if rand.Float64() < sampleRate(rule.Sampling, lib.CanonicalPath(r.URL.Path)) {
+		return true
+	}
+	lib.IncrCounter("rules.sampled_out", 1, "rule:"+rule.Name)
+	return false
+}
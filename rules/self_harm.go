@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"bytes"
+	"log"
+	"text/template"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// defaultCrisisTemplate is returned by a self harm rule without a response_template
+const defaultCrisisTemplate = `It sounds like you may be going through a really difficult time. You don't have to face this alone, and talking to someone can help.
+{{range .Helplines}}
+- {{.}}{{end}}
+
+If you are in immediate danger, please contact your local emergency number.`
+
+// Interception is returned as the error of a rule that answers the request itself
+// instead of blocking it. Content is the response returned to the client.
+type Interception struct {
+	Rule    string
+	Content string
+}
+
+func (i *Interception) Error() string {
+	return "request intercepted by rule " + i.Rule
+}
+
+// crisisResponse renders the response template of a self harm rule
+func crisisResponse(inputConfig lib.Rule) (string, error) {
+	text := inputConfig.Config.ResponseTemplate
+	if text == "" {
+		text = defaultCrisisTemplate
+	}
+	tmpl, err := template.New(inputConfig.Name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var content bytes.Buffer
+	if err := tmpl.Execute(&content, struct{ Helplines []string }{inputConfig.Config.Helplines}); err != nil {
+		return "", err
+	}
+	return content.String(), nil
+}
+
+// handleSelfHarmAction answers a matched request with the crisis response by
+// default. The block and monitor actions behave like the other rules.
+func handleSelfHarmAction(inputConfig lib.Rule, rule RuleResult) (bool, string, error) {
+	if !rule.Match {
+		log.Println("Self Harm Rule Not Matched")
+		return false, "", nil
+	}
+
+	switch inputConfig.Action.Type {
+	case "block":
+		log.Println("Blocking request due to self harm detection.")
+		return true, "request blocked due to rule match", nil
+	case "monitor":
+		log.Println("Monitoring request due to self harm detection.")
+		return false, "", nil
+	}
+
+	content, err := crisisResponse(inputConfig)
+	if err != nil {
+		log.Printf("Error rendering crisis response template: %v", err)
+		content, _ = crisisResponse(lib.Rule{Name: inputConfig.Name, Config: lib.Config{Helplines: inputConfig.Config.Helplines}})
+	}
+	log.Println("Intercepting request due to self harm detection.")
+	return true, content, &Interception{Rule: inputConfig.Name, Content: content}
+}
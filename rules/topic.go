@@ -0,0 +1,118 @@
+package rules
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// appliesToProduct reports whether a rule applies to the product of the request.
+// Rules without products apply to every product.
+func appliesToProduct(r *http.Request, rule lib.Rule) bool {
+	if len(rule.Products) == 0 {
+		return true
+	}
+	productID, ok := r.Context().Value("productId").(uuid.UUID)
+	return ok && lib.ProductListed(rule.Products, productID)
+}
+
+// appliesToRoute reports whether a rule applies to the path of the request.
+// Rules without routes apply to every route, and requests to the /v1 alias
+// are matched by their /openai/v1 path.
+func appliesToRoute(r *http.Request, rule lib.Rule) bool {
+	if len(rule.Routes) == 0 {
+		return true
+	}
+	requestPath := lib.CanonicalPath(r.URL.Path)
+	for _, route := range rule.Routes {
+		if matched, _ := path.Match(route, requestPath); matched || route == requestPath {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesToSchedule reports whether a rule applies at the time of the request.
+// Rules without schedules always apply.
+func appliesToSchedule(r *http.Request, rule lib.Rule) bool {
+	return lib.ScheduleListed(r, rule.Schedules)
+}
+
+// keywordPattern matches any of the keywords as whole words, ignoring case
+func keywordPattern(keywords []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			quoted = append(quoted, regexp.QuoteMeta(keyword))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(?:` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}])`)
+}
+
+// topicMatch reports whether text is on the topic of a rule: one of its keywords
+// occurs in text, or its classifier plugin matches. The classifier only runs
+// when no keyword matched.
+func topicMatch(rule lib.Rule, text string) (bool, error) {
+	if pattern := keywordPattern(rule.Config.Keywords); pattern != nil && pattern.MatchString(text) {
+		log.Printf("Topic %s: keyword match", rule.Name)
+		return true, nil
+	}
+	if rule.Config.PluginName == "" {
+		return false, nil
+	}
+
+	result, err := sendRequest(Rule{
+		Prompt: openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: text}}},
+		Config: rule.Config,
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Topic %s classifier result: Match=%v, Score=%f", rule.Name, result.Match, result.Inspection.Score)
+	return result.Match, nil
+}
+
+func handleTopicInputRule(r *http.Request, inputConfig lib.Rule, userPrompt openai.ChatCompletionRequest) (bool, string, error) {
+	extractedPrompt, _, err := extractUserPrompt(userPrompt)
+	if err != nil {
+		log.Println(err)
+		return true, err.Error(), err
+	}
+
+	match, err := topicMatch(inputConfig, extractedPrompt)
+	if err != nil {
+		return true, err.Error(), err
+	}
+	if match {
+		recordRisk(r, inputConfig, 0)
+		if inputConfig.Action.Type == "block" {
+			log.Printf("Blocking request due to topic %s.", inputConfig.Name)
+			return true, fmt.Sprintf("request blocked due to topic %s", inputConfig.Name), nil
+		}
+		log.Printf("Monitoring request due to topic %s.", inputConfig.Name)
+	}
+	return false, "", nil
+}
+
+func handleTopicOutputRule(r *http.Request, outputConfig lib.Rule, resp *openai.ChatCompletionResponse) (bool, string, error) {
+	for _, choice := range resp.Choices {
+		match, err := topicMatch(outputConfig, choice.Message.Content)
+		if err != nil {
+			return true, err.Error(), err
+		}
+		if match && outputAction(r, outputConfig, "topic "+outputConfig.Name, fmt.Sprintf("completion is on topic %s", outputConfig.Name)) {
+			return true, fmt.Sprintf("response blocked due to topic %s", outputConfig.Name), nil
+		}
+	}
+	return false, "", nil
+}
@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicKeywords(t *testing.T) {
+	rule := lib.Rule{Name: "competitor", Config: lib.Config{Keywords: []string{"Acme Corp", "AcmeGPT"}}}
+
+	for text, expected := range map[string]bool{
+		"How does this compare to acme corp?": true,
+		"Is AcmeGPT better?":                  true,
+		"Acmegptx is unrelated":               false,
+		"Nothing to see here":                 false,
+	} {
+		match, err := topicMatch(rule, text)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, match, text)
+	}
+}
+
+func TestTopicRuleProducts(t *testing.T) {
+	product := uuid.New()
+	lib.AppConfig.Rules.Input = []lib.Rule{{
+		Enabled:  true,
+		Name:     "competitor",
+		Type:     inputTypes.Topic,
+		Config:   lib.Config{Keywords: []string{"Acme"}},
+		Action:   lib.Action{Type: "block"},
+		Products: []string{product.String()},
+	}}
+	defer func() { lib.AppConfig.Rules.Input = nil }()
+	prompt := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Tell me about Acme."}}}
+
+	req := httptest.NewRequest("POST", "/test", nil)
+	blocked, message, err := Input(req.WithContext(context.WithValue(req.Context(), "productId", product)), prompt)
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, "request blocked due to topic competitor", message)
+
+	blocked, _, _ = Input(req.WithContext(context.WithValue(req.Context(), "productId", uuid.New())), prompt)
+	assert.False(t, blocked)
+}
+
+func TestTopicRuleRoutesAlias(t *testing.T) {
+	originalCompatibility := lib.AppConfig.Settings.SDKCompatibility
+	lib.AppConfig.Settings.SDKCompatibility = &lib.SDKCompatibility{Enabled: true}
+	lib.AppConfig.Rules.Input = []lib.Rule{{
+		Enabled: true,
+		Name:    "competitor",
+		Type:    inputTypes.Topic,
+		Config:  lib.Config{Keywords: []string{"Acme"}},
+		Action:  lib.Action{Type: "block"},
+		Routes:  []string{"/openai/v1/chat/*"},
+	}}
+	defer func() {
+		lib.AppConfig.Rules.Input = nil
+		lib.AppConfig.Settings.SDKCompatibility = originalCompatibility
+	}()
+	prompt := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Tell me about Acme."}}}
+
+	for _, route := range []string{"/openai/v1/chat/completions", "/v1/chat/completions"} {
+		blocked, _, err := Input(httptest.NewRequest("POST", route, nil), prompt)
+		assert.NoError(t, err)
+		assert.True(t, blocked, route)
+	}
+	blocked, _, _ := Input(httptest.NewRequest("POST", "/anthropic/v1/messages", nil), prompt)
+	assert.False(t, blocked)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+	"github.com/sashabaranov/go-openai"
+)
+
+// BenchOptions configure a benchmark run. UpstreamLatency is added by the mock
+// provider to every completion.
+type BenchOptions struct {
+	Requests        int
+	Concurrency     int
+	APIKey          string
+	Model           string
+	UpstreamLatency time.Duration
+}
+
+// StageReport is the latency of one middleware stage, not counting the stages it called
+type StageReport struct {
+	Stage string        `json:"stage"`
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P99   time.Duration `json:"p99"`
+}
+
+// BenchReport is the result of a benchmark run. Allocations are those of the
+// whole process, the load generator and the mock provider included.
+type BenchReport struct {
+	Requests         int           `json:"requests"`
+	Failures         int           `json:"failures"`
+	Duration         time.Duration `json:"duration"`
+	Throughput       float64       `json:"throughput"`
+	P50              time.Duration `json:"p50"`
+	P99              time.Duration `json:"p99"`
+	AllocsPerRequest uint64        `json:"allocs_per_request"`
+	BytesPerRequest  uint64        `json:"bytes_per_request"`
+	Stages           []StageReport `json:"stages"`
+}
+
+// stageTimings collects the stage durations of a benchmark run
+type stageTimings struct {
+	mu        sync.Mutex
+	order     []string
+	durations map[string][]time.Duration
+}
+
+func (s *stageTimings) record(stage string, self time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.durations[stage]; !ok {
+		s.order = append(s.order, stage)
+	}
+	s.durations[stage] = append(s.durations[stage], self)
+}
+
+// reports returns the stages in pipeline order. Stages are recorded when they
+// finish, so the innermost stage of the first request comes first.
+func (s *stageTimings) reports() []StageReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reports := make([]StageReport, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		durations := s.durations[s.order[i]]
+		var total time.Duration
+		for _, duration := range durations {
+			total += duration
+		}
+		reports = append(reports, StageReport{
+			Stage: s.order[i],
+			Count: len(durations),
+			Mean:  total / time.Duration(len(durations)),
+			P50:   percentile(durations, 0.5),
+			P99:   percentile(durations, 0.99),
+		})
+	}
+	return reports
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// mockProvider answers chat completions and the rule server with canned
+// responses, so the benchmark measures the gateway rather than its upstreams
+func mockProvider(latency time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(latency)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			ID:      "chatcmpl-bench",
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "This is a benchmark response."},
+				FinishReason: openai.FinishReasonStop,
+			}},
+			Usage: openai.Usage{PromptTokens: 12, CompletionTokens: 6, TotalTokens: 18},
+		})
+	})
+	mux.HandleFunc("/rule/execute", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"match":false,"inspection":{"check_result":false,"score":0}}`))
+	})
+	return mux
+}
+
+// useMockProvider points the OpenAI provider and the rule server at the mock
+// provider and returns a function restoring the configuration
+func useMockProvider(url string) func() {
+	providers, ruleServer, egress := lib.AppConfig.Providers.OpenAI, lib.AppConfig.Settings.RuleServer, lib.AppConfig.Settings.Egress
+
+	provider := lib.ProviderConfig{Enabled: true}
+	if providers != nil {
+		provider = *providers
+	}
+	provider.BaseURL = url + "/v1"
+	lib.AppConfig.Providers.OpenAI = &provider
+	lib.AppConfig.Settings.RuleServer = &lib.RuleServer{Url: url}
+	if egress != nil && len(egress.AllowedHosts) > 0 {
+		allowed := append(append([]string{}, egress.AllowedHosts...), "127.0.0.1")
+		lib.AppConfig.Settings.Egress = &lib.Egress{AllowedHosts: allowed}
+	}
+
+	return func() {
+		lib.AppConfig.Providers.OpenAI = providers
+		lib.AppConfig.Settings.RuleServer = ruleServer
+		lib.AppConfig.Settings.Egress = egress
+	}
+}
+
+// RunBench drives synthetic chat completions through the full router against a
+// mock provider and reports the throughput, latency and allocations. Every
+// request has its own prompt, so the cache and request coalescing don't
+// shortcut the pipeline.
+func RunBench(options BenchOptions) (BenchReport, error) {
+	if options.Requests <= 0 || options.Concurrency <= 0 {
+		return BenchReport{}, fmt.Errorf("requests and concurrency must be positive")
+	}
+
+	mock := httptest.NewServer(mockProvider(options.UpstreamLatency))
+	defer mock.Close()
+	defer useMockProvider(mock.URL)()
+
+	stages := &stageTimings{durations: map[string][]time.Duration{}}
+	lib.SetStageRecorder(stages.record)
+	defer lib.SetStageRecorder(nil)
+	gateway := httptest.NewServer(NewRouter())
+	defer gateway.Close()
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: options.Concurrency}}
+	send := func(i int) (time.Duration, error) {
+		body, err := json.Marshal(openai.ChatCompletionRequest{
+			Model:    options.Model,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("Benchmark request %d: say hello.", i)}},
+		})
+		if err != nil {
+			return 0, err
+		}
+		req, err := http.NewRequest("POST", gateway.URL+"/openai/v1/chat/completions", bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+options.APIKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return time.Since(start), nil
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var failures int
+	var lastErr error
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < options.Concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				latency, err := send(i)
+				mu.Lock()
+				if err != nil {
+					failures++
+					lastErr = err
+				} else {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < options.Requests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if failures == options.Requests {
+		return BenchReport{}, fmt.Errorf("every request failed, last error: %v", lastErr)
+	}
+	return BenchReport{
+		Requests:         options.Requests,
+		Failures:         failures,
+		Duration:         elapsed,
+		Throughput:       float64(options.Requests-failures) / elapsed.Seconds(),
+		P50:              percentile(latencies, 0.5),
+		P99:              percentile(latencies, 0.99),
+		AllocsPerRequest: (after.Mallocs - before.Mallocs) / uint64(options.Requests),
+		BytesPerRequest:  (after.TotalAlloc - before.TotalAlloc) / uint64(options.Requests),
+		Stages:           stages.reports(),
+	}, nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ContractOptions configure a contract test run against a live instance. BaseURL
+// is the OpenAI base URL of the instance, such as http://localhost:8080/openai/v1.
+type ContractOptions struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Timeout time.Duration
+}
+
+// ContractResult is the outcome of one contract check. Deviation says how the
+// gateway differs from the OpenAI API.
+type ContractResult struct {
+	Name      string        `json:"name"`
+	Passed    bool          `json:"passed"`
+	Deviation string        `json:"deviation,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// ContractReport is the result of a contract test run
+type ContractReport struct {
+	Passed  int              `json:"passed"`
+	Failed  int              `json:"failed"`
+	Results []ContractResult `json:"results"`
+}
+
+type contractCheck struct {
+	name string
+	run  func(ctx context.Context, client *openai.Client, options ContractOptions) error
+}
+
+// contractChecks exercise the behaviors OpenAI SDKs rely on. They are written
+// against the official Go SDK, the Python SDK parses the same fields.
+var contractChecks = []contractCheck{
+	{"list_models", checkListModels},
+	{"retrieve_model", checkRetrieveModel},
+	{"chat_completion", checkChatCompletion},
+	{"chat_completion_stream", checkChatCompletionStream},
+	{"tool_calls", checkToolCalls},
+	{"error_unknown_model", checkUnknownModelError},
+	{"error_invalid_api_key", checkInvalidAPIKeyError},
+}
+
+// RunContractTests runs the OpenAI compatibility checks against a live instance
+func RunContractTests(options ContractOptions) ContractReport {
+	if options.Timeout <= 0 {
+		options.Timeout = 60 * time.Second
+	}
+	config := openai.DefaultConfig(options.APIKey)
+	config.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	client := openai.NewClientWithConfig(config)
+
+	report := ContractReport{}
+	for _, check := range contractChecks {
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+		start := time.Now()
+		err := check.run(ctx, client, options)
+		cancel()
+
+		result := ContractResult{Name: check.name, Passed: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			result.Deviation = err.Error()
+			report.Failed++
+		} else {
+			report.Passed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+func checkListModels(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	if len(models.Models) == 0 {
+		return fmt.Errorf("no models listed")
+	}
+	for _, model := range models.Models {
+		if model.ID == "" || model.Object != "model" {
+			return fmt.Errorf("model %q has object %q, want \"model\"", model.ID, model.Object)
+		}
+	}
+	return nil
+}
+
+func checkRetrieveModel(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	model, err := client.GetModel(ctx, options.Model)
+	if err != nil {
+		return err
+	}
+	if model.ID != options.Model {
+		return fmt.Errorf("retrieved model %q, want %q", model.ID, options.Model)
+	}
+	return nil
+}
+
+func contractRequest(options ContractOptions) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:     options.Model,
+		MaxTokens: 16,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Reply with the single word OK."}},
+	}
+}
+
+func checkChatCompletion(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	resp, err := client.CreateChatCompletion(ctx, contractRequest(options))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.ID == "":
+		return fmt.Errorf("response has no id")
+	case resp.Object != "chat.completion":
+		return fmt.Errorf("response object is %q, want \"chat.completion\"", resp.Object)
+	case len(resp.Choices) == 0:
+		return fmt.Errorf("response has no choices")
+	case resp.Choices[0].Message.Role != openai.ChatMessageRoleAssistant:
+		return fmt.Errorf("message role is %q, want \"assistant\"", resp.Choices[0].Message.Role)
+	case resp.Choices[0].FinishReason == "":
+		return fmt.Errorf("choice has no finish_reason")
+	case resp.Usage.TotalTokens == 0:
+		return fmt.Errorf("response has no usage")
+	}
+	return nil
+}
+
+func checkChatCompletionStream(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	stream, err := client.CreateChatCompletionStream(ctx, contractRequest(options))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	chunks, finished := 0, false
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("after %d chunks: %v", chunks, err)
+		}
+		chunks++
+		if chunk.Object != "chat.completion.chunk" {
+			return fmt.Errorf("chunk object is %q, want \"chat.completion.chunk\"", chunk.Object)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				finished = true
+			}
+		}
+	}
+	switch {
+	case chunks == 0:
+		return fmt.Errorf("stream ended without chunks")
+	case content.Len() == 0:
+		return fmt.Errorf("stream had no content")
+	case !finished:
+		return fmt.Errorf("no chunk had a finish_reason")
+	}
+	return nil
+}
+
+func checkToolCalls(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	req := contractRequest(options)
+	req.MaxTokens = 64
+	req.Messages[0].Content = "What is the weather in Paris? Use the tool."
+	req.Tools = []openai.Tool{{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        "get_weather",
+			Description: "Get the weather of a city",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+		},
+	}}
+	req.ToolChoice = openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: "get_weather"}}
+
+	resp, err := client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Choices) == 0 || len(resp.Choices[0].Message.ToolCalls) == 0 {
+		return fmt.Errorf("response has no tool calls")
+	}
+	call := resp.Choices[0].Message.ToolCalls[0]
+	if call.ID == "" || call.Function.Name != "get_weather" {
+		return fmt.Errorf("tool call %q calls %q, want get_weather", call.ID, call.Function.Name)
+	}
+	if !json.Valid([]byte(call.Function.Arguments)) {
+		return fmt.Errorf("tool call arguments are not JSON: %s", call.Function.Arguments)
+	}
+	return nil
+}
+
+// expectAPIError checks that an error is an OpenAI error response with the status
+func expectAPIError(err error, statuses ...int) error {
+	if err == nil {
+		return fmt.Errorf("request succeeded, want an error")
+	}
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("error is not an OpenAI error response: %v", err)
+	}
+	if apiErr.Message == "" {
+		return fmt.Errorf("error response has no message")
+	}
+	for _, status := range statuses {
+		if apiErr.HTTPStatusCode == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("error status is %d, want one of %v", apiErr.HTTPStatusCode, statuses)
+}
+
+func checkUnknownModelError(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	req := contractRequest(options)
+	req.Model = "openshield-contract-unknown-model"
+	_, err := client.CreateChatCompletion(ctx, req)
+	return expectAPIError(err, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound)
+}
+
+func checkInvalidAPIKeyError(ctx context.Context, client *openai.Client, options ContractOptions) error {
+	config := openai.DefaultConfig("invalid-contract-test-key")
+	config.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	_, err := openai.NewClientWithConfig(config).CreateChatCompletion(ctx, contractRequest(options))
+	return expectAPIError(err, http.StatusUnauthorized)
+}
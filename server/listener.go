@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/openshieldai/openshield/lib"
+)
+
+// listenFDEnv passes the file descriptor of the listener to the process taking over during an upgrade
+const listenFDEnv = "OPENSHIELD_LISTEN_FD"
+
+// listen opens the listener of the server, or reuses the one inherited from
+// the previous process during an upgrade
+func listen(addr string, network *lib.Network) (net.Listener, error) {
+	if fd := os.Getenv(listenFDEnv); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", listenFDEnv, err)
+		}
+		file := os.NewFile(uintptr(n), "listener")
+		defer file.Close()
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit listener: %v", err)
+		}
+		fmt.Printf("Inherited listener on %s\n", listener.Addr())
+		return listener, nil
+	}
+
+	var listenConfig net.ListenConfig
+	if network != nil && network.ReusePort {
+		if reusePortControl == nil {
+			return nil, fmt.Errorf("settings.network.reuse_port is not supported on this platform")
+		}
+		listenConfig.Control = reusePortControl
+	}
+	return listenConfig.Listen(context.Background(), "tcp", addr)
+}
+
+// startUpgrade starts the current binary again with the listener passed on as fd 3
+func startUpgrade(listener net.Listener) error {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("listener can't be passed on")
+	}
+	file, err := tcpListener.File()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Started process %d to take over the listener\n", cmd.Process.Pid)
+	return nil
+}
+
+// drain stops accepting connections and waits for in-flight requests, including
+// streamed responses, for up to the drain timeout before closing the rest
+func drain(srv *http.Server, stop context.CancelFunc) error {
+	defer stop()
+
+	timeout := 15 * time.Second
+	if network := config.Settings.Network; network != nil && network.DrainTimeout > 0 {
+		timeout = time.Duration(network.DrainTimeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("Connections still open after %v, closing them\n", timeout)
+		return srv.Close()
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal is not available on this platform
+var upgradeSignal os.Signal
+
+// reusePortControl is not available on this platform
+var reusePortControl func(network string, address string, conn syscall.RawConn) error
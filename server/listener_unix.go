@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package server
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// upgradeSignal makes the server hand its listener to a new process and drain
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// reusePortControl sets SO_REUSEPORT so several processes can listen on the same port
+var reusePortControl = func(network string, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,514 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SDKLanguages are the languages GenerateSDK writes clients in
+var SDKLanguages = []string{"go", "typescript"}
+
+// sdkHeader starts every generated file
+const sdkHeader = "Code generated by openshield sdk generate. DO NOT EDIT."
+
+// GenerateSDK writes a typed client of the spec in one of SDKLanguages and
+// returns its files by name. packageName names the Go package.
+func GenerateSDK(spec *APISpec, language string, packageName string) (map[string][]byte, error) {
+	switch language {
+	case "go":
+		source, err := generateGoSDK(spec, packageName)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{"client.go": source}, nil
+	case "typescript", "ts":
+		return map[string][]byte{"client.ts": generateTypeScriptSDK(spec)}, nil
+	}
+	return nil, fmt.Errorf("unsupported language %q, expected one of %s", language, strings.Join(SDKLanguages, ", "))
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// comment writes text as a comment with the given line prefix
+func comment(out *bytes.Buffer, indent string, prefix string, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Fprintf(out, "%s%s %s\n", indent, prefix, strings.TrimSpace(line))
+	}
+}
+
+// goIdentifier returns name, renamed when it is a Go keyword
+func goIdentifier(name string) string {
+	if token.IsKeyword(name) || name == "" {
+		return name + "Param"
+	}
+	return name
+}
+
+// goType returns the Go type of a schema. Optional fields of object types are pointers.
+func goType(spec *APISpec, schema *APISchema, optional bool) string {
+	if schema == nil {
+		return "json.RawMessage"
+	}
+	if len(schema.AllOf) == 1 {
+		return goType(spec, schema.AllOf[0], optional)
+	}
+	if schema.Ref != "" {
+		name := definitionName(schema.Ref)
+		if resolved := spec.schema(schema); optional && resolved != nil && resolved.Type == "object" {
+			return "*" + name
+		}
+		return name
+	}
+	switch schema.Type {
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(spec, schema.Items, false)
+	case "object":
+		if len(schema.Properties) > 0 {
+			var fields bytes.Buffer
+			goFields(&fields, spec, schema)
+			return "struct {\n" + fields.String() + "}"
+		}
+		if schema.AdditionalProperties != nil {
+			return "map[string]" + goType(spec, schema.AdditionalProperties, false)
+		}
+		return "map[string]interface{}"
+	}
+	return "json.RawMessage"
+}
+
+// goFields writes the fields of an object schema
+func goFields(out *bytes.Buffer, spec *APISpec, schema *APISchema) {
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		if property.Description != "" {
+			comment(out, "\t", "//", property.Description)
+		}
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(out, "\t%s %s `json:%q`\n", pascalCase(name), goType(spec, property, !required[name]), tag)
+	}
+}
+
+// generateGoSDK writes the Go client, formatted with gofmt
+func generateGoSDK(spec *APISpec, packageName string) ([]byte, error) {
+	if packageName == "" {
+		packageName = "openshield"
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// %s\n\n", sdkHeader)
+	fmt.Fprintf(&out, "// Package %s is a client of the OpenShield gateway and admin API.\n", packageName)
+	fmt.Fprintf(&out, "package %s\n\n", packageName)
+	out.WriteString(goClientPrelude)
+
+	for _, name := range sortedKeys(spec.Definitions) {
+		definition := spec.Definitions[name]
+		typeName := definitionName(name)
+		if definition.Description != "" {
+			comment(&out, "", "//", definition.Description)
+		} else {
+			fmt.Fprintf(&out, "// %s is the %s schema of the gateway\n", typeName, name)
+		}
+		switch {
+		case definition.Type == "object" || len(definition.Properties) > 0:
+			fmt.Fprintf(&out, "type %s struct {\n", typeName)
+			goFields(&out, spec, definition)
+			out.WriteString("}\n\n")
+		case definition.Type == "string" && len(definition.Enum) > 0:
+			fmt.Fprintf(&out, "type %s string\n\n", typeName)
+			out.WriteString("const (\n")
+			seen := map[string]bool{}
+			for _, value := range definition.Enum {
+				constant := typeName + pascalCase(fmt.Sprint(value))
+				if seen[constant] {
+					continue
+				}
+				seen[constant] = true
+				fmt.Fprintf(&out, "\t%s %s = %q\n", constant, typeName, fmt.Sprint(value))
+			}
+			out.WriteString(")\n\n")
+		default:
+			fmt.Fprintf(&out, "type %s = %s\n\n", typeName, goType(spec, definition, false))
+		}
+	}
+
+	for _, operation := range spec.operations() {
+		name := operation.name()
+		fmt.Fprintf(&out, "// %s calls %s %s\n", name, strings.ToUpper(operation.method), operation.path)
+		if operation.Summary != "" {
+			out.WriteString("//\n")
+			comment(&out, "", "//", operation.Summary)
+		}
+
+		args := []string{"ctx context.Context"}
+		path := strconv.Quote(operation.path)
+		for _, parameter := range operation.Parameters {
+			if parameter.In != "path" {
+				continue
+			}
+			arg := goIdentifier(camelCase(parameter.Name))
+			args = append(args, arg+" string")
+			path = strings.Replace(path, "{"+parameter.Name+"}", `" + url.PathEscape(`+arg+`) + "`, 1)
+		}
+		path = strings.TrimSuffix(strings.TrimPrefix(path, `"" + `), ` + ""`)
+		body := "nil"
+		if parameter := operation.body(); parameter != nil {
+			bodyType := "interface{}"
+			if parameter.Schema != nil {
+				bodyType = goType(spec, parameter.Schema, true)
+			}
+			args = append(args, "body "+bodyType)
+			body = "body"
+		}
+		query := "nil"
+		if operation.method == "get" || operation.method == "delete" {
+			args = append(args, "query url.Values")
+			query = "query"
+		}
+
+		result := goType(spec, operation.result(), false)
+		fmt.Fprintf(&out, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), goResultType(result))
+		fmt.Fprintf(&out, "\tvar out %s\n", result)
+		fmt.Fprintf(&out, "\tif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\n", strings.ToUpper(operation.method), path, query, body)
+		fmt.Fprintf(&out, "\t\treturn %s, err\n\t}\n", goZero(result))
+		if goResultType(result) != result {
+			out.WriteString("\treturn &out, nil\n}\n\n")
+		} else {
+			out.WriteString("\treturn out, nil\n}\n\n")
+		}
+	}
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated Go client is invalid: %v", err)
+	}
+	return source, nil
+}
+
+// goResultType returns the type an operation returns for its result type,
+// named types are returned as pointers
+func goResultType(result string) string {
+	if strings.HasPrefix(result, "[]") || strings.HasPrefix(result, "map[") || result == "json.RawMessage" || result == "string" ||
+		result == "int64" || result == "float64" || result == "bool" || strings.HasPrefix(result, "struct") {
+		return result
+	}
+	return "*" + result
+}
+
+// goZero returns the value returned with an error for a result type
+func goZero(result string) string {
+	switch goResultType(result) {
+	case "string":
+		return `""`
+	case "int64", "float64":
+		return "0"
+	case "bool":
+		return "false"
+	}
+	if strings.HasPrefix(result, "struct") {
+		return "out"
+	}
+	return "nil"
+}
+
+// goClientPrelude holds the imports and the client of the Go SDK
+const goClientPrelude = `import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls a gateway. APIKey authenticates the provider routes, Session
+// the admin API with the session token of a login.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	Session    string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client of the gateway at baseURL, such as http://localhost:8080
+func NewClient(baseURL string, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: apiKey, HTTPClient: http.DefaultClient}
+}
+
+// APIError is an error response of the gateway
+type APIError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("openshield: status %d: %s", e.StatusCode, strings.TrimSpace(string(e.Body)))
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	if c.Session != "" {
+		req.AddCookie(&http.Cookie{Name: "openshield_session", Value: c.Session})
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &APIError{StatusCode: resp.StatusCode, Body: data}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+`
+
+// tsIdentifier matches the property names TypeScript takes without quotes
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsType returns the TypeScript type of a schema
+func tsType(spec *APISpec, schema *APISchema) string {
+	if schema == nil {
+		return "unknown"
+	}
+	if len(schema.AllOf) == 1 {
+		return tsType(spec, schema.AllOf[0])
+	}
+	if schema.Ref != "" {
+		return definitionName(schema.Ref)
+	}
+	switch schema.Type {
+	case "string":
+		if len(schema.Enum) > 0 {
+			values := make([]string, len(schema.Enum))
+			for i, value := range schema.Enum {
+				values[i] = strconv.Quote(fmt.Sprint(value))
+			}
+			return strings.Join(values, " | ")
+		}
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		items := tsType(spec, schema.Items)
+		if strings.Contains(items, " ") {
+			items = "(" + items + ")"
+		}
+		return items + "[]"
+	case "object":
+		if len(schema.Properties) > 0 {
+			var fields bytes.Buffer
+			tsFields(&fields, spec, schema, "  ")
+			return "{\n" + fields.String() + "}"
+		}
+		if schema.AdditionalProperties != nil {
+			return "Record<string, " + tsType(spec, schema.AdditionalProperties) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+// tsFields writes the properties of an object schema
+func tsFields(out *bytes.Buffer, spec *APISpec, schema *APISchema, indent string) {
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		if property.Description != "" {
+			out.WriteString(indent + "/**\n")
+			comment(out, indent, " *", property.Description)
+			out.WriteString(indent + " */\n")
+		}
+		key := name
+		if !tsIdentifier.MatchString(name) {
+			key = strconv.Quote(name)
+		}
+		optional := "?"
+		if required[name] {
+			optional = ""
+		}
+		fmt.Fprintf(out, "%s%s%s: %s;\n", indent, key, optional, tsType(spec, property))
+	}
+}
+
+// generateTypeScriptSDK writes the TypeScript client, for fetch in browsers and Node.js 18 or later
+func generateTypeScriptSDK(spec *APISpec) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// %s\n\n", sdkHeader)
+	for _, name := range sortedKeys(spec.Definitions) {
+		definition := spec.Definitions[name]
+		typeName := definitionName(name)
+		if definition.Description != "" {
+			out.WriteString("/**\n")
+			comment(&out, "", " *", definition.Description)
+			out.WriteString(" */\n")
+		}
+		if definition.Type == "object" || len(definition.Properties) > 0 {
+			fmt.Fprintf(&out, "export interface %s {\n", typeName)
+			tsFields(&out, spec, definition, "  ")
+			out.WriteString("}\n\n")
+			continue
+		}
+		fmt.Fprintf(&out, "export type %s = %s;\n\n", typeName, tsType(spec, definition))
+	}
+	out.WriteString(tsClientPrelude)
+
+	for _, operation := range spec.operations() {
+		name := camelCase(operation.name())
+		out.WriteString("\n  /**\n")
+		if operation.Summary != "" {
+			comment(&out, "  ", " *", operation.Summary)
+		} else {
+			fmt.Fprintf(&out, "   * Calls %s %s\n", strings.ToUpper(operation.method), operation.path)
+		}
+		out.WriteString("   */\n")
+
+		var args []string
+		path := operation.path
+		for _, parameter := range operation.Parameters {
+			if parameter.In != "path" {
+				continue
+			}
+			arg := camelCase(parameter.Name)
+			args = append(args, arg+": string")
+			path = strings.Replace(path, "{"+parameter.Name+"}", "${encodeURIComponent("+arg+")}", 1)
+		}
+		body := "undefined"
+		if parameter := operation.body(); parameter != nil {
+			args = append(args, "body: "+tsType(spec, parameter.Schema))
+			body = "body"
+		}
+		query := "undefined"
+		if operation.method == "get" || operation.method == "delete" {
+			args = append(args, "query?: Record<string, string>")
+			query = "query"
+		}
+		result := tsType(spec, operation.result())
+		fmt.Fprintf(&out, "  async %s(%s): Promise<%s> {\n", name, strings.Join(args, ", "), result)
+		fmt.Fprintf(&out, "    return this.request<%s>(%q, `%s`, %s, %s);\n  }\n", result, strings.ToUpper(operation.method), path, query, body)
+	}
+	out.WriteString("}\n")
+	return out.Bytes()
+}
+
+// tsClientPrelude opens the client class of the TypeScript SDK
+const tsClientPrelude = `/**
+ * An error response of the gateway
+ */
+export class APIError extends Error {
+  constructor(public status: number, public body: string) {
+    super(` + "`openshield: status ${status}: ${body}`" + `);
+  }
+}
+
+export interface ClientOptions {
+  /** The gateway, such as http://localhost:8080 */
+  baseURL: string;
+  /** Authenticates the provider routes */
+  apiKey?: string;
+  /** Session token of an admin login, browsers send the session cookie instead */
+  session?: string;
+  fetch?: typeof fetch;
+}
+
+/**
+ * A client of the OpenShield gateway and admin API
+ */
+export class Client {
+  constructor(private options: ClientOptions) {}
+
+  private async request<T>(method: string, path: string, query?: Record<string, string>, body?: unknown): Promise<T> {
+    let url = this.options.baseURL.replace(/\/$/, "") + path;
+    if (query && Object.keys(query).length > 0) {
+      url += "?" + new URLSearchParams(query).toString();
+    }
+    const headers: Record<string, string> = {};
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (this.options.apiKey) {
+      headers["Authorization"] = "Bearer " + this.options.apiKey;
+    }
+    if (this.options.session) {
+      headers["Cookie"] = "openshield_session=" + this.options.session;
+    }
+    const response = await (this.options.fetch ?? fetch)(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      credentials: "include",
+    });
+    const text = await response.text();
+    if (!response.ok) {
+      throw new APIError(response.status, text);
+    }
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+`
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpecRouter() chi.Router {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	router := chi.NewRouter()
+	router.Route("/openai/v1", func(r chi.Router) {
+		r.Get("/models", handler)
+		r.Get("/models/{model}", handler)
+		r.Post("/chat/completions", handler)
+		r.Post("/embeddings", handler)
+	})
+	router.Route("/v1", func(r chi.Router) {
+		r.Post("/embeddings", handler)
+	})
+	router.Route("/admin", func(r chi.Router) {
+		r.Put("/models/{id}/deprecation", handler)
+		r.Delete("/models/{id:[0-9a-f-]+}/approvals/{workspace}", handler)
+	})
+	router.Get("/swagger/*", handler)
+	return router
+}
+
+func TestGatewaySpec(t *testing.T) {
+	spec, err := GatewaySpec(testSpecRouter())
+	require.NoError(t, err)
+
+	// Annotated routes keep their operations, the others are added untyped
+	assert.Equal(t, "Create chat completion", spec.Paths["/openai/v1/chat/completions"]["post"].Summary)
+	assert.Equal(t, []APIParameter{{Name: "body", In: "body"}}, spec.Paths["/openai/v1/embeddings"]["post"].Parameters)
+	assert.Equal(t, []string{"admin"}, spec.Paths["/admin/models/{id}/deprecation"]["put"].Tags)
+	assert.Equal(t, []APIParameter{
+		{Name: "id", In: "path", Required: true, Type: "string"},
+		{Name: "workspace", In: "path", Required: true, Type: "string"},
+	}, spec.Paths["/admin/models/{id}/approvals/{workspace}"]["delete"].Parameters)
+	assert.NotContains(t, spec.Paths, "/v1/embeddings")
+	assert.NotContains(t, spec.Paths, "/swagger/*")
+	assert.Contains(t, spec.Definitions, "openai.ChatCompletionRequest")
+}
+
+func TestGenerateGoSDK(t *testing.T) {
+	spec, err := GatewaySpec(testSpecRouter())
+	require.NoError(t, err)
+	files, err := GenerateSDK(spec, "go", "gateway")
+	require.NoError(t, err)
+	source := string(files["client.go"])
+
+	assert.Contains(t, source, "package gateway")
+	assert.Contains(t, source, "func (c *Client) PostOpenaiV1ChatCompletions(ctx context.Context, body *OpenaiChatCompletionRequest) (*OpenaiChatCompletionResponse, error)")
+	assert.Contains(t, source, "func (c *Client) PutAdminModelsByIdDeprecation(ctx context.Context, id string, body interface{}) (json.RawMessage, error)")
+	assert.Contains(t, source, `"/admin/models/"+url.PathEscape(id)+"/approvals/"+url.PathEscape(workspace)`)
+	assert.Regexp(t, `OpenaiFinishReasonStop +OpenaiFinishReason = "stop"`, source)
+
+	// The client type checks against the standard library
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", source, parser.ParseComments)
+	require.NoError(t, err)
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	_, err = config.Check("gateway", fset, []*ast.File{file}, nil)
+	assert.NoError(t, err)
+}
+
+func TestGenerateTypeScriptSDK(t *testing.T) {
+	spec, err := GatewaySpec(testSpecRouter())
+	require.NoError(t, err)
+	files, err := GenerateSDK(spec, "typescript", "")
+	require.NoError(t, err)
+	source := string(files["client.ts"])
+
+	assert.Contains(t, source, "export interface OpenaiChatCompletionRequest {")
+	assert.Contains(t, source, `export type OpenaiToolType = "function";`)
+	assert.Contains(t, source, "async postOpenaiV1ChatCompletions(body: OpenaiChatCompletionRequest): Promise<OpenaiChatCompletionResponse>")
+	assert.Contains(t, source, "async deleteAdminModelsByIdApprovalsByWorkspace(id: string, workspace: string, query?: Record<string, string>): Promise<unknown>")
+	assert.Contains(t, source, "`/admin/models/${encodeURIComponent(id)}/approvals/${encodeURIComponent(workspace)}`")
+	assert.Equal(t, strings.Count(source, "{"), strings.Count(source, "}"))
+
+	_, err = GenerateSDK(spec, "rust", "")
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -14,10 +15,15 @@ import (
 	httprateredis "github.com/go-chi/httprate-redis"
 	_ "github.com/openshieldai/openshield/docs"
 	"github.com/openshieldai/openshield/lib"
+	"github.com/openshieldai/openshield/lib/admin"
+	"github.com/openshieldai/openshield/lib/anthropic"
 	"github.com/openshieldai/openshield/lib/openai"
+	"github.com/openshieldai/openshield/lib/scim"
+	"github.com/openshieldai/openshield/lib/vectordb"
 	"github.com/redis/go-redis/v9"
 	"github.com/swaggo/http-swagger"
 	"golang.org/x/sync/errgroup"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -84,34 +90,15 @@ func ChatCompletionHandler(w http.ResponseWriter, r *http.Request) {
 func StartServer() error {
 	config = lib.GetConfig()
 
-	router = chi.NewRouter()
-	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(60 * time.Second))
-
-	// CORS configuration
-	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
-
-	router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			next.ServeHTTP(w, r)
-		})
-	})
+	if err := lib.ValidateCryptoProfile(); err != nil {
+		return err
+	}
+	if err := lib.SetupLogFile(); err != nil {
+		return err
+	}
 
-	setupOpenAIRoutes(router)
-	router.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("/swagger/doc.json"),
-	))
+	router = NewRouter()
+	lib.RegisterProbeJob(router)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -122,34 +109,110 @@ func StartServer() error {
 		Addr:    addr,
 		Handler: router,
 	}
+	tlsConfig, err := lib.ServerTLSConfig(config.Settings.Network)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+	listener, err := listen(addr, config.Settings.Network)
+	if err != nil {
+		return err
+	}
 	// Start the server
 	g.Go(func() error {
-		addr := fmt.Sprintf(":%d", config.Settings.Network.Port)
 		fmt.Printf("Server is starting on %s...\n", addr)
-		return http.ListenAndServe(addr, router)
+		serve := srv.Serve
+		if tlsConfig != nil {
+			serve = func(listener net.Listener) error { return srv.ServeTLS(listener, "", "") }
+		}
+		if err := serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
 	})
 
-	// Handle graceful shutdown
+	if lib.RedisConfigured() {
+		g.Go(func() error {
+			lib.WatchKillSwitches(ctx)
+			return nil
+		})
+		g.Go(func() error {
+			lib.WatchConfigBroadcast(ctx)
+			return nil
+		})
+		g.Go(func() error {
+			lib.WatchConfigRollout(ctx)
+			return nil
+		})
+		g.Go(func() error {
+			lib.WatchDetectorReloads(ctx)
+			return nil
+		})
+		g.Go(func() error {
+			lib.WatchRateLimitIdentities(ctx)
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		lib.RunJobs(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		lib.RunWriteQueue(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		lib.RunWatchdog(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		lib.RunUploadSpoolCleaner(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		lib.RunTraceExporter(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		reopen := make(chan os.Signal, 1)
+		signal.Notify(reopen, syscall.SIGHUP)
+		defer signal.Stop(reopen)
+		for {
+			select {
+			case <-reopen:
+				lib.ReopenLogFiles()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	// Handle graceful shutdown. On the upgrade signal a new process takes over
+	// the listener first, then this one drains its connections.
 	g.Go(func() error {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		upgrade := make(chan os.Signal, 1)
+		if upgradeSignal != nil {
+			signal.Notify(upgrade, upgradeSignal)
+		}
 
-		select {
-		case <-quit:
-			fmt.Println("Starting shutdown...")
-
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-
-			err := srv.Shutdown(ctx)
-			if err != nil {
-				return err
+		for {
+			select {
+			case <-upgrade:
+				if err := startUpgrade(listener); err != nil {
+					fmt.Printf("Upgrade failed, continuing to serve: %v\n", err)
+					continue
+				}
+				fmt.Println("New process started, draining connections...")
+			case <-quit:
+				fmt.Println("Starting shutdown...")
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		case <-ctx.Done():
-			return ctx.Err()
+			return drain(srv, cancel)
 		}
-
-		return nil
 	})
 
 	if err := g.Wait(); err != nil {
@@ -160,15 +223,267 @@ func StartServer() error {
 	return nil
 }
 
+// sdkHeaders are sent by the OpenAI SDKs, and the frameworks built on them, in
+// browsers too
+var sdkHeaders = []string{
+	"X-Stainless-Arch", "X-Stainless-Lang", "X-Stainless-Os", "X-Stainless-Package-Version",
+	"X-Stainless-Retry-Count", "X-Stainless-Runtime", "X-Stainless-Runtime-Version", "X-Stainless-Timeout",
+}
+
+// NewRouter builds the router with the middlewares and the routes enabled by the configuration
+func NewRouter() chi.Router {
+	config = lib.GetConfig()
+
+	router := chi.NewRouter()
+	router.Use(lib.TimeStage("request_id", middleware.RequestID))
+	router.Use(lib.TimeStage("real_ip", middleware.RealIP))
+	router.Use(lib.TimeStage("tenant", lib.TenantMiddleware))
+	router.Use(lib.TimeStage("tracing", lib.TracingMiddleware))
+	router.Use(lib.TimeStage("metrics", lib.MetricsMiddleware))
+	router.Use(lib.TimeStage("logger", middleware.Logger))
+	router.Use(lib.TimeStage("access_log", lib.AccessLogMiddleware))
+	router.Use(lib.TimeStage("recoverer", lib.Recoverer))
+	router.Use(lib.TimeStage("watchdog", lib.WatchdogMiddleware))
+	router.Use(lib.TimeStage("timeout", middleware.Timeout(60*time.Second)))
+	router.Use(lib.TimeStage("deadline", lib.RequestDeadlineMiddleware))
+
+	// CORS configuration
+	router.Use(lib.TimeStage("cors", cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   append([]string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", lib.DryRunHeader, lib.RequestTimeoutHeader, "X-Api-Key", "Anthropic-Version", "Anthropic-Beta"}, sdkHeaders...),
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	})))
+
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	setupOpenAIRoutes(router)
+	if config.Providers.Anthropic != nil && config.Providers.Anthropic.Enabled {
+		setupAnthropicRoutes(router)
+	}
+	setupAdminRoutes(router)
+	if len(config.Settings.VectorStores) > 0 {
+		setupVectorDBRoutes(router)
+	}
+	if len(config.Settings.RAGPipelines) > 0 {
+		setupRAGRoutes(router)
+	}
+	setupEvaluateRoutes(router)
+	if config.Settings.SCIM != nil && config.Settings.SCIM.Enabled {
+		setupSCIMRoutes(router)
+	}
+	if config.Settings.ExtAuthz != nil && config.Settings.ExtAuthz.Enabled {
+		// Envoy sends checks with the method of the original request
+		router.HandleFunc(lib.ExtAuthzPrefix, lib.AuthOpenShieldMiddleware(lib.ExtAuthzHandler))
+		router.HandleFunc(lib.ExtAuthzPrefix+"/*", lib.AuthOpenShieldMiddleware(lib.ExtAuthzHandler))
+	}
+	router.Get("/version", lib.VersionHandler)
+	router.Get("/swagger/*", httpSwagger.Handler(
+		httpSwagger.URL("/swagger/doc.json"),
+	))
+	router.NotFound(lib.NotFoundHandler(lib.PublicRoutes(router)))
+	return router
+}
+
+// setupOpenAIRoutes serves the OpenAI routes at /openai/v1, and at /v1 with SDK
+// compatibility enabled
 func setupOpenAIRoutes(r chi.Router) {
-	r.Route("/openai/v1", func(r chi.Router) {
-		r.Get("/models", lib.AuthOpenShieldMiddleware(openai.ListModelsHandler))
-		r.Get("/models/{model}", lib.AuthOpenShieldMiddleware(openai.GetModelHandler))
-		r.Post("/chat/completions", lib.AuthOpenShieldMiddleware(openai.ChatCompletionHandler))
+	r.Route(lib.OpenAIPrefix, openAIRoutes)
+	if lib.SDKCompatibilityEnabled() {
+		r.Route(lib.SDKPrefix, openAIRoutes)
+	}
+}
+
+func openAIRoutes(r chi.Router) {
+	r.Use(lib.TimeStage("kill_switch", lib.KillSwitchMiddleware("openai")))
+	r.Use(lib.TimeStage("config_rollout", lib.ConfigRolloutMiddleware))
+	r.Get("/models", lib.AuthOpenShieldMiddleware(openai.ListModelsHandler))
+	r.Get("/models/{model}", lib.AuthOpenShieldMiddleware(openai.GetModelHandler))
+	r.Group(func(r chi.Router) {
+		rateLimited(r)
+		r.Post("/chat/completions", lib.TimeHandler("auth", lib.AuthOpenShieldMiddleware(lib.TimeHandler("chat_completion", openai.ChatCompletionHandler))))
+		r.Post("/completions", lib.AuthOpenShieldMiddleware(openai.CompletionHandler))
+		r.Post("/responses", lib.AuthOpenShieldMiddleware(openai.ResponsesHandler))
+		r.Post("/audio/transcriptions", lib.AuthOpenShieldMiddleware(openai.AudioTranscriptionHandler))
+		r.Post("/audio/translations", lib.AuthOpenShieldMiddleware(openai.AudioTranslationHandler))
+		r.Post("/rerank", lib.AuthOpenShieldMiddleware(openai.RerankHandler))
+		r.Post("/embeddings", lib.AuthOpenShieldMiddleware(openai.EmbeddingsHandler))
 	})
+	r.Post("/fine_tuning/jobs", lib.AuthOpenShieldMiddleware(openai.CreateFineTuningJobHandler))
+	r.Get("/fine_tuning/jobs", lib.AuthOpenShieldMiddleware(openai.ListFineTuningJobsHandler))
+	r.Get("/fine_tuning/jobs/{job}", lib.AuthOpenShieldMiddleware(openai.FineTuningJobHandler))
+	r.Post("/fine_tuning/jobs/{job}/cancel", lib.AuthOpenShieldMiddleware(openai.FineTuningJobHandler))
+	r.Get("/fine_tuning/jobs/{job}/events", lib.AuthOpenShieldMiddleware(openai.FineTuningJobHandler))
+	r.Get("/fine_tuning/jobs/{job}/checkpoints", lib.AuthOpenShieldMiddleware(openai.FineTuningJobHandler))
+}
+
+// setupAnthropicRoutes serves the Anthropic Messages API at /anthropic/v1
+func setupAnthropicRoutes(r chi.Router) {
+	r.Route(lib.AnthropicPrefix, func(r chi.Router) {
+		r.Use(lib.TimeStage("kill_switch", lib.KillSwitchMiddleware("anthropic")))
+		r.Use(lib.TimeStage("config_rollout", lib.ConfigRolloutMiddleware))
+		r.Get("/models", lib.AuthOpenShieldMiddleware(anthropic.ListModelsHandler))
+		r.Get("/models/{model}", lib.AuthOpenShieldMiddleware(anthropic.GetModelHandler))
+		r.Group(func(r chi.Router) {
+			rateLimited(r)
+			r.Post("/messages", lib.TimeHandler("auth", lib.AuthOpenShieldMiddleware(lib.TimeHandler("messages", anthropic.MessagesHandler))))
+		})
+	})
+}
+
+func setupVectorDBRoutes(r chi.Router) {
+	r.Route("/vectordb", func(r chi.Router) {
+		r.Use(lib.KillSwitchMiddleware("vectordb"))
+		r.Use(lib.ConfigRolloutMiddleware)
+		r.HandleFunc("/{store}/*", lib.AuthOpenShieldMiddleware(vectordb.ProxyHandler))
+	})
+}
+
+func setupRAGRoutes(r chi.Router) {
+	r.Route("/openshield/v1/rag", func(r chi.Router) {
+		r.Use(lib.KillSwitchMiddleware("openai"))
+		r.Use(lib.ConfigRolloutMiddleware)
+		rateLimited(r)
+		r.Post("/completions", lib.AuthOpenShieldMiddleware(openai.RAGCompletionHandler))
+	})
+}
+
+func setupEvaluateRoutes(r chi.Router) {
+	r.With(lib.KillSwitchMiddleware("evaluate"), lib.ConfigRolloutMiddleware).
+		Post("/openshield/v1/evaluate", lib.AuthOpenShieldMiddleware(openai.EvaluateHandler))
+}
+
+func setupAdminRoutes(r chi.Router) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Post("/login", admin.LoginHandler)
+		r.Post("/logout", admin.LogoutHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(admin.AuthAdminSessionMiddleware)
+			r.Get("/traces", admin.ListTracesHandler)
+			r.Get("/traffic", admin.TrafficHandler)
+			r.Get("/fine-tuning-jobs", admin.ListFineTuneJobsHandler)
+			r.Get("/requests/{id}/diff", admin.RequestDiffHandler)
+			r.Get("/analytics/usage", admin.UsageAnalyticsHandler)
+
+			// The versioned admin API, where new admin routes go
+			r.Route("/v1", func(r chi.Router) {
+				r.Get("/tail", admin.TailHandler)
+				r.With(admin.RequireGatewayScope).Get("/cache", admin.CacheStatsHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(admin.RequireGatewayScope, admin.RequireAdminRole)
+					// Created API keys are returned in the results of bulk jobs
+					r.Post("/jobs", admin.CreateBulkJobHandler)
+					r.Get("/jobs/{id}", admin.GetBulkJobHandler)
+					r.Delete("/cache", admin.InvalidateCacheHandler)
+				})
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(admin.RequireGatewayScope)
+				r.Get("/kill-switches", admin.ListKillSwitchesHandler)
+				r.Get("/jobs", admin.ListJobsHandler)
+				r.Get("/write-queue", admin.WriteQueueHandler)
+				r.Get("/config-rollout", admin.ConfigRolloutStatusHandler)
+				r.Get("/latency", admin.LatencyHandler)
+				r.Get("/latency/slo", admin.LatencySLOHandler)
+				r.Get("/concurrency", admin.ConcurrencyHandler)
+				r.Get("/watchdog", admin.WatchdogHandler)
+				r.Get("/crash-reports", admin.ListCrashReportsHandler)
+				r.Get("/probes", admin.ListProbeResultsHandler)
+				r.Get("/prompt-compression", admin.PromptCompressionHandler)
+				r.Post("/rules/test", admin.TestRulesHandler)
+				r.Get("/tool-approvals", admin.ListToolApprovalsHandler)
+				r.Get("/models", admin.ListModelsHandler)
+				r.Get("/models/pending", admin.ListPendingModelsHandler)
+				r.Get("/detectors", admin.ListDetectorsHandler)
+				r.Post("/usage/{id}/verify", admin.VerifyUsageContentHandler)
+				r.Get("/usage/history", admin.UsageHistoryHandler)
+				r.Get("/products", admin.ListProductsHandler)
+				r.Get("/products/{id}", admin.GetProductHandler)
+				r.Get("/api-keys", admin.ListApiKeysHandler)
+				r.Get("/api-keys/{id}", admin.GetApiKeyHandler)
+				r.Get("/quotas", admin.ListQuotasHandler)
+				r.Get("/quotas/{workspace}", admin.GetQuotaHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(admin.RequireAdminRole)
+					r.Post("/kill-switches", admin.SetKillSwitchHandler)
+					r.Delete("/kill-switches/{scope}/*", admin.RemoveKillSwitchHandler)
+					r.Post("/jobs/{name}/trigger", admin.TriggerJobHandler)
+					r.Get("/config-bundle", admin.ExportConfigBundleHandler)
+					r.Post("/config-bundle", admin.ImportConfigBundleHandler)
+					r.Post("/config-rollout", admin.StartConfigRolloutHandler)
+					r.Post("/config-rollout/promote", admin.PromoteConfigRolloutHandler)
+					r.Post("/config-rollout/rollback", admin.RollbackConfigRolloutHandler)
+					r.Post("/tool-approvals/{id}/approve", admin.ApproveToolCallHandler)
+					r.Post("/tool-approvals/{id}/reject", admin.RejectToolCallHandler)
+					r.Post("/models/{id}/approvals", admin.ApproveModelHandler)
+					r.Delete("/models/{id}/approvals/{workspace}", admin.RevokeModelApprovalHandler)
+					r.Put("/models/{id}/deprecation", admin.DeprecateModelHandler)
+					r.Delete("/models/{id}/deprecation", admin.UndeprecateModelHandler)
+					r.Post("/detectors/{name}/reload", admin.ReloadDetectorHandler)
+					r.Post("/policy-overrides", admin.IssuePolicyOverrideHandler)
+					r.Put("/products/{id}", admin.PutProductHandler)
+					r.Delete("/products/{id}", admin.DeleteProductHandler)
+					r.Put("/api-keys/{id}", admin.PutApiKeyHandler)
+					r.Delete("/api-keys/{id}", admin.DeleteApiKeyHandler)
+					r.Put("/quotas/{workspace}", admin.PutQuotaHandler)
+					r.Delete("/quotas/{workspace}", admin.DeleteQuotaHandler)
+					// Rules can hold the keys of classifier services, like the config bundle
+					r.Get("/rules/{direction}", admin.ListRulesHandler)
+					r.Get("/rules/{direction}/{name}", admin.GetRuleHandler)
+					r.Put("/rules/{direction}/{name}", admin.PutRuleHandler)
+					r.Delete("/rules/{direction}/{name}", admin.DeleteRuleHandler)
+				})
+			})
+		})
+	})
+}
+
+func setupSCIMRoutes(r chi.Router) {
+	r.Route("/scim/v2", func(r chi.Router) {
+		r.Use(scim.AuthSCIMMiddleware)
+		r.Get("/Users", scim.ListUsersHandler)
+		r.Post("/Users", scim.CreateUserHandler)
+		r.Get("/Users/{id}", scim.GetUserHandler)
+		r.Put("/Users/{id}", scim.ReplaceUserHandler)
+		r.Patch("/Users/{id}", scim.PatchUserHandler)
+		r.Delete("/Users/{id}", scim.DeleteUserHandler)
+	})
+}
+
+// rateLimitSettings returns the settings of the per route rate limits, which
+// need rate_limiting enabled and Redis to count the requests in
+func rateLimitSettings() (lib.RouteSettings, bool) {
+	rateLimit := lib.GetConfig().Settings.RateLimit
+	if rateLimit == nil || rateLimit.FeatureToggle == nil || !rateLimit.Enabled || !lib.RedisConfigured() {
+		return lib.RouteSettings{}, false
+	}
+	routeSettings, err := lib.GetRouteSettings()
+	if err != nil {
+		fmt.Printf("Error reading the rate limiting settings: %v\n", err)
+		return lib.RouteSettings{}, false
+	}
+	return routeSettings, true
+}
+
+// rateLimited applies the route rate limits to the routes of r, if they are enabled
+func rateLimited(r chi.Router) {
+	if routeSettings, ok := rateLimitSettings(); ok {
+		setupRoute(r, routeSettings)
+	}
 }
 
-func setupRoute(r chi.Router, routeSettings lib.RouteSettings, handler http.HandlerFunc) {
+func setupRoute(r chi.Router, routeSettings lib.RouteSettings) {
 
 	redisClient := redis.NewClient(routeSettings.Redis.Options)
 
@@ -180,10 +495,11 @@ func setupRoute(r chi.Router, routeSettings lib.RouteSettings, handler http.Hand
 		panic(err)
 	}
 
+	r.Use(lib.RateLimitTierMiddleware)
 	r.Use(httprate.Limit(
 		routeSettings.RateLimit.Max,
 		time.Duration(routeSettings.RateLimit.Window)*time.Second,
-		httprate.WithKeyByIP(),
+		httprate.WithKeyFuncs(lib.RateLimitKey),
 		httprateredis.WithRedisLimitCounter(&httprateredis.Config{
 			Client: redisClient,
 		}),
@@ -6,8 +6,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -205,3 +207,119 @@ func setupTestServer() *chi.Mux {
 
 	return router
 }
+
+// fakeOpenAI is an OpenAI API stand-in for the contract tests. Deviate breaks
+// the streamed chunk objects.
+func fakeOpenAI(deviate bool) *httptest.Server {
+	mux := http.NewServeMux()
+	writeError := func(w http.ResponseWriter, status int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"message":%q,"type":"invalid_request_error"}}`, message)
+	}
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"gpt-4o-mini","object":"model","owned_by":"openai"}]}`)
+	})
+	mux.HandleFunc("/v1/models/gpt-4o-mini", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"gpt-4o-mini","object":"model","owned_by":"openai"}`)
+	})
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			writeError(w, http.StatusUnauthorized, "Incorrect API key provided")
+			return
+		}
+		var req struct {
+			Model  string            `json:"model"`
+			Stream bool              `json:"stream"`
+			Tools  []json.RawMessage `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "gpt-4o-mini" {
+			writeError(w, http.StatusNotFound, "The model does not exist")
+			return
+		}
+		if req.Stream {
+			object := "chat.completion.chunk"
+			if deviate {
+				object = "chat.completion"
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"object\":%q,\"choices\":[{\"index\":0,\"delta\":{\"content\":\"OK\"}}]}\n\n", object)
+			fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"object\":%q,\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n", object)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		message := `{"role":"assistant","content":"OK"}`
+		finishReason := "stop"
+		if len(req.Tools) > 0 {
+			message = `{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}`
+			finishReason = "tool_calls"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-mini","choices":[{"index":0,"message":%s,"finish_reason":%q}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`, message, finishReason)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRunContractTests(t *testing.T) {
+	upstream := fakeOpenAI(false)
+	defer upstream.Close()
+
+	report := RunContractTests(ContractOptions{BaseURL: upstream.URL + "/v1", APIKey: "test-key", Model: "gpt-4o-mini"})
+	for _, result := range report.Results {
+		assert.True(t, result.Passed, "%s: %s", result.Name, result.Deviation)
+	}
+	assert.Equal(t, len(contractChecks), report.Passed)
+	assert.Equal(t, 0, report.Failed)
+}
+
+func TestRunContractTestsReportsDeviations(t *testing.T) {
+	upstream := fakeOpenAI(true)
+	defer upstream.Close()
+
+	report := RunContractTests(ContractOptions{BaseURL: upstream.URL + "/v1", APIKey: "test-key", Model: "gpt-4o-mini"})
+	assert.Equal(t, 1, report.Failed)
+	for _, result := range report.Results {
+		if result.Name == "chat_completion_stream" {
+			assert.False(t, result.Passed)
+			assert.Contains(t, result.Deviation, "chat.completion.chunk")
+		}
+	}
+}
+
+func TestDrainFinishesStreams(t *testing.T) {
+	saved := config.Settings.Network
+	defer func() { config.Settings.Network = saved }()
+	config.Settings.Network = &lib.Network{DrainTimeout: 1}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go srv.Serve(listener)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+
+	stopped := false
+	assert.NoError(t, drain(srv, func() { stopped = true }))
+	assert.True(t, stopped)
+	assert.Equal(t, "data: first\n\ndata: [DONE]\n\n", <-body)
+}
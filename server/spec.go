@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openshieldai/openshield/docs"
+	"github.com/openshieldai/openshield/lib"
+)
+
+// APISpec is the part of the Swagger 2.0 spec of the gateway the SDK generator reads
+type APISpec struct {
+	Paths       map[string]map[string]*APIOperation `json:"paths"`
+	Definitions map[string]*APISchema               `json:"definitions"`
+}
+
+// APIOperation is an operation of a path, keyed by its lowercase HTTP method
+type APIOperation struct {
+	OperationID string                  `json:"operationId,omitempty"`
+	Summary     string                  `json:"summary,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Parameters  []APIParameter          `json:"parameters,omitempty"`
+	Responses   map[string]*APIResponse `json:"responses,omitempty"`
+}
+
+// APIParameter is a path, query or body parameter of an operation
+type APIParameter struct {
+	Name     string     `json:"name"`
+	In       string     `json:"in"`
+	Required bool       `json:"required,omitempty"`
+	Type     string     `json:"type,omitempty"`
+	Schema   *APISchema `json:"schema,omitempty"`
+}
+
+// APIResponse is a response of an operation, without a schema when its body isn't described
+type APIResponse struct {
+	Description string     `json:"description,omitempty"`
+	Schema      *APISchema `json:"schema,omitempty"`
+}
+
+// APISchema is a JSON schema of the spec
+type APISchema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Properties           map[string]*APISchema `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	Items                *APISchema            `json:"items,omitempty"`
+	AdditionalProperties *APISchema            `json:"additionalProperties,omitempty"`
+	AllOf                []*APISchema          `json:"allOf,omitempty"`
+	Enum                 []interface{}         `json:"enum,omitempty"`
+}
+
+// UnmarshalJSON accepts additionalProperties given as a boolean, which the
+// generator reads like a schema of any value
+func (s *APISchema) UnmarshalJSON(data []byte) error {
+	type schema APISchema
+	var raw struct {
+		schema
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = APISchema(raw.schema)
+	if len(raw.AdditionalProperties) > 0 && raw.AdditionalProperties[0] == '{' {
+		return json.Unmarshal(raw.AdditionalProperties, &s.AdditionalProperties)
+	}
+	return nil
+}
+
+// chiParam matches the path parameters of chi routes, with their optional pattern
+var chiParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// GatewaySpec returns the Swagger spec of the gateway with every route of the
+// router. Routes the annotated spec doesn't describe, such as the admin API,
+// are added with their path parameters and untyped bodies, so the SDKs cover
+// them as well. The SDK compatibility aliases of the OpenAI routes are left out.
+func GatewaySpec(router chi.Routes) (*APISpec, error) {
+	var spec APISpec
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &spec); err != nil {
+		return nil, err
+	}
+	if spec.Paths == nil {
+		spec.Paths = map[string]map[string]*APIOperation{}
+	}
+	if spec.Definitions == nil {
+		spec.Definitions = map[string]*APISchema{}
+	}
+
+	err := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		switch method {
+		case http.MethodConnect, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			return nil
+		}
+		if strings.Contains(route, "*") || route == lib.SDKPrefix || strings.HasPrefix(route, lib.SDKPrefix+"/") {
+			return nil
+		}
+		route = strings.TrimSuffix(route, "/")
+		path := chiParam.ReplaceAllString(route, "{$1}")
+		method = strings.ToLower(method)
+		if spec.Paths[path] == nil {
+			spec.Paths[path] = map[string]*APIOperation{}
+		}
+		if spec.Paths[path][method] != nil {
+			return nil
+		}
+
+		operation := &APIOperation{Tags: []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]}}
+		for _, match := range chiParam.FindAllStringSubmatch(route, -1) {
+			operation.Parameters = append(operation.Parameters, APIParameter{Name: match[1], In: "path", Required: true, Type: "string"})
+		}
+		switch method {
+		case "post", "put", "patch":
+			operation.Parameters = append(operation.Parameters, APIParameter{Name: "body", In: "body"})
+		}
+		spec.Paths[path][method] = operation
+		return nil
+	})
+	return &spec, err
+}
+
+// specOperation is an operation with the path and method it is served at
+type specOperation struct {
+	path   string
+	method string
+	*APIOperation
+}
+
+// operations returns the operations of the spec ordered by path and method
+func (s *APISpec) operations() []specOperation {
+	var operations []specOperation
+	for path, methods := range s.Paths {
+		for method, operation := range methods {
+			operations = append(operations, specOperation{path: path, method: method, APIOperation: operation})
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].path != operations[j].path {
+			return operations[i].path < operations[j].path
+		}
+		return operations[i].method < operations[j].method
+	})
+	return operations
+}
+
+// schema returns the definition a schema refers to, or the schema itself
+func (s *APISpec) schema(schema *APISchema) *APISchema {
+	for schema != nil {
+		if len(schema.AllOf) == 1 {
+			schema = schema.AllOf[0]
+			continue
+		}
+		if schema.Ref == "" {
+			return schema
+		}
+		schema = s.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+	}
+	return nil
+}
+
+// body returns the body parameter of an operation, nil without one
+func (o specOperation) body() *APIParameter {
+	for i := range o.Parameters {
+		if o.Parameters[i].In == "body" {
+			return &o.Parameters[i]
+		}
+	}
+	return nil
+}
+
+// result returns the schema of the successful response, nil when the body
+// isn't described
+func (o specOperation) result() *APISchema {
+	for _, status := range []string{"200", "201", "202"} {
+		if response, ok := o.Responses[status]; ok {
+			return response.Schema
+		}
+	}
+	return nil
+}
+
+// name returns the name of the operation, its operationId or the method and path
+func (o specOperation) name() string {
+	if o.OperationID != "" {
+		return pascalCase(o.OperationID)
+	}
+	name := pascalCase(o.method)
+	for _, segment := range strings.Split(o.path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			segment = "by " + strings.Trim(segment, "{}")
+		}
+		name += pascalCase(segment)
+	}
+	return name
+}
+
+// pascalCase joins the words of s, split at characters that can't be part of
+// an identifier, with their first letters upper case
+func pascalCase(s string) string {
+	var name strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	if name.Len() > 0 && name.String()[0] >= '0' && name.String()[0] <= '9' {
+		return "X" + name.String()
+	}
+	return name.String()
+}
+
+// camelCase is pascalCase with a lower case first letter
+func camelCase(s string) string {
+	name := pascalCase(s)
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// definitionName returns the type name of a definition of the spec
+func definitionName(ref string) string {
+	return pascalCase(strings.TrimPrefix(ref, "#/definitions/"))
+}